@@ -0,0 +1,14 @@
+const http = require('http')
+const port = process.env.PORT || 8080
+
+const server = http.createServer((request, response) => {
+  response.end(`Hello from a single file, NODE_ENV=${process.env.NODE_ENV}`)
+})
+
+server.listen(port, (err) => {
+  if (err) {
+    return console.log('something bad happened', err)
+  }
+
+  console.log(`server is listening on ${port}`)
+})
@@ -0,0 +1,405 @@
+package appconfig
+
+import (
+	"fmt"
+	"io/ioutil"
+	"nodejs/prune"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+const (
+	// File is the buildpack config committed in the root of the app.
+	File = ".cfnodejs.yml"
+	// BuildpackYML holds the same config in its nodejs section, as it does
+	// for the Paketo buildpacks.
+	BuildpackYML = "buildpack.yml"
+)
+
+// Sources of a setting besides the config file, in order of precedence.
+const (
+	SourceEnv     = "environment"
+	SourceDefault = "default"
+)
+
+// List is a list of names, written as a YAML sequence or as the comma
+// separated string the env var takes.
+type List []string
+
+func (l *List) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var items []string
+	if err := unmarshal(&items); err != nil {
+		var value string
+		if err := unmarshal(&value); err != nil {
+			return err
+		}
+		items = strings.Split(value, ",")
+	}
+	*l = nil
+	for _, item := range items {
+		if item = strings.TrimSpace(item); item != "" {
+			*l = append(*l, item)
+		}
+	}
+	return nil
+}
+
+// Config is the buildpack config of an app. Every key mirrors the env var in
+// its env tag, and unset keys leave the env var alone.
+type Config struct {
+	Version             string `yaml:"version" env:"BP_NODE_VERSION"`
+	Workspace           string `yaml:"workspace" env:"BP_NODE_WORKSPACE"`
+	ModulesLocation     string `yaml:"modules_location" env:"BP_NODE_MODULES_LOCATION"`
+	DirectStart         *bool  `yaml:"direct_start" env:"BP_NODE_DIRECT_START"`
+	Metrics             *bool  `yaml:"metrics" env:"BP_NODE_METRICS"`
+	HealthEndpoint      string `yaml:"health_endpoint" env:"BP_INJECT_HEALTH_ENDPOINT"`
+	LegacyNodePaths     *bool  `yaml:"legacy_node_paths" env:"BP_LEGACY_NODE_PATHS"`
+	LogDedup            *bool  `yaml:"log_dedup" env:"BP_LOG_DEDUP"`
+	Diagnostics         *bool  `yaml:"diagnostics" env:"BP_NODE_DIAGNOSTICS"`
+	ExportServiceURLs   *bool  `yaml:"export_service_urls" env:"BP_EXPORT_SERVICE_URLS"`
+	Verbose             *bool  `yaml:"verbose" env:"NODE_VERBOSE"`
+	FixPermissions      *bool  `yaml:"fix_permissions" env:"BP_FIX_PERMISSIONS"`
+	DownloadBrowsers    *bool  `yaml:"download_browsers" env:"BP_DOWNLOAD_BROWSERS"`
+	NodeGypPython       string `yaml:"node_gyp_python" env:"BP_NODE_GYP_PYTHON"`
+	DNSResultOrder      string `yaml:"dns_result_order" env:"BP_DNS_RESULT_ORDER"`
+	Locale              string `yaml:"locale" env:"BP_LOCALE"`
+	BuildNodeEnv        string `yaml:"build_node_env" env:"BP_BUILD_NODE_ENV"`
+	RuntimeNodeEnv      string `yaml:"runtime_node_env" env:"BP_RUNTIME_NODE_ENV"`
+	RuntimeWritableDirs List   `yaml:"runtime_writable_dirs" env:"BP_RUNTIME_WRITABLE_DIRS"`
+	MaxNodeModulesMB    string `yaml:"max_node_modules_mb" env:"BP_MAX_NODE_MODULES_MB"`
+	NetworkConcurrency  string `yaml:"network_concurrency" env:"BP_NETWORK_CONCURRENCY"`
+	NetworkBudget       string `yaml:"optional_network_budget" env:"BP_OPTIONAL_NETWORK_BUDGET"`
+	PrecompressAssets   List   `yaml:"precompress_assets" env:"BP_PRECOMPRESS_ASSETS"`
+	NestedInstallPaths  List   `yaml:"nested_install_paths" env:"BP_NESTED_INSTALL_PATHS"`
+
+	OpenSSLLegacyProvider        *bool `yaml:"openssl_legacy_provider" env:"BP_OPENSSL_LEGACY_PROVIDER"`
+	RuntimeOpenSSLLegacyProvider *bool `yaml:"runtime_openssl_legacy_provider" env:"BP_RUNTIME_OPENSSL_LEGACY_PROVIDER"`
+	InstallProductionOnly        *bool `yaml:"install_production_only" env:"BP_INSTALL_PRODUCTION_ONLY"`
+	InstallProgress              *bool `yaml:"install_progress" env:"BP_INSTALL_PROGRESS"`
+
+	Scripts struct {
+		ProcessTypes List   `yaml:"process_types" env:"BP_SCRIPT_PROCESS_TYPES"`
+		Dotenv       string `yaml:"dotenv" env:"BP_LOAD_DOTENV"`
+		Run          List   `yaml:"run" env:"BP_NODE_RUN_SCRIPTS"`
+		Release      string `yaml:"release" env:"BP_RELEASE_SCRIPT"`
+		Timeout      string `yaml:"timeout" env:"BP_SCRIPT_TIMEOUT"`
+	} `yaml:"scripts"`
+
+	BuildInfo struct {
+		Generate *bool `yaml:"generate" env:"BP_GENERATE_BUILD_INFO"`
+		Env      List  `yaml:"env" env:"BP_BUILD_INFO_ENV"`
+		Module   *bool `yaml:"module" env:"BP_BUILD_INFO_MODULE"`
+	} `yaml:"build_info"`
+
+	Prune struct {
+		Omit List `yaml:"omit" env:"BP_PRUNE_OMIT"`
+		Keep List `yaml:"keep" env:"BP_PRUNE_KEEP"`
+	} `yaml:"prune"`
+
+	Cache struct {
+		TmpDir    string `yaml:"tmpdir" env:"BP_TMPDIR"`
+		SkipCheck *bool  `yaml:"skip_disk_check" env:"BP_SKIP_DISK_CHECK"`
+		Dirs      List   `yaml:"dirs" env:"BP_BUILD_CACHE_DIRS"`
+		MaxMB     string `yaml:"max_mb" env:"BP_BUILD_CACHE_MAX_MB"`
+	} `yaml:"cache"`
+
+	NPM struct {
+		Audit          *bool `yaml:"audit" env:"BP_NPM_AUDIT"`
+		AuthScopes     List  `yaml:"auth_scopes" env:"BP_NPM_AUTH_SCOPES"`
+		LegacyPeerDeps *bool `yaml:"legacy_peer_deps" env:"BP_NPM_LEGACY_PEER_DEPS"`
+		GlobalPackages List  `yaml:"global_packages" env:"BP_NPM_GLOBAL_PACKAGES"`
+		GlobalRuntime  *bool `yaml:"global_runtime" env:"BP_NPM_GLOBAL_RUNTIME"`
+	} `yaml:"npm"`
+
+	Yarn struct {
+		Cache         string `yaml:"cache" env:"BP_YARN_CACHE"`
+		AlwaysInstall *bool  `yaml:"always_install" env:"BP_ALWAYS_INSTALL_YARN"`
+	} `yaml:"yarn"`
+
+	Dependencies struct {
+		Denylist        string `yaml:"denylist" env:"BP_PACKAGE_DENYLIST"`
+		EnforceDenylist *bool  `yaml:"enforce_denylist" env:"BP_PACKAGE_DENYLIST_ENFORCE"`
+		Singletons      List   `yaml:"singletons" env:"BP_SINGLETON_PACKAGES"`
+		FailOnOptional  List   `yaml:"fail_on_optional" env:"BP_FAIL_ON_OPTIONAL_DEPS"`
+		BenignOptional  List   `yaml:"benign_optional" env:"BP_BENIGN_OPTIONAL_DEPS"`
+		APMPreload      List   `yaml:"apm_preload" env:"BP_APM_PRELOAD"`
+		KeepForeign     *bool  `yaml:"keep_foreign_binaries" env:"BP_KEEP_FOREIGN_BINARIES"`
+		OutdatedReport  *bool  `yaml:"outdated_report" env:"BP_OUTDATED_REPORT"`
+		OutdatedTimeout string `yaml:"outdated_timeout" env:"BP_OUTDATED_TIMEOUT"`
+		RecordResolved  *bool  `yaml:"record_resolved" env:"BP_RECORD_RESOLVED"`
+		ReplayResolved  string `yaml:"replay_resolved" env:"BP_REPLAY_RESOLVED"`
+		NodeSassCheck   string `yaml:"node_sass_check" env:"BP_NODE_SASS_CHECK"`
+	} `yaml:"dependencies"`
+}
+
+// Key is a key of Config, dotted like prune.omit, and the env var it mirrors.
+type Key struct {
+	Name string
+	Env  string
+}
+
+// Keys lists the keys of Config in the order of the struct.
+func Keys() []Key {
+	var keys []Key
+	eachField(reflect.ValueOf(&Config{}).Elem(), "", func(name, env string, _ reflect.Value) {
+		keys = append(keys, Key{Name: name, Env: env})
+	})
+	return keys
+}
+
+// eachField calls visit with the dotted name, the env var and the value of
+// every key of the struct v.
+func eachField(v reflect.Value, prefix string, visit func(name, env string, value reflect.Value)) {
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		name := prefix + strings.Split(field.Tag.Get("yaml"), ",")[0]
+		if field.Type.Kind() == reflect.Struct {
+			eachField(v.Field(i), name+".", visit)
+			continue
+		}
+		visit(name, field.Tag.Get("env"), v.Field(i))
+	}
+}
+
+// AppConfig is the config read from an app.
+type AppConfig struct {
+	// Source is the file the config was read from.
+	Source   string
+	Config   Config
+	Warnings []string
+}
+
+// Load reads File, or the nodejs section of BuildpackYML, from appDir. It
+// returns nil when the app has neither, and fails on values of the wrong type
+// or out of range. Unknown keys are ignored with a warning naming the nearest
+// valid key.
+func Load(appDir string) (*AppConfig, error) {
+	config := &AppConfig{}
+
+	contents, err := ioutil.ReadFile(filepath.Join(appDir, File))
+	if err == nil {
+		config.Source = File
+		if section, err := nodejsSection(filepath.Join(appDir, BuildpackYML)); err != nil {
+			return nil, err
+		} else if section != nil {
+			config.Warnings = append(config.Warnings, fmt.Sprintf("Ignoring the nodejs section of %s, %s takes precedence", BuildpackYML, File))
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	} else {
+		contents, err = nodejsSection(filepath.Join(appDir, BuildpackYML))
+		if err != nil || contents == nil {
+			return nil, err
+		}
+		config.Source = BuildpackYML + " (nodejs)"
+	}
+
+	var raw map[interface{}]interface{}
+	if err := yaml.Unmarshal(contents, &raw); err != nil {
+		return nil, fmt.Errorf("unable to parse %s: %s", config.Source, err)
+	}
+	config.Warnings = append(config.Warnings, unknownKeys(raw, config.Source)...)
+
+	if err := yaml.Unmarshal(contents, &config.Config); err != nil {
+		return nil, fmt.Errorf("unable to parse %s: %s", config.Source, err)
+	}
+	if err := config.Config.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid %s: %s", config.Source, err)
+	}
+	return config, nil
+}
+
+// nodejsSection returns the nodejs section of the buildpack.yml at path,
+// re-encoded on its own, or nil when there is none.
+func nodejsSection(path string) ([]byte, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var buildpackYML struct {
+		Nodejs yaml.MapSlice `yaml:"nodejs"`
+	}
+	if err := yaml.Unmarshal(contents, &buildpackYML); err != nil {
+		return nil, fmt.Errorf("unable to parse %s: %s", BuildpackYML, err)
+	}
+	if buildpackYML.Nodejs == nil {
+		return nil, nil
+	}
+	return yaml.Marshal(buildpackYML.Nodejs)
+}
+
+// Validate checks the values which have a fixed set of choices.
+func (c Config) Validate() error {
+	switch c.ModulesLocation {
+	case "", "appdir", "depdir-symlink":
+	default:
+		return fmt.Errorf("modules_location is %q, expected appdir or depdir-symlink", c.ModulesLocation)
+	}
+	for _, kind := range c.Prune.Omit {
+		if kind != prune.Dev && kind != prune.Optional && kind != prune.Peer {
+			return fmt.Errorf("prune.omit lists %q, expected dev, optional and peer", kind)
+		}
+	}
+	if c.Cache.TmpDir != "" && c.Cache.TmpDir != "cache" {
+		return fmt.Errorf("cache.tmpdir is %q, expected cache", c.Cache.TmpDir)
+	}
+	return nil
+}
+
+func unknownKeys(raw map[interface{}]interface{}, source string) []string {
+	// siblings lists the keys and sections within each section, by prefix.
+	valid := map[string]bool{}
+	siblings := map[string][]string{}
+	for _, key := range Keys() {
+		for name := key.Name; name != "" && !valid[name]; {
+			valid[name] = true
+			prefix := ""
+			if idx := strings.LastIndex(name, "."); idx >= 0 {
+				prefix = name[:idx+1]
+			}
+			siblings[prefix] = append(siblings[prefix], name)
+			name = strings.TrimSuffix(prefix, ".")
+		}
+	}
+
+	var warnings []string
+	var walk func(m map[interface{}]interface{}, prefix string)
+	walk = func(m map[interface{}]interface{}, prefix string) {
+		for k, v := range m {
+			name := prefix + fmt.Sprint(k)
+			if !valid[name] {
+				warnings = append(warnings, fmt.Sprintf("Ignoring unknown key %s in %s, did you mean %s?", name, source, nearest(name, siblings[prefix])))
+				continue
+			}
+			if nested, ok := v.(map[interface{}]interface{}); ok {
+				walk(nested, name+".")
+			}
+		}
+	}
+	walk(raw, "")
+	sort.Strings(warnings)
+	return warnings
+}
+
+// nearest returns the candidate with the smallest edit distance to name,
+// the first of them on a tie.
+func nearest(name string, candidates []string) string {
+	best, bestDistance := "", -1
+	for _, candidate := range candidates {
+		if d := distance(name, candidate); bestDistance < 0 || d < bestDistance {
+			best, bestDistance = candidate, d
+		}
+	}
+	return best
+}
+
+// distance is the Levenshtein distance between a and b.
+func distance(a, b string) int {
+	previous := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current := make([]int, len(b)+1)
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous = current
+	}
+	return previous[len(b)]
+}
+
+func min(values ...int) int {
+	m := values[0]
+	for _, v := range values[1:] {
+		if v < m {
+			m = v
+		}
+	}
+	return m
+}
+
+// Setting is the value an env var mirrored by the config ends up with.
+type Setting struct {
+	Key
+	Value string
+	// Source is SourceEnv, the source of the config or SourceDefault, which
+	// leaves the value to the buildpack.
+	Source string
+	// Overrides is the value in the config which the environment overrides.
+	Overrides string
+}
+
+// Resolve merges the environment, through lookupEnv, over the config, and
+// both over the defaults of the buildpack. config may be nil.
+func Resolve(config *AppConfig, lookupEnv func(string) (string, bool)) []Setting {
+	values := map[string]string{}
+	if config != nil {
+		eachField(reflect.ValueOf(&config.Config).Elem(), "", func(name, env string, value reflect.Value) {
+			if s, set := envValue(value); set {
+				values[name] = s
+			}
+		})
+	}
+
+	var settings []Setting
+	for _, key := range Keys() {
+		setting := Setting{Key: key, Source: SourceDefault}
+		fileValue, inFile := values[key.Name]
+		if value, found := lookupEnv(key.Env); found && value != "" {
+			setting.Value, setting.Source = value, SourceEnv
+			if inFile && fileValue != value {
+				setting.Overrides = fileValue
+			}
+		} else if inFile {
+			setting.Value, setting.Source = fileValue, config.Source
+		}
+		settings = append(settings, setting)
+	}
+	return settings
+}
+
+// envValue formats value like its env var, and reports whether it is set.
+func envValue(value reflect.Value) (string, bool) {
+	switch v := value.Interface().(type) {
+	case string:
+		return v, v != ""
+	case *bool:
+		if v == nil {
+			return "", false
+		}
+		return strconv.FormatBool(*v), true
+	case List:
+		return strings.Join(v, ","), len(v) > 0
+	}
+	return "", false
+}
+
+// Setenv exports the settings which come from the config file, so that the
+// rest of the buildpack reads them like the env vars they mirror.
+func Setenv(settings []Setting) error {
+	for _, s := range settings {
+		if s.Source == SourceEnv || s.Source == SourceDefault {
+			continue
+		}
+		if err := os.Setenv(s.Env, s.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,13 @@
+package appconfig_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestAppconfig(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Appconfig Suite")
+}
@@ -0,0 +1,299 @@
+package appconfig_test
+
+import (
+	"io/ioutil"
+	"nodejs/appconfig"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+const everyKey = `
+version: 20.x
+workspace: packages/api
+modules_location: depdir-symlink
+direct_start: false
+metrics: true
+health_endpoint: /healthz
+legacy_node_paths: true
+log_dedup: false
+diagnostics: true
+export_service_urls: true
+verbose: true
+fix_permissions: true
+download_browsers: true
+node_gyp_python: "3.10"
+dns_result_order: ipv6first
+locale: de_DE.UTF-8
+build_node_env: development
+runtime_node_env: production
+runtime_writable_dirs: [node_modules/.cache, .tmp]
+max_node_modules_mb: 500
+network_concurrency: "4"
+optional_network_budget: 45s
+precompress_assets: [dist, public]
+nested_install_paths: [packages/api, packages/worker]
+openssl_legacy_provider: true
+runtime_openssl_legacy_provider: false
+install_production_only: true
+install_progress: true
+scripts:
+  process_types: [worker, scheduler]
+  dotenv: .env.build
+  run: [build, lint]
+  release: db-migrate
+  timeout: 10m,postinstall=2m
+build_info:
+  generate: true
+  env: [GIT_SHA, RELEASE]
+  module: true
+prune:
+  omit: dev,peer
+  keep:
+    - ejs
+cache:
+  tmpdir: cache
+  skip_disk_check: true
+  dirs: [.next/cache, .cache]
+  max_mb: 2048
+npm:
+  audit: true
+  auth_scopes: ["@corp"]
+  legacy_peer_deps: true
+  global_packages: ["@angular/cli@17", firebase-tools@13]
+  global_runtime: true
+yarn:
+  cache: project
+  always_install: true
+dependencies:
+  denylist: denylist.json
+  enforce_denylist: true
+  singletons: [react, vue]
+  fail_on_optional: fsevents
+  benign_optional: [dtrace-provider]
+  apm_preload: newrelic
+  keep_foreign_binaries: true
+  outdated_report: true
+  outdated_timeout: 30s
+  record_resolved: true
+  replay_resolved: tarballs
+  node_sass_check: warn
+`
+
+var _ = Describe("Appconfig", func() {
+	var appDir string
+
+	writeFile := func(name, contents string) {
+		Expect(ioutil.WriteFile(filepath.Join(appDir, name), []byte(contents), 0644)).To(Succeed())
+	}
+
+	noEnv := func(string) (string, bool) { return "", false }
+
+	valuesFrom := func(settings []appconfig.Setting, source string) map[string]string {
+		values := map[string]string{}
+		for _, s := range settings {
+			if s.Source == source {
+				values[s.Env] = s.Value
+			}
+		}
+		return values
+	}
+
+	BeforeEach(func() {
+		var err error
+		appDir, err = ioutil.TempDir("", "nodejs-buildpack.appconfig.")
+		Expect(err).To(BeNil())
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(appDir)).To(Succeed())
+	})
+
+	It("returns nil without a config file", func() {
+		writeFile("buildpack.yml", "java:\n  version: 17\n")
+		Expect(appconfig.Load(appDir)).To(BeNil())
+	})
+
+	It("reads every key", func() {
+		writeFile(".cfnodejs.yml", everyKey)
+
+		config, err := appconfig.Load(appDir)
+		Expect(err).To(BeNil())
+		Expect(config.Source).To(Equal(".cfnodejs.yml"))
+		Expect(config.Warnings).To(BeEmpty())
+
+		settings := appconfig.Resolve(config, noEnv)
+		Expect(settings).To(HaveLen(len(appconfig.Keys())))
+		Expect(valuesFrom(settings, appconfig.SourceDefault)).To(BeEmpty())
+		Expect(valuesFrom(settings, ".cfnodejs.yml")).To(Equal(map[string]string{
+			"BP_NODE_VERSION":                    "20.x",
+			"BP_NODE_WORKSPACE":                  "packages/api",
+			"BP_NODE_MODULES_LOCATION":           "depdir-symlink",
+			"BP_NODE_DIRECT_START":               "false",
+			"BP_NODE_METRICS":                    "true",
+			"BP_INJECT_HEALTH_ENDPOINT":          "/healthz",
+			"BP_NODE_DIAGNOSTICS":                "true",
+			"BP_LEGACY_NODE_PATHS":               "true",
+			"BP_LOG_DEDUP":                       "false",
+			"BP_EXPORT_SERVICE_URLS":             "true",
+			"NODE_VERBOSE":                       "true",
+			"BP_FIX_PERMISSIONS":                 "true",
+			"BP_DOWNLOAD_BROWSERS":               "true",
+			"BP_NODE_GYP_PYTHON":                 "3.10",
+			"BP_DNS_RESULT_ORDER":                "ipv6first",
+			"BP_LOCALE":                          "de_DE.UTF-8",
+			"BP_BUILD_NODE_ENV":                  "development",
+			"BP_RUNTIME_NODE_ENV":                "production",
+			"BP_RUNTIME_WRITABLE_DIRS":           "node_modules/.cache,.tmp",
+			"BP_MAX_NODE_MODULES_MB":             "500",
+			"BP_NETWORK_CONCURRENCY":             "4",
+			"BP_OPTIONAL_NETWORK_BUDGET":         "45s",
+			"BP_PRECOMPRESS_ASSETS":              "dist,public",
+			"BP_NESTED_INSTALL_PATHS":            "packages/api,packages/worker",
+			"BP_OPENSSL_LEGACY_PROVIDER":         "true",
+			"BP_RUNTIME_OPENSSL_LEGACY_PROVIDER": "false",
+			"BP_INSTALL_PRODUCTION_ONLY":         "true",
+			"BP_INSTALL_PROGRESS":                "true",
+			"BP_SCRIPT_PROCESS_TYPES":            "worker,scheduler",
+			"BP_LOAD_DOTENV":                     ".env.build",
+			"BP_NODE_RUN_SCRIPTS":                "build,lint",
+			"BP_RELEASE_SCRIPT":                  "db-migrate",
+			"BP_SCRIPT_TIMEOUT":                  "10m,postinstall=2m",
+			"BP_PRUNE_OMIT":                      "dev,peer",
+			"BP_PRUNE_KEEP":                      "ejs",
+			"BP_TMPDIR":                          "cache",
+			"BP_SKIP_DISK_CHECK":                 "true",
+			"BP_BUILD_CACHE_DIRS":                ".next/cache,.cache",
+			"BP_BUILD_CACHE_MAX_MB":              "2048",
+			"BP_GENERATE_BUILD_INFO":             "true",
+			"BP_BUILD_INFO_ENV":                  "GIT_SHA,RELEASE",
+			"BP_BUILD_INFO_MODULE":               "true",
+			"BP_NPM_AUDIT":                       "true",
+			"BP_NPM_AUTH_SCOPES":                 "@corp",
+			"BP_NPM_LEGACY_PEER_DEPS":            "true",
+			"BP_NPM_GLOBAL_PACKAGES":             "@angular/cli@17,firebase-tools@13",
+			"BP_NPM_GLOBAL_RUNTIME":              "true",
+			"BP_YARN_CACHE":                      "project",
+			"BP_ALWAYS_INSTALL_YARN":             "true",
+			"BP_PACKAGE_DENYLIST":                "denylist.json",
+			"BP_PACKAGE_DENYLIST_ENFORCE":        "true",
+			"BP_SINGLETON_PACKAGES":              "react,vue",
+			"BP_FAIL_ON_OPTIONAL_DEPS":           "fsevents",
+			"BP_BENIGN_OPTIONAL_DEPS":            "dtrace-provider",
+			"BP_APM_PRELOAD":                     "newrelic",
+			"BP_KEEP_FOREIGN_BINARIES":           "true",
+			"BP_OUTDATED_REPORT":                 "true",
+			"BP_OUTDATED_TIMEOUT":                "30s",
+			"BP_RECORD_RESOLVED":                 "true",
+			"BP_REPLAY_RESOLVED":                 "tarballs",
+			"BP_NODE_SASS_CHECK":                 "warn",
+		}))
+	})
+
+	It("warns about typos with the nearest valid key", func() {
+		writeFile(".cfnodejs.yml", "verison: 20.x\nprune:\n  omt: dev\nnpm:\n  audit: true\ncahce:\n  tmpdir: cache\n")
+
+		config, err := appconfig.Load(appDir)
+		Expect(err).To(BeNil())
+		Expect(config.Warnings).To(Equal([]string{
+			"Ignoring unknown key cahce in .cfnodejs.yml, did you mean cache?",
+			"Ignoring unknown key prune.omt in .cfnodejs.yml, did you mean prune.omit?",
+			"Ignoring unknown key verison in .cfnodejs.yml, did you mean version?",
+		}))
+		Expect(valuesFrom(appconfig.Resolve(config, noEnv), ".cfnodejs.yml")).To(Equal(map[string]string{"BP_NPM_AUDIT": "true"}))
+	})
+
+	Context("buildpack.yml", func() {
+		It("reads the nodejs section", func() {
+			writeFile("buildpack.yml", "nodejs:\n  version: 18.x\n  prune:\n    omit: [dev]\njava:\n  version: 17\n")
+
+			config, err := appconfig.Load(appDir)
+			Expect(err).To(BeNil())
+			Expect(config.Source).To(Equal("buildpack.yml (nodejs)"))
+			Expect(valuesFrom(appconfig.Resolve(config, noEnv), config.Source)).To(Equal(map[string]string{
+				"BP_NODE_VERSION": "18.x",
+				"BP_PRUNE_OMIT":   "dev",
+			}))
+		})
+
+		It("is ignored with a warning next to .cfnodejs.yml", func() {
+			writeFile("buildpack.yml", "nodejs:\n  version: 18.x\n")
+			writeFile(".cfnodejs.yml", "version: 20.x\n")
+
+			config, err := appconfig.Load(appDir)
+			Expect(err).To(BeNil())
+			Expect(config.Warnings).To(Equal([]string{"Ignoring the nodejs section of buildpack.yml, .cfnodejs.yml takes precedence"}))
+			Expect(valuesFrom(appconfig.Resolve(config, noEnv), ".cfnodejs.yml")).To(Equal(map[string]string{"BP_NODE_VERSION": "20.x"}))
+		})
+	})
+
+	DescribeTable("validation",
+		func(contents, message string) {
+			writeFile(".cfnodejs.yml", contents)
+			_, err := appconfig.Load(appDir)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring(message))
+		},
+		Entry("not YAML", "version: [20", "unable to parse .cfnodejs.yml"),
+		Entry("a string for a boolean", "metrics: maybe\n", "unable to parse .cfnodejs.yml"),
+		Entry("a map for a list", "prune:\n  keep: {ejs: true}\n", "unable to parse .cfnodejs.yml"),
+		Entry("an unknown modules location", "modules_location: home\n", `invalid .cfnodejs.yml: modules_location is "home", expected appdir or depdir-symlink`),
+		Entry("an unknown dependency type", "prune:\n  omit: [dev, test]\n", `invalid .cfnodejs.yml: prune.omit lists "test", expected dev, optional and peer`),
+		Entry("an unknown tmpdir", "cache:\n  tmpdir: /tmp\n", `invalid .cfnodejs.yml: cache.tmpdir is "/tmp", expected cache`),
+	)
+
+	DescribeTable("Resolve",
+		func(file string, env map[string]string, value, source, overrides string) {
+			var config *appconfig.AppConfig
+			if file != "" {
+				writeFile(".cfnodejs.yml", file)
+				var err error
+				config, err = appconfig.Load(appDir)
+				Expect(err).To(BeNil())
+			}
+			lookupEnv := func(key string) (string, bool) {
+				value, found := env[key]
+				return value, found
+			}
+
+			for _, s := range appconfig.Resolve(config, lookupEnv) {
+				if s.Env == "BP_NODE_VERSION" {
+					Expect(s.Value).To(Equal(value))
+					Expect(s.Source).To(Equal(source))
+					Expect(s.Overrides).To(Equal(overrides))
+				}
+			}
+		},
+		Entry("neither", "", map[string]string{}, "", appconfig.SourceDefault, ""),
+		Entry("the file", "version: 20.x\n", map[string]string{}, "20.x", ".cfnodejs.yml", ""),
+		Entry("the environment", "", map[string]string{"BP_NODE_VERSION": "18.x"}, "18.x", appconfig.SourceEnv, ""),
+		Entry("the environment over the file", "version: 20.x\n", map[string]string{"BP_NODE_VERSION": "18.x"}, "18.x", appconfig.SourceEnv, "20.x"),
+		Entry("the file over an empty env var", "version: 20.x\n", map[string]string{"BP_NODE_VERSION": ""}, "20.x", ".cfnodejs.yml", ""),
+	)
+
+	It("exports the settings from the file only", func() {
+		for _, key := range []string{"BP_NODE_VERSION", "BP_PRUNE_KEEP"} {
+			old, had := os.LookupEnv(key)
+			defer func(key, old string, had bool) {
+				if had {
+					os.Setenv(key, old)
+				} else {
+					os.Unsetenv(key)
+				}
+			}(key, old, had)
+		}
+		os.Setenv("BP_NODE_VERSION", "18.x")
+		os.Unsetenv("BP_PRUNE_KEEP")
+
+		writeFile(".cfnodejs.yml", "version: 20.x\nprune:\n  keep: [ejs, jake]\n")
+		config, err := appconfig.Load(appDir)
+		Expect(err).To(BeNil())
+		Expect(appconfig.Setenv(appconfig.Resolve(config, os.LookupEnv))).To(Succeed())
+
+		Expect(os.Getenv("BP_NODE_VERSION")).To(Equal("18.x"))
+		Expect(os.Getenv("BP_PRUNE_KEEP")).To(Equal("ejs,jake"))
+	})
+})
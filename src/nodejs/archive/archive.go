@@ -0,0 +1,235 @@
+// Package archive extracts the archives of dependencies by the format their
+// contents have, whatever the extension of their uri says, so that node can
+// come as a .tar.xz from upstream or as a .tar.zst from a mirror.
+package archive
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// Format is the format of an archive.
+type Format string
+
+const (
+	Gzip Format = "gzip"
+	Xz   Format = "xz"
+	Zstd Format = "zstd"
+	Tar  Format = "tar"
+	Zip  Format = "zip"
+)
+
+// tarMagicOffset is where the ustar magic of the first header of a tar is,
+// which takes the longest prefix to detect.
+const tarMagicOffset = 257
+
+var magics = []struct {
+	format Format
+	offset int
+	magic  []byte
+}{
+	{Gzip, 0, []byte{0x1f, 0x8b}},
+	{Xz, 0, []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}},
+	{Zstd, 0, []byte{0x28, 0xb5, 0x2f, 0xfd}},
+	{Zip, 0, []byte{'P', 'K', 0x03, 0x04}},
+	{Tar, tarMagicOffset, []byte("ustar")},
+}
+
+// Detect returns the format of the archive which starts with head. Unknown
+// formats fail with the first bytes of head.
+func Detect(head []byte) (Format, error) {
+	for _, m := range magics {
+		if len(head) >= m.offset+len(m.magic) && bytes.Equal(head[m.offset:m.offset+len(m.magic)], m.magic) {
+			return m.format, nil
+		}
+	}
+	if len(head) > 8 {
+		head = head[:8]
+	}
+	return "", fmt.Errorf("unknown archive format, starting with the bytes % x", head)
+}
+
+// Extract extracts the archive at path into destDir.
+func Extract(path, destDir string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReaderSize(file, 4096)
+	head, err := reader.Peek(tarMagicOffset + len("ustar"))
+	if err != nil && err != io.EOF {
+		return err
+	}
+	format, err := Detect(head)
+	if err != nil {
+		return fmt.Errorf("unable to extract %s: %s", filepath.Base(path), err)
+	}
+
+	if err := os.MkdirAll(destDir, 0755); err != nil {
+		return err
+	}
+	if format == Zip {
+		return libbuildpack.ExtractZip(path, destDir)
+	}
+
+	var stream io.Reader = reader
+	switch format {
+	case Gzip:
+		gz, err := gzip.NewReader(reader)
+		if err != nil {
+			return fmt.Errorf("unable to extract %s (%s): %s", filepath.Base(path), format, err)
+		}
+		defer gz.Close()
+		stream = gz
+	case Xz:
+		xzReader, err := xz.NewReader(reader)
+		if err != nil {
+			return fmt.Errorf("unable to extract %s (%s): %s", filepath.Base(path), format, err)
+		}
+		stream = xzReader
+	case Zstd:
+		decoder, err := zstd.NewReader(reader)
+		if err != nil {
+			return fmt.Errorf("unable to extract %s (%s): %s", filepath.Base(path), format, err)
+		}
+		defer decoder.Close()
+		stream = decoder
+	}
+
+	if err := extractTar(stream, destDir); err != nil {
+		return fmt.Errorf("unable to extract %s (%s): %s", filepath.Base(path), format, err)
+	}
+	// Reading to the end checks the rest of the stream, such as the
+	// checksum which ends a gzip.
+	if _, err := io.Copy(ioutil.Discard, stream); err != nil {
+		return fmt.Errorf("unable to extract %s (%s): %s", filepath.Base(path), format, err)
+	}
+	return nil
+}
+
+// extractTar extracts the tar in r into destDir, refusing entries which
+// would end up outside of it, symlinks which point outside of it included.
+func extractTar(r io.Reader, destDir string) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		path, err := destPath(destDir, hdr.Name)
+		if err != nil {
+			return err
+		}
+		if err := checkParents(destDir, path, hdr.Name); err != nil {
+			return err
+		}
+		mode := hdr.FileInfo().Mode()
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(path, mode.Perm()|0700)
+		case tar.TypeSymlink:
+			if err = checkSymlink(destDir, path, hdr); err == nil {
+				if err = os.MkdirAll(filepath.Dir(path), 0755); err == nil {
+					os.Remove(path)
+					err = os.Symlink(hdr.Linkname, path)
+				}
+			}
+		case tar.TypeLink:
+			var target string
+			if target, err = destPath(destDir, hdr.Linkname); err == nil {
+				if err = checkParents(destDir, target, hdr.Linkname); err != nil {
+					return err
+				}
+				if err = os.MkdirAll(filepath.Dir(path), 0755); err == nil {
+					os.Remove(path)
+					err = os.Link(target, path)
+				}
+			}
+		case tar.TypeReg, tar.TypeRegA:
+			err = writeFile(tr, path, mode.Perm())
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// destPath returns where the entry name goes below destDir.
+func destPath(destDir, name string) (string, error) {
+	clean := filepath.Clean(filepath.FromSlash(name))
+	if filepath.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("the entry %s is outside of the archive", name)
+	}
+	return filepath.Join(destDir, clean), nil
+}
+
+// checkSymlink fails a symlink entry at path which is absolute or points
+// outside of destDir, as later entries would be written through it.
+func checkSymlink(destDir, path string, hdr *tar.Header) error {
+	target := filepath.FromSlash(hdr.Linkname)
+	if !filepath.IsAbs(target) && inside(destDir, filepath.Join(filepath.Dir(path), target)) {
+		return nil
+	}
+	return fmt.Errorf("the symlink %s points to %s, outside of the archive", hdr.Name, hdr.Linkname)
+}
+
+// checkParents fails the entry name at path when its dir, as far as it
+// exists, resolves outside of destDir through the symlinks of earlier
+// entries.
+func checkParents(destDir, path, name string) error {
+	realDestDir, err := filepath.EvalSymlinks(destDir)
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(path)
+	for {
+		if _, err := os.Lstat(dir); err == nil {
+			break
+		}
+		dir = filepath.Dir(dir)
+	}
+	realDir, err := filepath.EvalSymlinks(dir)
+	if err != nil || !inside(realDestDir, realDir) {
+		return fmt.Errorf("the entry %s is outside of the archive", name)
+	}
+	return nil
+}
+
+// inside reports whether path is dir or below it.
+func inside(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+func writeFile(r io.Reader, path string, perm os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	out, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, r); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,177 @@
+package archive_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/ioutil"
+	"nodejs/archive"
+	"os"
+	"path/filepath"
+
+	"github.com/cloudfoundry/libbuildpack"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Extract", func() {
+	var (
+		err     error
+		tmpDir  string
+		destDir string
+	)
+
+	BeforeEach(func() {
+		tmpDir, err = ioutil.TempDir("", "nodejs-buildpack.archive.")
+		Expect(err).To(BeNil())
+		destDir = filepath.Join(tmpDir, "dest")
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(tmpDir)).To(Succeed())
+	})
+
+	expectNode := func() {
+		nodeDir := filepath.Join(destDir, "node-v1.2.3-linux-x64")
+		Expect(ioutil.ReadFile(filepath.Join(nodeDir, "bin", "node"))).To(Equal([]byte("#!/bin/sh\necho v1.2.3\n")))
+		info, err := os.Stat(filepath.Join(nodeDir, "bin", "node"))
+		Expect(err).To(BeNil())
+		Expect(info.Mode().Perm()).To(Equal(os.FileMode(0755)))
+		Expect(os.Readlink(filepath.Join(nodeDir, "bin", "npm"))).To(Equal("../lib/npm-cli.js"))
+		Expect(ioutil.ReadFile(filepath.Join(nodeDir, "bin", "npm"))).To(Equal([]byte("module.exports = \"npm\"\n")))
+	}
+
+	DescribeTable("round-trips each format",
+		func(fixture string) {
+			Expect(archive.Extract(filepath.Join("testdata", fixture), destDir)).To(Succeed())
+			expectNode()
+		},
+		Entry("gzip", "node.tar.gz"),
+		Entry("xz", "node.tar.xz"),
+		Entry("zstd", "node.tar.zst"),
+		Entry("plain tar", "node.tar"),
+	)
+
+	It("detects the format from the contents, not the extension", func() {
+		Expect(libbuildpack.CopyFile(filepath.Join("testdata", "node.tar.zst"), filepath.Join(tmpDir, "node.tgz"))).To(Succeed())
+
+		Expect(archive.Extract(filepath.Join(tmpDir, "node.tgz"), destDir)).To(Succeed())
+		expectNode()
+	})
+
+	It("fails for an unknown format with its magic bytes", func() {
+		path := filepath.Join(tmpDir, "node.tgz")
+		Expect(ioutil.WriteFile(path, []byte("hello world, not an archive"), 0644)).To(Succeed())
+
+		Expect(archive.Extract(path, destDir)).To(MatchError("unable to extract node.tgz: unknown archive format, starting with the bytes 68 65 6c 6c 6f 20 77 6f"))
+	})
+
+	DescribeTable("fails for a corrupted stream",
+		func(fixture string) {
+			contents, err := ioutil.ReadFile(filepath.Join("testdata", fixture))
+			Expect(err).To(BeNil())
+			path := filepath.Join(tmpDir, fixture)
+			Expect(ioutil.WriteFile(path, contents[:len(contents)*2/3], 0644)).To(Succeed())
+
+			err = archive.Extract(path, destDir)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(HavePrefix("unable to extract " + fixture))
+		},
+		Entry("gzip", "node.tar.gz"),
+		Entry("xz", "node.tar.xz"),
+		Entry("zstd", "node.tar.zst"),
+	)
+
+	It("refuses entries outside of the dest dir", func() {
+		buffer := new(bytes.Buffer)
+		tw := tar.NewWriter(buffer)
+		Expect(tw.WriteHeader(&tar.Header{Name: "../evil", Mode: 0644, Size: 4, Typeflag: tar.TypeReg})).To(Succeed())
+		_, err := tw.Write([]byte("evil"))
+		Expect(err).To(BeNil())
+		Expect(tw.Close()).To(Succeed())
+		path := filepath.Join(tmpDir, "evil.tar")
+		Expect(ioutil.WriteFile(path, buffer.Bytes(), 0644)).To(Succeed())
+
+		Expect(archive.Extract(path, destDir)).To(MatchError("unable to extract evil.tar (tar): the entry ../evil is outside of the archive"))
+		Expect(filepath.Join(tmpDir, "evil")).NotTo(BeAnExistingFile())
+	})
+
+	Context("with symlinks", func() {
+		// writeTar writes a tar of the headers, whose regular files say evil.
+		writeTar := func(headers ...*tar.Header) string {
+			buffer := new(bytes.Buffer)
+			tw := tar.NewWriter(buffer)
+			for _, hdr := range headers {
+				if hdr.Typeflag == tar.TypeReg {
+					hdr.Size = 4
+				}
+				Expect(tw.WriteHeader(hdr)).To(Succeed())
+				if hdr.Typeflag == tar.TypeReg {
+					_, err := tw.Write([]byte("evil"))
+					Expect(err).To(BeNil())
+				}
+			}
+			Expect(tw.Close()).To(Succeed())
+			path := filepath.Join(tmpDir, "evil.tar")
+			Expect(ioutil.WriteFile(path, buffer.Bytes(), 0644)).To(Succeed())
+			return path
+		}
+
+		BeforeEach(func() {
+			Expect(os.MkdirAll(filepath.Join(tmpDir, "outside"), 0755)).To(Succeed())
+		})
+
+		It("refuses an absolute symlink", func() {
+			path := writeTar(
+				&tar.Header{Name: "bin", Linkname: filepath.Join(tmpDir, "outside"), Mode: 0777, Typeflag: tar.TypeSymlink},
+				&tar.Header{Name: "bin/evil", Mode: 0644, Typeflag: tar.TypeReg},
+			)
+
+			Expect(archive.Extract(path, destDir)).To(MatchError("unable to extract evil.tar (tar): the symlink bin points to " + filepath.Join(tmpDir, "outside") + ", outside of the archive"))
+			Expect(filepath.Join(tmpDir, "outside", "evil")).NotTo(BeAnExistingFile())
+		})
+
+		It("refuses a symlink which leaves the dest dir", func() {
+			path := writeTar(
+				&tar.Header{Name: "lib/up", Linkname: "../../outside", Mode: 0777, Typeflag: tar.TypeSymlink},
+				&tar.Header{Name: "lib/up/evil", Mode: 0644, Typeflag: tar.TypeReg},
+			)
+
+			Expect(archive.Extract(path, destDir)).To(MatchError("unable to extract evil.tar (tar): the symlink lib/up points to ../../outside, outside of the archive"))
+			Expect(filepath.Join(tmpDir, "outside", "evil")).NotTo(BeAnExistingFile())
+		})
+
+		It("refuses entries written through a chain of symlinks which leaves the dest dir", func() {
+			path := writeTar(
+				&tar.Header{Name: "a/here", Linkname: ".", Mode: 0777, Typeflag: tar.TypeSymlink},
+				// Clean takes it for dest, but it resolves to the dir above.
+				&tar.Header{Name: "a/up", Linkname: "here/here/../..", Mode: 0777, Typeflag: tar.TypeSymlink},
+				&tar.Header{Name: "a/up/outside/evil", Mode: 0644, Typeflag: tar.TypeReg},
+			)
+
+			Expect(archive.Extract(path, destDir)).To(MatchError("unable to extract evil.tar (tar): the entry a/up/outside/evil is outside of the archive"))
+			Expect(filepath.Join(tmpDir, "outside", "evil")).NotTo(BeAnExistingFile())
+		})
+
+		It("refuses a hard link through a symlink which leaves the dest dir", func() {
+			Expect(ioutil.WriteFile(filepath.Join(tmpDir, "outside", "secret"), []byte("secret"), 0644)).To(Succeed())
+			Expect(os.MkdirAll(destDir, 0755)).To(Succeed())
+			Expect(os.Symlink(filepath.Join(tmpDir, "outside"), filepath.Join(destDir, "out"))).To(Succeed())
+			path := writeTar(&tar.Header{Name: "secret", Linkname: "out/secret", Mode: 0644, Typeflag: tar.TypeLink})
+
+			Expect(archive.Extract(path, destDir)).To(MatchError("unable to extract evil.tar (tar): the entry out/secret is outside of the archive"))
+			Expect(filepath.Join(destDir, "secret")).NotTo(BeAnExistingFile())
+		})
+	})
+
+	DescribeTable("Detect",
+		func(head []byte, expected archive.Format) {
+			Expect(archive.Detect(head)).To(Equal(expected))
+		},
+		Entry("gzip", []byte{0x1f, 0x8b, 0x08, 0x00}, archive.Gzip),
+		Entry("xz", []byte{0xfd, '7', 'z', 'X', 'Z', 0x00, 0x00}, archive.Xz),
+		Entry("zstd", []byte{0x28, 0xb5, 0x2f, 0xfd, 0x04}, archive.Zstd),
+		Entry("zip", []byte("PK\x03\x04\x14\x00"), archive.Zip),
+		Entry("tar", append(make([]byte, 257), []byte("ustar\x0000")...), archive.Tar),
+	)
+})
@@ -0,0 +1,200 @@
+package archive
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"nodejs/network"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Masterminds/semver"
+	"github.com/cloudfoundry/libbuildpack"
+)
+
+// Installer installs the dependencies of a manifest like
+// libbuildpack.Installer, which checks the sha256 of the compressed
+// artifact, but downloads them with Downloader, which resumes a download
+// that breaks off, and extracts them with Extract, by the format of their
+// contents rather than the extension of their uri.
+type Installer struct {
+	*libbuildpack.Installer
+	Manifest   *libbuildpack.Manifest
+	Log        *libbuildpack.Logger
+	Downloader network.Downloader
+	// Now is when the end of life of a dependency is checked against.
+	Now time.Time
+
+	appCacheDir string
+}
+
+// NewInstaller returns an Installer for the dependencies of manifest.
+func NewInstaller(manifest *libbuildpack.Manifest, logger *libbuildpack.Logger) *Installer {
+	return &Installer{
+		Installer:  libbuildpack.NewInstaller(manifest),
+		Manifest:   manifest,
+		Log:        logger,
+		Downloader: network.Downloader{Client: network.Client, RetryDelay: time.Second},
+		Now:        time.Now(),
+	}
+}
+
+// SetAppCacheDir keeps the downloaded dependencies in the dependencies dir
+// of appCacheDir, like libbuildpack.Installer.
+func (i *Installer) SetAppCacheDir(appCacheDir string) error {
+	if err := i.Installer.SetAppCacheDir(appCacheDir); err != nil {
+		return err
+	}
+	dir, err := filepath.Abs(filepath.Join(appCacheDir, "dependencies"))
+	i.appCacheDir = dir
+	return err
+}
+
+// InstallDependency installs dep into outputDir, warning like
+// libbuildpack.Installer about a newer patch in the manifest and an end of
+// life close by.
+func (i *Installer) InstallDependency(dep libbuildpack.Dependency, outputDir string) error {
+	entry, err := i.Manifest.GetEntry(dep)
+	if err != nil {
+		return err
+	}
+	if strings.HasSuffix(entry.URI, ".sh") {
+		return i.Installer.InstallDependency(dep, outputDir)
+	}
+
+	i.Log.BeginStep("Installing %s %s", dep.Name, dep.Version)
+
+	tmpDir, err := ioutil.TempDir("", "downloads")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	path := filepath.Join(tmpDir, filepath.Base(entry.URI))
+	if err := i.fetch(dep, entry, path); err != nil {
+		return err
+	}
+	if err := i.warnNewerPatch(dep); err != nil {
+		return err
+	}
+	if err := i.warnEndOfLife(dep); err != nil {
+		return err
+	}
+
+	return Extract(path, outputDir)
+}
+
+// fetch writes the artifact of entry to path. An artifact which is neither
+// in the buildpack nor in the app cache is downloaded into the app cache
+// first, at the path libbuildpack.Installer looks it up at, so that
+// FetchDependency copies it from there and CleanupAppCache keeps it.
+func (i *Installer) fetch(dep libbuildpack.Dependency, entry *libbuildpack.ManifestEntry, path string) error {
+	if entry.File != "" {
+		return i.FetchDependency(dep, path)
+	}
+	if i.appCacheDir == "" {
+		return i.download(entry, path)
+	}
+
+	uriSum := sha256.Sum256([]byte(entry.URI))
+	cacheFile := filepath.Join(i.appCacheDir, hex.EncodeToString(uriSum[:]), filepath.Base(entry.URI))
+	found, err := libbuildpack.FileExists(cacheFile)
+	if err != nil {
+		return err
+	}
+	if !found {
+		if err := os.MkdirAll(filepath.Dir(cacheFile), 0755); err != nil {
+			return err
+		}
+		if err := i.download(entry, cacheFile); err != nil {
+			return err
+		}
+	}
+	return i.FetchDependency(dep, path)
+}
+
+// download writes the artifact of entry to path with Downloader and removes
+// it again when it does not match the sha256 of the manifest.
+func (i *Installer) download(entry *libbuildpack.ManifestEntry, path string) error {
+	i.Log.Info("Download [%s]", redactURI(entry.URI))
+	actual, err := i.Downloader.Download(entry.URI, path)
+	if err == nil && actual != entry.SHA256 {
+		err = fmt.Errorf("dependency sha256 mismatch: expected sha256 %s, actual sha256 %s", entry.SHA256, actual)
+	}
+	if err != nil {
+		os.Remove(path)
+	}
+	return err
+}
+
+// redactURI hides the credentials of uri, like libbuildpack does in its log.
+func redactURI(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.User == nil {
+		return uri
+	}
+	u.User = url.UserPassword("-redacted-", "-redacted-")
+	return u.String()
+}
+
+// InstallOnlyVersion installs the one version of depName in the manifest
+// into installDir.
+func (i *Installer) InstallOnlyVersion(depName string, installDir string) error {
+	versions := i.Manifest.AllDependencyVersions(depName)
+	if len(versions) > 1 {
+		return fmt.Errorf("more than one version of %s found", depName)
+	} else if len(versions) == 0 {
+		return fmt.Errorf("no versions of %s found", depName)
+	}
+	return i.InstallDependency(libbuildpack.Dependency{Name: depName, Version: versions[0]}, installDir)
+}
+
+func (i *Installer) warnNewerPatch(dep libbuildpack.Dependency) error {
+	v, err := semver.NewVersion(dep.Version)
+	if err != nil {
+		return nil
+	}
+	latest, err := libbuildpack.FindMatchingVersion(fmt.Sprintf("%d.%d.x", v.Major(), v.Minor()), i.Manifest.AllDependencyVersions(dep.Name))
+	if err != nil {
+		return err
+	}
+	if latest != dep.Version {
+		i.Log.Warning("A newer version of %s is available in this buildpack. "+
+			"Please adjust your app to use version %s instead of version %s as soon as possible. "+
+			"Old versions of %s are only provided to assist in migrating to newer versions.", dep.Name, latest, dep.Version, dep.Name)
+	}
+	return nil
+}
+
+func (i *Installer) warnEndOfLife(dep libbuildpack.Dependency) error {
+	v, versionErr := semver.NewVersion(dep.Version)
+	for _, deprecation := range i.Manifest.Deprecations {
+		if deprecation.Name != dep.Name {
+			continue
+		}
+		if versionErr != nil {
+			if deprecation.VersionLine != dep.Version {
+				continue
+			}
+		} else if constraint, err := semver.NewConstraint(deprecation.VersionLine); err != nil || !constraint.Check(v) {
+			continue
+		}
+
+		eol, err := time.Parse("2006-01-02", deprecation.Date)
+		if err != nil {
+			return err
+		}
+		if eol.Sub(i.Now) < 30*24*time.Hour {
+			warning := fmt.Sprintf("%s %s will no longer be available in new buildpacks released after %s.", dep.Name, deprecation.VersionLine, deprecation.Date)
+			if deprecation.Link != "" {
+				warning += "\nSee: " + deprecation.Link
+			}
+			i.Log.Warning("%s", warning)
+		}
+	}
+	return nil
+}
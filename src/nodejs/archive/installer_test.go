@@ -0,0 +1,179 @@
+package archive_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"nodejs/archive"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/cloudfoundry/libbuildpack/ansicleaner"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Installer", func() {
+	var (
+		err          error
+		buildpackDir string
+		outputDir    string
+		buffer       *bytes.Buffer
+		oldStack     string
+	)
+
+	// writeBuildpack writes a cached buildpack with node 1.2.3 from
+	// testdata/node.tar.zst under a uri which says gzip.
+	writeBuildpack := func(sha string) *archive.Installer {
+		Expect(os.MkdirAll(filepath.Join(buildpackDir, "dependencies"), 0755)).To(Succeed())
+		Expect(libbuildpack.CopyFile(filepath.Join("testdata", "node.tar.zst"), filepath.Join(buildpackDir, "dependencies", "node-v1.2.3-linux-x64.tar.gz"))).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(buildpackDir, "manifest.yml"), []byte(fmt.Sprintf(`---
+language: nodejs
+dependency_deprecation_dates:
+- version_line: 1.x
+  name: node
+  date: 2030-02-01
+  link: https://github.com/nodejs/Release
+dependencies:
+- name: node
+  version: 1.2.3
+  uri: https://buildpacks.example.com/node-v1.2.3-linux-x64.tar.gz
+  file: dependencies/node-v1.2.3-linux-x64.tar.gz
+  sha256: %s
+  cf_stacks:
+  - cflinuxfs3
+`, sha)), 0644)).To(Succeed())
+
+		logger := libbuildpack.NewLogger(ansicleaner.New(buffer))
+		manifest, err := libbuildpack.NewManifest(buildpackDir, logger, time.Now())
+		Expect(err).To(BeNil())
+		installer := archive.NewInstaller(manifest, logger)
+		installer.Now = time.Date(2030, 1, 15, 0, 0, 0, 0, time.UTC)
+		return installer
+	}
+
+	checksum := func(path string) string {
+		contents, err := ioutil.ReadFile(path)
+		Expect(err).To(BeNil())
+		sum := sha256.Sum256(contents)
+		return hex.EncodeToString(sum[:])
+	}
+
+	BeforeEach(func() {
+		oldStack = os.Getenv("CF_STACK")
+		os.Setenv("CF_STACK", "cflinuxfs3")
+
+		buildpackDir, err = ioutil.TempDir("", "nodejs-buildpack.buildpack.")
+		Expect(err).To(BeNil())
+		outputDir, err = ioutil.TempDir("", "nodejs-buildpack.output.")
+		Expect(err).To(BeNil())
+		buffer = new(bytes.Buffer)
+	})
+
+	AfterEach(func() {
+		os.Setenv("CF_STACK", oldStack)
+		Expect(os.RemoveAll(buildpackDir)).To(Succeed())
+		Expect(os.RemoveAll(outputDir)).To(Succeed())
+	})
+
+	It("extracts a dependency by the format of its contents", func() {
+		installer := writeBuildpack(checksum(filepath.Join("testdata", "node.tar.zst")))
+
+		Expect(installer.InstallDependency(libbuildpack.Dependency{Name: "node", Version: "1.2.3"}, outputDir)).To(Succeed())
+		Expect(ioutil.ReadFile(filepath.Join(outputDir, "node-v1.2.3-linux-x64", "bin", "node"))).To(Equal([]byte("#!/bin/sh\necho v1.2.3\n")))
+		Expect(buffer.String()).To(ContainSubstring("-----> Installing node 1.2.3"))
+		Expect(buffer.String()).To(ContainSubstring("node 1.x will no longer be available in new buildpacks released after 2030-02-01."))
+	})
+
+	It("installs the only version", func() {
+		installer := writeBuildpack(checksum(filepath.Join("testdata", "node.tar.zst")))
+
+		Expect(installer.InstallOnlyVersion("node", outputDir)).To(Succeed())
+		Expect(filepath.Join(outputDir, "node-v1.2.3-linux-x64", "bin", "node")).To(BeARegularFile())
+	})
+
+	It("checks the sha256 of the compressed artifact", func() {
+		installer := writeBuildpack(checksum(filepath.Join("testdata", "node.tar")))
+
+		Expect(installer.InstallDependency(libbuildpack.Dependency{Name: "node", Version: "1.2.3"}, outputDir)).To(MatchError(ContainSubstring("dependency sha256 mismatch")))
+		Expect(filepath.Join(outputDir, "node-v1.2.3-linux-x64")).NotTo(BeAnExistingFile())
+	})
+
+	Context("an uncached buildpack", func() {
+		var (
+			server   *httptest.Server
+			requests int
+		)
+
+		// writeUncachedBuildpack writes a buildpack with node 1.2.3 at the
+		// test server.
+		writeUncachedBuildpack := func(sha string) *archive.Installer {
+			Expect(ioutil.WriteFile(filepath.Join(buildpackDir, "manifest.yml"), []byte(fmt.Sprintf(`---
+language: nodejs
+dependencies:
+- name: node
+  version: 1.2.3
+  uri: %s/node-v1.2.3-linux-x64.tar.gz
+  sha256: %s
+  cf_stacks:
+  - cflinuxfs3
+`, server.URL, sha)), 0644)).To(Succeed())
+
+			logger := libbuildpack.NewLogger(ansicleaner.New(buffer))
+			manifest, err := libbuildpack.NewManifest(buildpackDir, logger, time.Now())
+			Expect(err).To(BeNil())
+			installer := archive.NewInstaller(manifest, logger)
+			installer.Downloader.RetryDelay = 0
+			return installer
+		}
+
+		BeforeEach(func() {
+			requests = 0
+			server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests++
+				http.ServeFile(w, r, filepath.Join("testdata", "node.tar.zst"))
+			}))
+		})
+
+		AfterEach(func() {
+			server.Close()
+		})
+
+		It("downloads and extracts a dependency", func() {
+			installer := writeUncachedBuildpack(checksum(filepath.Join("testdata", "node.tar.zst")))
+
+			Expect(installer.InstallDependency(libbuildpack.Dependency{Name: "node", Version: "1.2.3"}, outputDir)).To(Succeed())
+			Expect(ioutil.ReadFile(filepath.Join(outputDir, "node-v1.2.3-linux-x64", "bin", "node"))).To(Equal([]byte("#!/bin/sh\necho v1.2.3\n")))
+			Expect(buffer.String()).To(ContainSubstring("Download [" + server.URL + "/node-v1.2.3-linux-x64.tar.gz]"))
+		})
+
+		It("checks the sha256 of the download", func() {
+			installer := writeUncachedBuildpack(checksum(filepath.Join("testdata", "node.tar")))
+
+			Expect(installer.InstallDependency(libbuildpack.Dependency{Name: "node", Version: "1.2.3"}, outputDir)).To(MatchError(ContainSubstring("dependency sha256 mismatch")))
+			Expect(filepath.Join(outputDir, "node-v1.2.3-linux-x64")).NotTo(BeAnExistingFile())
+		})
+
+		It("keeps the download in the app cache", func() {
+			appCacheDir, err := ioutil.TempDir("", "nodejs-buildpack.cache.")
+			Expect(err).To(BeNil())
+			defer os.RemoveAll(appCacheDir)
+
+			installer := writeUncachedBuildpack(checksum(filepath.Join("testdata", "node.tar.zst")))
+			Expect(installer.SetAppCacheDir(appCacheDir)).To(Succeed())
+			Expect(installer.InstallDependency(libbuildpack.Dependency{Name: "node", Version: "1.2.3"}, outputDir)).To(Succeed())
+			Expect(installer.CleanupAppCache()).To(Succeed())
+
+			installer = writeUncachedBuildpack(checksum(filepath.Join("testdata", "node.tar.zst")))
+			Expect(installer.SetAppCacheDir(appCacheDir)).To(Succeed())
+			Expect(installer.InstallDependency(libbuildpack.Dependency{Name: "node", Version: "1.2.3"}, outputDir)).To(Succeed())
+			Expect(requests).To(Equal(1))
+		})
+	})
+})
@@ -0,0 +1,215 @@
+package cache
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// tempPrefix starts the names of the directories an entry is written to
+	// before it is renamed into place.
+	tempPrefix = ".tmp-"
+	// markerSuffix is added to the name of an entry for its COMPLETE marker,
+	// which is written last and makes the entry valid.
+	markerSuffix = ".complete"
+
+	// OrphanAge is how old a temp directory left by an interrupted staging
+	// must be before Sweep removes it.
+	OrphanAge = 24 * time.Hour
+)
+
+// Step is a point of Write after which a staging may be killed.
+type Step string
+
+const (
+	Filled  Step = "filled"
+	Synced  Step = "synced"
+	Renamed Step = "renamed"
+	Marked  Step = "marked"
+)
+
+// Cache writes entries, directories named by a path relative to Dir, so
+// that a staging killed at any point leaves either the previous entry, the
+// new one, or none at all. Never half of one.
+type Cache struct {
+	Dir string
+	// Hook, if set, is called after each step of Write. Tests use it to stop
+	// the writer between steps.
+	Hook func(Step)
+}
+
+func New(dir string) *Cache {
+	return &Cache{Dir: dir}
+}
+
+// Write fills a temp directory in the cache using fill, syncs it to disk and
+// renames it over the entry. The COMPLETE marker of the entry is removed
+// first and written last.
+func (c *Cache) Write(name string, fill func(dir string) error) error {
+	final := filepath.Join(c.Dir, name)
+	marker := final + markerSuffix
+	if err := os.Remove(marker); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(final), 0755); err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempDir(filepath.Dir(final), tempPrefix+filepath.Base(final)+"-")
+	if err != nil {
+		return err
+	}
+	if err := fill(tmp); err != nil {
+		os.RemoveAll(tmp)
+		return err
+	}
+	c.step(Filled)
+
+	if err := syncTree(tmp); err != nil {
+		os.RemoveAll(tmp)
+		return err
+	}
+	c.step(Synced)
+
+	if err := os.RemoveAll(final); err != nil {
+		os.RemoveAll(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, final); err != nil {
+		os.RemoveAll(tmp)
+		return err
+	}
+	if err := syncDir(filepath.Dir(final)); err != nil {
+		return err
+	}
+	c.step(Renamed)
+
+	if err := writeMarker(marker); err != nil {
+		return err
+	}
+	c.step(Marked)
+	return nil
+}
+
+// Restore returns the path of an entry when it is complete. An entry without
+// its marker is a miss and is removed.
+func (c *Cache) Restore(name string) (string, bool, error) {
+	final := filepath.Join(c.Dir, name)
+	if _, err := os.Stat(final + markerSuffix); err == nil {
+		return final, true, nil
+	} else if !os.IsNotExist(err) {
+		return "", false, err
+	}
+	return "", false, os.RemoveAll(final)
+}
+
+// Remove removes an entry, its marker first, so that an interrupted removal
+// leaves a miss.
+func (c *Cache) Remove(name string) error {
+	final := filepath.Join(c.Dir, name)
+	if err := os.Remove(final + markerSuffix); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return os.RemoveAll(final)
+}
+
+// Sweep removes the temp directories of writes which were interrupted more
+// than maxAge ago, and returns their paths. Younger ones may belong to a
+// staging running concurrently.
+func (c *Cache) Sweep(maxAge time.Duration, now time.Time) ([]string, error) {
+	var removed []string
+	err := filepath.Walk(c.Dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if !info.IsDir() {
+			return nil
+		}
+		if !strings.HasPrefix(info.Name(), tempPrefix) {
+			// Entry names have at most two parts, like .cache/yarn, so only
+			// the top level and unmarked directories in it are walked, never
+			// the contents of the entries themselves.
+			rel, _ := filepath.Rel(c.Dir, path)
+			if strings.Count(rel, string(filepath.Separator)) >= 1 {
+				return filepath.SkipDir
+			}
+			if _, err := os.Stat(path + markerSuffix); err == nil {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if now.Sub(info.ModTime()) > maxAge {
+			if err := os.RemoveAll(path); err != nil {
+				return err
+			}
+			removed = append(removed, path)
+		}
+		return filepath.SkipDir
+	})
+	return removed, err
+}
+
+func (c *Cache) step(step Step) {
+	if c.Hook != nil {
+		c.Hook(step)
+	}
+}
+
+func writeMarker(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(f, "COMPLETE %s\n", time.Now().UTC().Format(time.RFC3339)); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+// syncTree flushes the regular files and directories under root to disk.
+func syncTree(root string) error {
+	return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() && !info.IsDir() {
+			return nil
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		if err := f.Sync(); err != nil {
+			f.Close()
+			return err
+		}
+		return f.Close()
+	})
+}
+
+func syncDir(dir string) error {
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
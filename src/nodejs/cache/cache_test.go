@@ -0,0 +1,228 @@
+package cache_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"nodejs/cache"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Cache", func() {
+	var (
+		err      error
+		cacheDir string
+		c        *cache.Cache
+	)
+
+	fill := func(contents string) func(string) error {
+		return func(dir string) error {
+			if err := os.MkdirAll(filepath.Join(dir, "chromium"), 0755); err != nil {
+				return err
+			}
+			return ioutil.WriteFile(filepath.Join(dir, "chromium", "chrome"), []byte(contents), 0755)
+		}
+	}
+
+	contents := func(dir string) string {
+		data, err := ioutil.ReadFile(filepath.Join(dir, "chromium", "chrome"))
+		Expect(err).To(BeNil())
+		return string(data)
+	}
+
+	tempDirs := func() []string {
+		matches, err := filepath.Glob(filepath.Join(cacheDir, ".tmp-*"))
+		Expect(err).To(BeNil())
+		return matches
+	}
+
+	// crashAt runs Write in a goroutine which exits at step, like a staging
+	// killed by the platform, without any cleanup running.
+	crashAt := func(step cache.Step, name string, fill func(string) error) {
+		c.Hook = func(s cache.Step) {
+			if s == step {
+				runtime.Goexit()
+			}
+		}
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			defer GinkgoRecover()
+			c.Write(name, fill)
+			Fail("Write returned instead of crashing at " + string(step))
+		}()
+		<-done
+		c.Hook = nil
+	}
+
+	BeforeEach(func() {
+		cacheDir, err = ioutil.TempDir("", "nodejs-buildpack.cache.")
+		Expect(err).To(BeNil())
+		c = cache.New(cacheDir)
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(cacheDir)).To(Succeed())
+	})
+
+	It("restores a complete entry", func() {
+		Expect(c.Write("browsers", fill("v1"))).To(Succeed())
+
+		dir, found, err := c.Restore("browsers")
+		Expect(err).To(BeNil())
+		Expect(found).To(BeTrue())
+		Expect(dir).To(Equal(filepath.Join(cacheDir, "browsers")))
+		Expect(contents(dir)).To(Equal("v1"))
+		Expect(tempDirs()).To(BeEmpty())
+	})
+
+	It("replaces a previous entry", func() {
+		Expect(c.Write("browsers", fill("v1"))).To(Succeed())
+		Expect(c.Write("browsers", fill("v2"))).To(Succeed())
+
+		dir, found, err := c.Restore("browsers")
+		Expect(err).To(BeNil())
+		Expect(found).To(BeTrue())
+		Expect(contents(dir)).To(Equal("v2"))
+	})
+
+	It("writes entries nested in the cache", func() {
+		Expect(c.Write(".cache/yarn", fill("v1"))).To(Succeed())
+
+		dir, found, err := c.Restore(".cache/yarn")
+		Expect(err).To(BeNil())
+		Expect(found).To(BeTrue())
+		Expect(contents(dir)).To(Equal("v1"))
+	})
+
+	It("treats a missing entry as a miss", func() {
+		_, found, err := c.Restore("browsers")
+		Expect(err).To(BeNil())
+		Expect(found).To(BeFalse())
+	})
+
+	It("treats an entry without its marker as a miss and removes it", func() {
+		Expect(fill("half")(filepath.Join(cacheDir, "browsers"))).To(Succeed())
+
+		_, found, err := c.Restore("browsers")
+		Expect(err).To(BeNil())
+		Expect(found).To(BeFalse())
+		Expect(filepath.Join(cacheDir, "browsers")).NotTo(BeADirectory())
+	})
+
+	It("removes an entry with its marker", func() {
+		Expect(c.Write("browsers", fill("v1"))).To(Succeed())
+
+		Expect(c.Remove("browsers")).To(Succeed())
+		Expect(filepath.Join(cacheDir, "browsers")).NotTo(BeADirectory())
+		Expect(filepath.Join(cacheDir, "browsers.complete")).NotTo(BeAnExistingFile())
+		Expect(c.Remove("browsers")).To(Succeed())
+	})
+
+	It("removes the temp directory and keeps no marker when fill fails", func() {
+		Expect(c.Write("browsers", func(dir string) error {
+			fill("half")(dir)
+			return errors.New("disk full")
+		})).To(MatchError("disk full"))
+
+		Expect(tempDirs()).To(BeEmpty())
+		_, found, err := c.Restore("browsers")
+		Expect(err).To(BeNil())
+		Expect(found).To(BeFalse())
+	})
+
+	DescribeTable("a staging killed during Write",
+		func(step cache.Step, restored string) {
+			Expect(c.Write("browsers", fill("v1"))).To(Succeed())
+
+			crashAt(step, "browsers", fill("v2"))
+
+			dir, found, err := c.Restore("browsers")
+			Expect(err).To(BeNil())
+			if restored == "" {
+				Expect(found).To(BeFalse())
+				Expect(filepath.Join(cacheDir, "browsers")).NotTo(BeADirectory())
+			} else {
+				Expect(found).To(BeTrue())
+				Expect(contents(dir)).To(Equal(restored))
+			}
+
+			// The next staging writes the entry again.
+			Expect(c.Write("browsers", fill("v3"))).To(Succeed())
+			dir, found, err = c.Restore("browsers")
+			Expect(err).To(BeNil())
+			Expect(found).To(BeTrue())
+			Expect(contents(dir)).To(Equal("v3"))
+		},
+		Entry("after filling the temp directory", cache.Filled, ""),
+		Entry("after syncing the temp directory", cache.Synced, ""),
+		Entry("after renaming the temp directory", cache.Renamed, ""),
+		Entry("after writing the marker", cache.Marked, "v2"),
+	)
+
+	It("leaves the temp directory of a killed write for Sweep", func() {
+		crashAt(cache.Synced, "browsers", fill("v1"))
+		Expect(tempDirs()).To(HaveLen(1))
+	})
+
+	Describe("Sweep", func() {
+		It("removes temp directories older than maxAge", func() {
+			crashAt(cache.Filled, "browsers", fill("v1"))
+			crashAt(cache.Filled, ".cache/yarn", fill("v1"))
+			orphans := append(tempDirs(), mustGlob(filepath.Join(cacheDir, ".cache", ".tmp-*"))...)
+			Expect(orphans).To(HaveLen(2))
+			old := time.Now().Add(-25 * time.Hour)
+			for _, orphan := range orphans {
+				Expect(os.Chtimes(orphan, old, old)).To(Succeed())
+			}
+
+			removed, err := c.Sweep(cache.OrphanAge, time.Now())
+			Expect(err).To(BeNil())
+			Expect(removed).To(ConsistOf(orphans))
+			for _, orphan := range orphans {
+				Expect(orphan).NotTo(BeADirectory())
+			}
+		})
+
+		It("keeps young temp directories and the entries", func() {
+			Expect(c.Write("browsers", fill("v1"))).To(Succeed())
+			crashAt(cache.Filled, "browsers", fill("v2"))
+
+			removed, err := c.Sweep(cache.OrphanAge, time.Now())
+			Expect(err).To(BeNil())
+			Expect(removed).To(BeEmpty())
+			Expect(tempDirs()).To(HaveLen(1))
+			Expect(filepath.Join(cacheDir, "browsers")).To(BeADirectory())
+		})
+
+		It("does not look inside entries", func() {
+			Expect(c.Write("browsers", func(dir string) error {
+				return os.MkdirAll(filepath.Join(dir, ".tmp-chromium"), 0755)
+			})).To(Succeed())
+			old := time.Now().Add(-25 * time.Hour)
+			Expect(os.Chtimes(filepath.Join(cacheDir, "browsers", ".tmp-chromium"), old, old)).To(Succeed())
+
+			removed, err := c.Sweep(cache.OrphanAge, time.Now())
+			Expect(err).To(BeNil())
+			Expect(removed).To(BeEmpty())
+		})
+
+		It("does nothing for a missing cache", func() {
+			removed, err := cache.New(filepath.Join(cacheDir, "missing")).Sweep(cache.OrphanAge, time.Now())
+			Expect(err).To(BeNil())
+			Expect(removed).To(BeEmpty())
+		})
+	})
+})
+
+func mustGlob(pattern string) []string {
+	matches, err := filepath.Glob(pattern)
+	Expect(err).To(BeNil())
+	return matches
+}
@@ -0,0 +1,135 @@
+package cache
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// Layer is the cache a Layered lookup found an entry in.
+type Layer string
+
+const (
+	Shared  Layer = "shared"
+	Private Layer = "private"
+)
+
+// Layered looks entries up in a read-only cache shared between the stagings
+// of similar apps, like BP_SHARED_CACHE_DIR, before the private cache of the
+// app. Stagings never write to the shared cache, and treat it as a miss when
+// it is absent or unreadable. Either dir may be "".
+type Layered struct {
+	Shared  string
+	Private string
+}
+
+func NewLayered(shared, private string) *Layered {
+	return &Layered{Shared: shared, Private: private}
+}
+
+// Lookup returns the path of the file or directory name in the first layer
+// where valid accepts it, and which layer that is. valid must only read the
+// path, since other stagings may read the shared layer at the same time.
+func (l *Layered) Lookup(name string, valid func(path string) bool) (string, Layer, bool) {
+	for _, layer := range []struct {
+		dir   string
+		layer Layer
+	}{{l.Shared, Shared}, {l.Private, Private}} {
+		if layer.dir == "" {
+			continue
+		}
+		path := filepath.Join(layer.dir, name)
+		if _, err := os.Stat(path); err != nil {
+			continue
+		}
+		if valid(path) {
+			return path, layer.layer, true
+		}
+	}
+	return "", "", false
+}
+
+// Prime copies the files of the shared directory name which its private
+// counterpart lacks, for tools like npm which take a single cache directory
+// and fall back to the network on a miss. Files the private cache has are
+// kept. An absent or unreadable shared directory primes nothing. It returns
+// the number of files copied.
+func (l *Layered) Prime(name string) (int, error) {
+	if l.Shared == "" || l.Private == "" {
+		return 0, nil
+	}
+	src := filepath.Join(l.Shared, name)
+	if info, err := os.Stat(src); err != nil || !info.IsDir() {
+		return 0, nil
+	}
+
+	dest := filepath.Join(l.Private, name)
+	copied := 0
+	err := filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			// Skip what we can't read, the shared cache is only a shortcut.
+			if info != nil && info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dest, rel)
+		if _, err := os.Lstat(target); err == nil {
+			return nil
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+		ok, err := copyNew(path, target, info.Mode())
+		if ok {
+			copied++
+		}
+		return err
+	})
+	return copied, err
+}
+
+// copyNew copies src to a temp file beside dest and renames it into place,
+// so that an interrupted copy never leaves a truncated file in the private
+// cache. A src which can't be read is skipped.
+func copyNew(src, dest string, mode os.FileMode) (bool, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return false, nil
+	}
+	defer in.Close()
+
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return false, err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(dest), tempPrefix+filepath.Base(dest)+"-")
+	if err != nil {
+		return false, err
+	}
+	if _, err := io.Copy(tmp, in); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		// The shared file may have been replaced while we read it.
+		return false, nil
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return false, err
+	}
+	if err := os.Chmod(tmp.Name(), mode.Perm()|0600); err != nil {
+		os.Remove(tmp.Name())
+		return false, err
+	}
+	if err := os.Rename(tmp.Name(), dest); err != nil {
+		os.Remove(tmp.Name())
+		return false, err
+	}
+	return true, nil
+}
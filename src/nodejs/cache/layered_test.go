@@ -0,0 +1,149 @@
+package cache_test
+
+import (
+	"io/ioutil"
+	"nodejs/cache"
+	"os"
+	"path/filepath"
+	"sync"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Layered", func() {
+	var (
+		err        error
+		sharedDir  string
+		privateDir string
+		layers     *cache.Layered
+	)
+
+	write := func(dir, name, contents string) {
+		Expect(os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0755)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(dir, name), []byte(contents), 0644)).To(Succeed())
+	}
+
+	read := func(path string) string {
+		data, err := ioutil.ReadFile(path)
+		Expect(err).To(BeNil())
+		return string(data)
+	}
+
+	valid := func(path string) bool {
+		data, err := ioutil.ReadFile(path)
+		return err == nil && string(data) != "corrupt"
+	}
+
+	BeforeEach(func() {
+		sharedDir, err = ioutil.TempDir("", "nodejs-buildpack.shared.")
+		Expect(err).To(BeNil())
+		privateDir, err = ioutil.TempDir("", "nodejs-buildpack.cache.")
+		Expect(err).To(BeNil())
+		layers = cache.NewLayered(sharedDir, privateDir)
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(sharedDir)).To(Succeed())
+		Expect(os.RemoveAll(privateDir)).To(Succeed())
+	})
+
+	Describe("Lookup", func() {
+		It("finds a hit in the shared cache first", func() {
+			write(sharedDir, "downloads/agent", "shared")
+			write(privateDir, "downloads/agent", "private")
+
+			path, layer, found := layers.Lookup("downloads/agent", valid)
+			Expect(found).To(BeTrue())
+			Expect(layer).To(Equal(cache.Shared))
+			Expect(path).To(Equal(filepath.Join(sharedDir, "downloads", "agent")))
+		})
+
+		It("falls through to a hit in the private cache", func() {
+			write(privateDir, "downloads/agent", "private")
+
+			path, layer, found := layers.Lookup("downloads/agent", valid)
+			Expect(found).To(BeTrue())
+			Expect(layer).To(Equal(cache.Private))
+			Expect(read(path)).To(Equal("private"))
+		})
+
+		It("falls through invalid shared entries", func() {
+			write(sharedDir, "downloads/agent", "corrupt")
+			write(privateDir, "downloads/agent", "private")
+
+			_, layer, found := layers.Lookup("downloads/agent", valid)
+			Expect(found).To(BeTrue())
+			Expect(layer).To(Equal(cache.Private))
+		})
+
+		It("misses when neither cache has the entry", func() {
+			write(sharedDir, "downloads/other", "shared")
+
+			_, _, found := layers.Lookup("downloads/agent", valid)
+			Expect(found).To(BeFalse())
+		})
+
+		It("tolerates an absent or unusable shared cache", func() {
+			write(privateDir, "downloads/agent", "private")
+			for _, shared := range []string{"", filepath.Join(sharedDir, "missing"), filepath.Join(privateDir, "downloads", "agent")} {
+				_, layer, found := cache.NewLayered(shared, privateDir).Lookup("downloads/agent", valid)
+				Expect(found).To(BeTrue())
+				Expect(layer).To(Equal(cache.Private))
+			}
+		})
+
+		It("is safe for concurrent readers", func() {
+			write(sharedDir, "downloads/agent", "shared")
+
+			var wg sync.WaitGroup
+			layersFound := make(chan cache.Layer, 8)
+			for i := 0; i < 8; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					_, layer, _ := cache.NewLayered(sharedDir, "").Lookup("downloads/agent", valid)
+					layersFound <- layer
+				}()
+			}
+			wg.Wait()
+			close(layersFound)
+			for layer := range layersFound {
+				Expect(layer).To(Equal(cache.Shared))
+			}
+		})
+	})
+
+	Describe("Prime", func() {
+		It("copies the shared files the private cache lacks", func() {
+			write(sharedDir, ".npm/_cacache/content-v2/sha512/aa/one", "one")
+			write(sharedDir, ".npm/_cacache/content-v2/sha512/bb/two", "shared two")
+			write(privateDir, ".npm/_cacache/content-v2/sha512/bb/two", "private two")
+
+			copied, err := layers.Prime(".npm")
+			Expect(err).To(BeNil())
+			Expect(copied).To(Equal(1))
+			Expect(read(filepath.Join(privateDir, ".npm/_cacache/content-v2/sha512/aa/one"))).To(Equal("one"))
+			Expect(read(filepath.Join(privateDir, ".npm/_cacache/content-v2/sha512/bb/two"))).To(Equal("private two"))
+		})
+
+		It("never writes to the shared cache", func() {
+			write(privateDir, ".npm/_cacache/index-v5/aa/private", "private")
+			write(sharedDir, ".npm/_cacache/index-v5/aa/shared", "shared")
+
+			_, err := layers.Prime(".npm")
+			Expect(err).To(BeNil())
+			Expect(filepath.Join(sharedDir, ".npm/_cacache/index-v5/aa/private")).NotTo(BeAnExistingFile())
+			leftovers, err := filepath.Glob(filepath.Join(privateDir, ".npm/_cacache/index-v5/aa/.tmp-*"))
+			Expect(err).To(BeNil())
+			Expect(leftovers).To(BeEmpty())
+		})
+
+		It("primes nothing from an absent shared cache", func() {
+			copied, err := cache.NewLayered(filepath.Join(sharedDir, "missing"), privateDir).Prime(".npm")
+			Expect(err).To(BeNil())
+			Expect(copied).To(Equal(0))
+			Expect(filepath.Join(privateDir, ".npm")).NotTo(BeADirectory())
+		})
+	})
+})
@@ -0,0 +1,153 @@
+package denylist
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"nodejs/network"
+	"nodejs/npmrange"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Masterminds/semver"
+)
+
+// Entry blocks the versions of a package matching an npm style semver range.
+type Entry struct {
+	Versions string `json:"versions"`
+	Reason   string `json:"reason"`
+}
+
+// Denylist maps package names (including their @scope) onto blocked versions.
+type Denylist map[string][]Entry
+
+type Match struct {
+	Name    string
+	Version string
+	Path    string
+	Entry   Entry
+}
+
+func (m Match) String() string {
+	return fmt.Sprintf("%s@%s (%s): %s", m.Name, m.Version, m.Path, m.Entry.Reason)
+}
+
+func Parse(data []byte) (Denylist, error) {
+	var file struct {
+		Packages Denylist `json:"packages"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, err
+	}
+	for name, entries := range file.Packages {
+		for _, entry := range entries {
+			if _, err := npmrange.Constraint(entry.Versions); err != nil {
+				return nil, fmt.Errorf("invalid version range %q for %s: %s", entry.Versions, name, err)
+			}
+		}
+	}
+	return file.Packages, nil
+}
+
+func Load(path string) (Denylist, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(data)
+}
+
+func LoadURL(url string) (Denylist, error) {
+	resp, err := network.NewClient(30 * time.Second).Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("could not download %s: %s", url, resp.Status)
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(data)
+}
+
+// Merge adds the entries of other to d.
+func (d Denylist) Merge(other Denylist) {
+	for name, entries := range other {
+		d[name] = append(d[name], entries...)
+	}
+}
+
+// Check returns the entry blocking version of the named package.
+func (d Denylist) Check(name, version string) (Entry, bool) {
+	v, err := semver.NewVersion(version)
+	if err != nil {
+		return Entry{}, false
+	}
+	for _, entry := range d[name] {
+		if c, err := npmrange.Constraint(entry.Versions); err == nil && c.Check(v) {
+			return entry, true
+		}
+	}
+	return Entry{}, false
+}
+
+// Scan walks every package installed below nodeModules, including nested and
+// scoped packages, and returns the ones on the denylist.
+func Scan(nodeModules string, d Denylist) ([]Match, error) {
+	var matches []Match
+	if err := scanDir(nodeModules, filepath.Dir(nodeModules), d, &matches); err != nil {
+		return nil, err
+	}
+	sort.Slice(matches, func(i, j int) bool { return matches[i].Path < matches[j].Path })
+	return matches, nil
+}
+
+func scanDir(dir, root string, d Denylist, matches *[]Match) error {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		if !entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		if strings.HasPrefix(entry.Name(), "@") {
+			if err := scanDir(path, root, d, matches); err != nil {
+				return err
+			}
+			continue
+		}
+
+		var pkg struct {
+			Name    string `json:"name"`
+			Version string `json:"version"`
+		}
+		if data, err := ioutil.ReadFile(filepath.Join(path, "package.json")); err == nil {
+			if json.Unmarshal(data, &pkg) == nil {
+				if e, found := d.Check(pkg.Name, pkg.Version); found {
+					rel, _ := filepath.Rel(root, path)
+					*matches = append(*matches, Match{Name: pkg.Name, Version: pkg.Version, Path: rel, Entry: e})
+				}
+			}
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+
+		if err := scanDir(filepath.Join(path, "node_modules"), root, d, matches); err != nil {
+			return err
+		}
+	}
+	return nil
+}
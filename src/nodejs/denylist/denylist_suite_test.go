@@ -0,0 +1,13 @@
+package denylist_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestDenylist(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Denylist Suite")
+}
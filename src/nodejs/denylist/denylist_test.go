@@ -0,0 +1,114 @@
+package denylist_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"nodejs/denylist"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Denylist", func() {
+	list, err := denylist.Parse([]byte(`{"packages": {
+		"node-ipc": [{"versions": "9.2.2 || >=10.1.1 <10.1.3", "reason": "protestware"}],
+		"@evil/pkg": [{"versions": "^2.0.0", "reason": "compromised"}],
+		"colors": [{"versions": "1.4.44-liberty-2", "reason": "sabotaged"}],
+		"spaced": [{"versions": ">= 1.0.0 < 2", "reason": "spaces"}],
+		"hyphen": [{"versions": "1.0.0 - 1.2.0", "reason": "hyphen range"}]
+	}}`))
+
+	It("parses", func() {
+		Expect(err).To(BeNil())
+	})
+
+	DescribeTable("Check",
+		func(name, version string, blocked bool) {
+			_, found := list.Check(name, version)
+			Expect(found).To(Equal(blocked))
+		},
+		Entry("exact version in an || range", "node-ipc", "9.2.2", true),
+		Entry("version inside a space separated range", "node-ipc", "10.1.2", true),
+		Entry("version outside the ranges", "node-ipc", "10.1.3", false),
+		Entry("older version", "node-ipc", "9.2.1", false),
+		Entry("scoped package in range", "@evil/pkg", "2.3.1", true),
+		Entry("scoped package out of range", "@evil/pkg", "1.9.9", false),
+		Entry("same name in another scope", "@other/pkg", "2.3.1", false),
+		Entry("prerelease version", "colors", "1.4.44-liberty-2", true),
+		Entry("operators separated by spaces", "spaced", "1.5.0", true),
+		Entry("hyphen range", "hyphen", "1.1.0", true),
+		Entry("invalid installed version", "node-ipc", "not-a-version", false),
+		Entry("unlisted package", "express", "4.16.0", false),
+	)
+
+	It("rejects invalid ranges", func() {
+		_, err := denylist.Parse([]byte(`{"packages": {"a": [{"versions": "not a range", "reason": "x"}]}}`))
+		Expect(err).To(MatchError(ContainSubstring(`invalid version range "not a range" for a`)))
+	})
+
+	It("merges lists", func() {
+		other := denylist.Denylist{"node-ipc": {{Versions: "11.0.0", Reason: "new"}}}
+		merged := denylist.Denylist{}
+		merged.Merge(list)
+		merged.Merge(other)
+		_, found := merged.Check("node-ipc", "11.0.0")
+		Expect(found).To(BeTrue())
+		_, found = merged.Check("node-ipc", "9.2.2")
+		Expect(found).To(BeTrue())
+	})
+
+	It("loads lists from a URL", func() {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{"packages": {"a": [{"versions": "*", "reason": "x"}]}}`))
+		}))
+		defer server.Close()
+
+		remote, err := denylist.LoadURL(server.URL)
+		Expect(err).To(BeNil())
+		Expect(remote).To(HaveKey("a"))
+	})
+
+	Describe("Scan", func() {
+		var dir string
+
+		installPackage := func(path, name, version string) {
+			Expect(os.MkdirAll(filepath.Join(dir, path), 0755)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(dir, path, "package.json"), []byte(`{"name":"`+name+`","version":"`+version+`"}`), 0644)).To(Succeed())
+		}
+
+		BeforeEach(func() {
+			var err error
+			dir, err = ioutil.TempDir("", "nodejs-buildpack.app.")
+			Expect(err).To(BeNil())
+		})
+
+		AfterEach(func() {
+			Expect(os.RemoveAll(dir)).To(Succeed())
+		})
+
+		It("finds top level, nested and scoped packages", func() {
+			installPackage("node_modules/express", "express", "4.16.0")
+			installPackage("node_modules/node-ipc", "node-ipc", "10.1.1")
+			installPackage("node_modules/@evil/pkg", "@evil/pkg", "2.0.0")
+			installPackage("node_modules/foo/node_modules/colors", "colors", "1.4.44-liberty-2")
+			installPackage("node_modules/.bin/colors", "colors", "1.4.44-liberty-2")
+
+			matches, err := denylist.Scan(filepath.Join(dir, "node_modules"), list)
+			Expect(err).To(BeNil())
+			Expect(matches).To(HaveLen(3))
+			Expect(matches[0].String()).To(Equal("@evil/pkg@2.0.0 (node_modules/@evil/pkg): compromised"))
+			Expect(matches[1].String()).To(Equal("colors@1.4.44-liberty-2 (node_modules/foo/node_modules/colors): sabotaged"))
+			Expect(matches[2].String()).To(Equal("node-ipc@10.1.1 (node_modules/node-ipc): protestware"))
+		})
+
+		It("handles missing node_modules", func() {
+			matches, err := denylist.Scan(filepath.Join(dir, "node_modules"), list)
+			Expect(err).To(BeNil())
+			Expect(matches).To(BeEmpty())
+		})
+	})
+})
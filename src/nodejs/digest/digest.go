@@ -0,0 +1,147 @@
+package digest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+)
+
+// Tree is the digest of a directory tree.
+type Tree struct {
+	SHA256 string `json:"sha256"`
+	Size   uint64 `json:"size"`
+	Files  int    `json:"files"`
+}
+
+// Skip lists the directories, relative to the root, left out of the digest
+// because tools write build caches to them.
+var Skip = []string{".cache"}
+
+type entry struct {
+	path string
+	// line is the contribution of the entry to the digest, filled in by the
+	// workers for regular files.
+	line string
+	size int64
+}
+
+// Compute digests the tree below root from the sorted list of its files and
+// the sha256 of each, so that it only changes with the paths and contents of
+// the files, not with their mtimes. Symlinks, such as those in .bin, count
+// with their target and are not followed, except for root itself. Files are
+// hashed by workers in parallel, a workers of 0 uses one per cpu. A missing
+// root has an empty digest.
+func Compute(root string, workers int) (Tree, error) {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	if resolved, err := filepath.EvalSymlinks(root); err == nil {
+		root = resolved
+	}
+
+	var entries []*entry
+	var files []*entry
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == root {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		switch {
+		case info.IsDir():
+			for _, skip := range Skip {
+				if rel == skip {
+					return filepath.SkipDir
+				}
+			}
+		case info.Mode()&os.ModeSymlink != 0:
+			target, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			entries = append(entries, &entry{path: rel, line: fmt.Sprintf("l %s %s\n", rel, filepath.ToSlash(target))})
+		case info.Mode().IsRegular():
+			e := &entry{path: rel, size: info.Size()}
+			entries = append(entries, e)
+			files = append(files, e)
+		}
+		return nil
+	})
+	if err != nil {
+		return Tree{}, err
+	}
+
+	if err := hashFiles(root, files, workers); err != nil {
+		return Tree{}, err
+	}
+
+	// filepath.Walk visits entries in lexical order, so the digest does not
+	// depend on the order in which the workers finish.
+	hash := sha256.New()
+	var tree Tree
+	for _, e := range entries {
+		io.WriteString(hash, e.line)
+		tree.Size += uint64(e.size)
+	}
+	tree.Files = len(files)
+	tree.SHA256 = hex.EncodeToString(hash.Sum(nil))
+	return tree, nil
+}
+
+func hashFiles(root string, files []*entry, workers int) error {
+	jobs := make(chan *entry)
+	errs := make(chan error, workers)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for e := range jobs {
+				sum, err := File(filepath.Join(root, filepath.FromSlash(e.path)))
+				if err != nil {
+					errs <- err
+					// Drain the remaining jobs so that the sender finishes.
+					for range jobs {
+					}
+					return
+				}
+				e.line = fmt.Sprintf("f %s %s\n", e.path, sum)
+			}
+		}()
+	}
+
+	for _, e := range files {
+		jobs <- e
+	}
+	close(jobs)
+	wg.Wait()
+	close(errs)
+	return <-errs
+}
+
+// File returns the hex sha256 of the contents of the file at path.
+func File(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
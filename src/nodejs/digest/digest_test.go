@@ -0,0 +1,143 @@
+package digest_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"nodejs/digest"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Compute", func() {
+	var (
+		err  error
+		root string
+	)
+
+	writeFile := func(path, contents string) {
+		Expect(os.MkdirAll(filepath.Dir(path), 0755)).To(Succeed())
+		Expect(ioutil.WriteFile(path, []byte(contents), 0644)).To(Succeed())
+	}
+
+	writeTree := func(dir string) {
+		for i := 0; i < 50; i++ {
+			writeFile(filepath.Join(dir, fmt.Sprintf("pkg%02d", i), "index.js"), fmt.Sprintf("module.exports = %d\n", i))
+			writeFile(filepath.Join(dir, fmt.Sprintf("pkg%02d", i), "package.json"), fmt.Sprintf(`{"name":"pkg%02d"}`, i))
+		}
+		writeFile(filepath.Join(dir, "@scope", "tool", "cli.js"), "#!/usr/bin/env node\n")
+		Expect(os.MkdirAll(filepath.Join(dir, ".bin"), 0755)).To(Succeed())
+		Expect(os.Symlink("../@scope/tool/cli.js", filepath.Join(dir, ".bin", "tool"))).To(Succeed())
+	}
+
+	BeforeEach(func() {
+		root, err = ioutil.TempDir("", "nodejs-buildpack.digest.")
+		Expect(err).To(BeNil())
+		writeTree(filepath.Join(root, "node_modules"))
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(root)).To(Succeed())
+	})
+
+	It("is deterministic across walks and worker counts", func() {
+		first, err := digest.Compute(filepath.Join(root, "node_modules"), 1)
+		Expect(err).To(BeNil())
+		for _, workers := range []int{0, 2, 8, 64} {
+			Expect(digest.Compute(filepath.Join(root, "node_modules"), workers)).To(Equal(first))
+		}
+		Expect(first.Files).To(Equal(101))
+		Expect(first.SHA256).To(HaveLen(64))
+	})
+
+	It("does not depend on mtimes or where the tree is", func() {
+		first, err := digest.Compute(filepath.Join(root, "node_modules"), 0)
+		Expect(err).To(BeNil())
+
+		writeTree(filepath.Join(root, "copy"))
+		old := time.Now().Add(-48 * time.Hour)
+		Expect(os.Chtimes(filepath.Join(root, "copy", "pkg00", "index.js"), old, old)).To(Succeed())
+
+		Expect(digest.Compute(filepath.Join(root, "copy"), 0)).To(Equal(first))
+	})
+
+	It("counts the sizes of regular files", func() {
+		dir := filepath.Join(root, "small")
+		writeFile(filepath.Join(dir, "a", "index.js"), "12345")
+		writeFile(filepath.Join(dir, "b", "index.js"), "123")
+		Expect(os.Symlink("../a/index.js", filepath.Join(dir, "b", "link.js"))).To(Succeed())
+
+		tree, err := digest.Compute(dir, 0)
+		Expect(err).To(BeNil())
+		Expect(tree.Size).To(Equal(uint64(8)))
+		Expect(tree.Files).To(Equal(2))
+	})
+
+	It("changes with the contents of a file", func() {
+		before, err := digest.Compute(filepath.Join(root, "node_modules"), 0)
+		Expect(err).To(BeNil())
+		writeFile(filepath.Join(root, "node_modules", "pkg07", "index.js"), "module.exports = 'seven'\n")
+
+		after, err := digest.Compute(filepath.Join(root, "node_modules"), 0)
+		Expect(err).To(BeNil())
+		Expect(after.SHA256).NotTo(Equal(before.SHA256))
+	})
+
+	It("changes with the target of a .bin symlink", func() {
+		before, err := digest.Compute(filepath.Join(root, "node_modules"), 0)
+		Expect(err).To(BeNil())
+		Expect(os.Remove(filepath.Join(root, "node_modules", ".bin", "tool"))).To(Succeed())
+		Expect(os.Symlink("../pkg00/index.js", filepath.Join(root, "node_modules", ".bin", "tool"))).To(Succeed())
+
+		after, err := digest.Compute(filepath.Join(root, "node_modules"), 0)
+		Expect(err).To(BeNil())
+		Expect(after.SHA256).NotTo(Equal(before.SHA256))
+		Expect(after.Files).To(Equal(before.Files))
+	})
+
+	It("changes when a file is renamed", func() {
+		before, err := digest.Compute(filepath.Join(root, "node_modules"), 0)
+		Expect(err).To(BeNil())
+		Expect(os.Rename(filepath.Join(root, "node_modules", "pkg03", "index.js"), filepath.Join(root, "node_modules", "pkg03", "main.js"))).To(Succeed())
+
+		after, err := digest.Compute(filepath.Join(root, "node_modules"), 0)
+		Expect(err).To(BeNil())
+		Expect(after.SHA256).NotTo(Equal(before.SHA256))
+	})
+
+	It("skips build caches", func() {
+		before, err := digest.Compute(filepath.Join(root, "node_modules"), 0)
+		Expect(err).To(BeNil())
+		writeFile(filepath.Join(root, "node_modules", ".cache", "babel-loader", "0123.json"), "{}")
+
+		Expect(digest.Compute(filepath.Join(root, "node_modules"), 0)).To(Equal(before))
+	})
+
+	It("follows a symlinked root", func() {
+		Expect(os.Symlink(filepath.Join(root, "node_modules"), filepath.Join(root, "link"))).To(Succeed())
+
+		tree, err := digest.Compute(filepath.Join(root, "node_modules"), 0)
+		Expect(err).To(BeNil())
+		Expect(digest.Compute(filepath.Join(root, "link"), 0)).To(Equal(tree))
+	})
+
+	It("returns an empty digest for a missing directory", func() {
+		tree, err := digest.Compute(filepath.Join(root, "missing"), 0)
+		Expect(err).To(BeNil())
+		Expect(tree.Files).To(Equal(0))
+		Expect(tree.Size).To(Equal(uint64(0)))
+	})
+
+	It("returns an error for an unreadable file", func() {
+		if os.Getuid() == 0 {
+			Skip("root can read any file")
+		}
+		Expect(os.Chmod(filepath.Join(root, "node_modules", "pkg10", "index.js"), 0)).To(Succeed())
+
+		_, err := digest.Compute(filepath.Join(root, "node_modules"), 4)
+		Expect(err).To(HaveOccurred())
+	})
+})
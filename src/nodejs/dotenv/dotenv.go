@@ -0,0 +1,192 @@
+package dotenv
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+type Variable struct {
+	Key   string
+	Value string
+}
+
+// Load parses the dotenv file at path.
+func Load(path string) ([]Variable, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(string(contents))
+}
+
+// Parse reads dotenv syntax: KEY=value lines with optional `export`
+// prefixes, # comments, single quoted literal values and double quoted
+// values with escapes which may span several lines. Later definitions of a
+// key replace earlier ones.
+func Parse(contents string) ([]Variable, error) {
+	p := &parser{src: strings.Replace(contents, "\r\n", "\n", -1), line: 1}
+	var vars []Variable
+	index := map[string]int{}
+
+	for {
+		p.skipBlankAndComments()
+		if p.eof() {
+			return vars, nil
+		}
+
+		v, err := p.parseVariable()
+		if err != nil {
+			return nil, err
+		}
+		if idx, found := index[v.Key]; found {
+			vars[idx] = v
+		} else {
+			index[v.Key] = len(vars)
+			vars = append(vars, v)
+		}
+	}
+}
+
+type parser struct {
+	src  string
+	pos  int
+	line int
+}
+
+func (p *parser) eof() bool {
+	return p.pos >= len(p.src)
+}
+
+func (p *parser) peek() byte {
+	return p.src[p.pos]
+}
+
+func (p *parser) next() byte {
+	c := p.src[p.pos]
+	p.pos++
+	if c == '\n' {
+		p.line++
+	}
+	return c
+}
+
+func (p *parser) skipSpaces() {
+	for !p.eof() && (p.peek() == ' ' || p.peek() == '\t') {
+		p.next()
+	}
+}
+
+func (p *parser) skipToEOL() {
+	for !p.eof() && p.peek() != '\n' {
+		p.next()
+	}
+}
+
+func (p *parser) skipBlankAndComments() {
+	for !p.eof() {
+		switch p.peek() {
+		case ' ', '\t', '\n':
+			p.next()
+		case '#':
+			p.skipToEOL()
+		default:
+			return
+		}
+	}
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("line %d: %s", p.line, fmt.Sprintf(format, args...))
+}
+
+func isKeyChar(c byte) bool {
+	return c == '_' || c == '.' || c == '-' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+func (p *parser) parseKey() string {
+	start := p.pos
+	for !p.eof() && isKeyChar(p.peek()) {
+		p.next()
+	}
+	return p.src[start:p.pos]
+}
+
+func (p *parser) parseVariable() (Variable, error) {
+	key := p.parseKey()
+	if key == "export" && !p.eof() && (p.peek() == ' ' || p.peek() == '\t') {
+		p.skipSpaces()
+		key = p.parseKey()
+	}
+	if key == "" {
+		return Variable{}, p.errorf("expected a variable name")
+	}
+
+	p.skipSpaces()
+	if p.eof() || p.peek() != '=' {
+		return Variable{}, p.errorf("expected = after %s", key)
+	}
+	p.next()
+	p.skipSpaces()
+
+	var value string
+	var err error
+	if !p.eof() && (p.peek() == '"' || p.peek() == '\'') {
+		value, err = p.parseQuoted(p.next())
+		if err != nil {
+			return Variable{}, err
+		}
+		p.skipSpaces()
+		if !p.eof() && p.peek() != '\n' && p.peek() != '#' {
+			return Variable{}, p.errorf("unexpected characters after the quoted value of %s", key)
+		}
+		p.skipToEOL()
+	} else {
+		value = p.parseUnquoted()
+	}
+
+	return Variable{Key: key, Value: value}, nil
+}
+
+func (p *parser) parseQuoted(quote byte) (string, error) {
+	start := p.line
+	var value []byte
+	for !p.eof() {
+		c := p.next()
+		if c == quote {
+			return string(value), nil
+		}
+		if c == '\\' && quote == '"' && !p.eof() {
+			switch e := p.next(); e {
+			case 'n':
+				value = append(value, '\n')
+			case 'r':
+				value = append(value, '\r')
+			case 't':
+				value = append(value, '\t')
+			case '"', '\\', '$':
+				value = append(value, e)
+			default:
+				value = append(value, '\\', e)
+			}
+			continue
+		}
+		value = append(value, c)
+	}
+	return "", fmt.Errorf("line %d: unterminated quoted value", start)
+}
+
+// parseUnquoted reads to the end of the line, dropping comments which are
+// preceded by whitespace.
+func (p *parser) parseUnquoted() string {
+	start := p.pos
+	for !p.eof() && p.peek() != '\n' {
+		if p.peek() == '#' && p.pos > start && (p.src[p.pos-1] == ' ' || p.src[p.pos-1] == '\t') {
+			value := p.src[start:p.pos]
+			p.skipToEOL()
+			return strings.TrimSpace(value)
+		}
+		p.next()
+	}
+	return strings.TrimSpace(p.src[start:p.pos])
+}
@@ -0,0 +1,43 @@
+package dotenv_test
+
+import (
+	"nodejs/dotenv"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Dotenv", func() {
+	DescribeTable("Parse",
+		func(contents string, expected []dotenv.Variable) {
+			vars, err := dotenv.Parse(contents)
+			Expect(err).To(BeNil())
+			Expect(vars).To(Equal(expected))
+		},
+		Entry("plain values", "A=1\nB=two words\n", []dotenv.Variable{{"A", "1"}, {"B", "two words"}}),
+		Entry("empty values", "EMPTY=\nQUOTED=\"\"\n", []dotenv.Variable{{"EMPTY", ""}, {"QUOTED", ""}}),
+		Entry("spaces around =", "A = 1  \n", []dotenv.Variable{{"A", "1"}}),
+		Entry("export prefix", "export API_URL=https://api.example.com\n", []dotenv.Variable{{"API_URL", "https://api.example.com"}}),
+		Entry("a variable named export", "export=1\n", []dotenv.Variable{{"export", "1"}}),
+		Entry("comments and blank lines", "# comment\n\n  # indented comment\nA=1 # trailing comment\nB=no#comment\n", []dotenv.Variable{{"A", "1"}, {"B", "no#comment"}}),
+		Entry("single quotes are literal", `A='$HOME \n "x"'`, []dotenv.Variable{{"A", `$HOME \n "x"`}}),
+		Entry("double quote escapes", `A="line1\nline2\t\"q\" \\ \$x \d"`, []dotenv.Variable{{"A", "line1\nline2\t\"q\" \\ $x \\d"}}),
+		Entry("comments after quoted values", "A=\"v # not a comment\" # comment\n", []dotenv.Variable{{"A", "v # not a comment"}}),
+		Entry("multiline double quoted values", "KEY=\"-----BEGIN-----\nabc\n-----END-----\"\nNEXT=1\n", []dotenv.Variable{{"KEY", "-----BEGIN-----\nabc\n-----END-----"}, {"NEXT", "1"}}),
+		Entry("windows line endings", "A=1\r\nB=2\r\n", []dotenv.Variable{{"A", "1"}, {"B", "2"}}),
+		Entry("later definitions win", "A=1\nB=2\nA=3\n", []dotenv.Variable{{"A", "3"}, {"B", "2"}}),
+		Entry("no trailing newline", "A=1", []dotenv.Variable{{"A", "1"}}),
+	)
+
+	DescribeTable("Parse errors",
+		func(contents, message string) {
+			_, err := dotenv.Parse(contents)
+			Expect(err).To(MatchError(message))
+		},
+		Entry("missing =", "A=1\nB\n", "line 2: expected = after B"),
+		Entry("missing name", "=1\n", "line 1: expected a variable name"),
+		Entry("unterminated quote", "A=1\nB=\"open\nC=2\n", "line 2: unterminated quoted value"),
+		Entry("text after a quoted value", "A='x' y\n", "line 1: unexpected characters after the quoted value of A"),
+	)
+})
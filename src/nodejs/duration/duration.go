@@ -0,0 +1,25 @@
+// Package duration parses the durations of the BP_ settings, which take a
+// number of seconds like 30 as well as a duration like 30s or 2m.
+package duration
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Parse returns the duration of value, a number of seconds or a duration
+// like 30s. A negative duration is an error.
+func Parse(value string) (time.Duration, error) {
+	d, err := time.ParseDuration(value)
+	if seconds, atoiErr := strconv.Atoi(value); atoiErr == nil {
+		d, err = time.Duration(seconds)*time.Second, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a duration", value)
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("%q is negative", value)
+	}
+	return d, nil
+}
@@ -0,0 +1,13 @@
+package duration_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestDuration(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Duration Suite")
+}
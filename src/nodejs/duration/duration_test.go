@@ -0,0 +1,33 @@
+package duration_test
+
+import (
+	"nodejs/duration"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Parse", func() {
+	DescribeTable("valid values",
+		func(value string, expected time.Duration) {
+			Expect(duration.Parse(value)).To(Equal(expected))
+		},
+		Entry("seconds", "45", 45*time.Second),
+		Entry("zero", "0", time.Duration(0)),
+		Entry("a duration", "1m30s", 90*time.Second),
+		Entry("milliseconds", "500ms", 500*time.Millisecond),
+	)
+
+	DescribeTable("invalid values",
+		func(value, message string) {
+			_, err := duration.Parse(value)
+			Expect(err).To(MatchError(message))
+		},
+		Entry("empty", "", `"" is not a duration`),
+		Entry("a word", "soon", `"soon" is not a duration`),
+		Entry("negative seconds", "-5", `"-5" is negative`),
+		Entry("a negative duration", "-5s", `"-5s" is negative`),
+	)
+})
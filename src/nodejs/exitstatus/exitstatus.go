@@ -0,0 +1,295 @@
+// Package exitstatus runs the commands of staging, recording how they exit
+// and how much memory they take, so that an install killed for exceeding the
+// staging memory limit fails with an explanation rather than a bare exit
+// status 137.
+package exitstatus
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"nodejs/proctree"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// DefaultInterval is how often the memory of a command is sampled.
+const DefaultInterval = 250 * time.Millisecond
+
+// Status is how a command exited, with the memory it took.
+type Status struct {
+	Command string
+	// ExitCode is -1 when a signal terminated the command.
+	ExitCode int
+	// Signal is the signal which terminated the command, or 0.
+	Signal syscall.Signal
+	// PeakRSS is the most resident memory the command and its children took
+	// at once, as far as sampling saw.
+	PeakRSS uint64
+	// Limit is the memory limit of the cgroup of staging, 0 when unknown.
+	Limit uint64
+	// OOMKills is how many processes the kernel killed in the cgroup of
+	// staging for exceeding its limit while the command ran, -1 when
+	// unknown.
+	OOMKills int
+}
+
+// Killed returns whether SIGKILL terminated the command, or, for a command
+// run through a shell, its last process.
+func (s Status) Killed() bool {
+	return s.Signal == syscall.SIGKILL || s.ExitCode == 128+int(syscall.SIGKILL)
+}
+
+// OutOfMemory returns whether the command likely failed for exceeding the
+// staging memory limit: the kernel killed a process of the cgroup while it
+// ran, which need not be the command itself but a child of it, or SIGKILL
+// terminated the command while nothing says the OOM killer didn't.
+func (s Status) OutOfMemory() bool {
+	if s.OOMKills > 0 {
+		return true
+	}
+	if !s.Killed() {
+		return false
+	}
+	// Without the counts of the cgroup, a command killed well below a known
+	// limit was killed by something else, like a staging timeout.
+	return s.OOMKills < 0 && (s.Limit == 0 || s.PeakRSS >= s.Limit/10*8)
+}
+
+func (s Status) String() string {
+	var status string
+	if s.Signal != 0 {
+		status = fmt.Sprintf("%s was killed by %s (exit status %d)", s.Command, signalName(s.Signal), 128+int(s.Signal))
+	} else {
+		status = fmt.Sprintf("%s failed with exit status %d", s.Command, s.ExitCode)
+	}
+	switch {
+	case s.PeakRSS > 0 && s.Limit > 0:
+		status += fmt.Sprintf(" after using up to %d MiB of the %d MiB staging memory limit", s.PeakRSS>>20, s.Limit>>20)
+	case s.PeakRSS > 0:
+		status += fmt.Sprintf(" after using up to %d MiB of memory", s.PeakRSS>>20)
+	}
+	return status
+}
+
+// Hint explains a command which ran out of memory and how to install with
+// less, or is empty.
+func (s Status) Hint() string {
+	if !s.OutOfMemory() {
+		return ""
+	}
+	heap := "a smaller heap in NODE_OPTIONS"
+	if s.Limit > 0 {
+		// Leave a quarter of the limit to the processes besides the heap of
+		// node, as OPTIMIZE_MEMORY does at runtime.
+		heap = fmt.Sprintf("NODE_OPTIONS=--max-old-space-size=%d", s.Limit>>20*3/4)
+	}
+	return "It was likely killed for exceeding the staging memory limit; try increasing staging memory or reducing install parallelism\n" +
+		"Staging gets the memory of the app, so push with more (cf push -m), or install with fewer parallel downloads with BP_NETWORK_CONCURRENCY=4 and with " + heap
+}
+
+func signalName(signal syscall.Signal) string {
+	switch signal {
+	case syscall.SIGKILL:
+		return "SIGKILL"
+	case syscall.SIGTERM:
+		return "SIGTERM"
+	case syscall.SIGABRT:
+		return "SIGABRT"
+	case syscall.SIGSEGV:
+		return "SIGSEGV"
+	}
+	return fmt.Sprintf("signal %d (%s)", int(signal), signal)
+}
+
+// Error is a command which failed, with its Status.
+type Error struct {
+	Status Status
+	Err    error
+}
+
+func (e *Error) Error() string {
+	message := e.Status.String()
+	if hint := e.Status.Hint(); hint != "" {
+		message += "\n" + hint
+	}
+	return message
+}
+
+// Command runs commands like libbuildpack.Command, sampling their memory,
+// and fails with an *Error describing how they exited.
+type Command struct {
+	Interval time.Duration
+	// ProcDir and CgroupDir are /proc and /sys/fs/cgroup, replaced in
+	// tests.
+	ProcDir   string
+	CgroupDir string
+}
+
+// New returns a Command sampling every DefaultInterval.
+func New() *Command {
+	return &Command{Interval: DefaultInterval, ProcDir: "/proc", CgroupDir: "/sys/fs/cgroup"}
+}
+
+func (c *Command) Execute(dir string, stdout io.Writer, stderr io.Writer, program string, args ...string) error {
+	cmd := exec.Command(program, args...)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	cmd.Dir = dir
+	return c.Run(cmd)
+}
+
+func (c *Command) Run(cmd *exec.Cmd) error {
+	oomKills := c.OOMKills()
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	sampler := Sample(c.ProcDir, cmd.Process.Pid, c.Interval)
+	err := cmd.Wait()
+	peak := sampler.Stop()
+
+	exitErr, ok := err.(*exec.ExitError)
+	if !ok {
+		return err
+	}
+	status := Status{
+		Command:  commandName(filepath.Base(cmd.Path), cmd.Args[1:]),
+		ExitCode: -1,
+		PeakRSS:  peak,
+		Limit:    c.MemoryLimit(),
+		OOMKills: -1,
+	}
+	if waitStatus, ok := exitErr.Sys().(syscall.WaitStatus); ok {
+		status.ExitCode = waitStatus.ExitStatus()
+		if waitStatus.Signaled() {
+			status.Signal = waitStatus.Signal()
+		}
+	}
+	if after := c.OOMKills(); oomKills >= 0 && after >= 0 {
+		status.OOMKills = after - oomKills
+	}
+	return &Error{Status: status, Err: err}
+}
+
+// commandName is the program and its subcommand, e.g. `npm ci`.
+func commandName(program string, args []string) string {
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		return program + " " + args[0]
+	}
+	return program
+}
+
+// MemoryLimit returns the memory limit of the cgroup of staging, from
+// cgroup v2 or v1, or 0 when there is none.
+func (c *Command) MemoryLimit() uint64 {
+	for _, file := range []string{"memory.max", "memory/memory.limit_in_bytes"} {
+		contents, err := ioutil.ReadFile(filepath.Join(c.CgroupDir, file))
+		if err != nil {
+			continue
+		}
+		limit, err := strconv.ParseUint(strings.TrimSpace(string(contents)), 10, 64)
+		// v1 has no limit as the largest multiple of the page size.
+		if err != nil || limit >= 1<<62 {
+			return 0
+		}
+		return limit
+	}
+	return 0
+}
+
+// OOMKills returns how many processes the kernel killed in the cgroup of
+// staging for exceeding its memory limit, or -1 when the kernel doesn't
+// count them.
+func (c *Command) OOMKills() int {
+	for _, file := range []string{"memory.events", "memory/memory.oom_control"} {
+		contents, err := ioutil.ReadFile(filepath.Join(c.CgroupDir, file))
+		if err != nil {
+			continue
+		}
+		for _, line := range strings.Split(string(contents), "\n") {
+			if fields := strings.Fields(line); len(fields) == 2 && fields[0] == "oom_kill" {
+				if kills, err := strconv.Atoi(fields[1]); err == nil {
+					return kills
+				}
+			}
+		}
+	}
+	return -1
+}
+
+// Sampler records the peak resident memory of a process and its
+// descendants.
+type Sampler struct {
+	mu   sync.Mutex
+	peak uint64
+	done chan struct{}
+	stop chan struct{}
+}
+
+// Sample samples the resident memory of pid and its descendants below
+// procDir every interval, until Stop.
+func Sample(procDir string, pid int, interval time.Duration) *Sampler {
+	s := &Sampler{done: make(chan struct{}), stop: make(chan struct{})}
+	go func() {
+		defer close(s.done)
+		for {
+			rss := TreeRSS(procDir, pid)
+			s.mu.Lock()
+			if rss > s.peak {
+				s.peak = rss
+			}
+			s.mu.Unlock()
+
+			select {
+			case <-s.stop:
+				return
+			case <-time.After(interval):
+			}
+		}
+	}()
+	return s
+}
+
+// Peak returns the peak resident memory sampled so far, in bytes.
+func (s *Sampler) Peak() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.peak
+}
+
+// Stop stops sampling and returns the peak.
+func (s *Sampler) Stop() uint64 {
+	close(s.stop)
+	<-s.done
+	return s.Peak()
+}
+
+// TreeRSS returns the resident memory of pid and its descendants below
+// procDir, in bytes. Processes which exit while they are read count as 0.
+func TreeRSS(procDir string, pid int) uint64 {
+	var total uint64
+	pageSize := uint64(os.Getpagesize())
+	for _, p := range proctree.Snapshot(procDir, pid) {
+		total += residentPages(procDir, p.PID) * pageSize
+	}
+	return total
+}
+
+func residentPages(procDir string, pid int) uint64 {
+	contents, err := ioutil.ReadFile(filepath.Join(procDir, strconv.Itoa(pid), "statm"))
+	if err != nil {
+		return 0
+	}
+	fields := strings.Fields(string(contents))
+	if len(fields) < 2 {
+		return 0
+	}
+	pages, _ := strconv.ParseUint(fields[1], 10, 64)
+	return pages
+}
@@ -0,0 +1,13 @@
+package exitstatus_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestExitStatus(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "ExitStatus Suite")
+}
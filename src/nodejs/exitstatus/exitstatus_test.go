@@ -0,0 +1,158 @@
+package exitstatus_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"nodejs/exitstatus"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gexec"
+)
+
+var _ = Describe("ExitStatus", func() {
+	var allocate string
+
+	BeforeSuite(func() {
+		var err error
+		allocate, err = gexec.Build("nodejs/exitstatus/testdata/allocate")
+		Expect(err).To(BeNil())
+	})
+
+	AfterSuite(func() {
+		gexec.CleanupBuildArtifacts()
+	})
+
+	DescribeTable("OutOfMemory",
+		func(status exitstatus.Status, expected bool) {
+			Expect(status.OutOfMemory()).To(Equal(expected))
+			if expected {
+				Expect(status.Hint()).To(ContainSubstring("likely killed for exceeding the staging memory limit"))
+			} else {
+				Expect(status.Hint()).To(Equal(""))
+			}
+		},
+		Entry("a failure", exitstatus.Status{ExitCode: 1, OOMKills: -1}, false),
+		Entry("SIGKILL without counts or a limit", exitstatus.Status{ExitCode: -1, Signal: syscall.SIGKILL, OOMKills: -1}, true),
+		Entry("exit status 137 of a shell", exitstatus.Status{ExitCode: 137, OOMKills: -1}, true),
+		Entry("SIGKILL near the limit", exitstatus.Status{ExitCode: -1, Signal: syscall.SIGKILL, PeakRSS: 900 << 20, Limit: 1 << 30, OOMKills: -1}, true),
+		Entry("SIGKILL well below the limit", exitstatus.Status{ExitCode: -1, Signal: syscall.SIGKILL, PeakRSS: 100 << 20, Limit: 1 << 30, OOMKills: -1}, false),
+		Entry("SIGKILL the cgroup didn't count", exitstatus.Status{ExitCode: -1, Signal: syscall.SIGKILL, OOMKills: 0}, false),
+		Entry("a child the cgroup counts as killed", exitstatus.Status{ExitCode: 1, OOMKills: 1}, true),
+		Entry("SIGTERM", exitstatus.Status{ExitCode: -1, Signal: syscall.SIGTERM, OOMKills: -1}, false),
+	)
+
+	It("describes a status", func() {
+		status := exitstatus.Status{Command: "npm ci", ExitCode: -1, Signal: syscall.SIGKILL, PeakRSS: 1480 << 20, Limit: 1536 << 20, OOMKills: 1}
+		Expect(status.String()).To(Equal("npm ci was killed by SIGKILL (exit status 137) after using up to 1480 MiB of the 1536 MiB staging memory limit"))
+		Expect(status.Hint()).To(ContainSubstring("BP_NETWORK_CONCURRENCY=4 and with NODE_OPTIONS=--max-old-space-size=1152"))
+
+		status = exitstatus.Status{Command: "yarn install", ExitCode: 1, PeakRSS: 200 << 20, OOMKills: -1}
+		Expect(status.String()).To(Equal("yarn install failed with exit status 1 after using up to 200 MiB of memory"))
+	})
+
+	Describe("Command", func() {
+		var (
+			cgroupDir string
+			command   *exitstatus.Command
+		)
+
+		BeforeEach(func() {
+			var err error
+			cgroupDir, err = ioutil.TempDir("", "nodejs-buildpack.cgroup.")
+			Expect(err).To(BeNil())
+			command = &exitstatus.Command{Interval: 20 * time.Millisecond, ProcDir: "/proc", CgroupDir: cgroupDir}
+		})
+
+		AfterEach(func() {
+			Expect(os.RemoveAll(cgroupDir)).To(Succeed())
+		})
+
+		writeCgroup := func(name, contents string) {
+			Expect(os.MkdirAll(filepath.Dir(filepath.Join(cgroupDir, name)), 0755)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(cgroupDir, name), []byte(contents), 0644)).To(Succeed())
+		}
+
+		It("succeeds like libbuildpack.Command", func() {
+			stdout := new(bytes.Buffer)
+			Expect(command.Execute("", stdout, stdout, "sh", "-c", "echo hello")).To(Succeed())
+			Expect(stdout.String()).To(Equal("hello\n"))
+		})
+
+		It("returns the error of a command which doesn't start", func() {
+			err := command.Execute("", nil, nil, filepath.Join(cgroupDir, "missing"))
+			Expect(err).To(HaveOccurred())
+			Expect(err).NotTo(BeAssignableToTypeOf(&exitstatus.Error{}))
+		})
+
+		It("samples the peak memory of a process killed under a low rlimit", func() {
+			// The fixture allocates until the limit of its data segment stops
+			// it, and is killed like the OOM killer would kill it.
+			cmd := exec.Command("sh", "-c", `ulimit -d 200000 && exec "$0" 1024`, allocate)
+			err := command.Run(cmd)
+			Expect(err).To(BeAssignableToTypeOf(&exitstatus.Error{}))
+
+			status := err.(*exitstatus.Error).Status
+			Expect(status.Command).To(Equal("sh"))
+			Expect(status.Signal).To(Equal(syscall.SIGKILL))
+			Expect(status.PeakRSS).To(BeNumerically(">", 64<<20))
+			Expect(status.PeakRSS).To(BeNumerically("<", 200000<<10))
+			Expect(status.OutOfMemory()).To(BeTrue())
+			Expect(err.Error()).To(MatchRegexp(`^sh was killed by SIGKILL \(exit status 137\) after using up to \d+ MiB of memory\nIt was likely killed for exceeding the staging memory limit`))
+		})
+
+		It("counts the memory of the children of the command", func() {
+			err := command.Execute("", nil, nil, "sh", "-c", `"$0" 64 exit; exit 3`, allocate)
+			Expect(err).To(BeAssignableToTypeOf(&exitstatus.Error{}))
+
+			status := err.(*exitstatus.Error).Status
+			Expect(status.ExitCode).To(Equal(3))
+			Expect(status.Signal).To(Equal(syscall.Signal(0)))
+			Expect(status.PeakRSS).To(BeNumerically(">=", 64<<20))
+			Expect(status.OutOfMemory()).To(BeFalse())
+		})
+
+		It("counts the OOM kills of cgroup v2 while the command runs", func() {
+			writeCgroup("memory.max", "536870912\n")
+			writeCgroup("memory.events", "low 0\nhigh 0\nmax 12\noom 1\noom_kill 1\n")
+
+			err := command.Execute("", nil, nil, "sh", "-c", `printf 'oom 2\noom_kill 2\n' > "$0"; exit 1`, filepath.Join(cgroupDir, "memory.events"))
+			status := err.(*exitstatus.Error).Status
+			Expect(status.Limit).To(Equal(uint64(512 << 20)))
+			Expect(status.OOMKills).To(Equal(1))
+			Expect(status.OutOfMemory()).To(BeTrue())
+		})
+
+		It("reads the limit and the OOM kills of cgroup v1", func() {
+			writeCgroup("memory/memory.limit_in_bytes", "1073741824\n")
+			writeCgroup("memory/memory.oom_control", "oom_kill_disable 0\nunder_oom 0\noom_kill 4\n")
+			Expect(command.MemoryLimit()).To(Equal(uint64(1 << 30)))
+			Expect(command.OOMKills()).To(Equal(4))
+
+			writeCgroup("memory/memory.limit_in_bytes", "9223372036854771712\n")
+			Expect(command.MemoryLimit()).To(Equal(uint64(0)))
+		})
+
+		It("reads no limit and unknown OOM kills without a cgroup", func() {
+			Expect(command.MemoryLimit()).To(Equal(uint64(0)))
+			Expect(command.OOMKills()).To(Equal(-1))
+
+			writeCgroup("memory.max", "max\n")
+			Expect(command.MemoryLimit()).To(Equal(uint64(0)))
+		})
+	})
+
+	It("samples until stopped", func() {
+		sampler := exitstatus.Sample("/proc", os.Getpid(), 10*time.Millisecond)
+		Eventually(sampler.Peak).Should(BeNumerically(">", 0))
+		peak := sampler.Stop()
+		Expect(peak).To(Equal(sampler.Peak()))
+		Expect(exitstatus.TreeRSS("/proc", 1<<30)).To(Equal(uint64(0)))
+	})
+})
@@ -0,0 +1,42 @@
+// allocate is the fixture process of the exitstatus tests. It allocates
+// memory in chunks of 8 MiB, touching every page so that it is resident,
+// until it holds the MiB of its argument or mmap fails under the data
+// segment rlimit it runs with. Then it kills itself with SIGKILL, as the OOM
+// killer would, or with `exit` as its second argument, exits with status 1.
+package main
+
+import (
+	"os"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+const chunk = 8 << 20
+
+func main() {
+	mib, err := strconv.Atoi(os.Args[1])
+	if err != nil {
+		os.Exit(2)
+	}
+
+	var chunks [][]byte
+	for len(chunks)*chunk < mib<<20 {
+		mem, err := syscall.Mmap(-1, 0, chunk, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_ANON|syscall.MAP_PRIVATE)
+		if err != nil {
+			break
+		}
+		for i := 0; i < len(mem); i += os.Getpagesize() {
+			mem[i] = 1
+		}
+		chunks = append(chunks, mem)
+	}
+
+	// Hold the memory long enough to be sampled.
+	time.Sleep(300 * time.Millisecond)
+	if len(os.Args) > 2 && os.Args[2] == "exit" {
+		os.Exit(1)
+	}
+	syscall.Kill(os.Getpid(), syscall.SIGKILL)
+	time.Sleep(time.Second)
+}
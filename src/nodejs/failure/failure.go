@@ -0,0 +1,103 @@
+package failure
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cloudfoundry/libbuildpack"
+)
+
+// Code names a kind of staging failure. Codes are stable across releases, so
+// that platforms can aggregate failures without matching the log text.
+type Code string
+
+const (
+	NodeEngineUnresolvable Code = "NODE_ENGINE_UNRESOLVABLE"
+	DownloadFailed         Code = "DOWNLOAD_FAILED"
+	ChecksumMismatch       Code = "CHECKSUM_MISMATCH"
+	InstallFailed          Code = "INSTALL_FAILED"
+	BuildScriptFailed      Code = "BUILD_SCRIPT_FAILED"
+	ScriptTimedOut         Code = "SCRIPT_TIMED_OUT"
+	HookFailed             Code = "HOOK_FAILED"
+	PruneFailed            Code = "PRUNE_FAILED"
+	NodeModulesTooLarge    Code = "NODE_MODULES_TOO_LARGE"
+	LocalPackageMissing    Code = "LOCAL_PACKAGE_MISSING"
+	LocalPackageCycle      Code = "LOCAL_PACKAGE_CYCLE"
+	ProcfileInvalid        Code = "PROCFILE_INVALID"
+	NodeSassIncompatible   Code = "NODE_SASS_INCOMPATIBLE"
+	// StagingFailed is the code of the failures without a more specific one.
+	StagingFailed Code = "STAGING_FAILED"
+)
+
+// FileName is written to the dep dir when staging fails.
+const FileName = "error.json"
+
+// Error is a failure with a code. Its message is the message of the wrapped
+// error, unchanged.
+type Error struct {
+	Code Code
+	Err  error
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+// Wrap gives err the code, unless err has a code already: the code closest to
+// the failure is the most specific one.
+func Wrap(code Code, err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := err.(*Error); ok {
+		return err
+	}
+	return &Error{Code: code, Err: err}
+}
+
+// Download wraps the error of installing a dependency from the manifest,
+// telling a checksum mismatch apart from a failed download.
+func Download(err error) error {
+	if err == nil {
+		return nil
+	}
+	message := err.Error()
+	if strings.Contains(message, "sha256 mismatch") || strings.Contains(message, "checksum mismatch") {
+		return Wrap(ChecksumMismatch, err)
+	}
+	return Wrap(DownloadFailed, err)
+}
+
+// CodeOf is the code of err, StagingFailed when it has none.
+func CodeOf(err error) Code {
+	if e, ok := err.(*Error); ok {
+		return e.Code
+	}
+	return StagingFailed
+}
+
+// Report logs err with its code as the last line of the output and writes it
+// to error.json in depDir. The error is logged even when error.json can't be
+// written, as the dep dir may not exist yet.
+func Report(log *libbuildpack.Logger, depDir string, err error) {
+	code := CodeOf(err)
+	log.Error("[%s] %s", code, err.Error())
+
+	if depDir == "" {
+		return
+	}
+	contents, jsonErr := json.Marshal(struct {
+		Code    Code   `json:"code"`
+		Message string `json:"message"`
+	}{code, err.Error()})
+	if jsonErr != nil {
+		return
+	}
+	if _, statErr := os.Stat(depDir); statErr != nil {
+		return
+	}
+	ioutil.WriteFile(filepath.Join(depDir, FileName), contents, 0644)
+}
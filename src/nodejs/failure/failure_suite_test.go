@@ -0,0 +1,13 @@
+package failure_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestFailure(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Failure Suite")
+}
@@ -0,0 +1,81 @@
+package failure_test
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"nodejs/failure"
+	"os"
+	"path/filepath"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/cloudfoundry/libbuildpack/ansicleaner"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Failure", func() {
+	Describe("Wrap", func() {
+		It("keeps the message of the error", func() {
+			err := failure.Wrap(failure.InstallFailed, errors.New("npm install exited with 1"))
+			Expect(err).To(MatchError("npm install exited with 1"))
+			Expect(failure.CodeOf(err)).To(Equal(failure.InstallFailed))
+		})
+
+		It("keeps the code of an error which has one", func() {
+			err := failure.Wrap(failure.HookFailed, failure.Wrap(failure.ChecksumMismatch, errors.New("checksum mismatch")))
+			Expect(failure.CodeOf(err)).To(Equal(failure.ChecksumMismatch))
+		})
+
+		It("leaves nil alone", func() {
+			Expect(failure.Wrap(failure.PruneFailed, nil)).To(BeNil())
+			Expect(failure.Download(nil)).To(BeNil())
+		})
+	})
+
+	DescribeTable("Download",
+		func(message string, code failure.Code) {
+			Expect(failure.CodeOf(failure.Download(errors.New(message)))).To(Equal(code))
+		},
+		Entry("a sha256 mismatch", "dependency sha256 mismatch: expected sha256 abc, actual sha256 def", failure.ChecksumMismatch),
+		Entry("a checksum mismatch", "checksum mismatch: expected abc, got def", failure.ChecksumMismatch),
+		Entry("a failed download", "could not download: 404", failure.DownloadFailed),
+	)
+
+	It("has a code for errors without one", func() {
+		Expect(failure.CodeOf(errors.New("disk full"))).To(Equal(failure.StagingFailed))
+	})
+
+	Describe("Report", func() {
+		var (
+			err    error
+			depDir string
+			buffer *bytes.Buffer
+			logger *libbuildpack.Logger
+		)
+
+		BeforeEach(func() {
+			depDir, err = ioutil.TempDir("", "nodejs-buildpack.dep.")
+			Expect(err).To(BeNil())
+			buffer = new(bytes.Buffer)
+			logger = libbuildpack.NewLogger(ansicleaner.New(buffer))
+		})
+
+		AfterEach(func() {
+			Expect(os.RemoveAll(depDir)).To(Succeed())
+		})
+
+		It("logs the code and writes error.json", func() {
+			failure.Report(logger, depDir, failure.Wrap(failure.NodeEngineUnresolvable, errors.New("no match found for 99.x")))
+			Expect(buffer.String()).To(Equal("       **ERROR** [NODE_ENGINE_UNRESOLVABLE] no match found for 99.x\n"))
+			Expect(ioutil.ReadFile(filepath.Join(depDir, "error.json"))).To(MatchJSON(`{"code":"NODE_ENGINE_UNRESOLVABLE","message":"no match found for 99.x"}`))
+		})
+
+		It("logs the error when the dep dir does not exist", func() {
+			failure.Report(logger, filepath.Join(depDir, "missing"), errors.New("disk full"))
+			Expect(buffer.String()).To(ContainSubstring("**ERROR** [STAGING_FAILED] disk full"))
+			Expect(filepath.Join(depDir, "missing")).NotTo(BeAnExistingFile())
+		})
+	})
+})
@@ -0,0 +1,16 @@
+package finalize
+
+import (
+	"nodejs/appconfig"
+	"os"
+)
+
+// LoadAppConfig exports the keys of .cfnodejs.yml again for finalize, which
+// runs in its own process. Supply has reported them already.
+func (f *Finalizer) LoadAppConfig() error {
+	config, err := appconfig.Load(f.Stager.BuildDir())
+	if err != nil || config == nil {
+		return err
+	}
+	return appconfig.Setenv(appconfig.Resolve(config, os.LookupEnv))
+}
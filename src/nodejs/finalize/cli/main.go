@@ -3,9 +3,16 @@ package main
 import (
 	"io"
 	"io/ioutil"
+	"nodejs/exitstatus"
+	"nodejs/failure"
 	"nodejs/finalize"
-	_ "nodejs/hooks"
+	"nodejs/heartbeat"
+	"nodejs/hooks"
+	"nodejs/logdedup"
+	"nodejs/netbudget"
+	"nodejs/yarn"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/cloudfoundry/libbuildpack"
@@ -20,7 +27,9 @@ func main() {
 		os.Exit(8)
 	}
 
-	stdout := io.MultiWriter(os.Stdout, logfile)
+	// The log file keeps every warning, the staging output each one once.
+	dedup := logdedup.New(os.Stdout)
+	stdout := io.MultiWriter(dedup, logfile)
 	logger := libbuildpack.NewLogger(stdout)
 
 	buildpackDir, err := libbuildpack.GetBuildpackDir()
@@ -52,17 +61,37 @@ func main() {
 		Manifest: manifest,
 		Log:      logger,
 		Logfile:  logfile,
+		Yarn: &yarn.Yarn{
+			Command: heartbeat.New(exitstatus.New(), logger),
+			Log:     logger,
+		},
+		Command: heartbeat.New(exitstatus.New(), logger),
 	}
 
-	if err := finalize.Run(&f); err != nil {
+	err = finalize.Run(&f)
+	dedup.Flush()
+	if err != nil {
+		failure.Report(logger, stager.DepDir(), err)
 		os.Exit(12)
 	}
 
+	if err := hooks.AddExternalHooks(buildpackDir, stager.DepsDir()); err != nil {
+		logger.Error("Unable to find the hooks in %s: %s", hooks.ExternalHooksDir, err)
+		os.Exit(13)
+	}
+
 	if err := libbuildpack.RunAfterCompile(stager); err != nil {
 		logger.Error("After Compile: %s", err.Error())
+		failure.Report(logger, stager.DepDir(), failure.Wrap(failure.HookFailed, err))
 		os.Exit(13)
 	}
 
+	if err := f.RecordHookComponents(); err != nil {
+		logger.Warning("Unable to record the components of the hooks: %s", err.Error())
+	}
+
+	reportNetworkBudget(logger, stager.DepDir())
+
 	if err := stager.SetLaunchEnvironment(); err != nil {
 		logger.Error("Unable to setup launch environment: %s", err.Error())
 		os.Exit(14)
@@ -70,3 +99,21 @@ func main() {
 
 	stager.StagingComplete()
 }
+
+// reportNetworkBudget reports how the optional network features of the
+// staging went on their shared budget.
+func reportNetworkBudget(logger *libbuildpack.Logger, depDir string) {
+	outcomes, err := netbudget.Finish(depDir)
+	if err != nil {
+		logger.Debug("Unable to report the optional network features: %s", err)
+		return
+	}
+	if len(outcomes) == 0 {
+		return
+	}
+	var lines []string
+	for _, outcome := range outcomes {
+		lines = append(lines, outcome.String())
+	}
+	logger.Info("Optional network features (BP_OPTIONAL_NETWORK_BUDGET):\n%s", strings.Join(lines, "\n"))
+}
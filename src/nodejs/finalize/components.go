@@ -0,0 +1,37 @@
+package finalize
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"nodejs/hooks"
+	"os"
+	"path/filepath"
+
+	"github.com/cloudfoundry/libbuildpack"
+)
+
+// RecordHookComponents adds the components the hooks contributed, like the
+// agents they installed, to buildpack-metadata.json, so that tools building
+// the SBOM of the droplet find code which isn't in node_modules.
+func (f *Finalizer) RecordHookComponents() error {
+	components, err := hooks.FinishComponents(f.Stager.DepDir())
+	if err != nil || len(components) == 0 {
+		return err
+	}
+
+	path := filepath.Join(f.Stager.DepDir(), BuildpackMetadataFile)
+	var metadata buildpackMetadata
+	if contents, err := ioutil.ReadFile(path); err == nil {
+		if err := json.Unmarshal(contents, &metadata); err != nil {
+			return err
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	for _, component := range components {
+		f.Log.Info("[%s] installed %s %s", component.Hook, component.Name, component.Version)
+	}
+	metadata.Components = append(metadata.Components, components...)
+	return libbuildpack.NewJSON().Write(path, metadata)
+}
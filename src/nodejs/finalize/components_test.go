@@ -0,0 +1,91 @@
+package finalize_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"nodejs/finalize"
+	"nodejs/hooks"
+	"os"
+	"path/filepath"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/cloudfoundry/libbuildpack/ansicleaner"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("RecordHookComponents", func() {
+	var (
+		err       error
+		depsDir   string
+		depDir    string
+		finalizer *finalize.Finalizer
+		buffer    *bytes.Buffer
+	)
+
+	agent := hooks.Component{
+		Name:    "dynatrace-oneagent",
+		Version: "1.130.0.20170914-153344",
+		Source:  "https://example.com/v1/deployment/installer/agent/unix/paas-sh/version/1.130.0.20170914-153344",
+		SHA256:  "a9f5e6d3",
+		Hook:    "dynatrace",
+	}
+
+	metadata := func() map[string]json.RawMessage {
+		data, err := ioutil.ReadFile(filepath.Join(depDir, finalize.BuildpackMetadataFile))
+		Expect(err).To(BeNil())
+		var written map[string]json.RawMessage
+		Expect(json.Unmarshal(data, &written)).To(Succeed())
+		return written
+	}
+
+	BeforeEach(func() {
+		depsDir, err = ioutil.TempDir("", "nodejs-buildpack.deps.")
+		Expect(err).To(BeNil())
+		depDir = filepath.Join(depsDir, "0")
+		Expect(os.MkdirAll(depDir, 0755)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(depDir, finalize.BuildpackMetadataFile), []byte(`{"node_modules":{"sha256":"abc","size":19,"files":1}}`), 0644)).To(Succeed())
+
+		buffer = new(bytes.Buffer)
+		logger := libbuildpack.NewLogger(ansicleaner.New(buffer))
+		finalizer = &finalize.Finalizer{
+			Stager: libbuildpack.NewStager([]string{"", "", depsDir, "0"}, logger, &libbuildpack.Manifest{}),
+			Log:    logger,
+		}
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(depsDir)).To(Succeed())
+	})
+
+	It("adds the components of the hooks to the metadata", func() {
+		Expect(libbuildpack.NewJSON().Write(filepath.Join(depDir, hooks.ComponentsFile), []hooks.Component{agent})).To(Succeed())
+
+		Expect(finalizer.RecordHookComponents()).To(Succeed())
+		Expect(buffer.String()).To(ContainSubstring("[dynatrace] installed dynatrace-oneagent 1.130.0.20170914-153344"))
+
+		written := metadata()
+		Expect(string(written["node_modules"])).To(Equal(`{"sha256":"abc","size":19,"files":1}`))
+		var components []hooks.Component
+		Expect(json.Unmarshal(written["components"], &components)).To(Succeed())
+		Expect(components).To(Equal([]hooks.Component{agent}))
+		Expect(filepath.Join(depDir, hooks.ComponentsFile)).NotTo(BeAnExistingFile())
+	})
+
+	It("adds no components when no hook contributed any", func() {
+		Expect(finalizer.RecordHookComponents()).To(Succeed())
+
+		Expect(metadata()).NotTo(HaveKey("components"))
+		Expect(buffer.String()).To(Equal(""))
+	})
+
+	It("writes the metadata when the node_modules digest is missing", func() {
+		Expect(os.Remove(filepath.Join(depDir, finalize.BuildpackMetadataFile))).To(Succeed())
+		Expect(libbuildpack.NewJSON().Write(filepath.Join(depDir, hooks.ComponentsFile), []hooks.Component{agent})).To(Succeed())
+
+		Expect(finalizer.RecordHookComponents()).To(Succeed())
+		Expect(metadata()).To(HaveKey("components"))
+		Expect(metadata()).NotTo(HaveKey("node_modules"))
+	})
+})
@@ -0,0 +1,66 @@
+package finalize
+
+import (
+	"nodejs/digest"
+	"nodejs/hooks"
+	"nodejs/supply"
+	"path/filepath"
+
+	"github.com/cloudfoundry/libbuildpack"
+)
+
+// BuildpackMetadataFile is written to the dep dir with facts about the
+// droplet for tools inspecting it.
+const BuildpackMetadataFile = "buildpack-metadata.json"
+
+type buildpackMetadata struct {
+	NodeModules *digest.Tree `json:"node_modules,omitempty"`
+	// Components are what the hooks put into the droplet, like APM agents.
+	Components []hooks.Component `json:"components,omitempty"`
+}
+
+// RecordNodeModulesDigest digests the final node_modules, stores the digest
+// in the cache and in buildpack-metadata.json, and logs whether the
+// dependency tree changed since the previous staging.
+func (f *Finalizer) RecordNodeModulesDigest() error {
+	nodeModules, err := f.nodeModulesDir()
+	if err != nil {
+		return err
+	}
+
+	tree, err := digest.Compute(nodeModules, 0)
+	if err != nil {
+		return err
+	}
+
+	metadata, err := supply.LoadCacheMetadata(f.Stager.CacheDir())
+	if err != nil {
+		return err
+	}
+	switch previous := metadata.NodeModulesDigest; {
+	case previous == nil:
+		f.Log.Info("node_modules digest: %s (%d files, %d bytes, first build)", tree.SHA256, tree.Files, tree.Size)
+	case previous.SHA256 == tree.SHA256:
+		f.Log.Info("node_modules digest: %s (%d files, %d bytes, identical to the previous build)", tree.SHA256, tree.Files, tree.Size)
+	default:
+		f.Log.Info("node_modules digest: %s (%d files, %d bytes, changed from %s with %d files, %d bytes)", tree.SHA256, tree.Files, tree.Size, previous.SHA256, previous.Files, previous.Size)
+	}
+
+	metadata.NodeModulesDigest = &tree
+	if err := metadata.Save(f.Stager.CacheDir()); err != nil {
+		return err
+	}
+	return libbuildpack.NewJSON().Write(filepath.Join(f.Stager.DepDir(), BuildpackMetadataFile), buildpackMetadata{NodeModules: &tree})
+}
+
+// nodeModulesDir returns the node_modules of the droplet, in the dep dir
+// with BP_NODE_MODULES_LOCATION=depdir-symlink, else in the app dir.
+func (f *Finalizer) nodeModulesDir() (string, error) {
+	nodeModules := filepath.Join(f.Stager.DepDir(), "node_modules")
+	if found, err := libbuildpack.FileExists(nodeModules); err != nil {
+		return "", err
+	} else if !found {
+		nodeModules = filepath.Join(f.Stager.BuildDir(), "node_modules")
+	}
+	return nodeModules, nil
+}
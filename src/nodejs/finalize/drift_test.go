@@ -0,0 +1,117 @@
+package finalize_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"nodejs/finalize"
+	"nodejs/supply"
+	"os"
+	"path/filepath"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/cloudfoundry/libbuildpack/ansicleaner"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("RecordNodeModulesDigest", func() {
+	var (
+		err       error
+		buildDir  string
+		cacheDir  string
+		depsDir   string
+		finalizer *finalize.Finalizer
+		buffer    *bytes.Buffer
+	)
+
+	writeFile := func(path, contents string) {
+		Expect(os.MkdirAll(filepath.Dir(path), 0755)).To(Succeed())
+		Expect(ioutil.WriteFile(path, []byte(contents), 0644)).To(Succeed())
+	}
+
+	BeforeEach(func() {
+		buildDir, err = ioutil.TempDir("", "nodejs-buildpack.build.")
+		Expect(err).To(BeNil())
+		cacheDir, err = ioutil.TempDir("", "nodejs-buildpack.cache.")
+		Expect(err).To(BeNil())
+		depsDir, err = ioutil.TempDir("", "nodejs-buildpack.deps.")
+		Expect(err).To(BeNil())
+		Expect(os.MkdirAll(filepath.Join(depsDir, "0"), 0755)).To(Succeed())
+
+		writeFile(filepath.Join(depsDir, "0", "node_modules", "leftpad", "index.js"), "module.exports = 1\n")
+
+		buffer = new(bytes.Buffer)
+		logger := libbuildpack.NewLogger(ansicleaner.New(buffer))
+		finalizer = &finalize.Finalizer{
+			Stager: libbuildpack.NewStager([]string{buildDir, cacheDir, depsDir, "0"}, logger, &libbuildpack.Manifest{}),
+			Log:    logger,
+		}
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(buildDir)).To(Succeed())
+		Expect(os.RemoveAll(cacheDir)).To(Succeed())
+		Expect(os.RemoveAll(depsDir)).To(Succeed())
+	})
+
+	It("records the digest of the first build", func() {
+		Expect(finalizer.RecordNodeModulesDigest()).To(Succeed())
+		Expect(buffer.String()).To(ContainSubstring("(1 files, 19 bytes, first build)"))
+
+		metadata, err := supply.LoadCacheMetadata(cacheDir)
+		Expect(err).To(BeNil())
+		Expect(metadata.NodeModulesDigest).NotTo(BeNil())
+		Expect(metadata.NodeModulesDigest.Size).To(Equal(uint64(19)))
+
+		data, err := ioutil.ReadFile(filepath.Join(depsDir, "0", "buildpack-metadata.json"))
+		Expect(err).To(BeNil())
+		var written struct {
+			NodeModules struct {
+				SHA256 string `json:"sha256"`
+				Size   uint64 `json:"size"`
+				Files  int    `json:"files"`
+			} `json:"node_modules"`
+		}
+		Expect(json.Unmarshal(data, &written)).To(Succeed())
+		Expect(written.NodeModules.SHA256).To(Equal(metadata.NodeModulesDigest.SHA256))
+		Expect(written.NodeModules.Files).To(Equal(1))
+	})
+
+	It("keeps the other cache metadata", func() {
+		Expect(supply.CacheMetadata{Stack: "cflinuxfs4", NodeModulesSize: 42}.Save(cacheDir)).To(Succeed())
+		Expect(finalizer.RecordNodeModulesDigest()).To(Succeed())
+
+		metadata, err := supply.LoadCacheMetadata(cacheDir)
+		Expect(err).To(BeNil())
+		Expect(metadata.Stack).To(Equal("cflinuxfs4"))
+		Expect(metadata.NodeModulesSize).To(Equal(uint64(42)))
+	})
+
+	It("reports an identical dependency tree", func() {
+		Expect(finalizer.RecordNodeModulesDigest()).To(Succeed())
+		buffer.Reset()
+
+		Expect(finalizer.RecordNodeModulesDigest()).To(Succeed())
+		Expect(buffer.String()).To(ContainSubstring("identical to the previous build"))
+	})
+
+	It("reports a changed dependency tree", func() {
+		Expect(finalizer.RecordNodeModulesDigest()).To(Succeed())
+		previous, err := supply.LoadCacheMetadata(cacheDir)
+		Expect(err).To(BeNil())
+		buffer.Reset()
+
+		writeFile(filepath.Join(depsDir, "0", "node_modules", "leftpad", "index.js"), "module.exports = 2\n")
+		Expect(finalizer.RecordNodeModulesDigest()).To(Succeed())
+		Expect(buffer.String()).To(ContainSubstring("changed from " + previous.NodeModulesDigest.SHA256 + " with 1 files, 19 bytes"))
+	})
+
+	It("digests node_modules in the app dir", func() {
+		Expect(os.RemoveAll(filepath.Join(depsDir, "0", "node_modules"))).To(Succeed())
+		writeFile(filepath.Join(buildDir, "node_modules", "leftpad", "index.js"), "module.exports = 10\n")
+
+		Expect(finalizer.RecordNodeModulesDigest()).To(Succeed())
+		Expect(buffer.String()).To(ContainSubstring("(1 files, 20 bytes, first build)"))
+	})
+})
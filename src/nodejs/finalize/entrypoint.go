@@ -0,0 +1,36 @@
+package finalize
+
+import (
+	"nodejs/packagejson"
+	"nodejs/supply"
+)
+
+// StartEntrypoint starts an app without package.json, which npm start can't
+// run, with node and the first of supply.Entrypoints it has. A web process
+// of the Procfile takes precedence.
+func (f *Finalizer) StartEntrypoint() error {
+	if _, found, err := f.packageJSON(); err != nil || found {
+		return err
+	}
+
+	procfile, err := f.readProcfile()
+	if err != nil {
+		return err
+	}
+	if _, found := procfile.Command("web"); found {
+		return nil
+	}
+
+	entrypoint, err := supply.Entrypoint(f.Stager.BuildDir())
+	if err != nil || entrypoint == "" {
+		// WarnNoStart explains that the app has no way to start.
+		return err
+	}
+	if err := f.writeWrapper(StartWrapper, "start", "npm", "exec node "+entrypoint, packagejson.Package{}); err != nil {
+		return err
+	}
+	f.Entrypoint = entrypoint
+
+	f.Log.Warning("No package.json found, starting the app with `node %s`\nNo dependencies were installed, add a package.json listing them to install them", entrypoint)
+	return nil
+}
@@ -0,0 +1,89 @@
+package finalize_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"nodejs/finalize"
+	"os"
+	"path/filepath"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/cloudfoundry/libbuildpack/ansicleaner"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("StartEntrypoint", func() {
+	var (
+		err       error
+		buildDir  string
+		depsDir   string
+		finalizer *finalize.Finalizer
+		buffer    *bytes.Buffer
+	)
+
+	writeFile := func(name, contents string) {
+		Expect(ioutil.WriteFile(filepath.Join(buildDir, name), []byte(contents), 0644)).To(Succeed())
+	}
+
+	BeforeEach(func() {
+		buildDir, err = ioutil.TempDir("", "nodejs-buildpack.build.")
+		Expect(err).To(BeNil())
+		depsDir, err = ioutil.TempDir("", "nodejs-buildpack.deps.")
+		Expect(err).To(BeNil())
+		Expect(os.MkdirAll(filepath.Join(depsDir, "0"), 0755)).To(Succeed())
+
+		buffer = new(bytes.Buffer)
+		logger := libbuildpack.NewLogger(ansicleaner.New(buffer))
+		finalizer = &finalize.Finalizer{
+			Stager: libbuildpack.NewStager([]string{buildDir, "", depsDir, "0"}, logger, &libbuildpack.Manifest{}),
+			Log:    logger,
+		}
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(buildDir)).To(Succeed())
+		Expect(os.RemoveAll(depsDir)).To(Succeed())
+	})
+
+	It("starts the first entrypoint of an app without package.json with node", func() {
+		writeFile("app.js", "")
+		writeFile("index.js", "")
+
+		Expect(finalizer.StartEntrypoint()).To(Succeed())
+		Expect(finalizer.Entrypoint).To(Equal("index.js"))
+		wrapper, err := ioutil.ReadFile(filepath.Join(buildDir, finalize.StartWrapper))
+		Expect(err).To(BeNil())
+		Expect(string(wrapper)).To(ContainSubstring("\nexec node index.js \"$@\"\n"))
+		Expect(buffer.String()).To(ContainSubstring("No package.json found, starting the app with `node index.js`"))
+		Expect(buffer.String()).To(ContainSubstring("No dependencies were installed"))
+
+		Expect(finalizer.WarnNoStart()).To(Succeed())
+		Expect(buffer.String()).NotTo(ContainSubstring("may not specify any way to start"))
+	})
+
+	It("leaves an app with package.json to DirectStart", func() {
+		writeFile("package.json", `{"name": "app"}`)
+		writeFile("index.js", "")
+
+		Expect(finalizer.StartEntrypoint()).To(Succeed())
+		Expect(filepath.Join(buildDir, finalize.StartWrapper)).NotTo(BeAnExistingFile())
+		Expect(buffer.String()).To(Equal(""))
+	})
+
+	It("leaves the web process of the Procfile alone", func() {
+		writeFile("Procfile", "web: node main.js\n")
+		writeFile("server.js", "")
+
+		Expect(finalizer.StartEntrypoint()).To(Succeed())
+		Expect(filepath.Join(buildDir, finalize.StartWrapper)).NotTo(BeAnExistingFile())
+	})
+
+	It("leaves an app without an entrypoint to WarnNoStart", func() {
+		writeFile("README.md", "")
+
+		Expect(finalizer.StartEntrypoint()).To(Succeed())
+		Expect(finalizer.WarnNoStart()).To(Succeed())
+		Expect(buffer.String()).To(ContainSubstring("This app may not specify any way to start a node process"))
+	})
+})
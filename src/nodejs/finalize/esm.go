@@ -0,0 +1,248 @@
+package finalize
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+type nodeFlag struct {
+	Name  string
+	Value string
+}
+
+// parseNodeFlags returns the --require/-r, --import and --loader flags in a
+// NODE_OPTIONS style string, along with the first argument which is not a
+// flag (the entrypoint of a node command line).
+func parseNodeFlags(args string) ([]nodeFlag, string) {
+	var flags []nodeFlag
+	fields := strings.Fields(args)
+	for i := 0; i < len(fields); i++ {
+		field := fields[i]
+		if !strings.HasPrefix(field, "-") {
+			return flags, field
+		}
+
+		name, value := field, ""
+		if idx := strings.Index(field, "="); idx >= 0 {
+			name, value = field[:idx], field[idx+1:]
+		}
+		switch name {
+		case "-r", "--require", "--import", "--loader", "--experimental-loader":
+			if value == "" && i+1 < len(fields) {
+				i++
+				value = fields[i]
+			}
+			if name == "-r" {
+				name = "--require"
+			}
+			if name == "--experimental-loader" {
+				name = "--loader"
+			}
+			flags = append(flags, nodeFlag{Name: name, Value: strings.Trim(value, `"'`)})
+		}
+	}
+	return flags, ""
+}
+
+func moduleFormat(pkgType, path string) string {
+	switch filepath.Ext(path) {
+	case ".mjs":
+		return "module"
+	case ".cjs":
+		return "commonjs"
+	}
+	if pkgType == "module" {
+		return "module"
+	}
+	return "commonjs"
+}
+
+func isLocalPath(path string) bool {
+	return strings.HasPrefix(path, "./") || strings.HasPrefix(path, "../") || strings.HasPrefix(path, "/")
+}
+
+func nodeVersionAtLeast(version string, major, minor int) bool {
+	parts := strings.SplitN(strings.TrimPrefix(version, "v"), ".", 3)
+	gotMajor, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return true
+	}
+	gotMinor := 0
+	if len(parts) > 1 {
+		gotMinor, _ = strconv.Atoi(parts[1])
+	}
+	return gotMajor > major || (gotMajor == major && gotMinor >= minor)
+}
+
+// supportsImportFlag reports whether Node.js version has --import, which
+// came with 19.0.0 and was backported to 18.18.0.
+func supportsImportFlag(version string) bool {
+	return nodeVersionAtLeast(version, 18, 18)
+}
+
+// CheckModuleFormat returns a warning for every ESM/CJS mismatch between the
+// package.json type, the entrypoint, the preloads in nodeOptions and the
+// Node.js version. An empty entry or nodeVersion skips the rules which need
+// them.
+func CheckModuleFormat(pkgType, entry, nodeOptions, nodeVersion string) []string {
+	var warnings []string
+
+	if entry != "" && nodeVersion != "" && moduleFormat(pkgType, entry) == "module" && !nodeVersionAtLeast(nodeVersion, 12, 17) {
+		warnings = append(warnings, fmt.Sprintf("%s is an ES module, which Node.js %s cannot load without --experimental-modules\nUse Node.js 12.17 or later in engines.node", entry, nodeVersion))
+	}
+
+	flags, _ := parseNodeFlags(nodeOptions)
+	for _, flag := range flags {
+		switch flag.Name {
+		case "--require":
+			if filepath.Ext(flag.Value) == ".mjs" {
+				warnings = append(warnings, fmt.Sprintf("--require %s loads an ES module and fails with ERR_REQUIRE_ESM\nUse --import %s instead", flag.Value, flag.Value))
+			} else if isLocalPath(flag.Value) && moduleFormat(pkgType, flag.Value) == "module" {
+				warnings = append(warnings, fmt.Sprintf("--require %s loads an ES module because package.json has \"type\": \"module\" and fails with ERR_REQUIRE_ESM\nRename it to %s or use --import %s instead", flag.Value, strings.TrimSuffix(flag.Value, filepath.Ext(flag.Value))+".cjs", flag.Value))
+			}
+		case "--import":
+			if nodeVersion != "" && !supportsImportFlag(nodeVersion) {
+				warnings = append(warnings, fmt.Sprintf("--import %s is not supported by Node.js %s\nUse Node.js 18.18 or later, or --require with a CommonJS file", flag.Value, nodeVersion))
+			}
+		case "--loader":
+			if nodeVersion != "" && nodeVersionAtLeast(nodeVersion, 20, 6) {
+				warnings = append(warnings, fmt.Sprintf("--loader %s is deprecated on Node.js %s\nRegister the loader from a file passed to --import instead", flag.Value, nodeVersion))
+			}
+		}
+	}
+
+	return warnings
+}
+
+var (
+	commonJSPattern = regexp.MustCompile(`(^|[^.\w])(require\s*\(|module\.exports\b|exports\.\w+\s*=)`)
+	esModulePattern = regexp.MustCompile(`^(import\s+[\w*{"']|import\s*\{|export\s+(default|const|let|var|function|class|async|\{|\*))`)
+)
+
+// moduleSyntax reports whether a file uses CommonJS (require, module.exports)
+// or ES module (import, export) syntax at the top level.
+func moduleSyntax(file string) (bool, bool, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, false, nil
+		}
+		return false, false, err
+	}
+	defer f.Close()
+
+	var cjs, esm bool
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "//") || strings.HasPrefix(line, "*") || strings.Contains(line, "createRequire") {
+			continue
+		}
+		cjs = cjs || commonJSPattern.MatchString(line)
+		esm = esm || esModulePattern.MatchString(line)
+	}
+	return cjs, esm, scanner.Err()
+}
+
+func (f *Finalizer) startCommand() (string, error) {
+	procfile, err := f.readProcfile()
+	if err != nil {
+		return "", err
+	}
+	if command, found := procfile.Command("web"); found {
+		return command, nil
+	}
+
+	if f.StartScript != "" {
+		return f.StartScript, nil
+	}
+	return "node server.js", nil
+}
+
+// entrypoint returns the file and node flags of a `node ...` start command.
+// `node .` resolves through main, exports is not used for it.
+func (f *Finalizer) entrypoint(command string) (string, string) {
+	fields := strings.Fields(command)
+	for i, field := range fields {
+		if filepath.Base(field) != "node" {
+			continue
+		}
+		args := strings.Join(fields[i+1:], " ")
+		_, entry := parseNodeFlags(args)
+		if entry == "." || entry == "./" {
+			entry = f.Main
+			if entry == "" {
+				entry = "index.js"
+			}
+		}
+		return entry, args
+	}
+	return "", ""
+}
+
+func (f *Finalizer) installedNodeVersion() string {
+	header := filepath.Join(f.Stager.DepDir(), "node", "include", "node", "node_version.h")
+	contents, err := ioutil.ReadFile(header)
+	if err != nil {
+		return ""
+	}
+	version := make([]string, 3)
+	for i, name := range []string{"NODE_MAJOR_VERSION", "NODE_MINOR_VERSION", "NODE_PATCH_VERSION"} {
+		m := regexp.MustCompile(`#define ` + name + ` (\d+)`).FindSubmatch(contents)
+		if m == nil {
+			return ""
+		}
+		version[i] = string(m[1])
+	}
+	return strings.Join(version, ".")
+}
+
+// WarnModuleFormat warns about ESM/CJS mismatches which only show up at
+// runtime, like ERR_REQUIRE_ESM.
+func (f *Finalizer) WarnModuleFormat() error {
+	command, err := f.startCommand()
+	if err != nil {
+		return err
+	}
+	entry, args := f.entrypoint(command)
+	nodeVersion := f.installedNodeVersion()
+
+	warnings := CheckModuleFormat(f.PackageType, entry, strings.TrimSpace(os.Getenv("NODE_OPTIONS")+" "+args), nodeVersion)
+
+	if strings.TrimSpace(command) == "node ." && f.Main == "" && f.HasExports {
+		warnings = append(warnings, "package.json has exports but no main, `node .` ignores exports and starts index.js\nSet main to the app entrypoint")
+	}
+
+	if entry != "" {
+		cjs, esm, err := moduleSyntax(filepath.Join(f.Stager.BuildDir(), entry))
+		if err != nil {
+			return err
+		}
+		base := strings.TrimSuffix(entry, filepath.Ext(entry))
+		if moduleFormat(f.PackageType, entry) == "module" && cjs && !esm {
+			fix := "Rename it to " + base + ".cjs or use import/export"
+			if filepath.Ext(entry) == ".js" {
+				fix += ", or remove \"type\": \"module\" from package.json"
+			}
+			warnings = append(warnings, fmt.Sprintf("%s uses require() or module.exports but is loaded as an ES module\n%s", entry, fix))
+		}
+		if moduleFormat(f.PackageType, entry) == "commonjs" && esm && !cjs {
+			fix := "Rename it to " + base + ".mjs"
+			if filepath.Ext(entry) == ".js" {
+				fix += ", or add \"type\": \"module\" to package.json"
+			}
+			warnings = append(warnings, fmt.Sprintf("%s uses import/export but is loaded as CommonJS and fails with \"Cannot use import statement outside a module\"\n%s", entry, fix))
+		}
+	}
+
+	for _, warning := range warnings {
+		f.Log.Warning("%s", warning)
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+package finalize_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"nodejs/finalize"
+	"os"
+	"path/filepath"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/cloudfoundry/libbuildpack/ansicleaner"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Module format", func() {
+	DescribeTable("CheckModuleFormat",
+		func(pkgType, entry, nodeOptions, nodeVersion string, expected []string) {
+			warnings := finalize.CheckModuleFormat(pkgType, entry, nodeOptions, nodeVersion)
+			Expect(warnings).To(HaveLen(len(expected)))
+			for i, warning := range expected {
+				Expect(warnings[i]).To(ContainSubstring(warning))
+			}
+		},
+		Entry("commonjs app", "", "server.js", "", "8.12.0", nil),
+		Entry("module app on a modern node", "module", "server.js", "", "18.19.0", nil),
+		Entry("module app on an old node", "module", "server.js", "", "10.24.1", []string{"server.js is an ES module, which Node.js 10.24.1 cannot load"}),
+		Entry(".mjs entry on an old node", "", "server.mjs", "", "12.16.0", []string{"Use Node.js 12.17 or later"}),
+		Entry(".cjs entry in a module app on an old node", "module", "server.cjs", "", "10.24.1", nil),
+		Entry("--require of a .js preload in a module app", "module", "server.js", "-r ./tracing.js", "20.11.1", []string{"--require ./tracing.js loads an ES module because package.json has \"type\": \"module\" and fails with ERR_REQUIRE_ESM\nRename it to ./tracing.cjs or use --import ./tracing.js"}),
+		Entry("--require of a .cjs preload in a module app", "module", "server.js", "--require ./tracing.cjs", "20.11.1", nil),
+		Entry("--require of a package in a module app", "module", "server.js", "--require dd-trace/init", "20.11.1", nil),
+		Entry("--require of a .mjs preload", "", "server.js", "--require=./tracing.mjs", "16.20.2", []string{"Use --import ./tracing.mjs instead"}),
+		Entry("--import on node 18.18", "module", "server.js", "--import ./tracing.js", "18.18.0", nil),
+		Entry("--import on node 18.17", "module", "server.js", "--import ./tracing.js", "18.17.1", []string{"--import ./tracing.js is not supported by Node.js 18.17.1"}),
+		Entry("--import on node 19.0", "module", "server.js", "--import ./tracing.js", "19.0.0", nil),
+		Entry("--import on node 20.5", "module", "server.js", "--import ./tracing.js", "20.5.1", nil),
+		Entry("--import on node 16", "", "server.js", "--import ./tracing.mjs", "16.20.2", []string{"--import ./tracing.mjs is not supported by Node.js 16.20.2\nUse Node.js 18.18 or later, or --require with a CommonJS file"}),
+		Entry("--loader on node 20.6", "module", "server.js", "--loader ts-node/esm", "20.6.0", []string{"--loader ts-node/esm is deprecated"}),
+		Entry("unknown node version", "module", "server.js", "--import ./tracing.js", "", nil),
+	)
+
+	Describe("WarnModuleFormat", func() {
+		var (
+			err            error
+			buildDir       string
+			depsDir        string
+			finalizer      *finalize.Finalizer
+			buffer         *bytes.Buffer
+			oldNodeOptions string
+		)
+
+		writeFile := func(path, contents string) {
+			Expect(os.MkdirAll(filepath.Dir(path), 0755)).To(Succeed())
+			Expect(ioutil.WriteFile(path, []byte(contents), 0644)).To(Succeed())
+		}
+
+		BeforeEach(func() {
+			buildDir, err = ioutil.TempDir("", "nodejs-buildpack.build.")
+			Expect(err).To(BeNil())
+			depsDir, err = ioutil.TempDir("", "nodejs-buildpack.deps.")
+			Expect(err).To(BeNil())
+			Expect(os.MkdirAll(filepath.Join(depsDir, "0"), 0755)).To(Succeed())
+
+			oldNodeOptions = os.Getenv("NODE_OPTIONS")
+			os.Unsetenv("NODE_OPTIONS")
+
+			writeFile(filepath.Join(depsDir, "0", "node", "include", "node", "node_version.h"), "#define NODE_MAJOR_VERSION 16\n#define NODE_MINOR_VERSION 20\n#define NODE_PATCH_VERSION 2\n")
+
+			buffer = new(bytes.Buffer)
+			logger := libbuildpack.NewLogger(ansicleaner.New(buffer))
+			finalizer = &finalize.Finalizer{
+				Stager: libbuildpack.NewStager([]string{buildDir, "", depsDir, "0"}, logger, &libbuildpack.Manifest{}),
+				Log:    logger,
+			}
+		})
+
+		AfterEach(func() {
+			os.Setenv("NODE_OPTIONS", oldNodeOptions)
+			Expect(os.RemoveAll(buildDir)).To(Succeed())
+			Expect(os.RemoveAll(depsDir)).To(Succeed())
+		})
+
+		It("warns about require() in an ES module entrypoint", func() {
+			finalizer.PackageType = "module"
+			finalizer.StartScript = "node app.js"
+			writeFile(filepath.Join(buildDir, "app.js"), "const express = require('express')\n")
+
+			Expect(finalizer.WarnModuleFormat()).To(Succeed())
+			Expect(buffer.String()).To(ContainSubstring("app.js uses require() or module.exports but is loaded as an ES module"))
+			Expect(buffer.String()).To(ContainSubstring("Rename it to app.cjs"))
+		})
+
+		It("warns about import in a CommonJS entrypoint", func() {
+			writeFile(filepath.Join(buildDir, "server.js"), "import express from 'express'\n")
+
+			Expect(finalizer.WarnModuleFormat()).To(Succeed())
+			Expect(buffer.String()).To(ContainSubstring("server.js uses import/export but is loaded as CommonJS"))
+			Expect(buffer.String()).To(ContainSubstring("add \"type\": \"module\" to package.json"))
+		})
+
+		It("checks the Procfile command, NODE_OPTIONS and the installed node version", func() {
+			os.Setenv("NODE_OPTIONS", "--max-old-space-size=512 --import ./otel.mjs")
+			finalizer.PackageType = "module"
+			writeFile(filepath.Join(buildDir, "Procfile"), "web: node --require ./tracing.js dist/index.js\n")
+			writeFile(filepath.Join(buildDir, "dist", "index.js"), "import http from 'http'\n")
+
+			Expect(finalizer.WarnModuleFormat()).To(Succeed())
+			Expect(buffer.String()).To(ContainSubstring("--import ./otel.mjs is not supported by Node.js 16.20.2"))
+			Expect(buffer.String()).To(ContainSubstring("--require ./tracing.js loads an ES module"))
+		})
+
+		It("resolves `node .` through main", func() {
+			finalizer.PackageType = "module"
+			finalizer.StartScript = "node ."
+			finalizer.Main = "lib/main.js"
+			writeFile(filepath.Join(buildDir, "lib", "main.js"), "module.exports = {}\n")
+
+			Expect(finalizer.WarnModuleFormat()).To(Succeed())
+			Expect(buffer.String()).To(ContainSubstring("lib/main.js uses require() or module.exports"))
+		})
+
+		It("warns when `node .` would ignore exports", func() {
+			finalizer.StartScript = "node ."
+			finalizer.HasExports = true
+
+			Expect(finalizer.WarnModuleFormat()).To(Succeed())
+			Expect(buffer.String()).To(ContainSubstring("`node .` ignores exports and starts index.js"))
+		})
+
+		It("does not warn for consistent apps", func() {
+			finalizer.PackageType = "module"
+			writeFile(filepath.Join(buildDir, "server.js"), "import http from 'http'\nexport default http\n")
+
+			Expect(finalizer.WarnModuleFormat()).To(Succeed())
+			Expect(buffer.String()).To(Equal(""))
+		})
+	})
+})
@@ -1,7 +1,10 @@
 package finalize
 
 import (
+	"io"
 	"io/ioutil"
+	"nodejs/packagejson"
+	"nodejs/profiled"
 	"os"
 	"path/filepath"
 	"strings"
@@ -15,69 +18,180 @@ type Manifest interface {
 
 type Stager interface {
 	BuildDir() string
+	CacheDir() string
 	DepDir() string
 	DepsIdx() string
 }
 
+type Yarn interface {
+	FocusWorkspace(string, string, string) error
+}
+
+type Command interface {
+	Execute(string, io.Writer, io.Writer, string, ...string) error
+}
+
 type Finalizer struct {
 	Stager      Stager
 	Log         *libbuildpack.Logger
 	Logfile     *os.File
 	Manifest    Manifest
+	Yarn        Yarn
+	Command     Command
 	StartScript string
+	PackageType string
+	Main        string
+	HasExports  bool
+	// Entrypoint is the script StartEntrypoint starts an app without
+	// package.json with.
+	Entrypoint string
 }
 
 func Run(f *Finalizer) error {
+	if err := f.LoadAppConfig(); err != nil {
+		f.Log.Error("Unable to load the buildpack config: %s", err.Error())
+		return err
+	}
+
 	if err := f.ReadPackageJSON(); err != nil {
 		f.Log.Error("Failed parsing package.json: %s", err.Error())
 		return err
 	}
 
+	if err := f.CheckProcfile(); err != nil {
+		f.Log.Error(err.Error())
+		return err
+	}
+
+	if err := f.WarnLegacyNodePaths(); err != nil {
+		f.Log.Error(err.Error())
+		return err
+	}
+
+	if err := f.FocusWorkspace(); err != nil {
+		f.Log.Error("Unable to install the production dependencies of BP_NODE_WORKSPACE: %s", err.Error())
+		return err
+	}
+
+	if err := f.PruneNestedPaths(); err != nil {
+		f.Log.Error("Unable to prune the dependencies of BP_NESTED_INSTALL_PATHS: %s", err.Error())
+		return err
+	}
+
 	if err := f.CopyProfileScripts(); err != nil {
 		f.Log.Error("Unable to copy profile.d scripts: %s", err.Error())
 		return err
 	}
 
+	if err := f.SetupWritableDirs(); err != nil {
+		f.Log.Error("Unable to set up BP_RUNTIME_WRITABLE_DIRS: %s", err.Error())
+		return err
+	}
+
+	if err := f.InstallMetricsPreload(); err != nil {
+		f.Log.Error("Unable to install metrics preload: %s", err.Error())
+		return err
+	}
+
+	if err := f.InstallHealthEndpoint(); err != nil {
+		f.Log.Error("Unable to install the health endpoint: %s", err.Error())
+		return err
+	}
+
+	if err := f.ExportServiceURLs(); err != nil {
+		f.Log.Error("Unable to export the service URLs: %s", err.Error())
+		return err
+	}
+
+	if err := f.WarnProfileCollisions(); err != nil {
+		f.Log.Warning("Unable to check profile.d scripts of other buildpacks: %s", err.Error())
+	}
+
+	if err := f.StartEntrypoint(); err != nil {
+		f.Log.Error("Unable to start the app without package.json: %s", err.Error())
+		return err
+	}
+
 	if err := f.WarnNoStart(); err != nil {
 		f.Log.Error(err.Error())
 		return err
 	}
 
-	if err := f.Logfile.Sync(); err != nil {
+	if err := f.WarnModuleFormat(); err != nil {
 		f.Log.Error(err.Error())
 		return err
 	}
 
-	return nil
-}
+	if err := f.WarnNodePathResolution(); err != nil {
+		f.Log.Error(err.Error())
+		return err
+	}
 
-func (f *Finalizer) ReadPackageJSON() error {
-	var p struct {
-		Scripts struct {
-			StartScript string `json:"start"`
-		} `json:"scripts"`
+	if err := f.DirectStart(); err != nil {
+		f.Log.Error("Unable to rewrite the start command: %s", err.Error())
+		return err
 	}
 
-	if err := libbuildpack.NewJSON().Load(filepath.Join(f.Stager.BuildDir(), "package.json"), &p); err != nil {
-		if os.IsNotExist(err) {
-			f.Log.Warning("No package.json found")
-			return nil
-		} else {
-			return err
-		}
+	if err := f.ScriptProcessTypes(); err != nil {
+		f.Log.Error(err.Error())
+		return err
+	}
+
+	if err := f.ReleaseTask(); err != nil {
+		f.Log.Error("Unable to add the release task: %s", err.Error())
+		return err
+	}
+
+	if err := f.PrecompressAssets(); err != nil {
+		f.Log.Error("Unable to precompress the assets: %s", err.Error())
+		return err
+	}
+
+	if err := f.CheckNodeModulesSize(); err != nil {
+		f.Log.Error(err.Error())
+		return err
+	}
+
+	if err := f.RecordNodeModulesDigest(); err != nil {
+		f.Log.Warning("Unable to record the node_modules digest: %s", err.Error())
+	}
+
+	if err := f.ReportOutdated(); err != nil {
+		f.Log.Warning("Unable to report outdated dependencies: %s", err.Error())
 	}
 
-	f.StartScript = p.Scripts.StartScript
+	if err := f.Logfile.Sync(); err != nil {
+		f.Log.Error(err.Error())
+		return err
+	}
 
 	return nil
 }
 
-func (f *Finalizer) CopyProfileScripts() error {
-	profiledDir := filepath.Join(f.Stager.DepDir(), "profile.d")
-	if err := os.MkdirAll(profiledDir, 0755); err != nil {
+// packageJSON returns the package.json which supply parsed, before the
+// build scripts ran.
+func (f *Finalizer) packageJSON() (packagejson.Package, bool, error) {
+	return packagejson.LoadStaged(f.Stager.DepDir(), f.Stager.BuildDir())
+}
+
+func (f *Finalizer) ReadPackageJSON() error {
+	p, found, err := f.packageJSON()
+	if err != nil {
 		return err
+	} else if !found {
+		f.Log.Warning("No package.json found")
+		return nil
 	}
 
+	f.StartScript = p.Scripts["start"]
+	f.PackageType = p.Type
+	f.Main = p.Main
+	f.HasExports = p.HasExports()
+
+	return nil
+}
+
+func (f *Finalizer) CopyProfileScripts() error {
 	scriptsDir := filepath.Join(f.Stager.DepDir(), "scripts")
 	if err := os.MkdirAll(scriptsDir, 0755); err != nil {
 		return err
@@ -94,11 +208,15 @@ func (f *Finalizer) CopyProfileScripts() error {
 			if err := libbuildpack.CopyFile(filepath.Join(path, fi.Name()), filepath.Join(scriptsDir, fi.Name())); err != nil {
 				return err
 			}
-			if err := ioutil.WriteFile(filepath.Join(profiledDir, fi.Name()+".sh"), []byte("eval $(ruby $DEPS_DIR/"+f.Stager.DepsIdx()+"/scripts/"+fi.Name()+")\n"), 0755); err != nil {
+			if err := profiled.Write(f.Stager, fi.Name()+".sh", "eval $(ruby $DEPS_DIR/"+f.Stager.DepsIdx()+"/scripts/"+fi.Name()+")\n"); err != nil {
 				return err
 			}
 		} else {
-			if err := libbuildpack.CopyFile(filepath.Join(path, fi.Name()), filepath.Join(profiledDir, fi.Name())); err != nil {
+			contents, err := ioutil.ReadFile(filepath.Join(path, fi.Name()))
+			if err != nil {
+				return err
+			}
+			if err := profiled.Write(f.Stager, fi.Name(), string(contents)); err != nil {
 				return err
 			}
 		}
@@ -116,7 +234,18 @@ func (f *Finalizer) WarnNoStart() error {
 		return err
 	}
 
-	if !procfileExists && !serverJsExists && f.StartScript == "" {
+	if !procfileExists && f.StartScript != "" {
+		binary, err := f.prunedStartBinary(f.StartScript)
+		if err != nil {
+			return err
+		}
+		if binary != "" {
+			f.Log.Warning("This app may not specify any way to start a node process\nscripts.start runs %s, which comes from devDependencies and is not installed after pruning, so npm start would fail\nMove %s to dependencies, or start the app with a Procfile", binary, binary)
+			return nil
+		}
+	}
+
+	if !procfileExists && !serverJsExists && f.StartScript == "" && f.Entrypoint == "" {
 		warning := "This app may not specify any way to start a node process\n"
 		warning += "See: https://docs.cloudfoundry.org/buildpacks/node/node-tips.html#start"
 		f.Log.Warning(warning)
@@ -4,8 +4,10 @@ import (
 	"bytes"
 	"io/ioutil"
 	"nodejs/finalize"
+	"nodejs/packagejson"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/cloudfoundry/libbuildpack"
 	"github.com/cloudfoundry/libbuildpack/ansicleaner"
@@ -85,6 +87,20 @@ var _ = Describe("Finalize", func() {
 				Expect(finalizer.ReadPackageJSON()).To(Succeed())
 				Expect(finalizer.StartScript).To(Equal("start-my-app"))
 			})
+
+			It("reads the package.json supply parsed over one the build changed", func() {
+				Expect(os.MkdirAll(filepath.Join(depsDir, depsIdx), 0755)).To(Succeed())
+				Expect(packagejson.Save(filepath.Join(depsDir, depsIdx), packagejson.Package{
+					Type:    "module",
+					Main:    "server.js",
+					Scripts: map[string]string{"start": "node server.js"},
+				})).To(Succeed())
+
+				Expect(finalizer.ReadPackageJSON()).To(Succeed())
+				Expect(finalizer.StartScript).To(Equal("node server.js"))
+				Expect(finalizer.PackageType).To(Equal("module"))
+				Expect(finalizer.Main).To(Equal("server.js"))
+			})
 		})
 	})
 
@@ -107,8 +123,8 @@ var _ = Describe("Finalize", func() {
 
 		It("Copies scripts from <buildpack_dir>/profile to <dep_dir>/profile.d", func() {
 			Expect(finalizer.CopyProfileScripts()).To(Succeed())
-			Expect(ioutil.ReadFile(filepath.Join(depsDir, depsIdx, "profile.d", "test.sh"))).To(Equal([]byte("Random Text")))
-			Expect(ioutil.ReadFile(filepath.Join(depsDir, depsIdx, "profile.d", "other.sh"))).To(Equal([]byte("more Text")))
+			Expect(ioutil.ReadFile(filepath.Join(depsDir, depsIdx, "profile.d", "009_nodejs_buildpack_test.sh"))).To(Equal([]byte("Random Text")))
+			Expect(ioutil.ReadFile(filepath.Join(depsDir, depsIdx, "profile.d", "009_nodejs_buildpack_other.sh"))).To(Equal([]byte("more Text")))
 		})
 
 		It("Copies ruby scripts from <buildpack_dir>/profile to <dep_dir>/scripts", func() {
@@ -120,7 +136,7 @@ var _ = Describe("Finalize", func() {
 		It("Creates a profile.d file to source the ruby script", func() {
 			Expect(finalizer.CopyProfileScripts()).To(Succeed())
 			expected := "eval $(ruby $DEPS_DIR/9/scripts/test.rb)\n"
-			actual, err := ioutil.ReadFile(filepath.Join(depsDir, depsIdx, "profile.d", "test.rb.sh"))
+			actual, err := ioutil.ReadFile(filepath.Join(depsDir, depsIdx, "profile.d", "009_nodejs_buildpack_test.rb.sh"))
 			Expect(err).ToNot(HaveOccurred())
 			Expect(string(actual)).To(Equal(expected))
 		})
@@ -160,6 +176,32 @@ var _ = Describe("Finalize", func() {
 			})
 		})
 
+		Context("scripts.start runs a devDependency which was pruned", func() {
+			BeforeEach(func() {
+				finalizer.StartScript = "PORT=8080 http-server ./public"
+				Expect(ioutil.WriteFile(filepath.Join(buildDir, "package.json"), []byte(`{"devDependencies":{"http-server":"^14.1.1"}}`), 0644)).To(Succeed())
+			})
+
+			It("logs a warning naming the binary", func() {
+				Expect(finalizer.WarnNoStart()).To(Succeed())
+				Expect(buffer.String()).To(ContainSubstring("**WARNING** This app may not specify any way to start a node process\n"))
+				Expect(buffer.String()).To(ContainSubstring("scripts.start runs http-server, which comes from devDependencies and is not installed after pruning, so npm start would fail"))
+			})
+
+			It("doesn't log a warning while the binary is installed", func() {
+				Expect(os.MkdirAll(filepath.Join(buildDir, "node_modules", ".bin"), 0755)).To(Succeed())
+				Expect(ioutil.WriteFile(filepath.Join(buildDir, "node_modules", ".bin", "http-server"), nil, 0755)).To(Succeed())
+				Expect(finalizer.WarnNoStart()).To(Succeed())
+				Expect(buffer.String()).To(Equal(""))
+			})
+
+			It("doesn't log a warning with a Procfile", func() {
+				Expect(ioutil.WriteFile(filepath.Join(buildDir, "Procfile"), []byte("web: node server.js"), 0644)).To(Succeed())
+				Expect(finalizer.WarnNoStart()).To(Succeed())
+				Expect(buffer.String()).To(Equal(""))
+			})
+		})
+
 		Context("none of the above exists", func() {
 			It("logs a warning", func() {
 				Expect(finalizer.WarnNoStart()).To(Succeed())
@@ -168,4 +210,57 @@ var _ = Describe("Finalize", func() {
 			})
 		})
 	})
+
+	Describe("Run with only devDependencies", func() {
+		var (
+			cacheDir     string
+			buildpackDir string
+			logfile      *os.File
+		)
+
+		BeforeEach(func() {
+			cacheDir, err = ioutil.TempDir("", "nodejs-buildpack.cache.")
+			Expect(err).To(BeNil())
+			buildpackDir, err = ioutil.TempDir("", "nodejs-buildpack.buildpack.")
+			Expect(err).To(BeNil())
+			Expect(os.MkdirAll(filepath.Join(buildpackDir, "profile"), 0755)).To(Succeed())
+			mockManifest.EXPECT().RootDir().Return(buildpackDir).AnyTimes()
+			logfile, err = ioutil.TempFile("", "nodejs-buildpack.logfile")
+			Expect(err).To(BeNil())
+
+			Expect(libbuildpack.CopyDirectory(filepath.Join("testdata", "dev_only_app"), buildDir)).To(Succeed())
+
+			finalizer.Stager = libbuildpack.NewStager([]string{buildDir, cacheDir, depsDir, depsIdx}, logger, &libbuildpack.Manifest{})
+			finalizer.Logfile = logfile
+		})
+
+		AfterEach(func() {
+			logfile.Close()
+			Expect(os.Remove(logfile.Name())).To(Succeed())
+			Expect(os.RemoveAll(cacheDir)).To(Succeed())
+			Expect(os.RemoveAll(buildpackDir)).To(Succeed())
+		})
+
+		runs := func() {
+			Expect(finalize.Run(finalizer)).To(Succeed())
+			Expect(buffer.String()).To(ContainSubstring("node_modules is empty, the app has no production dependencies"))
+			Expect(buffer.String()).To(ContainSubstring("**WARNING** This app may not specify any way to start a node process\n" +
+				"       scripts.start runs serve, which comes from devDependencies and is not installed after pruning, so npm start would fail\n" +
+				"       Move serve to dependencies, or start the app with a Procfile"))
+			Expect(buffer.String()).NotTo(ContainSubstring("does not forward SIGTERM"))
+			Expect(strings.Count(buffer.String(), "WARNING")).To(Equal(1))
+			Expect(filepath.Join(buildDir, ".cloudfoundry", "node-start")).NotTo(BeAnExistingFile())
+		}
+
+		It("finalizes an app pruned down to an empty node_modules", func() {
+			Expect(os.MkdirAll(filepath.Join(buildDir, "node_modules", ".bin"), 0755)).To(Succeed())
+			Expect(os.Symlink("../serve/build/main.js", filepath.Join(buildDir, "node_modules", ".bin", "serve"))).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(buildDir, "node_modules", ".package-lock.json"), []byte(`{"lockfileVersion":3}`), 0644)).To(Succeed())
+			runs()
+		})
+
+		It("finalizes an app without node_modules", func() {
+			runs()
+		})
+	})
 })
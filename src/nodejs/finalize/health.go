@@ -0,0 +1,89 @@
+package finalize
+
+import (
+	"fmt"
+	"io/ioutil"
+	"nodejs/profiled"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// healthPreload is required into the app process through NODE_OPTIONS and
+// answers 200 on $BP_INJECT_HEALTH_ENDPOINT at 127.0.0.1:$HEALTH_PORT, apart
+// from the server of the app. It only uses the http module of node and must
+// never take the app down, so every failure is logged and ignored.
+const healthPreload = `'use strict';
+// Installed by the Cloud Foundry Node.js buildpack (BP_INJECT_HEALTH_ENDPOINT)
+(function () {
+  try {
+    var path = process.env.BP_INJECT_HEALTH_ENDPOINT || '/healthz';
+    var port = parseInt(process.env.HEALTH_PORT || '8091', 10);
+
+    var server = require('http').createServer(function (req, res) {
+      var url = (req.url || '').split('?')[0];
+      if (url !== path || (req.method !== 'GET' && req.method !== 'HEAD')) {
+        res.statusCode = 404;
+        res.end();
+        return;
+      }
+      res.setHeader('Content-Type', 'text/plain');
+      res.setHeader('Cache-Control', 'no-store');
+      res.end(req.method === 'HEAD' ? undefined : 'ok\n');
+    });
+    server.on('error', function (err) {
+      // Every node process the app spawns loads this file too, and only the
+      // first one gets the port.
+      if (err.code === 'EADDRINUSE') {
+        return;
+      }
+      console.warn('[health] unable to listen on 127.0.0.1:' + port + ' (' + err.message + '), ' + path + ' endpoint disabled');
+    });
+    server.listen(port, '127.0.0.1');
+    server.unref();
+
+    // A listener for SIGTERM turns off the default exit of node, so the
+    // signal is raised again once the server is closed, unless the app
+    // handles it itself.
+    var onSIGTERM = function () {
+      server.close();
+      if (process.listenerCount('SIGTERM') === 0) {
+        process.kill(process.pid, 'SIGTERM');
+      }
+    };
+    process.once('SIGTERM', onSIGTERM);
+  } catch (err) {
+    console.warn('[health] unable to start the health endpoint: ' + err.message);
+  }
+})();
+`
+
+// InstallHealthEndpoint wires up the health endpoint preload when
+// BP_INJECT_HEALTH_ENDPOINT is set, for apps which lack the endpoint the
+// platform checks. HEALTH_PORT is exported for the health check of the
+// sidecar.
+func (f *Finalizer) InstallHealthEndpoint() error {
+	path := strings.TrimSpace(os.Getenv("BP_INJECT_HEALTH_ENDPOINT"))
+	if path == "" {
+		return nil
+	}
+	if !strings.HasPrefix(path, "/") || strings.ContainsAny(path, "?# ") {
+		return fmt.Errorf("BP_INJECT_HEALTH_ENDPOINT is %q, it must be a URL path like /healthz", path)
+	}
+
+	healthDir := filepath.Join(f.Stager.DepDir(), "health")
+	if err := os.MkdirAll(healthDir, 0755); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(healthDir, "preload.js"), []byte(healthPreload), 0644); err != nil {
+		return err
+	}
+
+	preload := filepath.Join("$DEPS_DIR", f.Stager.DepsIdx(), "health", "preload.js")
+	f.Log.Info("Serving %s on 127.0.0.1:${HEALTH_PORT:-8091}", path)
+	return profiled.Write(f.Stager, "health_endpoint.sh", strings.Join([]string{
+		"export BP_INJECT_HEALTH_ENDPOINT=" + shellQuote(path),
+		`export HEALTH_PORT="${HEALTH_PORT:-8091}"`,
+		`export NODE_OPTIONS="${NODE_OPTIONS:+$NODE_OPTIONS }--require ` + preload + `"`,
+	}, "\n")+"\n")
+}
@@ -0,0 +1,166 @@
+package finalize_test
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"nodejs/finalize"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/cloudfoundry/libbuildpack/ansicleaner"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+	"github.com/onsi/gomega/gexec"
+)
+
+var _ = Describe("InstallHealthEndpoint", func() {
+	var (
+		err       error
+		buildDir  string
+		depsDir   string
+		depsIdx   string
+		finalizer *finalize.Finalizer
+		buffer    *bytes.Buffer
+		oldHealth string
+	)
+
+	BeforeEach(func() {
+		buildDir, err = ioutil.TempDir("", "nodejs-buildpack.build.")
+		Expect(err).To(BeNil())
+		depsDir, err = ioutil.TempDir("", "nodejs-buildpack.deps.")
+		Expect(err).To(BeNil())
+		depsIdx = "3"
+		Expect(os.MkdirAll(filepath.Join(depsDir, depsIdx), 0755)).To(Succeed())
+
+		oldHealth = os.Getenv("BP_INJECT_HEALTH_ENDPOINT")
+		os.Setenv("BP_INJECT_HEALTH_ENDPOINT", "/healthz")
+
+		buffer = new(bytes.Buffer)
+		logger := libbuildpack.NewLogger(ansicleaner.New(buffer))
+		finalizer = &finalize.Finalizer{
+			Stager: libbuildpack.NewStager([]string{buildDir, "", depsDir, depsIdx}, logger, &libbuildpack.Manifest{}),
+			Log:    logger,
+		}
+	})
+
+	AfterEach(func() {
+		os.Setenv("BP_INJECT_HEALTH_ENDPOINT", oldHealth)
+		Expect(os.RemoveAll(buildDir)).To(Succeed())
+		Expect(os.RemoveAll(depsDir)).To(Succeed())
+	})
+
+	It("exports the path, HEALTH_PORT and a --require of the preload via profile.d", func() {
+		Expect(finalizer.InstallHealthEndpoint()).To(Succeed())
+
+		Expect(filepath.Join(depsDir, depsIdx, "health", "preload.js")).To(BeAnExistingFile())
+		contents, err := ioutil.ReadFile(filepath.Join(depsDir, depsIdx, "profile.d", "003_nodejs_buildpack_health_endpoint.sh"))
+		Expect(err).To(BeNil())
+		Expect(string(contents)).To(Equal(`export BP_INJECT_HEALTH_ENDPOINT='/healthz'
+export HEALTH_PORT="${HEALTH_PORT:-8091}"
+export NODE_OPTIONS="${NODE_OPTIONS:+$NODE_OPTIONS }--require $DEPS_DIR/3/health/preload.js"
+`))
+		Expect(buffer.String()).To(ContainSubstring("Serving /healthz on 127.0.0.1:${HEALTH_PORT:-8091}"))
+	})
+
+	It("refuses a value which is not a URL path", func() {
+		os.Setenv("BP_INJECT_HEALTH_ENDPOINT", "healthz")
+		Expect(finalizer.InstallHealthEndpoint()).To(MatchError(`BP_INJECT_HEALTH_ENDPOINT is "healthz", it must be a URL path like /healthz`))
+		Expect(filepath.Join(depsDir, depsIdx, "profile.d", "003_nodejs_buildpack_health_endpoint.sh")).NotTo(BeAnExistingFile())
+	})
+
+	It("does nothing when BP_INJECT_HEALTH_ENDPOINT is not set", func() {
+		os.Unsetenv("BP_INJECT_HEALTH_ENDPOINT")
+		Expect(finalizer.InstallHealthEndpoint()).To(Succeed())
+		Expect(buffer.String()).To(Equal(""))
+		Expect(filepath.Join(depsDir, depsIdx, "health")).NotTo(BeAnExistingFile())
+	})
+
+	Context("in a node process", func() {
+		var (
+			port    int
+			preload string
+		)
+
+		BeforeEach(func() {
+			if _, err := exec.LookPath("node"); err != nil {
+				Skip("node is not installed")
+			}
+			Expect(finalizer.InstallHealthEndpoint()).To(Succeed())
+			preload = filepath.Join(depsDir, depsIdx, "health", "preload.js")
+
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			Expect(err).To(BeNil())
+			port = listener.Addr().(*net.TCPAddr).Port
+			Expect(listener.Close()).To(Succeed())
+		})
+
+		start := func(app string) *gexec.Session {
+			cmd := exec.Command("node", "-e", app)
+			cmd.Env = append(os.Environ(), "NODE_OPTIONS=--require "+preload, "HEALTH_PORT="+strconv.Itoa(port))
+			session, err := gexec.Start(cmd, GinkgoWriter, GinkgoWriter)
+			Expect(err).To(BeNil())
+			return session
+		}
+
+		status := func(path string) func() (int, error) {
+			return func() (int, error) {
+				resp, err := http.Get(fmt.Sprintf("http://127.0.0.1:%d%s", port, path))
+				if err != nil {
+					return 0, err
+				}
+				resp.Body.Close()
+				return resp.StatusCode, nil
+			}
+		}
+
+		It("answers 200 on the path and 404 elsewhere, apart from the server of the app", func() {
+			session := start(`require('http').createServer((req, res) => res.end()).listen(0)`)
+			defer session.Kill()
+
+			Eventually(status("/healthz"), 5*time.Second).Should(Equal(http.StatusOK))
+			Expect(status("/healthz?probe=1")()).To(Equal(http.StatusOK))
+			Expect(status("/")()).To(Equal(http.StatusNotFound))
+		})
+
+		It("does not keep an app alive which is done", func() {
+			Eventually(start(`console.log('done')`), 5*time.Second).Should(gexec.Exit(0))
+		})
+
+		It("leaves the app running when the port is taken", func() {
+			listener, err := net.Listen("tcp", "127.0.0.1:"+strconv.Itoa(port))
+			Expect(err).To(BeNil())
+			defer listener.Close()
+
+			session := start(`setTimeout(() => console.log('still running'), 500)`)
+			Eventually(session, 5*time.Second).Should(gexec.Exit(0))
+			Expect(session.Out).To(gbytes.Say("still running"))
+		})
+
+		It("closes the server and exits on SIGTERM when the app does not handle it", func() {
+			session := start(`setInterval(() => {}, 1000)`)
+			Eventually(status("/healthz"), 5*time.Second).Should(Equal(http.StatusOK))
+
+			session.Signal(syscall.SIGTERM)
+			Eventually(session, 5*time.Second).Should(gexec.Exit())
+			Expect(session.ExitCode()).NotTo(Equal(0))
+		})
+
+		It("leaves SIGTERM to the app when it handles it", func() {
+			session := start(`process.on('SIGTERM', () => { console.log('draining'); setTimeout(() => process.exit(0), 100) }); setInterval(() => {}, 1000)`)
+			Eventually(status("/healthz"), 5*time.Second).Should(Equal(http.StatusOK))
+
+			session.Signal(syscall.SIGTERM)
+			Eventually(session, 5*time.Second).Should(gexec.Exit(0))
+			Expect(session.Out).To(gbytes.Say("draining"))
+		})
+	})
+})
@@ -0,0 +1,48 @@
+package finalize
+
+import (
+	"nodejs/supply"
+	"os"
+	"sort"
+	"strings"
+)
+
+// WarnLegacyNodePaths warns about the processes of the Procfile and the
+// scripts of package.json which run node from a hardcoded path of an older
+// layout, which breaks when the layout of the dep dir changes again.
+func (f *Finalizer) WarnLegacyNodePaths() error {
+	var warnings []string
+
+	procfile, err := f.readProcfile()
+	if err != nil {
+		return err
+	}
+	if procfile != nil {
+		for _, process := range procfile.Processes {
+			warnings = append(warnings, supply.ScanLegacyNodePaths("The "+process.Name+" process of the Procfile", process.Command)...)
+		}
+	}
+
+	pkg, _, err := f.packageJSON()
+	if err != nil {
+		return err
+	}
+	var names []string
+	for name := range pkg.Scripts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		warnings = append(warnings, supply.ScanLegacyNodePaths("The "+name+" script of package.json", pkg.Scripts[name])...)
+	}
+
+	if len(warnings) == 0 {
+		return nil
+	}
+	message := strings.Join(warnings, "\n")
+	if os.Getenv("BP_LEGACY_NODE_PATHS") != "true" {
+		message += "\nUntil then, BP_LEGACY_NODE_PATHS=true links .heroku/node, .heroku/yarn and vendor/node to the installed node and yarn"
+	}
+	f.Log.Warning("%s", message)
+	return nil
+}
@@ -0,0 +1,76 @@
+package finalize_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"nodejs/finalize"
+	"os"
+	"path/filepath"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/cloudfoundry/libbuildpack/ansicleaner"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("WarnLegacyNodePaths", func() {
+	var (
+		err       error
+		buildDir  string
+		finalizer *finalize.Finalizer
+		buffer    *bytes.Buffer
+		oldLegacy string
+	)
+
+	writeFile := func(name, contents string) {
+		Expect(ioutil.WriteFile(filepath.Join(buildDir, name), []byte(contents), 0644)).To(Succeed())
+	}
+
+	BeforeEach(func() {
+		oldLegacy = os.Getenv("BP_LEGACY_NODE_PATHS")
+		os.Unsetenv("BP_LEGACY_NODE_PATHS")
+
+		buildDir, err = ioutil.TempDir("", "nodejs-buildpack.build.")
+		Expect(err).To(BeNil())
+
+		buffer = new(bytes.Buffer)
+		logger := libbuildpack.NewLogger(ansicleaner.New(buffer))
+		finalizer = &finalize.Finalizer{
+			Stager: libbuildpack.NewStager([]string{buildDir, "", "", "0"}, logger, &libbuildpack.Manifest{}),
+			Log:    logger,
+		}
+	})
+
+	AfterEach(func() {
+		os.Setenv("BP_LEGACY_NODE_PATHS", oldLegacy)
+		Expect(os.RemoveAll(buildDir)).To(Succeed())
+	})
+
+	It("says nothing about an app without hardcoded paths", func() {
+		writeFile("Procfile", "web: node server.js\n")
+		writeFile("package.json", `{"scripts": {"start": "$NODE_HOME/bin/node server.js"}}`)
+
+		Expect(finalizer.WarnLegacyNodePaths()).To(Succeed())
+		Expect(buffer.String()).To(Equal(""))
+	})
+
+	It("warns about the hardcoded paths of the Procfile and the scripts", func() {
+		writeFile("Procfile", "web: .heroku/node/bin/node server.js\n")
+		writeFile("package.json", `{"scripts": {"worker": "vendor/node/bin/node worker.js", "build": "webpack"}}`)
+
+		Expect(finalizer.WarnLegacyNodePaths()).To(Succeed())
+		Expect(buffer.String()).To(ContainSubstring("The web process of the Procfile hardcodes .heroku/node"))
+		Expect(buffer.String()).To(ContainSubstring("The worker script of package.json hardcodes vendor/node"))
+		Expect(buffer.String()).NotTo(ContainSubstring("build script"))
+		Expect(buffer.String()).To(ContainSubstring("Until then, BP_LEGACY_NODE_PATHS=true links .heroku/node, .heroku/yarn and vendor/node"))
+	})
+
+	It("leaves out the hint with BP_LEGACY_NODE_PATHS=true", func() {
+		os.Setenv("BP_LEGACY_NODE_PATHS", "true")
+		writeFile("Procfile", "web: .heroku/node/bin/node server.js\n")
+
+		Expect(finalizer.WarnLegacyNodePaths()).To(Succeed())
+		Expect(buffer.String()).To(ContainSubstring("hardcodes .heroku/node"))
+		Expect(buffer.String()).NotTo(ContainSubstring("Until then"))
+	})
+})
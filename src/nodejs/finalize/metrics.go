@@ -0,0 +1,96 @@
+package finalize
+
+import (
+	"io/ioutil"
+	"nodejs/profiled"
+	"os"
+	"path/filepath"
+
+	"github.com/cloudfoundry/libbuildpack"
+)
+
+// metricsPreload is required into the app process through NODE_OPTIONS and
+// serves prom-client's default process metrics on 127.0.0.1:$METRICS_PORT.
+// It must never take the app down, so every failure is logged and ignored.
+const metricsPreload = `'use strict';
+// Installed by the Cloud Foundry Node.js buildpack (BP_NODE_METRICS=true)
+(function () {
+  var client;
+  try {
+    client = require('prom-client');
+  } catch (e) {
+    try {
+      client = require(require('path').join(process.cwd(), 'node_modules', 'prom-client'));
+    } catch (e2) {
+      console.warn('[metrics] prom-client not found, /metrics endpoint disabled');
+      return;
+    }
+  }
+
+  try {
+    var register = client.register;
+    client.collectDefaultMetrics({ register: register });
+
+    var port = parseInt(process.env.METRICS_PORT || '9100', 10);
+    var server = require('http').createServer(function (req, res) {
+      if (req.url !== '/metrics') {
+        res.statusCode = 404;
+        res.end();
+        return;
+      }
+      Promise.resolve(register.metrics()).then(function (body) {
+        res.setHeader('Content-Type', register.contentType);
+        res.end(body);
+      }, function (err) {
+        res.statusCode = 500;
+        res.end(String(err));
+      });
+    });
+    server.on('error', function (err) {
+      console.warn('[metrics] unable to listen on 127.0.0.1:' + port + ' (' + err.message + '), /metrics endpoint disabled');
+    });
+    server.listen(port, '127.0.0.1');
+    server.unref();
+  } catch (err) {
+    console.warn('[metrics] unable to start /metrics endpoint: ' + err.message);
+  }
+})();
+`
+
+// InstallMetricsPreload wires up the /metrics preload when BP_NODE_METRICS
+// is set and the app has prom-client installed.
+func (f *Finalizer) InstallMetricsPreload() error {
+	if os.Getenv("BP_NODE_METRICS") != "true" {
+		return nil
+	}
+
+	found, err := f.hasNodeModule("prom-client")
+	if err != nil {
+		return err
+	}
+	if !found {
+		f.Log.Warning("BP_NODE_METRICS is set but prom-client is not installed, the /metrics endpoint is disabled\nAdd prom-client to the dependencies in package.json to enable it")
+		return nil
+	}
+
+	metricsDir := filepath.Join(f.Stager.DepDir(), "metrics")
+	if err := os.MkdirAll(metricsDir, 0755); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(metricsDir, "preload.js"), []byte(metricsPreload), 0644); err != nil {
+		return err
+	}
+
+	preload := filepath.Join("$DEPS_DIR", f.Stager.DepsIdx(), "metrics", "preload.js")
+	f.Log.Info("Exposing process metrics on 127.0.0.1:${METRICS_PORT:-9100}/metrics")
+	return profiled.Write(f.Stager, "node_metrics.sh", `export NODE_OPTIONS="${NODE_OPTIONS:+$NODE_OPTIONS }--require `+preload+`"`+"\n")
+}
+
+func (f *Finalizer) hasNodeModule(name string) (bool, error) {
+	for _, dir := range []string{f.Stager.BuildDir(), f.Stager.DepDir()} {
+		if found, err := libbuildpack.FileExists(filepath.Join(dir, "node_modules", name, "package.json")); err != nil || found {
+			return found, err
+		}
+	}
+	return false, nil
+}
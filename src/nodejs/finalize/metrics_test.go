@@ -0,0 +1,114 @@
+package finalize_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"nodejs/finalize"
+	"os"
+	"path/filepath"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/cloudfoundry/libbuildpack/ansicleaner"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("InstallMetricsPreload", func() {
+	var (
+		err            error
+		buildDir       string
+		depsDir        string
+		depsIdx        string
+		finalizer      *finalize.Finalizer
+		buffer         *bytes.Buffer
+		oldNodeMetrics string
+	)
+
+	BeforeEach(func() {
+		buildDir, err = ioutil.TempDir("", "nodejs-buildpack.build.")
+		Expect(err).To(BeNil())
+		depsDir, err = ioutil.TempDir("", "nodejs-buildpack.deps.")
+		Expect(err).To(BeNil())
+		depsIdx = "3"
+		Expect(os.MkdirAll(filepath.Join(depsDir, depsIdx), 0755)).To(Succeed())
+
+		oldNodeMetrics = os.Getenv("BP_NODE_METRICS")
+		os.Setenv("BP_NODE_METRICS", "true")
+
+		buffer = new(bytes.Buffer)
+		logger := libbuildpack.NewLogger(ansicleaner.New(buffer))
+		finalizer = &finalize.Finalizer{
+			Stager: libbuildpack.NewStager([]string{buildDir, "", depsDir, depsIdx}, logger, &libbuildpack.Manifest{}),
+			Log:    logger,
+		}
+	})
+
+	AfterEach(func() {
+		os.Setenv("BP_NODE_METRICS", oldNodeMetrics)
+		Expect(os.RemoveAll(buildDir)).To(Succeed())
+		Expect(os.RemoveAll(depsDir)).To(Succeed())
+	})
+
+	installPromClient := func(dir string) {
+		Expect(os.MkdirAll(filepath.Join(dir, "node_modules", "prom-client"), 0755)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(dir, "node_modules", "prom-client", "package.json"), []byte(`{"name":"prom-client"}`), 0644)).To(Succeed())
+	}
+
+	Context("prom-client is installed in the dep dir", func() {
+		BeforeEach(func() {
+			installPromClient(filepath.Join(depsDir, depsIdx))
+		})
+
+		It("writes the preload script into the dep dir", func() {
+			Expect(finalizer.InstallMetricsPreload()).To(Succeed())
+
+			contents, err := ioutil.ReadFile(filepath.Join(depsDir, depsIdx, "metrics", "preload.js"))
+			Expect(err).To(BeNil())
+			Expect(string(contents)).To(ContainSubstring("require('prom-client')"))
+			Expect(string(contents)).To(ContainSubstring("process.env.METRICS_PORT || '9100'"))
+			Expect(string(contents)).To(ContainSubstring("server.on('error'"))
+		})
+
+		It("appends a --require to NODE_OPTIONS via profile.d", func() {
+			Expect(finalizer.InstallMetricsPreload()).To(Succeed())
+
+			contents, err := ioutil.ReadFile(filepath.Join(depsDir, depsIdx, "profile.d", "003_nodejs_buildpack_node_metrics.sh"))
+			Expect(err).To(BeNil())
+			Expect(string(contents)).To(Equal(`export NODE_OPTIONS="${NODE_OPTIONS:+$NODE_OPTIONS }--require $DEPS_DIR/3/metrics/preload.js"` + "\n"))
+		})
+	})
+
+	Context("prom-client is vendored in the app dir", func() {
+		BeforeEach(func() {
+			installPromClient(buildDir)
+		})
+
+		It("installs the preload", func() {
+			Expect(finalizer.InstallMetricsPreload()).To(Succeed())
+			Expect(filepath.Join(depsDir, depsIdx, "profile.d", "003_nodejs_buildpack_node_metrics.sh")).To(BeAnExistingFile())
+		})
+	})
+
+	Context("prom-client is not installed", func() {
+		It("warns and disables the feature", func() {
+			Expect(finalizer.InstallMetricsPreload()).To(Succeed())
+
+			Expect(buffer.String()).To(ContainSubstring("prom-client is not installed"))
+			Expect(filepath.Join(depsDir, depsIdx, "metrics", "preload.js")).NotTo(BeAnExistingFile())
+			Expect(filepath.Join(depsDir, depsIdx, "profile.d", "003_nodejs_buildpack_node_metrics.sh")).NotTo(BeAnExistingFile())
+		})
+	})
+
+	Context("BP_NODE_METRICS is not set", func() {
+		BeforeEach(func() {
+			os.Unsetenv("BP_NODE_METRICS")
+			installPromClient(buildDir)
+		})
+
+		It("does nothing", func() {
+			Expect(finalizer.InstallMetricsPreload()).To(Succeed())
+			Expect(buffer.String()).To(Equal(""))
+			Expect(filepath.Join(depsDir, depsIdx, "profile.d", "003_nodejs_buildpack_node_metrics.sh")).NotTo(BeAnExistingFile())
+		})
+	})
+})
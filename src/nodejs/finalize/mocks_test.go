@@ -79,6 +79,18 @@ func (mr *MockStagerMockRecorder) BuildDir() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "BuildDir", reflect.TypeOf((*MockStager)(nil).BuildDir))
 }
 
+// CacheDir mocks base method
+func (m *MockStager) CacheDir() string {
+	ret := m.ctrl.Call(m, "CacheDir")
+	ret0, _ := ret[0].(string)
+	return ret0
+}
+
+// CacheDir indicates an expected call of CacheDir
+func (mr *MockStagerMockRecorder) CacheDir() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CacheDir", reflect.TypeOf((*MockStager)(nil).CacheDir))
+}
+
 // DepDir mocks base method
 func (m *MockStager) DepDir() string {
 	ret := m.ctrl.Call(m, "DepDir")
@@ -102,3 +114,38 @@ func (m *MockStager) DepsIdx() string {
 func (mr *MockStagerMockRecorder) DepsIdx() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DepsIdx", reflect.TypeOf((*MockStager)(nil).DepsIdx))
 }
+
+// MockYarn is a mock of Yarn interface
+type MockYarn struct {
+	ctrl     *gomock.Controller
+	recorder *MockYarnMockRecorder
+}
+
+// MockYarnMockRecorder is the mock recorder for MockYarn
+type MockYarnMockRecorder struct {
+	mock *MockYarn
+}
+
+// NewMockYarn creates a new mock instance
+func NewMockYarn(ctrl *gomock.Controller) *MockYarn {
+	mock := &MockYarn{ctrl: ctrl}
+	mock.recorder = &MockYarnMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use
+func (m *MockYarn) EXPECT() *MockYarnMockRecorder {
+	return m.recorder
+}
+
+// FocusWorkspace mocks base method
+func (m *MockYarn) FocusWorkspace(arg0, arg1, arg2 string) error {
+	ret := m.ctrl.Call(m, "FocusWorkspace", arg0, arg1, arg2)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// FocusWorkspace indicates an expected call of FocusWorkspace
+func (mr *MockYarnMockRecorder) FocusWorkspace(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FocusWorkspace", reflect.TypeOf((*MockYarn)(nil).FocusWorkspace), arg0, arg1, arg2)
+}
@@ -0,0 +1,83 @@
+package finalize
+
+import (
+	"bytes"
+	"fmt"
+	"nodejs/failure"
+	"nodejs/prune"
+	"nodejs/supply"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cloudfoundry/libbuildpack"
+)
+
+// PruneNestedPaths prunes the node_modules of every path of
+// BP_NESTED_INSTALL_PATHS on its own, as supply installed them, omitting
+// what BP_PRUNE_OMIT lists or else the devDependencies. Pruning is skipped
+// when the app doesn't run in production, like it is for the app install.
+func (f *Finalizer) PruneNestedPaths() error {
+	paths, err := supply.ParseNestedInstallPaths(os.Getenv("BP_NESTED_INSTALL_PATHS"))
+	if err != nil || len(paths) == 0 {
+		return err
+	}
+	if reason := supply.ResolveNodeEnv(os.Environ()).PruneSkipReason(os.Environ()); reason != "" {
+		f.Log.Info("Skipping pruning of the nested paths: %s", reason)
+		return nil
+	}
+
+	value := os.Getenv("BP_PRUNE_OMIT")
+	if value == "" {
+		value = prune.Dev
+	}
+	omit, err := prune.ParseOmit(value)
+	if err != nil {
+		return err
+	}
+
+	for _, path := range paths {
+		if err := f.pruneNestedPath(path, omit); err != nil {
+			return failure.Wrap(failure.PruneFailed, fmt.Errorf("pruning %s failed: %s", path, err))
+		}
+	}
+	return nil
+}
+
+func (f *Finalizer) pruneNestedPath(path string, omit []string) error {
+	dir := filepath.Join(f.Stager.BuildDir(), path)
+	nodeModules := filepath.Join(dir, "node_modules")
+	if found, err := libbuildpack.FileExists(nodeModules); err != nil || !found {
+		return err
+	}
+
+	manager := prune.NPM
+	if found, err := libbuildpack.FileExists(filepath.Join(dir, "yarn.lock")); err != nil {
+		return err
+	} else if found {
+		manager = prune.Yarn
+	}
+
+	buffer := new(bytes.Buffer)
+	if err := f.Command.Execute(dir, buffer, buffer, manager, "--version"); err != nil {
+		return err
+	}
+	args, err := prune.Command(manager, strings.TrimSpace(buffer.String()), omit)
+	if err != nil || len(args) == 0 {
+		return err
+	}
+
+	before, err := prune.CountPackages(nodeModules)
+	if err != nil {
+		return err
+	}
+	if err := f.Command.Execute(dir, f.Log.Output(), f.Log.Output(), args[0], args[1:]...); err != nil {
+		return err
+	}
+	after, err := prune.CountPackages(nodeModules)
+	if err != nil {
+		return err
+	}
+	f.Log.Info("Pruned the node_modules of %s from %d to %d packages", path, before, after)
+	return nil
+}
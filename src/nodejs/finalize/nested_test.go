@@ -0,0 +1,126 @@
+package finalize_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"nodejs/failure"
+	"nodejs/finalize"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/cloudfoundry/libbuildpack/ansicleaner"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// fakePruner answers the version of the package manager and removes what
+// npm prune would from the node_modules of the nested path.
+type fakePruner struct {
+	calls []string
+	err   error
+}
+
+func (p *fakePruner) Execute(dir string, stdout io.Writer, _ io.Writer, program string, args ...string) error {
+	call := filepath.Base(dir) + ": " + program + " " + strings.Join(args, " ")
+	p.calls = append(p.calls, call)
+	if len(args) == 1 && args[0] == "--version" {
+		io.WriteString(stdout, "10.2.0\n")
+		return nil
+	}
+	if p.err != nil {
+		return p.err
+	}
+	return os.RemoveAll(filepath.Join(dir, "node_modules", "jest"))
+}
+
+var _ = Describe("PruneNestedPaths", func() {
+	var (
+		err       error
+		buildDir  string
+		finalizer *finalize.Finalizer
+		pruner    *fakePruner
+		buffer    *bytes.Buffer
+		oldEnv    map[string]string
+	)
+
+	install := func(path string, packages ...string) {
+		for _, name := range packages {
+			dir := filepath.Join(buildDir, path, "node_modules", name)
+			Expect(os.MkdirAll(dir, 0755)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(dir, "package.json"), []byte(`{"name":"`+name+`"}`), 0644)).To(Succeed())
+		}
+	}
+
+	BeforeEach(func() {
+		buildDir, err = ioutil.TempDir("", "nodejs-buildpack.build.")
+		Expect(err).To(BeNil())
+		install("packages/api", "express", "jest")
+		install("packages/worker", "bullmq", "jest")
+
+		oldEnv = map[string]string{}
+		for _, key := range []string{"BP_NESTED_INSTALL_PATHS", "BP_PRUNE_OMIT", "NODE_ENV", "BP_RUNTIME_NODE_ENV", "NPM_CONFIG_PRODUCTION"} {
+			oldEnv[key] = os.Getenv(key)
+			os.Unsetenv(key)
+		}
+		os.Setenv("BP_NESTED_INSTALL_PATHS", "packages/api,packages/worker")
+		os.Setenv("NODE_ENV", "production")
+
+		pruner = &fakePruner{}
+		buffer = new(bytes.Buffer)
+		logger := libbuildpack.NewLogger(ansicleaner.New(buffer))
+		finalizer = &finalize.Finalizer{
+			Stager:  libbuildpack.NewStager([]string{buildDir, "", "", "0"}, logger, &libbuildpack.Manifest{}),
+			Log:     logger,
+			Command: pruner,
+		}
+	})
+
+	AfterEach(func() {
+		for key, value := range oldEnv {
+			os.Setenv(key, value)
+		}
+		Expect(os.RemoveAll(buildDir)).To(Succeed())
+	})
+
+	It("prunes the devDependencies of every path on its own", func() {
+		Expect(finalizer.PruneNestedPaths()).To(Succeed())
+		Expect(pruner.calls).To(Equal([]string{
+			"api: npm --version",
+			"api: npm prune --omit=dev",
+			"worker: npm --version",
+			"worker: npm prune --omit=dev",
+		}))
+		Expect(buffer.String()).To(ContainSubstring("Pruned the node_modules of packages/api from 2 to 1 packages"))
+		Expect(buffer.String()).To(ContainSubstring("Pruned the node_modules of packages/worker from 2 to 1 packages"))
+	})
+
+	It("omits what BP_PRUNE_OMIT lists", func() {
+		os.Setenv("BP_PRUNE_OMIT", "dev,optional")
+		Expect(finalizer.PruneNestedPaths()).To(Succeed())
+		Expect(pruner.calls).To(ContainElement("api: npm prune --omit=dev --omit=optional"))
+	})
+
+	It("skips pruning when the app does not run in production", func() {
+		os.Setenv("NODE_ENV", "development")
+		Expect(finalizer.PruneNestedPaths()).To(Succeed())
+		Expect(pruner.calls).To(BeEmpty())
+		Expect(buffer.String()).To(ContainSubstring("Skipping pruning of the nested paths: the app runs with NODE_ENV=development"))
+	})
+
+	It("fails naming the path which fails to prune", func() {
+		pruner.err = errors.New("exit status 1")
+		err := finalizer.PruneNestedPaths()
+		Expect(err).To(MatchError("pruning packages/api failed: exit status 1"))
+		Expect(failure.CodeOf(err)).To(Equal(failure.PruneFailed))
+	})
+
+	It("does nothing without BP_NESTED_INSTALL_PATHS", func() {
+		os.Unsetenv("BP_NESTED_INSTALL_PATHS")
+		Expect(finalizer.PruneNestedPaths()).To(Succeed())
+		Expect(pruner.calls).To(BeEmpty())
+	})
+})
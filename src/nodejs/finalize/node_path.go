@@ -0,0 +1,97 @@
+package finalize
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var (
+	// bareSpecifierPattern matches require(), import() and import ... from
+	// of a specifier which is not a relative or absolute path.
+	bareSpecifierPattern = regexp.MustCompile(`(?:\brequire\s*\(|\bimport\s*\(|\bfrom|^import)\s*['"]([^'"./][^'"]*)['"]`)
+	nodePathAssignment   = regexp.MustCompile(`(^|\s)NODE_PATH=`)
+)
+
+// WarnNodePathResolution warns when the start command sets NODE_PATH or the
+// entrypoint requires a dir of the app as if it were a package, which only
+// resolves through NODE_PATH. NODE_PATH is ignored by ES modules and the
+// buildpack only sets it when node_modules is outside the app dir.
+func (f *Finalizer) WarnNodePathResolution() error {
+	command, err := f.startCommand()
+	if err != nil {
+		return err
+	}
+	if nodePathAssignment.MatchString(command) {
+		f.Log.Warning("The start command sets NODE_PATH, which ES modules ignore\nRequire the modules of the app with relative paths, like ./lib/db, instead")
+	}
+
+	entry, _ := f.entrypoint(command)
+	if entry == "" {
+		return nil
+	}
+	nodeModules, err := f.nodeModulesDir()
+	if err != nil {
+		return err
+	}
+	specifiers, err := appLocalSpecifiers(f.Stager.BuildDir(), nodeModules, entry)
+	if err != nil {
+		return err
+	}
+	for _, specifier := range specifiers {
+		f.Log.Warning("%s requires %s, which only resolves through NODE_PATH\nRequire it as ./%s instead, NODE_PATH is ignored by ES modules", entry, specifier, specifier)
+	}
+	return nil
+}
+
+// appLocalSpecifiers returns the bare specifiers in entry, like lib/db,
+// whose first segment is a file or dir of the app rather than a package in
+// nodeModules.
+func appLocalSpecifiers(buildDir, nodeModules, entry string) ([]string, error) {
+	file, err := os.Open(filepath.Join(buildDir, entry))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+
+	var specifiers []string
+	seen := map[string]bool{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "//") || strings.HasPrefix(line, "*") {
+			continue
+		}
+		for _, match := range bareSpecifierPattern.FindAllStringSubmatch(line, -1) {
+			specifier := match[1]
+			if seen[specifier] || strings.HasPrefix(specifier, "@") || strings.Contains(specifier, ":") {
+				continue
+			}
+			seen[specifier] = true
+			if isAppLocal(buildDir, nodeModules, strings.SplitN(specifier, "/", 2)[0]) {
+				specifiers = append(specifiers, specifier)
+			}
+		}
+	}
+	return specifiers, scanner.Err()
+}
+
+func isAppLocal(buildDir, nodeModules, name string) bool {
+	if name == "node_modules" {
+		return false
+	}
+	if _, err := os.Stat(filepath.Join(nodeModules, name)); err == nil {
+		return false
+	}
+	for _, candidate := range []string{name, name + ".js", name + ".json", name + ".cjs", name + ".mjs"} {
+		if _, err := os.Stat(filepath.Join(buildDir, candidate)); err == nil {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,92 @@
+package finalize_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"nodejs/finalize"
+	"os"
+	"path/filepath"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/cloudfoundry/libbuildpack/ansicleaner"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("WarnNodePathResolution", func() {
+	var (
+		err       error
+		buildDir  string
+		depsDir   string
+		finalizer *finalize.Finalizer
+		buffer    *bytes.Buffer
+	)
+
+	writeFile := func(path, contents string) {
+		Expect(os.MkdirAll(filepath.Dir(path), 0755)).To(Succeed())
+		Expect(ioutil.WriteFile(path, []byte(contents), 0644)).To(Succeed())
+	}
+
+	BeforeEach(func() {
+		buildDir, err = ioutil.TempDir("", "nodejs-buildpack.build.")
+		Expect(err).To(BeNil())
+		depsDir, err = ioutil.TempDir("", "nodejs-buildpack.deps.")
+		Expect(err).To(BeNil())
+		Expect(os.MkdirAll(filepath.Join(depsDir, "0"), 0755)).To(Succeed())
+
+		writeFile(filepath.Join(buildDir, "lib", "db.js"), "module.exports = {}\n")
+		writeFile(filepath.Join(buildDir, "config.json"), "{}\n")
+
+		buffer = new(bytes.Buffer)
+		logger := libbuildpack.NewLogger(ansicleaner.New(buffer))
+		finalizer = &finalize.Finalizer{
+			Stager: libbuildpack.NewStager([]string{buildDir, "", depsDir, "0"}, logger, &libbuildpack.Manifest{}),
+			Log:    logger,
+		}
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(buildDir)).To(Succeed())
+		Expect(os.RemoveAll(depsDir)).To(Succeed())
+	})
+
+	It("warns about bare requires of the dirs and files of the app", func() {
+		writeFile(filepath.Join(buildDir, "server.js"), "const db = require('lib/db')\nconst config = require(\"config\")\nconst express = require('express')\n")
+
+		Expect(finalizer.WarnNodePathResolution()).To(Succeed())
+		Expect(buffer.String()).To(ContainSubstring("server.js requires lib/db, which only resolves through NODE_PATH"))
+		Expect(buffer.String()).To(ContainSubstring("Require it as ./lib/db instead, NODE_PATH is ignored by ES modules"))
+		Expect(buffer.String()).To(ContainSubstring("server.js requires config, which only resolves through NODE_PATH"))
+		Expect(buffer.String()).NotTo(ContainSubstring("express"))
+	})
+
+	It("warns about imports of the app from the entrypoint of the start script", func() {
+		finalizer.StartScript = "node app.mjs"
+		writeFile(filepath.Join(buildDir, "app.mjs"), "import db from 'lib/db.js'\nimport 'lib/setup'\n")
+
+		Expect(finalizer.WarnNodePathResolution()).To(Succeed())
+		Expect(buffer.String()).To(ContainSubstring("app.mjs requires lib/db.js, which only resolves through NODE_PATH"))
+		Expect(buffer.String()).To(ContainSubstring("app.mjs requires lib/setup, which only resolves through NODE_PATH"))
+	})
+
+	It("leaves out relative requires, builtins and installed packages", func() {
+		writeFile(filepath.Join(depsDir, "0", "node_modules", "lib", "package.json"), "{}\n")
+		writeFile(filepath.Join(buildDir, "server.js"), "require('./lib/db')\nrequire('node:fs')\nrequire('http')\nrequire('lib')\nrequire('@scope/lib')\n")
+
+		Expect(finalizer.WarnNodePathResolution()).To(Succeed())
+		Expect(buffer.String()).To(Equal(""))
+	})
+
+	It("warns about a start command which sets NODE_PATH", func() {
+		finalizer.StartScript = "NODE_PATH=. node server.js"
+		writeFile(filepath.Join(buildDir, "server.js"), "require('./lib/db')\n")
+
+		Expect(finalizer.WarnNodePathResolution()).To(Succeed())
+		Expect(buffer.String()).To(ContainSubstring("The start command sets NODE_PATH, which ES modules ignore"))
+	})
+
+	It("does nothing without an entrypoint", func() {
+		Expect(finalizer.WarnNodePathResolution()).To(Succeed())
+		Expect(buffer.String()).To(Equal(""))
+	})
+})
@@ -0,0 +1,120 @@
+package finalize
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"nodejs/duration"
+	"nodejs/installed"
+	"nodejs/netbudget"
+	"nodejs/outdated"
+	"nodejs/supply"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	// DefaultOutdatedTimeout is how long the outdated report may add to
+	// staging unless BP_OUTDATED_TIMEOUT says otherwise.
+	DefaultOutdatedTimeout = 15 * time.Second
+
+	outdatedMax            = 20
+	outdatedConcurrency    = 8
+	outdatedRequestTimeout = 5 * time.Second
+)
+
+// ReportOutdated prints the direct dependencies with newer versions in the
+// registry when BP_OUTDATED_REPORT=true, the most outdated first. Lookups
+// stop after BP_OUTDATED_TIMEOUT or what is left of the optional network
+// budget, and dependencies which can't be looked up are left out, so that an
+// offline staging reports nothing.
+func (f *Finalizer) ReportOutdated() error {
+	if os.Getenv("BP_OUTDATED_REPORT") != "true" {
+		return nil
+	}
+	budget, err := outdatedTimeout(os.Getenv("BP_OUTDATED_TIMEOUT"))
+	if err != nil {
+		return err
+	}
+
+	deps, _, err := installed.List(f.Stager.BuildDir())
+	if err != nil {
+		return err
+	}
+	var direct []outdated.Dependency
+	for _, dep := range deps {
+		if dep.Version != "" {
+			direct = append(direct, outdated.Dependency{Name: dep.Name, Current: dep.Version, Specifier: dep.Specifier})
+		}
+	}
+	if len(direct) == 0 {
+		return nil
+	}
+
+	npmrc, err := ioutil.ReadFile(filepath.Join(f.Stager.BuildDir(), ".npmrc"))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	network, err := netbudget.Staging(f.Stager.DepDir())
+	if err != nil {
+		return err
+	}
+	call, err := network.Start("outdated report")
+	if err != nil {
+		f.Log.Info("Skipping the outdated report: %s", err)
+		return network.Save()
+	}
+	if call.Remaining < budget {
+		budget = call.Remaining
+	}
+
+	checker := outdated.Checker{
+		Registry:    supply.NPMRegistry(npmrc, os.Environ()),
+		Concurrency: outdatedConcurrency,
+		Timeout:     outdatedRequestTimeout,
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), budget)
+	defer cancel()
+	results := checker.Check(ctx, direct)
+	if len(results) == 0 {
+		call.Done(errors.New("no dependency could be looked up in the registry"))
+		return network.Save()
+	}
+	call.Done(nil)
+	if err := network.Save(); err != nil {
+		return err
+	}
+
+	stale := outdated.MostOutdated(results, 0)
+	if len(stale) == 0 {
+		f.Log.Info("The %d direct dependencies found in the registry are up to date", len(results))
+		return nil
+	}
+
+	count, shown := len(stale), ""
+	if count > outdatedMax {
+		shown = fmt.Sprintf(", the %d most outdated shown", outdatedMax)
+		stale = stale[:outdatedMax]
+	}
+	table := new(bytes.Buffer)
+	if err := outdated.Write(table, stale); err != nil {
+		return err
+	}
+	f.Log.Info("Outdated direct dependencies (%d of %d found in the registry%s):\n%s", count, len(results), shown, bytes.TrimRight(table.Bytes(), "\n"))
+	return nil
+}
+
+// outdatedTimeout parses BP_OUTDATED_TIMEOUT, a duration like 30s or a number
+// of seconds.
+func outdatedTimeout(value string) (time.Duration, error) {
+	if value == "" {
+		return DefaultOutdatedTimeout, nil
+	}
+	if d, err := duration.Parse(value); err == nil && d > 0 {
+		return d, nil
+	}
+	return 0, fmt.Errorf("invalid BP_OUTDATED_TIMEOUT %q, expected a duration like 15s", value)
+}
@@ -0,0 +1,180 @@
+package finalize_test
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"nodejs/finalize"
+	"nodejs/netbudget"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/cloudfoundry/libbuildpack/ansicleaner"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ReportOutdated", func() {
+	var (
+		err       error
+		buildDir  string
+		depsDir   string
+		finalizer *finalize.Finalizer
+		buffer    *bytes.Buffer
+		server    *httptest.Server
+		delay     time.Duration
+		requests  int32
+		oldEnv    map[string]string
+	)
+
+	install := func(name, version string) {
+		dir := filepath.Join(buildDir, "node_modules", name)
+		Expect(os.MkdirAll(dir, 0755)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(dir, "package.json"), []byte(fmt.Sprintf(`{"name":%q,"version":%q}`, name, version)), 0644)).To(Succeed())
+	}
+
+	BeforeEach(func() {
+		buildDir, err = ioutil.TempDir("", "nodejs-buildpack.build.")
+		Expect(err).To(BeNil())
+		depsDir, err = ioutil.TempDir("", "nodejs-buildpack.deps.")
+		Expect(err).To(BeNil())
+		Expect(os.MkdirAll(filepath.Join(depsDir, "0"), 0755)).To(Succeed())
+
+		Expect(ioutil.WriteFile(filepath.Join(buildDir, "package.json"), []byte(`{"dependencies":{"express":"^4.17.0","lodash":"^4.17.21"}}`), 0644)).To(Succeed())
+		install("express", "4.17.1")
+		install("lodash", "4.17.21")
+
+		delay = 0
+		requests = 0
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&requests, 1)
+			if delay > 0 {
+				select {
+				case <-time.After(delay):
+				case <-r.Context().Done():
+					return
+				}
+			}
+			switch r.URL.Path {
+			case "/express":
+				fmt.Fprint(w, `{"dist-tags":{"latest":"5.0.1"},"versions":{"4.17.1":{},"4.21.2":{},"5.0.1":{}}}`)
+			case "/lodash":
+				fmt.Fprint(w, `{"dist-tags":{"latest":"4.17.21"},"versions":{"4.17.21":{}}}`)
+			default:
+				http.NotFound(w, r)
+			}
+		}))
+
+		oldEnv = map[string]string{}
+		for _, key := range []string{"BP_OUTDATED_REPORT", "BP_OUTDATED_TIMEOUT", "BP_OPTIONAL_NETWORK_BUDGET", "BP_OFFLINE", "npm_config_registry"} {
+			oldEnv[key] = os.Getenv(key)
+		}
+		os.Setenv("BP_OUTDATED_REPORT", "true")
+		os.Setenv("BP_OUTDATED_TIMEOUT", "")
+		os.Setenv("BP_OPTIONAL_NETWORK_BUDGET", "")
+		os.Setenv("BP_OFFLINE", "")
+		os.Setenv("npm_config_registry", server.URL+"/")
+
+		buffer = new(bytes.Buffer)
+		logger := libbuildpack.NewLogger(ansicleaner.New(buffer))
+		finalizer = &finalize.Finalizer{
+			Stager: libbuildpack.NewStager([]string{buildDir, "", depsDir, "0"}, logger, &libbuildpack.Manifest{}),
+			Log:    logger,
+		}
+	})
+
+	AfterEach(func() {
+		server.Close()
+		for key, value := range oldEnv {
+			os.Setenv(key, value)
+		}
+		Expect(os.RemoveAll(buildDir)).To(Succeed())
+		Expect(os.RemoveAll(depsDir)).To(Succeed())
+	})
+
+	outcomes := func() []string {
+		outcomes, err := netbudget.Finish(filepath.Join(depsDir, "0"))
+		Expect(err).To(BeNil())
+		var lines []string
+		for _, outcome := range outcomes {
+			lines = append(lines, string(outcome.Status)+" "+outcome.Reason)
+		}
+		return lines
+	}
+
+	It("prints the outdated direct dependencies", func() {
+		Expect(finalizer.ReportOutdated()).To(Succeed())
+		Expect(buffer.String()).To(ContainSubstring("Outdated direct dependencies (1 of 2 found in the registry):"))
+		Expect(buffer.String()).To(ContainSubstring("PACKAGE  CURRENT  WANTED  LATEST"))
+		Expect(buffer.String()).To(ContainSubstring("express  4.17.1   4.21.2  5.0.1"))
+		Expect(buffer.String()).NotTo(ContainSubstring("lodash"))
+	})
+
+	It("says so when everything is up to date", func() {
+		install("express", "5.0.1")
+		Expect(finalizer.ReportOutdated()).To(Succeed())
+		Expect(buffer.String()).To(ContainSubstring("The 2 direct dependencies found in the registry are up to date"))
+	})
+
+	It("does nothing unless BP_OUTDATED_REPORT is set", func() {
+		os.Setenv("BP_OUTDATED_REPORT", "")
+		Expect(finalizer.ReportOutdated()).To(Succeed())
+		Expect(buffer.String()).To(Equal(""))
+	})
+
+	It("is silent when the registry is unreachable", func() {
+		server.Close()
+		Expect(finalizer.ReportOutdated()).To(Succeed())
+		Expect(buffer.String()).To(Equal(""))
+		Expect(outcomes()).To(Equal([]string{"failed no dependency could be looked up in the registry"}))
+	})
+
+	It("charges the lookups to the optional network budget", func() {
+		Expect(finalizer.ReportOutdated()).To(Succeed())
+		Expect(outcomes()).To(Equal([]string{"ran "}))
+	})
+
+	It("is skipped when the optional network budget is 0", func() {
+		os.Setenv("BP_OPTIONAL_NETWORK_BUDGET", "0")
+		Expect(finalizer.ReportOutdated()).To(Succeed())
+		Expect(buffer.String()).To(ContainSubstring("Skipping the outdated report: BP_OPTIONAL_NETWORK_BUDGET is 0"))
+		Expect(buffer.String()).NotTo(ContainSubstring("Outdated direct dependencies"))
+	})
+
+	It("is skipped without a request to the registry when staging is offline", func() {
+		os.Setenv("BP_OFFLINE", "true")
+		Expect(finalizer.ReportOutdated()).To(Succeed())
+		Expect(atomic.LoadInt32(&requests)).To(BeZero())
+		Expect(buffer.String()).To(ContainSubstring("Skipping the outdated report: staging is offline (BP_OFFLINE)"))
+		Expect(outcomes()).To(Equal([]string{"skipped staging is offline (BP_OFFLINE)"}))
+	})
+
+	It("stays within the optional network budget", func() {
+		delay = 10 * time.Second
+		os.Setenv("BP_OPTIONAL_NETWORK_BUDGET", "100ms")
+
+		start := time.Now()
+		Expect(finalizer.ReportOutdated()).To(Succeed())
+		Expect(time.Since(start)).To(BeNumerically("<", 2*time.Second))
+	})
+
+	It("stays within BP_OUTDATED_TIMEOUT", func() {
+		delay = 10 * time.Second
+		os.Setenv("BP_OUTDATED_TIMEOUT", "100ms")
+
+		start := time.Now()
+		Expect(finalizer.ReportOutdated()).To(Succeed())
+		Expect(time.Since(start)).To(BeNumerically("<", 2*time.Second))
+		Expect(buffer.String()).To(Equal(""))
+	})
+
+	It("rejects an invalid BP_OUTDATED_TIMEOUT", func() {
+		os.Setenv("BP_OUTDATED_TIMEOUT", "soon")
+		Expect(finalizer.ReportOutdated()).To(MatchError(`invalid BP_OUTDATED_TIMEOUT "soon", expected a duration like 15s`))
+	})
+})
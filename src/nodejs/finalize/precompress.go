@@ -0,0 +1,53 @@
+package finalize
+
+import (
+	"fmt"
+	"nodejs/precompress"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// PrecompressAssets writes gzip variants of the static assets below the dirs
+// of BP_PRECOMPRESS_ASSETS, such as dist,public, so that the app or a proxy
+// in front of it serves them without compressing on every request. Brotli
+// needs an encoder the buildpack does not ship, so it writes only gzip.
+func (f *Finalizer) PrecompressAssets() error {
+	var dirs []string
+	for _, dir := range strings.Split(os.Getenv("BP_PRECOMPRESS_ASSETS"), ",") {
+		if dir = strings.TrimSpace(dir); dir != "" {
+			dirs = append(dirs, dir)
+		}
+	}
+	if len(dirs) == 0 {
+		return nil
+	}
+
+	var total precompress.Report
+	for _, dir := range dirs {
+		root := filepath.Join(f.Stager.BuildDir(), dir)
+		if rel, err := filepath.Rel(f.Stager.BuildDir(), root); err != nil || strings.HasPrefix(rel, "..") {
+			return fmt.Errorf("BP_PRECOMPRESS_ASSETS lists %s, which is outside the app", dir)
+		}
+		if info, err := os.Stat(root); os.IsNotExist(err) {
+			f.Log.Warning("BP_PRECOMPRESS_ASSETS lists %s, which does not exist after the build", dir)
+			continue
+		} else if err != nil {
+			return err
+		} else if !info.IsDir() {
+			return fmt.Errorf("BP_PRECOMPRESS_ASSETS lists %s, which is not a dir", dir)
+		}
+
+		report, err := precompress.Compress(root, precompress.MinSize, 0)
+		if err != nil {
+			return err
+		}
+		total.Files += report.Files
+		total.Skipped += report.Skipped
+		total.Size += report.Size
+		total.Compressed += report.Compressed
+	}
+
+	f.Log.Info("Precompressed %d assets in %s with gzip from %d to %d KiB, saving %d KiB (skipped %d small, compressed or incompressible files)", total.Files, strings.Join(dirs, ", "), total.Size/1024, total.Compressed/1024, total.Saved()/1024, total.Skipped)
+	return nil
+}
@@ -0,0 +1,69 @@
+package finalize_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"nodejs/finalize"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/cloudfoundry/libbuildpack/ansicleaner"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("PrecompressAssets", func() {
+	var (
+		err       error
+		buildDir  string
+		finalizer *finalize.Finalizer
+		buffer    *bytes.Buffer
+		oldAssets string
+	)
+
+	BeforeEach(func() {
+		buildDir, err = ioutil.TempDir("", "nodejs-buildpack.build.")
+		Expect(err).To(BeNil())
+
+		oldAssets = os.Getenv("BP_PRECOMPRESS_ASSETS")
+		os.Unsetenv("BP_PRECOMPRESS_ASSETS")
+
+		Expect(os.MkdirAll(filepath.Join(buildDir, "dist"), 0755)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(buildDir, "dist", "main.js"), []byte(strings.Repeat("console.log(1);\n", 1024)), 0644)).To(Succeed())
+
+		buffer = new(bytes.Buffer)
+		logger := libbuildpack.NewLogger(ansicleaner.New(buffer))
+		finalizer = &finalize.Finalizer{
+			Stager: libbuildpack.NewStager([]string{buildDir, "", "", "0"}, logger, &libbuildpack.Manifest{}),
+			Log:    logger,
+		}
+	})
+
+	AfterEach(func() {
+		os.Setenv("BP_PRECOMPRESS_ASSETS", oldAssets)
+		Expect(os.RemoveAll(buildDir)).To(Succeed())
+	})
+
+	It("does nothing without BP_PRECOMPRESS_ASSETS", func() {
+		Expect(finalizer.PrecompressAssets()).To(Succeed())
+		Expect(filepath.Join(buildDir, "dist", "main.js.gz")).NotTo(BeAnExistingFile())
+		Expect(buffer.String()).To(Equal(""))
+	})
+
+	It("compresses the listed dirs and warns about missing ones", func() {
+		os.Setenv("BP_PRECOMPRESS_ASSETS", "dist, public")
+
+		Expect(finalizer.PrecompressAssets()).To(Succeed())
+		Expect(filepath.Join(buildDir, "dist", "main.js.gz")).To(BeAnExistingFile())
+		Expect(buffer.String()).To(ContainSubstring("BP_PRECOMPRESS_ASSETS lists public, which does not exist after the build"))
+		Expect(buffer.String()).To(ContainSubstring("Precompressed 1 assets in dist, public with gzip from 16 to 0 KiB, saving 15 KiB"))
+	})
+
+	It("refuses dirs outside the app", func() {
+		os.Setenv("BP_PRECOMPRESS_ASSETS", "../dist")
+
+		Expect(finalizer.PrecompressAssets()).To(MatchError("BP_PRECOMPRESS_ASSETS lists ../dist, which is outside the app"))
+	})
+})
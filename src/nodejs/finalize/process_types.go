@@ -0,0 +1,152 @@
+package finalize
+
+import (
+	"fmt"
+	"io/ioutil"
+	"nodejs/packagejson"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/cloudfoundry/libbuildpack"
+)
+
+// ProcessTypesFile holds, relative to the app dir, the process types which
+// bin/release adds to the default web process.
+const ProcessTypesFile = ".cloudfoundry/process-types.yml"
+
+var processTypeName = regexp.MustCompile(`^[\w-]+$`)
+
+// ProcessType is a process type running a script of package.json.
+type ProcessType struct {
+	Name    string
+	Command string
+}
+
+// ProcessTypesYAML renders types as entries of default_process_types.
+func ProcessTypesYAML(types []ProcessType) string {
+	var out string
+	for _, t := range types {
+		out += fmt.Sprintf("  %s: %s\n", t.Name, t.Command)
+	}
+	return out
+}
+
+// ScriptProcessTypes turns the scripts listed in BP_SCRIPT_PROCESS_TYPES
+// into process types, so that apps can run workers without a Procfile. A
+// script which is a plain node command runs through a wrapper like the start
+// script does, others through `npm run` or `yarn run`. Process types defined
+// in the Procfile take precedence, and web is left to the existing logic.
+func (f *Finalizer) ScriptProcessTypes() error {
+	value := os.Getenv("BP_SCRIPT_PROCESS_TYPES")
+	if value == "" {
+		return nil
+	}
+
+	pkg, _, err := f.packageJSON()
+	if err != nil {
+		return err
+	}
+	procfileTypes, err := f.procfileProcessTypes()
+	if err != nil {
+		return err
+	}
+
+	tool, err := f.scriptTool()
+	if err != nil {
+		return err
+	}
+
+	var types []ProcessType
+	var missing []string
+	seen := map[string]bool{}
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+
+		if !processTypeName.MatchString(name) {
+			return fmt.Errorf("invalid process type %q in BP_SCRIPT_PROCESS_TYPES, expected letters, digits, - and _", name)
+		}
+		if name == "web" {
+			return fmt.Errorf("BP_SCRIPT_PROCESS_TYPES cannot define the web process, set it with scripts.start or a Procfile")
+		}
+		if procfileTypes[name] {
+			f.Log.Warning("The Procfile defines the %s process, ignoring scripts.%s from BP_SCRIPT_PROCESS_TYPES", name, name)
+			continue
+		}
+		if _, found := pkg.Scripts[name]; !found {
+			missing = append(missing, name)
+			continue
+		}
+
+		command, err := f.scriptCommand(name, tool, pkg)
+		if err != nil {
+			return err
+		}
+		types = append(types, ProcessType{Name: name, Command: command})
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("BP_SCRIPT_PROCESS_TYPES lists scripts which are not in package.json: %s", strings.Join(missing, ", "))
+	}
+	if len(types) == 0 {
+		return nil
+	}
+
+	path := filepath.Join(f.Stager.BuildDir(), ProcessTypesFile)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path, []byte(ProcessTypesYAML(types)), 0644); err != nil {
+		return err
+	}
+
+	for _, t := range types {
+		f.Log.Info("Adding process type %s: %s", t.Name, t.Command)
+	}
+	return nil
+}
+
+// scriptCommand returns the command of the process type running
+// scripts.<name>, writing its wrapper when the script can run without tool.
+func (f *Finalizer) scriptCommand(name, tool string, pkg packagejson.Package) (string, error) {
+	command := tool + " run " + name
+	if os.Getenv("BP_NODE_DIRECT_START") == "false" || pkg.Scripts["pre"+name] != "" || pkg.Scripts["post"+name] != "" {
+		return command, nil
+	}
+	exec, ok := DirectStartCommand(pkg.Scripts[name])
+	if !ok {
+		return command, nil
+	}
+
+	wrapper := ".cloudfoundry/node-" + name
+	if err := f.writeWrapper(wrapper, name, tool, exec, pkg); err != nil {
+		return "", err
+	}
+	return wrapper, nil
+}
+
+// scriptTool returns the package manager which runs the scripts of the app.
+func (f *Finalizer) scriptTool() (string, error) {
+	if found, err := libbuildpack.FileExists(filepath.Join(f.Stager.BuildDir(), "yarn.lock")); err != nil {
+		return "", err
+	} else if found {
+		return "yarn", nil
+	}
+	return "npm", nil
+}
+
+func (f *Finalizer) procfileProcessTypes() (map[string]bool, error) {
+	types := map[string]bool{}
+	procfile, err := f.readProcfile()
+	if err != nil || procfile == nil {
+		return types, err
+	}
+	for _, process := range procfile.Processes {
+		types[process.Name] = true
+	}
+	return types, nil
+}
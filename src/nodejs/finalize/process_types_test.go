@@ -0,0 +1,152 @@
+package finalize_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"nodejs/finalize"
+	"os"
+	"path/filepath"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/cloudfoundry/libbuildpack/ansicleaner"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Script process types", func() {
+	var (
+		err       error
+		buildDir  string
+		finalizer *finalize.Finalizer
+		buffer    *bytes.Buffer
+		oldEnv    map[string]*string
+	)
+
+	writeFile := func(path, contents string) {
+		Expect(os.MkdirAll(filepath.Dir(path), 0755)).To(Succeed())
+		Expect(ioutil.WriteFile(path, []byte(contents), 0644)).To(Succeed())
+	}
+
+	processTypes := func() string {
+		contents, err := ioutil.ReadFile(filepath.Join(buildDir, ".cloudfoundry", "process-types.yml"))
+		Expect(err).To(BeNil())
+		return string(contents)
+	}
+
+	BeforeEach(func() {
+		buildDir, err = ioutil.TempDir("", "nodejs-buildpack.build.")
+		Expect(err).To(BeNil())
+
+		oldEnv = map[string]*string{}
+		for _, key := range []string{"BP_SCRIPT_PROCESS_TYPES", "BP_NODE_DIRECT_START"} {
+			if value, found := os.LookupEnv(key); found {
+				oldEnv[key] = &value
+			} else {
+				oldEnv[key] = nil
+			}
+			os.Unsetenv(key)
+		}
+
+		writeFile(filepath.Join(buildDir, "package.json"), `{
+			"name": "shop",
+			"scripts": {
+				"start": "node server.js",
+				"worker": "node worker.js --queue default",
+				"scheduler": "node scheduler.js | pino-pretty",
+				"prejobs": "node migrate.js",
+				"jobs": "node jobs.js"
+			}
+		}`)
+
+		buffer = new(bytes.Buffer)
+		logger := libbuildpack.NewLogger(ansicleaner.New(buffer))
+		finalizer = &finalize.Finalizer{
+			Stager: libbuildpack.NewStager([]string{buildDir, "", "", "0"}, logger, &libbuildpack.Manifest{}),
+			Log:    logger,
+		}
+	})
+
+	AfterEach(func() {
+		for key, value := range oldEnv {
+			if value == nil {
+				os.Unsetenv(key)
+			} else {
+				os.Setenv(key, *value)
+			}
+		}
+		Expect(os.RemoveAll(buildDir)).To(Succeed())
+	})
+
+	It("renders process types as YAML", func() {
+		Expect(finalize.ProcessTypesYAML([]finalize.ProcessType{
+			{Name: "worker", Command: ".cloudfoundry/node-worker"},
+			{Name: "scheduler", Command: "npm run scheduler"},
+		})).To(Equal("  worker: .cloudfoundry/node-worker\n  scheduler: npm run scheduler\n"))
+	})
+
+	It("does nothing without BP_SCRIPT_PROCESS_TYPES", func() {
+		Expect(finalizer.ScriptProcessTypes()).To(Succeed())
+
+		_, err := os.Stat(filepath.Join(buildDir, ".cloudfoundry", "process-types.yml"))
+		Expect(os.IsNotExist(err)).To(BeTrue())
+	})
+
+	It("adds a process type for each listed script", func() {
+		os.Setenv("BP_SCRIPT_PROCESS_TYPES", "worker, scheduler,jobs,worker")
+
+		Expect(finalizer.ScriptProcessTypes()).To(Succeed())
+
+		Expect(processTypes()).To(Equal("  worker: .cloudfoundry/node-worker\n  scheduler: npm run scheduler\n  jobs: npm run jobs\n"))
+		wrapper, err := ioutil.ReadFile(filepath.Join(buildDir, ".cloudfoundry", "node-worker"))
+		Expect(err).To(BeNil())
+		Expect(string(wrapper)).To(ContainSubstring("export npm_lifecycle_event=worker\n"))
+		Expect(string(wrapper)).To(HaveSuffix("exec node worker.js --queue default \"$@\"\n"))
+		Expect(buffer.String()).To(ContainSubstring("Adding process type scheduler: npm run scheduler"))
+	})
+
+	It("runs the scripts with yarn in a yarn app", func() {
+		os.Setenv("BP_SCRIPT_PROCESS_TYPES", "scheduler")
+		writeFile(filepath.Join(buildDir, "yarn.lock"), "")
+
+		Expect(finalizer.ScriptProcessTypes()).To(Succeed())
+		Expect(processTypes()).To(Equal("  scheduler: yarn run scheduler\n"))
+	})
+
+	It("keeps npm run when BP_NODE_DIRECT_START is false", func() {
+		os.Setenv("BP_SCRIPT_PROCESS_TYPES", "worker")
+		os.Setenv("BP_NODE_DIRECT_START", "false")
+
+		Expect(finalizer.ScriptProcessTypes()).To(Succeed())
+		Expect(processTypes()).To(Equal("  worker: npm run worker\n"))
+	})
+
+	It("fails when a listed script does not exist", func() {
+		os.Setenv("BP_SCRIPT_PROCESS_TYPES", "worker,mailer,cron")
+
+		Expect(finalizer.ScriptProcessTypes()).To(MatchError("BP_SCRIPT_PROCESS_TYPES lists scripts which are not in package.json: mailer, cron"))
+	})
+
+	It("leaves the web process to the existing logic", func() {
+		os.Setenv("BP_SCRIPT_PROCESS_TYPES", "web")
+
+		Expect(finalizer.ScriptProcessTypes()).To(MatchError(ContainSubstring("cannot define the web process")))
+	})
+
+	It("rejects invalid process type names", func() {
+		os.Setenv("BP_SCRIPT_PROCESS_TYPES", "worker:1")
+
+		Expect(finalizer.ScriptProcessTypes()).To(MatchError(ContainSubstring(`invalid process type "worker:1"`)))
+	})
+
+	It("lets the Procfile define a process type", func() {
+		os.Setenv("BP_SCRIPT_PROCESS_TYPES", "worker,scheduler")
+		writeFile(filepath.Join(buildDir, "Procfile"), "web: node server.js\nworker: node worker.js --queue high\n")
+
+		Expect(finalizer.ScriptProcessTypes()).To(Succeed())
+
+		Expect(processTypes()).To(Equal("  scheduler: npm run scheduler\n"))
+		Expect(buffer.String()).To(ContainSubstring("The Procfile defines the worker process, ignoring scripts.worker"))
+		_, err := os.Stat(filepath.Join(buildDir, ".cloudfoundry", "node-worker"))
+		Expect(os.IsNotExist(err)).To(BeTrue())
+	})
+})
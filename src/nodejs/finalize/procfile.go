@@ -0,0 +1,216 @@
+package finalize
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"nodejs/failure"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// ProcfileName is the file in the root of the app which defines its process
+// types.
+const ProcfileName = "Procfile"
+
+// KnownProcessTypes are the process types other types are compared to for
+// typos. Any name is allowed.
+var KnownProcessTypes = []string{"web", "worker", "release", "clock", "scheduler"}
+
+var (
+	utf8BOM      = []byte("\xef\xbb\xbf")
+	procfileLine = regexp.MustCompile(`^([\w-]+):[ \t]*(.*)$`)
+)
+
+// Process is a process type of a Procfile and the line it is defined on,
+// counting from 1.
+type Process struct {
+	Name    string
+	Command string
+	Line    int
+}
+
+// Procfile is a parsed Procfile. It keeps the comments and blank lines, so
+// that rewriting a command leaves the rest of the file as it was.
+type Procfile struct {
+	Processes []Process
+	lines     []string
+	// Normalized lists what parsing changed about the file, such as its BOM
+	// or CRLF line endings, which Bytes writes without.
+	Normalized []string
+}
+
+// ParseProcfile parses the contents of a Procfile of `name: command` lines,
+// with blank lines and # comments. It strips a BOM and reads CRLF line
+// endings, rejects lines of another syntax, empty commands and names defined
+// twice, and returns warnings about process types which look misspelt.
+func ParseProcfile(contents []byte) (*Procfile, []string, error) {
+	procfile := &Procfile{}
+	if bytes.HasPrefix(contents, utf8BOM) {
+		contents = contents[len(utf8BOM):]
+		procfile.Normalized = append(procfile.Normalized, "removed the byte order mark")
+	}
+	if bytes.Contains(contents, []byte("\r\n")) {
+		contents = bytes.Replace(contents, []byte("\r\n"), []byte("\n"), -1)
+		procfile.Normalized = append(procfile.Normalized, "converted the CRLF line endings")
+	}
+
+	var problems, warnings []string
+	lines := map[string][]int{}
+	procfile.lines = strings.Split(string(contents), "\n")
+	for i, line := range procfile.lines {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+
+		match := procfileLine.FindStringSubmatch(line)
+		if match == nil {
+			problems = append(problems, fmt.Sprintf("  line %d: %q is not `name: command`%s", i+1, line, syntaxHint(line)))
+			continue
+		}
+		name, command := match[1], strings.TrimSpace(match[2])
+		if command == "" {
+			problems = append(problems, fmt.Sprintf("  line %d: the %s process has no command", i+1, name))
+			continue
+		}
+		if lines[name] == nil {
+			procfile.Processes = append(procfile.Processes, Process{Name: name, Command: command, Line: i + 1})
+			if warning := unknownProcessType(name); warning != "" {
+				warnings = append(warnings, warning)
+			}
+		}
+		lines[name] = append(lines[name], i+1)
+	}
+
+	var names []string
+	for name := range lines {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if len(lines[name]) > 1 {
+			problems = append(problems, fmt.Sprintf("  the %s process is defined on lines %s", name, joinLines(lines[name])))
+		}
+	}
+
+	if len(problems) > 0 {
+		return nil, warnings, fmt.Errorf("The Procfile is invalid:\n%s", strings.Join(problems, "\n"))
+	}
+	return procfile, warnings, nil
+}
+
+// syntaxHint explains the usual mistakes on a line which isn't
+// `name: command`.
+func syntaxHint(line string) string {
+	fields := strings.Fields(line)
+	switch {
+	case strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t"):
+		return ", remove the indentation"
+	case len(fields) > 1 && processTypeName.MatchString(fields[0]) && !strings.Contains(fields[0], ":"):
+		return fmt.Sprintf(", write %q", fields[0]+": "+strings.TrimSpace(line[len(fields[0]):]))
+	}
+	return ""
+}
+
+func unknownProcessType(name string) string {
+	for _, known := range KnownProcessTypes {
+		if name == known {
+			return ""
+		}
+	}
+	return fmt.Sprintf("The Procfile defines the %s process, which is not one of %s. Check it for typos, Cloud Foundry only runs it when the app manifest lists it in processes", name, strings.Join(KnownProcessTypes, ", "))
+}
+
+func joinLines(lines []int) string {
+	var parts []string
+	for _, line := range lines {
+		parts = append(parts, fmt.Sprintf("%d", line))
+	}
+	if len(parts) == 2 {
+		return parts[0] + " and " + parts[1]
+	}
+	return strings.Join(parts[:len(parts)-1], ", ") + " and " + parts[len(parts)-1]
+}
+
+// Command returns the command of the process type name.
+func (p *Procfile) Command(name string) (string, bool) {
+	if p == nil {
+		return "", false
+	}
+	for _, process := range p.Processes {
+		if process.Name == name {
+			return process.Command, true
+		}
+	}
+	return "", false
+}
+
+// SetCommand replaces the command of the process type name on its line.
+func (p *Procfile) SetCommand(name, command string) {
+	for i, process := range p.Processes {
+		if process.Name == name {
+			p.Processes[i].Command = command
+			p.lines[process.Line-1] = name + ": " + command
+		}
+	}
+}
+
+// Bytes returns the Procfile with LF line endings, without a BOM and with
+// every process as `name: command`.
+func (p *Procfile) Bytes() []byte {
+	lines := make([]string, len(p.lines))
+	copy(lines, p.lines)
+	for _, process := range p.Processes {
+		lines[process.Line-1] = process.Name + ": " + process.Command
+	}
+	return []byte(strings.Join(lines, "\n"))
+}
+
+// readProcfile parses the Procfile of the app, or returns nil without one.
+func (f *Finalizer) readProcfile() (*Procfile, error) {
+	contents, err := ioutil.ReadFile(filepath.Join(f.Stager.BuildDir(), ProcfileName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	procfile, _, err := ParseProcfile(contents)
+	return procfile, failure.Wrap(failure.ProcfileInvalid, err)
+}
+
+// CheckProcfile fails for an invalid Procfile, before the start command is
+// taken from it, warns about misspelt process types, and writes the
+// Procfile back normalized when its encoding or syntax would trip up the
+// platform reading it.
+func (f *Finalizer) CheckProcfile() error {
+	path := filepath.Join(f.Stager.BuildDir(), ProcfileName)
+	contents, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	procfile, warnings, err := ParseProcfile(contents)
+	for _, warning := range warnings {
+		f.Log.Warning("%s", warning)
+	}
+	if err != nil {
+		return failure.Wrap(failure.ProcfileInvalid, err)
+	}
+
+	normalized := procfile.Bytes()
+	if bytes.Equal(normalized, contents) {
+		return nil
+	}
+	changes := procfile.Normalized
+	if len(changes) == 0 || !bytes.Equal(normalized, bytes.Replace(bytes.TrimPrefix(contents, utf8BOM), []byte("\r\n"), []byte("\n"), -1)) {
+		changes = append(changes, "wrote every process as `name: command`")
+	}
+	f.Log.Info("Normalized the Procfile: %s", strings.Join(changes, ", "))
+	return ioutil.WriteFile(path, normalized, 0644)
+}
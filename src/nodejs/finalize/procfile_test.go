@@ -0,0 +1,136 @@
+package finalize_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"nodejs/failure"
+	"nodejs/finalize"
+	"os"
+	"path/filepath"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/cloudfoundry/libbuildpack/ansicleaner"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Procfile", func() {
+	procfile := func(name string) []byte {
+		contents, err := ioutil.ReadFile(filepath.Join("testdata", "procfile", name))
+		Expect(err).To(BeNil())
+		return contents
+	}
+
+	Describe("ParseProcfile", func() {
+		It("parses the processes around comments and blank lines", func() {
+			parsed, warnings, err := finalize.ParseProcfile(procfile("valid"))
+			Expect(err).To(BeNil())
+			Expect(warnings).To(BeEmpty())
+			Expect(parsed.Processes).To(Equal([]finalize.Process{
+				{Name: "web", Command: "node server.js --port $PORT", Line: 3},
+				{Name: "worker", Command: "node worker.js", Line: 4},
+				{Name: "release", Command: "./migrate.sh", Line: 7},
+			}))
+
+			command, found := parsed.Command("worker")
+			Expect(found).To(BeTrue())
+			Expect(command).To(Equal("node worker.js"))
+			_, found = parsed.Command("clock")
+			Expect(found).To(BeFalse())
+
+			Expect(string(parsed.Bytes())).To(Equal("# Processes of the app\n\nweb: node server.js --port $PORT\nworker: node worker.js\n\n  # release runs before the new version starts\nrelease: ./migrate.sh\n"))
+		})
+
+		It("strips a BOM and CRLF line endings", func() {
+			parsed, _, err := finalize.ParseProcfile(procfile("crlf"))
+			Expect(err).To(BeNil())
+			Expect(parsed.Normalized).To(Equal([]string{"converted the CRLF line endings"}))
+			Expect(string(parsed.Bytes())).To(Equal("web: node server.js\nworker: node worker.js\n"))
+
+			parsed, _, err = finalize.ParseProcfile(procfile("bom"))
+			Expect(err).To(BeNil())
+			Expect(parsed.Normalized).To(Equal([]string{"removed the byte order mark"}))
+			Expect(parsed.Processes).To(Equal([]finalize.Process{{Name: "web", Command: "node server.js", Line: 1}}))
+		})
+
+		It("rewrites a command in place", func() {
+			parsed, _, err := finalize.ParseProcfile(procfile("valid"))
+			Expect(err).To(BeNil())
+			parsed.SetCommand("web", "./start.sh")
+			Expect(string(parsed.Bytes())).To(ContainSubstring("\n\nweb: ./start.sh\nworker: node worker.js\n"))
+		})
+
+		It("warns about process types which look misspelt", func() {
+			_, warnings, err := finalize.ParseProcfile(procfile("unknown_type"))
+			Expect(err).To(BeNil())
+			Expect(warnings).To(ConsistOf(ContainSubstring("The Procfile defines the wokrer process, which is not one of web, worker, release, clock, scheduler")))
+		})
+
+		DescribeTable("rejects a malformed Procfile",
+			func(name, message string) {
+				_, _, err := finalize.ParseProcfile(procfile(name))
+				Expect(err).NotTo(BeNil())
+				Expect(err.Error()).To(ContainSubstring("The Procfile is invalid:\n"))
+				Expect(err.Error()).To(ContainSubstring(message))
+			},
+			Entry("indented", "indented", `line 2: "\tworker: node worker.js" is not `+"`name: command`"+`, remove the indentation`),
+			Entry("without a colon", "no_colon", `line 1: "web node server.js" is not `+"`name: command`"+`, write "web: node server.js"`),
+			Entry("without a command", "empty_command", "line 2: the worker process has no command"),
+			Entry("defining a process twice", "duplicate", "the web process is defined on lines 1 and 4"),
+		)
+	})
+
+	Describe("CheckProcfile", func() {
+		var (
+			err       error
+			buildDir  string
+			finalizer *finalize.Finalizer
+			buffer    *bytes.Buffer
+		)
+
+		BeforeEach(func() {
+			buildDir, err = ioutil.TempDir("", "nodejs-buildpack.build.")
+			Expect(err).To(BeNil())
+
+			buffer = new(bytes.Buffer)
+			logger := libbuildpack.NewLogger(ansicleaner.New(buffer))
+			finalizer = &finalize.Finalizer{
+				Stager: libbuildpack.NewStager([]string{buildDir, "", "", "0"}, logger, &libbuildpack.Manifest{}),
+				Log:    logger,
+			}
+		})
+
+		AfterEach(func() {
+			Expect(os.RemoveAll(buildDir)).To(Succeed())
+		})
+
+		It("does nothing without a Procfile", func() {
+			Expect(finalizer.CheckProcfile()).To(Succeed())
+			Expect(buffer.String()).To(Equal(""))
+		})
+
+		It("leaves a normalized Procfile alone", func() {
+			Expect(ioutil.WriteFile(filepath.Join(buildDir, "Procfile"), []byte("web: node server.js\n"), 0644)).To(Succeed())
+
+			Expect(finalizer.CheckProcfile()).To(Succeed())
+			Expect(buffer.String()).To(Equal(""))
+		})
+
+		It("writes the Procfile back normalized", func() {
+			Expect(ioutil.WriteFile(filepath.Join(buildDir, "Procfile"), procfile("crlf"), 0644)).To(Succeed())
+
+			Expect(finalizer.CheckProcfile()).To(Succeed())
+			Expect(ioutil.ReadFile(filepath.Join(buildDir, "Procfile"))).To(Equal([]byte("web: node server.js\nworker: node worker.js\n")))
+			Expect(buffer.String()).To(ContainSubstring("Normalized the Procfile: converted the CRLF line endings"))
+		})
+
+		It("fails for an invalid Procfile", func() {
+			Expect(ioutil.WriteFile(filepath.Join(buildDir, "Procfile"), procfile("duplicate"), 0644)).To(Succeed())
+
+			err := finalizer.CheckProcfile()
+			Expect(err).To(MatchError(ContainSubstring("the web process is defined on lines 1 and 4")))
+			Expect(failure.CodeOf(err)).To(Equal(failure.ProcfileInvalid))
+		})
+	})
+})
@@ -0,0 +1,28 @@
+package finalize
+
+import (
+	"fmt"
+	"nodejs/profiled"
+	"path/filepath"
+	"strings"
+)
+
+// WarnProfileCollisions warns about variables which the profile.d scripts of
+// this buildpack and of another buildpack both export, where the one sourced
+// last silently overrides the other.
+func (f *Finalizer) WarnProfileCollisions() error {
+	collisions, err := profiled.Collisions(filepath.Dir(f.Stager.DepDir()), f.Stager.DepsIdx())
+	if err != nil {
+		return err
+	}
+	if len(collisions) == 0 {
+		return nil
+	}
+
+	lines := []string{"Other buildpacks export the same variables as the Node.js buildpack at runtime, the script sourced last wins:"}
+	for _, c := range collisions {
+		lines = append(lines, fmt.Sprintf("  %s: %s and %s", c.Variable, strings.Join(c.Ours, ", "), strings.Join(c.Theirs, ", ")))
+	}
+	f.Log.Warning("%s", strings.Join(lines, "\n"))
+	return nil
+}
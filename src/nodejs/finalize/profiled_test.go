@@ -0,0 +1,62 @@
+package finalize_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"nodejs/finalize"
+	"os"
+	"path/filepath"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/cloudfoundry/libbuildpack/ansicleaner"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("WarnProfileCollisions", func() {
+	var (
+		err       error
+		depsDir   string
+		finalizer *finalize.Finalizer
+		buffer    *bytes.Buffer
+	)
+
+	writeFile := func(path, contents string) {
+		path = filepath.Join(depsDir, path)
+		Expect(os.MkdirAll(filepath.Dir(path), 0755)).To(Succeed())
+		Expect(ioutil.WriteFile(path, []byte(contents), 0755)).To(Succeed())
+	}
+
+	BeforeEach(func() {
+		depsDir, err = ioutil.TempDir("", "nodejs-buildpack.deps.")
+		Expect(err).To(BeNil())
+		writeFile("1/profile.d/001_nodejs_buildpack_node.sh", "export NODE_HOME=$DEPS_DIR/1/node\nexport PATH=$PATH:$NODE_HOME/bin\n")
+
+		buffer = new(bytes.Buffer)
+		logger := libbuildpack.NewLogger(ansicleaner.New(buffer))
+		finalizer = &finalize.Finalizer{
+			Stager: libbuildpack.NewStager([]string{"", "", depsDir, "1"}, logger, &libbuildpack.Manifest{}),
+			Log:    logger,
+		}
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(depsDir)).To(Succeed())
+	})
+
+	It("warns about variables another buildpack exports too", func() {
+		writeFile("0/profile.d/node.sh", "export NODE_HOME=/opt/node\nexport PATH=/opt/node/bin:$PATH\n")
+
+		Expect(finalizer.WarnProfileCollisions()).To(Succeed())
+		Expect(buffer.String()).To(ContainSubstring("Other buildpacks export the same variables as the Node.js buildpack at runtime"))
+		Expect(buffer.String()).To(ContainSubstring("NODE_HOME: 1/profile.d/001_nodejs_buildpack_node.sh and 0/profile.d/node.sh"))
+		Expect(buffer.String()).NotTo(ContainSubstring("PATH:"))
+	})
+
+	It("stays quiet without collisions", func() {
+		writeFile("0/profile.d/java.sh", "export JAVA_HOME=/opt/java\n")
+
+		Expect(finalizer.WarnProfileCollisions()).To(Succeed())
+		Expect(buffer.String()).To(BeEmpty())
+	})
+})
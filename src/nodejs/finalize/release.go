@@ -0,0 +1,90 @@
+package finalize
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ReleaseWrapper is the script, relative to the app dir, which runs the
+// release script of package.json with the environment of the app.
+const ReleaseWrapper = ".cloudfoundry/node-release"
+
+// DefaultReleaseScript is the script ReleaseTask runs unless
+// BP_RELEASE_SCRIPT names another.
+const DefaultReleaseScript = "cf-release"
+
+// ReleaseTask adds a release process type running scripts.cf-release, or the
+// script named by BP_RELEASE_SCRIPT, to the staging metadata, so that the
+// platform runs it, typically to migrate a database, before new instances
+// start. The command is a wrapper which sources the profile.d scripts of the
+// buildpacks first, since it needs the environment the app runs with. Apps
+// without the script get no release process type, and one defined in the
+// Procfile or BP_SCRIPT_PROCESS_TYPES takes precedence.
+func (f *Finalizer) ReleaseTask() error {
+	name := os.Getenv("BP_RELEASE_SCRIPT")
+	if name == "" {
+		name = DefaultReleaseScript
+	}
+
+	pkg, _, err := f.packageJSON()
+	if err != nil {
+		return err
+	}
+	if _, found := pkg.Scripts[name]; !found {
+		if os.Getenv("BP_RELEASE_SCRIPT") != "" {
+			f.Log.Warning("BP_RELEASE_SCRIPT=%s is not a script in package.json, there is no release task", name)
+		}
+		return nil
+	}
+
+	procfileTypes, err := f.procfileProcessTypes()
+	if err != nil {
+		return err
+	}
+	if procfileTypes["release"] {
+		f.Log.Warning("The Procfile defines the release process, ignoring scripts.%s", name)
+		return nil
+	}
+
+	path := filepath.Join(f.Stager.BuildDir(), ProcessTypesFile)
+	types, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if strings.HasPrefix(string(types), "  release:") || strings.Contains(string(types), "\n  release:") {
+		f.Log.Warning("BP_SCRIPT_PROCESS_TYPES defines the release process, ignoring scripts.%s", name)
+		return nil
+	}
+
+	tool, err := f.scriptTool()
+	if err != nil {
+		return err
+	}
+	command := tool + " run " + shellQuote(name)
+	wrapper := []string{
+		"#!/usr/bin/env bash",
+		"# Generated by the nodejs buildpack to run the " + name + " script as the release task,",
+		"# with the environment of the app.",
+		`cd "$(dirname "$0")/.."`,
+		`for script in "$DEPS_DIR"/*/profile.d/*.sh; do`,
+		`  if [[ -f "$script" ]]; then source "$script"; fi`,
+		"done",
+		"exec " + command + ` "$@"`,
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(f.Stager.BuildDir(), ReleaseWrapper), []byte(strings.Join(wrapper, "\n")+"\n"), 0755); err != nil {
+		return err
+	}
+
+	types = append(types, ProcessTypesYAML([]ProcessType{{Name: "release", Command: ReleaseWrapper}})...)
+	if err := ioutil.WriteFile(path, types, 0644); err != nil {
+		return err
+	}
+
+	f.Log.Info("Adding release task: %s", command)
+	return nil
+}
@@ -0,0 +1,124 @@
+package finalize_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"nodejs/finalize"
+	"os"
+	"path/filepath"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/cloudfoundry/libbuildpack/ansicleaner"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Release task", func() {
+	var (
+		err        error
+		buildDir   string
+		finalizer  *finalize.Finalizer
+		buffer     *bytes.Buffer
+		oldRelease string
+	)
+
+	writeFile := func(path, contents string) {
+		path = filepath.Join(buildDir, path)
+		Expect(os.MkdirAll(filepath.Dir(path), 0755)).To(Succeed())
+		Expect(ioutil.WriteFile(path, []byte(contents), 0644)).To(Succeed())
+	}
+
+	readFile := func(path string) string {
+		contents, err := ioutil.ReadFile(filepath.Join(buildDir, path))
+		Expect(err).To(BeNil())
+		return string(contents)
+	}
+
+	BeforeEach(func() {
+		buildDir, err = ioutil.TempDir("", "nodejs-buildpack.build.")
+		Expect(err).To(BeNil())
+
+		oldRelease = os.Getenv("BP_RELEASE_SCRIPT")
+		os.Unsetenv("BP_RELEASE_SCRIPT")
+
+		writeFile("package.json", `{"scripts": {"start": "node server.js", "cf-release": "knex migrate:latest", "db-migrate": "node migrate.js"}}`)
+
+		buffer = new(bytes.Buffer)
+		logger := libbuildpack.NewLogger(ansicleaner.New(buffer))
+		finalizer = &finalize.Finalizer{
+			Stager: libbuildpack.NewStager([]string{buildDir, "", "", "0"}, logger, &libbuildpack.Manifest{}),
+			Log:    logger,
+		}
+	})
+
+	AfterEach(func() {
+		os.Setenv("BP_RELEASE_SCRIPT", oldRelease)
+		Expect(os.RemoveAll(buildDir)).To(Succeed())
+	})
+
+	It("writes a wrapper which sources the profile.d scripts and runs scripts.cf-release", func() {
+		Expect(finalizer.ReleaseTask()).To(Succeed())
+
+		Expect(readFile(".cloudfoundry/node-release")).To(Equal(`#!/usr/bin/env bash
+# Generated by the nodejs buildpack to run the cf-release script as the release task,
+# with the environment of the app.
+cd "$(dirname "$0")/.."
+for script in "$DEPS_DIR"/*/profile.d/*.sh; do
+  if [[ -f "$script" ]]; then source "$script"; fi
+done
+exec npm run 'cf-release' "$@"
+`))
+		info, err := os.Stat(filepath.Join(buildDir, ".cloudfoundry", "node-release"))
+		Expect(err).To(BeNil())
+		Expect(info.Mode().Perm()).To(Equal(os.FileMode(0755)))
+		Expect(buffer.String()).To(ContainSubstring("Adding release task: npm run 'cf-release'"))
+	})
+
+	It("adds the release process type to the staging metadata", func() {
+		writeFile(".cloudfoundry/process-types.yml", "  worker: npm run worker\n")
+
+		Expect(finalizer.ReleaseTask()).To(Succeed())
+		Expect(readFile(".cloudfoundry/process-types.yml")).To(Equal("  worker: npm run worker\n  release: .cloudfoundry/node-release\n"))
+	})
+
+	It("runs the script named by BP_RELEASE_SCRIPT with yarn in a yarn app", func() {
+		os.Setenv("BP_RELEASE_SCRIPT", "db-migrate")
+		writeFile("yarn.lock", "")
+
+		Expect(finalizer.ReleaseTask()).To(Succeed())
+		Expect(readFile(".cloudfoundry/node-release")).To(ContainSubstring("exec yarn run 'db-migrate' \"$@\"\n"))
+		Expect(readFile(".cloudfoundry/process-types.yml")).To(Equal("  release: .cloudfoundry/node-release\n"))
+	})
+
+	It("adds nothing without the script", func() {
+		writeFile("package.json", `{"scripts": {"start": "node server.js"}}`)
+
+		Expect(finalizer.ReleaseTask()).To(Succeed())
+		Expect(filepath.Join(buildDir, ".cloudfoundry", "node-release")).NotTo(BeAnExistingFile())
+		Expect(filepath.Join(buildDir, ".cloudfoundry", "process-types.yml")).NotTo(BeAnExistingFile())
+		Expect(buffer.String()).To(BeEmpty())
+	})
+
+	It("warns when BP_RELEASE_SCRIPT names a missing script", func() {
+		os.Setenv("BP_RELEASE_SCRIPT", "migrate")
+
+		Expect(finalizer.ReleaseTask()).To(Succeed())
+		Expect(filepath.Join(buildDir, ".cloudfoundry", "process-types.yml")).NotTo(BeAnExistingFile())
+		Expect(buffer.String()).To(ContainSubstring("BP_RELEASE_SCRIPT=migrate is not a script in package.json, there is no release task"))
+	})
+
+	It("leaves the release process of the Procfile alone", func() {
+		writeFile("Procfile", "web: node server.js\nrelease: ./migrate.sh\n")
+
+		Expect(finalizer.ReleaseTask()).To(Succeed())
+		Expect(filepath.Join(buildDir, ".cloudfoundry", "node-release")).NotTo(BeAnExistingFile())
+		Expect(buffer.String()).To(ContainSubstring("The Procfile defines the release process, ignoring scripts.cf-release"))
+	})
+
+	It("leaves the release process of BP_SCRIPT_PROCESS_TYPES alone", func() {
+		writeFile(".cloudfoundry/process-types.yml", "  release: npm run release\n")
+
+		Expect(finalizer.ReleaseTask()).To(Succeed())
+		Expect(readFile(".cloudfoundry/process-types.yml")).To(Equal("  release: npm run release\n"))
+	})
+})
@@ -0,0 +1,129 @@
+package finalize
+
+import (
+	"io/ioutil"
+	"nodejs/profiled"
+	"os"
+	"path/filepath"
+)
+
+// serviceURLsScript runs at boot and prints an export for each of
+// DATABASE_URL, REDIS_URL and MONGODB_URL, which the app has not set, from
+// the service bound for it in VCAP_SERVICES. Services match by label or tag,
+// and when several match the one tagged primary wins. Warnings go to stderr,
+// since the profile.d script evals stdout.
+const serviceURLsScript = `'use strict';
+// Installed by the Cloud Foundry Node.js buildpack (BP_EXPORT_SERVICE_URLS=true)
+var kinds = [
+  { env: 'DATABASE_URL', scheme: 'postgres', match: ['postgres', 'postgresql', 'elephantsql'] },
+  { env: 'DATABASE_URL', scheme: 'mysql', match: ['mysql', 'mariadb', 'cleardb'] },
+  { env: 'REDIS_URL', scheme: 'redis', match: ['redis'] },
+  { env: 'MONGODB_URL', scheme: 'mongodb', match: ['mongodb', 'mongo'] }
+];
+
+function warn(message) {
+  process.stderr.write('[service-urls] ' + message + '\n');
+}
+
+function matches(service, kind) {
+  var names = [service.label || ''].concat(service.tags || []).map(function (name) {
+    return String(name).toLowerCase();
+  });
+  return names.some(function (name) {
+    return kind.match.some(function (m) { return name.indexOf(m) !== -1; });
+  });
+}
+
+function url(service, kind) {
+  var c = service.credentials || {};
+  var uri = c.uri || c.url || c.database_uri;
+  if (typeof uri === 'string' && uri !== '') {
+    return uri;
+  }
+  var host = c.host || c.hostname;
+  if (!host) {
+    return '';
+  }
+  var scheme = kind.scheme;
+  if (scheme === 'redis' && (c.tls_enabled === true || c.tls === true)) {
+    scheme = 'rediss';
+  }
+  var user = c.username || c.user || '';
+  var auth = '';
+  if (user !== '' || c.password) {
+    auth = encodeURIComponent(user) + (c.password ? ':' + encodeURIComponent(c.password) : '') + '@';
+  }
+  var port = c.tls_port && scheme === 'rediss' ? c.tls_port : c.port;
+  var name = c.name || c.database || c.db || '';
+  return scheme + '://' + auth + host + (port ? ':' + port : '') + (name !== '' ? '/' + encodeURIComponent(name) : '');
+}
+
+function quote(value) {
+  return "'" + value.replace(/'/g, "'\\''") + "'";
+}
+
+var services = [];
+try {
+  var vcap = JSON.parse(process.env.VCAP_SERVICES || '{}');
+  Object.keys(vcap).forEach(function (offering) {
+    (vcap[offering] || []).forEach(function (service) {
+      services.push(service);
+    });
+  });
+} catch (err) {
+  warn('unable to parse VCAP_SERVICES: ' + err.message);
+}
+
+var found = {};
+kinds.forEach(function (kind) {
+  var bound = services.filter(function (service) { return matches(service, kind); });
+  if (bound.length === 0) {
+    return;
+  }
+  if (bound.length > 1) {
+    var primary = bound.filter(function (service) { return (service.tags || []).indexOf('primary') !== -1; });
+    if (primary.length !== 1) {
+      warn(bound.length + ' ' + kind.scheme + ' services are bound (' + bound.map(function (s) { return s.name; }).join(', ') + '), tag one of them primary to export ' + kind.env);
+      return;
+    }
+    bound = primary;
+  }
+  if (found[kind.env]) {
+    warn(found[kind.env].name + ' and ' + bound[0].name + ' are both bound for ' + kind.env + ', exporting ' + found[kind.env].name);
+    return;
+  }
+  found[kind.env] = bound[0];
+
+  if (process.env[kind.env]) {
+    return;
+  }
+  var value = url(bound[0], kind);
+  if (value === '') {
+    warn(bound[0].name + ' has no uri or host in its credentials to export ' + kind.env + ' from');
+    return;
+  }
+  process.stdout.write('export ' + kind.env + '=' + quote(value) + '\n');
+});
+`
+
+// ExportServiceURLs exports the URLs of the bound databases as the
+// DATABASE_URL, REDIS_URL and MONGODB_URL that frameworks and ORMs read,
+// when BP_EXPORT_SERVICE_URLS is set. Services are bound after staging, so
+// the URLs are taken from VCAP_SERVICES at boot.
+func (f *Finalizer) ExportServiceURLs() error {
+	if os.Getenv("BP_EXPORT_SERVICE_URLS") != "true" {
+		return nil
+	}
+
+	servicesDir := filepath.Join(f.Stager.DepDir(), "services")
+	if err := os.MkdirAll(servicesDir, 0755); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(servicesDir, "urls.js"), []byte(serviceURLsScript), 0644); err != nil {
+		return err
+	}
+
+	depDir := filepath.Join("$DEPS_DIR", f.Stager.DepsIdx())
+	f.Log.Info("Exporting DATABASE_URL, REDIS_URL and MONGODB_URL from the bound services at boot")
+	return profiled.Write(f.Stager, "service_urls.sh", `eval "$("`+depDir+`/bin/node" "`+depDir+`/services/urls.js")"`+"\n")
+}
@@ -0,0 +1,53 @@
+package finalize
+
+import (
+	"fmt"
+	"nodejs/failure"
+	"nodejs/prune"
+	"nodejs/size"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// SizeReportTop is how many of the largest packages the size report lists.
+const SizeReportTop = 10
+
+// CheckNodeModulesSize logs the size of the final node_modules. With
+// BP_MAX_NODE_MODULES_MB it fails the build when node_modules is larger,
+// listing the largest packages so that the team knows what to cut.
+func (f *Finalizer) CheckNodeModulesSize() error {
+	var budget uint64
+	if value := strings.TrimSpace(os.Getenv("BP_MAX_NODE_MODULES_MB")); value != "" {
+		mb, err := strconv.ParseUint(value, 10, 64)
+		if err != nil || mb == 0 {
+			return fmt.Errorf("BP_MAX_NODE_MODULES_MB=%s is not a positive number of MiB", value)
+		}
+		budget = mb * size.MiB
+	}
+
+	nodeModules, err := f.nodeModulesDir()
+	if err != nil {
+		return err
+	}
+	if empty, err := prune.IsEmpty(nodeModules); err != nil {
+		return err
+	} else if empty {
+		f.Log.Info("node_modules is empty, the app has no production dependencies")
+		return nil
+	}
+	report, err := size.Measure(nodeModules)
+	if err != nil {
+		return err
+	}
+
+	if budget == 0 {
+		f.Log.Info("node_modules is %d MiB", report.Total/size.MiB)
+		return nil
+	}
+	if report.Total > budget {
+		return failure.Wrap(failure.NodeModulesTooLarge, fmt.Errorf("node_modules is %d MiB, over the %d MiB of BP_MAX_NODE_MODULES_MB. The largest packages:\n%s", report.Total/size.MiB, budget/size.MiB, report.Lines(SizeReportTop)))
+	}
+	f.Log.Info("node_modules is %d MiB, within the %d MiB of BP_MAX_NODE_MODULES_MB", report.Total/size.MiB, budget/size.MiB)
+	return nil
+}
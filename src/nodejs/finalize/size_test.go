@@ -0,0 +1,90 @@
+package finalize_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"nodejs/failure"
+	"nodejs/finalize"
+	"os"
+	"path/filepath"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/cloudfoundry/libbuildpack/ansicleaner"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("CheckNodeModulesSize", func() {
+	var (
+		err       error
+		buildDir  string
+		finalizer *finalize.Finalizer
+		buffer    *bytes.Buffer
+		oldBudget string
+	)
+
+	file := func(path string, mib int64) {
+		path = filepath.Join(buildDir, "node_modules", path)
+		Expect(os.MkdirAll(filepath.Dir(path), 0755)).To(Succeed())
+		Expect(ioutil.WriteFile(path, nil, 0644)).To(Succeed())
+		Expect(os.Truncate(path, mib*1024*1024)).To(Succeed())
+	}
+
+	BeforeEach(func() {
+		buildDir, err = ioutil.TempDir("", "nodejs-buildpack.build.")
+		Expect(err).To(BeNil())
+
+		oldBudget = os.Getenv("BP_MAX_NODE_MODULES_MB")
+		os.Unsetenv("BP_MAX_NODE_MODULES_MB")
+
+		file("next/dist/index.js", 30)
+		file("@swc/core-linux-x64-gnu/swc.node", 40)
+		file("react/index.js", 2)
+		Expect(os.MkdirAll(filepath.Join(buildDir, "node_modules", "next", "node_modules", ".bin"), 0755)).To(Succeed())
+		Expect(os.Symlink("../..", filepath.Join(buildDir, "node_modules", "next", "node_modules", ".bin", "loop"))).To(Succeed())
+
+		buffer = new(bytes.Buffer)
+		logger := libbuildpack.NewLogger(ansicleaner.New(buffer))
+		finalizer = &finalize.Finalizer{
+			Stager: libbuildpack.NewStager([]string{buildDir, "", "", "0"}, logger, &libbuildpack.Manifest{}),
+			Log:    logger,
+		}
+	})
+
+	AfterEach(func() {
+		os.Setenv("BP_MAX_NODE_MODULES_MB", oldBudget)
+		Expect(os.RemoveAll(buildDir)).To(Succeed())
+	})
+
+	It("logs the size without BP_MAX_NODE_MODULES_MB", func() {
+		Expect(finalizer.CheckNodeModulesSize()).To(Succeed())
+		Expect(buffer.String()).To(ContainSubstring("node_modules is 72 MiB\n"))
+	})
+
+	It("passes under the budget", func() {
+		os.Setenv("BP_MAX_NODE_MODULES_MB", "100")
+		Expect(finalizer.CheckNodeModulesSize()).To(Succeed())
+		Expect(buffer.String()).To(ContainSubstring("node_modules is 72 MiB, within the 100 MiB of BP_MAX_NODE_MODULES_MB"))
+	})
+
+	It("fails over the budget with the largest packages", func() {
+		os.Setenv("BP_MAX_NODE_MODULES_MB", "50")
+		err := finalizer.CheckNodeModulesSize()
+		Expect(err).To(MatchError("node_modules is 72 MiB, over the 50 MiB of BP_MAX_NODE_MODULES_MB. The largest packages:\n  @swc/core-linux-x64-gnu: 40 MiB\n  next: 30 MiB\n  react: 2 MiB"))
+		Expect(failure.CodeOf(err)).To(Equal(failure.NodeModulesTooLarge))
+	})
+
+	It("says so when node_modules is empty", func() {
+		os.Setenv("BP_MAX_NODE_MODULES_MB", "50")
+		Expect(os.RemoveAll(filepath.Join(buildDir, "node_modules"))).To(Succeed())
+		Expect(os.MkdirAll(filepath.Join(buildDir, "node_modules", ".bin"), 0755)).To(Succeed())
+
+		Expect(finalizer.CheckNodeModulesSize()).To(Succeed())
+		Expect(buffer.String()).To(Equal("       node_modules is empty, the app has no production dependencies\n"))
+	})
+
+	It("rejects a budget which is not a number", func() {
+		os.Setenv("BP_MAX_NODE_MODULES_MB", "1GB")
+		Expect(finalizer.CheckNodeModulesSize()).To(MatchError("BP_MAX_NODE_MODULES_MB=1GB is not a positive number of MiB"))
+	})
+})
@@ -0,0 +1,216 @@
+package finalize
+
+import (
+	"io/ioutil"
+	"nodejs/packagejson"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/cloudfoundry/libbuildpack"
+)
+
+// StartWrapper is the script, relative to the app dir, which runs
+// scripts.start without npm or yarn. bin/release uses it in place of
+// `npm start` when it exists.
+const StartWrapper = ".cloudfoundry/node-start"
+
+var (
+	// packageManagerStart matches a start command which runs scripts.start
+	// through npm or yarn, after optional environment assignments.
+	packageManagerStart = regexp.MustCompile(`^((?:\w+=\S*\s+)*)(npm|yarn)\s+(?:run\s+|run-script\s+)?start$`)
+	envAssignment       = regexp.MustCompile(`^\w+=`)
+)
+
+// DirectStartCommand returns the line of a shell script which execs script,
+// when script is a plain `node ...` command. Scripts with pipes, lists,
+// redirections or subshells need a shell of their own and are refused.
+func DirectStartCommand(script string) (string, bool) {
+	if strings.ContainsAny(script, "|&;<>()`\\\n") || strings.Contains(script, "$(") {
+		return "", false
+	}
+
+	fields := strings.Fields(script)
+	for i, field := range fields {
+		if envAssignment.MatchString(field) {
+			continue
+		}
+		if filepath.Base(field) != "node" {
+			return "", false
+		}
+		return strings.Join(append(append([]string{}, fields[:i]...), "exec", strings.Join(fields[i:], " ")), " "), true
+	}
+	return "", false
+}
+
+// DirectStart replaces a start command going through `npm start` or
+// `yarn start` with a wrapper which execs node, since neither forwards
+// SIGTERM to node and the graceful shutdown window of the platform is lost.
+// The default start command and a Procfile web process are both rewritten.
+// BP_NODE_DIRECT_START=false keeps npm and yarn in place.
+func (f *Finalizer) DirectStart() error {
+	if os.Getenv("BP_NODE_DIRECT_START") == "false" {
+		return nil
+	}
+
+	procfile, err := f.readProcfile()
+	if err != nil {
+		return err
+	}
+	command, inProcfile := procfile.Command("web")
+	if !inProcfile {
+		command = "npm start"
+	}
+
+	match := packageManagerStart.FindStringSubmatch(command)
+	if match == nil {
+		return nil
+	}
+	assignments, tool := match[1], match[2]
+
+	pkg, found, err := f.packageJSON()
+	if err != nil || !found {
+		// StartEntrypoint starts an app without package.json.
+		return err
+	}
+
+	script := pkg.Scripts["start"]
+	if script == "" && tool == "npm" {
+		// npm start runs server.js when there is no start script.
+		if found, err := libbuildpack.FileExists(filepath.Join(f.Stager.BuildDir(), "server.js")); err != nil {
+			return err
+		} else if found {
+			script = "node server.js"
+		}
+	}
+	if script == "" {
+		return nil
+	}
+	if binary, err := f.prunedStartBinary(script); err != nil || binary != "" {
+		// WarnNoStart explains the start script which can't run.
+		return err
+	}
+
+	if pkg.Scripts["prestart"] != "" || pkg.Scripts["poststart"] != "" {
+		f.Log.Warning("%s does not forward SIGTERM to node, but is kept since package.json has prestart or poststart scripts\nStart node directly in a Procfile to shut down gracefully", command)
+		return nil
+	}
+
+	exec, ok := DirectStartCommand(script)
+	if !ok {
+		f.Log.Warning("%s does not forward SIGTERM to node, but is kept since the start script %q is not a plain node command\nStart node directly in a Procfile to shut down gracefully", command, script)
+		return nil
+	}
+
+	if err := f.writeWrapper(StartWrapper, "start", tool, exec, pkg); err != nil {
+		return err
+	}
+
+	if inProcfile {
+		procfile.SetCommand("web", assignments+StartWrapper)
+		if err := ioutil.WriteFile(filepath.Join(f.Stager.BuildDir(), ProcfileName), procfile.Bytes(), 0644); err != nil {
+			return err
+		}
+	}
+
+	f.Log.Info("Starting with `%s` instead of `%s`, so that node receives SIGTERM", script, command)
+	return nil
+}
+
+// writeWrapper writes a script to path, relative to the app dir, which sets
+// up the environment npm gives scripts.<event> and runs exec, a line from
+// DirectStartCommand.
+func (f *Finalizer) writeWrapper(path, event, tool, exec string, pkg packagejson.Package) error {
+	wrapper := []string{
+		"#!/usr/bin/env bash",
+		"# Generated by the nodejs buildpack to run the " + event + " script without " + tool + ",",
+		"# so that node receives SIGTERM.",
+		`cd "$(dirname "$0")/.."`,
+		`export PATH="$PWD/node_modules/.bin:$PATH"`,
+		"export npm_lifecycle_event=" + event,
+	}
+	if pkg.Name != "" {
+		wrapper = append(wrapper, "export npm_package_name="+shellQuote(pkg.Name))
+	}
+	if pkg.Version != "" {
+		wrapper = append(wrapper, "export npm_package_version="+shellQuote(pkg.Version))
+	}
+	wrapper = append(wrapper, exec+` "$@"`)
+
+	path = filepath.Join(f.Stager.BuildDir(), path)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, []byte(strings.Join(wrapper, "\n")+"\n"), 0755)
+}
+
+// prunedStartBinary returns the command script runs when it is the
+// executable of a devDependency missing from node_modules/.bin, like `serve`
+// in `serve -s dist` once pruning removed the devDependencies.
+func (f *Finalizer) prunedStartBinary(script string) (string, error) {
+	command := ""
+	for _, field := range strings.Fields(script) {
+		if !envAssignment.MatchString(field) {
+			command = field
+			break
+		}
+	}
+	if command == "" || strings.ContainsAny(command, "/$") {
+		return "", nil
+	}
+
+	devBins, err := f.devBinaries()
+	if err != nil || !devBins[command] {
+		return "", err
+	}
+	nodeModules, err := f.nodeModulesDir()
+	if err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(filepath.Join(nodeModules, ".bin", command)); err == nil {
+		return "", nil
+	} else if !os.IsNotExist(err) {
+		return "", err
+	}
+	return command, nil
+}
+
+// devBinaries returns the names of the executables the devDependencies
+// provide: the names of the packages, and the bins of the dev packages in
+// package-lock.json.
+func (f *Finalizer) devBinaries() (map[string]bool, error) {
+	bins := map[string]bool{}
+
+	pkg, _, err := f.packageJSON()
+	if err != nil {
+		return nil, err
+	}
+	for name := range pkg.DevDependencies {
+		bins[path.Base(name)] = true
+	}
+
+	var lock struct {
+		Packages map[string]struct {
+			Dev bool              `json:"dev"`
+			Bin map[string]string `json:"bin"`
+		} `json:"packages"`
+	}
+	if err := libbuildpack.NewJSON().Load(filepath.Join(f.Stager.BuildDir(), "package-lock.json"), &lock); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for _, dep := range lock.Packages {
+		if !dep.Dev {
+			continue
+		}
+		for name := range dep.Bin {
+			bins[name] = true
+		}
+	}
+	return bins, nil
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
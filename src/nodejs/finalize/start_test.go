@@ -0,0 +1,185 @@
+package finalize_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"nodejs/finalize"
+	"os"
+	"path/filepath"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/cloudfoundry/libbuildpack/ansicleaner"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Direct start", func() {
+	DescribeTable("DirectStartCommand",
+		func(script, expected string, ok bool) {
+			command, found := finalize.DirectStartCommand(script)
+			Expect(found).To(Equal(ok))
+			Expect(command).To(Equal(expected))
+		},
+		Entry("node command", "node server.js", "exec node server.js", true),
+		Entry("node flags", "node --max-old-space-size=512 dist/index.js --port $PORT", "exec node --max-old-space-size=512 dist/index.js --port $PORT", true),
+		Entry("environment assignments", "NODE_ENV=production node server.js", "NODE_ENV=production exec node server.js", true),
+		Entry("node by path", "/usr/bin/node server.js", "exec /usr/bin/node server.js", true),
+		Entry("list", "npm run migrate && node server.js", "", false),
+		Entry("pipe", "node server.js | pino-pretty", "", false),
+		Entry("background", "node worker.js & node server.js", "", false),
+		Entry("command substitution", "node $(cat entry)", "", false),
+		Entry("other program", "nodemon server.js", "", false),
+		Entry("only assignments", "NODE_ENV=production", "", false),
+	)
+
+	Describe("DirectStart", func() {
+		var (
+			err         error
+			buildDir    string
+			finalizer   *finalize.Finalizer
+			buffer      *bytes.Buffer
+			oldEnv      string
+			oldEnvFound bool
+		)
+
+		writeFile := func(path, contents string) {
+			Expect(os.MkdirAll(filepath.Dir(path), 0755)).To(Succeed())
+			Expect(ioutil.WriteFile(path, []byte(contents), 0644)).To(Succeed())
+		}
+
+		readFile := func(path string) string {
+			contents, err := ioutil.ReadFile(path)
+			Expect(err).To(BeNil())
+			return string(contents)
+		}
+
+		wrapper := func() string {
+			return filepath.Join(buildDir, ".cloudfoundry", "node-start")
+		}
+
+		BeforeEach(func() {
+			buildDir, err = ioutil.TempDir("", "nodejs-buildpack.build.")
+			Expect(err).To(BeNil())
+
+			oldEnv, oldEnvFound = os.LookupEnv("BP_NODE_DIRECT_START")
+			os.Unsetenv("BP_NODE_DIRECT_START")
+
+			buffer = new(bytes.Buffer)
+			logger := libbuildpack.NewLogger(ansicleaner.New(buffer))
+			finalizer = &finalize.Finalizer{
+				Stager: libbuildpack.NewStager([]string{buildDir, "", "", "0"}, logger, &libbuildpack.Manifest{}),
+				Log:    logger,
+			}
+		})
+
+		AfterEach(func() {
+			if oldEnvFound {
+				os.Setenv("BP_NODE_DIRECT_START", oldEnv)
+			} else {
+				os.Unsetenv("BP_NODE_DIRECT_START")
+			}
+			Expect(os.RemoveAll(buildDir)).To(Succeed())
+		})
+
+		It("writes a wrapper for the default npm start", func() {
+			writeFile(filepath.Join(buildDir, "package.json"), `{"name":"shop","version":"1.2.0","scripts":{"start":"node dist/server.js"}}`)
+
+			Expect(finalizer.DirectStart()).To(Succeed())
+
+			info, err := os.Stat(wrapper())
+			Expect(err).To(BeNil())
+			Expect(info.Mode() & 0111).NotTo(BeZero())
+			script := readFile(wrapper())
+			Expect(script).To(HavePrefix("#!/usr/bin/env bash\n"))
+			Expect(script).To(ContainSubstring(`export PATH="$PWD/node_modules/.bin:$PATH"`))
+			Expect(script).To(ContainSubstring("export npm_package_name='shop'\n"))
+			Expect(script).To(ContainSubstring("export npm_package_version='1.2.0'\n"))
+			Expect(script).To(HaveSuffix("exec node dist/server.js \"$@\"\n"))
+			Expect(buffer.String()).To(ContainSubstring("Starting with `node dist/server.js` instead of `npm start`"))
+
+			_, err = os.Stat(filepath.Join(buildDir, "Procfile"))
+			Expect(os.IsNotExist(err)).To(BeTrue())
+		})
+
+		It("starts server.js when npm start has no start script", func() {
+			writeFile(filepath.Join(buildDir, "package.json"), `{"name":"shop"}`)
+			writeFile(filepath.Join(buildDir, "server.js"), "")
+
+			Expect(finalizer.DirectStart()).To(Succeed())
+			Expect(readFile(wrapper())).To(HaveSuffix("exec node server.js \"$@\"\n"))
+		})
+
+		It("rewrites yarn start in the Procfile", func() {
+			writeFile(filepath.Join(buildDir, "package.json"), `{"scripts":{"start":"NODE_ENV=production node index.js"}}`)
+			writeFile(filepath.Join(buildDir, "Procfile"), "web: yarn start\nworker: yarn run worker\n")
+
+			Expect(finalizer.DirectStart()).To(Succeed())
+
+			Expect(readFile(filepath.Join(buildDir, "Procfile"))).To(Equal("web: .cloudfoundry/node-start\nworker: yarn run worker\n"))
+			Expect(readFile(wrapper())).To(ContainSubstring("without yarn"))
+			Expect(readFile(wrapper())).To(HaveSuffix("NODE_ENV=production exec node index.js \"$@\"\n"))
+			Expect(buffer.String()).To(ContainSubstring("instead of `yarn start`"))
+		})
+
+		It("keeps the environment assignments of yarn run start in the Procfile", func() {
+			writeFile(filepath.Join(buildDir, "package.json"), `{"scripts":{"start":"node index.js"}}`)
+			writeFile(filepath.Join(buildDir, "Procfile"), "web: NODE_OPTIONS=--enable-source-maps yarn run start")
+
+			Expect(finalizer.DirectStart()).To(Succeed())
+			Expect(readFile(filepath.Join(buildDir, "Procfile"))).To(Equal("web: NODE_OPTIONS=--enable-source-maps .cloudfoundry/node-start"))
+		})
+
+		It("keeps npm start and warns for a complex start script", func() {
+			writeFile(filepath.Join(buildDir, "package.json"), `{"scripts":{"start":"npm run migrate && node server.js"}}`)
+			writeFile(filepath.Join(buildDir, "Procfile"), "web: npm run start\n")
+
+			Expect(finalizer.DirectStart()).To(Succeed())
+
+			Expect(readFile(filepath.Join(buildDir, "Procfile"))).To(Equal("web: npm run start\n"))
+			_, err := os.Stat(wrapper())
+			Expect(os.IsNotExist(err)).To(BeTrue())
+			Expect(buffer.String()).To(ContainSubstring(`npm run start does not forward SIGTERM to node, but is kept since the start script "npm run migrate && node server.js" is not a plain node command`))
+		})
+
+		It("keeps npm start when there are prestart scripts", func() {
+			writeFile(filepath.Join(buildDir, "package.json"), `{"scripts":{"prestart":"node migrate.js","start":"node server.js"}}`)
+
+			Expect(finalizer.DirectStart()).To(Succeed())
+
+			_, err := os.Stat(wrapper())
+			Expect(os.IsNotExist(err)).To(BeTrue())
+			Expect(buffer.String()).To(ContainSubstring("has prestart or poststart scripts"))
+		})
+
+		It("leaves a start script whose devDependency was pruned to WarnNoStart", func() {
+			writeFile(filepath.Join(buildDir, "package.json"), `{"scripts":{"start":"serve -s dist"},"devDependencies":{"serve":"^14.2.1"}}`)
+
+			Expect(finalizer.DirectStart()).To(Succeed())
+			Expect(wrapper()).NotTo(BeAnExistingFile())
+			Expect(buffer.String()).To(BeEmpty())
+		})
+
+		It("leaves other Procfile commands alone", func() {
+			writeFile(filepath.Join(buildDir, "package.json"), `{"scripts":{"start":"node server.js"}}`)
+			writeFile(filepath.Join(buildDir, "Procfile"), "web: npm start -- --port 8080\n")
+
+			Expect(finalizer.DirectStart()).To(Succeed())
+
+			Expect(readFile(filepath.Join(buildDir, "Procfile"))).To(Equal("web: npm start -- --port 8080\n"))
+			_, err := os.Stat(wrapper())
+			Expect(os.IsNotExist(err)).To(BeTrue())
+			Expect(buffer.String()).To(BeEmpty())
+		})
+
+		It("can be turned off with BP_NODE_DIRECT_START", func() {
+			os.Setenv("BP_NODE_DIRECT_START", "false")
+			writeFile(filepath.Join(buildDir, "package.json"), `{"scripts":{"start":"node server.js"}}`)
+
+			Expect(finalizer.DirectStart()).To(Succeed())
+
+			_, err := os.Stat(wrapper())
+			Expect(os.IsNotExist(err)).To(BeTrue())
+		})
+	})
+})
@@ -0,0 +1,49 @@
+package finalize
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/cloudfoundry/libbuildpack"
+)
+
+// FocusWorkspace reduces the dependencies of a yarn 2+ monorepo to the
+// production dependencies of BP_NODE_WORKSPACE. Supply installs every
+// workspace when build scripts need devDependencies, which are no longer
+// needed once the scripts have run.
+func (f *Finalizer) FocusWorkspace() error {
+	workspace := os.Getenv("BP_NODE_WORKSPACE")
+	if workspace == "" {
+		return nil
+	}
+	if found, err := libbuildpack.FileExists(filepath.Join(f.Stager.BuildDir(), "yarn.lock")); err != nil || !found {
+		return err
+	}
+
+	// yarn works on node_modules in the app dir, so bring it back from the
+	// dep dir for the duration of the focus.
+	appNodeModules := filepath.Join(f.Stager.BuildDir(), "node_modules")
+	depNodeModules := filepath.Join(f.Stager.DepDir(), "node_modules")
+	inDepDir, err := libbuildpack.FileExists(depNodeModules)
+	if err != nil {
+		return err
+	}
+	if inDepDir {
+		if inAppDir, err := libbuildpack.FileExists(appNodeModules); err != nil {
+			return err
+		} else if inAppDir {
+			inDepDir = false
+		} else if err := os.Rename(depNodeModules, appNodeModules); err != nil {
+			return err
+		}
+	}
+
+	if err := f.Yarn.FocusWorkspace(f.Stager.BuildDir(), f.Stager.CacheDir(), workspace); err != nil {
+		return err
+	}
+
+	if inDepDir {
+		return os.Rename(appNodeModules, depNodeModules)
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package finalize_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"nodejs/finalize"
+	"os"
+	"path/filepath"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/cloudfoundry/libbuildpack/ansicleaner"
+	"golang.google.cn/x/mock/gomock"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("FocusWorkspace", func() {
+	var (
+		err          error
+		buildDir     string
+		cacheDir     string
+		depsDir      string
+		finalizer    *finalize.Finalizer
+		mockCtrl     *gomock.Controller
+		mockYarn     *MockYarn
+		oldWorkspace string
+	)
+
+	BeforeEach(func() {
+		buildDir, err = ioutil.TempDir("", "nodejs-buildpack.build.")
+		Expect(err).To(BeNil())
+		cacheDir, err = ioutil.TempDir("", "nodejs-buildpack.cache.")
+		Expect(err).To(BeNil())
+		depsDir, err = ioutil.TempDir("", "nodejs-buildpack.deps.")
+		Expect(err).To(BeNil())
+		Expect(os.MkdirAll(filepath.Join(depsDir, "0"), 0755)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(buildDir, "yarn.lock"), []byte{}, 0644)).To(Succeed())
+
+		oldWorkspace = os.Getenv("BP_NODE_WORKSPACE")
+		os.Setenv("BP_NODE_WORKSPACE", "@acme/api")
+
+		logger := libbuildpack.NewLogger(ansicleaner.New(new(bytes.Buffer)))
+		mockCtrl = gomock.NewController(GinkgoT())
+		mockYarn = NewMockYarn(mockCtrl)
+		finalizer = &finalize.Finalizer{
+			Stager: libbuildpack.NewStager([]string{buildDir, cacheDir, depsDir, "0"}, logger, &libbuildpack.Manifest{}),
+			Log:    logger,
+			Yarn:   mockYarn,
+		}
+	})
+
+	AfterEach(func() {
+		mockCtrl.Finish()
+		os.Setenv("BP_NODE_WORKSPACE", oldWorkspace)
+		Expect(os.RemoveAll(buildDir)).To(Succeed())
+		Expect(os.RemoveAll(cacheDir)).To(Succeed())
+		Expect(os.RemoveAll(depsDir)).To(Succeed())
+	})
+
+	It("focuses on the workspace", func() {
+		mockYarn.EXPECT().FocusWorkspace(buildDir, cacheDir, "@acme/api")
+		Expect(finalizer.FocusWorkspace()).To(Succeed())
+	})
+
+	It("focuses node_modules in the dep dir in place", func() {
+		depNodeModules := filepath.Join(depsDir, "0", "node_modules")
+		Expect(os.MkdirAll(filepath.Join(depNodeModules, "express"), 0755)).To(Succeed())
+		mockYarn.EXPECT().FocusWorkspace(buildDir, cacheDir, "@acme/api").DoAndReturn(func(string, string, string) error {
+			Expect(filepath.Join(buildDir, "node_modules", "express")).To(BeADirectory())
+			Expect(os.RemoveAll(filepath.Join(buildDir, "node_modules", "express"))).To(Succeed())
+			return nil
+		})
+
+		Expect(finalizer.FocusWorkspace()).To(Succeed())
+		Expect(depNodeModules).To(BeADirectory())
+		Expect(filepath.Join(depNodeModules, "express")).NotTo(BeADirectory())
+		Expect(filepath.Join(buildDir, "node_modules")).NotTo(BeADirectory())
+	})
+
+	It("does nothing without BP_NODE_WORKSPACE", func() {
+		os.Unsetenv("BP_NODE_WORKSPACE")
+		Expect(finalizer.FocusWorkspace()).To(Succeed())
+	})
+
+	It("does nothing for npm apps", func() {
+		Expect(os.Remove(filepath.Join(buildDir, "yarn.lock"))).To(Succeed())
+		Expect(finalizer.FocusWorkspace()).To(Succeed())
+	})
+})
@@ -0,0 +1,227 @@
+package finalize
+
+import (
+	"fmt"
+	"nodejs/profiled"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/cloudfoundry/libbuildpack"
+)
+
+// WritablePackage is a package which writes below a dir at runtime, and
+// crashes with EROFS or EACCES on a stack where the app user can't write
+// there.
+type WritablePackage struct {
+	Package string
+	// Dir is the dir, relative to the app dir, the package writes to.
+	Dir string
+	// Env names the variable of the dir the package writes to instead, when
+	// it writes to no fixed dir.
+	Env string
+}
+
+// WritablePackages are the packages known to need a writable dir.
+var WritablePackages = []WritablePackage{
+	// libvips spills large images to temporary files.
+	{Package: "sharp", Env: "TMPDIR"},
+	// The default storage dir of node-persist is below the working dir.
+	{Package: "node-persist", Dir: ".node-persist/storage"},
+}
+
+// writableDirEnv are the variables BP_RUNTIME_WRITABLE_DIRS points at a dir,
+// by the base names of the dirs they conventionally point at.
+var writableDirEnv = []struct {
+	Variable string
+	Names    []string
+}{
+	{"TMPDIR", []string{"tmp", ".tmp", "temp", ".temp"}},
+	{"XDG_CACHE_HOME", []string{"cache", ".cache"}},
+}
+
+// ParseWritableDirs parses the comma-separated dirs of
+// BP_RUNTIME_WRITABLE_DIRS, relative to the app dir.
+func ParseWritableDirs(value string) ([]string, error) {
+	var dirs []string
+	for _, dir := range strings.Split(value, ",") {
+		if dir = strings.TrimSpace(dir); dir == "" {
+			continue
+		}
+		clean := filepath.Clean(dir)
+		if filepath.IsAbs(clean) || clean == "." || clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+			return nil, fmt.Errorf("BP_RUNTIME_WRITABLE_DIRS lists %s, which is not a dir inside the app", dir)
+		}
+		dirs = append(dirs, clean)
+	}
+	return dirs, nil
+}
+
+// WritableDirEnv returns the variables which point at dirs, the first of
+// the dirs with the base name each conventionally points at.
+func WritableDirEnv(dirs []string) map[string]string {
+	env := map[string]string{}
+	for _, e := range writableDirEnv {
+		for _, dir := range dirs {
+			if _, found := env[e.Variable]; !found && contains(e.Names, filepath.Base(dir)) {
+				env[e.Variable] = dir
+			}
+		}
+	}
+	return env
+}
+
+// WritableDirsScript returns the profile.d script which exports the
+// variables of env, pointing below the app dir.
+func WritableDirsScript(env map[string]string) string {
+	script := "# The dirs below the app dir BP_RUNTIME_WRITABLE_DIRS made writable.\n"
+	for _, e := range writableDirEnv {
+		if dir, found := env[e.Variable]; found {
+			script += fmt.Sprintf("export %s=\"$HOME/%s\"\n", e.Variable, filepath.ToSlash(dir))
+		}
+	}
+	return script
+}
+
+// UnwritablePackages returns the packages of WritablePackages for which
+// installed returns true, and which would write outside of dirs, the
+// writable dirs, given the variables of env.
+func UnwritablePackages(dirs []string, env map[string]string, installed func(name string) (bool, error)) ([]WritablePackage, error) {
+	var unwritable []WritablePackage
+	for _, pkg := range WritablePackages {
+		found, err := installed(pkg.Package)
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			continue
+		}
+		if pkg.Env != "" {
+			if _, found := env[pkg.Env]; !found {
+				unwritable = append(unwritable, pkg)
+			}
+		} else if !writableBelow(dirs, filepath.Clean(pkg.Dir)) {
+			unwritable = append(unwritable, pkg)
+		}
+	}
+	return unwritable, nil
+}
+
+// writableBelow returns whether dir is one of dirs or below one of them.
+func writableBelow(dirs []string, dir string) bool {
+	for _, writable := range dirs {
+		if dir == writable || strings.HasPrefix(dir, writable+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// SetupWritableDirs creates the dirs of BP_RUNTIME_WRITABLE_DIRS below the
+// app dir, for stacks where the app user can write nowhere else, and points
+// TMPDIR and XDG_CACHE_HOME at those named like them at runtime. It warns
+// about the installed packages known to write elsewhere.
+func (f *Finalizer) SetupWritableDirs() error {
+	dirs, err := ParseWritableDirs(os.Getenv("BP_RUNTIME_WRITABLE_DIRS"))
+	if err != nil || len(dirs) == 0 {
+		return err
+	}
+
+	owner, err := os.Stat(f.Stager.BuildDir())
+	if err != nil {
+		return err
+	}
+	for _, dir := range dirs {
+		base, path := f.writableDirPath(dir)
+		if err := makeWritableDir(base, path, owner); err != nil {
+			return fmt.Errorf("unable to create %s of BP_RUNTIME_WRITABLE_DIRS: %s", dir, err)
+		}
+	}
+
+	env := WritableDirEnv(dirs)
+	var summary []string
+	for _, dir := range dirs {
+		var variables []string
+		for _, e := range writableDirEnv {
+			if env[e.Variable] == dir {
+				variables = append(variables, e.Variable)
+			}
+		}
+		if len(variables) > 0 {
+			dir += " (" + strings.Join(variables, ", ") + ")"
+		}
+		summary = append(summary, dir)
+	}
+	f.Log.Info("Writable at runtime: %s", strings.Join(summary, ", "))
+
+	unwritable, err := UnwritablePackages(dirs, env, f.hasNodeModule)
+	if err != nil {
+		return err
+	}
+	for _, pkg := range unwritable {
+		if pkg.Env != "" {
+			f.Log.Warning("%s writes to %s, which none of BP_RUNTIME_WRITABLE_DIRS points at\nAdd a dir named like .tmp to BP_RUNTIME_WRITABLE_DIRS", pkg.Package, pkg.Env)
+		} else {
+			f.Log.Warning("%s writes to %s, which is not below BP_RUNTIME_WRITABLE_DIRS\nAdd %s to BP_RUNTIME_WRITABLE_DIRS", pkg.Package, pkg.Dir, pkg.Dir)
+		}
+	}
+
+	if len(env) == 0 {
+		return nil
+	}
+	return profiled.Write(f.Stager, "writable_dirs.sh", WritableDirsScript(env))
+}
+
+// writableDirPath returns the dir dir of the app dir is below at staging,
+// and its path. Below node_modules, which the dep dir may hold and profile.d
+// link into the app dir, that is the dep dir.
+func (f *Finalizer) writableDirPath(dir string) (string, string) {
+	if parts := strings.SplitN(dir, string(filepath.Separator), 2); parts[0] == "node_modules" && len(parts) == 2 {
+		if found, _ := libbuildpack.FileExists(filepath.Join(f.Stager.BuildDir(), "node_modules")); !found {
+			if found, _ := libbuildpack.FileExists(filepath.Join(f.Stager.DepDir(), "node_modules")); found {
+				return f.Stager.DepDir(), filepath.Join(f.Stager.DepDir(), dir)
+			}
+		}
+	}
+	return f.Stager.BuildDir(), filepath.Join(f.Stager.BuildDir(), dir)
+}
+
+// makeWritableDir creates path below base writable by its owner. When
+// staging runs as root, path and the dirs it is in below base are given to
+// owner, the owner of the app dir, who runs the app.
+func makeWritableDir(base, path string, owner os.FileInfo) error {
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a dir", path)
+	}
+	if err := os.Chmod(path, info.Mode().Perm()|0700); err != nil {
+		return err
+	}
+
+	stat, ok := owner.Sys().(*syscall.Stat_t)
+	if !ok || os.Geteuid() != 0 {
+		return nil
+	}
+	for ; path != base && strings.HasPrefix(path, base+string(filepath.Separator)); path = filepath.Dir(path) {
+		if err := os.Chown(path, int(stat.Uid), int(stat.Gid)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
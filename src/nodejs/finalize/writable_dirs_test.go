@@ -0,0 +1,157 @@
+package finalize_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"nodejs/finalize"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/cloudfoundry/libbuildpack/ansicleaner"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("SetupWritableDirs", func() {
+	var (
+		err         error
+		buildDir    string
+		depsDir     string
+		finalizer   *finalize.Finalizer
+		buffer      *bytes.Buffer
+		oldWritable string
+	)
+
+	profileScript := func() string {
+		return filepath.Join(depsDir, "0", "profile.d", "000_nodejs_buildpack_writable_dirs.sh")
+	}
+
+	installModule := func(dir, name string) {
+		Expect(os.MkdirAll(filepath.Join(dir, "node_modules", name), 0755)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(dir, "node_modules", name, "package.json"), []byte(`{"name": "`+name+`"}`), 0644)).To(Succeed())
+	}
+
+	BeforeEach(func() {
+		oldWritable = os.Getenv("BP_RUNTIME_WRITABLE_DIRS")
+		os.Unsetenv("BP_RUNTIME_WRITABLE_DIRS")
+
+		buildDir, err = ioutil.TempDir("", "nodejs-buildpack.build.")
+		Expect(err).To(BeNil())
+		depsDir, err = ioutil.TempDir("", "nodejs-buildpack.deps.")
+		Expect(err).To(BeNil())
+		Expect(os.MkdirAll(filepath.Join(depsDir, "0"), 0755)).To(Succeed())
+
+		buffer = new(bytes.Buffer)
+		logger := libbuildpack.NewLogger(ansicleaner.New(buffer))
+		finalizer = &finalize.Finalizer{
+			Stager: libbuildpack.NewStager([]string{buildDir, "", depsDir, "0"}, logger, &libbuildpack.Manifest{}),
+			Log:    logger,
+		}
+	})
+
+	AfterEach(func() {
+		os.Setenv("BP_RUNTIME_WRITABLE_DIRS", oldWritable)
+		Expect(os.RemoveAll(buildDir)).To(Succeed())
+		Expect(os.RemoveAll(depsDir)).To(Succeed())
+	})
+
+	It("does nothing without BP_RUNTIME_WRITABLE_DIRS", func() {
+		installModule(buildDir, "node-persist")
+
+		Expect(finalizer.SetupWritableDirs()).To(Succeed())
+		Expect(profileScript()).NotTo(BeAnExistingFile())
+		Expect(buffer.String()).To(Equal(""))
+	})
+
+	It("creates the dirs writable and exports TMPDIR and XDG_CACHE_HOME", func() {
+		installModule(buildDir, "express")
+		os.Setenv("BP_RUNTIME_WRITABLE_DIRS", "node_modules/.cache, .tmp,compiled/templates")
+
+		Expect(finalizer.SetupWritableDirs()).To(Succeed())
+		for _, dir := range []string{"node_modules/.cache", ".tmp", "compiled/templates"} {
+			info, err := os.Stat(filepath.Join(buildDir, dir))
+			Expect(err).To(BeNil())
+			Expect(info.IsDir()).To(BeTrue())
+			Expect(info.Mode().Perm() & 0700).To(Equal(os.FileMode(0700)))
+		}
+		Expect(buffer.String()).To(ContainSubstring("Writable at runtime: node_modules/.cache (XDG_CACHE_HOME), .tmp (TMPDIR), compiled/templates"))
+
+		cmd := exec.Command("sh", "-c", `. "$0" && printf '%s %s' "$TMPDIR" "$XDG_CACHE_HOME"`, profileScript())
+		cmd.Env = []string{"HOME=/home/vcap/app"}
+		output, err := cmd.CombinedOutput()
+		Expect(err).To(BeNil(), string(output))
+		Expect(string(output)).To(Equal("/home/vcap/app/.tmp /home/vcap/app/node_modules/.cache"))
+	})
+
+	It("makes an existing dir writable", func() {
+		Expect(os.MkdirAll(filepath.Join(buildDir, ".tmp"), 0555)).To(Succeed())
+		os.Setenv("BP_RUNTIME_WRITABLE_DIRS", ".tmp")
+
+		Expect(finalizer.SetupWritableDirs()).To(Succeed())
+		info, err := os.Stat(filepath.Join(buildDir, ".tmp"))
+		Expect(err).To(BeNil())
+		Expect(info.Mode().Perm()).To(Equal(os.FileMode(0755)))
+	})
+
+	It("creates dirs below node_modules in the dep dir which holds it", func() {
+		installModule(filepath.Join(depsDir, "0"), "express")
+		os.Setenv("BP_RUNTIME_WRITABLE_DIRS", "node_modules/.cache")
+
+		Expect(finalizer.SetupWritableDirs()).To(Succeed())
+		Expect(filepath.Join(depsDir, "0", "node_modules", ".cache")).To(BeADirectory())
+		Expect(filepath.Join(buildDir, "node_modules")).NotTo(BeAnExistingFile())
+	})
+
+	It("writes no profile.d script when no dir is named like TMPDIR or XDG_CACHE_HOME", func() {
+		os.Setenv("BP_RUNTIME_WRITABLE_DIRS", "uploads")
+
+		Expect(finalizer.SetupWritableDirs()).To(Succeed())
+		Expect(filepath.Join(buildDir, "uploads")).To(BeADirectory())
+		Expect(profileScript()).NotTo(BeAnExistingFile())
+	})
+
+	It("refuses a dir outside the app", func() {
+		os.Setenv("BP_RUNTIME_WRITABLE_DIRS", ".tmp,../cache")
+		Expect(finalizer.SetupWritableDirs()).To(MatchError("BP_RUNTIME_WRITABLE_DIRS lists ../cache, which is not a dir inside the app"))
+	})
+
+	It("warns about installed packages which write outside of the dirs", func() {
+		installModule(buildDir, "sharp")
+		installModule(buildDir, "node-persist")
+		os.Setenv("BP_RUNTIME_WRITABLE_DIRS", "node_modules/.cache")
+
+		Expect(finalizer.SetupWritableDirs()).To(Succeed())
+		Expect(buffer.String()).To(ContainSubstring("sharp writes to TMPDIR, which none of BP_RUNTIME_WRITABLE_DIRS points at"))
+		Expect(buffer.String()).To(ContainSubstring("node-persist writes to .node-persist/storage, which is not below BP_RUNTIME_WRITABLE_DIRS"))
+	})
+
+	DescribeTable("UnwritablePackages",
+		func(dirs []string, installed []string, expected []string) {
+			isInstalled := func(name string) (bool, error) {
+				for _, i := range installed {
+					if i == name {
+						return true, nil
+					}
+				}
+				return false, nil
+			}
+
+			unwritable, err := finalize.UnwritablePackages(dirs, finalize.WritableDirEnv(dirs), isInstalled)
+			Expect(err).To(BeNil())
+			var names []string
+			for _, pkg := range unwritable {
+				names = append(names, pkg.Package)
+			}
+			Expect(names).To(Equal(expected))
+		},
+		Entry("none installed", []string{"cache"}, nil, nil),
+		Entry("sharp without TMPDIR", []string{".cache"}, []string{"sharp"}, []string{"sharp"}),
+		Entry("sharp with TMPDIR", []string{"tmp"}, []string{"sharp"}, nil),
+		Entry("node-persist outside of the dirs", []string{".tmp", ".node-persist/cache"}, []string{"node-persist"}, []string{"node-persist"}),
+		Entry("node-persist with its dir", []string{".node-persist/storage"}, []string{"node-persist"}, nil),
+		Entry("node-persist below a dir", []string{".node-persist"}, []string{"sharp", "node-persist"}, []string{"sharp"}),
+	)
+})
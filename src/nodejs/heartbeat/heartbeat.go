@@ -0,0 +1,228 @@
+package heartbeat
+
+import (
+	"io"
+	"nodejs/duration"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudfoundry/libbuildpack"
+)
+
+// DefaultInterval is how long an operation may be silent before a heartbeat
+// line is logged, unless BP_HEARTBEAT_INTERVAL sets another, a number of
+// seconds or a duration like 30s.
+const DefaultInterval = 30 * time.Second
+
+// progressPoll is how often a Runner asks for progress while Report is on.
+const progressPoll = time.Second
+
+// progress is the line of progress which Runners log, see Report.
+var progress struct {
+	sync.Mutex
+	line func() (string, bool)
+}
+
+// Report makes Runners log the line that line returns, when it returns one,
+// between the lines of the output of the operations they run, until stop is
+// called. line is asked every second, and has to limit its own rate.
+func Report(line func() (string, bool)) (stop func()) {
+	progress.Lock()
+	progress.line = line
+	progress.Unlock()
+	return func() {
+		progress.Lock()
+		progress.line = nil
+		progress.Unlock()
+	}
+}
+
+func progressLine() func() (string, bool) {
+	progress.Lock()
+	defer progress.Unlock()
+	return progress.line
+}
+
+type Command interface {
+	Execute(dir string, stdout io.Writer, stderr io.Writer, program string, args ...string) error
+	Run(cmd *exec.Cmd) error
+}
+
+// Clock is the time source of a Runner, replaced by a fake one in tests.
+type Clock interface {
+	Now() time.Time
+	After(time.Duration) <-chan time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// Runner wraps a Command and logs a `still running` line whenever the
+// command has not written any output for Interval, so that platforms which
+// kill silent stagings keep waiting. An Interval of 0 disables heartbeats.
+type Runner struct {
+	Command  Command
+	Log      *libbuildpack.Logger
+	Interval time.Duration
+	Clock    Clock
+}
+
+// New returns a Runner for command with the interval from
+// BP_HEARTBEAT_INTERVAL.
+func New(command Command, log *libbuildpack.Logger) *Runner {
+	interval := DefaultInterval
+	if value := os.Getenv("BP_HEARTBEAT_INTERVAL"); value != "" {
+		if d, err := duration.Parse(value); err == nil {
+			interval = d
+		} else {
+			log.Warning("Ignoring invalid BP_HEARTBEAT_INTERVAL %q, expected a number of seconds or a duration like 30s", value)
+		}
+	}
+	return &Runner{Command: command, Log: log, Interval: interval, Clock: realClock{}}
+}
+
+// Watch logs heartbeats for a long running operation without output of its
+// own, such as copying a cache.
+func Watch(log *libbuildpack.Logger, name string, f func() error) error {
+	return New(nil, log).Watch(name, f)
+}
+
+func (r *Runner) Execute(dir string, stdout io.Writer, stderr io.Writer, program string, args ...string) error {
+	m := r.monitor()
+	return r.watch(m, commandName(program, args), func() error {
+		return r.Command.Execute(dir, m.writer(stdout), m.writer(stderr), program, args...)
+	})
+}
+
+func (r *Runner) Run(cmd *exec.Cmd) error {
+	m := r.monitor()
+	if cmd.Stdout != nil {
+		cmd.Stdout = m.writer(cmd.Stdout)
+	}
+	if cmd.Stderr != nil {
+		cmd.Stderr = m.writer(cmd.Stderr)
+	}
+	return r.watch(m, commandName(filepath.Base(cmd.Path), cmd.Args[1:]), func() error {
+		return r.Command.Run(cmd)
+	})
+}
+
+// Watch runs f, logging heartbeats while it runs.
+func (r *Runner) Watch(name string, f func() error) error {
+	return r.watch(r.monitor(), name, f)
+}
+
+func (r *Runner) monitor() *monitor {
+	return &monitor{clock: r.Clock, last: r.Clock.Now()}
+}
+
+func (r *Runner) watch(m *monitor, name string, f func() error) error {
+	if r.Interval <= 0 && progressLine() == nil {
+		return f()
+	}
+
+	start := m.last
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		for {
+			// The line of progress is asked for outside the lock, so that
+			// counting does not hold up the output of the command.
+			line, report := "", false
+			if progress := progressLine(); progress != nil {
+				line, report = progress()
+			}
+
+			m.mu.Lock()
+			now := r.Clock.Now()
+			// A line in the middle of a line of the command's output would
+			// garble it, so wait for the line to end.
+			if report && m.midLine == nil {
+				r.Log.Info("%s", line)
+				m.last = now
+			}
+			wait := progressPoll
+			if r.Interval > 0 {
+				beat := m.last.Add(r.Interval).Sub(now)
+				if beat <= 0 {
+					// A line which has been silent for a whole interval may
+					// never end, e.g. a prompt, so it is ended for the
+					// heartbeat.
+					if m.midLine != nil {
+						m.midLine.Write([]byte("\n"))
+						m.midLine = nil
+					}
+					r.Log.Info("still running: %s (%s elapsed)", name, now.Sub(start).Round(time.Second))
+					m.last = now
+					beat = r.Interval
+				}
+				if progressLine() == nil || beat < wait {
+					wait = beat
+				}
+			}
+			m.mu.Unlock()
+
+			select {
+			case <-done:
+				return
+			case <-r.Clock.After(wait):
+			}
+		}
+	}()
+
+	err := f()
+	close(done)
+	<-stopped
+	return err
+}
+
+// commandName is the program and its subcommand, e.g. `npm ci`.
+func commandName(program string, args []string) string {
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		return program + " " + args[0]
+	}
+	return program
+}
+
+// monitor records when output was last written, and serializes that output
+// with the heartbeats. midLine is the writer whose last write did not end a
+// line, if any.
+type monitor struct {
+	mu      sync.Mutex
+	clock   Clock
+	last    time.Time
+	midLine io.Writer
+}
+
+func (m *monitor) writer(w io.Writer) io.Writer {
+	if w == nil {
+		return nil
+	}
+	return &activityWriter{monitor: m, w: w}
+}
+
+type activityWriter struct {
+	monitor *monitor
+	w       io.Writer
+}
+
+func (a *activityWriter) Write(p []byte) (int, error) {
+	a.monitor.mu.Lock()
+	defer a.monitor.mu.Unlock()
+
+	if len(p) > 0 {
+		a.monitor.last = a.monitor.clock.Now()
+		a.monitor.midLine = nil
+		if p[len(p)-1] != '\n' {
+			a.monitor.midLine = a.w
+		}
+	}
+	return a.w.Write(p)
+}
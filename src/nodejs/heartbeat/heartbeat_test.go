@@ -0,0 +1,312 @@
+package heartbeat_test
+
+import (
+	"bytes"
+	"io"
+	"nodejs/heartbeat"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/cloudfoundry/libbuildpack/ansicleaner"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type waiter struct {
+	deadline time.Time
+	ch       chan time.Time
+}
+
+type fakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []waiter
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ch := make(chan time.Time, 1)
+	c.waiters = append(c.waiters, waiter{deadline: c.now.Add(d), ch: ch})
+	return ch
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	var pending []waiter
+	for _, w := range c.waiters {
+		if w.deadline.After(c.now) {
+			pending = append(pending, w)
+		} else {
+			w.ch <- c.now
+		}
+	}
+	c.waiters = pending
+}
+
+func (c *fakeClock) Waiters() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.waiters)
+}
+
+// fakeCommand writes each string sent on output until output is closed.
+type fakeCommand struct {
+	output  chan string
+	written chan bool
+}
+
+func (f *fakeCommand) write(w io.Writer) error {
+	for s := range f.output {
+		io.WriteString(w, s)
+		f.written <- true
+	}
+	return nil
+}
+
+func (f *fakeCommand) Execute(dir string, stdout io.Writer, stderr io.Writer, program string, args ...string) error {
+	return f.write(stdout)
+}
+
+func (f *fakeCommand) Run(cmd *exec.Cmd) error {
+	return f.write(cmd.Stdout)
+}
+
+var _ = Describe("Heartbeat", func() {
+	var (
+		buffer  *bytes.Buffer
+		logger  *libbuildpack.Logger
+		clock   *fakeClock
+		command *fakeCommand
+		runner  *heartbeat.Runner
+		done    chan error
+	)
+
+	BeforeEach(func() {
+		buffer = new(bytes.Buffer)
+		logger = libbuildpack.NewLogger(ansicleaner.New(buffer))
+		clock = &fakeClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+		command = &fakeCommand{output: make(chan string), written: make(chan bool)}
+		runner = &heartbeat.Runner{Command: command, Log: logger, Interval: 30 * time.Second, Clock: clock}
+		done = make(chan error, 1)
+	})
+
+	write := func(s string) {
+		command.output <- s
+		<-command.written
+	}
+
+	// advance moves the clock and waits for the heartbeat to wait again.
+	advance := func(d time.Duration) {
+		clock.Advance(d)
+		Eventually(clock.Waiters).Should(Equal(1))
+	}
+
+	Describe("Execute", func() {
+		BeforeEach(func() {
+			go func() {
+				done <- runner.Execute("/app", logger.Output(), logger.Output(), "npm", "ci", "--quiet")
+			}()
+			Eventually(clock.Waiters).Should(Equal(1))
+		})
+
+		AfterEach(func() {
+			close(command.output)
+			Eventually(done).Should(Receive(BeNil()))
+		})
+
+		It("logs while the command is silent", func() {
+			advance(29 * time.Second)
+			Expect(buffer.String()).To(Equal(""))
+
+			advance(time.Second)
+			Expect(buffer.String()).To(Equal("       still running: npm ci (30s elapsed)\n"))
+
+			advance(100 * time.Second)
+			Expect(buffer.String()).To(HaveSuffix("       still running: npm ci (2m10s elapsed)\n"))
+		})
+
+		It("waits for a full interval of silence after output", func() {
+			advance(20 * time.Second)
+			write("added 1 package\n")
+			advance(20 * time.Second)
+			Expect(buffer.String()).To(Equal("added 1 package\n"))
+
+			advance(10 * time.Second)
+			Expect(buffer.String()).To(Equal("added 1 package\n       still running: npm ci (50s elapsed)\n"))
+		})
+
+		It("ends a line of output which is silent for an interval", func() {
+			write("Continue? [y/N] ")
+			advance(29 * time.Second)
+			Expect(buffer.String()).To(Equal("Continue? [y/N] "))
+
+			advance(time.Second)
+			Expect(buffer.String()).To(Equal("Continue? [y/N] \n       still running: npm ci (30s elapsed)\n"))
+
+			write("y\n")
+			Expect(buffer.String()).To(HaveSuffix("elapsed)\ny\n"))
+		})
+	})
+
+	Describe("Report", func() {
+		var (
+			lines chan string
+			stop  func()
+		)
+
+		// seconds advances the clock a second at a time, as often as the
+		// progress is asked for.
+		seconds := func(n int) {
+			for i := 0; i < n; i++ {
+				advance(time.Second)
+			}
+		}
+
+		BeforeEach(func() {
+			lines = make(chan string, 1)
+			stop = heartbeat.Report(func() (string, bool) {
+				select {
+				case line := <-lines:
+					return line, true
+				default:
+					return "", false
+				}
+			})
+			go func() {
+				done <- runner.Execute("/app", logger.Output(), logger.Output(), "npm", "ci")
+			}()
+			Eventually(clock.Waiters).Should(Equal(1))
+		})
+
+		AfterEach(func() {
+			stop()
+			close(command.output)
+			Eventually(done).Should(Receive(BeNil()))
+		})
+
+		It("logs the progress between the lines of output", func() {
+			write("added 1 package\n")
+			lines <- "installed 1/3 packages"
+			seconds(1)
+			Expect(buffer.String()).To(Equal("added 1 package\n       installed 1/3 packages\n"))
+		})
+
+		It("does not interrupt a line of output", func() {
+			write("downloading")
+			lines <- "installed 2/3 packages"
+			seconds(1)
+			Expect(buffer.String()).To(Equal("downloading"))
+		})
+
+		It("counts as a heartbeat", func() {
+			seconds(20)
+			lines <- "installed 2/3 packages"
+			seconds(30)
+			Expect(buffer.String()).To(Equal("       installed 2/3 packages\n"))
+
+			seconds(1)
+			Expect(buffer.String()).To(HaveSuffix("       still running: npm ci (51s elapsed)\n"))
+		})
+
+		It("stops with stop", func() {
+			stop()
+			lines <- "installed 3/3 packages"
+			advance(30 * time.Second)
+			Expect(buffer.String()).To(Equal("       still running: npm ci (30s elapsed)\n"))
+		})
+	})
+
+	It("stops when the command finishes", func() {
+		go func() {
+			done <- runner.Execute("/app", logger.Output(), logger.Output(), "npm", "ci")
+		}()
+		Eventually(clock.Waiters).Should(Equal(1))
+
+		close(command.output)
+		Eventually(done).Should(Receive(BeNil()))
+
+		clock.Advance(time.Minute)
+		Expect(buffer.String()).To(Equal(""))
+	})
+
+	It("wraps the output of Run", func() {
+		cmd := exec.Command("yarn", "install", "--frozen-lockfile")
+		cmd.Stdout = logger.Output()
+		go func() {
+			done <- runner.Run(cmd)
+		}()
+		Eventually(clock.Waiters).Should(Equal(1))
+
+		write("[1/4] Resolving packages...\n")
+		advance(30 * time.Second)
+		Expect(buffer.String()).To(Equal("[1/4] Resolving packages...\n       still running: yarn install (30s elapsed)\n"))
+
+		close(command.output)
+		Eventually(done).Should(Receive(BeNil()))
+	})
+
+	It("watches operations without output", func() {
+		release := make(chan bool)
+		go func() {
+			done <- runner.Watch("saving the cache", func() error {
+				<-release
+				return nil
+			})
+		}()
+		Eventually(clock.Waiters).Should(Equal(1))
+
+		advance(45 * time.Second)
+		Expect(buffer.String()).To(Equal("       still running: saving the cache (45s elapsed)\n"))
+
+		close(release)
+		Eventually(done).Should(Receive(BeNil()))
+	})
+
+	It("is disabled with an interval of 0", func() {
+		runner.Interval = 0
+		Expect(runner.Watch("saving the cache", func() error { return nil })).To(Succeed())
+		Expect(clock.Waiters()).To(Equal(0))
+	})
+
+	Describe("New", func() {
+		var oldInterval string
+
+		BeforeEach(func() {
+			oldInterval = os.Getenv("BP_HEARTBEAT_INTERVAL")
+		})
+
+		AfterEach(func() {
+			os.Setenv("BP_HEARTBEAT_INTERVAL", oldInterval)
+		})
+
+		It("defaults to 30 seconds", func() {
+			os.Setenv("BP_HEARTBEAT_INTERVAL", "")
+			Expect(heartbeat.New(command, logger).Interval).To(Equal(30 * time.Second))
+		})
+
+		It("reads BP_HEARTBEAT_INTERVAL", func() {
+			os.Setenv("BP_HEARTBEAT_INTERVAL", "10")
+			Expect(heartbeat.New(command, logger).Interval).To(Equal(10 * time.Second))
+			os.Setenv("BP_HEARTBEAT_INTERVAL", "1m")
+			Expect(heartbeat.New(command, logger).Interval).To(Equal(time.Minute))
+		})
+
+		It("warns about invalid intervals", func() {
+			os.Setenv("BP_HEARTBEAT_INTERVAL", "soon")
+			Expect(heartbeat.New(command, logger).Interval).To(Equal(30 * time.Second))
+			Expect(buffer.String()).To(ContainSubstring("Ignoring invalid BP_HEARTBEAT_INTERVAL \"soon\""))
+		})
+	})
+})
@@ -0,0 +1,140 @@
+package hooks
+
+import (
+	"nodejs/profiled"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cloudfoundry/libbuildpack"
+)
+
+// AppSignalAgent is the npm package of the AppSignal agent.
+const AppSignalAgent = "@appsignal/nodejs"
+
+// appSignalKeys are the credentials which hold the push API key, in order.
+var appSignalKeys = []string{"push_api_key", "pushApiKey", "APPSIGNAL_PUSH_API_KEY"}
+
+// AppSignalHook activates AppSignal for a bound appsignal service or
+// APPSIGNAL_PUSH_API_KEY. It exports the config of the agent at boot and
+// preloads it with the mechanism PreloadFlags picks for the installed Node.js.
+type AppSignalHook struct {
+	libbuildpack.DefaultHook
+	Log *libbuildpack.Logger
+}
+
+func init() {
+	AddIsolatedHook("appsignal", func(logger *libbuildpack.Logger) libbuildpack.Hook {
+		return AppSignalHook{Log: logger}
+	})
+}
+
+// Active reports whether APPSIGNAL_PUSH_API_KEY is set or a single appsignal
+// service with a push API key is bound.
+func (h AppSignalHook) Active() bool {
+	if os.Getenv("APPSIGNAL_PUSH_API_KEY") != "" {
+		return true
+	}
+	_, found := h.service(LoadVCAPServices(h.Log))
+	return found
+}
+
+func (h AppSignalHook) AfterCompile(stager *libbuildpack.Stager) error {
+	var service VCAPService
+	if os.Getenv("APPSIGNAL_PUSH_API_KEY") == "" {
+		var found bool
+		if service, found = h.service(LoadVCAPServices(h.Log)); !found {
+			h.Log.Debug("Neither APPSIGNAL_PUSH_API_KEY nor an appsignal service found")
+			return nil
+		}
+	}
+
+	installed := false
+	for _, dir := range []string{stager.BuildDir(), stager.DepDir()} {
+		found, err := libbuildpack.FileExists(filepath.Join(dir, "node_modules", AppSignalAgent, "package.json"))
+		if err != nil {
+			return err
+		}
+		installed = installed || found
+	}
+	if !installed {
+		h.Log.Warning("AppSignal is configured, but %s is not installed\nAdd %s to the dependencies in package.json", AppSignalAgent, AppSignalAgent)
+		return nil
+	}
+
+	// The key is read from VCAP_SERVICES at boot, so that it stays out of
+	// the droplet and follows a rebinding.
+	var script []string
+	if service.Name != "" {
+		h.Log.Info("Activating AppSignal with the push API key of the %s service", service.Name)
+		script = append(script,
+			`if [ -z "${APPSIGNAL_PUSH_API_KEY-}" ]; then`,
+			`  export APPSIGNAL_PUSH_API_KEY=$(echo "${VCAP_SERVICES-}" | jq -r --arg name `+shellQuote(service.Name)+` '[.[][] | select(.name == $name)][0].credentials | `+appSignalKeysFilter()+` // empty')`,
+			`fi`)
+	} else {
+		h.Log.Info("Activating AppSignal with APPSIGNAL_PUSH_API_KEY")
+	}
+	script = append(script,
+		`if [ -z "${APPSIGNAL_APP_NAME-}" ]; then`,
+		`  export APPSIGNAL_APP_NAME=$(echo "${VCAP_APPLICATION-}" | jq -r '.application_name // .name // empty')`,
+		`fi`,
+		`export APPSIGNAL_APP_ENV="${APPSIGNAL_APP_ENV:-${NODE_ENV:-production}}"`)
+
+	// BP_APM_PRELOAD preloads the agent already when it lists it.
+	preloaded := false
+	for _, agent := range preloadAgents() {
+		preloaded = preloaded || agent == AppSignalAgent
+	}
+	if !preloaded {
+		nodeVersion := installedNodeVersion(stager.DepDir())
+		flags, mechanism := PreloadFlags(nodeVersion, AppSignalAgent)
+		h.Log.Info("Preloading %s with %s for Node.js %s: %s", AppSignalAgent, mechanism, nodeVersion, strings.Join(flags, " "))
+		script = append(script, `export NODE_OPTIONS="${NODE_OPTIONS:+$NODE_OPTIONS }`+strings.Join(flags, " ")+`"`)
+	}
+
+	return profiled.Write(stager, "appsignal.sh", strings.Join(script, "\n")+"\n")
+}
+
+// service returns the single bound service named, labelled or tagged
+// appsignal which has a push API key.
+func (h AppSignalHook) service(vcapServices VCAPServices) (VCAPService, bool) {
+	var detected []VCAPService
+	for _, service := range vcapServices.All() {
+		if !isAppSignal(service) {
+			continue
+		}
+		for _, key := range appSignalKeys {
+			if service.CredentialString(key) != "" {
+				detected = append(detected, service)
+				break
+			}
+		}
+	}
+
+	if len(detected) == 1 {
+		h.Log.Debug("Found one matching service: %s", detected[0].Name)
+		return detected[0], true
+	} else if len(detected) > 1 {
+		h.Log.Warning("More than one appsignal service found, set APPSIGNAL_PUSH_API_KEY to pick the key")
+	}
+	return VCAPService{}, false
+}
+
+func isAppSignal(service VCAPService) bool {
+	names := append([]string{service.Name, service.Label}, service.Tags...)
+	for _, name := range names {
+		if strings.Contains(strings.ToLower(name), "appsignal") {
+			return true
+		}
+	}
+	return false
+}
+
+// appSignalKeysFilter is the jq filter for the first of appSignalKeys set.
+func appSignalKeysFilter() string {
+	var filters []string
+	for _, key := range appSignalKeys {
+		filters = append(filters, "."+key)
+	}
+	return strings.Join(filters, " // ")
+}
@@ -0,0 +1,145 @@
+package hooks_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/cloudfoundry/libbuildpack/ansicleaner"
+
+	"nodejs/hooks"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("AppSignalHook", func() {
+	const vcapServices = `{"user-provided": [
+		{"name": "monitoring", "label": "user-provided", "tags": ["appsignal"], "credentials": {"push_api_key": "it's-secret"}},
+		{"name": "smtp", "label": "user-provided", "tags": [], "credentials": {"uri": "smtp://mail.internal"}}
+	]}`
+
+	var (
+		err      error
+		buildDir string
+		depsDir  string
+		buffer   *bytes.Buffer
+		stager   *libbuildpack.Stager
+		hook     hooks.AppSignalHook
+		oldEnv   map[string]string
+	)
+
+	writeFile := func(path, contents string) {
+		Expect(os.MkdirAll(filepath.Dir(path), 0755)).To(Succeed())
+		Expect(ioutil.WriteFile(path, []byte(contents), 0644)).To(Succeed())
+	}
+
+	script := func() string {
+		contents, err := ioutil.ReadFile(filepath.Join(depsDir, "0", "profile.d", "000_nodejs_buildpack_appsignal.sh"))
+		Expect(err).To(BeNil())
+		return string(contents)
+	}
+
+	// boot sources the profile.d script with the environment of the app and
+	// returns the AppSignal config it sees.
+	boot := func(env ...string) string {
+		if _, err := exec.LookPath("jq"); err != nil {
+			Skip("jq is not installed")
+		}
+		cmd := exec.Command("bash", "-c", `. "$0" && echo "$APPSIGNAL_PUSH_API_KEY|$APPSIGNAL_APP_NAME|$APPSIGNAL_APP_ENV"`, filepath.Join(depsDir, "0", "profile.d", "000_nodejs_buildpack_appsignal.sh"))
+		cmd.Env = append([]string{"PATH=" + os.Getenv("PATH")}, env...)
+		output, err := cmd.CombinedOutput()
+		Expect(err).To(BeNil(), string(output))
+		return string(output)
+	}
+
+	BeforeEach(func() {
+		oldEnv = map[string]string{}
+		for _, key := range []string{"APPSIGNAL_PUSH_API_KEY", "VCAP_SERVICES", "BP_APM_PRELOAD"} {
+			oldEnv[key] = os.Getenv(key)
+			os.Unsetenv(key)
+		}
+
+		buildDir, err = ioutil.TempDir("", "nodejs-buildpack.build.")
+		Expect(err).To(BeNil())
+		depsDir, err = ioutil.TempDir("", "nodejs-buildpack.deps.")
+		Expect(err).To(BeNil())
+		writeFile(filepath.Join(depsDir, "0", "node", "include", "node", "node_version.h"), "#define NODE_MAJOR_VERSION 20\n#define NODE_MINOR_VERSION 11\n#define NODE_PATCH_VERSION 1\n")
+		writeFile(filepath.Join(buildDir, "node_modules", "@appsignal", "nodejs", "package.json"), "{}")
+
+		buffer = new(bytes.Buffer)
+		logger := libbuildpack.NewLogger(ansicleaner.New(buffer))
+		stager = libbuildpack.NewStager([]string{buildDir, "", depsDir, "0"}, logger, &libbuildpack.Manifest{})
+		hook = hooks.AppSignalHook{Log: logger}
+	})
+
+	AfterEach(func() {
+		for key, value := range oldEnv {
+			os.Setenv(key, value)
+		}
+		Expect(os.RemoveAll(buildDir)).To(Succeed())
+		Expect(os.RemoveAll(depsDir)).To(Succeed())
+	})
+
+	It("does nothing when AppSignal is not configured", func() {
+		os.Setenv("VCAP_SERVICES", `{"user-provided": [{"name": "appsignal", "credentials": {}}]}`)
+
+		Expect(hook.Active()).To(BeFalse())
+		Expect(hook.AfterCompile(stager)).To(Succeed())
+		Expect(filepath.Join(depsDir, "0", "profile.d")).NotTo(BeAnExistingFile())
+	})
+
+	It("activates for a bound appsignal service and reads its key at boot", func() {
+		os.Setenv("VCAP_SERVICES", vcapServices)
+
+		Expect(hook.Active()).To(BeTrue())
+		Expect(hook.AfterCompile(stager)).To(Succeed())
+		Expect(script()).NotTo(ContainSubstring("secret"))
+		Expect(script()).To(ContainSubstring(`export NODE_OPTIONS="${NODE_OPTIONS:+$NODE_OPTIONS }--import @appsignal/nodejs"`))
+		Expect(buffer.String()).To(ContainSubstring("Activating AppSignal with the push API key of the monitoring service"))
+		Expect(buffer.String()).To(ContainSubstring("Preloading @appsignal/nodejs with --import for Node.js 20.11.1: --import @appsignal/nodejs"))
+
+		Expect(boot("VCAP_SERVICES="+vcapServices, `VCAP_APPLICATION={"application_name": "orders"}`)).To(Equal("it's-secret|orders|production\n"))
+		Expect(boot("VCAP_SERVICES="+vcapServices, "APPSIGNAL_APP_NAME=Orders", "NODE_ENV=staging")).To(Equal("it's-secret|Orders|staging\n"))
+	})
+
+	It("activates for APPSIGNAL_PUSH_API_KEY", func() {
+		os.Setenv("APPSIGNAL_PUSH_API_KEY", "from-env")
+
+		Expect(hook.Active()).To(BeTrue())
+		Expect(hook.AfterCompile(stager)).To(Succeed())
+		Expect(script()).NotTo(ContainSubstring("VCAP_SERVICES"))
+		Expect(buffer.String()).To(ContainSubstring("Activating AppSignal with APPSIGNAL_PUSH_API_KEY"))
+
+		Expect(boot("APPSIGNAL_PUSH_API_KEY=from-env", `VCAP_APPLICATION={"name": "orders"}`)).To(Equal("from-env|orders|production\n"))
+	})
+
+	It("leaves the preload to BP_APM_PRELOAD when it lists the agent", func() {
+		os.Setenv("APPSIGNAL_PUSH_API_KEY", "from-env")
+		os.Setenv("BP_APM_PRELOAD", "@appsignal/nodejs")
+
+		Expect(hook.AfterCompile(stager)).To(Succeed())
+		Expect(script()).NotTo(ContainSubstring("NODE_OPTIONS"))
+	})
+
+	It("warns when the agent is not installed", func() {
+		os.Setenv("APPSIGNAL_PUSH_API_KEY", "from-env")
+		Expect(os.RemoveAll(filepath.Join(buildDir, "node_modules"))).To(Succeed())
+
+		Expect(hook.AfterCompile(stager)).To(Succeed())
+		Expect(buffer.String()).To(ContainSubstring("AppSignal is configured, but @appsignal/nodejs is not installed"))
+		Expect(buffer.String()).To(ContainSubstring("Add @appsignal/nodejs to the dependencies in package.json"))
+		Expect(filepath.Join(depsDir, "0", "profile.d")).NotTo(BeAnExistingFile())
+	})
+
+	It("warns about several appsignal services", func() {
+		os.Setenv("VCAP_SERVICES", `{"appsignal": [{"name": "a", "credentials": {"push_api_key": "1"}}, {"name": "b", "credentials": {"pushApiKey": "2"}}]}`)
+
+		Expect(hook.AfterCompile(stager)).To(Succeed())
+		Expect(buffer.String()).To(ContainSubstring("More than one appsignal service found, set APPSIGNAL_PUSH_API_KEY to pick the key"))
+		Expect(filepath.Join(depsDir, "0", "profile.d")).NotTo(BeAnExistingFile())
+	})
+})
@@ -0,0 +1,88 @@
+package hooks
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/cloudfoundry/libbuildpack"
+)
+
+// ComponentsFile keeps the components the hooks contributed in the dep dir
+// until finalize writes them to buildpack-metadata.json.
+const ComponentsFile = "hook_components.json"
+
+// Component describes code a hook put into the droplet, like an APM agent,
+// which scanning node_modules does not find.
+type Component struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	// Source is where the component came from, without credentials.
+	Source string `json:"source,omitempty"`
+	SHA256 string `json:"sha256,omitempty"`
+	// Integrity is the subresource integrity npm recorded, for components
+	// installed from a registry.
+	Integrity string `json:"integrity,omitempty"`
+	// Hook is the name of the hook which installed the component.
+	Hook string `json:"hook"`
+}
+
+// Contributor is implemented by hooks which install or download components.
+// Contributions is asked after a successful AfterCompile and describes what
+// that installed, nothing when the hook had nothing to do.
+type Contributor interface {
+	Contributions(stager *libbuildpack.Stager) ([]Component, error)
+}
+
+// recordContributions adds the contributions of the hook name to
+// ComponentsFile in the dep dir.
+func recordContributions(hook libbuildpack.Hook, name string, stager *libbuildpack.Stager) error {
+	contributor, ok := hook.(Contributor)
+	if !ok {
+		return nil
+	}
+	components, err := contributor.Contributions(stager)
+	if err != nil || len(components) == 0 {
+		return err
+	}
+
+	path := filepath.Join(stager.DepDir(), ComponentsFile)
+	recorded, err := loadComponents(path)
+	if err != nil {
+		return err
+	}
+	for _, component := range components {
+		component.Hook = name
+		recorded = append(recorded, component)
+	}
+	return libbuildpack.NewJSON().Write(path, recorded)
+}
+
+// FinishComponents returns the components the hooks contributed during
+// staging and removes ComponentsFile from the dep dir.
+func FinishComponents(depDir string) ([]Component, error) {
+	path := filepath.Join(depDir, ComponentsFile)
+	components, err := loadComponents(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return components, nil
+}
+
+func loadComponents(path string) ([]Component, error) {
+	contents, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var components []Component
+	if err := json.Unmarshal(contents, &components); err != nil {
+		return nil, err
+	}
+	return components, nil
+}
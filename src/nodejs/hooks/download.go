@@ -0,0 +1,164 @@
+package hooks
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"nodejs/cache"
+	"nodejs/failure"
+	"nodejs/network"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	downloadCacheDir = "hook-downloads"
+
+	// downloadRetryDelay is how long a download waits before it retries.
+	downloadRetryDelay = time.Second
+)
+
+var unsafeKeyChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// CachedDownload returns the path of the artifact at url, downloading it into
+// the build cache unless a verified copy from a previous build is there, or
+// copying it from the read-only shared cache in BP_SHARED_CACHE_DIR when a
+// verified copy is there. key is "<agent>/<version>"; the cache keeps one
+// entry per agent, keyed by key, url and checksum. The artifact is verified
+// against checksum when given, otherwise against the checksum recorded when
+// it was downloaded. Corrupt entries are downloaded again. The returned bool
+// reports a hit in either cache.
+func CachedDownload(cacheDir, key, url, checksum string) (string, bool, error) {
+	agent := strings.SplitN(key, "/", 2)[0]
+	agentName := filepath.Join(downloadCacheDir, unsafeKeyChars.ReplaceAllString(agent, "_"))
+	entryName := filepath.Join(agentName, unsafeKeyChars.ReplaceAllString(key, "_")+"-"+checksumOf(url + "\n" + checksum)[:16])
+
+	layers := cache.NewLayered(os.Getenv("BP_SHARED_CACHE_DIR"), cacheDir)
+	source, layer, found := layers.Lookup(entryName, func(path string) bool { return verified(path, checksum) })
+	if found && layer == cache.Private {
+		return source, true, nil
+	}
+
+	// Callers make the artifact executable, so a hit in the read-only shared
+	// cache is copied into the build cache like a download.
+	fetch := func(path string) (string, error) { return download(url, path, checksum) }
+	if found {
+		fetch = func(path string) (string, error) { return copyArtifact(source, path, checksum) }
+	}
+
+	if cacheDir == "" {
+		tmpDir, err := ioutil.TempDir("", "hook-download")
+		if err != nil {
+			return "", false, err
+		}
+		path := filepath.Join(tmpDir, "artifact")
+		_, err = fetch(path)
+		return path, found, err
+	}
+
+	agentDir := filepath.Join(cacheDir, agentName)
+	path := filepath.Join(cacheDir, entryName)
+
+	if err := os.RemoveAll(agentDir); err != nil {
+		return "", false, err
+	}
+	if err := os.MkdirAll(agentDir, 0755); err != nil {
+		return "", false, err
+	}
+
+	actual, err := fetch(path)
+	if err != nil {
+		os.Remove(path)
+		return "", false, err
+	}
+	if err := ioutil.WriteFile(path+".sha256", []byte(actual+"\n"), 0644); err != nil {
+		return "", false, err
+	}
+	return path, found, nil
+}
+
+// verified reports whether the entry at path matches checksum, or when it is
+// "" the checksum recorded when it was downloaded.
+func verified(path, checksum string) bool {
+	recorded, err := ioutil.ReadFile(path + ".sha256")
+	if err != nil {
+		return false
+	}
+	expected := checksum
+	if expected == "" {
+		expected = strings.TrimSpace(string(recorded))
+	}
+	actual, err := fileChecksum(path)
+	return err == nil && actual == expected
+}
+
+// download writes url to path and returns the sha256 of the content, which
+// must match expected when given. Downloads which break off resume.
+func download(url, path, expected string) (string, error) {
+	actual, err := network.Downloader{Client: network.Client, RetryDelay: downloadRetryDelay}.Download(url, path)
+	if err != nil {
+		return "", err
+	}
+	return actual, checkChecksum(actual, expected)
+}
+
+// copyArtifact copies the cached artifact src to path like download.
+func copyArtifact(src, path, expected string) (string, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+
+	return save(in, path, expected)
+}
+
+// save writes r to path and returns the sha256 of the content, which must
+// match expected when given.
+func save(r io.Reader, path, expected string) (string, error) {
+	out, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	defer out.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(out, hash), r); err != nil {
+		return "", err
+	}
+
+	actual := hex.EncodeToString(hash.Sum(nil))
+	return actual, checkChecksum(actual, expected)
+}
+
+// checkChecksum fails when actual does not match expected, if given.
+func checkChecksum(actual, expected string) error {
+	if expected != "" && actual != expected {
+		return failure.Wrap(failure.ChecksumMismatch, fmt.Errorf("checksum mismatch: expected %s, got %s", expected, actual))
+	}
+	return nil
+}
+
+func fileChecksum(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func checksumOf(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
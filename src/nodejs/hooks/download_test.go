@@ -0,0 +1,197 @@
+package hooks_test
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"nodejs/failure"
+	"nodejs/hooks"
+
+	"gopkg.in/jarcoal/httpmock.v1"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("CachedDownload", func() {
+	const url = "https://example.com/agent-1.2.3.tgz"
+	var (
+		err       error
+		cacheDir  string
+		downloads int
+		content   string
+		checksum  string
+	)
+
+	BeforeEach(func() {
+		cacheDir, err = ioutil.TempDir("", "nodejs-buildpack.cache.")
+		Expect(err).To(BeNil())
+
+		httpmock.Reset()
+		downloads = 0
+		content = "agent contents"
+		sum := sha256.Sum256([]byte(content))
+		checksum = hex.EncodeToString(sum[:])
+
+		httpmock.RegisterResponder("GET", url, func(req *http.Request) (*http.Response, error) {
+			downloads++
+			return httpmock.NewStringResponse(200, content), nil
+		})
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(cacheDir)).To(Succeed())
+	})
+
+	It("downloads into the cache on a miss", func() {
+		path, cached, err := hooks.CachedDownload(cacheDir, "agent/1.2.3", url, checksum)
+		Expect(err).To(BeNil())
+		Expect(cached).To(BeFalse())
+		Expect(downloads).To(Equal(1))
+		Expect(path).To(HavePrefix(filepath.Join(cacheDir, "hook-downloads", "agent")))
+		Expect(ioutil.ReadFile(path)).To(Equal([]byte(content)))
+	})
+
+	It("reuses a verified entry on a hit", func() {
+		first, _, err := hooks.CachedDownload(cacheDir, "agent/1.2.3", url, checksum)
+		Expect(err).To(BeNil())
+
+		path, cached, err := hooks.CachedDownload(cacheDir, "agent/1.2.3", url, checksum)
+		Expect(err).To(BeNil())
+		Expect(cached).To(BeTrue())
+		Expect(path).To(Equal(first))
+		Expect(downloads).To(Equal(1))
+	})
+
+	It("verifies entries without a checksum against the one recorded on download", func() {
+		_, _, err := hooks.CachedDownload(cacheDir, "agent/1.2.3", url, "")
+		Expect(err).To(BeNil())
+
+		_, cached, err := hooks.CachedDownload(cacheDir, "agent/1.2.3", url, "")
+		Expect(err).To(BeNil())
+		Expect(cached).To(BeTrue())
+		Expect(downloads).To(Equal(1))
+	})
+
+	It("downloads corrupted entries again", func() {
+		path, _, err := hooks.CachedDownload(cacheDir, "agent/1.2.3", url, "")
+		Expect(err).To(BeNil())
+		Expect(ioutil.WriteFile(path, []byte("truncated"), 0644)).To(Succeed())
+
+		path, cached, err := hooks.CachedDownload(cacheDir, "agent/1.2.3", url, "")
+		Expect(err).To(BeNil())
+		Expect(cached).To(BeFalse())
+		Expect(downloads).To(Equal(2))
+		Expect(ioutil.ReadFile(path)).To(Equal([]byte(content)))
+	})
+
+	It("fails when the download does not match the checksum", func() {
+		_, _, err := hooks.CachedDownload(cacheDir, "agent/1.2.3", url, "0000")
+		Expect(err).To(MatchError(ContainSubstring("checksum mismatch: expected 0000, got " + checksum)))
+		Expect(failure.CodeOf(err)).To(Equal(failure.ChecksumMismatch))
+
+		files, err := filepath.Glob(filepath.Join(cacheDir, "hook-downloads", "agent", "*"))
+		Expect(err).To(BeNil())
+		Expect(files).To(BeEmpty())
+	})
+
+	It("keeps one version per agent", func() {
+		httpmock.RegisterResponder("GET", "https://example.com/agent-1.2.4.tgz", httpmock.NewStringResponder(200, "newer agent"))
+
+		_, _, err := hooks.CachedDownload(cacheDir, "agent/1.2.3", url, "")
+		Expect(err).To(BeNil())
+		path, _, err := hooks.CachedDownload(cacheDir, "agent/1.2.4", "https://example.com/agent-1.2.4.tgz", "")
+		Expect(err).To(BeNil())
+
+		files, err := filepath.Glob(filepath.Join(cacheDir, "hook-downloads", "agent", "*"))
+		Expect(err).To(BeNil())
+		Expect(files).To(ConsistOf(path, path+".sha256"))
+	})
+
+	It("downloads to a temporary file without a cache dir", func() {
+		path, cached, err := hooks.CachedDownload("", "agent/1.2.3", url, checksum)
+		Expect(err).To(BeNil())
+		Expect(cached).To(BeFalse())
+		Expect(ioutil.ReadFile(path)).To(Equal([]byte(content)))
+		Expect(os.RemoveAll(filepath.Dir(path))).To(Succeed())
+	})
+
+	Context("with a shared cache", func() {
+		var (
+			sharedDir string
+			oldShared string
+		)
+
+		BeforeEach(func() {
+			sharedDir, err = ioutil.TempDir("", "nodejs-buildpack.shared.")
+			Expect(err).To(BeNil())
+
+			// Fill the shared cache the way the platform bakes it, from the
+			// cache of an earlier staging.
+			_, _, err = hooks.CachedDownload(sharedDir, "agent/1.2.3", url, checksum)
+			Expect(err).To(BeNil())
+			downloads = 0
+
+			oldShared = os.Getenv("BP_SHARED_CACHE_DIR")
+			os.Setenv("BP_SHARED_CACHE_DIR", sharedDir)
+		})
+
+		AfterEach(func() {
+			os.Setenv("BP_SHARED_CACHE_DIR", oldShared)
+			Expect(os.RemoveAll(sharedDir)).To(Succeed())
+		})
+
+		It("copies a hit in the shared cache into the build cache", func() {
+			path, cached, err := hooks.CachedDownload(cacheDir, "agent/1.2.3", url, checksum)
+			Expect(err).To(BeNil())
+			Expect(cached).To(BeTrue())
+			Expect(downloads).To(Equal(0))
+			Expect(path).To(HavePrefix(filepath.Join(cacheDir, "hook-downloads", "agent")))
+			Expect(ioutil.ReadFile(path)).To(Equal([]byte(content)))
+		})
+
+		It("copies a hit in the shared cache without a cache dir", func() {
+			path, cached, err := hooks.CachedDownload("", "agent/1.2.3", url, checksum)
+			Expect(err).To(BeNil())
+			Expect(cached).To(BeTrue())
+			Expect(downloads).To(Equal(0))
+			Expect(ioutil.ReadFile(path)).To(Equal([]byte(content)))
+			Expect(os.RemoveAll(filepath.Dir(path))).To(Succeed())
+		})
+
+		It("falls through to the build cache and the network on a miss", func() {
+			httpmock.RegisterResponder("GET", "https://example.com/agent-1.2.4.tgz", func(req *http.Request) (*http.Response, error) {
+				downloads++
+				return httpmock.NewStringResponse(200, "newer agent"), nil
+			})
+
+			_, cached, err := hooks.CachedDownload(cacheDir, "agent/1.2.4", "https://example.com/agent-1.2.4.tgz", "")
+			Expect(err).To(BeNil())
+			Expect(cached).To(BeFalse())
+			_, cached, err = hooks.CachedDownload(cacheDir, "agent/1.2.4", "https://example.com/agent-1.2.4.tgz", "")
+			Expect(err).To(BeNil())
+			Expect(cached).To(BeTrue())
+			Expect(downloads).To(Equal(1))
+
+			files, err := filepath.Glob(filepath.Join(sharedDir, "hook-downloads", "agent", "*"))
+			Expect(err).To(BeNil())
+			Expect(files).To(HaveLen(2))
+		})
+
+		It("downloads when the shared entry is corrupt", func() {
+			files, err := filepath.Glob(filepath.Join(sharedDir, "hook-downloads", "agent", "*.sha256"))
+			Expect(err).To(BeNil())
+			Expect(ioutil.WriteFile(strings.TrimSuffix(files[0], ".sha256"), []byte("truncated"), 0644)).To(Succeed())
+
+			_, cached, err := hooks.CachedDownload(cacheDir, "agent/1.2.3", url, "")
+			Expect(err).To(BeNil())
+			Expect(cached).To(BeFalse())
+			Expect(downloads).To(Equal(1))
+		})
+	})
+})
@@ -1,13 +1,21 @@
 package hooks
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"io"
 	"io/ioutil"
 	"net/http"
+	"net/url"
+	"nodejs/failure"
+	"nodejs/netbudget"
+	"nodejs/network"
+	"nodejs/profiled"
+	"nodejs/vcap"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 
 	"github.com/cloudfoundry/libbuildpack"
@@ -31,19 +39,24 @@ type DynatraceHook struct {
 }
 
 func init() {
-	logger := libbuildpack.NewLogger(os.Stdout)
-	command := &libbuildpack.Command{}
-
-	libbuildpack.AddHook(DynatraceHook{
-		Log:     logger,
-		Command: command,
+	AddIsolatedHook("dynatrace", func(logger *libbuildpack.Logger) libbuildpack.Hook {
+		return DynatraceHook{
+			Log:     logger,
+			Command: &libbuildpack.Command{},
+		}
 	})
 }
 
+// Active reports whether a single dynatrace service with credentials is bound.
+func (h DynatraceHook) Active() bool {
+	_, found := h.dtCredentials(LoadVCAPServices(h.Log))
+	return found
+}
+
 func (h DynatraceHook) AfterCompile(stager *libbuildpack.Stager) error {
 	h.Log.Debug("Checking for enabled dynatrace service...")
 
-	credentials, found := h.dtCredentials()
+	credentials, found := h.dtCredentials(LoadVCAPServices(h.Log))
 	if !found {
 		h.Log.Debug("Dynatrace service credentials not found!")
 		return nil
@@ -56,17 +69,13 @@ func (h DynatraceHook) AfterCompile(stager *libbuildpack.Stager) error {
 		apiurl = "https://" + credentials.EnvironmentId + ".live.dynatrace.com/api"
 	}
 
-	url := apiurl + "/v1/deployment/installer/agent/unix/paas-sh/latest?include=nodejs&include=process&bitness=64&Api-Token=" + credentials.ApiToken
-	installerPath := filepath.Join(os.TempDir(), "paasInstaller.sh")
-
-	h.Log.Debug("Downloading '%s' to '%s'", url, installerPath)
-	err := h.downloadFile(url, installerPath)
+	installerPath, err := h.downloadInstaller(stager.CacheDir(), stager.DepDir(), apiurl, credentials.ApiToken)
 	if err != nil {
 		if credentials.SkipErrors {
 			h.Log.Warning("Error during installer download, skipping installation")
 			return nil
 		}
-		return err
+		return failure.Download(err)
 	}
 
 	h.Log.Debug("Making %s executable...", installerPath)
@@ -75,7 +84,7 @@ func (h DynatraceHook) AfterCompile(stager *libbuildpack.Stager) error {
 	h.Log.BeginStep("Starting Dynatrace PaaS agent installer")
 
 	if os.Getenv("BP_DEBUG") != "" {
-		err = h.Command.Execute("", os.Stdout, os.Stderr, installerPath, stager.BuildDir())
+		err = h.Command.Execute("", h.Log.Output(), h.Log.Output(), installerPath, stager.BuildDir())
 	} else {
 		err = h.Command.Execute("", ioutil.Discard, ioutil.Discard, installerPath, stager.BuildDir())
 	}
@@ -87,7 +96,7 @@ func (h DynatraceHook) AfterCompile(stager *libbuildpack.Stager) error {
 
 	dynatraceEnvName := "dynatrace-env.sh"
 	installDir := "dynatrace/oneagent"
-	dynatraceEnvPath := filepath.Join(stager.DepDir(), "profile.d", dynatraceEnvName)
+	dynatraceEnvPath := filepath.Join(stager.DepDir(), "profile.d", profiled.Name(stager.DepsIdx(), dynatraceEnvName))
 	agentLibPath, err := h.agentPath(filepath.Join(stager.BuildDir(), installDir))
 	if err != nil {
 		h.Log.Error("Manifest handling failed!")
@@ -134,45 +143,74 @@ func (h DynatraceHook) AfterCompile(stager *libbuildpack.Stager) error {
 	return nil
 }
 
-func (h DynatraceHook) getCredentialString(credentials map[string]interface{},key string) string {
-	value, isString := credentials[key].(string)
-
-	if isString {
-		return value
+// Contributions describes the PaaS agent the installer put into the app,
+// with the checksum of the library LD_PRELOAD loads.
+func (h DynatraceHook) Contributions(stager *libbuildpack.Stager) ([]Component, error) {
+	credentials, found := h.dtCredentials(LoadVCAPServices(h.Log))
+	if !found {
+		return nil, nil
+	}
+	installDir := filepath.Join(stager.BuildDir(), "dynatrace/oneagent")
+	if _, err := os.Stat(filepath.Join(installDir, "manifest.json")); os.IsNotExist(err) {
+		// The installer was skipped, see SkipErrors.
+		return nil, nil
 	}
-	return ""
-}
 
-func (h DynatraceHook) dtCredentials() (DynatraceCredentials, bool) {
-	type Service struct {
-		Name        string                 `json:"name"`
-		Credentials map[string]interface{} `json:"credentials"`
+	version, err := h.agentVersion(installDir)
+	if err != nil {
+		return nil, err
+	}
+	agentLibPath, err := h.agentPath(installDir)
+	if err != nil {
+		return nil, err
+	}
+	checksum, err := fileChecksum(filepath.Join(installDir, agentLibPath))
+	if err != nil {
+		return nil, err
 	}
 
-	var vcapServices map[string][]Service
+	apiurl := credentials.ApiURL
+	if apiurl == "" {
+		apiurl = "https://" + credentials.EnvironmentId + ".live.dynatrace.com/api"
+	}
+	return []Component{{
+		Name:    "dynatrace-oneagent",
+		Version: version,
+		Source:  apiurl + "/v1/deployment/installer/agent/unix/paas-sh/version/" + version,
+		SHA256:  checksum,
+	}}, nil
+}
 
-	err := json.Unmarshal([]byte(os.Getenv("VCAP_SERVICES")), &vcapServices)
+// agentVersion returns the version in the manifest of the installed agent.
+func (h DynatraceHook) agentVersion(installDir string) (string, error) {
+	raw, err := ioutil.ReadFile(filepath.Join(installDir, "manifest.json"))
 	if err != nil {
-		h.Log.Debug("Failed to unmarshal VCAP_SERVICES: %s", err)
-		return DynatraceCredentials{}, false
+		return "", err
+	}
+	var manifest struct {
+		Version string `json:"version"`
+	}
+	if err := json.Unmarshal(raw, &manifest); err != nil {
+		return "", err
 	}
+	return manifest.Version, nil
+}
 
+func (h DynatraceHook) dtCredentials(vcapServices VCAPServices) (DynatraceCredentials, bool) {
 	var detectedCredentials []DynatraceCredentials
 
-	for _, services := range vcapServices {
-		for _, service := range services {
-			if strings.Contains(service.Name, "dynatrace") {
-				credentials := DynatraceCredentials{
-					ServiceName :   service.Name,
-					EnvironmentId : h.getCredentialString(service.Credentials, "environmentid"),
-					ApiToken :      h.getCredentialString(service.Credentials, "apitoken"),
-					ApiURL :        h.getCredentialString(service.Credentials, "apiurl"),
-					SkipErrors :    h.getCredentialString(service.Credentials, "skiperrors") == "true",
-				}
-
-				if credentials.EnvironmentId != "" && credentials.ApiToken != "" {
-					detectedCredentials = append(detectedCredentials, credentials)
-				}
+	for _, service := range vcapServices.All() {
+		if strings.Contains(service.Name, "dynatrace") {
+			credentials := DynatraceCredentials{
+				ServiceName :   service.Name,
+				EnvironmentId : service.CredentialString("environmentid"),
+				ApiToken :      service.CredentialString("apitoken"),
+				ApiURL :        service.CredentialString("apiurl"),
+				SkipErrors :    service.CredentialString("skiperrors") == "true",
+			}
+
+			if credentials.EnvironmentId != "" && credentials.ApiToken != "" {
+				detectedCredentials = append(detectedCredentials, credentials)
 			}
 		}
 	}
@@ -188,10 +226,7 @@ func (h DynatraceHook) dtCredentials() (DynatraceCredentials, bool) {
 }
 
 func (h DynatraceHook) appName() string {
-	var application struct {
-		Name string `json:"name"`
-	}
-	err := json.Unmarshal([]byte(os.Getenv("VCAP_APPLICATION")), &application)
+	application, _, err := vcap.LoadApplication()
 	if err != nil {
 		return ""
 	}
@@ -199,31 +234,125 @@ func (h DynatraceHook) appName() string {
 	return application.Name
 }
 
-func (h DynatraceHook) downloadFile(url, path string) error {
-	out, err := os.Create(path)
+// downloadInstaller downloads the latest installer. With a build cache the
+// latest version is looked up first, on the optional network budget, so that
+// the installer of that version can be reused by later builds.
+func (h DynatraceHook) downloadInstaller(cacheDir, depDir, apiurl, apiToken string) (string, error) {
+	query := "?include=nodejs&include=process&bitness=64&Api-Token=" + apiToken
+
+	if cacheDir != "" {
+		version, err := h.budgetedAgentVersion(depDir, apiurl, apiToken)
+		if err == nil {
+			url := apiurl + "/v1/deployment/installer/agent/unix/paas-sh/version/" + version + query
+			path, cached, err := CachedDownload(cacheDir, "dynatrace/"+version, url, "")
+			if err == nil && cached {
+				h.Log.Info("Using cached Dynatrace PaaS agent installer %s", version)
+			}
+			return path, err
+		}
+		h.Log.Debug("Unable to look up the latest agent version, not caching the installer: %s", err)
+	}
+
+	url := apiurl + "/v1/deployment/installer/agent/unix/paas-sh/latest" + query
+	installerPath := filepath.Join(os.TempDir(), "paasInstaller.sh")
+
+	h.Log.Debug("Downloading '%s' to '%s'", url, installerPath)
+	return installerPath, h.downloadFile(url, installerPath)
+}
+
+// budgetedAgentVersion looks the latest agent version up within what is left
+// of the optional network budget.
+func (h DynatraceHook) budgetedAgentVersion(depDir, apiurl, apiToken string) (string, error) {
+	budget, err := netbudget.Staging(depDir)
 	if err != nil {
-		return err
+		return "", err
+	}
+	call, err := budget.Start("dynatrace agent version")
+	if err != nil {
+		if saveErr := budget.Save(); saveErr != nil {
+			return "", saveErr
+		}
+		return "", err
 	}
 
-	defer out.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), call.Remaining)
+	defer cancel()
+	version, err := h.latestAgentVersion(ctx, apiurl, apiToken)
+	reason := err
+	if urlErr, ok := err.(*url.Error); ok {
+		// The URL has the API token in it.
+		reason = urlErr.Err
+	}
+	call.Done(reason)
+	if saveErr := budget.Save(); saveErr != nil {
+		return "", saveErr
+	}
+	return version, err
+}
 
-	resp, err := http.Get(url)
+func (h DynatraceHook) latestAgentVersion(ctx context.Context, apiurl, apiToken string) (string, error) {
+	req, err := http.NewRequest("GET", apiurl+"/v1/deployment/installer/agent/versions/unix/paas-sh?Api-Token="+apiToken, nil)
 	if err != nil {
-		return err
+		return "", err
 	}
+	resp, err := network.Client.Do(req.WithContext(ctx))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
 
 	if resp.StatusCode != 200 {
-		return errors.New("Download returned with status " + resp.Status)
+		return "", errors.New("Version lookup returned with status " + resp.Status)
 	}
 
-	defer resp.Body.Close()
+	var versions struct {
+		AvailableVersions []string `json:"availableVersions"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&versions); err != nil {
+		return "", err
+	}
+	if len(versions.AvailableVersions) == 0 {
+		return "", errors.New("no agent versions available")
+	}
 
-	_, err = io.Copy(out, resp.Body)
-	if err != nil {
-		return err
+	latest := versions.AvailableVersions[0]
+	for _, version := range versions.AvailableVersions[1:] {
+		if compareAgentVersions(version, latest) > 0 {
+			latest = version
+		}
 	}
+	return latest, nil
+}
 
-	return nil
+// compareAgentVersions compares versions like 1.181.154.20191112-102834 by
+// their numeric components.
+func compareAgentVersions(a, b string) int {
+	split := func(v string) []string {
+		return strings.FieldsFunc(v, func(r rune) bool { return r == '.' || r == '-' })
+	}
+	as, bs := split(a), split(b)
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		an, aErr := strconv.Atoi(as[i])
+		bn, bErr := strconv.Atoi(bs[i])
+		if aErr != nil || bErr != nil {
+			if as[i] != bs[i] {
+				return strings.Compare(as[i], bs[i])
+			}
+			continue
+		}
+		if an != bn {
+			if an < bn {
+				return -1
+			}
+			return 1
+		}
+	}
+	return len(as) - len(bs)
+}
+
+func (h DynatraceHook) downloadFile(url, path string) error {
+	_, err := download(url, path, "")
+	return err
 }
 
 func (h DynatraceHook) agentPath(installDir string) (string, error) {
@@ -1,8 +1,11 @@
 package hooks_test
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"io"
 	"io/ioutil"
+	"net/http"
 	"os"
 	"path/filepath"
 
@@ -12,6 +15,7 @@ import (
 	"golang.google.cn/x/mock/gomock"
 
 	"nodejs/hooks"
+	"nodejs/netbudget"
 
 	"gopkg.in/jarcoal/httpmock.v1"
 
@@ -198,13 +202,101 @@ var _ = Describe("dynatraceHook", func() {
 				Expect(err).To(BeNil())
 
 				// Sets up profile.d
-				contents, err := ioutil.ReadFile(filepath.Join(depsDir, depsIdx, "profile.d", "dynatrace-env.sh"))
+				contents, err := ioutil.ReadFile(filepath.Join(depsDir, depsIdx, "profile.d", "007_nodejs_buildpack_dynatrace-env.sh"))
 				Expect(err).To(BeNil())
 
 				Expect(string(contents)).To(Equal("echo running dynatrace-env.sh\n" +
 					"export LD_PRELOAD=${HOME}/dynatrace/oneagent/agent/lib64/liboneagentproc.so\n" +
 					"export DT_HOST_ID=JimBob_${CF_INSTANCE_INDEX}"))
 			})
+
+			It("contributes the agent it installed", func() {
+				mockCommand.EXPECT().Execute("", gomock.Any(), gomock.Any(), gomock.Any(), buildDir).Do(runInstaller)
+
+				Expect(dynatrace.Contributions(stager)).To(BeEmpty())
+				Expect(dynatrace.AfterCompile(stager)).To(Succeed())
+
+				sum := sha256.Sum256([]byte("library"))
+				Expect(dynatrace.Contributions(stager)).To(Equal([]hooks.Component{{
+					Name:    "dynatrace-oneagent",
+					Version: "1.130.0.20170914-153344",
+					Source:  "https://example.com/v1/deployment/installer/agent/unix/paas-sh/version/1.130.0.20170914-153344",
+					SHA256:  hex.EncodeToString(sum[:]),
+				}}))
+			})
+		})
+
+		Context("VCAP_SERVICES contains dynatrace service and there is a build cache", func() {
+			var (
+				cacheDir  string
+				downloads int
+			)
+
+			BeforeEach(func() {
+				apiToken := "ExcitingToken28"
+				os.Setenv("VCAP_APPLICATION", `{"name":"JimBob"}`)
+				os.Setenv("VCAP_SERVICES", `{
+					"0": [{"name":"dynatrace","credentials":{"apiurl":"https://example.com","apitoken":"`+apiToken+`","environmentid":"123456"}}]
+				}`)
+
+				cacheDir, err = ioutil.TempDir("", "nodejs-buildpack.cache.")
+				Expect(err).To(BeNil())
+
+				downloads = 0
+				httpmock.RegisterResponder("GET", "https://example.com/v1/deployment/installer/agent/versions/unix/paas-sh?Api-Token="+apiToken,
+					func(req *http.Request) (*http.Response, error) {
+						return httpmock.NewStringResponse(200, `{"availableVersions": ["1.9.0.20170101-000000", "1.130.0.20170914-153344", "1.20.0.20170501-000000"]}`), nil
+					})
+				httpmock.RegisterResponder("GET", "https://example.com/v1/deployment/installer/agent/unix/paas-sh/version/1.130.0.20170914-153344?include=nodejs&include=process&bitness=64&Api-Token="+apiToken,
+					func(req *http.Request) (*http.Response, error) {
+						downloads++
+						return httpmock.NewStringResponse(200, "echo Install Dynatrace"), nil
+					})
+			})
+
+			JustBeforeEach(func() {
+				stager = libbuildpack.NewStager([]string{buildDir, cacheDir, depsDir, depsIdx}, logger, &libbuildpack.Manifest{})
+			})
+
+			AfterEach(func() {
+				Expect(os.RemoveAll(cacheDir)).To(Succeed())
+			})
+
+			It("reuses the installer of the latest version on the next build", func() {
+				mockCommand.EXPECT().Execute("", gomock.Any(), gomock.Any(), gomock.Any(), buildDir).Do(runInstaller).Times(2)
+
+				Expect(dynatrace.AfterCompile(stager)).To(Succeed())
+				Expect(dynatrace.AfterCompile(stager)).To(Succeed())
+
+				Expect(downloads).To(Equal(1))
+				Expect(buffer.String()).To(ContainSubstring("Using cached Dynatrace PaaS agent installer 1.130.0.20170914-153344"))
+			})
+
+			Context("and no optional network budget", func() {
+				var oldBudget string
+
+				BeforeEach(func() {
+					oldBudget = os.Getenv("BP_OPTIONAL_NETWORK_BUDGET")
+					os.Setenv("BP_OPTIONAL_NETWORK_BUDGET", "0")
+					httpmock.RegisterResponder("GET", "https://example.com/v1/deployment/installer/agent/unix/paas-sh/latest?include=nodejs&include=process&bitness=64&Api-Token=ExcitingToken28",
+						httpmock.NewStringResponder(200, "echo Install Dynatrace"))
+				})
+
+				AfterEach(func() {
+					os.Setenv("BP_OPTIONAL_NETWORK_BUDGET", oldBudget)
+				})
+
+				It("downloads the latest installer without looking its version up", func() {
+					mockCommand.EXPECT().Execute("", gomock.Any(), gomock.Any(), gomock.Any(), buildDir).Do(runInstaller)
+
+					Expect(dynatrace.AfterCompile(stager)).To(Succeed())
+
+					Expect(downloads).To(Equal(0))
+					outcomes, err := netbudget.Finish(filepath.Join(depsDir, depsIdx))
+					Expect(err).To(BeNil())
+					Expect(outcomes).To(Equal([]netbudget.Outcome{{Feature: "dynatrace agent version", Status: netbudget.Skipped, Reason: "BP_OPTIONAL_NETWORK_BUDGET is 0"}}))
+				})
+			})
 		})
 
 		Context("VCAP_SERVICES contains malformed dynatrace service", func() {
@@ -267,7 +359,7 @@ var _ = Describe("dynatraceHook", func() {
 				Expect(err).To(BeNil())
 
 				// Sets up profile.d
-				contents, err := ioutil.ReadFile(filepath.Join(depsDir, depsIdx, "profile.d", "dynatrace-env.sh"))
+				contents, err := ioutil.ReadFile(filepath.Join(depsDir, depsIdx, "profile.d", "007_nodejs_buildpack_dynatrace-env.sh"))
 				Expect(err).To(BeNil())
 
 				Expect(string(contents)).To(Equal("echo running dynatrace-env.sh\n" +
@@ -0,0 +1,261 @@
+package hooks
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"nodejs/profiled"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/cloudfoundry/libbuildpack"
+)
+
+const (
+	// ExternalHooksDir holds the executables which run as hooks, in the
+	// buildpack and in the dep dirs of supply buildpacks.
+	ExternalHooksDir = "hooks.d"
+	// ExternalProtocol is the version of ExternalRequest and
+	// ExternalResponse, raised on incompatible changes.
+	ExternalProtocol = 1
+)
+
+// ExternalRequest is the staging context an external hook reads as JSON on
+// stdin. Services only names the bindings, the hook finds their credentials
+// in VCAP_SERVICES like the built-in hooks do.
+type ExternalRequest struct {
+	Protocol int               `json:"protocol"`
+	Phase    string            `json:"phase"`
+	BuildDir string            `json:"build_dir"`
+	CacheDir string            `json:"cache_dir"`
+	DepsDir  string            `json:"deps_dir"`
+	DepDir   string            `json:"dep_dir"`
+	DepsIdx  string            `json:"deps_idx"`
+	Stack    string            `json:"stack"`
+	Versions map[string]string `json:"versions"`
+	Services []ExternalService `json:"services"`
+}
+
+// ExternalService is a service binding, without its credentials.
+type ExternalService struct {
+	Label string   `json:"label"`
+	Name  string   `json:"name"`
+	Tags  []string `json:"tags"`
+}
+
+// ExternalResponse is what an external hook may write as JSON to stdout. Env
+// is set for the rest of staging, LaunchEnv is exported when the app runs.
+type ExternalResponse struct {
+	Env       map[string]string `json:"env"`
+	LaunchEnv map[string]string `json:"launch_env"`
+	Warnings  []string          `json:"warnings"`
+}
+
+// ExternalHook runs an executable as a hook. Its stderr is the output of the
+// hook, and a non-zero exit fails staging like the error of a built-in hook.
+type ExternalHook struct {
+	Log  *libbuildpack.Logger
+	Path string
+}
+
+var envName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// FindExternalHooks returns the executables in the ExternalHooksDir of
+// buildpackDir and then of every dep dir in depsDir, each sorted by name.
+func FindExternalHooks(buildpackDir, depsDir string) ([]string, error) {
+	dirs := []string{filepath.Join(buildpackDir, ExternalHooksDir)}
+	depDirs, err := filepath.Glob(filepath.Join(depsDir, "*", ExternalHooksDir))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(depDirs)
+	dirs = append(dirs, depDirs...)
+
+	var paths []string
+	for _, dir := range dirs {
+		files, err := ioutil.ReadDir(dir)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		for _, file := range files {
+			path := filepath.Join(dir, file.Name())
+			info, err := os.Stat(path)
+			if err != nil || !info.Mode().IsRegular() || info.Mode()&0111 == 0 || strings.HasPrefix(file.Name(), ".") {
+				continue
+			}
+			paths = append(paths, path)
+		}
+	}
+	return paths, nil
+}
+
+// AddExternalHooks registers the executables FindExternalHooks finds as
+// isolated hooks, named after their file.
+func AddExternalHooks(buildpackDir, depsDir string) error {
+	paths, err := FindExternalHooks(buildpackDir, depsDir)
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		path := path
+		AddIsolatedHook(filepath.Base(path), func(logger *libbuildpack.Logger) libbuildpack.Hook {
+			return ExternalHook{Log: logger, Path: path}
+		})
+	}
+	return nil
+}
+
+// Active reports true, an external hook decides for itself whether it has
+// anything to do.
+func (h ExternalHook) Active() bool {
+	return true
+}
+
+func (h ExternalHook) BeforeCompile(stager *libbuildpack.Stager) error {
+	return h.run("BeforeCompile", stager)
+}
+
+func (h ExternalHook) AfterCompile(stager *libbuildpack.Stager) error {
+	return h.run("AfterCompile", stager)
+}
+
+func (h ExternalHook) run(phase string, stager *libbuildpack.Stager) error {
+	request, err := json.Marshal(NewExternalRequest(phase, stager, LoadVCAPServices(h.Log)))
+	if err != nil {
+		return err
+	}
+
+	stdout := new(bytes.Buffer)
+	cmd := exec.Command(h.Path)
+	cmd.Dir = stager.BuildDir()
+	cmd.Stdin = bytes.NewReader(request)
+	cmd.Stdout = stdout
+	cmd.Stderr = h.Log.Output()
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s failed: %s", h.Path, err)
+	}
+
+	response, err := ParseExternalResponse(stdout.Bytes())
+	if err != nil {
+		return fmt.Errorf("%s wrote an invalid response: %s", h.Path, err)
+	}
+	return h.apply(response, stager)
+}
+
+// NewExternalRequest describes the staging context of phase.
+func NewExternalRequest(phase string, stager *libbuildpack.Stager, services VCAPServices) ExternalRequest {
+	request := ExternalRequest{
+		Protocol: ExternalProtocol,
+		Phase:    phase,
+		BuildDir: stager.BuildDir(),
+		CacheDir: stager.CacheDir(),
+		DepsDir:  stager.DepsDir(),
+		DepDir:   stager.DepDir(),
+		DepsIdx:  stager.DepsIdx(),
+		Stack:    os.Getenv("CF_STACK"),
+		Versions: installedVersions(stager.DepDir()),
+		Services: []ExternalService{},
+	}
+	for _, service := range services.All() {
+		request.Services = append(request.Services, ExternalService{Label: service.Label, Name: service.Name, Tags: service.Tags})
+	}
+	return request
+}
+
+// ParseExternalResponse parses the stdout of an external hook, which may be
+// empty.
+func ParseExternalResponse(data []byte) (ExternalResponse, error) {
+	var response ExternalResponse
+	if len(bytes.TrimSpace(data)) == 0 {
+		return response, nil
+	}
+	if err := json.Unmarshal(data, &response); err != nil {
+		return ExternalResponse{}, err
+	}
+	for _, env := range []map[string]string{response.Env, response.LaunchEnv} {
+		for name := range env {
+			if !envName.MatchString(name) {
+				return ExternalResponse{}, fmt.Errorf("%q is not a valid environment variable name", name)
+			}
+		}
+	}
+	return response, nil
+}
+
+func (h ExternalHook) apply(response ExternalResponse, stager *libbuildpack.Stager) error {
+	for _, warning := range response.Warnings {
+		h.Log.Warning("%s", warning)
+	}
+
+	for _, name := range sortedNames(response.Env) {
+		if err := os.Setenv(name, response.Env[name]); err != nil {
+			return err
+		}
+		if err := stager.WriteEnvFile(name, response.Env[name]); err != nil {
+			return err
+		}
+		h.Log.Info("Set %s for staging", name)
+	}
+
+	if len(response.LaunchEnv) == 0 {
+		return nil
+	}
+	var script []string
+	for _, name := range sortedNames(response.LaunchEnv) {
+		script = append(script, "export "+name+"="+shellQuote(response.LaunchEnv[name]))
+		h.Log.Info("Set %s for the app", name)
+	}
+	name := filepath.Base(h.Path)
+	return profiled.Write(stager, "hook_"+strings.TrimSuffix(name, filepath.Ext(name))+".sh", strings.Join(script, "\n")+"\n")
+}
+
+// installedVersions returns the versions of node, npm and yarn installed in
+// depDir. Before they are installed, the map is empty.
+func installedVersions(depDir string) map[string]string {
+	versions := map[string]string{}
+	if header, err := ioutil.ReadFile(filepath.Join(depDir, "node", "include", "node", "node_version.h")); err == nil {
+		var parts []string
+		for _, name := range []string{"NODE_MAJOR_VERSION", "NODE_MINOR_VERSION", "NODE_PATCH_VERSION"} {
+			if m := regexp.MustCompile(`#define ` + name + ` (\d+)`).FindSubmatch(header); m != nil {
+				parts = append(parts, string(m[1]))
+			}
+		}
+		if len(parts) == 3 {
+			versions["node"] = strings.Join(parts, ".")
+		}
+	}
+
+	packages := map[string]string{"npm": filepath.Join(depDir, "node", "lib", "node_modules", "npm", "package.json")}
+	if matches, _ := filepath.Glob(filepath.Join(depDir, "yarn", "yarn-v*", "package.json")); len(matches) == 1 {
+		packages["yarn"] = matches[0]
+	}
+	for name, path := range packages {
+		var pkg struct {
+			Version string `json:"version"`
+		}
+		if data, err := ioutil.ReadFile(path); err == nil && json.Unmarshal(data, &pkg) == nil && pkg.Version != "" {
+			versions[name] = pkg.Version
+		}
+	}
+	return versions
+}
+
+func sortedNames(env map[string]string) []string {
+	var names []string
+	for name := range env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
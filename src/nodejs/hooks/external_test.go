@@ -0,0 +1,241 @@
+package hooks_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"nodejs/failure"
+	"nodejs/hooks"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/cloudfoundry/libbuildpack/ansicleaner"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("External hooks", func() {
+	var (
+		err      error
+		buildDir string
+		cacheDir string
+		depsDir  string
+		depDir   string
+		buffer   *bytes.Buffer
+		logger   *libbuildpack.Logger
+		stager   *libbuildpack.Stager
+		oldEnv   map[string]string
+	)
+
+	fixture := func(name string) string {
+		path, err := filepath.Abs(filepath.Join("testdata", "external", name))
+		Expect(err).To(BeNil())
+		return path
+	}
+
+	BeforeEach(func() {
+		buildDir, err = ioutil.TempDir("", "nodejs-buildpack.build.")
+		Expect(err).To(BeNil())
+		cacheDir, err = ioutil.TempDir("", "nodejs-buildpack.cache.")
+		Expect(err).To(BeNil())
+		depsDir, err = ioutil.TempDir("", "nodejs-buildpack.deps.")
+		Expect(err).To(BeNil())
+		depDir = filepath.Join(depsDir, "1")
+		Expect(os.MkdirAll(depDir, 0755)).To(Succeed())
+
+		oldEnv = map[string]string{}
+		for _, key := range []string{"VCAP_SERVICES", "CF_STACK", "SIGNING_KEY_ID", "BP_DEBUG"} {
+			oldEnv[key] = os.Getenv(key)
+			os.Unsetenv(key)
+		}
+
+		buffer = new(bytes.Buffer)
+		logger = libbuildpack.NewLogger(ansicleaner.New(buffer))
+		stager = libbuildpack.NewStager([]string{buildDir, cacheDir, depsDir, "1"}, logger, &libbuildpack.Manifest{})
+	})
+
+	AfterEach(func() {
+		for key, value := range oldEnv {
+			os.Setenv(key, value)
+		}
+		Expect(os.RemoveAll(buildDir)).To(Succeed())
+		Expect(os.RemoveAll(cacheDir)).To(Succeed())
+		Expect(os.RemoveAll(depsDir)).To(Succeed())
+	})
+
+	Describe("FindExternalHooks", func() {
+		var buildpackDir string
+
+		write := func(path string, mode os.FileMode) {
+			Expect(os.MkdirAll(filepath.Dir(path), 0755)).To(Succeed())
+			Expect(ioutil.WriteFile(path, []byte("#!/bin/sh\n"), mode)).To(Succeed())
+		}
+
+		BeforeEach(func() {
+			buildpackDir, err = ioutil.TempDir("", "nodejs-buildpack.buildpack.")
+			Expect(err).To(BeNil())
+		})
+
+		AfterEach(func() {
+			Expect(os.RemoveAll(buildpackDir)).To(Succeed())
+		})
+
+		It("finds the executables of the buildpack and then of the deps, by name", func() {
+			write(filepath.Join(buildpackDir, "hooks.d", "20-apm"), 0755)
+			write(filepath.Join(buildpackDir, "hooks.d", "10-sign"), 0755)
+			write(filepath.Join(depsDir, "0", "hooks.d", "audit"), 0755)
+
+			paths, err := hooks.FindExternalHooks(buildpackDir, depsDir)
+			Expect(err).To(BeNil())
+			Expect(paths).To(Equal([]string{
+				filepath.Join(buildpackDir, "hooks.d", "10-sign"),
+				filepath.Join(buildpackDir, "hooks.d", "20-apm"),
+				filepath.Join(depsDir, "0", "hooks.d", "audit"),
+			}))
+		})
+
+		It("skips files which aren't executable, hidden files and directories", func() {
+			write(filepath.Join(buildpackDir, "hooks.d", "README.md"), 0644)
+			write(filepath.Join(buildpackDir, "hooks.d", ".sign.swp"), 0755)
+			write(filepath.Join(buildpackDir, "hooks.d", "lib", "helper"), 0755)
+
+			paths, err := hooks.FindExternalHooks(buildpackDir, depsDir)
+			Expect(err).To(BeNil())
+			Expect(paths).To(BeEmpty())
+		})
+
+		It("finds nothing without hooks.d", func() {
+			paths, err := hooks.FindExternalHooks(buildpackDir, depsDir)
+			Expect(err).To(BeNil())
+			Expect(paths).To(BeEmpty())
+		})
+	})
+
+	Describe("the request", func() {
+		It("describes the staging context on stdin", func() {
+			os.Setenv("CF_STACK", "cflinuxfs4")
+			os.Setenv("VCAP_SERVICES", `{"apm":[{"name":"my-apm","tags":["monitoring"],"credentials":{"token":"tok-0123456789"}}]}`)
+			header := filepath.Join(depDir, "node", "include", "node", "node_version.h")
+			Expect(os.MkdirAll(filepath.Dir(header), 0755)).To(Succeed())
+			Expect(ioutil.WriteFile(header, []byte("#define NODE_MAJOR_VERSION 20\n#define NODE_MINOR_VERSION 11\n#define NODE_PATCH_VERSION 1\n"), 0644)).To(Succeed())
+			npmPackage := filepath.Join(depDir, "node", "lib", "node_modules", "npm", "package.json")
+			Expect(os.MkdirAll(filepath.Dir(npmPackage), 0755)).To(Succeed())
+			Expect(ioutil.WriteFile(npmPackage, []byte(`{"name":"npm","version":"10.2.4"}`), 0644)).To(Succeed())
+
+			hook := hooks.ExternalHook{Log: logger, Path: fixture("request.sh")}
+			Expect(hook.BeforeCompile(stager)).To(Succeed())
+
+			data, err := ioutil.ReadFile(filepath.Join(buildDir, "hook-request.json"))
+			Expect(err).To(BeNil())
+			Expect(string(data)).NotTo(ContainSubstring("tok-0123456789"))
+			var request hooks.ExternalRequest
+			Expect(json.Unmarshal(data, &request)).To(Succeed())
+			Expect(request).To(Equal(hooks.ExternalRequest{
+				Protocol: 1,
+				Phase:    "BeforeCompile",
+				BuildDir: buildDir,
+				CacheDir: cacheDir,
+				DepsDir:  depsDir,
+				DepDir:   depDir,
+				DepsIdx:  "1",
+				Stack:    "cflinuxfs4",
+				Versions: map[string]string{"node": "20.11.1", "npm": "10.2.4"},
+				Services: []hooks.ExternalService{{Label: "apm", Name: "my-apm", Tags: []string{"monitoring"}}},
+			}))
+		})
+
+		It("names the phase", func() {
+			hook := hooks.ExternalHook{Log: logger, Path: fixture("request.sh")}
+			Expect(hook.AfterCompile(stager)).To(Succeed())
+
+			data, err := ioutil.ReadFile(filepath.Join(buildDir, "hook-request.json"))
+			Expect(err).To(BeNil())
+			Expect(string(data)).To(ContainSubstring(`"phase":"AfterCompile"`))
+			Expect(string(data)).To(ContainSubstring(`"versions":{}`))
+			Expect(string(data)).To(ContainSubstring(`"services":[]`))
+		})
+	})
+
+	Describe("the response", func() {
+		It("applies the env and launch env and logs the warnings", func() {
+			hook := hooks.ExternalHook{Log: logger, Path: fixture("env.sh")}
+			Expect(hook.BeforeCompile(stager)).To(Succeed())
+
+			Expect(os.Getenv("SIGNING_KEY_ID")).To(Equal("k-123"))
+			contents, err := ioutil.ReadFile(filepath.Join(depDir, "env", "SIGNING_KEY_ID"))
+			Expect(err).To(BeNil())
+			Expect(string(contents)).To(Equal("k-123"))
+
+			contents, err = ioutil.ReadFile(filepath.Join(depDir, "profile.d", "001_nodejs_buildpack_hook_env.sh"))
+			Expect(err).To(BeNil())
+			Expect(string(contents)).To(Equal("export APM_ENABLED='true'\nexport APM_ENDPOINT='https://apm.internal/it'\\''s'\n"))
+
+			Expect(buffer.String()).To(ContainSubstring("signing assets"))
+			Expect(buffer.String()).To(ContainSubstring("**WARNING** asset signing is in preview"))
+			Expect(buffer.String()).To(ContainSubstring("Set SIGNING_KEY_ID for staging"))
+			Expect(buffer.String()).To(ContainSubstring("Set APM_ENDPOINT for the app"))
+		})
+
+		It("fails on output which isn't JSON", func() {
+			hook := hooks.ExternalHook{Log: logger, Path: fixture("invalid.sh")}
+			Expect(hook.BeforeCompile(stager)).To(MatchError(ContainSubstring("invalid.sh wrote an invalid response")))
+		})
+
+		It("fails on invalid variable names", func() {
+			_, err := hooks.ParseExternalResponse([]byte(`{"env": {"NOT-VALID": "x"}}`))
+			Expect(err).To(MatchError(`"NOT-VALID" is not a valid environment variable name`))
+
+			hook := hooks.ExternalHook{Log: logger, Path: fixture("invalid_env.sh")}
+			Expect(hook.BeforeCompile(stager)).NotTo(Succeed())
+		})
+
+		It("accepts an empty response", func() {
+			response, err := hooks.ParseExternalResponse([]byte("\n"))
+			Expect(err).To(BeNil())
+			Expect(response).To(Equal(hooks.ExternalResponse{}))
+		})
+	})
+
+	Context("run as an isolated hook", func() {
+		var (
+			output   *bytes.Buffer
+			isolated hooks.IsolatedHook
+		)
+
+		isolate := func(name string) {
+			clock := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+			isolated = hooks.IsolatedHook{
+				Name: name,
+				Out:  output,
+				NewHook: func(log *libbuildpack.Logger) libbuildpack.Hook {
+					return hooks.ExternalHook{Log: log, Path: fixture(name)}
+				},
+				Now: func() time.Time { return clock },
+			}
+		}
+
+		BeforeEach(func() {
+			output = new(bytes.Buffer)
+		})
+
+		It("fails staging like a built-in hook, with the output of the hook", func() {
+			isolate("fail.sh")
+
+			err := isolated.AfterCompile(stager)
+			Expect(err).To(MatchError(ContainSubstring("fail.sh failed: exit status 3")))
+			Expect(failure.CodeOf(err)).To(Equal(failure.HookFailed))
+			Expect(output.String()).To(ContainSubstring("[fail.sh] signing key missing"))
+			Expect(output.String()).To(ContainSubstring("[fail.sh] AfterCompile failed"))
+		})
+
+		It("summarizes the output of a successful hook", func() {
+			isolate("env.sh")
+
+			Expect(isolated.BeforeCompile(stager)).To(Succeed())
+			Expect(output.String()).To(ContainSubstring("[env.sh] BeforeCompile finished in 0s"))
+			Expect(output.String()).NotTo(ContainSubstring("signing assets"))
+		})
+	})
+})
@@ -1,6 +1,7 @@
 package hooks_test
 
 import (
+	"nodejs/network"
 	"testing"
 
 	. "github.com/onsi/ginkgo"
@@ -11,6 +12,7 @@ import (
 
 var _ = BeforeSuite(func() {
 	httpmock.Activate()
+	httpmock.ActivateNonDefault(network.Client)
 })
 
 var _ = AfterSuite(func() {
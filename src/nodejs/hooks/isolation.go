@@ -0,0 +1,251 @@
+package hooks
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"nodejs/failure"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudfoundry/libbuildpack"
+)
+
+// HookFactory builds a hook which logs through the given logger.
+type HookFactory func(log *libbuildpack.Logger) libbuildpack.Hook
+
+// IsolatedHook runs a hook with its own logger so that every line it
+// emits is prefixed with the hook name. Output of successful hooks is
+// collapsed into a single summary line and their warnings and errors unless
+// BP_DEBUG is set; the full output of a failing hook is always flushed. The credentials in
+// VCAP_SERVICES are masked in the output and the error of the hook. A
+// failing hook fails staging unless its FailurePolicy is warn. The
+// components a Contributor installed are recorded after AfterCompile.
+type IsolatedHook struct {
+	Name    string
+	Out     io.Writer
+	NewHook HookFactory
+	Now     func() time.Time
+}
+
+// Activator is implemented by hooks which can tell whether they would do
+// anything, without changing the app or the environment.
+type Activator interface {
+	Active() bool
+}
+
+var isolatedHooks []IsolatedHook
+
+const (
+	// PolicyFail fails staging when the hook fails, the default.
+	PolicyFail = "fail"
+	// PolicyWarn stages the app without the hook when it fails, so that an
+	// outage of a vendor doesn't block every deployment.
+	PolicyWarn = "warn"
+)
+
+var nonAlphanumeric = regexp.MustCompile(`[^A-Za-z0-9]+`)
+
+// PolicyEnv is the env var with the failure policy of the hook name, like
+// BP_HOOK_FAILURE_POLICY_APM_PRELOAD for apm-preload.
+func PolicyEnv(name string) string {
+	return "BP_HOOK_FAILURE_POLICY_" + strings.ToUpper(nonAlphanumeric.ReplaceAllString(name, "_"))
+}
+
+// GateHooks fail staging on purpose, like snyk when it finds
+// vulnerabilities, so BP_HOOK_FAILURE_POLICY doesn't turn them into a
+// warning. Only their own PolicyEnv does.
+var GateHooks = map[string]bool{"snyk": true}
+
+// FailurePolicy returns the failure policy of the hook name and the env var
+// it comes from: PolicyEnv(name), or else BP_HOOK_FAILURE_POLICY unless the
+// hook is one of GateHooks, or else PolicyFail.
+func FailurePolicy(name string) (string, string, error) {
+	envs := []string{PolicyEnv(name)}
+	if !GateHooks[name] {
+		envs = append(envs, "BP_HOOK_FAILURE_POLICY")
+	}
+	for _, env := range envs {
+		switch policy := os.Getenv(env); policy {
+		case "":
+			continue
+		case PolicyFail, PolicyWarn:
+			return policy, env, nil
+		default:
+			return PolicyFail, env, fmt.Errorf("%s=%s is not one of %s, %s", env, policy, PolicyFail, PolicyWarn)
+		}
+	}
+	return PolicyFail, "", nil
+}
+
+func AddIsolatedHook(name string, newHook HookFactory) {
+	hook := IsolatedHook{
+		Name:    name,
+		Out:     os.Stdout,
+		NewHook: newHook,
+		Now:     time.Now,
+	}
+	isolatedHooks = append(isolatedHooks, hook)
+	libbuildpack.AddHook(hook)
+}
+
+// ActiveHooks returns the names of the registered hooks which would run
+// during staging.
+func ActiveHooks(log *libbuildpack.Logger) []string {
+	var names []string
+	for _, hook := range isolatedHooks {
+		if activator, ok := hook.NewHook(log).(Activator); ok && activator.Active() {
+			names = append(names, hook.Name)
+		}
+	}
+	return names
+}
+
+func (h IsolatedHook) BeforeCompile(stager *libbuildpack.Stager) error {
+	return h.run("BeforeCompile", func(hook libbuildpack.Hook) error {
+		return hook.BeforeCompile(stager)
+	})
+}
+
+func (h IsolatedHook) AfterCompile(stager *libbuildpack.Stager) error {
+	return h.run("AfterCompile", func(hook libbuildpack.Hook) error {
+		if err := hook.AfterCompile(stager); err != nil {
+			return err
+		}
+		return recordContributions(hook, h.Name, stager)
+	})
+}
+
+func (h IsolatedHook) run(phase string, fn func(libbuildpack.Hook) error) error {
+	now := h.Now
+	if now == nil {
+		now = time.Now
+	}
+
+	buffer := new(bytes.Buffer)
+	prefixed := NewPrefixWriter(buffer, "["+h.Name+"] ")
+	warnings := new(bytes.Buffer)
+	prefixedWarnings := NewPrefixWriter(warnings, "["+h.Name+"] ")
+
+	start := now()
+	err := fn(h.NewHook(libbuildpack.NewLogger(io.MultiWriter(prefixed, warningWriter{prefixedWarnings}))))
+	prefixed.Flush()
+	prefixedWarnings.Flush()
+	elapsed := now().Sub(start).Round(time.Millisecond)
+
+	services, _ := ParseVCAPServices(os.Getenv("VCAP_SERVICES"))
+	redactor := NewRedactor(services)
+
+	debug := os.Getenv("BP_DEBUG") != ""
+	if err != nil || debug {
+		io.WriteString(h.Out, redactor.Redact(buffer.String()))
+	}
+
+	log := libbuildpack.NewLogger(h.Out)
+	if err == nil {
+		if buffer.Len() > 0 || debug {
+			log.Info("[%s] %s finished in %s (%d lines of output)", h.Name, phase, elapsed, bytes.Count(buffer.Bytes(), []byte("\n")))
+		}
+		if !debug {
+			io.WriteString(h.Out, redactor.Redact(warnings.String()))
+		}
+		return nil
+	}
+
+	err = redactor.Error(err)
+	policy, env, policyErr := FailurePolicy(h.Name)
+	if policyErr != nil {
+		log.Warning("Failing on the error of the %s hook: %s", h.Name, policyErr)
+	}
+	if policy == PolicyWarn {
+		consequence := fmt.Sprintf("what the %s hook sets up may be missing from the app", h.Name)
+		if GateHooks[h.Name] {
+			consequence = fmt.Sprintf("although the %s hook failed the build", h.Name)
+		}
+		log.Warning("[%s] %s failed after %s: %s\n"+
+			"Staging continues because of %s=%s, %s", h.Name, phase, elapsed, err, env, policy, consequence)
+		return nil
+	}
+	log.Error("[%s] %s failed after %s", h.Name, phase, elapsed)
+	return failure.Wrap(failure.HookFailed, err)
+}
+
+// warningWriter passes on the messages of a logger which are warnings or
+// errors. The logger writes every message at once, so their continuation
+// lines come along.
+type warningWriter struct {
+	w io.Writer
+}
+
+func (w warningWriter) Write(data []byte) (int, error) {
+	if bytes.Contains(data, []byte("**WARNING**")) || bytes.Contains(data, []byte("**ERROR**")) {
+		if _, err := w.w.Write(data); err != nil {
+			return 0, err
+		}
+	}
+	return len(data), nil
+}
+
+// PrefixWriter inserts a prefix at the start of every line written to it,
+// after any leading indentation so that log headers stay aligned.
+type PrefixWriter struct {
+	w       io.Writer
+	prefix  []byte
+	pending []byte
+	mu      sync.Mutex
+}
+
+func NewPrefixWriter(w io.Writer, prefix string) *PrefixWriter {
+	return &PrefixWriter{w: w, prefix: []byte(prefix)}
+}
+
+func (p *PrefixWriter) Write(data []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.pending = append(p.pending, data...)
+	for {
+		idx := bytes.IndexByte(p.pending, '\n')
+		if idx < 0 {
+			break
+		}
+		if err := p.writeLine(p.pending[:idx+1]); err != nil {
+			return 0, err
+		}
+		p.pending = p.pending[idx+1:]
+	}
+
+	return len(data), nil
+}
+
+// Flush writes out any trailing partial line.
+func (p *PrefixWriter) Flush() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.pending) == 0 {
+		return nil
+	}
+	err := p.writeLine(append(p.pending, '\n'))
+	p.pending = nil
+	return err
+}
+
+func (p *PrefixWriter) writeLine(line []byte) error {
+	if len(bytes.TrimSpace(line)) == 0 {
+		_, err := p.w.Write(line)
+		return err
+	}
+
+	indent := len(line) - len(bytes.TrimLeft(line, " \t"))
+
+	out := make([]byte, 0, len(line)+len(p.prefix))
+	out = append(out, line[:indent]...)
+	out = append(out, p.prefix...)
+	out = append(out, line[indent:]...)
+	_, err := p.w.Write(out)
+	return err
+}
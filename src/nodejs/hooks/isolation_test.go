@@ -0,0 +1,362 @@
+package hooks_test
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/cloudfoundry/libbuildpack"
+
+	"nodejs/failure"
+	"nodejs/hooks"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type fakeHook struct {
+	libbuildpack.DefaultHook
+	log *libbuildpack.Logger
+	err error
+}
+
+func (h fakeHook) AfterCompile(stager *libbuildpack.Stager) error {
+	h.log.BeginStep("Setting up agent")
+	h.log.Info("downloading agent...")
+	h.log.Warning("agent is old\nplease upgrade")
+	return h.err
+}
+
+// leakyHook echoes its command and dumps its credentials on error.
+type leakyHook struct {
+	libbuildpack.DefaultHook
+	log *libbuildpack.Logger
+}
+
+func (h leakyHook) AfterCompile(stager *libbuildpack.Stager) error {
+	h.log.Info("curl https://agent.example.com/install?token=tok-0123456789")
+	h.log.Error("Credentials:\n{\n  \"token\": \"tok-0123456789\"\n}")
+	return errors.New("agent rejected the token tok-0123456789")
+}
+
+// quietHook logs an error of its agent and succeeds anyway, like a hook
+// skipping errors.
+type quietHook struct {
+	libbuildpack.DefaultHook
+	log *libbuildpack.Logger
+}
+
+func (h quietHook) AfterCompile(stager *libbuildpack.Stager) error {
+	h.log.Info("checking agent")
+	h.log.Info("agent is up to date")
+	h.log.Error("agent rejected the token tok-0123456789")
+	return nil
+}
+
+// agentHook installs an agent and contributes it.
+type agentHook struct {
+	fakeHook
+}
+
+func (h agentHook) Contributions(stager *libbuildpack.Stager) ([]hooks.Component, error) {
+	return []hooks.Component{{Name: "agent", Version: "1.2.3", Source: "https://agent.example.com/agent-1.2.3.tgz", SHA256: "abc123"}}, nil
+}
+
+var _ = Describe("IsolatedHook", func() {
+	var (
+		err        error
+		buffer     *bytes.Buffer
+		hookErr    error
+		isolated   hooks.IsolatedHook
+		oldBpDebug string
+	)
+
+	BeforeEach(func() {
+		oldBpDebug = os.Getenv("BP_DEBUG")
+		os.Unsetenv("BP_DEBUG")
+
+		buffer = new(bytes.Buffer)
+		hookErr = nil
+
+		clock := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+		isolated = hooks.IsolatedHook{
+			Name: "fake",
+			Out:  buffer,
+			NewHook: func(log *libbuildpack.Logger) libbuildpack.Hook {
+				return fakeHook{log: log, err: hookErr}
+			},
+			Now: func() time.Time {
+				clock = clock.Add(1500 * time.Millisecond)
+				return clock
+			},
+		}
+	})
+
+	AfterEach(func() {
+		os.Setenv("BP_DEBUG", oldBpDebug)
+	})
+
+	Context("the hook succeeds", func() {
+		It("collapses the output into a summary line and the warnings", func() {
+			err = isolated.AfterCompile(&libbuildpack.Stager{})
+			Expect(err).To(BeNil())
+
+			Expect(buffer.String()).To(Equal("       [fake] AfterCompile finished in 1.5s (4 lines of output)\n" +
+				"       [fake] \033[31;1m**WARNING**\033[0m agent is old\n" +
+				"       [fake] please upgrade\n"))
+		})
+
+		It("keeps the errors the hook logs, with their credentials masked", func() {
+			oldServices, hadServices := os.LookupEnv("VCAP_SERVICES")
+			defer func() {
+				if hadServices {
+					os.Setenv("VCAP_SERVICES", oldServices)
+				} else {
+					os.Unsetenv("VCAP_SERVICES")
+				}
+			}()
+			os.Setenv("VCAP_SERVICES", `{"agent": [{"name": "agent", "credentials": {"token": "tok-0123456789"}}]}`)
+			isolated.NewHook = func(log *libbuildpack.Logger) libbuildpack.Hook {
+				return quietHook{log: log}
+			}
+
+			err = isolated.AfterCompile(&libbuildpack.Stager{})
+			Expect(err).To(BeNil())
+
+			Expect(buffer.String()).To(ContainSubstring("[fake] AfterCompile finished in 1.5s (3 lines of output)\n"))
+			Expect(buffer.String()).To(ContainSubstring("[fake] \033[31;1m**ERROR**\033[0m agent rejected the token **********6789\n"))
+			Expect(buffer.String()).NotTo(ContainSubstring("checking agent"))
+		})
+
+		Context("BP_DEBUG is set", func() {
+			BeforeEach(func() {
+				os.Setenv("BP_DEBUG", "true")
+			})
+
+			It("prints every line prefixed with the hook name", func() {
+				err = isolated.AfterCompile(&libbuildpack.Stager{})
+				Expect(err).To(BeNil())
+
+				Expect(buffer.String()).To(ContainSubstring("[fake] -----> Setting up agent\n"))
+				Expect(buffer.String()).To(ContainSubstring("       [fake] downloading agent...\n"))
+				Expect(buffer.String()).To(MatchRegexp(`\[fake\] \S*\*\*WARNING\*\*`))
+				Expect(buffer.String()).To(ContainSubstring("       [fake] please upgrade\n"))
+				Expect(buffer.String()).To(ContainSubstring("[fake] AfterCompile finished in 1.5s"))
+			})
+		})
+	})
+
+	Context("the hook fails", func() {
+		BeforeEach(func() {
+			hookErr = errors.New("agent download failed")
+		})
+
+		It("returns the error and flushes the full buffered output", func() {
+			err = isolated.AfterCompile(&libbuildpack.Stager{})
+			Expect(err).To(MatchError("agent download failed"))
+			Expect(failure.CodeOf(err)).To(Equal(failure.HookFailed))
+
+			Expect(buffer.String()).To(ContainSubstring("[fake] -----> Setting up agent\n"))
+			Expect(buffer.String()).To(ContainSubstring("       [fake] downloading agent...\n"))
+			Expect(buffer.String()).To(ContainSubstring("       [fake] please upgrade\n"))
+			Expect(buffer.String()).To(ContainSubstring("[fake] AfterCompile failed after 1.5s"))
+		})
+	})
+
+	Context("the hook fails with a failure policy", func() {
+		var oldEnv map[string]string
+
+		BeforeEach(func() {
+			hookErr = errors.New("agent download failed")
+			oldEnv = map[string]string{}
+			for _, key := range []string{"BP_HOOK_FAILURE_POLICY", "BP_HOOK_FAILURE_POLICY_FAKE"} {
+				oldEnv[key] = os.Getenv(key)
+				os.Unsetenv(key)
+			}
+		})
+
+		AfterEach(func() {
+			for key, value := range oldEnv {
+				os.Setenv(key, value)
+			}
+		})
+
+		It("fails by default", func() {
+			policy, env, err := hooks.FailurePolicy("fake")
+			Expect(err).To(BeNil())
+			Expect(policy).To(Equal(hooks.PolicyFail))
+			Expect(env).To(Equal(""))
+
+			Expect(isolated.AfterCompile(&libbuildpack.Stager{})).To(MatchError("agent download failed"))
+		})
+
+		It("warns and continues with BP_HOOK_FAILURE_POLICY=warn", func() {
+			os.Setenv("BP_HOOK_FAILURE_POLICY", "warn")
+
+			Expect(isolated.AfterCompile(&libbuildpack.Stager{})).To(Succeed())
+			Expect(buffer.String()).To(ContainSubstring("       [fake] downloading agent...\n"))
+			Expect(buffer.String()).To(MatchRegexp(`\*\*WARNING\*\*\S* \[fake\] AfterCompile failed after 1.5s: agent download failed`))
+			Expect(buffer.String()).To(ContainSubstring("Staging continues because of BP_HOOK_FAILURE_POLICY=warn, what the fake hook sets up may be missing from the app"))
+		})
+
+		It("lets the policy of the hook override the global one", func() {
+			os.Setenv("BP_HOOK_FAILURE_POLICY", "warn")
+			os.Setenv("BP_HOOK_FAILURE_POLICY_FAKE", "fail")
+
+			err = isolated.AfterCompile(&libbuildpack.Stager{})
+			Expect(err).To(MatchError("agent download failed"))
+			Expect(failure.CodeOf(err)).To(Equal(failure.HookFailed))
+
+			os.Setenv("BP_HOOK_FAILURE_POLICY", "fail")
+			os.Setenv("BP_HOOK_FAILURE_POLICY_FAKE", "warn")
+			Expect(isolated.AfterCompile(&libbuildpack.Stager{})).To(Succeed())
+			Expect(buffer.String()).To(ContainSubstring("because of BP_HOOK_FAILURE_POLICY_FAKE=warn"))
+		})
+
+		Context("the hook is a gate, like snyk", func() {
+			BeforeEach(func() {
+				isolated.Name = "snyk"
+				for _, key := range []string{"BP_HOOK_FAILURE_POLICY_SNYK"} {
+					oldEnv[key] = os.Getenv(key)
+					os.Unsetenv(key)
+				}
+			})
+
+			It("fails despite BP_HOOK_FAILURE_POLICY=warn", func() {
+				os.Setenv("BP_HOOK_FAILURE_POLICY", "warn")
+
+				policy, env, err := hooks.FailurePolicy("snyk")
+				Expect(err).To(BeNil())
+				Expect(policy).To(Equal(hooks.PolicyFail))
+				Expect(env).To(Equal(""))
+				Expect(isolated.AfterCompile(&libbuildpack.Stager{})).To(MatchError("agent download failed"))
+			})
+
+			It("warns and continues with BP_HOOK_FAILURE_POLICY_SNYK=warn", func() {
+				os.Setenv("BP_HOOK_FAILURE_POLICY_SNYK", "warn")
+
+				Expect(isolated.AfterCompile(&libbuildpack.Stager{})).To(Succeed())
+				Expect(buffer.String()).To(ContainSubstring("Staging continues because of BP_HOOK_FAILURE_POLICY_SNYK=warn, although the snyk hook failed the build"))
+			})
+		})
+
+		It("fails for an unknown policy", func() {
+			os.Setenv("BP_HOOK_FAILURE_POLICY_FAKE", "ignore")
+
+			Expect(isolated.AfterCompile(&libbuildpack.Stager{})).To(MatchError("agent download failed"))
+			Expect(buffer.String()).To(ContainSubstring("Failing on the error of the fake hook: BP_HOOK_FAILURE_POLICY_FAKE=ignore is not one of fail, warn"))
+		})
+
+		It("names the env var of a hook after it", func() {
+			Expect(hooks.PolicyEnv("dynatrace")).To(Equal("BP_HOOK_FAILURE_POLICY_DYNATRACE"))
+			Expect(hooks.PolicyEnv("apm-preload")).To(Equal("BP_HOOK_FAILURE_POLICY_APM_PRELOAD"))
+			Expect(hooks.PolicyEnv("install-agent.sh")).To(Equal("BP_HOOK_FAILURE_POLICY_INSTALL_AGENT_SH"))
+		})
+	})
+
+	Context("the hook leaks credentials", func() {
+		var oldServices string
+
+		BeforeEach(func() {
+			oldServices = os.Getenv("VCAP_SERVICES")
+			os.Setenv("VCAP_SERVICES", `{"agent": [{"name": "agent", "credentials": {"token": "tok-0123456789"}}]}`)
+			isolated.NewHook = func(log *libbuildpack.Logger) libbuildpack.Hook {
+				return leakyHook{log: log}
+			}
+		})
+
+		AfterEach(func() {
+			os.Setenv("VCAP_SERVICES", oldServices)
+		})
+
+		It("masks them in the output and the error", func() {
+			err = isolated.AfterCompile(&libbuildpack.Stager{})
+			Expect(err).To(MatchError("agent rejected the token **********6789"))
+			Expect(failure.CodeOf(err)).To(Equal(failure.HookFailed))
+
+			Expect(buffer.String()).NotTo(ContainSubstring("tok-0123456789"))
+			Expect(buffer.String()).To(ContainSubstring("[fake] curl https://agent.example.com/install?token=**********6789\n"))
+			Expect(buffer.String()).To(ContainSubstring(`[fake] "token": "**********6789"`))
+		})
+	})
+
+	Context("the hook produces no output", func() {
+		BeforeEach(func() {
+			isolated.NewHook = func(log *libbuildpack.Logger) libbuildpack.Hook {
+				return libbuildpack.DefaultHook{}
+			}
+		})
+
+		It("prints nothing", func() {
+			err = isolated.AfterCompile(&libbuildpack.Stager{})
+			Expect(err).To(BeNil())
+
+			Expect(buffer.String()).To(Equal(""))
+		})
+	})
+	Context("the hook contributes components", func() {
+		var (
+			depsDir string
+			stager  *libbuildpack.Stager
+		)
+
+		BeforeEach(func() {
+			depsDir, err = ioutil.TempDir("", "nodejs-buildpack.deps.")
+			Expect(err).To(BeNil())
+			Expect(os.MkdirAll(filepath.Join(depsDir, "0"), 0755)).To(Succeed())
+			stager = libbuildpack.NewStager([]string{"", "", depsDir, "0"}, libbuildpack.NewLogger(buffer), &libbuildpack.Manifest{})
+
+			isolated.NewHook = func(log *libbuildpack.Logger) libbuildpack.Hook {
+				return agentHook{fakeHook{log: log, err: hookErr}}
+			}
+		})
+
+		AfterEach(func() {
+			Expect(os.RemoveAll(depsDir)).To(Succeed())
+		})
+
+		It("records them with the name of the hook", func() {
+			Expect(isolated.AfterCompile(stager)).To(Succeed())
+			Expect(isolated.AfterCompile(stager)).To(Succeed())
+
+			components, err := hooks.FinishComponents(stager.DepDir())
+			Expect(err).To(BeNil())
+			agent := hooks.Component{Name: "agent", Version: "1.2.3", Source: "https://agent.example.com/agent-1.2.3.tgz", SHA256: "abc123", Hook: "fake"}
+			Expect(components).To(Equal([]hooks.Component{agent, agent}))
+			Expect(filepath.Join(stager.DepDir(), hooks.ComponentsFile)).NotTo(BeAnExistingFile())
+		})
+
+		It("records nothing when the hook fails", func() {
+			hookErr = errors.New("agent download failed")
+			Expect(isolated.AfterCompile(stager)).NotTo(Succeed())
+
+			components, err := hooks.FinishComponents(stager.DepDir())
+			Expect(err).To(BeNil())
+			Expect(components).To(BeEmpty())
+		})
+
+		It("records nothing for hooks which contribute nothing", func() {
+			isolated.NewHook = func(log *libbuildpack.Logger) libbuildpack.Hook {
+				return fakeHook{log: log}
+			}
+			Expect(isolated.AfterCompile(stager)).To(Succeed())
+			Expect(filepath.Join(stager.DepDir(), hooks.ComponentsFile)).NotTo(BeAnExistingFile())
+		})
+	})
+})
+
+var _ = Describe("PrefixWriter", func() {
+	It("prefixes lines split across writes", func() {
+		buffer := new(bytes.Buffer)
+		writer := hooks.NewPrefixWriter(buffer, "[x] ")
+
+		writer.Write([]byte("one\n  tw"))
+		writer.Write([]byte("o\n\nthree"))
+		Expect(writer.Flush()).To(Succeed())
+
+		Expect(buffer.String()).To(Equal("[x] one\n  [x] two\n\n[x] three\n"))
+	})
+})
@@ -0,0 +1,175 @@
+package hooks
+
+import (
+	"fmt"
+	"io/ioutil"
+	"nodejs/profiled"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/Masterminds/semver"
+	"github.com/cloudfoundry/libbuildpack"
+)
+
+// AgentPreload is how an APM agent is loaded into the app through
+// NODE_OPTIONS. Import is its ESM entry for --import, Loader its loader for
+// --experimental-loader, and Require its CommonJS entry. Agents which only
+// hook ESM in Import or Loader and start from Require need both, so
+// RequireWithESM adds Require to the ESM flags.
+type AgentPreload struct {
+	Require        string
+	Import         string
+	Loader         string
+	RequireWithESM bool
+}
+
+// AgentPreloads maps the npm package of an agent to its entry points.
+var AgentPreloads = map[string]AgentPreload{
+	"newrelic": {
+		Require:        "newrelic",
+		Import:         "newrelic/esm-loader.mjs",
+		Loader:         "newrelic/esm-loader.mjs",
+		RequireWithESM: true,
+	},
+	"dd-trace": {
+		Require: "dd-trace/init",
+		Import:  "dd-trace/initialize.mjs",
+		Loader:  "dd-trace/loader-hook.mjs",
+	},
+	"elastic-apm-node": {
+		Require:        "elastic-apm-node/start",
+		Loader:         "elastic-apm-node/loader.mjs",
+		RequireWithESM: true,
+	},
+	"@appsignal/nodejs": {
+		Require: "@appsignal/nodejs",
+		Import:  "@appsignal/nodejs",
+	},
+	"@opentelemetry/auto-instrumentations-node": {
+		Require: "@opentelemetry/auto-instrumentations-node/register",
+		Import:  "@opentelemetry/auto-instrumentations-node/register",
+	},
+}
+
+var (
+	// importSince is the first Node.js with module.register(), which --import
+	// entries of agents use to hook ESM.
+	importSince = semver.MustParse("20.6.0")
+	loaderSince = semver.MustParse("18.0.0")
+)
+
+// PreloadFlags returns the NODE_OPTIONS flags which load agent into Node.js
+// nodeVersion, and the mechanism they use: --import from Node.js 20.6,
+// --experimental-loader from 18 on where the agent has no --import entry or
+// the node is older, and --require otherwise. An unknown agent or version
+// gets --require, which still instruments CommonJS.
+func PreloadFlags(nodeVersion, agent string) ([]string, string) {
+	preload, known := AgentPreloads[agent]
+	if !known {
+		return []string{"--require", agent}, "--require"
+	}
+	require := []string{"--require", preload.Require}
+
+	version, err := semver.NewVersion(nodeVersion)
+	if err != nil {
+		return require, "--require"
+	}
+
+	var flags []string
+	mechanism := "--require"
+	switch {
+	case preload.Import != "" && !version.LessThan(importSince):
+		flags, mechanism = []string{"--import", preload.Import}, "--import"
+	case preload.Loader != "" && !version.LessThan(loaderSince):
+		flags, mechanism = []string{"--experimental-loader", preload.Loader}, "--experimental-loader"
+	default:
+		return require, mechanism
+	}
+	if preload.RequireWithESM {
+		flags = append(flags, require...)
+	}
+	return flags, mechanism
+}
+
+// APMPreloadHook loads the agents listed in BP_APM_PRELOAD into the app with
+// the mechanism PreloadFlags picks for the installed Node.js, so that ESM
+// apps are instrumented as well.
+type APMPreloadHook struct {
+	libbuildpack.DefaultHook
+	Log *libbuildpack.Logger
+}
+
+func init() {
+	AddIsolatedHook("apm-preload", func(logger *libbuildpack.Logger) libbuildpack.Hook {
+		return APMPreloadHook{Log: logger}
+	})
+}
+
+// Active reports whether BP_APM_PRELOAD lists an agent.
+func (h APMPreloadHook) Active() bool {
+	return len(preloadAgents()) > 0
+}
+
+func (h APMPreloadHook) AfterCompile(stager *libbuildpack.Stager) error {
+	agents := preloadAgents()
+	if len(agents) == 0 {
+		return nil
+	}
+
+	nodeVersion := installedNodeVersion(stager.DepDir())
+	var options []string
+	for _, agent := range agents {
+		installed := false
+		for _, dir := range []string{stager.BuildDir(), stager.DepDir()} {
+			found, err := libbuildpack.FileExists(filepath.Join(dir, "node_modules", agent, "package.json"))
+			if err != nil {
+				return err
+			}
+			installed = installed || found
+		}
+		if !installed {
+			h.Log.Warning("BP_APM_PRELOAD lists %s, but it is not installed\nAdd %s to the dependencies in package.json", agent, agent)
+			continue
+		}
+
+		flags, mechanism := PreloadFlags(nodeVersion, agent)
+		h.Log.Info("Preloading %s with %s for Node.js %s: %s", agent, mechanism, nodeVersion, strings.Join(flags, " "))
+		options = append(options, flags...)
+	}
+	if len(options) == 0 {
+		return nil
+	}
+
+	return profiled.Write(stager, "apm_preload.sh", `export NODE_OPTIONS="${NODE_OPTIONS:+$NODE_OPTIONS }`+strings.Join(options, " ")+`"`+"\n")
+}
+
+func preloadAgents() []string {
+	var agents []string
+	for _, agent := range strings.Split(os.Getenv("BP_APM_PRELOAD"), ",") {
+		if agent = strings.TrimSpace(agent); agent != "" {
+			agents = append(agents, agent)
+		}
+	}
+	return agents
+}
+
+var nodeVersionDefine = regexp.MustCompile(`#define NODE_(MAJOR|MINOR|PATCH)_VERSION (\d+)`)
+
+// installedNodeVersion reads the version of the node installed in depDir
+// from its headers, or returns "" when it is unknown.
+func installedNodeVersion(depDir string) string {
+	contents, err := ioutil.ReadFile(filepath.Join(depDir, "node", "include", "node", "node_version.h"))
+	if err != nil {
+		return ""
+	}
+	parts := map[string]string{}
+	for _, m := range nodeVersionDefine.FindAllStringSubmatch(string(contents), -1) {
+		parts[m[1]] = m[2]
+	}
+	if len(parts) != 3 {
+		return ""
+	}
+	return fmt.Sprintf("%s.%s.%s", parts["MAJOR"], parts["MINOR"], parts["PATCH"])
+}
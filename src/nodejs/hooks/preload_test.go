@@ -0,0 +1,114 @@
+package hooks_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/cloudfoundry/libbuildpack/ansicleaner"
+
+	"nodejs/hooks"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("APM preloads", func() {
+	DescribeTable("PreloadFlags",
+		func(nodeVersion, agent string, flags []string, mechanism string) {
+			actualFlags, actualMechanism := hooks.PreloadFlags(nodeVersion, agent)
+			Expect(actualFlags).To(Equal(flags))
+			Expect(actualMechanism).To(Equal(mechanism))
+		},
+		Entry("newrelic on Node.js 22", "22.3.0", "newrelic", []string{"--import", "newrelic/esm-loader.mjs", "--require", "newrelic"}, "--import"),
+		Entry("newrelic on Node.js 20.6", "20.6.0", "newrelic", []string{"--import", "newrelic/esm-loader.mjs", "--require", "newrelic"}, "--import"),
+		Entry("newrelic on Node.js 20.5", "20.5.1", "newrelic", []string{"--experimental-loader", "newrelic/esm-loader.mjs", "--require", "newrelic"}, "--experimental-loader"),
+		Entry("newrelic on Node.js 18", "18.19.0", "newrelic", []string{"--experimental-loader", "newrelic/esm-loader.mjs", "--require", "newrelic"}, "--experimental-loader"),
+		Entry("newrelic on Node.js 16", "16.20.2", "newrelic", []string{"--require", "newrelic"}, "--require"),
+
+		Entry("dd-trace on Node.js 22", "22.3.0", "dd-trace", []string{"--import", "dd-trace/initialize.mjs"}, "--import"),
+		Entry("dd-trace on Node.js 18", "18.19.0", "dd-trace", []string{"--experimental-loader", "dd-trace/loader-hook.mjs"}, "--experimental-loader"),
+		Entry("dd-trace on Node.js 16", "16.20.2", "dd-trace", []string{"--require", "dd-trace/init"}, "--require"),
+
+		Entry("elastic-apm-node on Node.js 22, without an --import entry", "22.3.0", "elastic-apm-node", []string{"--experimental-loader", "elastic-apm-node/loader.mjs", "--require", "elastic-apm-node/start"}, "--experimental-loader"),
+		Entry("elastic-apm-node on Node.js 18", "18.19.0", "elastic-apm-node", []string{"--experimental-loader", "elastic-apm-node/loader.mjs", "--require", "elastic-apm-node/start"}, "--experimental-loader"),
+		Entry("elastic-apm-node on Node.js 16", "16.20.2", "elastic-apm-node", []string{"--require", "elastic-apm-node/start"}, "--require"),
+
+		Entry("OpenTelemetry on Node.js 22", "22.3.0", "@opentelemetry/auto-instrumentations-node", []string{"--import", "@opentelemetry/auto-instrumentations-node/register"}, "--import"),
+		Entry("OpenTelemetry on Node.js 18, without a loader", "18.19.0", "@opentelemetry/auto-instrumentations-node", []string{"--require", "@opentelemetry/auto-instrumentations-node/register"}, "--require"),
+
+		Entry("AppSignal on Node.js 22", "22.3.0", "@appsignal/nodejs", []string{"--import", "@appsignal/nodejs"}, "--import"),
+		Entry("AppSignal on Node.js 18, without a loader", "18.19.0", "@appsignal/nodejs", []string{"--require", "@appsignal/nodejs"}, "--require"),
+
+		Entry("an unknown Node.js version", "", "newrelic", []string{"--require", "newrelic"}, "--require"),
+		Entry("an unknown agent", "22.3.0", "my-agent", []string{"--require", "my-agent"}, "--require"),
+	)
+
+	Describe("APMPreloadHook", func() {
+		var (
+			err      error
+			buildDir string
+			depsDir  string
+			buffer   *bytes.Buffer
+			stager   *libbuildpack.Stager
+			hook     hooks.APMPreloadHook
+			oldEnv   string
+			hadEnv   bool
+		)
+
+		writeFile := func(path, contents string) {
+			Expect(os.MkdirAll(filepath.Dir(path), 0755)).To(Succeed())
+			Expect(ioutil.WriteFile(path, []byte(contents), 0644)).To(Succeed())
+		}
+
+		BeforeEach(func() {
+			oldEnv, hadEnv = os.LookupEnv("BP_APM_PRELOAD")
+			os.Unsetenv("BP_APM_PRELOAD")
+
+			buildDir, err = ioutil.TempDir("", "nodejs-buildpack.build.")
+			Expect(err).To(BeNil())
+			depsDir, err = ioutil.TempDir("", "nodejs-buildpack.deps.")
+			Expect(err).To(BeNil())
+			writeFile(filepath.Join(depsDir, "0", "node", "include", "node", "node_version.h"), "#define NODE_MAJOR_VERSION 20\n#define NODE_MINOR_VERSION 11\n#define NODE_PATCH_VERSION 1\n")
+
+			buffer = new(bytes.Buffer)
+			logger := libbuildpack.NewLogger(ansicleaner.New(buffer))
+			stager = libbuildpack.NewStager([]string{buildDir, "", depsDir, "0"}, logger, &libbuildpack.Manifest{})
+			hook = hooks.APMPreloadHook{Log: logger}
+		})
+
+		AfterEach(func() {
+			if hadEnv {
+				os.Setenv("BP_APM_PRELOAD", oldEnv)
+			} else {
+				os.Unsetenv("BP_APM_PRELOAD")
+			}
+			Expect(os.RemoveAll(buildDir)).To(Succeed())
+			Expect(os.RemoveAll(depsDir)).To(Succeed())
+		})
+
+		It("is inactive without BP_APM_PRELOAD", func() {
+			Expect(hook.Active()).To(BeFalse())
+			Expect(hook.AfterCompile(stager)).To(Succeed())
+			Expect(filepath.Join(depsDir, "0", "profile.d", "000_nodejs_buildpack_apm_preload.sh")).NotTo(BeAnExistingFile())
+		})
+
+		It("preloads the installed agents with the mechanism for the installed node", func() {
+			os.Setenv("BP_APM_PRELOAD", "newrelic, dd-trace,elastic-apm-node")
+			writeFile(filepath.Join(buildDir, "node_modules", "newrelic", "package.json"), "{}")
+			writeFile(filepath.Join(buildDir, "node_modules", "dd-trace", "package.json"), "{}")
+			Expect(hook.Active()).To(BeTrue())
+
+			Expect(hook.AfterCompile(stager)).To(Succeed())
+
+			contents, err := ioutil.ReadFile(filepath.Join(depsDir, "0", "profile.d", "000_nodejs_buildpack_apm_preload.sh"))
+			Expect(err).To(BeNil())
+			Expect(string(contents)).To(Equal(`export NODE_OPTIONS="${NODE_OPTIONS:+$NODE_OPTIONS }--import newrelic/esm-loader.mjs --require newrelic --import dd-trace/initialize.mjs"` + "\n"))
+			Expect(buffer.String()).To(ContainSubstring("Preloading newrelic with --import for Node.js 20.11.1: --import newrelic/esm-loader.mjs --require newrelic"))
+			Expect(buffer.String()).To(ContainSubstring("BP_APM_PRELOAD lists elastic-apm-node, but it is not installed"))
+		})
+	})
+})
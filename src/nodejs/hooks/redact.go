@@ -0,0 +1,125 @@
+package hooks
+
+import (
+	"encoding/json"
+	"errors"
+	"net/url"
+	"nodejs/failure"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	// redactMinLength is the length from which every credential string is
+	// masked. Shorter ones, like ports and flags, are only masked under a
+	// secretKey, masking them everywhere would garble unrelated output.
+	redactMinLength = 8
+	// redactVisible is how many characters a masked value keeps at its end.
+	redactVisible = 4
+)
+
+var secretKey = regexp.MustCompile(`(?i)token|key|secret|password|auth`)
+
+// Redactor masks the credentials of the service bindings in the output of
+// the hooks, which echo the commands they run and the responses of failed
+// requests.
+type Redactor struct {
+	replacer *strings.Replacer
+}
+
+// NewRedactor masks the strings in the credentials of services, and the
+// passwords of the URLs among them, as they are and as they appear escaped
+// in URLs and JSON.
+func NewRedactor(services VCAPServices) *Redactor {
+	secrets := map[string]bool{}
+	add := func(secret string) {
+		for _, variant := range []string{secret, url.QueryEscape(secret), url.PathEscape(secret), jsonEscape(secret)} {
+			if variant != "" {
+				secrets[variant] = true
+			}
+		}
+	}
+	for _, service := range services.All() {
+		collectSecrets(service.Credentials, false, add)
+	}
+
+	var sorted []string
+	for secret := range secrets {
+		sorted = append(sorted, secret)
+	}
+	// Longer secrets go first, so that a secret which contains another is
+	// masked whole.
+	sort.Slice(sorted, func(i, j int) bool {
+		if len(sorted[i]) != len(sorted[j]) {
+			return len(sorted[i]) > len(sorted[j])
+		}
+		return sorted[i] < sorted[j]
+	})
+
+	var pairs []string
+	for _, secret := range sorted {
+		pairs = append(pairs, secret, mask(secret))
+	}
+	return &Redactor{replacer: strings.NewReplacer(pairs...)}
+}
+
+// collectSecrets passes the strings of a credentials value to add, walking
+// nested objects and lists.
+func collectSecrets(value interface{}, sensitive bool, add func(string)) {
+	switch v := value.(type) {
+	case string:
+		if sensitive || len(v) >= redactMinLength {
+			add(v)
+		}
+		if u, err := url.Parse(v); err == nil && u.User != nil {
+			if password, found := u.User.Password(); found && password != "" {
+				add(password)
+			}
+		}
+	case map[string]interface{}:
+		for key, item := range v {
+			collectSecrets(item, sensitive || secretKey.MatchString(key), add)
+		}
+	case []interface{}:
+		for _, item := range v {
+			collectSecrets(item, sensitive, add)
+		}
+	}
+}
+
+// Redact masks the credentials in s.
+func (r *Redactor) Redact(s string) string {
+	return r.replacer.Replace(s)
+}
+
+// Error masks the credentials in the message of err, keeping its code.
+func (r *Redactor) Error(err error) error {
+	if err == nil {
+		return nil
+	}
+	message := r.Redact(err.Error())
+	if message == err.Error() {
+		return err
+	}
+	if e, ok := err.(*failure.Error); ok {
+		return &failure.Error{Code: e.Code, Err: errors.New(message)}
+	}
+	return errors.New(message)
+}
+
+// mask replaces all but the last characters of secret with asterisks.
+func mask(secret string) string {
+	if len(secret) <= redactVisible {
+		return strings.Repeat("*", len(secret))
+	}
+	return strings.Repeat("*", len(secret)-redactVisible) + secret[len(secret)-redactVisible:]
+}
+
+func jsonEscape(s string) string {
+	encoded, err := json.Marshal(s)
+	if err != nil {
+		return ""
+	}
+	return strings.Trim(string(encoded), `"`)
+}
@@ -2,6 +2,8 @@ package hooks
 
 import (
 	"encoding/json"
+	"io/ioutil"
+	"nodejs/vcap"
 	"os"
 	"path/filepath"
 	"strings"
@@ -33,16 +35,15 @@ type SnykCredentials struct {
 const snykLocalAgentPath = "node_modules/snyk/cli/index.js"
 
 func init() {
-	logger := libbuildpack.NewLogger(os.Stdout)
-	command := &libbuildpack.Command{}
-
-	libbuildpack.AddHook(SnykHook{
-		Log:         logger,
-		SnykCommand: command,
-		buildDir:    "",
-		depsDir:     "",
-		localAgent:  true,
-		orgName:     "",
+	AddIsolatedHook("snyk", func(logger *libbuildpack.Logger) libbuildpack.Hook {
+		return SnykHook{
+			Log:         logger,
+			SnykCommand: &libbuildpack.Command{},
+			buildDir:    "",
+			depsDir:     "",
+			localAgent:  true,
+			orgName:     "",
+		}
 	})
 }
 
@@ -106,13 +107,45 @@ func (h SnykHook) AfterCompile(stager *libbuildpack.Stager) error {
 	return nil
 }
 
+// Active reports whether a Snyk token is set or a snyk service is bound.
+func (h SnykHook) Active() bool {
+	if os.Getenv("SNYK_TOKEN") != "" {
+		return true
+	}
+	found, _ := h.getCredentialsFromService(LoadVCAPServices(h.Log))
+	return found
+}
+
+// Contributions describes the Snyk CLI installed globally when the app has
+// none of its own, the one in node_modules is found by scanning those.
+func (h SnykHook) Contributions(stager *libbuildpack.Stager) ([]Component, error) {
+	if !h.Active() {
+		return nil, nil
+	}
+	raw, err := ioutil.ReadFile(filepath.Join(stager.DepDir(), "node", "lib", "node_modules", "snyk", "package.json"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var pkg struct {
+		Version   string `json:"version"`
+		Resolved  string `json:"_resolved"`
+		Integrity string `json:"_integrity"`
+	}
+	if err := json.Unmarshal(raw, &pkg); err != nil {
+		return nil, err
+	}
+	return []Component{{Name: "snyk", Version: pkg.Version, Source: pkg.Resolved, Integrity: pkg.Integrity}}, nil
+}
+
 func (h SnykHook) isTokenExists() bool {
 	token := os.Getenv("SNYK_TOKEN")
 	if token != "" {
 		return true
 	}
 
-	status, snykCredentials := h.getCredentialsFromService()
+	status, snykCredentials := h.getCredentialsFromService(LoadVCAPServices(h.Log))
 	if status {
 		os.Setenv("SNYK_TOKEN", snykCredentials.ApiToken)
 		if snykCredentials.ApiUrl != "" {
@@ -212,34 +245,14 @@ func (h SnykHook) runProtect() error {
 	return err
 }
 
-func getCredentialString(credentials map[string]interface{}, key string) string {
-	value, isString := credentials[key].(string)
-
-	if isString {
-		return value
-	}
-	return ""
-}
-
-func (h SnykHook) getCredentialsFromService() (bool, SnykCredentials) {
-	type Service struct {
-		Name        string                 `json:"name"`
-		Credentials map[string]interface{} `json:"credentials"`
-	}
-	var vcapServices map[string][]Service
-	err := json.Unmarshal([]byte(os.Getenv("VCAP_SERVICES")), &vcapServices)
-	if err != nil {
-		h.Log.Warning("Failed to parse VCAP_SERVICES")
-		return false, SnykCredentials{}
-	}
-
+func (h SnykHook) getCredentialsFromService(vcapServices VCAPServices) (bool, SnykCredentials) {
 	for key, services := range vcapServices {
 		if strings.Contains(key, "snyk") {
 			for _, service := range services {
-				apiToken := getCredentialString(service.Credentials, "apiToken")
+				apiToken := service.CredentialString("apiToken")
 				if apiToken != "" {
-					apiUrl := getCredentialString(service.Credentials, "apiUrl")
-					orgName := getCredentialString(service.Credentials, "orgName")
+					apiUrl := service.CredentialString("apiUrl")
+					orgName := service.CredentialString("orgName")
 					snykCredantials := SnykCredentials{
 						ApiToken: apiToken,
 						ApiUrl:   apiUrl,
@@ -255,10 +268,7 @@ func (h SnykHook) getCredentialsFromService() (bool, SnykCredentials) {
 }
 
 func (h SnykHook) appName() string {
-	var application struct {
-		Name string `json:"name"`
-	}
-	err := json.Unmarshal([]byte(os.Getenv("VCAP_APPLICATION")), &application)
+	application, _, err := vcap.LoadApplication()
 	if err != nil {
 		return ""
 	}
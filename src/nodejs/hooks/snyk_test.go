@@ -331,4 +331,38 @@ var _ = Describe("snykHook", func() {
 			})
 		})
 	})
+
+	Describe("Contributions", func() {
+		var oldSnykToken string
+
+		BeforeEach(func() {
+			oldSnykToken = os.Getenv("SNYK_TOKEN")
+			os.Setenv("SNYK_TOKEN", "MY_SECRET_TOKEN")
+		})
+
+		AfterEach(func() {
+			os.Setenv("SNYK_TOKEN", oldSnykToken)
+		})
+
+		It("describes the agent installed globally", func() {
+			Expect(os.MkdirAll(filepath.Join(depsDir, "node", "lib", "node_modules", "snyk"), 0755)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(depsDir, "node", "lib", "node_modules", "snyk", "package.json"), []byte(`{
+				"name": "snyk",
+				"version": "1.1064.0",
+				"_resolved": "https://registry.npmjs.org/snyk/-/snyk-1.1064.0.tgz",
+				"_integrity": "sha512-abc"
+			}`), 0644)).To(Succeed())
+
+			Expect(snyk.Contributions(stager)).To(Equal([]hooks.Component{{
+				Name:      "snyk",
+				Version:   "1.1064.0",
+				Source:    "https://registry.npmjs.org/snyk/-/snyk-1.1064.0.tgz",
+				Integrity: "sha512-abc",
+			}}))
+		})
+
+		It("describes nothing when the app brings its own agent", func() {
+			Expect(snyk.Contributions(stager)).To(BeEmpty())
+		})
+	})
 })
@@ -0,0 +1,10 @@
+#!/bin/sh
+cat > /dev/null
+echo "signing assets" >&2
+cat <<'JSON'
+{
+  "env": {"SIGNING_KEY_ID": "k-123"},
+  "launch_env": {"APM_ENDPOINT": "https://apm.internal/it's", "APM_ENABLED": "true"},
+  "warnings": ["asset signing is in preview"]
+}
+JSON
@@ -0,0 +1,4 @@
+#!/bin/sh
+cat > /dev/null
+echo "signing key missing" >&2
+exit 3
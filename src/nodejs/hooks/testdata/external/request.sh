@@ -0,0 +1,2 @@
+#!/bin/sh
+cat > hook-request.json
@@ -0,0 +1,193 @@
+package hooks
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/cloudfoundry/libbuildpack"
+)
+
+// VCAPService is one service binding from VCAP_SERVICES.
+type VCAPService struct {
+	Label       string
+	Name        string
+	Tags        []string
+	Credentials map[string]interface{}
+}
+
+// CredentialString returns the credential key when it is a string.
+func (s VCAPService) CredentialString(key string) string {
+	value, isString := s.Credentials[key].(string)
+	if isString {
+		return value
+	}
+	return ""
+}
+
+// VCAPServices are the service bindings of the app by label.
+type VCAPServices map[string][]VCAPService
+
+// All returns every service, ordered by label.
+func (v VCAPServices) All() []VCAPService {
+	var labels []string
+	for label := range v {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+
+	var services []VCAPService
+	for _, label := range labels {
+		services = append(services, v[label]...)
+	}
+	return services
+}
+
+// LoadVCAPServices parses VCAP_SERVICES from the environment and logs a
+// warning for every part of it which had to be skipped.
+func LoadVCAPServices(log *libbuildpack.Logger) VCAPServices {
+	services, problems := ParseVCAPServices(os.Getenv("VCAP_SERVICES"))
+	for _, problem := range problems {
+		log.Warning("%s", problem)
+	}
+	return services
+}
+
+// ParseVCAPServices parses VCAP_SERVICES as leniently as possible. Service
+// entries, names, tags and credentials of an unexpected type are skipped
+// instead of failing the whole document, and raw control characters inside
+// strings are escaped. The returned problems describe everything skipped.
+func ParseVCAPServices(data string) (VCAPServices, []string) {
+	services := VCAPServices{}
+	var problems []string
+	if strings.TrimSpace(data) == "" {
+		return services, nil
+	}
+
+	var labels map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(data), &labels); err != nil {
+		escaped, changed := escapeControlCharacters(data)
+		if !changed || json.Unmarshal([]byte(escaped), &labels) != nil {
+			return services, []string{fmt.Sprintf("Unable to parse VCAP_SERVICES%s: %s", nearestService(data, err), err)}
+		}
+		problems = append(problems, fmt.Sprintf("VCAP_SERVICES contains unescaped control characters%s", nearestService(data, err)))
+	}
+
+	for label, raw := range labels {
+		var entries []json.RawMessage
+		if err := json.Unmarshal(raw, &entries); err != nil {
+			problems = append(problems, fmt.Sprintf("Skipping VCAP_SERVICES label %q: expected a list of services", label))
+			continue
+		}
+
+		for idx, entry := range entries {
+			var fields map[string]interface{}
+			if err := json.Unmarshal(entry, &fields); err != nil || fields == nil {
+				problems = append(problems, fmt.Sprintf("Skipping service %d of VCAP_SERVICES label %q: expected an object", idx, label))
+				continue
+			}
+
+			service := VCAPService{Label: label}
+			if name, found := fields["name"]; found {
+				if s, isString := name.(string); isString {
+					service.Name = s
+				} else {
+					problems = append(problems, fmt.Sprintf("Ignoring the name of service %d of VCAP_SERVICES label %q: expected a string", idx, label))
+				}
+			}
+
+			if tags, isList := fields["tags"].([]interface{}); isList {
+				for _, tag := range tags {
+					if s, isString := tag.(string); isString {
+						service.Tags = append(service.Tags, s)
+					}
+				}
+			}
+
+			if credentials, found := fields["credentials"]; found && credentials != nil {
+				if m, isMap := credentials.(map[string]interface{}); isMap {
+					service.Credentials = m
+				} else {
+					problems = append(problems, fmt.Sprintf("Ignoring the credentials of service %s: expected an object", describeService(service, idx)))
+				}
+			}
+
+			services[label] = append(services[label], service)
+		}
+	}
+
+	sort.Strings(problems)
+	return services, problems
+}
+
+func describeService(service VCAPService, idx int) string {
+	if service.Name != "" {
+		return fmt.Sprintf("%q (label %q)", service.Name, service.Label)
+	}
+	return fmt.Sprintf("%d of label %q", idx, service.Label)
+}
+
+var (
+	vcapNamePattern  = regexp.MustCompile(`"name"\s*:\s*"([^"]*)"`)
+	vcapLabelPattern = regexp.MustCompile(`"([^"]+)"\s*:\s*\[`)
+)
+
+// nearestService names the service preceding the position of a syntax error.
+func nearestService(data string, err error) string {
+	syntaxErr, ok := err.(*json.SyntaxError)
+	if !ok {
+		return ""
+	}
+	offset := int(syntaxErr.Offset)
+	if offset > len(data) {
+		offset = len(data)
+	}
+	before := data[:offset]
+
+	var label, name string
+	if matches := vcapLabelPattern.FindAllStringSubmatchIndex(before, -1); len(matches) > 0 {
+		last := matches[len(matches)-1]
+		label = before[last[2]:last[3]]
+		before = before[last[1]:]
+	}
+	if matches := vcapNamePattern.FindAllStringSubmatch(before, -1); len(matches) > 0 {
+		name = matches[len(matches)-1][1]
+	}
+
+	switch {
+	case name != "":
+		return fmt.Sprintf(" near service %q (label %q)", name, label)
+	case label != "":
+		return fmt.Sprintf(" near label %q", label)
+	}
+	return ""
+}
+
+// escapeControlCharacters escapes raw control characters inside JSON strings,
+// which encoding/json rejects.
+func escapeControlCharacters(data string) (string, bool) {
+	var out []byte
+	inString, escaped, changed := false, false, false
+	for i := 0; i < len(data); i++ {
+		c := data[i]
+		switch {
+		case !inString:
+			inString = c == '"'
+		case escaped:
+			escaped = false
+		case c == '\\':
+			escaped = true
+		case c == '"':
+			inString = false
+		case c < 0x20:
+			out = append(out, []byte(fmt.Sprintf(`\u%04x`, c))...)
+			changed = true
+			continue
+		}
+		out = append(out, c)
+	}
+	return string(out), changed
+}
@@ -0,0 +1,138 @@
+package hooks_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"golang.google.cn/x/mock/gomock"
+
+	"nodejs/hooks"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var malformedVCAPServices = map[string]string{
+	"truncated":                 `{"user-provided": [{"name": "dynatrace", "credentials": {`,
+	"top level list":            `[{"name": "dynatrace"}]`,
+	"label is not a list":       `{"user-provided": {"name": "dynatrace"}}`,
+	"service is a string":       `{"user-provided": ["dynatrace"]}`,
+	"service is null":           `{"user-provided": [null]}`,
+	"name is a number":          `{"user-provided": [{"name": 42, "credentials": {"apitoken": "x"}}]}`,
+	"credentials are a list":    `{"dynatrace": [{"name": "dynatrace", "credentials": ["apitoken", "x"]}], "snyk": [{"name": "snyk", "credentials": ["apiToken"]}]}`,
+	"credentials are a number":  `{"snyk": [{"name": "snyk", "credentials": 12}]}`,
+	"credential values numbers": `{"dynatrace": [{"name": "dynatrace", "credentials": {"apitoken": 1, "environmentid": 2}}], "snyk": [{"name": "snyk", "credentials": {"apiToken": 3}}]}`,
+	"control character":         "{\"user-provided\": [{\"name\": \"broken\", \"credentials\": {\"password\": \"a\x01b\"}}]}",
+	"invalid escape":            `{"user-provided": [{"name": "mysql"}, {"name": "dynatrace", "credentials": {"apitoken": "\q"}}]}`,
+}
+
+var _ = Describe("VCAP_SERVICES", func() {
+	DescribeTable("ParseVCAPServices",
+		func(data string, services []hooks.VCAPService, problems []string) {
+			parsed, parseProblems := hooks.ParseVCAPServices(data)
+			Expect(parsed.All()).To(Equal(services))
+			Expect(parseProblems).To(HaveLen(len(problems)))
+			for i, problem := range problems {
+				Expect(parseProblems[i]).To(HavePrefix(problem))
+			}
+		},
+		Entry("empty", "", []hooks.VCAPService(nil), []string(nil)),
+		Entry("valid", `{"b": [{"name": "two", "tags": ["t", 1]}], "a": [{"name": "one", "credentials": {"k": "v"}}]}`,
+			[]hooks.VCAPService{
+				{Label: "a", Name: "one", Credentials: map[string]interface{}{"k": "v"}},
+				{Label: "b", Name: "two", Tags: []string{"t"}},
+			}, []string(nil)),
+		Entry("skips entries which are not objects", `{"a": ["x", {"name": "one"}, 3]}`,
+			[]hooks.VCAPService{{Label: "a", Name: "one"}},
+			[]string{`Skipping service 0 of VCAP_SERVICES label "a": expected an object`, `Skipping service 2 of VCAP_SERVICES label "a": expected an object`}),
+		Entry("skips labels which are not lists", `{"a": {"name": "one"}, "b": [{"name": "two"}]}`,
+			[]hooks.VCAPService{{Label: "b", Name: "two"}},
+			[]string{`Skipping VCAP_SERVICES label "a": expected a list of services`}),
+		Entry("ignores credentials which are not objects", `{"a": [{"name": "one", "credentials": ["secret"]}]}`,
+			[]hooks.VCAPService{{Label: "a", Name: "one"}},
+			[]string{`Ignoring the credentials of service "one" (label "a"): expected an object`}),
+		Entry("ignores names which are not strings", `{"a": [{"name": 7, "credentials": "x"}]}`,
+			[]hooks.VCAPService{{Label: "a"}},
+			[]string{`Ignoring the credentials of service 0 of label "a": expected an object`, `Ignoring the name of service 0 of VCAP_SERVICES label "a": expected a string`}),
+		Entry("escapes control characters", "{\"user-provided\": [{\"name\": \"db\"}, {\"name\": \"broken\", \"credentials\": {\"password\": \"a\tb\"}}]}",
+			[]hooks.VCAPService{
+				{Label: "user-provided", Name: "db"},
+				{Label: "user-provided", Name: "broken", Credentials: map[string]interface{}{"password": "a\tb"}},
+			},
+			[]string{`VCAP_SERVICES contains unescaped control characters near service "broken" (label "user-provided")`}),
+		Entry("names the service nearest to a syntax error", `{"mysql": [{"name": "db"}], "user-provided": [{"name": "api", "credentials": {"token": "\q"}}]}`,
+			[]hooks.VCAPService(nil),
+			[]string{`Unable to parse VCAP_SERVICES near service "api" (label "user-provided"): `}),
+		Entry("names the label when no service name precedes the error", `{"mysql": [{"name": "db"}], "user-provided": [{"credentials": {"token": "\q"}}]}`,
+			[]hooks.VCAPService(nil),
+			[]string{`Unable to parse VCAP_SERVICES near label "user-provided": `}),
+	)
+
+	Describe("hooks with malformed VCAP_SERVICES", func() {
+		var (
+			buffer          *bytes.Buffer
+			logger          *libbuildpack.Logger
+			stager          *libbuildpack.Stager
+			mockCtrl        *gomock.Controller
+			buildDir        string
+			depsDir         string
+			oldVcapServices string
+			oldSnykToken    string
+		)
+
+		BeforeEach(func() {
+			var err error
+			buildDir, err = ioutil.TempDir("", "nodejs-buildpack.build.")
+			Expect(err).To(BeNil())
+			depsDir, err = ioutil.TempDir("", "nodejs-buildpack.deps.")
+			Expect(err).To(BeNil())
+
+			oldVcapServices = os.Getenv("VCAP_SERVICES")
+			oldSnykToken = os.Getenv("SNYK_TOKEN")
+			os.Unsetenv("SNYK_TOKEN")
+
+			buffer = new(bytes.Buffer)
+			logger = libbuildpack.NewLogger(buffer)
+			stager = libbuildpack.NewStager([]string{buildDir, "", depsDir, "0"}, logger, &libbuildpack.Manifest{})
+			mockCtrl = gomock.NewController(GinkgoT())
+		})
+
+		AfterEach(func() {
+			mockCtrl.Finish()
+			os.Setenv("VCAP_SERVICES", oldVcapServices)
+			os.Setenv("SNYK_TOKEN", oldSnykToken)
+			Expect(os.RemoveAll(buildDir)).To(Succeed())
+			Expect(os.RemoveAll(depsDir)).To(Succeed())
+		})
+
+		It("reports hooks with bound services as active", func() {
+			os.Setenv("VCAP_SERVICES", `{"user-provided": [{"name": "dynatrace", "credentials": {"apitoken": "x", "environmentid": "y"}}], "snyk": [{"name": "snyk", "credentials": {"apiToken": "z"}}]}`)
+			Expect(hooks.DynatraceHook{Log: logger}.Active()).To(BeTrue())
+			Expect(hooks.SnykHook{Log: logger}.Active()).To(BeTrue())
+			Expect(os.Getenv("SNYK_TOKEN")).To(Equal(""))
+		})
+
+		for description, data := range malformedVCAPServices {
+			description, data := description, data
+
+			It("dynatrace does nothing when "+description, func() {
+				os.Setenv("VCAP_SERVICES", data)
+				dynatrace := hooks.DynatraceHook{Log: logger, Command: NewMockCommand(mockCtrl)}
+				Expect(dynatrace.Active()).To(BeFalse())
+				Expect(dynatrace.AfterCompile(stager)).To(Succeed())
+				Expect(buffer.String()).NotTo(ContainSubstring("Dynatrace service credentials found"))
+			})
+
+			It("snyk does nothing when "+description, func() {
+				os.Setenv("VCAP_SERVICES", data)
+				snyk := hooks.SnykHook{Log: logger, SnykCommand: NewMockSnykCommand(mockCtrl)}
+				Expect(snyk.Active()).To(BeFalse())
+				Expect(snyk.AfterCompile(stager)).To(Succeed())
+				Expect(os.Getenv("SNYK_TOKEN")).To(Equal(""))
+			})
+		}
+	})
+})
@@ -0,0 +1,120 @@
+package installed
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"nodejs/packagejson"
+	"os"
+	"path/filepath"
+	"sort"
+	"text/tabwriter"
+)
+
+// Layouts of the installed dependencies.
+const (
+	NodeModules = "node_modules"
+	PNPM        = "pnpm"
+	PnP         = "pnp"
+)
+
+// Dependency is a top level dependency of the app. Version is empty when it
+// is not installed.
+type Dependency struct {
+	Name      string
+	Version   string
+	Specifier string
+	Type      string
+}
+
+// List returns the dependencies declared in the package.json of appDir with
+// the versions installed for them, like `npm ls --depth=0`, along with the
+// layout they were read from. Dependencies and optionalDependencies are
+// always listed, devDependencies only when installed.
+func List(appDir string) ([]Dependency, string, error) {
+	pkg, found, err := packagejson.Load(appDir)
+	if err != nil || !found {
+		return nil, "", err
+	}
+
+	layout, versions, err := installedVersions(appDir, pkg.Name)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var deps []Dependency
+	for _, group := range []struct {
+		kind  string
+		specs map[string]string
+	}{
+		{"prod", pkg.Dependencies},
+		{"optional", pkg.OptionalDependencies},
+		{"dev", pkg.DevDependencies},
+	} {
+		for name, specifier := range group.specs {
+			version := versions(name)
+			if version == "" && group.kind == "dev" {
+				continue
+			}
+			deps = append(deps, Dependency{Name: name, Version: version, Specifier: specifier, Type: group.kind})
+		}
+	}
+	sort.Slice(deps, func(i, j int) bool { return deps[i].Name < deps[j].Name })
+	return deps, layout, nil
+}
+
+// installedVersions returns the layout of appDir and a lookup of the
+// installed version of a top level dependency. name is the name of the app.
+func installedVersions(appDir, name string) (string, func(string) string, error) {
+	if path, found, err := pnpDataFile(appDir); err != nil {
+		return "", nil, err
+	} else if found {
+		versions, err := pnpVersions(path, name)
+		if err != nil {
+			return "", nil, fmt.Errorf("unable to read %s: %s", filepath.Base(path), err)
+		}
+		return PnP, func(name string) string { return versions[name] }, nil
+	}
+
+	layout := NodeModules
+	if _, err := os.Stat(filepath.Join(appDir, "node_modules", ".pnpm")); err == nil {
+		layout = PNPM
+	}
+	// pnpm links each top level dependency into its store, which reading
+	// through the link resolves.
+	return layout, func(name string) string {
+		var dep struct {
+			Version string `json:"version"`
+		}
+		if readJSON(filepath.Join(appDir, "node_modules", filepath.FromSlash(name), "package.json"), &dep) != nil {
+			return ""
+		}
+		return dep.Version
+	}, nil
+}
+
+func readJSON(path string, v interface{}) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// Write writes deps as a table with a header naming the layout.
+func Write(w io.Writer, deps []Dependency, layout string) error {
+	if _, err := fmt.Fprintf(w, "# %d top level dependencies installed with the %s layout\n", len(deps), layout); err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tVERSION\tSPECIFIER\tTYPE")
+	for _, dep := range deps {
+		version := dep.Version
+		if version == "" {
+			version = "not installed"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", dep.Name, version, dep.Specifier, dep.Type)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,13 @@
+package installed_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestInstalled(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Installed Suite")
+}
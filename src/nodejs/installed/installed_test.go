@@ -0,0 +1,101 @@
+package installed_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"nodejs/installed"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Installed", func() {
+	yarnPnPDeps := []installed.Dependency{
+		{Name: "fsevents", Version: "", Specifier: "^2.3.2", Type: "optional"},
+		{Name: "lodash", Version: "4.17.21", Specifier: "^4.17.21", Type: "prod"},
+		{Name: "my-lodash", Version: "4.17.20", Specifier: "npm:lodash@4.17.20", Type: "prod"},
+		{Name: "react-dom", Version: "18.2.0", Specifier: "^18.2.0", Type: "prod"},
+		{Name: "typescript", Version: "5.3.3", Specifier: "^5.3.0", Type: "dev"},
+		{Name: "utils", Version: "workspace:packages/utils", Specifier: "workspace:^", Type: "prod"},
+	}
+
+	DescribeTable("List",
+		func(fixture, layout string, expected []installed.Dependency) {
+			deps, found, err := installed.List(filepath.Join("testdata", fixture))
+			Expect(err).To(BeNil())
+			Expect(found).To(Equal(layout))
+			Expect(deps).To(Equal(expected))
+		},
+		Entry("npm", "npm", installed.NodeModules, []installed.Dependency{
+			{Name: "@types/node", Version: "20.11.5", Specifier: "^20.0.0", Type: "dev"},
+			{Name: "express", Version: "4.18.2", Specifier: "^4.18.0", Type: "prod"},
+			{Name: "fsevents", Version: "", Specifier: "^2.3.2", Type: "optional"},
+			{Name: "left-pad", Version: "1.3.0", Specifier: "^1.3.0", Type: "prod"},
+		}),
+		Entry("pnpm", "pnpm", installed.PNPM, []installed.Dependency{
+			{Name: "@sindresorhus/is", Version: "6.1.0", Specifier: "^6.0.0", Type: "prod"},
+			{Name: "lodash", Version: "4.17.21", Specifier: "~4.17.0", Type: "prod"},
+		}),
+		Entry("yarn 3+ Plug'n'Play", "yarn_pnp", installed.PnP, yarnPnPDeps),
+		Entry("yarn Plug'n'Play without inlining", "yarn_pnp_data", installed.PnP, yarnPnPDeps),
+		Entry("yarn 2 Plug'n'Play", "yarn2_pnp", installed.PnP, []installed.Dependency{
+			{Name: "lodash", Version: "4.17.21", Specifier: "^4.17.0", Type: "prod"},
+		}),
+	)
+
+	DescribeTable("PnPVersion",
+		func(reference, expected string) {
+			Expect(installed.PnPVersion(reference)).To(Equal(expected))
+		},
+		Entry("npm", "npm:4.17.21", "4.17.21"),
+		Entry("virtual", "virtual:7d8f9a9c4b1e2d3f#npm:18.2.0", "18.2.0"),
+		Entry("builtin patch", "patch:typescript@npm%3A5.3.3#optional!builtin<compat/typescript>::version=5.3.3&hash=e012d7", "5.3.3"),
+		Entry("workspace", "workspace:packages/utils", "workspace:packages/utils"),
+		Entry("git", "https://github.com/lodash/lodash.git#commit=2da024c", "https://github.com/lodash/lodash.git#commit=2da024c"),
+	)
+
+	Context("without a package.json", func() {
+		It("lists nothing", func() {
+			dir, err := ioutil.TempDir("", "nodejs-buildpack.build.")
+			Expect(err).To(BeNil())
+			defer os.RemoveAll(dir)
+
+			deps, layout, err := installed.List(dir)
+			Expect(err).To(BeNil())
+			Expect(layout).To(Equal(""))
+			Expect(deps).To(BeEmpty())
+		})
+	})
+
+	Context("with an unreadable Plug'n'Play file", func() {
+		It("returns an error", func() {
+			dir, err := ioutil.TempDir("", "nodejs-buildpack.build.")
+			Expect(err).To(BeNil())
+			defer os.RemoveAll(dir)
+			Expect(ioutil.WriteFile(filepath.Join(dir, "package.json"), []byte(`{"dependencies":{"lodash":"^4.17.0"}}`), 0644)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(dir, ".pnp.cjs"), []byte("module.exports = {};\n"), 0644)).To(Succeed())
+
+			_, _, err = installed.List(dir)
+			Expect(err).To(MatchError("unable to read .pnp.cjs: no Plug'n'Play runtime state found"))
+		})
+	})
+
+	Describe("Write", func() {
+		It("writes a table", func() {
+			buffer := new(bytes.Buffer)
+			Expect(installed.Write(buffer, []installed.Dependency{
+				{Name: "express", Version: "4.18.2", Specifier: "^4.18.0", Type: "prod"},
+				{Name: "fsevents", Specifier: "^2.3.2", Type: "optional"},
+			}, installed.NodeModules)).To(Succeed())
+
+			Expect(buffer.String()).To(Equal(`# 2 top level dependencies installed with the node_modules layout
+NAME      VERSION        SPECIFIER  TYPE
+express   4.18.2         ^4.18.0    prod
+fsevents  not installed  ^2.3.2     optional
+`))
+		})
+	})
+})
@@ -0,0 +1,227 @@
+package installed
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// pnpFiles are where yarn 2 and later keep the Plug'n'Play data: in
+// .pnp.data.json with pnpEnableInlining: false, otherwise inlined in the
+// loader, .pnp.cjs since yarn 3 and .pnp.js before.
+var pnpFiles = []string{".pnp.data.json", ".pnp.cjs", ".pnp.js"}
+
+func pnpDataFile(appDir string) (string, bool, error) {
+	for _, name := range pnpFiles {
+		path := filepath.Join(appDir, name)
+		if _, err := os.Stat(path); err == nil {
+			return path, true, nil
+		} else if !os.IsNotExist(err) {
+			return "", false, err
+		}
+	}
+	return "", false, nil
+}
+
+// pnpData is the part of the Plug'n'Play runtime state needed to find the
+// dependencies of the root workspace.
+type pnpData struct {
+	DependencyTreeRoots []struct {
+		Name      string `json:"name"`
+		Reference string `json:"reference"`
+	} `json:"dependencyTreeRoots"`
+	// PackageRegistryData is a list of [name, [[reference, info], ...]]
+	// pairs, with a null name and reference for the top level.
+	PackageRegistryData [][]json.RawMessage `json:"packageRegistryData"`
+}
+
+type pnpPackage struct {
+	// PackageDependencies is a list of [name, reference] pairs, where the
+	// reference of an aliased dependency is a [name, reference] pair itself.
+	PackageDependencies [][]json.RawMessage `json:"packageDependencies"`
+}
+
+// pnpVersions returns the versions of the dependencies of the root workspace
+// from a Plug'n'Play data file.
+func pnpVersions(path, rootName string) (map[string]string, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	data, err := parsePnPData(contents)
+	if err != nil {
+		return nil, err
+	}
+
+	var root *pnpPackage
+	if len(data.DependencyTreeRoots) > 0 {
+		root, err = data.lookup(data.DependencyTreeRoots[0].Name, data.DependencyTreeRoots[0].Reference)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if root == nil {
+		if root, err = data.lookup("", ""); err != nil {
+			return nil, err
+		}
+	}
+	if root == nil {
+		return nil, errors.New("no root workspace")
+	}
+
+	versions := map[string]string{}
+	for _, pair := range root.PackageDependencies {
+		if len(pair) != 2 {
+			continue
+		}
+		var name string
+		if err := json.Unmarshal(pair[0], &name); err != nil || name == rootName {
+			continue
+		}
+
+		var reference string
+		if err := json.Unmarshal(pair[1], &reference); err != nil {
+			var alias []string
+			if err := json.Unmarshal(pair[1], &alias); err != nil || len(alias) != 2 {
+				continue
+			}
+			reference = alias[1]
+		}
+		versions[name] = PnPVersion(reference)
+	}
+	return versions, nil
+}
+
+// lookup finds a package in the registry, with "" standing for the null name
+// and reference of the top level.
+func (d pnpData) lookup(name, reference string) (*pnpPackage, error) {
+	for _, entry := range d.PackageRegistryData {
+		if len(entry) != 2 {
+			continue
+		}
+		var entryName *string
+		if err := json.Unmarshal(entry[0], &entryName); err != nil {
+			return nil, err
+		}
+		if (entryName == nil && name != "") || (entryName != nil && *entryName != name) {
+			continue
+		}
+
+		var references [][]json.RawMessage
+		if err := json.Unmarshal(entry[1], &references); err != nil {
+			return nil, err
+		}
+		for _, ref := range references {
+			if len(ref) != 2 {
+				continue
+			}
+			var entryReference *string
+			if err := json.Unmarshal(ref[0], &entryReference); err != nil {
+				return nil, err
+			}
+			if (entryReference == nil && reference == "") || (entryReference != nil && *entryReference == reference) {
+				var pkg pnpPackage
+				if err := json.Unmarshal(ref[1], &pkg); err != nil {
+					return nil, err
+				}
+				return &pkg, nil
+			}
+		}
+	}
+	return nil, nil
+}
+
+// parsePnPData reads the runtime state from .pnp.data.json, or from the
+// loader which inlines it: as the RAW_RUNTIME_STATE string literal since
+// yarn 3, and as an object literal passed to hydrateRuntimeState in yarn 2.
+func parsePnPData(contents []byte) (pnpData, error) {
+	var data pnpData
+
+	trimmed := bytes.TrimSpace(contents)
+	if bytes.HasPrefix(trimmed, []byte("{")) {
+		err := json.Unmarshal(trimmed, &data)
+		return data, err
+	}
+
+	if idx := bytes.Index(contents, []byte("RAW_RUNTIME_STATE =")); idx >= 0 {
+		literal, err := jsStringLiteral(contents[idx+len("RAW_RUNTIME_STATE ="):])
+		if err != nil {
+			return data, err
+		}
+		err = json.Unmarshal([]byte(literal), &data)
+		return data, err
+	}
+
+	if idx := bytes.Index(contents, []byte("return hydrateRuntimeState(")); idx >= 0 {
+		// The decoder stops after the object, before the options argument.
+		err := json.NewDecoder(bytes.NewReader(contents[idx+len("return hydrateRuntimeState("):])).Decode(&data)
+		return data, err
+	}
+
+	return data, errors.New("no Plug'n'Play runtime state found")
+}
+
+// jsStringLiteral decodes the quoted JavaScript string at the start of src,
+// including line continuations.
+func jsStringLiteral(src []byte) (string, error) {
+	src = bytes.TrimLeft(src, " \t\r\n")
+	if len(src) == 0 || (src[0] != '\'' && src[0] != '"') {
+		return "", errors.New("expected a string literal")
+	}
+	quote := src[0]
+
+	var out bytes.Buffer
+	for i := 1; i < len(src); i++ {
+		c := src[i]
+		switch {
+		case c == quote:
+			return out.String(), nil
+		case c == '\\' && i+1 < len(src):
+			i++
+			switch src[i] {
+			case '\n':
+			case '\r':
+				if i+1 < len(src) && src[i+1] == '\n' {
+					i++
+				}
+			case 'n':
+				out.WriteByte('\n')
+			case 't':
+				out.WriteByte('\t')
+			case 'r':
+				out.WriteByte('\r')
+			default:
+				out.WriteByte(src[i])
+			}
+		default:
+			out.WriteByte(c)
+		}
+	}
+	return "", errors.New("unterminated string literal")
+}
+
+// PnPVersion returns the version in a yarn package reference, such as
+// npm:4.17.21, virtual:<hash>#npm:4.17.21 or a builtin patch which records
+// ::version=, and the reference itself for workspaces, links and other
+// protocols.
+func PnPVersion(reference string) string {
+	if strings.HasPrefix(reference, "virtual:") {
+		if idx := strings.Index(reference, "#"); idx >= 0 {
+			reference = reference[idx+1:]
+		}
+	}
+	if strings.HasPrefix(reference, "npm:") {
+		return strings.TrimPrefix(reference, "npm:")
+	}
+	if idx := strings.Index(reference, "::"); idx >= 0 {
+		if params, err := url.ParseQuery(reference[idx+2:]); err == nil && params.Get("version") != "" {
+			return params.Get("version")
+		}
+	}
+	return reference
+}
@@ -0,0 +1,144 @@
+#!/usr/bin/env node
+/* eslint-disable */
+
+try {
+  Object.freeze({}).detectStrictMode = true;
+} catch (error) {
+  throw new Error(`The whole PnP file got strict-mode-ified, which is known to break (Emscripten libraries aren't strict mode). This usually happens when the file goes through Babel.`);
+}
+
+var __non_webpack_module__ = module;
+
+function $$SETUP_STATE(hydrateRuntimeState, basePath) {
+  return hydrateRuntimeState({
+  "__info": [
+    "This file is automatically generated. Do not touch it, or risk",
+    "your modifications being lost."
+  ],
+  "dependencyTreeRoots": [
+    {
+      "name": "shop",
+      "reference": "workspace:."
+    }
+  ],
+  "enableTopLevelFallback": true,
+  "ignorePatternData": "(^(?:\\.yarn\\/sdks(?:\\/(?!\\.{1,2}(?:\\/|$))(?:(?:(?!(?:^|\\/)\\.{1,2}(?:\\/|$)).)*?)|$))$)",
+  "fallbackExclusionList": [
+    [
+      "shop",
+      [
+        "workspace:."
+      ]
+    ],
+    [
+      "utils",
+      [
+        "workspace:packages/utils"
+      ]
+    ]
+  ],
+  "fallbackPool": [],
+  "packageRegistryData": [
+    [
+      null,
+      [
+        [
+          null,
+          {
+            "packageLocation": "./",
+            "packageDependencies": [
+              [
+                "fsevents",
+                null
+              ],
+              [
+                "lodash",
+                "npm:4.17.21"
+              ],
+              [
+                "my-lodash",
+                [
+                  "lodash",
+                  "npm:4.17.20"
+                ]
+              ],
+              [
+                "react-dom",
+                "virtual:7d8f9a9c4b1e2d3f#npm:18.2.0"
+              ],
+              [
+                "shop",
+                "workspace:."
+              ],
+              [
+                "typescript",
+                "patch:typescript@npm%3A5.3.3#optional!builtin<compat/typescript>::version=5.3.3&hash=e012d7"
+              ],
+              [
+                "utils",
+                "workspace:packages/utils"
+              ]
+            ],
+            "linkType": "SOFT"
+          }
+        ]
+      ]
+    ],
+    [
+      "lodash",
+      [
+        [
+          "npm:4.17.20",
+          {
+            "packageLocation": "./.yarn/cache/lodash-npm-4.17.20-808a8cfaa0-b31afa09.zip/node_modules/lodash/",
+            "packageDependencies": [
+              [
+                "lodash",
+                "npm:4.17.20"
+              ]
+            ],
+            "linkType": "HARD"
+          }
+        ],
+        [
+          "npm:4.17.21",
+          {
+            "packageLocation": "./.yarn/cache/lodash-npm-4.17.21-6382451519-eb835a2e51.zip/node_modules/lodash/",
+            "packageDependencies": [
+              [
+                "lodash",
+                "npm:4.17.21"
+              ]
+            ],
+            "linkType": "HARD"
+          }
+        ]
+      ]
+    ],
+    [
+      "shop",
+      [
+        [
+          "workspace:.",
+          {
+            "packageLocation": "./",
+            "packageDependencies": [
+              [
+                "shop",
+                "workspace:."
+              ],
+              [
+                "lodash",
+                "npm:4.17.21"
+              ]
+            ],
+            "linkType": "SOFT"
+          }
+        ]
+      ]
+    ]
+  ]
+}, {
+    basePath: basePath || __dirname,
+  });
+}
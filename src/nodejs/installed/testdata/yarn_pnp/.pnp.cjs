@@ -0,0 +1,190 @@
+#!/usr/bin/env node
+/* eslint-disable */
+"use strict";
+
+const RAW_RUNTIME_STATE =
+'{\
+  "__info": [\
+    "This file is automatically generated. Do not touch it, or risk",\
+    "your modifications being lost."\
+  ],\
+  "dependencyTreeRoots": [\
+    {\
+      "name": "shop",\
+      "reference": "workspace:."\
+    },\
+    {\
+      "name": "utils",\
+      "reference": "workspace:packages/utils"\
+    }\
+  ],\
+  "enableTopLevelFallback": true,\
+  "ignorePatternData": "(^(?:\\\\.yarn\\\\/sdks(?:\\\\/(?!\\\\.{1,2}(?:\\\\/|$))(?:(?:(?!(?:^|\\\\/)\\\\.{1,2}(?:\\\\/|$)).)*?)|$))$)",\
+  "fallbackExclusionList": [\
+    [\
+      "shop",\
+      [\
+        "workspace:."\
+      ]\
+    ],\
+    [\
+      "utils",\
+      [\
+        "workspace:packages/utils"\
+      ]\
+    ]\
+  ],\
+  "fallbackPool": [],\
+  "packageRegistryData": [\
+    [\
+      null,\
+      [\
+        [\
+          null,\
+          {\
+            "packageLocation": "./",\
+            "packageDependencies": [\
+              [\
+                "fsevents",\
+                null\
+              ],\
+              [\
+                "lodash",\
+                "npm:4.17.21"\
+              ],\
+              [\
+                "my-lodash",\
+                [\
+                  "lodash",\
+                  "npm:4.17.20"\
+                ]\
+              ],\
+              [\
+                "react-dom",\
+                "virtual:7d8f9a9c4b1e2d3f#npm:18.2.0"\
+              ],\
+              [\
+                "shop",\
+                "workspace:."\
+              ],\
+              [\
+                "typescript",\
+                "patch:typescript@npm%3A5.3.3#optional!builtin<compat/typescript>::version=5.3.3&hash=e012d7"\
+              ],\
+              [\
+                "utils",\
+                "workspace:packages/utils"\
+              ]\
+            ],\
+            "linkType": "SOFT"\
+          }\
+        ]\
+      ]\
+    ],\
+    [\
+      "lodash",\
+      [\
+        [\
+          "npm:4.17.20",\
+          {\
+            "packageLocation": "./.yarn/cache/lodash-npm-4.17.20-808a8cfaa0-b31afa09.zip/node_modules/lodash/",\
+            "packageDependencies": [\
+              [\
+                "lodash",\
+                "npm:4.17.20"\
+              ]\
+            ],\
+            "linkType": "HARD"\
+          }\
+        ],\
+        [\
+          "npm:4.17.21",\
+          {\
+            "packageLocation": "./.yarn/cache/lodash-npm-4.17.21-6382451519-eb835a2e51.zip/node_modules/lodash/",\
+            "packageDependencies": [\
+              [\
+                "lodash",\
+                "npm:4.17.21"\
+              ]\
+            ],\
+            "linkType": "HARD"\
+          }\
+        ]\
+      ]\
+    ],\
+    [\
+      "shop",\
+      [\
+        [\
+          "workspace:.",\
+          {\
+            "packageLocation": "./",\
+            "packageDependencies": [\
+              [\
+                "shop",\
+                "workspace:."\
+              ],\
+              [\
+                "fsevents",\
+                null\
+              ],\
+              [\
+                "lodash",\
+                "npm:4.17.21"\
+              ],\
+              [\
+                "my-lodash",\
+                [\
+                  "lodash",\
+                  "npm:4.17.20"\
+                ]\
+              ],\
+              [\
+                "react-dom",\
+                "virtual:7d8f9a9c4b1e2d3f#npm:18.2.0"\
+              ],\
+              [\
+                "typescript",\
+                "patch:typescript@npm%3A5.3.3#optional!builtin<compat/typescript>::version=5.3.3&hash=e012d7"\
+              ],\
+              [\
+                "utils",\
+                "workspace:packages/utils"\
+              ]\
+            ],\
+            "linkType": "SOFT"\
+          }\
+        ]\
+      ]\
+    ],\
+    [\
+      "utils",\
+      [\
+        [\
+          "workspace:packages/utils",\
+          {\
+            "packageLocation": "./packages/utils/",\
+            "packageDependencies": [\
+              [\
+                "utils",\
+                "workspace:packages/utils"\
+              ],\
+              [\
+                "lodash",\
+                "npm:4.17.21"\
+              ]\
+            ],\
+            "linkType": "SOFT"\
+          }\
+        ]\
+      ]\
+    ]\
+  ]\
+}';
+
+function $$SETUP_STATE(hydrateRuntimeState, basePath) {
+  return hydrateRuntimeState(JSON.parse(RAW_RUNTIME_STATE), {basePath: basePath || __dirname});
+}
+
+const fs = require('fs');
+const path = require('path');
@@ -0,0 +1,53 @@
+package integration_test
+
+import (
+	"os/exec"
+	"path/filepath"
+
+	"github.com/cloudfoundry/libbuildpack/cutlass"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("CF NodeJS Buildpack", func() {
+	var app *cutlass.App
+	AfterEach(func() {
+		if app != nil {
+			app.Destroy()
+		}
+		app = nil
+	})
+
+	Context("deploying a single file Node.js app without package.json", func() {
+		BeforeEach(func() {
+			app = cutlass.New(filepath.Join(bpDir, "fixtures", "no_package_json"))
+		})
+
+		It("installs node and starts the entrypoint without installing dependencies", func() {
+			PushAppAndConfirm(app)
+
+			Expect(app.Stdout.String()).To(MatchRegexp("Installing node \\d+\\.\\d+\\.\\d+"))
+			Expect(app.Stdout.String()).To(ContainSubstring("No package.json found, so no dependencies are installed"))
+			Expect(app.Stdout.String()).To(ContainSubstring("No package.json found, starting the app with `node index.js`"))
+			Expect(app.Stdout.String()).NotTo(ContainSubstring("Building dependencies"))
+			Expect(app.GetBody("/")).To(ContainSubstring("Hello from a single file, NODE_ENV=production"))
+		})
+
+		It("is detected", func() {
+			output, err := exec.Command(filepath.Join(bpDir, "bin", "detect"), filepath.Join(bpDir, "fixtures", "no_package_json")).CombinedOutput()
+			Expect(err).To(BeNil(), string(output))
+			Expect(string(output)).To(HavePrefix("node.js "))
+		})
+	})
+
+	Context("an app without package.json node can't start", func() {
+		It("is not detected", func() {
+			// cutlass pushes with the buildpack named, which skips detection,
+			// so bin/detect runs on its own.
+			output, err := exec.Command(filepath.Join(bpDir, "bin", "detect"), filepath.Join(bpDir, "fixtures", "nothing_to_run")).CombinedOutput()
+			Expect(err).To(HaveOccurred())
+			Expect(string(output)).To(Equal(""))
+		})
+	})
+})
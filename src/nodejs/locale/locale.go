@@ -0,0 +1,72 @@
+// Package locale picks the UTF-8 locale the build scripts and the app run
+// with among those installed on the stack, whose default POSIX locale
+// mangles file names with non-ASCII characters.
+package locale
+
+import (
+	"bytes"
+	"io"
+	"strings"
+)
+
+const (
+	// Default is the locale unless BP_LOCALE says otherwise.
+	Default = "en_US.UTF-8"
+	// Fallback is the locale when the requested one is not installed, which
+	// every stack has.
+	Fallback = "C.UTF-8"
+)
+
+// Lister lists the locales installed on the stack.
+type Lister interface {
+	Locales() ([]string, error)
+}
+
+type Command interface {
+	Execute(string, io.Writer, io.Writer, string, ...string) error
+}
+
+// CommandLister lists the locales with locale -a.
+type CommandLister struct {
+	Command Command
+}
+
+func (l CommandLister) Locales() ([]string, error) {
+	output := new(bytes.Buffer)
+	if err := l.Command.Execute("", output, new(bytes.Buffer), "locale", "-a"); err != nil {
+		return nil, err
+	}
+	return strings.Fields(output.String()), nil
+}
+
+// Select returns requested when the stack has it, or else Fallback, or ""
+// when the stack has neither.
+func Select(lister Lister, requested string) (string, error) {
+	locales, err := lister.Locales()
+	if err != nil {
+		return "", err
+	}
+	for _, candidate := range []string{requested, Fallback} {
+		for _, locale := range locales {
+			if normalize(locale) == normalize(candidate) {
+				return candidate, nil
+			}
+		}
+	}
+	return "", nil
+}
+
+// normalize spells a locale like glibc does for the names of its locales,
+// which locale -a lists: en_US.UTF-8 is installed as en_US.utf8.
+func normalize(name string) string {
+	parts := strings.SplitN(name, ".", 2)
+	if len(parts) == 1 {
+		return name
+	}
+	codeset, modifier := parts[1], ""
+	if i := strings.Index(codeset, "@"); i >= 0 {
+		codeset, modifier = codeset[:i], codeset[i:]
+	}
+	codeset = strings.ToLower(strings.Replace(codeset, "-", "", -1))
+	return parts[0] + "." + codeset + modifier
+}
@@ -0,0 +1,13 @@
+package locale_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestLocale(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Locale Suite")
+}
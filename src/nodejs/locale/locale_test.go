@@ -0,0 +1,69 @@
+package locale_test
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"nodejs/locale"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+type fakeLister struct {
+	locales []string
+	err     error
+}
+
+func (l fakeLister) Locales() ([]string, error) {
+	return l.locales, l.err
+}
+
+type fakeCommand struct {
+	output string
+	err    error
+	args   []string
+}
+
+func (c *fakeCommand) Execute(dir string, stdout, stderr io.Writer, program string, args ...string) error {
+	c.args = append([]string{program}, args...)
+	fmt.Fprint(stdout, c.output)
+	return c.err
+}
+
+var _ = Describe("Locale", func() {
+	cflinuxfs3 := []string{"C", "C.UTF-8", "en_US.utf8", "POSIX"}
+
+	DescribeTable("Select",
+		func(available []string, requested, expected string) {
+			Expect(locale.Select(fakeLister{locales: available}, requested)).To(Equal(expected))
+		},
+		Entry("the default, spelled like glibc", cflinuxfs3, "en_US.UTF-8", "en_US.UTF-8"),
+		Entry("the default, spelled like the stack", cflinuxfs3, "en_US.utf8", "en_US.utf8"),
+		Entry("a missing locale falls back", cflinuxfs3, "de_DE.UTF-8", "C.UTF-8"),
+		Entry("a fallback spelled like glibc", []string{"C", "C.utf8", "POSIX"}, "de_DE.UTF-8", "C.UTF-8"),
+		Entry("a locale with a modifier", []string{"C.UTF-8", "sr_RS.utf8@latin"}, "sr_RS.UTF-8@latin", "sr_RS.UTF-8@latin"),
+		Entry("a modifier the stack does not have", []string{"C.UTF-8", "sr_RS.utf8"}, "sr_RS.UTF-8@latin", "C.UTF-8"),
+		Entry("no UTF-8 locale at all", []string{"C", "POSIX"}, "en_US.UTF-8", ""),
+	)
+
+	It("fails when the locales can't be listed", func() {
+		_, err := locale.Select(fakeLister{err: errors.New("exit status 127")}, locale.Default)
+		Expect(err).To(MatchError("exit status 127"))
+	})
+
+	Describe("CommandLister", func() {
+		It("lists the locales with locale -a", func() {
+			command := &fakeCommand{output: "C\nC.UTF-8\nen_US.utf8\nPOSIX\n"}
+			Expect(locale.CommandLister{Command: command}.Locales()).To(Equal(cflinuxfs3))
+			Expect(command.args).To(Equal([]string{"locale", "-a"}))
+		})
+
+		It("fails with the command", func() {
+			command := &fakeCommand{err: errors.New("exit status 127")}
+			_, err := locale.CommandLister{Command: command}.Locales()
+			Expect(err).To(MatchError("exit status 127"))
+		})
+	})
+})
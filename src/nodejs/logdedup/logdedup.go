@@ -0,0 +1,134 @@
+// Package logdedup keeps warnings which a build emits over and over, like a
+// deprecation warning of each package of a workspace, from drowning the
+// staging log.
+package logdedup
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// MaxTracked is how many distinct warnings a Writer remembers. Warnings
+// beyond it are printed every time, so that a build with endless distinct
+// warnings doesn't take endless memory.
+const MaxTracked = 1000
+
+// continuation indents the lines of a multiline message of the logger.
+const continuation = "       "
+
+var (
+	ansiEscape      = regexp.MustCompile(`\x1b\[[0-9;]*m`)
+	warningPrefixes = []string{"**WARNING**", "npm WARN ", "warning ", "WARN "}
+	errorPrefixes   = []string{"**ERROR**", "npm ERR!", "error "}
+)
+
+// Writer passes lines through to the writer it wraps, printing each warning
+// once. Flush prints the warnings which were repeated again, in the order
+// they first appeared, with how often they were. Errors are never held back.
+// BP_LOG_DEDUP=false turns it off.
+type Writer struct {
+	w       io.Writer
+	mu      sync.Mutex
+	pending []byte
+	counts  map[string]int
+	order   []string
+}
+
+func New(w io.Writer) *Writer {
+	return &Writer{w: w, counts: map[string]int{}}
+}
+
+// Write passes the complete lines of data through, holding back a partial
+// last line until it is complete. A warning of the logger and the indented
+// lines after it in the same write are a single warning.
+func (d *Writer) Write(data []byte) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.pending = append(d.pending, data...)
+	end := bytes.LastIndexByte(d.pending, '\n')
+	if end < 0 {
+		return len(data), nil
+	}
+	lines := strings.SplitAfter(string(d.pending[:end+1]), "\n")
+	d.pending = append([]byte(nil), d.pending[end+1:]...)
+
+	for i := 0; i < len(lines) && lines[i] != ""; {
+		unit := lines[i]
+		i++
+		if !hasPrefix(unit, warningPrefixes) {
+			if _, err := io.WriteString(d.w, unit); err != nil {
+				return 0, err
+			}
+			continue
+		}
+		for i < len(lines) && strings.HasPrefix(lines[i], continuation) && !hasPrefix(lines[i], warningPrefixes) && !hasPrefix(lines[i], errorPrefixes) {
+			unit += lines[i]
+			i++
+		}
+		if d.first(unit) {
+			if _, err := io.WriteString(d.w, unit); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return len(data), nil
+}
+
+// Flush writes out a trailing partial line and the warnings which were
+// repeated, with their count, and forgets them. It is called at the end of
+// each phase of staging.
+func (d *Writer) Flush() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if len(d.pending) > 0 {
+		if _, err := d.w.Write(append(d.pending, '\n')); err != nil {
+			return err
+		}
+		d.pending = nil
+	}
+	for _, unit := range d.order {
+		if count := d.counts[unit]; count > 1 {
+			if _, err := fmt.Fprintf(d.w, "%s (repeated %d times)\n", strings.TrimSuffix(unit, "\n"), count); err != nil {
+				return err
+			}
+		}
+	}
+	d.counts = map[string]int{}
+	d.order = nil
+	return nil
+}
+
+// first counts unit and returns whether to print it: the first time, or
+// every time once MaxTracked warnings are tracked or with BP_LOG_DEDUP=false.
+func (d *Writer) first(unit string) bool {
+	if os.Getenv("BP_LOG_DEDUP") == "false" {
+		return true
+	}
+	if _, found := d.counts[unit]; found {
+		d.counts[unit]++
+		return false
+	}
+	if len(d.order) >= MaxTracked {
+		return true
+	}
+	d.counts[unit] = 1
+	d.order = append(d.order, unit)
+	return true
+}
+
+func hasPrefix(line string, prefixes []string) bool {
+	line = strings.TrimLeft(ansiEscape.ReplaceAllString(line, ""), " \t")
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(line, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,13 @@
+package logdedup_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestLogdedup(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Logdedup Suite")
+}
@@ -0,0 +1,112 @@
+package logdedup_test
+
+import (
+	"bytes"
+	"fmt"
+	"nodejs/logdedup"
+	"os"
+	"strings"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/cloudfoundry/libbuildpack/ansicleaner"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Writer", func() {
+	var (
+		buffer   *bytes.Buffer
+		writer   *logdedup.Writer
+		oldDedup string
+	)
+
+	BeforeEach(func() {
+		oldDedup = os.Getenv("BP_LOG_DEDUP")
+		os.Unsetenv("BP_LOG_DEDUP")
+
+		buffer = new(bytes.Buffer)
+		writer = logdedup.New(buffer)
+	})
+
+	AfterEach(func() {
+		os.Setenv("BP_LOG_DEDUP", oldDedup)
+	})
+
+	write := func(lines ...string) {
+		for _, line := range lines {
+			_, err := writer.Write([]byte(line))
+			Expect(err).To(BeNil())
+		}
+	}
+
+	It("prints a repeated warning once and counts it at the end of the phase", func() {
+		write(
+			"npm WARN deprecated request@2.88.2: request has been deprecated\n",
+			"added 1 package\n",
+			"npm WARN deprecated request@2.88.2: request has been deprecated\nnpm WARN deprecated uuid@3.4.0: use uuid 7\n",
+			"npm WARN deprecated request@2.88.2: request has been deprecated\n",
+		)
+		Expect(buffer.String()).To(Equal("npm WARN deprecated request@2.88.2: request has been deprecated\nadded 1 package\nnpm WARN deprecated uuid@3.4.0: use uuid 7\n"))
+
+		Expect(writer.Flush()).To(Succeed())
+		Expect(buffer.String()).To(HaveSuffix("npm WARN deprecated uuid@3.4.0: use uuid 7\nnpm WARN deprecated request@2.88.2: request has been deprecated (repeated 3 times)\n"))
+
+		// The next phase starts afresh.
+		buffer.Reset()
+		write("npm WARN deprecated request@2.88.2: request has been deprecated\n")
+		Expect(writer.Flush()).To(Succeed())
+		Expect(buffer.String()).To(Equal("npm WARN deprecated request@2.88.2: request has been deprecated\n"))
+	})
+
+	It("keeps the order of the first occurrences", func() {
+		write("warning b@1: engine\n", "warning a@1: engine\n", "warning a@1: engine\n", "warning b@1: engine\n")
+		Expect(writer.Flush()).To(Succeed())
+		Expect(buffer.String()).To(Equal("warning b@1: engine\nwarning a@1: engine\nwarning b@1: engine (repeated 2 times)\nwarning a@1: engine (repeated 2 times)\n"))
+	})
+
+	It("treats a multiline warning of the logger as one", func() {
+		logger := libbuildpack.NewLogger(ansicleaner.New(writer))
+		logger.Warning("engine mismatch\nin packages/a")
+		logger.Warning("engine mismatch\nin packages/b")
+		logger.Warning("engine mismatch\nin packages/a")
+		logger.Info("installing")
+
+		Expect(buffer.String()).To(Equal("       **WARNING** engine mismatch\n       in packages/a\n       **WARNING** engine mismatch\n       in packages/b\n       installing\n"))
+		Expect(writer.Flush()).To(Succeed())
+		Expect(buffer.String()).To(HaveSuffix("       installing\n       **WARNING** engine mismatch\n       in packages/a (repeated 2 times)\n"))
+	})
+
+	It("never holds back an error", func() {
+		write("npm ERR! code E404\n", "npm ERR! code E404\n", "       **ERROR** failed\n", "       **ERROR** failed\n")
+		Expect(writer.Flush()).To(Succeed())
+		Expect(buffer.String()).To(Equal("npm ERR! code E404\nnpm ERR! code E404\n       **ERROR** failed\n       **ERROR** failed\n"))
+	})
+
+	It("holds back a partial line until it is complete", func() {
+		write("npm WARN dep", "recated x\nadded")
+		Expect(buffer.String()).To(Equal("npm WARN deprecated x\n"))
+		write(" 2 packages\n", "partial")
+		Expect(buffer.String()).To(Equal("npm WARN deprecated x\nadded 2 packages\n"))
+		Expect(writer.Flush()).To(Succeed())
+		Expect(buffer.String()).To(HaveSuffix("partial\n"))
+	})
+
+	It("prints every warning beyond the ones it tracks", func() {
+		for i := 0; i < logdedup.MaxTracked; i++ {
+			write(fmt.Sprintf("warning package-%d: deprecated\n", i))
+		}
+		write("warning package-0: deprecated\n", "warning untracked: deprecated\n", "warning untracked: deprecated\n")
+
+		Expect(strings.Count(buffer.String(), "warning package-0: deprecated\n")).To(Equal(1))
+		Expect(strings.Count(buffer.String(), "warning untracked: deprecated\n")).To(Equal(2))
+		Expect(writer.Flush()).To(Succeed())
+		Expect(buffer.String()).To(HaveSuffix("warning untracked: deprecated\nwarning package-0: deprecated (repeated 2 times)\n"))
+	})
+
+	It("prints every warning with BP_LOG_DEDUP=false", func() {
+		os.Setenv("BP_LOG_DEDUP", "false")
+		write("warning a@1: engine\n", "warning a@1: engine\n")
+		Expect(writer.Flush()).To(Succeed())
+		Expect(buffer.String()).To(Equal("warning a@1: engine\nwarning a@1: engine\n"))
+	})
+})
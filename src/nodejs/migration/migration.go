@@ -0,0 +1,181 @@
+package migration
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"nodejs/packagejson"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/cloudfoundry/libbuildpack"
+)
+
+// LeavingWithin is how far ahead of a deprecation date a node version line is
+// considered to leave the manifest with the next release.
+const LeavingWithin = 90 * 24 * time.Hour
+
+type Status string
+
+const (
+	Pass Status = "pass"
+	Warn Status = "warn"
+)
+
+// Result is the outcome of a rule for an app.
+type Result struct {
+	ID      string `json:"id"`
+	Status  Status `json:"status"`
+	Message string `json:"message"`
+}
+
+// App is what the rules know about the app being staged.
+type App struct {
+	UseYarn         bool
+	IsVendored      bool
+	YarnEngine      string
+	PackageManager  string
+	YarnBerry       bool
+	HasLockfile     bool
+	LockfileChanged bool
+	NodeVersion     string
+	Deprecations    []libbuildpack.DeprecationDate
+	Now             time.Time
+}
+
+// Rule checks the app for one behavior slated for removal.
+type Rule func(App) Result
+
+// Rules are evaluated in order by Evaluate. A new rule only needs to be added
+// here.
+var Rules = []Rule{
+	NPMLockfileMutation,
+	YarnClassicEngine,
+	NodeLeavingManifest,
+}
+
+// LoadApp reads the package.json and lockfiles of the app in buildDir.
+func LoadApp(buildDir string) (App, error) {
+	var app App
+
+	pkg, _, err := packagejson.Load(buildDir)
+	if err != nil {
+		return app, err
+	}
+	app.YarnEngine = pkg.Engines.Yarn
+	app.PackageManager = pkg.PackageManager
+
+	for _, name := range []string{"package-lock.json", "npm-shrinkwrap.json"} {
+		found, err := libbuildpack.FileExists(filepath.Join(buildDir, name))
+		if err != nil {
+			return app, err
+		}
+		app.HasLockfile = app.HasLockfile || found
+	}
+
+	yarnLock, err := ioutil.ReadFile(filepath.Join(buildDir, "yarn.lock"))
+	if err != nil && !os.IsNotExist(err) {
+		return app, err
+	}
+	app.YarnBerry = bytes.Contains(yarnLock, []byte("__metadata:"))
+
+	return app, nil
+}
+
+// Evaluate runs rules against app.
+func Evaluate(app App, rules []Rule) []Result {
+	results := make([]Result, 0, len(rules))
+	for _, rule := range rules {
+		results = append(results, rule(app))
+	}
+	return results
+}
+
+// Warnings returns the results of rules which warned.
+func Warnings(results []Result) []Result {
+	var warnings []Result
+	for _, result := range results {
+		if result.Status == Warn {
+			warnings = append(warnings, result)
+		}
+	}
+	return warnings
+}
+
+// WriteReport writes results as JSON to path.
+func WriteReport(path string, results []Result) error {
+	data, err := json.MarshalIndent(struct {
+		Results []Result `json:"results"`
+	}{results}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(data, '\n'), 0644)
+}
+
+func pass(id, message string) Result {
+	return Result{ID: id, Status: Pass, Message: message}
+}
+
+func warn(id, format string, args ...interface{}) Result {
+	return Result{ID: id, Status: Warn, Message: fmt.Sprintf(format, args...)}
+}
+
+// NPMLockfileMutation warns about npm apps which rely on npm install creating
+// or rewriting their lockfile, which npm ci refuses to do.
+func NPMLockfileMutation(app App) Result {
+	const id = "npm-lockfile-mutation"
+	switch {
+	case app.UseYarn:
+		return pass(id, "app uses yarn")
+	case app.IsVendored:
+		return pass(id, "node_modules is vendored")
+	case !app.HasLockfile:
+		return warn(id, "npm install resolved dependencies without a package-lock.json; a future release will install with npm ci, which requires one. Commit package-lock.json")
+	case app.LockfileChanged:
+		return warn(id, "npm install changed package-lock.json during staging, so it is out of sync with package.json; a future release will install with npm ci, which fails instead. Run npm install locally and commit package-lock.json")
+	}
+	return pass(id, "package-lock.json is in sync with package.json")
+}
+
+// YarnClassicEngine warns about yarn 1 apps which leave the yarn version to
+// the buildpack.
+func YarnClassicEngine(app App) Result {
+	const id = "yarn-classic-engine"
+	switch {
+	case !app.UseYarn:
+		return pass(id, "app uses npm")
+	case app.YarnBerry:
+		return pass(id, "app uses yarn 2 or later")
+	case app.YarnEngine != "":
+		return pass(id, fmt.Sprintf("engines.yarn is %s", app.YarnEngine))
+	case strings.HasPrefix(app.PackageManager, "yarn@"):
+		return pass(id, fmt.Sprintf("packageManager is %s", app.PackageManager))
+	}
+	return warn(id, "yarn classic is used without engines.yarn; a future release will stop defaulting to yarn 1. Set engines.yarn in package.json")
+}
+
+// NodeLeavingManifest warns about apps on a node version line whose
+// deprecation date has passed or is within LeavingWithin.
+func NodeLeavingManifest(app App) Result {
+	const id = "node-leaving-manifest"
+	if app.NodeVersion == "" {
+		return pass(id, "node version is unknown")
+	}
+	major := strings.SplitN(app.NodeVersion, ".", 2)[0]
+
+	for _, deprecation := range app.Deprecations {
+		if deprecation.Name != "node" || deprecation.VersionLine != major+".x" {
+			continue
+		}
+		date, err := time.Parse("2006-01-02", deprecation.Date)
+		if err != nil || date.After(app.Now.Add(LeavingWithin)) {
+			continue
+		}
+		return warn(id, "node %s is deprecated since %s and will leave the buildpack with the next release (%s). Upgrade engines.node to a supported version line", deprecation.VersionLine, deprecation.Date, deprecation.Link)
+	}
+	return pass(id, fmt.Sprintf("node %s.x is supported", major))
+}
@@ -0,0 +1,152 @@
+package migration_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"nodejs/migration"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/cloudfoundry/libbuildpack"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Migration", func() {
+	now := time.Date(2019, 10, 15, 0, 0, 0, 0, time.UTC)
+	deprecations := []libbuildpack.DeprecationDate{
+		{Name: "node", VersionLine: "8.x", Date: "2019-12-31", Link: "https://github.com/nodejs/LTS"},
+		{Name: "node", VersionLine: "10.x", Date: "2021-04-30", Link: "https://github.com/nodejs/LTS"},
+		{Name: "node", VersionLine: "6.x", Date: "2019-04-30", Link: "https://github.com/nodejs/LTS"},
+		{Name: "yarn", VersionLine: "12.x", Date: "2019-01-01"},
+	}
+
+	DescribeTable("rules",
+		func(rule migration.Rule, app migration.App, id string, status migration.Status, message string) {
+			result := rule(app)
+			Expect(result.ID).To(Equal(id))
+			Expect(result.Status).To(Equal(status))
+			Expect(result.Message).To(ContainSubstring(message))
+		},
+		Entry("npm with a lockfile", migration.NPMLockfileMutation, migration.App{HasLockfile: true},
+			"npm-lockfile-mutation", migration.Pass, "in sync"),
+		Entry("npm without a lockfile", migration.NPMLockfileMutation, migration.App{},
+			"npm-lockfile-mutation", migration.Warn, "without a package-lock.json"),
+		Entry("npm rewriting the lockfile", migration.NPMLockfileMutation, migration.App{HasLockfile: true, LockfileChanged: true},
+			"npm-lockfile-mutation", migration.Warn, "changed package-lock.json"),
+		Entry("vendored node_modules", migration.NPMLockfileMutation, migration.App{IsVendored: true},
+			"npm-lockfile-mutation", migration.Pass, "vendored"),
+		Entry("yarn without a package-lock.json", migration.NPMLockfileMutation, migration.App{UseYarn: true},
+			"npm-lockfile-mutation", migration.Pass, "yarn"),
+
+		Entry("yarn classic without engines.yarn", migration.YarnClassicEngine, migration.App{UseYarn: true},
+			"yarn-classic-engine", migration.Warn, "without engines.yarn"),
+		Entry("yarn classic with engines.yarn", migration.YarnClassicEngine, migration.App{UseYarn: true, YarnEngine: "1.22.x"},
+			"yarn-classic-engine", migration.Pass, "engines.yarn is 1.22.x"),
+		Entry("yarn pinned with packageManager", migration.YarnClassicEngine, migration.App{UseYarn: true, PackageManager: "yarn@1.22.19"},
+			"yarn-classic-engine", migration.Pass, "packageManager is yarn@1.22.19"),
+		Entry("yarn 2 or later", migration.YarnClassicEngine, migration.App{UseYarn: true, YarnBerry: true},
+			"yarn-classic-engine", migration.Pass, "yarn 2"),
+		Entry("npm", migration.YarnClassicEngine, migration.App{},
+			"yarn-classic-engine", migration.Pass, "npm"),
+
+		Entry("node line deprecated soon", migration.NodeLeavingManifest, migration.App{NodeVersion: "8.16.1", Deprecations: deprecations, Now: now},
+			"node-leaving-manifest", migration.Warn, "node 8.x is deprecated since 2019-12-31"),
+		Entry("node line already deprecated", migration.NodeLeavingManifest, migration.App{NodeVersion: "6.17.1", Deprecations: deprecations, Now: now},
+			"node-leaving-manifest", migration.Warn, "node 6.x is deprecated since 2019-04-30"),
+		Entry("node line deprecated later", migration.NodeLeavingManifest, migration.App{NodeVersion: "10.16.3", Deprecations: deprecations, Now: now},
+			"node-leaving-manifest", migration.Pass, "node 10.x is supported"),
+		Entry("node line without a deprecation date", migration.NodeLeavingManifest, migration.App{NodeVersion: "12.10.0", Deprecations: deprecations, Now: now},
+			"node-leaving-manifest", migration.Pass, "node 12.x is supported"),
+		Entry("unknown node version", migration.NodeLeavingManifest, migration.App{Deprecations: deprecations, Now: now},
+			"node-leaving-manifest", migration.Pass, "unknown"),
+	)
+
+	Describe("Evaluate", func() {
+		It("runs every rule in order", func() {
+			always := func(id string, status migration.Status) migration.Rule {
+				return func(migration.App) migration.Result {
+					return migration.Result{ID: id, Status: status, Message: id}
+				}
+			}
+			results := migration.Evaluate(migration.App{}, []migration.Rule{always("a", migration.Pass), always("b", migration.Warn), always("c", migration.Warn)})
+			Expect(results).To(HaveLen(3))
+			Expect(results[0].ID).To(Equal("a"))
+
+			warnings := migration.Warnings(results)
+			Expect(warnings).To(HaveLen(2))
+			Expect(warnings[0].ID).To(Equal("b"))
+			Expect(warnings[1].ID).To(Equal("c"))
+		})
+
+		It("includes the built in rules", func() {
+			results := migration.Evaluate(migration.App{HasLockfile: true}, migration.Rules)
+			var ids []string
+			for _, result := range results {
+				ids = append(ids, result.ID)
+			}
+			Expect(ids).To(Equal([]string{"npm-lockfile-mutation", "yarn-classic-engine", "node-leaving-manifest"}))
+		})
+	})
+
+	Describe("LoadApp", func() {
+		var buildDir string
+
+		BeforeEach(func() {
+			var err error
+			buildDir, err = ioutil.TempDir("", "nodejs-buildpack.build.")
+			Expect(err).To(BeNil())
+		})
+
+		AfterEach(func() {
+			Expect(os.RemoveAll(buildDir)).To(Succeed())
+		})
+
+		It("reads package.json and the lockfiles", func() {
+			Expect(ioutil.WriteFile(filepath.Join(buildDir, "package.json"), []byte(`{"engines":{"yarn":"1.x"},"packageManager":"yarn@1.22.19"}`), 0644)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(buildDir, "npm-shrinkwrap.json"), []byte(`{}`), 0644)).To(Succeed())
+
+			app, err := migration.LoadApp(buildDir)
+			Expect(err).To(BeNil())
+			Expect(app.YarnEngine).To(Equal("1.x"))
+			Expect(app.PackageManager).To(Equal("yarn@1.22.19"))
+			Expect(app.HasLockfile).To(BeTrue())
+			Expect(app.YarnBerry).To(BeFalse())
+		})
+
+		It("detects a yarn 2+ lockfile", func() {
+			Expect(ioutil.WriteFile(filepath.Join(buildDir, "yarn.lock"), []byte("__metadata:\n  version: 6\n"), 0644)).To(Succeed())
+
+			app, err := migration.LoadApp(buildDir)
+			Expect(err).To(BeNil())
+			Expect(app.YarnBerry).To(BeTrue())
+			Expect(app.HasLockfile).To(BeFalse())
+		})
+
+		It("returns an error for an invalid package.json", func() {
+			Expect(ioutil.WriteFile(filepath.Join(buildDir, "package.json"), []byte(`{`), 0644)).To(Succeed())
+
+			_, err := migration.LoadApp(buildDir)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("WriteReport", func() {
+		It("writes the results as JSON", func() {
+			dir, err := ioutil.TempDir("", "nodejs-buildpack.deps.")
+			Expect(err).To(BeNil())
+			defer os.RemoveAll(dir)
+
+			path := filepath.Join(dir, "migration-report.json")
+			Expect(migration.WriteReport(path, []migration.Result{{ID: "a", Status: migration.Warn, Message: "m"}})).To(Succeed())
+
+			data, err := ioutil.ReadFile(path)
+			Expect(err).To(BeNil())
+			var report map[string][]map[string]string
+			Expect(json.Unmarshal(data, &report)).To(Succeed())
+			Expect(report["results"]).To(Equal([]map[string]string{{"id": "a", "status": "warn", "message": "m"}}))
+		})
+	})
+})
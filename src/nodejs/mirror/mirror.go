@@ -0,0 +1,151 @@
+package mirror
+
+import (
+	"fmt"
+	"net/url"
+	"nodejs/network"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/cloudfoundry/libbuildpack"
+)
+
+// Config describes where manifest dependencies should be downloaded from
+// instead of their original URIs. Exclude lists dependency names (or
+// name@version) which keep their original URI.
+type Config struct {
+	Base    string
+	Exclude []string
+}
+
+type overrideConfig struct {
+	DependenciesMirror        string   `yaml:"dependencies_mirror"`
+	DependenciesMirrorExclude []string `yaml:"dependencies_mirror_exclude"`
+}
+
+// LoadConfig reads the mirror settings from the language section of any
+// override.yml in the deps dir, with BP_DEPS_MIRROR and
+// BP_DEPS_MIRROR_EXCLUDE taking precedence.
+func LoadConfig(depsDir, language string) (Config, error) {
+	var config Config
+
+	files, err := filepath.Glob(filepath.Join(depsDir, "*", "override.yml"))
+	if err != nil {
+		return Config{}, err
+	}
+	for _, file := range files {
+		var overrideYml map[string]overrideConfig
+		if err := libbuildpack.NewYAML().Load(file, &overrideYml); err != nil {
+			return Config{}, err
+		}
+		if o, found := overrideYml[language]; found {
+			if o.DependenciesMirror != "" {
+				config.Base = o.DependenciesMirror
+			}
+			config.Exclude = append(config.Exclude, o.DependenciesMirrorExclude...)
+		}
+	}
+
+	if base := os.Getenv("BP_DEPS_MIRROR"); base != "" {
+		config.Base = base
+	}
+	for _, name := range strings.Split(os.Getenv("BP_DEPS_MIRROR_EXCLUDE"), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			config.Exclude = append(config.Exclude, name)
+		}
+	}
+
+	return config, nil
+}
+
+func (c Config) excluded(dep libbuildpack.Dependency) bool {
+	for _, name := range c.Exclude {
+		if name == dep.Name || name == dep.Name+"@"+dep.Version {
+			return true
+		}
+	}
+	return false
+}
+
+// Rewrite points a manifest entry at the mirror, keeping the file name of the
+// original URI. The sha256 is left untouched so the download is still
+// verified against the manifest. Entries which are already local (file://
+// URIs or cached files) are returned unchanged.
+func (c Config) Rewrite(entry libbuildpack.ManifestEntry) (libbuildpack.ManifestEntry, bool, error) {
+	if c.Base == "" || entry.File != "" || c.excluded(entry.Dependency) {
+		return entry, false, nil
+	}
+
+	original, err := url.Parse(entry.URI)
+	if err != nil {
+		return entry, false, fmt.Errorf("invalid uri for %s %s: %s", entry.Dependency.Name, entry.Dependency.Version, err)
+	}
+	if original.Scheme == "file" {
+		return entry, false, nil
+	}
+
+	fileName := path.Base(original.Path)
+	if fileName == "/" || fileName == "." {
+		return entry, false, fmt.Errorf("unable to determine file name of %s", entry.URI)
+	}
+
+	base, err := network.ParseURL(c.Base)
+	if err != nil {
+		return entry, false, fmt.Errorf("invalid dependencies mirror %s: %s", c.Base, err)
+	}
+
+	switch base.Scheme {
+	case "file":
+		entry.File = filepath.Join(base.Path, fileName)
+	case "http", "https":
+		base.Path = strings.TrimSuffix(base.Path, "/") + "/" + fileName
+		entry.URI = base.String()
+	default:
+		if base.Scheme == "" && filepath.IsAbs(c.Base) {
+			entry.File = filepath.Join(c.Base, fileName)
+		} else {
+			return entry, false, fmt.Errorf("unsupported dependencies mirror %s, expected an http(s) or file URL", c.Base)
+		}
+	}
+
+	return entry, true, nil
+}
+
+// Apply rewrites every dependency in the manifest to download from the mirror.
+func Apply(manifest *libbuildpack.Manifest, config Config, log *libbuildpack.Logger) error {
+	if config.Base == "" {
+		return nil
+	}
+
+	log.Info("Using dependencies mirror %s", redact(config.Base))
+
+	for idx, entry := range manifest.ManifestEntries {
+		rewritten, changed, err := config.Rewrite(entry)
+		if err != nil {
+			return err
+		}
+		if !changed {
+			continue
+		}
+
+		to := rewritten.URI
+		if rewritten.File != "" {
+			to = rewritten.File
+		}
+		log.Debug("Mirroring %s %s: %s -> %s", entry.Dependency.Name, entry.Dependency.Version, redact(entry.URI), redact(to))
+		manifest.ManifestEntries[idx] = rewritten
+	}
+
+	return nil
+}
+
+func redact(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.User == nil {
+		return rawURL
+	}
+	u.User = url.UserPassword("-redacted-", "-redacted-")
+	return u.String()
+}
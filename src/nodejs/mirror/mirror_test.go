@@ -0,0 +1,235 @@
+package mirror_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"nodejs/mirror"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/cloudfoundry/libbuildpack/ansicleaner"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Mirror", func() {
+	var entry libbuildpack.ManifestEntry
+
+	BeforeEach(func() {
+		entry = libbuildpack.ManifestEntry{
+			Dependency: libbuildpack.Dependency{Name: "node", Version: "6.14.4"},
+			URI:        "https://buildpacks.cloudfoundry.org/dependencies/node/node-6.14.4-linux-x64-cflinuxfs2-48a4a12d.tgz",
+			SHA256:     "48a4a12d08dd067dedd8bf440e259dd853b77298e78fe80f702627c1ca4a54b6",
+		}
+	})
+
+	Describe("Rewrite", func() {
+		It("replaces the origin with the mirror base and keeps the file name", func() {
+			rewritten, changed, err := mirror.Config{Base: "https://mirror.internal/cf/deps/"}.Rewrite(entry)
+			Expect(err).To(BeNil())
+			Expect(changed).To(BeTrue())
+			Expect(rewritten.URI).To(Equal("https://mirror.internal/cf/deps/node-6.14.4-linux-x64-cflinuxfs2-48a4a12d.tgz"))
+			Expect(rewritten.SHA256).To(Equal(entry.SHA256))
+		})
+
+		It("maps a file:// mirror onto a local file", func() {
+			rewritten, changed, err := mirror.Config{Base: "file:///var/mirror"}.Rewrite(entry)
+			Expect(err).To(BeNil())
+			Expect(changed).To(BeTrue())
+			Expect(rewritten.File).To(Equal("/var/mirror/node-6.14.4-linux-x64-cflinuxfs2-48a4a12d.tgz"))
+			Expect(rewritten.URI).To(Equal(entry.URI))
+		})
+
+		It("leaves file:// dependency uris alone", func() {
+			entry.URI = "file:///tmp/node.tgz"
+			rewritten, changed, err := mirror.Config{Base: "https://mirror.internal"}.Rewrite(entry)
+			Expect(err).To(BeNil())
+			Expect(changed).To(BeFalse())
+			Expect(rewritten).To(Equal(entry))
+		})
+
+		It("leaves dependencies cached in the buildpack alone", func() {
+			entry.File = "dependencies/abc/node.tgz"
+			_, changed, err := mirror.Config{Base: "https://mirror.internal"}.Rewrite(entry)
+			Expect(err).To(BeNil())
+			Expect(changed).To(BeFalse())
+		})
+
+		It("honors the per-dependency escape hatch", func() {
+			for _, exclude := range []string{"node", "node@6.14.4"} {
+				_, changed, err := mirror.Config{Base: "https://mirror.internal", Exclude: []string{exclude}}.Rewrite(entry)
+				Expect(err).To(BeNil())
+				Expect(changed).To(BeFalse())
+			}
+
+			_, changed, err := mirror.Config{Base: "https://mirror.internal", Exclude: []string{"node@8.0.0"}}.Rewrite(entry)
+			Expect(err).To(BeNil())
+			Expect(changed).To(BeTrue())
+		})
+
+		It("accepts a bracketed IPv6 mirror", func() {
+			rewritten, changed, err := mirror.Config{Base: "http://[fd00::1]:8080/deps"}.Rewrite(entry)
+			Expect(err).To(BeNil())
+			Expect(changed).To(BeTrue())
+			Expect(rewritten.URI).To(Equal("http://[fd00::1]:8080/deps/node-6.14.4-linux-x64-cflinuxfs2-48a4a12d.tgz"))
+		})
+
+		It("rejects an IPv6 mirror without brackets", func() {
+			_, _, err := mirror.Config{Base: "http://fd00::1:8080/deps"}.Rewrite(entry)
+			Expect(err).To(MatchError("invalid dependencies mirror http://fd00::1:8080/deps: IPv6 addresses need brackets, like http://[fd00::1]:8080/"))
+		})
+
+		It("rejects unsupported mirror schemes", func() {
+			_, _, err := mirror.Config{Base: "ftp://mirror.internal"}.Rewrite(entry)
+			Expect(err).To(MatchError(ContainSubstring("unsupported dependencies mirror")))
+		})
+	})
+
+	Describe("LoadConfig", func() {
+		var (
+			depsDir          string
+			oldMirror        string
+			oldMirrorExclude string
+		)
+
+		BeforeEach(func() {
+			var err error
+			depsDir, err = ioutil.TempDir("", "nodejs-buildpack.deps.")
+			Expect(err).To(BeNil())
+
+			oldMirror = os.Getenv("BP_DEPS_MIRROR")
+			oldMirrorExclude = os.Getenv("BP_DEPS_MIRROR_EXCLUDE")
+			os.Unsetenv("BP_DEPS_MIRROR")
+			os.Unsetenv("BP_DEPS_MIRROR_EXCLUDE")
+
+			Expect(os.MkdirAll(filepath.Join(depsDir, "0"), 0755)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(depsDir, "0", "override.yml"), []byte(`---
+nodejs:
+  dependencies_mirror: https://from-file.internal
+  dependencies_mirror_exclude: [yarn]
+`), 0644)).To(Succeed())
+		})
+
+		AfterEach(func() {
+			os.Setenv("BP_DEPS_MIRROR", oldMirror)
+			os.Setenv("BP_DEPS_MIRROR_EXCLUDE", oldMirrorExclude)
+			Expect(os.RemoveAll(depsDir)).To(Succeed())
+		})
+
+		It("reads the mirror from override.yml", func() {
+			config, err := mirror.LoadConfig(depsDir, "nodejs")
+			Expect(err).To(BeNil())
+			Expect(config.Base).To(Equal("https://from-file.internal"))
+			Expect(config.Exclude).To(Equal([]string{"yarn"}))
+		})
+
+		It("ignores other languages", func() {
+			config, err := mirror.LoadConfig(depsDir, "ruby")
+			Expect(err).To(BeNil())
+			Expect(config.Base).To(Equal(""))
+		})
+
+		It("prefers the environment", func() {
+			os.Setenv("BP_DEPS_MIRROR", "https://from-env.internal")
+			os.Setenv("BP_DEPS_MIRROR_EXCLUDE", "node@6.14.4, python")
+
+			config, err := mirror.LoadConfig(depsDir, "nodejs")
+			Expect(err).To(BeNil())
+			Expect(config.Base).To(Equal("https://from-env.internal"))
+			Expect(config.Exclude).To(Equal([]string{"yarn", "node@6.14.4", "python"}))
+		})
+	})
+
+	Describe("Apply", func() {
+		var (
+			bpDir     string
+			outputDir string
+			server    *httptest.Server
+			contents  []byte
+			served    []byte
+			manifest  *libbuildpack.Manifest
+			buffer    *bytes.Buffer
+			logger    *libbuildpack.Logger
+			oldDebug  string
+			oldStack  string
+		)
+
+		BeforeEach(func() {
+			var err error
+			bpDir, err = ioutil.TempDir("", "nodejs-buildpack.bp.")
+			Expect(err).To(BeNil())
+			outputDir, err = ioutil.TempDir("", "nodejs-buildpack.out.")
+			Expect(err).To(BeNil())
+
+			oldDebug = os.Getenv("BP_DEBUG")
+			oldStack = os.Getenv("CF_STACK")
+			os.Setenv("BP_DEBUG", "true")
+			os.Setenv("CF_STACK", "cflinuxfs2")
+
+			contents = []byte("#!/bin/sh\necho hi\n")
+			served = contents
+			sum := sha256.Sum256(contents)
+
+			server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/mirror/tool-1.0.0.sh" {
+					w.WriteHeader(http.StatusNotFound)
+					return
+				}
+				w.Write(served)
+			}))
+
+			Expect(ioutil.WriteFile(filepath.Join(bpDir, "manifest.yml"), []byte(`---
+language: nodejs
+dependencies:
+- name: tool
+  version: 1.0.0
+  uri: https://origin.example.com/deps/tool/tool-1.0.0.sh
+  sha256: `+hex.EncodeToString(sum[:])+`
+  cf_stacks: [cflinuxfs2]
+`), 0644)).To(Succeed())
+
+			buffer = new(bytes.Buffer)
+			logger = libbuildpack.NewLogger(ansicleaner.New(buffer))
+			manifest, err = libbuildpack.NewManifest(bpDir, logger, time.Now())
+			Expect(err).To(BeNil())
+		})
+
+		AfterEach(func() {
+			server.Close()
+			os.Setenv("BP_DEBUG", oldDebug)
+			os.Setenv("CF_STACK", oldStack)
+			Expect(os.RemoveAll(bpDir)).To(Succeed())
+			Expect(os.RemoveAll(outputDir)).To(Succeed())
+		})
+
+		It("downloads from the mirror and logs the rewrite", func() {
+			Expect(mirror.Apply(manifest, mirror.Config{Base: server.URL + "/mirror"}, logger)).To(Succeed())
+			Expect(buffer.String()).To(ContainSubstring("https://origin.example.com/deps/tool/tool-1.0.0.sh -> " + server.URL + "/mirror/tool-1.0.0.sh"))
+
+			installer := libbuildpack.NewInstaller(manifest)
+			Expect(installer.InstallDependency(libbuildpack.Dependency{Name: "tool", Version: "1.0.0"}, filepath.Join(outputDir, "tool.sh"))).To(Succeed())
+			Expect(ioutil.ReadFile(filepath.Join(outputDir, "tool.sh"))).To(Equal(contents))
+		})
+
+		It("still enforces the manifest sha256", func() {
+			served = []byte("#!/bin/sh\necho tampered\n")
+			Expect(mirror.Apply(manifest, mirror.Config{Base: server.URL + "/mirror"}, logger)).To(Succeed())
+
+			installer := libbuildpack.NewInstaller(manifest)
+			err := installer.InstallDependency(libbuildpack.Dependency{Name: "tool", Version: "1.0.0"}, filepath.Join(outputDir, "tool.sh"))
+			Expect(err).To(MatchError(ContainSubstring("sha256 mismatch")))
+		})
+
+		It("does nothing without a mirror", func() {
+			Expect(mirror.Apply(manifest, mirror.Config{}, logger)).To(Succeed())
+			Expect(manifest.ManifestEntries[0].URI).To(Equal("https://origin.example.com/deps/tool/tool-1.0.0.sh"))
+			Expect(buffer.String()).To(Equal(""))
+		})
+	})
+})
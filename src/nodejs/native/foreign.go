@@ -0,0 +1,194 @@
+package native
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Binary formats of compiled addons.
+const (
+	FormatELF   = "ELF"
+	FormatMachO = "Mach-O"
+	FormatPE    = "PE"
+)
+
+var magics = []struct {
+	format string
+	magic  []byte
+}{
+	{FormatELF, []byte{0x7f, 'E', 'L', 'F'}},
+	{FormatMachO, []byte{0xfe, 0xed, 0xfa, 0xce}},
+	{FormatMachO, []byte{0xfe, 0xed, 0xfa, 0xcf}},
+	{FormatMachO, []byte{0xce, 0xfa, 0xed, 0xfe}},
+	{FormatMachO, []byte{0xcf, 0xfa, 0xed, 0xfe}},
+	// Universal binaries, which Java class files share the magic of.
+	{FormatMachO, []byte{0xca, 0xfe, 0xba, 0xbe}},
+	{FormatPE, []byte{'M', 'Z'}},
+}
+
+// platformFormats are the addon formats node loads on each os.
+var platformFormats = map[string]string{
+	"linux":  FormatELF,
+	"darwin": FormatMachO,
+	"win32":  FormatPE,
+}
+
+// BinaryFormat returns the format of the binary at path from its magic
+// bytes, or "" when it is none of the known formats.
+func BinaryFormat(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	header := make([]byte, 4)
+	n, err := io.ReadFull(f, header)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+	header = header[:n]
+	for _, m := range magics {
+		if bytes.HasPrefix(header, m.magic) {
+			return m.format, nil
+		}
+	}
+	return "", nil
+}
+
+// ForeignPackage is an installed package built for another platform.
+type ForeignPackage struct {
+	// Path is the package directory relative to the parent of node_modules.
+	Path    string
+	Package string
+	Reason  string
+}
+
+func (f ForeignPackage) String() string {
+	return fmt.Sprintf("%s (%s): %s", f.Package, f.Path, f.Reason)
+}
+
+// FindForeignPackages returns the packages below nodeModules whose os field
+// excludes platform, or which hold compiled addons in the binary format of
+// another os, as left behind by node_modules installed on a developer
+// machine.
+func FindForeignPackages(nodeModules string, platform Platform) ([]ForeignPackage, error) {
+	var foreign []ForeignPackage
+	if err := findForeignPackages(nodeModules, nodeModules, platform, &foreign); err != nil {
+		return nil, err
+	}
+	sort.Slice(foreign, func(i, j int) bool { return foreign[i].Path < foreign[j].Path })
+	return foreign, nil
+}
+
+func findForeignPackages(dir, nodeModules string, platform Platform, foreign *[]ForeignPackage) error {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		if !entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		if strings.HasPrefix(entry.Name(), "@") {
+			if err := findForeignPackages(path, nodeModules, platform, foreign); err != nil {
+				return err
+			}
+			continue
+		}
+
+		var pkg struct {
+			Name string   `json:"name"`
+			OS   []string `json:"os"`
+		}
+		if data, err := ioutil.ReadFile(filepath.Join(path, "package.json")); err == nil {
+			json.Unmarshal(data, &pkg)
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+		if pkg.Name == "" {
+			pkg.Name = packageName(filepath.Join(mustRel(nodeModules, path), "package.json"))
+		}
+
+		reason := ""
+		if !Supports(pkg.OS, platform.OS) {
+			reason = "its os field is " + strings.Join(pkg.OS, ",")
+		} else if reason, err = foreignAddon(path, platform); err != nil {
+			return err
+		}
+		if reason != "" {
+			*foreign = append(*foreign, ForeignPackage{
+				Path:    filepath.ToSlash(mustRel(filepath.Dir(nodeModules), path)),
+				Package: pkg.Name,
+				Reason:  reason,
+			})
+			continue
+		}
+
+		if err := findForeignPackages(filepath.Join(path, "node_modules"), nodeModules, platform, foreign); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var errFound = errors.New("found")
+
+// foreignAddon describes the first compiled addon of the package in dir,
+// outside its node_modules, which is not in the binary format of platform.
+// prebuilds holds binaries for every platform, node-gyp-build picks the one
+// for the running platform from it.
+func foreignAddon(dir string, platform Platform) (string, error) {
+	expected, known := platformFormats[platform.OS]
+	if !known {
+		return "", nil
+	}
+
+	reason := ""
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() && path != dir && (info.Name() == "node_modules" || info.Name() == "prebuilds" || strings.HasPrefix(info.Name(), ".")) {
+			return filepath.SkipDir
+		}
+		if !info.Mode().IsRegular() || filepath.Ext(path) != ".node" {
+			return nil
+		}
+
+		format, err := BinaryFormat(path)
+		if err != nil {
+			return err
+		}
+		if format != "" && format != expected {
+			reason = fmt.Sprintf("%s is a %s binary", filepath.ToSlash(mustRel(dir, path)), format)
+			return errFound
+		}
+		return nil
+	})
+	if err != nil && err != errFound {
+		return "", err
+	}
+	return reason, nil
+}
+
+func mustRel(base, path string) string {
+	rel, err := filepath.Rel(base, path)
+	if err != nil {
+		return path
+	}
+	return rel
+}
@@ -0,0 +1,97 @@
+package native_test
+
+import (
+	"io/ioutil"
+	"nodejs/native"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Foreign packages", func() {
+	linuxX64 := native.Platform{OS: "linux", CPU: "x64"}
+
+	DescribeTable("BinaryFormat",
+		func(path, format string) {
+			Expect(native.BinaryFormat(path)).To(Equal(format))
+		},
+		Entry("ELF", "testdata/new.node", native.FormatELF),
+		Entry("Mach-O", "testdata/macho.node", native.FormatMachO),
+		Entry("a universal Mach-O", "testdata/universal.node", native.FormatMachO),
+		Entry("PE", "testdata/pe.node", native.FormatPE),
+		Entry("not a binary", "testdata/script.node", ""),
+		Entry("a C source", "testdata/none.c", ""),
+	)
+
+	Describe("FindForeignPackages", func() {
+		var dir string
+
+		install := func(path, manifest string) {
+			Expect(os.MkdirAll(filepath.Join(dir, path), 0755)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(dir, path, "package.json"), []byte(manifest), 0644)).To(Succeed())
+		}
+		addon := func(path, fixture string) {
+			contents, err := ioutil.ReadFile(filepath.Join("testdata", fixture))
+			Expect(err).To(BeNil())
+			Expect(os.MkdirAll(filepath.Dir(filepath.Join(dir, path)), 0755)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(dir, path), contents, 0755)).To(Succeed())
+		}
+
+		BeforeEach(func() {
+			var err error
+			dir, err = ioutil.TempDir("", "nodejs-buildpack.foreign.")
+			Expect(err).To(BeNil())
+			dir = filepath.Join(dir, "node_modules")
+		})
+
+		AfterEach(func() {
+			Expect(os.RemoveAll(filepath.Dir(dir))).To(Succeed())
+		})
+
+		It("accepts packages built for the platform", func() {
+			install("bcrypt", `{"name":"bcrypt"}`)
+			addon("bcrypt/lib/binding/napi-v3/bcrypt_lib.node", "new.node")
+			install("@esbuild/linux-x64", `{"name":"@esbuild/linux-x64","os":["linux"]}`)
+			install("leveldown", `{"name":"leveldown"}`)
+			addon("leveldown/prebuilds/darwin-x64+arm64/node.napi.node", "universal.node")
+			addon("leveldown/prebuilds/linux-x64/node.napi.node", "new.node")
+			Expect(native.FindForeignPackages(dir, linuxX64)).To(BeEmpty())
+		})
+
+		It("reports packages for another os and addons in another format", func() {
+			install("fsevents", `{"name":"fsevents","os":["darwin"]}`)
+			addon("fsevents/fsevents.node", "macho.node")
+			install("@esbuild/win32-x64", `{"name":"@esbuild/win32-x64","os":["win32"]}`)
+			install("bcrypt", `{"name":"bcrypt"}`)
+			addon("bcrypt/lib/binding/napi-v3/bcrypt_lib.node", "macho.node")
+			install("app/node_modules/@corp/native", `{"name":"@corp/native"}`)
+			addon("app/node_modules/@corp/native/build/Release/native.node", "pe.node")
+			install("app/node_modules/scripted", `{"name":"scripted"}`)
+			addon("app/node_modules/scripted/build/scripted.node", "script.node")
+
+			foreign, err := native.FindForeignPackages(dir, linuxX64)
+			Expect(err).To(BeNil())
+			Expect(foreign).To(Equal([]native.ForeignPackage{
+				{Path: "node_modules/@esbuild/win32-x64", Package: "@esbuild/win32-x64", Reason: "its os field is win32"},
+				{Path: "node_modules/app/node_modules/@corp/native", Package: "@corp/native", Reason: "build/Release/native.node is a PE binary"},
+				{Path: "node_modules/bcrypt", Package: "bcrypt", Reason: "lib/binding/napi-v3/bcrypt_lib.node is a Mach-O binary"},
+				{Path: "node_modules/fsevents", Package: "fsevents", Reason: "its os field is darwin"},
+			}))
+			Expect(foreign[2].String()).To(Equal("bcrypt (node_modules/bcrypt): lib/binding/napi-v3/bcrypt_lib.node is a Mach-O binary"))
+		})
+
+		It("names packages without a package.json after their directory", func() {
+			addon("@corp/native/native.node", "macho.node")
+			Expect(native.FindForeignPackages(dir, linuxX64)).To(Equal([]native.ForeignPackage{
+				{Path: "node_modules/@corp/native", Package: "@corp/native", Reason: "native.node is a Mach-O binary"},
+			}))
+		})
+
+		It("returns nothing without node_modules", func() {
+			Expect(native.FindForeignPackages(filepath.Join(dir, "missing"), linuxX64)).To(BeEmpty())
+		})
+	})
+})
@@ -0,0 +1,247 @@
+package native
+
+import (
+	"debug/elf"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ErrNotELF is returned for files which are not ELF binaries.
+var ErrNotELF = errors.New("not an ELF file")
+
+const (
+	verneedSize = 16
+	vernauxSize = 16
+)
+
+// VersionNeed is a versioned dependency of an ELF binary on a shared
+// library, e.g. GLIBC_2.28 from libc.so.6.
+type VersionNeed struct {
+	Library string
+	Version string
+}
+
+// VersionNeeds reads the version dependencies from the .gnu.version_r
+// section of the ELF binary at path.
+func VersionNeeds(path string) ([]VersionNeed, error) {
+	f, err := elf.Open(path)
+	if err != nil {
+		if _, isFormatErr := err.(*elf.FormatError); isFormatErr {
+			return nil, ErrNotELF
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	section := f.SectionByType(elf.SHT_GNU_VERNEED)
+	if section == nil {
+		return nil, nil
+	}
+	if int(section.Link) >= len(f.Sections) {
+		return nil, fmt.Errorf("%s: invalid string table index %d", path, section.Link)
+	}
+
+	data, err := section.Data()
+	if err != nil {
+		return nil, err
+	}
+	strtab, err := f.Sections[section.Link].Data()
+	if err != nil {
+		return nil, err
+	}
+
+	return parseVerneed(data, strtab, f.ByteOrder, int(section.Info))
+}
+
+type byteOrder interface {
+	Uint16([]byte) uint16
+	Uint32([]byte) uint32
+}
+
+// parseVerneed walks the Elf_Verneed entries and their Elf_Vernaux lists,
+// which have the same layout in 32 and 64 bit binaries.
+func parseVerneed(data, strtab []byte, order byteOrder, count int) ([]VersionNeed, error) {
+	var needs []VersionNeed
+	offset := 0
+	for i := 0; i < count; i++ {
+		if offset < 0 || offset+verneedSize > len(data) {
+			return nil, errors.New("truncated version needs section")
+		}
+		entry := data[offset:]
+		auxCount := int(order.Uint16(entry[2:4]))
+		library, err := elfString(strtab, order.Uint32(entry[4:8]))
+		if err != nil {
+			return nil, err
+		}
+
+		auxOffset := offset + int(order.Uint32(entry[8:12]))
+		for j := 0; j < auxCount; j++ {
+			if auxOffset < 0 || auxOffset+vernauxSize > len(data) {
+				return nil, errors.New("truncated version needs section")
+			}
+			aux := data[auxOffset:]
+			version, err := elfString(strtab, order.Uint32(aux[8:12]))
+			if err != nil {
+				return nil, err
+			}
+			needs = append(needs, VersionNeed{Library: library, Version: version})
+
+			next := int(order.Uint32(aux[12:16]))
+			if next == 0 {
+				break
+			}
+			auxOffset += next
+		}
+
+		next := int(order.Uint32(entry[12:16]))
+		if next == 0 {
+			break
+		}
+		offset += next
+	}
+	return needs, nil
+}
+
+func elfString(strtab []byte, offset uint32) (string, error) {
+	if int(offset) >= len(strtab) {
+		return "", errors.New("string table offset out of range")
+	}
+	end := int(offset)
+	for end < len(strtab) && strtab[end] != 0 {
+		end++
+	}
+	return string(strtab[offset:end]), nil
+}
+
+// RequiredGlibc returns the newest glibc version the ELF binary at path
+// needs, or "" when it has no versioned glibc dependencies.
+func RequiredGlibc(path string) (string, error) {
+	needs, err := VersionNeeds(path)
+	if err != nil {
+		return "", err
+	}
+
+	required := ""
+	for _, need := range needs {
+		if !strings.HasPrefix(need.Version, "GLIBC_") {
+			continue
+		}
+		version := strings.TrimPrefix(need.Version, "GLIBC_")
+		if _, err := parseVersion(version); err != nil {
+			continue
+		}
+		if required == "" || CompareVersions(version, required) > 0 {
+			required = version
+		}
+	}
+	return required, nil
+}
+
+func parseVersion(version string) ([]int, error) {
+	var parts []int
+	for _, part := range strings.Split(version, ".") {
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, n)
+	}
+	return parts, nil
+}
+
+// CompareVersions compares dotted numeric versions such as 2.28 and 2.2.5.
+// Components which are not numbers compare as 0.
+func CompareVersions(a, b string) int {
+	as, _ := parseVersion(a)
+	bs, _ := parseVersion(b)
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		var x, y int
+		if i < len(as) {
+			x = as[i]
+		}
+		if i < len(bs) {
+			y = bs[i]
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+// Addon is a compiled .node file and the package it belongs to.
+type Addon struct {
+	Package string
+	Path    string
+}
+
+// FindAddons returns the compiled addons below nodeModules. Paths are
+// relative to nodeModules.
+func FindAddons(nodeModules string) ([]Addon, error) {
+	var addons []Addon
+	err := filepath.Walk(nodeModules, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() && path != nodeModules && strings.HasPrefix(info.Name(), ".") {
+			return filepath.SkipDir
+		}
+		if !info.Mode().IsRegular() || filepath.Ext(path) != ".node" {
+			return nil
+		}
+
+		rel, err := filepath.Rel(nodeModules, path)
+		if err != nil {
+			return err
+		}
+		addons = append(addons, Addon{Package: packageName(rel), Path: rel})
+		return nil
+	})
+	return addons, err
+}
+
+// packageName returns the innermost package of a path relative to
+// node_modules, e.g. a/node_modules/@scope/b/build/b.node is @scope/b.
+func packageName(rel string) string {
+	parts := strings.Split(filepath.ToSlash(rel), "/")
+	name := ""
+	for i := 0; i < len(parts)-1; {
+		if strings.HasPrefix(parts[i], "@") && i+1 < len(parts)-1 {
+			name = parts[i] + "/" + parts[i+1]
+			i += 2
+		} else {
+			name = parts[i]
+			i++
+		}
+		if i >= len(parts)-1 || parts[i] != "node_modules" {
+			break
+		}
+		i++
+	}
+	return name
+}
+
+// Packages returns the sorted names of the packages containing addons.
+func Packages(addons []Addon) []string {
+	seen := map[string]bool{}
+	var names []string
+	for _, addon := range addons {
+		if !seen[addon.Package] {
+			seen[addon.Package] = true
+			names = append(names, addon.Package)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,13 @@
+package native_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestNative(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Native Suite")
+}
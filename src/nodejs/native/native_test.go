@@ -0,0 +1,98 @@
+package native_test
+
+import (
+	"io/ioutil"
+	"nodejs/native"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Native", func() {
+	Describe("VersionNeeds", func() {
+		It("reads the library and version of every need", func() {
+			needs, err := native.VersionNeeds("testdata/new.node")
+			Expect(err).To(BeNil())
+			Expect(needs).To(ConsistOf(
+				native.VersionNeed{Library: "libc.so.6", Version: "GLIBC_2.25"},
+				native.VersionNeed{Library: "libc.so.6", Version: "GLIBC_2.36"},
+			))
+		})
+
+		It("returns nothing for binaries without versioned dependencies", func() {
+			Expect(native.VersionNeeds("testdata/none.node")).To(BeEmpty())
+		})
+
+		It("rejects files which are not ELF binaries", func() {
+			_, err := native.VersionNeeds("testdata/script.node")
+			Expect(err).To(Equal(native.ErrNotELF))
+		})
+
+		It("returns other errors", func() {
+			_, err := native.VersionNeeds("testdata/missing.node")
+			Expect(os.IsNotExist(err)).To(BeTrue())
+		})
+	})
+
+	DescribeTable("RequiredGlibc",
+		func(path, expected string) {
+			Expect(native.RequiredGlibc(path)).To(Equal(expected))
+		},
+		Entry("old symbol versions", "testdata/old.node", "2.2.5"),
+		Entry("the newest of several versions", "testdata/new.node", "2.36"),
+		Entry("no glibc versions", "testdata/none.node", ""),
+	)
+
+	DescribeTable("CompareVersions",
+		func(a, b string, expected int) {
+			Expect(native.CompareVersions(a, b)).To(Equal(expected))
+		},
+		Entry("equal", "2.27", "2.27", 0),
+		Entry("numeric not lexical", "2.2.5", "2.17", -1),
+		Entry("newer minor", "2.28", "2.27", 1),
+		Entry("missing components are 0", "2.28.0", "2.28", 0),
+	)
+
+	Describe("FindAddons", func() {
+		var dir string
+
+		BeforeEach(func() {
+			var err error
+			dir, err = ioutil.TempDir("", "nodejs-buildpack.native.")
+			Expect(err).To(BeNil())
+
+			for _, path := range []string{
+				"bcrypt/lib/binding/bcrypt_lib.node",
+				"@img/sharp-linux-x64/lib/sharp.node",
+				"app/node_modules/@scope/leveldown/build/Release/leveldown.node",
+				"app/node_modules/@scope/leveldown/index.js",
+				".cache/ignored.node",
+			} {
+				Expect(os.MkdirAll(filepath.Join(dir, filepath.Dir(path)), 0755)).To(Succeed())
+				Expect(ioutil.WriteFile(filepath.Join(dir, path), []byte("x"), 0644)).To(Succeed())
+			}
+		})
+
+		AfterEach(func() {
+			Expect(os.RemoveAll(dir)).To(Succeed())
+		})
+
+		It("finds addons and the packages they belong to", func() {
+			addons, err := native.FindAddons(dir)
+			Expect(err).To(BeNil())
+			Expect(addons).To(ConsistOf(
+				native.Addon{Package: "bcrypt", Path: "bcrypt/lib/binding/bcrypt_lib.node"},
+				native.Addon{Package: "@img/sharp-linux-x64", Path: "@img/sharp-linux-x64/lib/sharp.node"},
+				native.Addon{Package: "@scope/leveldown", Path: "app/node_modules/@scope/leveldown/build/Release/leveldown.node"},
+			))
+			Expect(native.Packages(addons)).To(Equal([]string{"@img/sharp-linux-x64", "@scope/leveldown", "bcrypt"}))
+		})
+
+		It("returns nothing without node_modules", func() {
+			Expect(native.FindAddons(filepath.Join(dir, "missing"))).To(BeEmpty())
+		})
+	})
+})
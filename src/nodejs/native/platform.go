@@ -0,0 +1,256 @@
+package native
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// Platform is a node process.platform and process.arch pair, as used in the
+// os and cpu fields of package.json.
+type Platform struct {
+	OS  string
+	CPU string
+}
+
+func (p Platform) String() string {
+	return p.OS + "/" + p.CPU
+}
+
+var nodeArchs = map[string]string{
+	"386":     "ia32",
+	"amd64":   "x64",
+	"arm":     "arm",
+	"arm64":   "arm64",
+	"ppc64le": "ppc64",
+	"s390x":   "s390x",
+}
+
+// HostPlatform returns the platform the buildpack is running on.
+func HostPlatform() Platform {
+	cpu, found := nodeArchs[runtime.GOARCH]
+	if !found {
+		cpu = runtime.GOARCH
+	}
+	return Platform{OS: runtime.GOOS, CPU: cpu}
+}
+
+// Convention describes how a package names the optional dependencies which
+// carry its prebuilt binary for each platform.
+type Convention struct {
+	Name string
+	// Pattern matches the name of a platform package. {os} and {cpu} stand
+	// for the platform, {abi} for an optional libc or ABI suffix such as
+	// -gnu and {name} for the rest of the name.
+	Pattern string
+	// LinuxABI is the {abi} of the glibc linux package for a cpu, with the
+	// "" key as the default for all other cpus.
+	LinuxABI map[string]string
+}
+
+// Conventions are tried in order, the first one matching a package name wins.
+var Conventions = []Convention{
+	{Name: "esbuild", Pattern: "@esbuild/{os}-{cpu}"},
+	{Name: "sharp", Pattern: "@img/{name}-{os}-{cpu}"},
+	{Name: "swc", Pattern: "@swc/{name}-{os}-{cpu}{abi}", LinuxABI: map[string]string{"arm": "-gnueabihf", "": "-gnu"}},
+	{Name: "napi-rs", Pattern: "{name}-{os}-{cpu}{abi}", LinuxABI: map[string]string{"arm": "-gnueabihf", "": "-gnu"}},
+}
+
+const (
+	osPattern  = "aix|android|darwin|freebsd|linux|linuxmusl|netbsd|openbsd|sunos|win32"
+	cpuPattern = "arm|arm64|ia32|loong64|mips64el|ppc64|riscv64|s390x|x64|universal"
+	abiPattern = "-gnu|-gnueabihf|-musl|-musleabihf|-msvc|-eabi"
+)
+
+var placeholder = regexp.MustCompile(`\{(name|os|cpu|abi)\}`)
+
+// Sibling returns the name of the package following the convention which
+// carries the binary for platform, when name is a platform package of the
+// convention.
+func (c Convention) Sibling(name string, platform Platform) (string, bool) {
+	var fields []string
+	expr := "^"
+	last := 0
+	for _, loc := range placeholder.FindAllStringSubmatchIndex(c.Pattern, -1) {
+		expr += regexp.QuoteMeta(c.Pattern[last:loc[0]])
+		field := c.Pattern[loc[2]:loc[3]]
+		fields = append(fields, field)
+		switch field {
+		case "name":
+			expr += "(.+?)"
+		case "os":
+			expr += "(" + osPattern + ")"
+		case "cpu":
+			expr += "(" + cpuPattern + ")"
+		case "abi":
+			expr += "(" + abiPattern + ")?"
+		}
+		last = loc[1]
+	}
+	expr += regexp.QuoteMeta(c.Pattern[last:]) + "$"
+
+	match := regexp.MustCompile(expr).FindStringSubmatch(name)
+	if match == nil {
+		return "", false
+	}
+
+	values := map[string]string{"os": platform.OS, "cpu": platform.CPU}
+	for i, field := range fields {
+		if field == "name" {
+			values["name"] = match[i+1]
+		}
+	}
+	if platform.OS == "linux" {
+		if abi, found := c.LinuxABI[platform.CPU]; found {
+			values["abi"] = abi
+		} else {
+			values["abi"] = c.LinuxABI[""]
+		}
+	}
+
+	return placeholder.ReplaceAllStringFunc(c.Pattern, func(s string) string {
+		return values[s[1:len(s)-1]]
+	}), true
+}
+
+// Supports reports whether the os or cpu field of a package.json allows
+// value. Entries starting with ! exclude a value, any other entries form the
+// list of allowed values.
+func Supports(field []string, value string) bool {
+	allowed := true
+	for _, entry := range field {
+		if strings.HasPrefix(entry, "!") {
+			if entry[1:] == value {
+				return false
+			}
+			continue
+		}
+		if entry == value {
+			return true
+		}
+		allowed = false
+	}
+	return allowed
+}
+
+// MissingPlatformPackage is an installed platform package for another
+// platform whose sibling for the host platform is not installed.
+type MissingPlatformPackage struct {
+	Path       string
+	Package    string
+	Platform   Platform
+	Convention string
+	Missing    string
+}
+
+func (m MissingPlatformPackage) String() string {
+	return fmt.Sprintf("%s (%s) is built for %s, but %s is not installed", m.Package, m.Path, m.Platform, m.Missing)
+}
+
+// FindMissingPlatformPackages checks the installed packages which declare os
+// or cpu constraints. A package which does not support platform and is named
+// after one of the Conventions needs its sibling for platform to be
+// installed next to it or in the top level node_modules.
+func FindMissingPlatformPackages(nodeModules string, platform Platform) ([]MissingPlatformPackage, error) {
+	var missing []MissingPlatformPackage
+	if err := findMissingPlatformPackages(nodeModules, nodeModules, platform, &missing); err != nil {
+		return nil, err
+	}
+	sort.Slice(missing, func(i, j int) bool { return missing[i].Path < missing[j].Path })
+	return missing, nil
+}
+
+func findMissingPlatformPackages(dir, nodeModules string, platform Platform, missing *[]MissingPlatformPackage) error {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		if !entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		if strings.HasPrefix(entry.Name(), "@") {
+			if err := findMissingPlatformPackages(path, nodeModules, platform, missing); err != nil {
+				return err
+			}
+			continue
+		}
+
+		var pkg struct {
+			Name string   `json:"name"`
+			OS   []string `json:"os"`
+			CPU  []string `json:"cpu"`
+			Libc []string `json:"libc"`
+		}
+		if data, err := ioutil.ReadFile(filepath.Join(path, "package.json")); err == nil {
+			if json.Unmarshal(data, &pkg) == nil && !supportsPlatform(pkg.OS, pkg.CPU, pkg.Libc, platform) {
+				if m, found := checkSibling(path, nodeModules, pkg.Name, pkg.OS, pkg.CPU, platform); found {
+					*missing = append(*missing, m)
+				}
+			}
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+
+		if err := findMissingPlatformPackages(filepath.Join(path, "node_modules"), nodeModules, platform, missing); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// supportsPlatform checks the os, cpu and libc fields of a package.json.
+// The stacks are all glibc based.
+func supportsPlatform(osField, cpuField, libcField []string, platform Platform) bool {
+	if !Supports(osField, platform.OS) || !Supports(cpuField, platform.CPU) {
+		return false
+	}
+	return platform.OS != "linux" || Supports(libcField, "glibc")
+}
+
+func checkSibling(path, nodeModules, name string, osField, cpuField []string, platform Platform) (MissingPlatformPackage, bool) {
+	for _, convention := range Conventions {
+		sibling, matched := convention.Sibling(name, platform)
+		if !matched {
+			continue
+		}
+
+		dir := filepath.Dir(path)
+		if strings.HasPrefix(name, "@") {
+			dir = filepath.Dir(dir)
+		}
+		for _, candidate := range []string{filepath.Join(dir, sibling), filepath.Join(nodeModules, sibling)} {
+			if _, err := os.Stat(filepath.Join(candidate, "package.json")); err == nil {
+				return MissingPlatformPackage{}, false
+			}
+		}
+
+		rel, _ := filepath.Rel(filepath.Dir(nodeModules), path)
+		return MissingPlatformPackage{
+			Path:       filepath.ToSlash(rel),
+			Package:    name,
+			Platform:   Platform{OS: fieldString(osField), CPU: fieldString(cpuField)},
+			Convention: convention.Name,
+			Missing:    sibling,
+		}, true
+	}
+	return MissingPlatformPackage{}, false
+}
+
+func fieldString(field []string) string {
+	if len(field) == 0 {
+		return "any"
+	}
+	return strings.Join(field, ",")
+}
@@ -0,0 +1,114 @@
+package native_test
+
+import (
+	"io/ioutil"
+	"nodejs/native"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Platform packages", func() {
+	linuxX64 := native.Platform{OS: "linux", CPU: "x64"}
+
+	DescribeTable("Conventions",
+		func(name, convention, sibling string) {
+			for _, c := range native.Conventions {
+				if found, matched := c.Sibling(name, linuxX64); matched {
+					Expect(c.Name).To(Equal(convention))
+					Expect(found).To(Equal(sibling))
+					return
+				}
+			}
+			Expect(convention).To(Equal(""), "no convention matched %s", name)
+		},
+		Entry("esbuild", "@esbuild/darwin-arm64", "esbuild", "@esbuild/linux-x64"),
+		Entry("sharp", "@img/sharp-darwin-arm64", "sharp", "@img/sharp-linux-x64"),
+		Entry("sharp libvips", "@img/sharp-libvips-darwin-arm64", "sharp", "@img/sharp-libvips-linux-x64"),
+		Entry("sharp on musl", "@img/sharp-linuxmusl-x64", "sharp", "@img/sharp-linux-x64"),
+		Entry("swc", "@swc/core-darwin-arm64", "swc", "@swc/core-linux-x64-gnu"),
+		Entry("swc on windows", "@swc/core-win32-x64-msvc", "swc", "@swc/core-linux-x64-gnu"),
+		Entry("napi-rs", "@node-rs/bcrypt-darwin-x64", "napi-rs", "@node-rs/bcrypt-linux-x64-gnu"),
+		Entry("napi-rs on musl", "lightningcss-linux-x64-musl", "napi-rs", "lightningcss-linux-x64-gnu"),
+		Entry("napi-rs with a dashed name", "@rollup/rollup-darwin-arm64", "napi-rs", "@rollup/rollup-linux-x64-gnu"),
+		Entry("other packages", "fsevents", "", ""),
+		Entry("names with a platform but no cpu", "node-linux", "", ""),
+	)
+
+	It("uses the ABI of 32 bit arm", func() {
+		sibling, matched := native.Conventions[3].Sibling("lightningcss-darwin-x64", native.Platform{OS: "linux", CPU: "arm"})
+		Expect(matched).To(BeTrue())
+		Expect(sibling).To(Equal("lightningcss-linux-arm-gnueabihf"))
+	})
+
+	DescribeTable("Supports",
+		func(field []string, value string, expected bool) {
+			Expect(native.Supports(field, value)).To(Equal(expected))
+		},
+		Entry("no constraint", nil, "linux", true),
+		Entry("allowed", []string{"darwin", "linux"}, "linux", true),
+		Entry("not allowed", []string{"darwin"}, "linux", false),
+		Entry("excluded", []string{"!linux"}, "linux", false),
+		Entry("not excluded", []string{"!win32"}, "linux", true),
+	)
+
+	Describe("FindMissingPlatformPackages", func() {
+		var dir string
+
+		install := func(path, manifest string) {
+			Expect(os.MkdirAll(filepath.Join(dir, path), 0755)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(dir, path, "package.json"), []byte(manifest), 0644)).To(Succeed())
+		}
+
+		BeforeEach(func() {
+			var err error
+			dir, err = ioutil.TempDir("", "nodejs-buildpack.platform.")
+			Expect(err).To(BeNil())
+			dir = filepath.Join(dir, "node_modules")
+		})
+
+		AfterEach(func() {
+			Expect(os.RemoveAll(filepath.Dir(dir))).To(Succeed())
+		})
+
+		It("accepts packages for the platform", func() {
+			install("@esbuild/linux-x64", `{"name":"@esbuild/linux-x64","os":["linux"],"cpu":["x64"]}`)
+			Expect(native.FindMissingPlatformPackages(dir, linuxX64)).To(BeEmpty())
+		})
+
+		It("accepts other platforms when the sibling is installed", func() {
+			install("@esbuild/darwin-arm64", `{"name":"@esbuild/darwin-arm64","os":["darwin"],"cpu":["arm64"]}`)
+			install("@esbuild/linux-x64", `{"name":"@esbuild/linux-x64","os":["linux"],"cpu":["x64"]}`)
+			install("vite/node_modules/@swc/core-darwin-arm64", `{"name":"@swc/core-darwin-arm64","os":["darwin"],"cpu":["arm64"]}`)
+			install("@swc/core-linux-x64-gnu", `{"name":"@swc/core-linux-x64-gnu","os":["linux"],"cpu":["x64"],"libc":["glibc"]}`)
+			Expect(native.FindMissingPlatformPackages(dir, linuxX64)).To(BeEmpty())
+		})
+
+		It("ignores constrained packages without a convention", func() {
+			install("fsevents", `{"name":"fsevents","os":["darwin"]}`)
+			Expect(native.FindMissingPlatformPackages(dir, linuxX64)).To(BeEmpty())
+		})
+
+		It("reports missing siblings", func() {
+			install("@esbuild/darwin-arm64", `{"name":"@esbuild/darwin-arm64","os":["darwin"],"cpu":["arm64"]}`)
+			install("app/node_modules/@img/sharp-darwin-arm64", `{"name":"@img/sharp-darwin-arm64","os":["darwin"],"cpu":["arm64"]}`)
+			install("lightningcss-linux-x64-musl", `{"name":"lightningcss-linux-x64-musl","os":["linux"],"cpu":["x64"],"libc":["musl"]}`)
+
+			missing, err := native.FindMissingPlatformPackages(dir, linuxX64)
+			Expect(err).To(BeNil())
+			Expect(missing).To(Equal([]native.MissingPlatformPackage{
+				{Path: "node_modules/@esbuild/darwin-arm64", Package: "@esbuild/darwin-arm64", Platform: native.Platform{OS: "darwin", CPU: "arm64"}, Convention: "esbuild", Missing: "@esbuild/linux-x64"},
+				{Path: "node_modules/app/node_modules/@img/sharp-darwin-arm64", Package: "@img/sharp-darwin-arm64", Platform: native.Platform{OS: "darwin", CPU: "arm64"}, Convention: "sharp", Missing: "@img/sharp-linux-x64"},
+				{Path: "node_modules/lightningcss-linux-x64-musl", Package: "lightningcss-linux-x64-musl", Platform: native.Platform{OS: "linux", CPU: "x64"}, Convention: "napi-rs", Missing: "lightningcss-linux-x64-gnu"},
+			}))
+			Expect(missing[0].String()).To(Equal("@esbuild/darwin-arm64 (node_modules/@esbuild/darwin-arm64) is built for darwin/arm64, but @esbuild/linux-x64 is not installed"))
+		})
+
+		It("returns nothing without node_modules", func() {
+			Expect(native.FindMissingPlatformPackages(filepath.Join(dir, "missing"), linuxX64)).To(BeEmpty())
+		})
+	})
+})
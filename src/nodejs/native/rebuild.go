@@ -0,0 +1,135 @@
+package native
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// installScripts are the lifecycle scripts npm rebuild runs, besides the
+// node-gyp build it runs for a binding.gyp.
+var installScripts = []string{"preinstall", "install", "postinstall"}
+
+// FindRebuildPackages returns the sorted names of the packages below
+// nodeModules which npm rebuild does anything for: those with a binding.gyp,
+// "gypfile": true, a prebuilds dir, compiled .node addons or install
+// scripts, which may download or select binaries. Rebuilding the other
+// packages only relinks their bins.
+func FindRebuildPackages(nodeModules string) ([]string, error) {
+	names := map[string]bool{}
+	err := filepath.Walk(nodeModules, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if path == nodeModules {
+			return nil
+		}
+		if info.IsDir() && strings.HasPrefix(info.Name(), ".") {
+			return filepath.SkipDir
+		}
+
+		rel, err := filepath.Rel(nodeModules, path)
+		if err != nil {
+			return err
+		}
+		name := packageName(rel)
+		atRoot := filepath.ToSlash(filepath.Dir(rel)) == packageDir(rel)
+		switch {
+		case info.IsDir() && info.Name() == "prebuilds" && atRoot:
+			names[name] = true
+			return filepath.SkipDir
+		case !info.Mode().IsRegular():
+		case info.Name() == "binding.gyp" && atRoot:
+			names[name] = true
+		case filepath.Ext(path) == ".node":
+			names[name] = true
+		case info.Name() == "package.json" && atRoot:
+			if rebuilds, err := rebuildsPackage(path); err != nil {
+				return err
+			} else if rebuilds {
+				names[name] = true
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var packages []string
+	for name := range names {
+		if name != "" {
+			packages = append(packages, name)
+		}
+	}
+	sort.Strings(packages)
+	return packages, nil
+}
+
+// packageDir returns the dir of the innermost package of a path relative to
+// node_modules, e.g. a/node_modules/@scope/b for a/node_modules/@scope/b/x.
+func packageDir(rel string) string {
+	parts := strings.Split(filepath.ToSlash(rel), "/")
+	end := 0
+	for i := 0; i < len(parts)-1; {
+		if strings.HasPrefix(parts[i], "@") && i+1 < len(parts)-1 {
+			i += 2
+		} else {
+			i++
+		}
+		end = i
+		if i >= len(parts)-1 || parts[i] != "node_modules" {
+			break
+		}
+		i++
+	}
+	return strings.Join(parts[:end], "/")
+}
+
+// rebuildsPackage reports whether the package.json at path has
+// "gypfile": true or install scripts. A package.json which doesn't parse
+// counts, so that a scoped rebuild never misses a package.
+func rebuildsPackage(path string) (bool, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return false, err
+	}
+	var pkg struct {
+		Gypfile bool              `json:"gypfile"`
+		Scripts map[string]string `json:"scripts"`
+	}
+	if err := json.Unmarshal(contents, &pkg); err != nil {
+		return true, nil
+	}
+	if pkg.Gypfile {
+		return true, nil
+	}
+	for _, script := range installScripts {
+		if strings.TrimSpace(pkg.Scripts[script]) != "" {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+var moduleVersionDefine = regexp.MustCompile(`#define NODE_MODULE_VERSION (\d+)`)
+
+// ModuleVersion returns the ABI version of the node installed in nodeDir,
+// which compiled addons are bound to, or "" when it is unknown.
+func ModuleVersion(nodeDir string) string {
+	contents, err := ioutil.ReadFile(filepath.Join(nodeDir, "include", "node", "node_version.h"))
+	if err != nil {
+		return ""
+	}
+	if m := moduleVersionDefine.FindStringSubmatch(string(contents)); m != nil {
+		return m[1]
+	}
+	return ""
+}
@@ -0,0 +1,52 @@
+package native_test
+
+import (
+	"io/ioutil"
+	"nodejs/native"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Rebuild", func() {
+	Describe("FindRebuildPackages", func() {
+		It("finds the packages with native code or install scripts", func() {
+			Expect(native.FindRebuildPackages(filepath.Join("testdata", "rebuild", "node_modules"))).To(Equal([]string{
+				"@serialport/bindings-cpp",
+				"bcrypt",
+				"esbuild",
+				"microtime",
+				"sharp",
+			}))
+		})
+
+		It("returns nothing without node_modules", func() {
+			Expect(native.FindRebuildPackages(filepath.Join("testdata", "missing"))).To(BeEmpty())
+		})
+
+		It("rebuilds packages whose package.json doesn't parse", func() {
+			dir, err := ioutil.TempDir("", "nodejs-buildpack.native.")
+			Expect(err).To(BeNil())
+			defer os.RemoveAll(dir)
+			Expect(os.MkdirAll(filepath.Join(dir, "broken"), 0755)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(dir, "broken", "package.json"), []byte("{"), 0644)).To(Succeed())
+
+			Expect(native.FindRebuildPackages(dir)).To(Equal([]string{"broken"}))
+		})
+	})
+
+	Describe("ModuleVersion", func() {
+		It("reads the ABI version from the node headers", func() {
+			dir, err := ioutil.TempDir("", "nodejs-buildpack.native.")
+			Expect(err).To(BeNil())
+			defer os.RemoveAll(dir)
+			Expect(native.ModuleVersion(dir)).To(Equal(""))
+
+			Expect(os.MkdirAll(filepath.Join(dir, "include", "node"), 0755)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(dir, "include", "node", "node_version.h"), []byte("#define NODE_MAJOR_VERSION 20\n#define NODE_MODULE_VERSION 115\n"), 0644)).To(Succeed())
+			Expect(native.ModuleVersion(dir)).To(Equal("115"))
+		})
+	})
+})
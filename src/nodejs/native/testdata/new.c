@@ -0,0 +1,4 @@
+#include <string.h>
+#include <stdlib.h>
+#include <sys/random.h>
+int addon(char *d, const char *s) { memcpy(d, s, 4); getrandom(d, 1, 0); return (int)arc4random(); }
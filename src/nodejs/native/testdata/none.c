@@ -0,0 +1 @@
+int addon(int a) { return a + 1; }
@@ -0,0 +1,3 @@
+#include <string.h>
+__asm__(".symver memcpy,memcpy@GLIBC_2.2.5");
+int addon(char *d, const char *s) { memcpy(d, s, strlen(s)); return 0; }
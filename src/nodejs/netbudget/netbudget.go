@@ -0,0 +1,241 @@
+// Package netbudget shares a wall-clock budget between the optional features
+// of staging which use the network, like the outdated report, npm audit and
+// the lookup of the latest agent versions, so that on a degraded network
+// together they add BP_OPTIONAL_NETWORK_BUDGET to staging at most. After two
+// failures in a row the breaker opens, and the remaining features are
+// skipped.
+package netbudget
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"nodejs/duration"
+	"nodejs/offline"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	// DefaultTotal is the budget unless BP_OPTIONAL_NETWORK_BUDGET says
+	// otherwise.
+	DefaultTotal = 30 * time.Second
+
+	// StateFile keeps the budget in the dep dir from supply to finalize.
+	StateFile = "network_budget.json"
+
+	// breakerFailures is how many failures in a row open the breaker.
+	breakerFailures = 2
+)
+
+// Clock tells the time the calls take.
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+// Status is how an optional feature went.
+type Status string
+
+const (
+	Ran     Status = "ran"
+	Skipped Status = "skipped"
+	Failed  Status = "failed"
+	// Unmeasured is a feature which runs as part of another step, so that
+	// its time can't be told apart.
+	Unmeasured Status = "unmeasured"
+)
+
+// Outcome is how an optional feature went, and why when it did not run.
+type Outcome struct {
+	Feature string        `json:"feature"`
+	Status  Status        `json:"status"`
+	Spent   time.Duration `json:"spent"`
+	Reason  string        `json:"reason,omitempty"`
+}
+
+func (o Outcome) String() string {
+	switch o.Status {
+	case Ran:
+		return fmt.Sprintf("%s: ran in %s", o.Feature, round(o.Spent))
+	case Failed:
+		return fmt.Sprintf("%s: failed after %s, %s", o.Feature, round(o.Spent), o.Reason)
+	case Unmeasured:
+		return fmt.Sprintf("%s: not measured, %s", o.Feature, o.Reason)
+	default:
+		return fmt.Sprintf("%s: %s, %s", o.Feature, o.Status, o.Reason)
+	}
+}
+
+func round(d time.Duration) time.Duration {
+	return (d + 50*time.Millisecond) / (100 * time.Millisecond) * (100 * time.Millisecond)
+}
+
+// Budget is the time left to the optional network features of a staging.
+type Budget struct {
+	Total time.Duration
+	// Offline skips every feature, staging has no network to spend the
+	// budget on.
+	Offline bool
+
+	path  string
+	clock Clock
+	state state
+}
+
+type state struct {
+	Spent    time.Duration `json:"spent"`
+	Failures int           `json:"failures"`
+	Outcomes []Outcome     `json:"outcomes"`
+}
+
+// New returns a budget of total, whose calls are timed by clock.
+func New(total time.Duration, clock Clock) *Budget {
+	return &Budget{Total: total, clock: clock}
+}
+
+// Load returns the budget saved at path, or a new one when there is none.
+// Save saves it there again.
+func Load(path string, total time.Duration, clock Clock) (*Budget, error) {
+	b := New(total, clock)
+	b.path = path
+	contents, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return b, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(contents, &b.state); err != nil {
+		return nil, fmt.Errorf("unable to read the network budget %s: %s", path, err)
+	}
+	return b, nil
+}
+
+// Staging returns the budget of the staging whose dep dir is depDir, of
+// BP_OPTIONAL_NETWORK_BUDGET, offline when the staging is.
+func Staging(depDir string) (*Budget, error) {
+	total, err := Total(os.Getenv("BP_OPTIONAL_NETWORK_BUDGET"))
+	if err != nil {
+		return nil, err
+	}
+	b, err := Load(filepath.Join(depDir, StateFile), total, systemClock{})
+	if err != nil {
+		return nil, err
+	}
+	b.Offline = offline.Enabled()
+	return b, nil
+}
+
+// Finish returns the outcomes of the staging whose dep dir is depDir, and
+// removes its budget, which the droplet has no use for.
+func Finish(depDir string) ([]Outcome, error) {
+	path := filepath.Join(depDir, StateFile)
+	b, err := Load(path, DefaultTotal, systemClock{})
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return b.Outcomes(), nil
+}
+
+// Total parses BP_OPTIONAL_NETWORK_BUDGET, a duration like 30s or a number
+// of seconds. Zero turns the optional network features off.
+func Total(value string) (time.Duration, error) {
+	if value == "" {
+		return DefaultTotal, nil
+	}
+	if d, err := duration.Parse(value); err == nil {
+		return d, nil
+	}
+	return 0, fmt.Errorf("invalid BP_OPTIONAL_NETWORK_BUDGET %q, expected a duration like 30s", value)
+}
+
+// Save saves the budget where Load loaded it from.
+func (b *Budget) Save() error {
+	if b.path == "" {
+		return nil
+	}
+	contents, err := json.Marshal(b.state)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(b.path, contents, 0644)
+}
+
+// Remaining is the time left in the budget.
+func (b *Budget) Remaining() time.Duration {
+	if b.state.Spent >= b.Total {
+		return 0
+	}
+	return b.Total - b.state.Spent
+}
+
+// Open reports whether the breaker is open, after failures in a row.
+func (b *Budget) Open() bool {
+	return b.state.Failures >= breakerFailures
+}
+
+// Outcomes returns the outcomes of the features, in the order they started.
+func (b *Budget) Outcomes() []Outcome {
+	return b.state.Outcomes
+}
+
+// Call is a feature running on the budget.
+type Call struct {
+	// Remaining is how long the feature may take.
+	Remaining time.Duration
+
+	budget  *Budget
+	feature string
+	started time.Time
+}
+
+// Start starts feature, which has to end with Done. When staging is offline,
+// the breaker is open or the budget is exhausted, feature is skipped instead, and the error
+// tells why.
+func (b *Budget) Start(feature string) (*Call, error) {
+	var reason string
+	switch {
+	case b.Offline:
+		reason = "staging is offline (BP_OFFLINE)"
+	case b.Open():
+		reason = fmt.Sprintf("the previous %d optional network calls failed", b.state.Failures)
+	case b.Total == 0:
+		reason = "BP_OPTIONAL_NETWORK_BUDGET is 0"
+	case b.Remaining() == 0:
+		reason = fmt.Sprintf("the network budget of %s is exhausted", b.Total)
+	}
+	if reason != "" {
+		b.state.Outcomes = append(b.state.Outcomes, Outcome{Feature: feature, Status: Skipped, Reason: reason})
+		return nil, fmt.Errorf("%s", reason)
+	}
+	return &Call{Remaining: b.Remaining(), budget: b, feature: feature, started: b.clock.Now()}, nil
+}
+
+// Done charges the time of the call to the budget. A failure counts towards
+// opening the breaker, a success closes it again.
+func (c *Call) Done(err error) {
+	b := c.budget
+	outcome := Outcome{Feature: c.feature, Status: Ran, Spent: b.clock.Now().Sub(c.started)}
+	b.state.Spent += outcome.Spent
+	if err != nil {
+		outcome.Status, outcome.Reason = Failed, err.Error()
+		b.state.Failures++
+	} else {
+		b.state.Failures = 0
+	}
+	b.state.Outcomes = append(b.state.Outcomes, outcome)
+}
+
+// Unmeasured ends a call whose time is spent in another step, which is not
+// charged to the budget, and leaves the breaker as it is. reason tells where
+// the time went.
+func (c *Call) Unmeasured(reason string) {
+	c.budget.state.Outcomes = append(c.budget.state.Outcomes, Outcome{Feature: c.feature, Status: Unmeasured, Reason: reason})
+}
@@ -0,0 +1,13 @@
+package netbudget_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestNetbudget(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Netbudget Suite")
+}
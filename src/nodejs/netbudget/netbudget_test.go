@@ -0,0 +1,197 @@
+package netbudget_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"nodejs/netbudget"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+func (c *fakeClock) Advance(d time.Duration) { c.now = c.now.Add(d) }
+
+var _ = Describe("Budget", func() {
+	var (
+		clock  *fakeClock
+		budget *netbudget.Budget
+	)
+
+	run := func(feature string, took time.Duration, err error) {
+		call, startErr := budget.Start(feature)
+		Expect(startErr).To(BeNil())
+		clock.Advance(took)
+		call.Done(err)
+	}
+
+	BeforeEach(func() {
+		clock = &fakeClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+		budget = netbudget.New(30*time.Second, clock)
+	})
+
+	It("charges the time of each call to the budget", func() {
+		call, err := budget.Start("npm audit")
+		Expect(err).To(BeNil())
+		Expect(call.Remaining).To(Equal(30 * time.Second))
+		clock.Advance(12 * time.Second)
+		call.Done(nil)
+
+		call, err = budget.Start("outdated report")
+		Expect(err).To(BeNil())
+		Expect(call.Remaining).To(Equal(18 * time.Second))
+		clock.Advance(2 * time.Second)
+		call.Done(nil)
+
+		Expect(budget.Remaining()).To(Equal(16 * time.Second))
+		Expect(budget.Outcomes()).To(Equal([]netbudget.Outcome{
+			{Feature: "npm audit", Status: netbudget.Ran, Spent: 12 * time.Second},
+			{Feature: "outdated report", Status: netbudget.Ran, Spent: 2 * time.Second},
+		}))
+	})
+
+	It("skips the features once the budget is exhausted", func() {
+		run("outdated report", 31*time.Second, nil)
+
+		_, err := budget.Start("dynatrace agent version")
+		Expect(err).To(MatchError("the network budget of 30s is exhausted"))
+		Expect(budget.Remaining()).To(BeZero())
+		Expect(budget.Outcomes()[1]).To(Equal(netbudget.Outcome{Feature: "dynatrace agent version", Status: netbudget.Skipped, Reason: "the network budget of 30s is exhausted"}))
+	})
+
+	It("opens the breaker after two failures in a row", func() {
+		run("npm audit", time.Second, errors.New("ENOTFOUND"))
+		Expect(budget.Open()).To(BeFalse())
+		run("outdated report", time.Second, errors.New("no dependency could be looked up in the registry"))
+		Expect(budget.Open()).To(BeTrue())
+
+		_, err := budget.Start("dynatrace agent version")
+		Expect(err).To(MatchError("the previous 2 optional network calls failed"))
+		Expect(budget.Remaining()).To(Equal(28 * time.Second))
+	})
+
+	It("closes the breaker after a success", func() {
+		run("npm audit", time.Second, errors.New("ENOTFOUND"))
+		run("outdated report", time.Second, nil)
+		run("dynatrace agent version", time.Second, errors.New("timeout"))
+
+		Expect(budget.Open()).To(BeFalse())
+	})
+
+	It("skips every feature when the budget is 0", func() {
+		budget = netbudget.New(0, clock)
+		_, err := budget.Start("npm audit")
+		Expect(err).To(MatchError("BP_OPTIONAL_NETWORK_BUDGET is 0"))
+	})
+
+	It("skips every feature when staging is offline", func() {
+		budget.Offline = true
+		_, err := budget.Start("outdated report")
+		Expect(err).To(MatchError("staging is offline (BP_OFFLINE)"))
+		Expect(budget.Outcomes()).To(Equal([]netbudget.Outcome{{Feature: "outdated report", Status: netbudget.Skipped, Reason: "staging is offline (BP_OFFLINE)"}}))
+	})
+
+	It("charges nothing for an unmeasured call and leaves the breaker as it is", func() {
+		run("outdated report", time.Second, errors.New("timeout"))
+		call, err := budget.Start("npm audit")
+		Expect(err).To(BeNil())
+		clock.Advance(10 * time.Second)
+		call.Unmeasured("it runs as part of npm install")
+		run("dynatrace agent version", time.Second, errors.New("timeout"))
+
+		Expect(budget.Remaining()).To(Equal(28 * time.Second))
+		Expect(budget.Open()).To(BeTrue())
+		Expect(budget.Outcomes()[1].String()).To(Equal("npm audit: not measured, it runs as part of npm install"))
+	})
+
+	It("describes the outcomes", func() {
+		run("npm audit", 1234*time.Millisecond, nil)
+		run("outdated report", 5*time.Second, errors.New("no dependency could be looked up in the registry"))
+		budget.Total = 0
+		budget.Start("dynatrace agent version")
+
+		var lines []string
+		for _, outcome := range budget.Outcomes() {
+			lines = append(lines, outcome.String())
+		}
+		Expect(lines).To(Equal([]string{
+			"npm audit: ran in 1.2s",
+			"outdated report: failed after 5s, no dependency could be looked up in the registry",
+			"dynatrace agent version: skipped, BP_OPTIONAL_NETWORK_BUDGET is 0",
+		}))
+	})
+
+	Describe("Load and Save", func() {
+		var depDir string
+
+		BeforeEach(func() {
+			var err error
+			depDir, err = ioutil.TempDir("", "nodejs-buildpack.netbudget.")
+			Expect(err).To(BeNil())
+		})
+
+		AfterEach(func() {
+			Expect(os.RemoveAll(depDir)).To(Succeed())
+		})
+
+		It("carries the budget and the breaker from supply to finalize", func() {
+			path := filepath.Join(depDir, netbudget.StateFile)
+			var err error
+			budget, err = netbudget.Load(path, 30*time.Second, clock)
+			Expect(err).To(BeNil())
+			run("npm audit", 20*time.Second, errors.New("ENOTFOUND"))
+			Expect(budget.Save()).To(Succeed())
+
+			budget, err = netbudget.Load(path, 30*time.Second, clock)
+			Expect(err).To(BeNil())
+			Expect(budget.Remaining()).To(Equal(10 * time.Second))
+			run("outdated report", time.Second, errors.New("timeout"))
+			Expect(budget.Open()).To(BeTrue())
+			Expect(budget.Save()).To(Succeed())
+
+			outcomes, err := netbudget.Finish(depDir)
+			Expect(err).To(BeNil())
+			Expect(outcomes).To(HaveLen(2))
+			Expect(path).NotTo(BeAnExistingFile())
+		})
+
+		It("is offline in an offline staging", func() {
+			oldEnv := os.Getenv("BP_OFFLINE")
+			defer os.Setenv("BP_OFFLINE", oldEnv)
+			os.Setenv("BP_OFFLINE", "true")
+
+			var err error
+			budget, err = netbudget.Staging(depDir)
+			Expect(err).To(BeNil())
+			Expect(budget.Offline).To(BeTrue())
+		})
+
+		It("finishes a staging without optional network features", func() {
+			Expect(netbudget.Finish(depDir)).To(BeEmpty())
+		})
+	})
+
+	DescribeTable("Total",
+		func(value string, expected time.Duration) {
+			Expect(netbudget.Total(value)).To(Equal(expected))
+		},
+		Entry("default", "", 30*time.Second),
+		Entry("seconds", "45", 45*time.Second),
+		Entry("duration", "1m", time.Minute),
+		Entry("off", "0", time.Duration(0)),
+	)
+
+	It("rejects an invalid total", func() {
+		_, err := netbudget.Total("-5s")
+		Expect(err).To(MatchError(`invalid BP_OPTIONAL_NETWORK_BUDGET "-5s", expected a duration like 30s`))
+	})
+})
@@ -0,0 +1,117 @@
+package network
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// DefaultDownloadAttempts is how many requests a download makes at most,
+// unless the Downloader says otherwise.
+const DefaultDownloadAttempts = 4
+
+// Downloader downloads large files, like node and the APM agents, over links
+// which break off. A download which fails midway resumes with a range
+// request from the bytes it received, while the server accepts ranges and
+// the ETag or Last-Modified of the file still match, and starts over
+// otherwise.
+type Downloader struct {
+	Client *http.Client
+	// Attempts is how many requests a download makes at most,
+	// DefaultDownloadAttempts when 0.
+	Attempts int
+	// RetryDelay is how long to wait before each retry.
+	RetryDelay time.Duration
+}
+
+// Download writes the file at url to path and returns its sha256, hashed as
+// the bytes arrive so that it covers the parts of every resumed request.
+func (d Downloader) Download(url, path string) (string, error) {
+	out, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	defer out.Close()
+
+	attempts := d.Attempts
+	if attempts == 0 {
+		attempts = DefaultDownloadAttempts
+	}
+	hash := sha256.New()
+	var received int64
+	// validator is what If-Range matches the file against on a resume, ""
+	// when the file can't be resumed.
+	validator := ""
+
+	for attempt := 1; ; attempt++ {
+		req, err := http.NewRequest("GET", url, nil)
+		if err != nil {
+			return "", err
+		}
+		resuming := received > 0 && validator != ""
+		if resuming {
+			req.Header.Set("Range", fmt.Sprintf("bytes=%d-", received))
+			req.Header.Set("If-Range", validator)
+		}
+
+		resp, err := d.Client.Do(req)
+		if err == nil {
+			switch {
+			case resuming && resp.StatusCode == http.StatusPartialContent && strings.HasPrefix(resp.Header.Get("Content-Range"), fmt.Sprintf("bytes %d-", received)):
+			case resp.StatusCode == http.StatusOK:
+				// The first request, or the file changed since.
+				if _, err := out.Seek(0, io.SeekStart); err != nil {
+					resp.Body.Close()
+					return "", err
+				}
+				if err := out.Truncate(0); err != nil {
+					resp.Body.Close()
+					return "", err
+				}
+				hash.Reset()
+				received = 0
+				validator = rangeValidator(resp.Header)
+			case resuming && resp.StatusCode == http.StatusPartialContent:
+				resp.Body.Close()
+				validator = ""
+				err = errors.New("Download resumed at the wrong offset, " + resp.Header.Get("Content-Range"))
+			default:
+				resp.Body.Close()
+				return "", errors.New("Download returned with status " + resp.Status)
+			}
+		}
+		if err == nil {
+			var n int64
+			n, err = io.Copy(io.MultiWriter(out, hash), resp.Body)
+			received += n
+			resp.Body.Close()
+			if err == nil {
+				return hex.EncodeToString(hash.Sum(nil)), nil
+			}
+		}
+
+		if attempt >= attempts {
+			return "", fmt.Errorf("Download failed after %d attempts: %s", attempts, err)
+		}
+		time.Sleep(d.RetryDelay)
+	}
+}
+
+// rangeValidator returns the validator of a response to resume it with, the
+// ETag unless it is weak, which If-Range does not accept, or else
+// Last-Modified, or "" when the server does not accept ranges.
+func rangeValidator(header http.Header) string {
+	if header.Get("Accept-Ranges") != "bytes" {
+		return ""
+	}
+	if etag := header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		return etag
+	}
+	return header.Get("Last-Modified")
+}
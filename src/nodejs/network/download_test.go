@@ -0,0 +1,149 @@
+package network_test
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"nodejs/network"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// breakingWriter drops the connection after writing n bytes of the body.
+type breakingWriter struct {
+	http.ResponseWriter
+	n int
+}
+
+func (w *breakingWriter) Write(p []byte) (int, error) {
+	if len(p) <= w.n {
+		w.n -= len(p)
+		return w.ResponseWriter.Write(p)
+	}
+	written, _ := w.ResponseWriter.Write(p[:w.n])
+	w.ResponseWriter.(http.Flusher).Flush()
+	conn, _, err := w.ResponseWriter.(http.Hijacker).Hijack()
+	if err == nil {
+		conn.Close()
+	}
+	return written, errors.New("connection dropped")
+}
+
+var _ = Describe("Downloader", func() {
+	var (
+		server   *httptest.Server
+		dir      string
+		path     string
+		mu       sync.Mutex
+		content  string
+		etag     string
+		ranges   bool
+		changes  bool
+		breaks   []int
+		requests []string
+	)
+
+	checksum := func(s string) string {
+		sum := sha256.Sum256([]byte(s))
+		return hex.EncodeToString(sum[:])
+	}
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "nodejs-buildpack.download.")
+		Expect(err).To(BeNil())
+		path = filepath.Join(dir, "node.tgz")
+
+		content = strings.Repeat("0123456789", 1000)
+		etag = `"v1"`
+		ranges = true
+		changes = false
+		breaks = nil
+		requests = nil
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			requests = append(requests, r.Header.Get("Range"))
+			body, tag := content, etag
+			if changes {
+				content, etag = strings.Repeat("abcdefghij", 900), `"v2"`
+			}
+			if len(breaks) > 0 {
+				w = &breakingWriter{ResponseWriter: w, n: breaks[0]}
+				breaks = breaks[1:]
+			}
+			mu.Unlock()
+
+			if r.URL.Path != "/node.tgz" {
+				http.NotFound(w, r)
+				return
+			}
+			if !ranges {
+				w.Write([]byte(body))
+				return
+			}
+			w.Header().Set("ETag", tag)
+			http.ServeContent(w, r, "node.tgz", time.Time{}, strings.NewReader(body))
+		}))
+	})
+
+	AfterEach(func() {
+		server.Close()
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	download := func() (string, error) {
+		return network.Downloader{Client: network.NewClient(0)}.Download(server.URL+"/node.tgz", path)
+	}
+
+	It("downloads the file and its checksum", func() {
+		Expect(download()).To(Equal(checksum(content)))
+		Expect(ioutil.ReadFile(path)).To(Equal([]byte(content)))
+		Expect(requests).To(Equal([]string{""}))
+	})
+
+	It("resumes a download which breaks off from the bytes it received", func() {
+		breaks = []int{4000, 3000}
+		Expect(download()).To(Equal(checksum(content)))
+		Expect(ioutil.ReadFile(path)).To(Equal([]byte(content)))
+		Expect(requests).To(Equal([]string{"", "bytes=4000-", "bytes=7000-"}))
+	})
+
+	It("starts over when the file changed since", func() {
+		breaks = []int{4000}
+		changes = true
+
+		Expect(download()).To(Equal(checksum(strings.Repeat("abcdefghij", 900))))
+		Expect(ioutil.ReadFile(path)).To(Equal([]byte(strings.Repeat("abcdefghij", 900))))
+		Expect(requests).To(Equal([]string{"", "bytes=4000-"}))
+	})
+
+	It("starts over when the server does not accept ranges", func() {
+		ranges = false
+		breaks = []int{4000}
+		Expect(download()).To(Equal(checksum(content)))
+		Expect(ioutil.ReadFile(path)).To(Equal([]byte(content)))
+		Expect(requests).To(Equal([]string{"", ""}))
+	})
+
+	It("gives up after the attempts", func() {
+		breaks = []int{1000, 1000, 1000}
+		_, err := network.Downloader{Client: network.NewClient(0), Attempts: 3}.Download(server.URL+"/node.tgz", path)
+		Expect(err).To(MatchError(HavePrefix("Download failed after 3 attempts: ")))
+		Expect(requests).To(HaveLen(3))
+	})
+
+	It("does not retry when the server refuses the file", func() {
+		_, err := network.Downloader{Client: network.NewClient(0)}.Download(server.URL+"/missing.tgz", path)
+		Expect(err).To(MatchError("Download returned with status 404 Not Found"))
+		Expect(requests).To(HaveLen(1))
+	})
+})
@@ -0,0 +1,88 @@
+package network
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// fallbackDelay is how long a dial waits for the first address family
+// before racing the other one (RFC 6555), as the default transport does.
+const fallbackDelay = 300 * time.Millisecond
+
+// Client is shared by the buildpack for its downloads and registry
+// requests.
+var Client = NewClient(0)
+
+// NewTransport dials both address families, so that hosts resolving to
+// IPv6 and IPv4 addresses connect on IPv6-only and IPv4-only networks alike.
+func NewTransport() *http.Transport {
+	dialer := &net.Dialer{
+		Timeout:       30 * time.Second,
+		KeepAlive:     30 * time.Second,
+		DualStack:     true,
+		FallbackDelay: fallbackDelay,
+	}
+	return &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dialer.DialContext,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+}
+
+// NewClient returns a client on NewTransport. A zero timeout never times out.
+func NewClient(timeout time.Duration) *http.Client {
+	return &http.Client{Transport: NewTransport(), Timeout: timeout}
+}
+
+// ParseURL parses a URL from the settings of an app, like a mirror or a
+// registry. IPv6 literals have to be in brackets, http://[fd00::1]:8080/,
+// as the port can't be told apart from the address otherwise.
+func ParseURL(raw string) (*url.URL, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	if strings.Count(u.Host, ":") > 1 && !strings.HasPrefix(u.Host, "[") {
+		return nil, errors.New("IPv6 addresses need brackets, like http://[fd00::1]:8080/")
+	}
+	if strings.HasPrefix(u.Host, "[") && net.ParseIP(strings.SplitN(u.Hostname(), "%", 2)[0]) == nil {
+		return nil, fmt.Errorf("invalid IPv6 address %s", u.Hostname())
+	}
+	return u, nil
+}
+
+// IPv6Only tells whether addrs, the addresses of the interfaces of the
+// container, only reach other hosts over IPv6: there is a global IPv6
+// address and no IPv4 address besides loopback and link-local ones.
+func IPv6Only(addrs []net.Addr) bool {
+	ipv6 := false
+	for _, addr := range addrs {
+		var ip net.IP
+		switch a := addr.(type) {
+		case *net.IPNet:
+			ip = a.IP
+		case *net.IPAddr:
+			ip = a.IP
+		default:
+			continue
+		}
+		if ip.IsLoopback() || ip.IsLinkLocalUnicast() {
+			continue
+		}
+		if ip.To4() != nil {
+			return false
+		}
+		if ip.IsGlobalUnicast() {
+			ipv6 = true
+		}
+	}
+	return ipv6
+}
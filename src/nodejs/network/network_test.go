@@ -0,0 +1,111 @@
+package network_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"nodejs/network"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Network", func() {
+	Describe("Client", func() {
+		var server *httptest.Server
+
+		BeforeEach(func() {
+			listener, err := net.Listen("tcp6", "[::1]:0")
+			if err != nil {
+				Skip("IPv6 loopback is not available: " + err.Error())
+			}
+			server = httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(w, "hello from ", r.Host)
+			}))
+			server.Listener = listener
+			server.Start()
+		})
+
+		AfterEach(func() {
+			if server != nil {
+				server.Close()
+			}
+		})
+
+		It("fetches from a bracketed IPv6 literal", func() {
+			u, err := network.ParseURL(server.URL + "/dist")
+			Expect(err).To(BeNil())
+			Expect(u.Hostname()).To(Equal("::1"))
+
+			resp, err := network.Client.Get(u.String())
+			Expect(err).To(BeNil())
+			defer resp.Body.Close()
+			body, err := ioutil.ReadAll(resp.Body)
+			Expect(err).To(BeNil())
+			Expect(string(body)).To(HavePrefix("hello from [::1]:"))
+		})
+
+		It("reaches a host resolving to both families over IPv6", func() {
+			_, port, err := net.SplitHostPort(server.Listener.Addr().String())
+			Expect(err).To(BeNil())
+
+			resp, err := network.NewClient(0).Get("http://localhost:" + port + "/")
+			if err != nil {
+				Skip("localhost does not resolve to ::1: " + err.Error())
+			}
+			defer resp.Body.Close()
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+		})
+	})
+
+	Describe("ParseURL", func() {
+		DescribeTable("accepts",
+			func(raw, hostname, port string) {
+				u, err := network.ParseURL(raw)
+				Expect(err).To(BeNil())
+				Expect(u.Hostname()).To(Equal(hostname))
+				Expect(u.Port()).To(Equal(port))
+			},
+			Entry("a bracketed IPv6 literal", "http://[fd00::1]/mirror", "fd00::1", ""),
+			Entry("a bracketed IPv6 literal with a port", "https://[fd00::1]:4873/", "fd00::1", "4873"),
+			Entry("a bracketed IPv6 literal with credentials", "https://user:pass@[2001:db8::10]:8443/npm/", "2001:db8::10", "8443"),
+			Entry("an IPv4 literal", "http://10.0.0.1:8080/", "10.0.0.1", "8080"),
+			Entry("a host name", "https://registry.npmjs.org/", "registry.npmjs.org", ""),
+			Entry("a file URL", "file:///var/mirror", "", ""),
+		)
+
+		It("rejects an IPv6 literal without brackets", func() {
+			_, err := network.ParseURL("http://fd00::1:8080/mirror")
+			Expect(err).To(MatchError("IPv6 addresses need brackets, like http://[fd00::1]:8080/"))
+		})
+
+		It("rejects an invalid bracketed address", func() {
+			_, err := network.ParseURL("http://[fd00::zz]/")
+			Expect(err).NotTo(BeNil())
+		})
+	})
+
+	Describe("IPv6Only", func() {
+		cidr := func(s string) net.Addr {
+			ip, ipnet, err := net.ParseCIDR(s)
+			Expect(err).To(BeNil())
+			ipnet.IP = ip
+			return ipnet
+		}
+
+		It("is true with only global IPv6 addresses besides loopback and link-local ones", func() {
+			Expect(network.IPv6Only([]net.Addr{cidr("127.0.0.1/8"), cidr("::1/128"), cidr("fe80::1/64"), cidr("169.254.0.2/16"), cidr("2001:db8::5/64")})).To(BeTrue())
+		})
+
+		It("is false with an IPv4 address", func() {
+			Expect(network.IPv6Only([]net.Addr{cidr("10.255.0.4/16"), cidr("2001:db8::5/64")})).To(BeFalse())
+		})
+
+		It("is false without a global IPv6 address", func() {
+			Expect(network.IPv6Only([]net.Addr{cidr("127.0.0.1/8"), cidr("::1/128"), cidr("fe80::1/64")})).To(BeFalse())
+		})
+	})
+})
@@ -0,0 +1,220 @@
+// Package nodeoptions parses, merges and formats NODE_OPTIONS the way node
+// reads them.
+package nodeoptions
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Option is a flag of NODE_OPTIONS, with its name as written and its value,
+// if it has one.
+type Option struct {
+	Name     string
+	Value    string
+	HasValue bool
+}
+
+// aliases are the short and old names of flags, by the name they are merged
+// under.
+var aliases = map[string]string{
+	"-r":                    "--require",
+	"-C":                    "--conditions",
+	"--experimental-loader": "--loader",
+}
+
+// repeatable are the flags node takes any number of times, adding up their
+// values. Every other flag takes a single value, the last one.
+var repeatable = map[string]bool{
+	"--require":         true,
+	"--import":          true,
+	"--loader":          true,
+	"--conditions":      true,
+	"--disable-warning": true,
+	"--allow-fs-read":   true,
+	"--allow-fs-write":  true,
+}
+
+// separateValue are the flags which take their value as the next argument
+// too, like --require ./tracing.js. Every other flag takes a value after =
+// only.
+var separateValue = map[string]bool{
+	"--require":              true,
+	"--import":               true,
+	"--loader":               true,
+	"--conditions":           true,
+	"--disable-warning":      true,
+	"--dns-result-order":     true,
+	"--max-http-header-size": true,
+	"--tls-cipher-list":      true,
+	"--title":                true,
+}
+
+// Key is the name option is merged under: the long name of an alias, and
+// the dashed name of a V8 flag like --max_old_space_size.
+func (o Option) Key() string {
+	name := o.Name
+	if alias, found := aliases[name]; found {
+		return alias
+	}
+	if strings.HasPrefix(name, "--") {
+		name = "--" + strings.Replace(name[2:], "_", "-", -1)
+	}
+	return name
+}
+
+// Repeatable returns whether node adds up the values of option rather than
+// taking the last one.
+func (o Option) Repeatable() bool {
+	return repeatable[o.Key()]
+}
+
+// SeparateValue returns whether option takes its value as the next argument
+// too.
+func (o Option) SeparateValue() bool {
+	return separateValue[o.Key()]
+}
+
+// String formats option as NODE_OPTIONS has it, quoting a value with spaces
+// or quotes. A short flag like -r takes its value as the next argument.
+func (o Option) String() string {
+	if !o.HasValue {
+		return o.Name
+	}
+	value := o.Value
+	if value == "" || strings.ContainsAny(value, " \t\n\"\\") {
+		value = `"` + strings.Replace(strings.Replace(value, `\`, `\\`, -1), `"`, `\"`, -1) + `"`
+	}
+	if !strings.HasPrefix(o.Name, "--") {
+		return o.Name + " " + value
+	}
+	return o.Name + "=" + value
+}
+
+// split splits value into arguments like node splits NODE_OPTIONS: at
+// whitespace outside double quotes, with backslash escapes inside them.
+func split(value string) ([]string, error) {
+	var args []string
+	var arg []rune
+	inArg, quoted, escaped := false, false, false
+	for _, r := range value {
+		switch {
+		case escaped:
+			arg = append(arg, r)
+			escaped = false
+		case quoted && r == '\\':
+			escaped = true
+		case r == '"':
+			quoted = !quoted
+			inArg = true
+		case !quoted && (r == ' ' || r == '\t' || r == '\n'):
+			if inArg {
+				args = append(args, string(arg))
+				arg, inArg = nil, false
+			}
+		default:
+			arg = append(arg, r)
+			inArg = true
+		}
+	}
+	if quoted {
+		return nil, fmt.Errorf("%s has an unterminated quote", value)
+	}
+	if inArg {
+		args = append(args, string(arg))
+	}
+	return args, nil
+}
+
+// Parse parses the flags of NODE_OPTIONS.
+func Parse(value string) ([]Option, error) {
+	args, err := split(value)
+	if err != nil {
+		return nil, err
+	}
+
+	var options []Option
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if !strings.HasPrefix(arg, "-") || arg == "-" || arg == "--" {
+			return nil, fmt.Errorf("%s has %s, which is not a flag", value, arg)
+		}
+		option := Option{Name: arg}
+		if idx := strings.Index(arg, "="); idx >= 0 {
+			option = Option{Name: arg[:idx], Value: arg[idx+1:], HasValue: true}
+		} else if option.SeparateValue() && i+1 < len(args) {
+			i++
+			option.Value, option.HasValue = args[i], true
+		}
+		options = append(options, option)
+	}
+	return options, nil
+}
+
+// Format formats options as NODE_OPTIONS.
+func Format(options []Option) string {
+	var args []string
+	for _, option := range options {
+		args = append(args, option.String())
+	}
+	return strings.Join(args, " ")
+}
+
+// Conflict is a flag which both the options and the overrides of Merge set,
+// to different values.
+type Conflict struct {
+	Option   Option
+	Override Option
+}
+
+// Merge appends overrides to options. A repeatable flag of overrides is
+// added unless options has it with the same value. Any other flag of
+// overrides replaces that of options, which is reported as a Conflict when
+// the values differ. The flags keep their order, with those of overrides
+// after those of options.
+func Merge(options, overrides []Option) ([]Option, []Conflict) {
+	overridden := map[string]Option{}
+	for _, override := range overrides {
+		if !override.Repeatable() {
+			overridden[override.Key()] = override
+		}
+	}
+
+	var merged []Option
+	var conflicts []Conflict
+	for _, option := range options {
+		override, found := overridden[option.Key()]
+		if !found {
+			merged = append(merged, option)
+			continue
+		}
+		if option.Value != override.Value || option.HasValue != override.HasValue {
+			conflicts = append(conflicts, Conflict{Option: option, Override: override})
+		}
+	}
+
+	added := map[string]bool{}
+	for _, override := range overrides {
+		if override.Repeatable() {
+			if !contains(merged, override) {
+				merged = append(merged, override)
+			}
+			continue
+		}
+		// The last override of a flag wins.
+		if key := override.Key(); !added[key] && overridden[key] == override {
+			added[key] = true
+			merged = append(merged, override)
+		}
+	}
+	return merged, conflicts
+}
+
+func contains(options []Option, option Option) bool {
+	for _, o := range options {
+		if o.Key() == option.Key() && o.Value == option.Value {
+			return true
+		}
+	}
+	return false
+}
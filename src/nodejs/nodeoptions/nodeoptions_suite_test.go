@@ -0,0 +1,13 @@
+package nodeoptions_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestNodeoptions(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Nodeoptions Suite")
+}
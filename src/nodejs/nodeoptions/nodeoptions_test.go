@@ -0,0 +1,89 @@
+package nodeoptions_test
+
+import (
+	"nodejs/nodeoptions"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("NodeOptions", func() {
+	parse := func(value string) []nodeoptions.Option {
+		options, err := nodeoptions.Parse(value)
+		Expect(err).To(BeNil())
+		return options
+	}
+
+	DescribeTable("Parse",
+		func(value string, expected []nodeoptions.Option) {
+			Expect(nodeoptions.Parse(value)).To(Equal(expected))
+		},
+		Entry("nothing", "  ", nil),
+		Entry("flags with and without values", "--enable-source-maps --max-old-space-size=2048", []nodeoptions.Option{
+			{Name: "--enable-source-maps"},
+			{Name: "--max-old-space-size", Value: "2048", HasValue: true},
+		}),
+		Entry("a value as the next argument", "-r ./tracing.js --require=dotenv/config", []nodeoptions.Option{
+			{Name: "-r", Value: "./tracing.js", HasValue: true},
+			{Name: "--require", Value: "dotenv/config", HasValue: true},
+		}),
+		Entry("quoted values", `--title="my app" "--require=./a b.js" --tls-cipher-list="a\"b\\c"`, []nodeoptions.Option{
+			{Name: "--title", Value: "my app", HasValue: true},
+			{Name: "--require", Value: "./a b.js", HasValue: true},
+			{Name: "--tls-cipher-list", Value: `a"b\c`, HasValue: true},
+		}),
+		Entry("an empty quoted value", `--title=""`, []nodeoptions.Option{
+			{Name: "--title", HasValue: true},
+		}),
+	)
+
+	DescribeTable("Parse refuses",
+		func(value, message string) {
+			_, err := nodeoptions.Parse(value)
+			Expect(err).To(MatchError(message))
+		},
+		Entry("an unterminated quote", `--title="my app`, `--title="my app has an unterminated quote`),
+		Entry("an argument which is not a flag", "--inspect server.js", "--inspect server.js has server.js, which is not a flag"),
+	)
+
+	It("formats what it parses", func() {
+		value := `-r ./tracing.js --title="my app" --tls-cipher-list="a\"b\\c" --title="" --inspect`
+		Expect(nodeoptions.Format(parse(value))).To(Equal(`-r ./tracing.js --title="my app" --tls-cipher-list="a\"b\\c" --title="" --inspect`))
+		Expect(parse(nodeoptions.Format(parse(value)))).To(Equal(parse(value)))
+	})
+
+	DescribeTable("Merge",
+		func(options, overrides, expected string, conflicts [][2]string) {
+			merged, found := nodeoptions.Merge(parse(options), parse(overrides))
+			Expect(nodeoptions.Format(merged)).To(Equal(expected))
+
+			var formatted [][2]string
+			for _, conflict := range found {
+				formatted = append(formatted, [2]string{conflict.Option.String(), conflict.Override.String()})
+			}
+			Expect(formatted).To(Equal(conflicts))
+		},
+		Entry("nothing to merge", "--inspect", "", "--inspect", nil),
+		Entry("overrides after the options", "--enable-source-maps", "--max-http-header-size=16384", "--enable-source-maps --max-http-header-size=16384", nil),
+		Entry("a single value flag the override wins",
+			"--max-http-header-size=8192 --enable-source-maps", "--max-http-header-size=16384",
+			"--enable-source-maps --max-http-header-size=16384",
+			[][2]string{{"--max-http-header-size=8192", "--max-http-header-size=16384"}}),
+		Entry("the same value is no conflict", "--max-http-header-size 16384", "--max-http-header-size=16384", "--max-http-header-size=16384", nil),
+		Entry("a V8 flag by either spelling",
+			"--max_old_space_size=512", "--max-old-space-size=1024",
+			"--max-old-space-size=1024",
+			[][2]string{{"--max_old_space_size=512", "--max-old-space-size=1024"}}),
+		Entry("a flag without a value against one with",
+			"--tls-cipher-list", `--tls-cipher-list="TLS_AES_256_GCM_SHA384:ECDHE-RSA-AES128-GCM-SHA256"`,
+			`--tls-cipher-list=TLS_AES_256_GCM_SHA384:ECDHE-RSA-AES128-GCM-SHA256`,
+			[][2]string{{"--tls-cipher-list", "--tls-cipher-list=TLS_AES_256_GCM_SHA384:ECDHE-RSA-AES128-GCM-SHA256"}}),
+		Entry("repeatable flags add up", "-r ./tracing.js", "--require=./audit.js -r ./tracing.js", "-r ./tracing.js --require=./audit.js", nil),
+		Entry("repeated overrides", "", "--title=a --require=./a.js --title=b --require=./a.js", "--require=./a.js --title=b", nil),
+		Entry("quoted values",
+			`--title="my app"`, `--title="the platform"`,
+			`--title="the platform"`,
+			[][2]string{{`--title="my app"`, `--title="the platform"`}}),
+	)
+})
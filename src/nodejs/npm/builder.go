@@ -0,0 +1,79 @@
+package npm
+
+import (
+	"nodejs/pkgmanager"
+	"os"
+	"path/filepath"
+)
+
+// Builder builds the npm commands of staging.
+type Builder struct{}
+
+var _ pkgmanager.Builder = Builder{}
+
+// InstallRequest is the install of the app in buildDir with the options of
+// the environment: BP_NPM_LEGACY_PEER_DEPS, and BP_REPLAY_RESOLVED, which
+// installs offline from the tarballs primed into the npm cache.
+func InstallRequest(buildDir, cacheDir string) pkgmanager.Install {
+	return pkgmanager.Install{
+		BuildDir:       buildDir,
+		CacheDir:       cacheDir,
+		NodeDir:        os.Getenv("NODE_HOME"),
+		Offline:        os.Getenv("BP_REPLAY_RESOLVED") != "",
+		LegacyPeerDeps: os.Getenv("BP_NPM_LEGACY_PEER_DEPS") == "true",
+	}
+}
+
+// RebuildRequest is the rebuild of packages of the vendored node_modules of
+// buildDir. The install after it keeps to the default cache and may fetch
+// the packages node_modules lacks.
+func RebuildRequest(buildDir string, packages []string) pkgmanager.Rebuild {
+	req := InstallRequest(buildDir, "")
+	req.Offline = false
+	return pkgmanager.Rebuild{Install: req, Packages: packages}
+}
+
+// Install returns npm install. npm reads its config from the .npmrc of the
+// app only, not the one of the staging user.
+func (Builder) Install(req pkgmanager.Install) []pkgmanager.Invocation {
+	args := []string{"install", "--unsafe-perm", "--userconfig", filepath.Join(req.BuildDir, ".npmrc")}
+	if req.CacheDir != "" {
+		args = append(args, "--cache", filepath.Join(req.CacheDir, ".npm"))
+	}
+	if req.LegacyPeerDeps {
+		args = append(args, "--legacy-peer-deps")
+	}
+	if req.Offline {
+		args = append(args, "--offline")
+	}
+	return []pkgmanager.Invocation{{Program: "npm", Args: args, Dir: req.BuildDir}}
+}
+
+// Rebuild returns npm rebuild, unless no package needs it, and the npm
+// install of what node_modules lacks.
+func (b Builder) Rebuild(req pkgmanager.Rebuild) []pkgmanager.Invocation {
+	var invocations []pkgmanager.Invocation
+	if req.Packages == nil || len(req.Packages) > 0 {
+		invocations = append(invocations, pkgmanager.Invocation{Program: "npm", Args: RebuildArgs(req.Packages, req.NodeDir), Dir: req.BuildDir})
+	}
+	return append(invocations, b.Install(req.Install)...)
+}
+
+// RebuildArgs returns the npm args which rebuild packages, or every package
+// when it is empty, against the headers of the node in nodeDir.
+func RebuildArgs(packages []string, nodeDir string) []string {
+	args := append([]string{"rebuild"}, packages...)
+	return append(args, "--nodedir="+nodeDir)
+}
+
+// GlobalInstall returns the npm install of packages, like firebase-tools@13,
+// into prefix rather than the global dir of node, with the .npmrc and cache
+// of the app install.
+func (Builder) GlobalInstall(req pkgmanager.Install, prefix string, packages []string) []pkgmanager.Invocation {
+	args := []string{"install", "--global", "--prefix", prefix, "--userconfig", filepath.Join(req.BuildDir, ".npmrc")}
+	if req.CacheDir != "" {
+		args = append(args, "--cache", filepath.Join(req.CacheDir, ".npm"))
+	}
+	args = append(args, packages...)
+	return []pkgmanager.Invocation{{Program: "npm", Args: args, Dir: req.BuildDir}}
+}
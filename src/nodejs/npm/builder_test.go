@@ -0,0 +1,102 @@
+package npm_test
+
+import (
+	"nodejs/npm"
+	"nodejs/pkgmanager"
+	"os"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Builder", func() {
+	install := pkgmanager.Install{BuildDir: "/tmp/app", CacheDir: "/tmp/cache", NodeDir: "/deps/0/node"}
+
+	DescribeTable("Install",
+		func(change func(*pkgmanager.Install), expected []string) {
+			req := install
+			change(&req)
+			Expect(pkgmanager.RenderPlan(npm.Builder{}.Install(req))).To(Equal(expected))
+		},
+		Entry("by default", func(*pkgmanager.Install) {}, []string{
+			"npm install --unsafe-perm --userconfig /tmp/app/.npmrc --cache /tmp/cache/.npm",
+		}),
+		Entry("without a cache", func(req *pkgmanager.Install) { req.CacheDir = "" }, []string{
+			"npm install --unsafe-perm --userconfig /tmp/app/.npmrc",
+		}),
+		Entry("offline with legacy peer deps", func(req *pkgmanager.Install) { req.Offline, req.LegacyPeerDeps = true, true }, []string{
+			"npm install --unsafe-perm --userconfig /tmp/app/.npmrc --cache /tmp/cache/.npm --legacy-peer-deps --offline",
+		}),
+	)
+
+	DescribeTable("Rebuild",
+		func(packages []string, expected []string) {
+			req := install
+			req.CacheDir = ""
+			Expect(pkgmanager.RenderPlan(npm.Builder{}.Rebuild(pkgmanager.Rebuild{Install: req, Packages: packages}))).To(Equal(expected))
+		},
+		Entry("every package", nil, []string{
+			"npm rebuild --nodedir=/deps/0/node",
+			"npm install --unsafe-perm --userconfig /tmp/app/.npmrc",
+		}),
+		Entry("some packages", []string{"@img/sharp", "bcrypt"}, []string{
+			"npm rebuild @img/sharp bcrypt --nodedir=/deps/0/node",
+			"npm install --unsafe-perm --userconfig /tmp/app/.npmrc",
+		}),
+		Entry("no package", []string{}, []string{
+			"npm install --unsafe-perm --userconfig /tmp/app/.npmrc",
+		}),
+	)
+
+	DescribeTable("GlobalInstall",
+		func(cacheDir string, expected []string) {
+			req := install
+			req.CacheDir = cacheDir
+			Expect(pkgmanager.RenderPlan(npm.Builder{}.GlobalInstall(req, "/deps/0/global", []string{"@angular/cli@17", "firebase-tools"}))).To(Equal(expected))
+		},
+		Entry("with a cache", "/tmp/cache", []string{
+			"npm install --global --prefix /deps/0/global --userconfig /tmp/app/.npmrc --cache /tmp/cache/.npm @angular/cli@17 firebase-tools",
+		}),
+		Entry("without a cache", "", []string{
+			"npm install --global --prefix /deps/0/global --userconfig /tmp/app/.npmrc @angular/cli@17 firebase-tools",
+		}),
+	)
+
+	Describe("requests", func() {
+		var oldEnv map[string]string
+
+		BeforeEach(func() {
+			oldEnv = map[string]string{}
+			for _, key := range []string{"NODE_HOME", "BP_REPLAY_RESOLVED", "BP_NPM_LEGACY_PEER_DEPS"} {
+				oldEnv[key] = os.Getenv(key)
+				os.Unsetenv(key)
+			}
+			os.Setenv("NODE_HOME", "/deps/0/node")
+		})
+
+		AfterEach(func() {
+			for key, value := range oldEnv {
+				os.Setenv(key, value)
+			}
+		})
+
+		It("takes the options of the install from the environment", func() {
+			Expect(npm.InstallRequest("/tmp/app", "/tmp/cache")).To(Equal(install))
+
+			os.Setenv("BP_REPLAY_RESOLVED", "tarballs")
+			os.Setenv("BP_NPM_LEGACY_PEER_DEPS", "true")
+			req := npm.InstallRequest("/tmp/app", "/tmp/cache")
+			Expect(req.Offline).To(BeTrue())
+			Expect(req.LegacyPeerDeps).To(BeTrue())
+		})
+
+		It("installs online with the default cache after a rebuild", func() {
+			os.Setenv("BP_REPLAY_RESOLVED", "tarballs")
+			req := npm.RebuildRequest("/tmp/app", []string{"bcrypt"})
+			Expect(req.Offline).To(BeFalse())
+			Expect(req.CacheDir).To(Equal(""))
+			Expect(req.Packages).To(Equal([]string{"bcrypt"}))
+		})
+	})
+})
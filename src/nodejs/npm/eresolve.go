@@ -0,0 +1,122 @@
+package npm
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// PeerRequest is a dependency on a range of a package, as npm prints it in an
+// ERESOLVE error: `dev react@"^18.2.0" from the root project`.
+type PeerRequest struct {
+	Type  string
+	Name  string
+	Range string
+	From  string
+}
+
+func (r PeerRequest) String() string {
+	s := fmt.Sprintf(`%s requests %s@"%s"`, r.From, r.Name, r.Range)
+	if r.Type != "" {
+		s += " (" + r.Type + ")"
+	}
+	return s
+}
+
+// PeerConflict is a peer dependency npm 7 and later could not resolve,
+// failing the install with ERESOLVE where npm 6 installed the tree anyway.
+type PeerConflict struct {
+	// Resolving is the package npm was resolving, like shop@1.4.0.
+	Resolving string
+	// Found is the installed version of the package, like react@18.2.0, and
+	// Installed the requests it was installed for.
+	Found     string
+	Installed []PeerRequest
+	// Peer is the peer dependency npm could not resolve.
+	Peer PeerRequest
+	// Conflicting is the version of the package the peer dependency needs,
+	// when npm reports one.
+	Conflicting string
+}
+
+func (c PeerConflict) String() string {
+	lines := []string{fmt.Sprintf("npm could not resolve the peer dependency %s of %s while resolving %s (ERESOLVE):", c.Peer.Name, c.Peer.From, c.Resolving)}
+	peer := "  " + c.Peer.String()
+	if c.Conflicting != "" {
+		peer += ", which would be " + c.Conflicting
+	}
+	lines = append(lines, peer)
+	for _, request := range c.Installed {
+		if request == c.Peer {
+			continue
+		}
+		lines = append(lines, "  "+request.String()+", which installed "+c.Found)
+	}
+	return strings.Join(lines, "\n")
+}
+
+var peerRequest = regexp.MustCompile(`^(?:(dev|optional|peer|peerOptional) )?(@?[^@\s"]+)@"([^"]*)" from (.+)$`)
+
+// ParsePeerConflict finds an ERESOLVE error in the output of npm install.
+// npm 7 and 8 prefix the lines of the error with `npm ERR!`, npm 9 and later
+// with `npm error`, and with --json npm writes the same text to the detail
+// of a JSON error.
+func ParsePeerConflict(output string) (PeerConflict, bool) {
+	if !strings.Contains(output, "ERESOLVE") {
+		return PeerConflict{}, false
+	}
+	if detail, found := jsonErrorDetail(output); found {
+		output = detail
+	}
+
+	var conflict PeerConflict
+	section := ""
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimRight(line, " \r")
+		line = strings.TrimPrefix(line, npmErrorPrefix.FindString(line))
+
+		switch {
+		case strings.HasPrefix(line, "While resolving: "):
+			conflict.Resolving = strings.TrimPrefix(line, "While resolving: ")
+		case strings.HasPrefix(line, "Found: "):
+			conflict.Found = strings.TrimPrefix(line, "Found: ")
+			section = "found"
+		case line == "Could not resolve dependency:":
+			section = "dependency"
+		case strings.HasPrefix(line, "Conflicting peer dependency: "):
+			conflict.Conflicting = strings.TrimPrefix(line, "Conflicting peer dependency: ")
+			section = "conflicting"
+		case line == "":
+			section = ""
+		case section == "found" && strings.HasPrefix(line, "  ") && !strings.HasPrefix(line, "   "):
+			if match := peerRequest.FindStringSubmatch(strings.TrimSpace(line)); match != nil {
+				conflict.Installed = append(conflict.Installed, PeerRequest{Type: match[1], Name: match[2], Range: match[3], From: match[4]})
+			}
+		case section == "dependency" && conflict.Peer.Name == "":
+			if match := peerRequest.FindStringSubmatch(line); match != nil {
+				conflict.Peer = PeerRequest{Type: match[1], Name: match[2], Range: match[3], From: match[4]}
+			}
+		}
+	}
+	return conflict, conflict.Peer.Name != ""
+}
+
+// jsonErrorDetail returns the detail of the ERESOLVE error npm writes with
+// --json.
+func jsonErrorDetail(output string) (string, bool) {
+	start := strings.Index(output, "{")
+	if start < 0 {
+		return "", false
+	}
+	var report struct {
+		Error struct {
+			Code   string `json:"code"`
+			Detail string `json:"detail"`
+		} `json:"error"`
+	}
+	if err := json.NewDecoder(strings.NewReader(output[start:])).Decode(&report); err != nil || report.Error.Code != "ERESOLVE" {
+		return "", false
+	}
+	return report.Error.Detail, true
+}
@@ -0,0 +1,116 @@
+package npm_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	n "nodejs/npm"
+	"os"
+	"path/filepath"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/cloudfoundry/libbuildpack/ansicleaner"
+	"golang.google.cn/x/mock/gomock"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Peer dependency conflicts", func() {
+	reactConflict := n.PeerConflict{
+		Resolving: "shop@1.4.0",
+		Found:     "react@18.2.0",
+		Installed: []n.PeerRequest{{Name: "react", Range: "^18.2.0", From: "the root project"}},
+		Peer:      n.PeerRequest{Type: "peer", Name: "react", Range: "^16.8.5 || ^17.0.0", From: "react-beautiful-dnd@13.1.1"},
+	}
+
+	DescribeTable("ParsePeerConflict",
+		func(fixture string, expected n.PeerConflict) {
+			output, err := ioutil.ReadFile(filepath.Join("testdata", fixture))
+			Expect(err).To(BeNil())
+			conflict, found := n.ParsePeerConflict(string(output))
+			Expect(found).To(Equal(expected.Peer.Name != ""))
+			Expect(conflict).To(Equal(expected))
+		},
+		Entry("npm 8", "npm8_eresolve.log", reactConflict),
+		Entry("npm 8 with --json", "npm8_eresolve.json", reactConflict),
+		Entry("npm 10 with a conflicting peer", "npm10_eresolve.log", n.PeerConflict{
+			Resolving: "@testing-library/react-hooks@7.0.2",
+			Found:     "@types/react@18.2.45",
+			Installed: []n.PeerRequest{
+				{Type: "dev", Name: "@types/react", Range: "^18.2.0", From: "the root project"},
+				{Type: "peerOptional", Name: "@types/react", Range: "^16.9.0 || ^17.0.0", From: "@testing-library/react-hooks@7.0.2"},
+			},
+			Peer:        n.PeerRequest{Type: "peerOptional", Name: "@types/react", Range: "^16.9.0 || ^17.0.0", From: "@testing-library/react-hooks@7.0.2"},
+			Conflicting: "@types/react@17.0.74",
+		}),
+		Entry("other errors", "npm10_enoent.log", n.PeerConflict{}),
+		Entry("native build failures", "npm8_install.log", n.PeerConflict{}),
+	)
+
+	It("summarizes the conflict", func() {
+		output, err := ioutil.ReadFile(filepath.Join("testdata", "npm10_eresolve.log"))
+		Expect(err).To(BeNil())
+		conflict, _ := n.ParsePeerConflict(string(output))
+		Expect(conflict.String()).To(Equal(`npm could not resolve the peer dependency @types/react of @testing-library/react-hooks@7.0.2 while resolving @testing-library/react-hooks@7.0.2 (ERESOLVE):
+  @testing-library/react-hooks@7.0.2 requests @types/react@"^16.9.0 || ^17.0.0" (peerOptional), which would be @types/react@17.0.74
+  the root project requests @types/react@"^18.2.0" (dev), which installed @types/react@18.2.45`))
+	})
+
+	Describe("installing", func() {
+		var (
+			buildDir          string
+			npm               *n.NPM
+			buffer            *bytes.Buffer
+			mockCtrl          *gomock.Controller
+			mockCommand       *MockCommand
+			oldLegacyPeerDeps string
+		)
+
+		BeforeEach(func() {
+			var err error
+			buildDir, err = ioutil.TempDir("", "nodejs-buildpack.build.")
+			Expect(err).To(BeNil())
+			Expect(ioutil.WriteFile(filepath.Join(buildDir, "package.json"), []byte("{}"), 0644)).To(Succeed())
+
+			oldLegacyPeerDeps = os.Getenv("BP_NPM_LEGACY_PEER_DEPS")
+			os.Unsetenv("BP_NPM_LEGACY_PEER_DEPS")
+
+			buffer = new(bytes.Buffer)
+			mockCtrl = gomock.NewController(GinkgoT())
+			mockCommand = NewMockCommand(mockCtrl)
+			npm = &n.NPM{Log: libbuildpack.NewLogger(ansicleaner.New(buffer)), Command: mockCommand}
+		})
+
+		AfterEach(func() {
+			mockCtrl.Finish()
+			os.Setenv("BP_NPM_LEGACY_PEER_DEPS", oldLegacyPeerDeps)
+			Expect(os.RemoveAll(buildDir)).To(Succeed())
+		})
+
+		It("reports the conflict of a failing install", func() {
+			mockCommand.EXPECT().Execute(buildDir, gomock.Any(), gomock.Any(), "npm", gomock.Any()).DoAndReturn(func(_ string, stdout io.Writer, _ io.Writer, _ string, _ ...string) error {
+				output, err := ioutil.ReadFile(filepath.Join("testdata", "npm8_eresolve.log"))
+				Expect(err).To(BeNil())
+				stdout.Write(output)
+				return errors.New("exit status 1")
+			})
+
+			err := npm.Build(buildDir, "")
+			Expect(err).To(MatchError(`npm could not resolve the peer dependency react of react-beautiful-dnd@13.1.1 while resolving shop@1.4.0 (ERESOLVE):
+  react-beautiful-dnd@13.1.1 requests react@"^16.8.5 || ^17.0.0" (peer)
+  the root project requests react@"^18.2.0", which installed react@18.2.0
+Fix the ranges in package.json, or set BP_NPM_LEGACY_PEER_DEPS=true to install with --legacy-peer-deps like npm 6: exit status 1`))
+		})
+
+		It("installs with --legacy-peer-deps with BP_NPM_LEGACY_PEER_DEPS=true", func() {
+			os.Setenv("BP_NPM_LEGACY_PEER_DEPS", "true")
+			mockCommand.EXPECT().Execute(buildDir, gomock.Any(), gomock.Any(), "npm", "install", "--unsafe-perm", "--userconfig", filepath.Join(buildDir, ".npmrc"), "--cache", filepath.Join("/cache", ".npm"), "--legacy-peer-deps")
+
+			Expect(npm.Build(buildDir, "/cache")).To(Succeed())
+			Expect(buffer.String()).To(ContainSubstring("Installing with --legacy-peer-deps (BP_NPM_LEGACY_PEER_DEPS), which does not install peer dependencies"))
+			Expect(buffer.String()).To(ContainSubstring("node_modules may differ from the tree the lockfile intends"))
+		})
+	})
+})
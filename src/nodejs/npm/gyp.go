@@ -0,0 +1,132 @@
+package npm
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// GypTailLines is how much of the verbose rebuild output is kept in the
+// error of a failed native build.
+const GypTailLines = 100
+
+// GypFailure is a native module whose node-gyp build failed.
+type GypFailure struct {
+	Package string
+}
+
+var (
+	npmErrorPrefix = regexp.MustCompile(`^npm (ERR!|error) ?`)
+	failedAtScript = regexp.MustCompile(`^Failed at the (.+)@[^@ ]+ [a-z]+ script`)
+)
+
+// ParseGypFailure finds the package whose native build failed in the output
+// of npm install or npm rebuild. npm 7 and later print the path of the
+// package whose script failed, npm 6 its name and version, and node-gyp
+// itself the directory it ran in.
+func ParseGypFailure(output string) (GypFailure, bool) {
+	if !strings.Contains(output, "gyp ERR!") {
+		return GypFailure{}, false
+	}
+
+	var fromPath, fromCwd, fromScript GypFailure
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		prefix := npmErrorPrefix.FindString(line)
+		line = strings.TrimPrefix(line, prefix)
+
+		switch {
+		case prefix != "" && strings.HasPrefix(line, "path ") && fromPath.Package == "":
+			fromPath = failureAt(strings.TrimSpace(strings.TrimPrefix(line, "path ")))
+		case strings.HasPrefix(line, "gyp ERR! cwd ") && fromCwd.Package == "":
+			fromCwd = failureAt(strings.TrimSpace(strings.TrimPrefix(line, "gyp ERR! cwd ")))
+		case prefix != "" && fromScript.Package == "":
+			if match := failedAtScript.FindStringSubmatch(line); match != nil {
+				fromScript = GypFailure{Package: match[1]}
+			}
+		}
+	}
+
+	for _, failure := range []GypFailure{fromPath, fromCwd, fromScript} {
+		if failure.Package != "" {
+			return failure, true
+		}
+	}
+	return GypFailure{}, false
+}
+
+// failureAt returns the innermost package of a path into node_modules.
+func failureAt(path string) GypFailure {
+	idx := strings.LastIndex(path, "node_modules/")
+	if idx < 0 {
+		return GypFailure{}
+	}
+	parts := strings.Split(path[idx+len("node_modules/"):], "/")
+	name := parts[0]
+	if strings.HasPrefix(name, "@") && len(parts) > 1 {
+		name += "/" + parts[1]
+	}
+	return GypFailure{Package: name}
+}
+
+// lastLines returns the last n lines of output.
+func lastLines(output string, n int) string {
+	lines := strings.Split(strings.TrimRight(output, "\n"), "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return strings.Join(lines, "\n")
+}
+
+var toolchain = [][]string{
+	{"gcc", "--version"},
+	{"make", "--version"},
+	{"python3", "--version"},
+	{"python", "--version"},
+}
+
+// toolchainVersions lists the versions of the build tools node-gyp uses.
+func (n *NPM) toolchainVersions(dir string) string {
+	var versions []string
+	for _, tool := range toolchain {
+		buffer := new(bytes.Buffer)
+		version := "not found"
+		if err := n.Command.Execute(dir, buffer, buffer, tool[0], tool[1:]...); err == nil {
+			version = strings.TrimSpace(strings.SplitN(strings.TrimSpace(buffer.String()), "\n", 2)[0])
+		}
+		versions = append(versions, fmt.Sprintf("%s: %s", tool[0], version))
+	}
+	return strings.Join(versions, ", ")
+}
+
+// execute runs npm. When a native module fails to build, the rebuild of
+// just that package is run once more with verbose logging, and the end of
+// its output is returned in the error. A peer dependency conflict is
+// summarized in the error.
+func (n *NPM) execute(dir string, args ...string) error {
+	output := new(bytes.Buffer)
+	w := io.MultiWriter(n.Log.Output(), output)
+	err := n.Command.Execute(dir, w, w, "npm", args...)
+	if err == nil {
+		return nil
+	}
+
+	failure, found := ParseGypFailure(output.String())
+	if !found {
+		if conflict, found := ParsePeerConflict(output.String()); found {
+			return fmt.Errorf("%s\nFix the ranges in package.json, or set BP_NPM_LEGACY_PEER_DEPS=true to install with --legacy-peer-deps like npm 6: %s", conflict, err)
+		}
+		return err
+	}
+
+	n.Log.Warning("Building the native module %s failed, rebuilding it with verbose logging", failure.Package)
+	verbose := new(bytes.Buffer)
+	if rebuildErr := n.Command.Execute(dir, verbose, verbose, "npm", "rebuild", failure.Package, "--loglevel", "verbose", "--foreground-scripts", "--nodedir="+os.Getenv("NODE_HOME")); rebuildErr == nil {
+		return fmt.Errorf("building the native module %s failed, but rebuilding it on its own succeeded: %s", failure.Package, err)
+	}
+
+	return fmt.Errorf("building the native module %s failed: %s\nLast %d lines of the verbose rebuild:\n%s\nBuild tools on %s: %s", failure.Package, err, GypTailLines, lastLines(verbose.String(), GypTailLines), os.Getenv("CF_STACK"), n.toolchainVersions(dir))
+}
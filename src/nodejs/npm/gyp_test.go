@@ -0,0 +1,129 @@
+package npm_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	n "nodejs/npm"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/cloudfoundry/libbuildpack/ansicleaner"
+	"golang.google.cn/x/mock/gomock"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Gyp failures", func() {
+	DescribeTable("ParseGypFailure",
+		func(fixture, expected string) {
+			output, err := ioutil.ReadFile(filepath.Join("testdata", fixture))
+			Expect(err).To(BeNil())
+			failure, found := n.ParseGypFailure(string(output))
+			Expect(found).To(Equal(expected != ""))
+			Expect(failure.Package).To(Equal(expected))
+		},
+		Entry("npm 8", "npm8_install.log", "bcrypt"),
+		Entry("npm 10, nested and scoped", "npm10_install.log", "@serialport/bindings-cpp"),
+		Entry("npm 6", "npm6_install.log", "sqlite3"),
+		Entry("other errors", "npm10_enoent.log", ""),
+	)
+
+	Describe("a failing install", func() {
+		var (
+			buildDir    string
+			npm         *n.NPM
+			buffer      *bytes.Buffer
+			mockCtrl    *gomock.Controller
+			mockCommand *MockCommand
+			oldNodeHome string
+			oldStack    string
+		)
+
+		failWith := func(fixture string) func(string, io.Writer, io.Writer, string, ...string) error {
+			return func(_ string, stdout io.Writer, _ io.Writer, _ string, _ ...string) error {
+				output, err := ioutil.ReadFile(filepath.Join("testdata", fixture))
+				Expect(err).To(BeNil())
+				stdout.Write(output)
+				return errors.New("exit status 1")
+			}
+		}
+
+		BeforeEach(func() {
+			var err error
+			buildDir, err = ioutil.TempDir("", "nodejs-buildpack.build.")
+			Expect(err).To(BeNil())
+			Expect(ioutil.WriteFile(filepath.Join(buildDir, "package.json"), []byte("{}"), 0644)).To(Succeed())
+
+			oldNodeHome = os.Getenv("NODE_HOME")
+			oldStack = os.Getenv("CF_STACK")
+			os.Setenv("NODE_HOME", "/deps/0/node")
+			os.Setenv("CF_STACK", "cflinuxfs4")
+
+			buffer = new(bytes.Buffer)
+			mockCtrl = gomock.NewController(GinkgoT())
+			mockCommand = NewMockCommand(mockCtrl)
+			npm = &n.NPM{Log: libbuildpack.NewLogger(ansicleaner.New(buffer)), Command: mockCommand}
+		})
+
+		AfterEach(func() {
+			mockCtrl.Finish()
+			os.Setenv("NODE_HOME", oldNodeHome)
+			os.Setenv("CF_STACK", oldStack)
+			Expect(os.RemoveAll(buildDir)).To(Succeed())
+		})
+
+		It("rebuilds the failing package verbosely and reports the end of its output", func() {
+			var verbose []string
+			for i := 1; i <= 150; i++ {
+				verbose = append(verbose, fmt.Sprintf("gyp verb line %d", i))
+			}
+
+			gomock.InOrder(
+				mockCommand.EXPECT().Execute(buildDir, gomock.Any(), gomock.Any(), "npm", gomock.Any()).DoAndReturn(failWith("npm8_install.log")),
+				mockCommand.EXPECT().Execute(buildDir, gomock.Any(), gomock.Any(), "npm", "rebuild", "bcrypt", "--loglevel", "verbose", "--foreground-scripts", "--nodedir=/deps/0/node").DoAndReturn(func(_ string, stdout io.Writer, _ io.Writer, _ string, _ ...string) error {
+					io.WriteString(stdout, strings.Join(verbose, "\n")+"\n")
+					return errors.New("exit status 1")
+				}),
+				mockCommand.EXPECT().Execute(buildDir, gomock.Any(), gomock.Any(), "gcc", "--version").DoAndReturn(func(_ string, stdout io.Writer, _ io.Writer, _ string, _ ...string) error {
+					io.WriteString(stdout, "gcc (Ubuntu 11.4.0-1ubuntu1~22.04) 11.4.0\nCopyright (C) 2021 Free Software Foundation, Inc.\n")
+					return nil
+				}),
+				mockCommand.EXPECT().Execute(buildDir, gomock.Any(), gomock.Any(), "make", "--version").DoAndReturn(func(_ string, stdout io.Writer, _ io.Writer, _ string, _ ...string) error {
+					io.WriteString(stdout, "GNU Make 4.3\n")
+					return nil
+				}),
+				mockCommand.EXPECT().Execute(buildDir, gomock.Any(), gomock.Any(), "python3", "--version").Return(errors.New("not found")),
+				mockCommand.EXPECT().Execute(buildDir, gomock.Any(), gomock.Any(), "python", "--version").Return(errors.New("not found")),
+			)
+
+			err := npm.Build(buildDir, "")
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(HavePrefix("building the native module bcrypt failed: exit status 1\nLast 100 lines of the verbose rebuild:\ngyp verb line 51\n"))
+			Expect(err.Error()).To(ContainSubstring("gyp verb line 150\nBuild tools on cflinuxfs4: gcc: gcc (Ubuntu 11.4.0-1ubuntu1~22.04) 11.4.0, make: GNU Make 4.3, python3: not found, python: not found"))
+			Expect(err.Error()).NotTo(ContainSubstring("gyp verb line 50\n"))
+			Expect(buffer.String()).To(ContainSubstring("npm ERR! gyp ERR! not ok"))
+			Expect(buffer.String()).To(ContainSubstring("Building the native module bcrypt failed, rebuilding it with verbose logging"))
+		})
+
+		It("retries during Rebuild too", func() {
+			gomock.InOrder(
+				mockCommand.EXPECT().Execute(buildDir, gomock.Any(), gomock.Any(), "npm", "rebuild", "--nodedir=/deps/0/node").DoAndReturn(failWith("npm10_install.log")),
+				mockCommand.EXPECT().Execute(buildDir, gomock.Any(), gomock.Any(), "npm", "rebuild", "@serialport/bindings-cpp", "--loglevel", "verbose", "--foreground-scripts", "--nodedir=/deps/0/node").Return(nil),
+			)
+
+			err := npm.Rebuild(buildDir, nil)
+			Expect(err).To(MatchError("building the native module @serialport/bindings-cpp failed, but rebuilding it on its own succeeded: exit status 1"))
+		})
+
+		It("returns other errors unchanged", func() {
+			mockCommand.EXPECT().Execute(buildDir, gomock.Any(), gomock.Any(), "npm", gomock.Any()).DoAndReturn(failWith("npm10_enoent.log"))
+			Expect(npm.Build(buildDir, "")).To(MatchError("exit status 1"))
+		})
+	})
+})
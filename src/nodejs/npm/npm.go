@@ -2,7 +2,7 @@ package npm
 
 import (
 	"io"
-	"os"
+	"nodejs/pkgmanager"
 	"path/filepath"
 	"strings"
 
@@ -28,11 +28,15 @@ func (n *NPM) Build(buildDir, cacheDir string) error {
 	}
 
 	n.Log.Info("Installing node modules (%s)", source)
-	npmArgs := []string{"install", "--unsafe-perm", "--userconfig", filepath.Join(buildDir, ".npmrc"), "--cache", filepath.Join(cacheDir, ".npm")}
-	return n.Command.Execute(buildDir, n.Log.Output(), n.Log.Output(), "npm", npmArgs...)
+	req := InstallRequest(buildDir, cacheDir)
+	n.logOptions(req)
+	return n.run(Builder{}.Install(req))
 }
 
-func (n *NPM) Rebuild(buildDir string) error {
+// Rebuild rebuilds the native modules of a vendored node_modules and
+// installs what it lacks. A nil packages rebuilds every package, otherwise
+// only those listed, and none for an empty list.
+func (n *NPM) Rebuild(buildDir string, packages []string) error {
 	doBuild, source, err := n.doBuild(buildDir)
 	if err != nil {
 		return err
@@ -41,14 +45,40 @@ func (n *NPM) Rebuild(buildDir string) error {
 		return nil
 	}
 
-	n.Log.Info("Rebuilding any native modules")
-	if err := n.Command.Execute(buildDir, n.Log.Output(), n.Log.Output(), "npm", "rebuild", "--nodedir="+os.Getenv("NODE_HOME")); err != nil {
+	if packages == nil {
+		n.Log.Info("Rebuilding any native modules")
+	} else if len(packages) > 0 {
+		n.Log.Info("Rebuilding the native modules %s", strings.Join(packages, ", "))
+	}
+	req := RebuildRequest(buildDir, packages)
+	invocations := Builder{}.Rebuild(req)
+	if err := n.run(invocations[:len(invocations)-1]); err != nil {
 		return err
 	}
 
 	n.Log.Info("Installing any new modules (%s)", source)
-	npmArgs := []string{"install", "--unsafe-perm", "--userconfig", filepath.Join(buildDir, ".npmrc")}
-	return n.Command.Execute(buildDir, n.Log.Output(), n.Log.Output(), "npm", npmArgs...)
+	n.logOptions(req.Install)
+	return n.run(invocations[len(invocations)-1:])
+}
+
+// logOptions explains the options of the environment an install runs with.
+func (n *NPM) logOptions(req pkgmanager.Install) {
+	if req.LegacyPeerDeps {
+		n.Log.Warning("Installing with --legacy-peer-deps (BP_NPM_LEGACY_PEER_DEPS), which does not install peer dependencies\nnode_modules may differ from the tree the lockfile intends")
+	}
+	if req.Offline {
+		n.Log.Info("Installing offline from the npm cache (BP_REPLAY_RESOLVED)")
+	}
+}
+
+// run runs the invocations of Builder in order.
+func (n *NPM) run(invocations []pkgmanager.Invocation) error {
+	for _, invocation := range invocations {
+		if err := n.execute(invocation.Dir, invocation.Args...); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func (n *NPM) doBuild(buildDir string) (bool, string, error) {
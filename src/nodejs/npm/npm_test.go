@@ -97,6 +97,27 @@ var _ = Describe("NPM", func() {
 				Expect(buffer.String()).To(ContainSubstring("Skipping (no package.json)"))
 			})
 		})
+
+		Context("BP_REPLAY_RESOLVED is set", func() {
+			var oldReplay string
+
+			BeforeEach(func() {
+				oldReplay = os.Getenv("BP_REPLAY_RESOLVED")
+				Expect(os.Setenv("BP_REPLAY_RESOLVED", "/tarballs")).To(Succeed())
+				Expect(ioutil.WriteFile(filepath.Join(buildDir, "package.json"), []byte("xxx"), 0644)).To(Succeed())
+			})
+
+			AfterEach(func() {
+				Expect(os.Setenv("BP_REPLAY_RESOLVED", oldReplay)).To(Succeed())
+			})
+
+			It("installs offline", func() {
+				mockCommand.EXPECT().Execute(buildDir, gomock.Any(), gomock.Any(), "npm", []string{"install", "--unsafe-perm", "--userconfig", filepath.Join(buildDir, ".npmrc"), "--cache", filepath.Join(cacheDir, ".npm"), "--offline"}).Return(nil)
+
+				Expect(npm.Build(buildDir, cacheDir)).To(Succeed())
+				Expect(buffer.String()).To(ContainSubstring("Installing offline from the npm cache (BP_REPLAY_RESOLVED)"))
+			})
+		})
 	})
 
 	Describe("Rebuild", func() {
@@ -126,7 +147,7 @@ var _ = Describe("NPM", func() {
 				})
 
 				It("runs the install, telling users about shrinkwrap", func() {
-					Expect(npm.Rebuild(buildDir)).To(Succeed())
+					Expect(npm.Rebuild(buildDir, nil)).To(Succeed())
 					Expect(buffer.String()).To(ContainSubstring("Rebuilding any native modules"))
 					Expect(buffer.String()).To(ContainSubstring("Installing any new modules (package.json + npm-shrinkwrap.json)"))
 				})
@@ -134,16 +155,39 @@ var _ = Describe("NPM", func() {
 
 			Context("npm-shrinkwrap.json does not exist", func() {
 				It("runs the install", func() {
-					Expect(npm.Rebuild(buildDir)).To(Succeed())
+					Expect(npm.Rebuild(buildDir, nil)).To(Succeed())
 					Expect(buffer.String()).To(ContainSubstring("Rebuilding any native modules"))
 					Expect(buffer.String()).To(ContainSubstring("Installing any new modules (package.json)"))
 				})
 			})
 		})
 
+		Context("with the packages to rebuild", func() {
+			BeforeEach(func() {
+				Expect(ioutil.WriteFile(filepath.Join(buildDir, "package.json"), []byte("xxx"), 0644)).To(Succeed())
+			})
+
+			It("rebuilds only them", func() {
+				gomock.InOrder(
+					mockCommand.EXPECT().Execute(buildDir, gomock.Any(), gomock.Any(), "npm", []string{"rebuild", "bcrypt", "sharp", "--nodedir=test_node_home"}).Return(nil),
+					mockCommand.EXPECT().Execute(buildDir, gomock.Any(), gomock.Any(), "npm", []string{"install", "--unsafe-perm", "--userconfig", filepath.Join(buildDir, ".npmrc")}).Return(nil),
+				)
+
+				Expect(npm.Rebuild(buildDir, []string{"bcrypt", "sharp"})).To(Succeed())
+				Expect(buffer.String()).To(ContainSubstring("Rebuilding the native modules bcrypt, sharp"))
+			})
+
+			It("only installs without any", func() {
+				mockCommand.EXPECT().Execute(buildDir, gomock.Any(), gomock.Any(), "npm", []string{"install", "--unsafe-perm", "--userconfig", filepath.Join(buildDir, ".npmrc")}).Return(nil)
+
+				Expect(npm.Rebuild(buildDir, []string{})).To(Succeed())
+				Expect(buffer.String()).NotTo(ContainSubstring("Rebuilding"))
+			})
+		})
+
 		Context("package.json does not exist", func() {
 			It("skips the install", func() {
-				Expect(npm.Rebuild(buildDir)).To(Succeed())
+				Expect(npm.Rebuild(buildDir, nil)).To(Succeed())
 				Expect(buffer.String()).To(ContainSubstring("Skipping (no package.json)"))
 			})
 		})
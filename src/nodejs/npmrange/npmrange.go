@@ -0,0 +1,34 @@
+// Package npmrange reads the version ranges of npm with the semver library.
+package npmrange
+
+import (
+	"strings"
+
+	"github.com/Masterminds/semver"
+)
+
+// Constraint converts an npm range, where whitespace separates the
+// comparators of a set, into the comma separated form of the semver library.
+func Constraint(npmRange string) (*semver.Constraints, error) {
+	var sets []string
+	for _, set := range strings.Split(npmRange, "||") {
+		set = strings.TrimSpace(set)
+		if strings.Contains(set, " - ") {
+			sets = append(sets, set)
+			continue
+		}
+
+		var comparators []string
+		operator := ""
+		for _, field := range strings.Fields(set) {
+			if strings.Trim(field, "<>=~^!") == "" {
+				operator += field
+				continue
+			}
+			comparators = append(comparators, operator+field)
+			operator = ""
+		}
+		sets = append(sets, strings.Join(comparators, ", "))
+	}
+	return semver.NewConstraint(strings.Join(sets, " || "))
+}
@@ -0,0 +1,13 @@
+package npmrange_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestNpmrange(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Npmrange Suite")
+}
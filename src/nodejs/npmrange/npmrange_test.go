@@ -0,0 +1,32 @@
+package npmrange_test
+
+import (
+	"nodejs/npmrange"
+
+	"github.com/Masterminds/semver"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Constraint", func() {
+	DescribeTable("matches like npm",
+		func(npmRange, version string, matches bool) {
+			c, err := npmrange.Constraint(npmRange)
+			Expect(err).To(BeNil())
+			Expect(c.Check(semver.MustParse(version))).To(Equal(matches))
+		},
+		Entry("a caret", "^4.17.0", "4.17.21", true),
+		Entry("comparators separated by whitespace", ">=14.18.0 <15.0.0", "14.18.0", true),
+		Entry("comparators separated by whitespace, above", ">=14.18.0 <15.0.0", "15.0.0", false),
+		Entry("an operator apart from its version", ">= 2.0.0 < 3", "2.5.0", true),
+		Entry("sets", "<1.0.0 || >=2.1.0 <2.2.0", "2.1.4", true),
+		Entry("sets, between", "<1.0.0 || >=2.1.0 <2.2.0", "1.5.0", false),
+		Entry("a hyphen range", "1.2.0 - 1.4.0", "1.3.0", true),
+	)
+
+	It("fails a range which is not one", func() {
+		_, err := npmrange.Constraint("latest")
+		Expect(err).NotTo(BeNil())
+	})
+})
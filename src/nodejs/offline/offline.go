@@ -0,0 +1,88 @@
+// Package offline decides once whether staging is offline, so that each tool
+// which would use the network, npx, prisma and the optional reports among
+// them, stays off it instead of hanging on connections which never succeed.
+package offline
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+)
+
+// Env is set to true in the env of every process of an offline staging,
+// those of the later buildpacks and finalize included.
+const Env = "BP_OFFLINE"
+
+// DefaultRegistry is the registry RegistryProbe checks without
+// npm_config_registry.
+const DefaultRegistry = "https://registry.npmjs.org/"
+
+// ProbeTimeout is how long RegistryProbe waits for an answer.
+const ProbeTimeout = 5 * time.Second
+
+// Probe returns an error when staging has no access to the internet.
+type Probe func() error
+
+// Stager writes the env of the later buildpacks.
+type Stager interface {
+	WriteEnvFile(string, string) error
+}
+
+// Enabled reports whether staging is offline.
+func Enabled() bool {
+	return os.Getenv(Env) == "true"
+}
+
+// Setup decides whether staging is offline, as BP_OFFLINE says when the app
+// sets it, otherwise when the buildpack is cached and probe fails: a cached
+// buildpack carries its dependencies for foundations without access to the
+// internet, but most run where the registry is reachable. An offline staging
+// has BP_OFFLINE=true from then on. The reason is "" for an online staging.
+func Setup(cached bool, probe Probe, stager Stager) (string, error) {
+	var reason string
+	switch value := os.Getenv(Env); value {
+	case "true":
+		reason = Env + "=true"
+	case "false":
+	case "":
+		if !cached {
+			break
+		}
+		if err := probe(); err != nil {
+			reason = err.Error()
+		}
+	default:
+		return "", fmt.Errorf("invalid %s %q, expected true or false", Env, value)
+	}
+	if reason == "" {
+		return "", nil
+	}
+	if err := os.Setenv(Env, "true"); err != nil {
+		return "", err
+	}
+	return reason, stager.WriteEnvFile(Env, "true")
+}
+
+// RegistryProbe checks that the registry of npm_config_registry, or the
+// public one, answers within ProbeTimeout. Any answer will do, the registry
+// may want credentials.
+func RegistryProbe() Probe {
+	registry := os.Getenv("npm_config_registry")
+	if registry == "" {
+		registry = DefaultRegistry
+	}
+	return URLProbe(&http.Client{Timeout: ProbeTimeout}, registry)
+}
+
+// URLProbe checks that url answers a HEAD request.
+func URLProbe(client *http.Client, url string) Probe {
+	return func() error {
+		resp, err := client.Head(url)
+		if err != nil {
+			return fmt.Errorf("no access to %s: %s", url, err)
+		}
+		resp.Body.Close()
+		return nil
+	}
+}
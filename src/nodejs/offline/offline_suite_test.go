@@ -0,0 +1,13 @@
+package offline_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestOffline(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Offline Suite")
+}
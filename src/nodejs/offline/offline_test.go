@@ -0,0 +1,127 @@
+package offline_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"nodejs/offline"
+	"os"
+	"path/filepath"
+
+	"github.com/cloudfoundry/libbuildpack"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Setup", func() {
+	var (
+		depsDir string
+		stager  *libbuildpack.Stager
+		oldEnv  string
+		hadEnv  bool
+	)
+
+	BeforeEach(func() {
+		var err error
+		depsDir, err = ioutil.TempDir("", "nodejs-buildpack.deps.")
+		Expect(err).To(BeNil())
+		Expect(os.MkdirAll(filepath.Join(depsDir, "0"), 0755)).To(Succeed())
+		stager = libbuildpack.NewStager([]string{"", "", depsDir, "0"}, libbuildpack.NewLogger(ioutil.Discard), &libbuildpack.Manifest{})
+
+		oldEnv, hadEnv = os.LookupEnv("BP_OFFLINE")
+		os.Unsetenv("BP_OFFLINE")
+	})
+
+	AfterEach(func() {
+		if hadEnv {
+			os.Setenv("BP_OFFLINE", oldEnv)
+		} else {
+			os.Unsetenv("BP_OFFLINE")
+		}
+		Expect(os.RemoveAll(depsDir)).To(Succeed())
+	})
+
+	envFile := func() string {
+		contents, err := ioutil.ReadFile(filepath.Join(depsDir, "0", "env", "BP_OFFLINE"))
+		if os.IsNotExist(err) {
+			return ""
+		}
+		Expect(err).To(BeNil())
+		return string(contents)
+	}
+
+	var probed bool
+	reachable := func() error {
+		probed = true
+		return nil
+	}
+	unreachable := func() error {
+		probed = true
+		return errors.New("no access to https://registry.npmjs.org/: dial tcp: i/o timeout")
+	}
+
+	BeforeEach(func() {
+		probed = false
+	})
+
+	It("is online with a cached buildpack which reaches the registry", func() {
+		Expect(offline.Setup(true, reachable, stager)).To(Equal(""))
+		Expect(probed).To(BeTrue())
+		Expect(offline.Enabled()).To(BeFalse())
+		Expect(envFile()).To(Equal(""))
+	})
+
+	It("is offline with a cached buildpack which does not reach the registry", func() {
+		Expect(offline.Setup(true, unreachable, stager)).To(Equal("no access to https://registry.npmjs.org/: dial tcp: i/o timeout"))
+		Expect(offline.Enabled()).To(BeTrue())
+		Expect(envFile()).To(Equal("true"))
+	})
+
+	It("is online with an uncached buildpack without probing", func() {
+		Expect(offline.Setup(false, unreachable, stager)).To(Equal(""))
+		Expect(probed).To(BeFalse())
+		Expect(offline.Enabled()).To(BeFalse())
+		Expect(envFile()).To(Equal(""))
+	})
+
+	It("is offline when BP_OFFLINE=true", func() {
+		os.Setenv("BP_OFFLINE", "true")
+		Expect(offline.Setup(false, reachable, stager)).To(Equal("BP_OFFLINE=true"))
+		Expect(probed).To(BeFalse())
+		Expect(envFile()).To(Equal("true"))
+	})
+
+	It("is online with a cached buildpack when BP_OFFLINE=false", func() {
+		os.Setenv("BP_OFFLINE", "false")
+		Expect(offline.Setup(true, unreachable, stager)).To(Equal(""))
+		Expect(probed).To(BeFalse())
+		Expect(offline.Enabled()).To(BeFalse())
+		Expect(envFile()).To(Equal(""))
+	})
+
+	It("rejects an invalid BP_OFFLINE", func() {
+		os.Setenv("BP_OFFLINE", "yes")
+		_, err := offline.Setup(true, reachable, stager)
+		Expect(err).To(MatchError(`invalid BP_OFFLINE "yes", expected true or false`))
+	})
+})
+
+var _ = Describe("URLProbe", func() {
+	It("succeeds on any answer", func() {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusUnauthorized)
+		}))
+		defer server.Close()
+
+		Expect(offline.URLProbe(server.Client(), server.URL)()).To(Succeed())
+	})
+
+	It("fails without an answer", func() {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		url := server.URL
+		server.Close()
+
+		Expect(offline.URLProbe(http.DefaultClient, url)()).To(MatchError(HavePrefix("no access to " + url + ": ")))
+	})
+})
@@ -0,0 +1,76 @@
+// Package operatordefaults loads the defaults a platform operator sets for
+// the apps of a foundation in place of those of the buildpack, without
+// repackaging it.
+package operatordefaults
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/Masterminds/semver"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// File is where the staging containers of a foundation have the defaults,
+// unless BP_DEFAULTS_FILE names another file, like
+//
+//	node: 20.x
+//	npm:
+//	  version: 10.x
+//	  legacy_peer_deps: true
+const File = "/var/vcap/packages/nodejs-buildpack-config/defaults.yml"
+
+// Defaults are the defaults of the operator, each of which applies to the
+// apps which set nothing of their own.
+type Defaults struct {
+	// Source is the file the defaults were read from.
+	Source string `yaml:"-"`
+	// Node is the node version of the apps which request none, in place of
+	// the default version of the manifest.
+	Node string `yaml:"node"`
+	NPM  struct {
+		// Version is the npm version of the apps without engines.npm, in
+		// place of the npm bundled with node.
+		Version string `yaml:"version"`
+		// LegacyPeerDeps is BP_NPM_LEGACY_PEER_DEPS of the apps which don't
+		// set it.
+		LegacyPeerDeps *bool `yaml:"legacy_peer_deps"`
+	} `yaml:"npm"`
+}
+
+// Load reads the defaults of BP_DEFAULTS_FILE or File, which need not exist.
+// As the defaults apply to every app of the foundation, a problem with them
+// is a warning rather than a failure: a file which is not valid is ignored
+// as a whole, an npm version which is not a range on its own.
+func Load() (Defaults, []string) {
+	path, explicit := File, false
+	if file := os.Getenv("BP_DEFAULTS_FILE"); file != "" {
+		path, explicit = file, true
+	}
+
+	contents, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) && !explicit {
+		return Defaults{}, nil
+	} else if err != nil {
+		return Defaults{}, []string{fmt.Sprintf("Ignoring the defaults of the platform operator, unable to read them: %s", err)}
+	}
+
+	var defaults Defaults
+	if err := yaml.UnmarshalStrict(contents, &defaults); err != nil {
+		return Defaults{}, []string{fmt.Sprintf("Ignoring the defaults of the platform operator, %s is not valid: %s", path, err)}
+	}
+	defaults.Source = path
+	defaults.Node = strings.TrimSpace(defaults.Node)
+	defaults.NPM.Version = strings.TrimSpace(defaults.NPM.Version)
+
+	var warnings []string
+	if defaults.NPM.Version != "" {
+		if _, err := semver.NewConstraint(defaults.NPM.Version); err != nil {
+			warnings = append(warnings, fmt.Sprintf("Ignoring the default npm version %s of %s, it is not a version range: %s", defaults.NPM.Version, path, err))
+			defaults.NPM.Version = ""
+		}
+	}
+	return defaults, warnings
+}
@@ -0,0 +1,13 @@
+package operatordefaults_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestOperatorDefaults(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "OperatorDefaults Suite")
+}
@@ -0,0 +1,92 @@
+package operatordefaults_test
+
+import (
+	"io/ioutil"
+	"nodejs/operatordefaults"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("OperatorDefaults", func() {
+	var (
+		dir     string
+		file    string
+		oldFile string
+	)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "nodejs-buildpack.defaults.")
+		Expect(err).To(BeNil())
+		file = filepath.Join(dir, "defaults.yml")
+
+		oldFile = os.Getenv("BP_DEFAULTS_FILE")
+		os.Setenv("BP_DEFAULTS_FILE", file)
+	})
+
+	AfterEach(func() {
+		os.Setenv("BP_DEFAULTS_FILE", oldFile)
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	write := func(contents string) {
+		Expect(ioutil.WriteFile(file, []byte(contents), 0644)).To(Succeed())
+	}
+
+	It("loads the defaults of BP_DEFAULTS_FILE", func() {
+		write("node: 20.x\nnpm:\n  version: 10.x\n  legacy_peer_deps: true\n")
+
+		defaults, warnings := operatordefaults.Load()
+		Expect(warnings).To(BeEmpty())
+		Expect(defaults.Source).To(Equal(file))
+		Expect(defaults.Node).To(Equal("20.x"))
+		Expect(defaults.NPM.Version).To(Equal("10.x"))
+		Expect(*defaults.NPM.LegacyPeerDeps).To(BeTrue())
+	})
+
+	It("has no defaults without the file at the well-known path", func() {
+		os.Unsetenv("BP_DEFAULTS_FILE")
+		Expect(operatordefaults.File).To(Equal("/var/vcap/packages/nodejs-buildpack-config/defaults.yml"))
+		if _, err := os.Stat(operatordefaults.File); err == nil {
+			Skip(operatordefaults.File + " exists")
+		}
+
+		defaults, warnings := operatordefaults.Load()
+		Expect(warnings).To(BeEmpty())
+		Expect(defaults).To(Equal(operatordefaults.Defaults{}))
+	})
+
+	It("warns when BP_DEFAULTS_FILE does not exist", func() {
+		defaults, warnings := operatordefaults.Load()
+		Expect(defaults).To(Equal(operatordefaults.Defaults{}))
+		Expect(warnings).To(ConsistOf(ContainSubstring("Ignoring the defaults of the platform operator, unable to read them: open " + file)))
+	})
+
+	It("ignores a file which is not valid", func() {
+		write("node: 20.x\nnpm:\n  legacy_peer_deps: maybe\n")
+
+		defaults, warnings := operatordefaults.Load()
+		Expect(defaults).To(Equal(operatordefaults.Defaults{}))
+		Expect(warnings).To(ConsistOf(ContainSubstring("Ignoring the defaults of the platform operator, " + file + " is not valid: ")))
+	})
+
+	It("ignores a file with unknown keys", func() {
+		write("node: 20.x\nyarn: 1.x\n")
+
+		defaults, warnings := operatordefaults.Load()
+		Expect(defaults).To(Equal(operatordefaults.Defaults{}))
+		Expect(warnings).To(ConsistOf(ContainSubstring("field yarn not found")))
+	})
+
+	It("ignores an npm version which is not a range", func() {
+		write("node: 20.x\nnpm:\n  version: ten\n")
+
+		defaults, warnings := operatordefaults.Load()
+		Expect(defaults.Node).To(Equal("20.x"))
+		Expect(defaults.NPM.Version).To(BeEmpty())
+		Expect(warnings).To(ConsistOf(ContainSubstring("Ignoring the default npm version ten of " + file + ", it is not a version range")))
+	})
+})
@@ -0,0 +1,243 @@
+package outdated
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"nodejs/network"
+	"nodejs/npmrange"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Masterminds/semver"
+)
+
+// Dependency is a direct dependency of the app, installed at Current from
+// the range Specifier.
+type Dependency struct {
+	Name      string
+	Current   string
+	Specifier string
+}
+
+// Result compares the installed version of a dependency with the newest
+// version its range allows, Wanted, and the latest version. Wanted is empty
+// when the specifier is not a semver range.
+type Result struct {
+	Name    string
+	Current string
+	Wanted  string
+	Latest  string
+}
+
+// Outdated reports whether a newer version than Current is wanted or latest.
+func (r Result) Outdated() bool {
+	return newer(r.Current, r.Latest) || newer(r.Current, r.Wanted)
+}
+
+func newer(current, other string) bool {
+	c, err := semver.NewVersion(current)
+	if err != nil {
+		return false
+	}
+	o, err := semver.NewVersion(other)
+	return err == nil && c.LessThan(o)
+}
+
+// Checker queries a registry for the versions of dependencies.
+type Checker struct {
+	// Registry returns the registry URL of a package.
+	Registry func(name string) string
+	Client   *http.Client
+	// Concurrency bounds the requests in flight.
+	Concurrency int
+	// Timeout bounds each request.
+	Timeout time.Duration
+}
+
+// abbreviatedMetadata is the install subset of a packument, which registries
+// serve for the Accept header below.
+type abbreviatedMetadata struct {
+	DistTags map[string]string          `json:"dist-tags"`
+	Versions map[string]json.RawMessage `json:"versions"`
+}
+
+const abbreviatedAccept = "application/vnd.npm.install-v1+json; q=1.0, application/json; q=0.8"
+
+// Check looks deps up in the registry until ctx is done, and returns the
+// results of the lookups which finished by then. Dependencies which fail to
+// resolve, like private packages or those of an unreachable registry, are
+// left out.
+func (c Checker) Check(ctx context.Context, deps []Dependency) []Result {
+	concurrency := c.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	var (
+		mu      sync.Mutex
+		results []Result
+		wg      sync.WaitGroup
+	)
+	slots := make(chan struct{}, concurrency)
+	for _, dep := range deps {
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		wg.Add(1)
+		go func(dep Dependency) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			result, err := c.check(ctx, dep)
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			results = append(results, result)
+			mu.Unlock()
+		}(dep)
+	}
+	wg.Wait()
+
+	sort.Slice(results, func(i, j int) bool { return results[i].Name < results[j].Name })
+	return results
+}
+
+func (c Checker) check(ctx context.Context, dep Dependency) (Result, error) {
+	current, err := semver.NewVersion(dep.Current)
+	if err != nil {
+		return Result{}, err
+	}
+
+	if c.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+	metadata, err := c.fetch(ctx, dep.Name)
+	if err != nil {
+		return Result{}, err
+	}
+
+	latest, found := metadata.DistTags["latest"]
+	if !found {
+		return Result{}, fmt.Errorf("%s has no latest version", dep.Name)
+	}
+	result := Result{Name: dep.Name, Current: current.String(), Latest: latest}
+
+	if allowed, err := npmrange.Constraint(dep.Specifier); err == nil {
+		var wanted *semver.Version
+		for version := range metadata.Versions {
+			v, err := semver.NewVersion(version)
+			if err != nil || !allowed.Check(v) {
+				continue
+			}
+			if wanted == nil || wanted.LessThan(v) {
+				wanted = v
+			}
+		}
+		if wanted != nil {
+			result.Wanted = wanted.String()
+		}
+	}
+	return result, nil
+}
+
+func (c Checker) fetch(ctx context.Context, name string) (abbreviatedMetadata, error) {
+	registry := strings.TrimSuffix(c.Registry(name), "/")
+	req, err := http.NewRequest("GET", registry+"/"+url.PathEscape(name), nil)
+	if err != nil {
+		return abbreviatedMetadata{}, err
+	}
+	req.Header.Set("Accept", abbreviatedAccept)
+
+	client := c.Client
+	if client == nil {
+		client = network.Client
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return abbreviatedMetadata{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return abbreviatedMetadata{}, fmt.Errorf("registry returned %s for %s", resp.Status, name)
+	}
+	var metadata abbreviatedMetadata
+	if err := json.NewDecoder(resp.Body).Decode(&metadata); err != nil {
+		return abbreviatedMetadata{}, err
+	}
+	return metadata, nil
+}
+
+// MostOutdated returns the outdated results, those behind by a major version
+// first, then by the most minor and patch versions, at most max of them.
+func MostOutdated(results []Result, max int) []Result {
+	var outdated []Result
+	for _, r := range results {
+		if r.Outdated() {
+			outdated = append(outdated, r)
+		}
+	}
+
+	sort.SliceStable(outdated, func(i, j int) bool {
+		a, b := gap(outdated[i]), gap(outdated[j])
+		for k := range a {
+			if a[k] != b[k] {
+				return a[k] > b[k]
+			}
+		}
+		return false
+	})
+	if max > 0 && len(outdated) > max {
+		outdated = outdated[:max]
+	}
+	return outdated
+}
+
+// gap is how far the current version is behind the latest, as the level of
+// the first differing part, 3 for major down to 1 for patch, and by how much.
+func gap(r Result) [2]int64 {
+	current, err := semver.NewVersion(r.Current)
+	if err != nil {
+		return [2]int64{}
+	}
+	latest, err := semver.NewVersion(r.Latest)
+	if err != nil || !current.LessThan(latest) {
+		return [2]int64{}
+	}
+	switch {
+	case latest.Major() != current.Major():
+		return [2]int64{3, latest.Major() - current.Major()}
+	case latest.Minor() != current.Minor():
+		return [2]int64{2, latest.Minor() - current.Minor()}
+	default:
+		return [2]int64{1, latest.Patch() - current.Patch()}
+	}
+}
+
+// Write writes results as a table like `npm outdated`.
+func Write(w io.Writer, results []Result) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "PACKAGE\tCURRENT\tWANTED\tLATEST")
+	for _, r := range results {
+		wanted := r.Wanted
+		if wanted == "" {
+			wanted = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", r.Name, r.Current, wanted, r.Latest)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,13 @@
+package outdated_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestOutdated(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Outdated Suite")
+}
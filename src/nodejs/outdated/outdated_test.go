@@ -0,0 +1,229 @@
+package outdated_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"nodejs/outdated"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Outdated", func() {
+	Describe("Checker", func() {
+		var (
+			server   *httptest.Server
+			checker  outdated.Checker
+			packages map[string]string
+			mu       sync.Mutex
+			requests []*http.Request
+			inFlight int
+			peak     int
+			delay    time.Duration
+			slow     string
+		)
+
+		BeforeEach(func() {
+			requests = nil
+			inFlight, peak, delay, slow = 0, 0, 0, ""
+			packages = map[string]string{
+				"/express":         `{"name":"express","dist-tags":{"latest":"5.0.1"},"versions":{"4.17.1":{},"4.21.2":{},"5.0.0":{},"5.0.1":{}}}`,
+				"/lodash":          `{"name":"lodash","dist-tags":{"latest":"4.17.21"},"versions":{"4.17.21":{}}}`,
+				"/@corp%2Fapi":     `{"name":"@corp/api","dist-tags":{"latest":"2.1.0"},"versions":{"1.0.0":{},"1.2.0":{},"2.1.0":{},"3.0.0-beta.1":{}}}`,
+				"/left-pad":        `{"name":"left-pad","dist-tags":{"latest":"1.3.0"},"versions":{"1.3.0":{}}}`,
+				"/broken-metadata": `{"name":`,
+			}
+			server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				requests = append(requests, r)
+				inFlight++
+				if inFlight > peak {
+					peak = inFlight
+				}
+				mu.Unlock()
+				defer func() {
+					mu.Lock()
+					inFlight--
+					mu.Unlock()
+				}()
+
+				if delay > 0 && (slow == "" || r.URL.EscapedPath() == slow) {
+					select {
+					case <-time.After(delay):
+					case <-r.Context().Done():
+						return
+					}
+				}
+				body, found := packages[r.URL.EscapedPath()]
+				if !found {
+					http.NotFound(w, r)
+					return
+				}
+				fmt.Fprint(w, body)
+			}))
+			checker = outdated.Checker{
+				Registry:    func(string) string { return server.URL + "/" },
+				Concurrency: 2,
+				Timeout:     time.Second,
+			}
+		})
+
+		AfterEach(func() {
+			server.Close()
+		})
+
+		It("compares the installed versions with the newest in range and the latest", func() {
+			results := checker.Check(context.Background(), []outdated.Dependency{
+				{Name: "express", Current: "4.17.1", Specifier: "^4.17.0"},
+				{Name: "lodash", Current: "4.17.21", Specifier: "~4.17.21"},
+				{Name: "@corp/api", Current: "1.0.0", Specifier: ">=1.0.0 <2.0.0"},
+				{Name: "left-pad", Current: "1.3.0", Specifier: "github:stevemao/left-pad"},
+			})
+			Expect(results).To(Equal([]outdated.Result{
+				{Name: "@corp/api", Current: "1.0.0", Wanted: "1.2.0", Latest: "2.1.0"},
+				{Name: "express", Current: "4.17.1", Wanted: "4.21.2", Latest: "5.0.1"},
+				{Name: "left-pad", Current: "1.3.0", Wanted: "", Latest: "1.3.0"},
+				{Name: "lodash", Current: "4.17.21", Wanted: "4.17.21", Latest: "4.17.21"},
+			}))
+		})
+
+		It("asks for the abbreviated metadata", func() {
+			checker.Check(context.Background(), []outdated.Dependency{{Name: "@corp/api", Current: "1.0.0", Specifier: "^1"}})
+			Expect(requests).To(HaveLen(1))
+			Expect(requests[0].Header.Get("Accept")).To(HavePrefix("application/vnd.npm.install-v1+json"))
+		})
+
+		It("queries the registry of each package", func() {
+			other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(w, `{"dist-tags":{"latest":"9.0.0"},"versions":{"9.0.0":{}}}`)
+			}))
+			defer other.Close()
+			checker.Registry = func(name string) string {
+				if strings.HasPrefix(name, "@corp/") {
+					return other.URL
+				}
+				return server.URL
+			}
+
+			results := checker.Check(context.Background(), []outdated.Dependency{
+				{Name: "@corp/api", Current: "1.0.0", Specifier: "^1"},
+				{Name: "lodash", Current: "4.17.21", Specifier: "^4"},
+			})
+			Expect(results).To(HaveLen(2))
+			Expect(results[0].Latest).To(Equal("9.0.0"))
+			Expect(results[1].Latest).To(Equal("4.17.21"))
+		})
+
+		It("leaves out the dependencies which fail to resolve", func() {
+			results := checker.Check(context.Background(), []outdated.Dependency{
+				{Name: "private", Current: "1.0.0", Specifier: "^1"},
+				{Name: "broken-metadata", Current: "1.0.0", Specifier: "^1"},
+				{Name: "lodash", Current: "linked", Specifier: "file:../lodash"},
+				{Name: "express", Current: "4.17.1", Specifier: "^4"},
+			})
+			Expect(results).To(HaveLen(1))
+			Expect(results[0].Name).To(Equal("express"))
+		})
+
+		It("returns nothing when the registry is unreachable", func() {
+			server.Close()
+			results := checker.Check(context.Background(), []outdated.Dependency{{Name: "express", Current: "4.17.1", Specifier: "^4"}})
+			Expect(results).To(BeEmpty())
+		})
+
+		It("bounds the requests in flight", func() {
+			delay = 20 * time.Millisecond
+			var deps []outdated.Dependency
+			for i := 0; i < 6; i++ {
+				deps = append(deps, outdated.Dependency{Name: "express", Current: "4.17.1", Specifier: "^4"})
+			}
+			Expect(checker.Check(context.Background(), deps)).To(HaveLen(6))
+			Expect(peak).To(BeNumerically("<=", 2))
+		})
+
+		It("gives up on a slow request after the request timeout", func() {
+			delay = 5 * time.Second
+			checker.Timeout = 50 * time.Millisecond
+
+			start := time.Now()
+			Expect(checker.Check(context.Background(), []outdated.Dependency{{Name: "express", Current: "4.17.1", Specifier: "^4"}})).To(BeEmpty())
+			Expect(time.Since(start)).To(BeNumerically("<", time.Second))
+		})
+
+		It("stops when the time budget runs out, keeping the results so far", func() {
+			delay, slow = 5*time.Second, "/express"
+			checker.Concurrency = 1
+			checker.Timeout = 0
+			deps := []outdated.Dependency{{Name: "lodash", Current: "4.17.21", Specifier: "^4"}}
+			for i := 0; i < 10; i++ {
+				deps = append(deps, outdated.Dependency{Name: "express", Current: "4.17.1", Specifier: "^4"})
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+			defer cancel()
+			start := time.Now()
+			results := checker.Check(ctx, deps)
+			Expect(time.Since(start)).To(BeNumerically("<", time.Second))
+			Expect(results).To(HaveLen(1))
+			Expect(results[0].Name).To(Equal("lodash"))
+
+			mu.Lock()
+			defer mu.Unlock()
+			Expect(requests).To(HaveLen(2))
+		})
+	})
+
+	Describe("MostOutdated", func() {
+		results := []outdated.Result{
+			{Name: "current", Current: "1.0.0", Wanted: "1.0.0", Latest: "1.0.0"},
+			{Name: "patch", Current: "1.0.0", Wanted: "1.0.3", Latest: "1.0.3"},
+			{Name: "minor", Current: "1.0.0", Wanted: "1.2.0", Latest: "1.2.0"},
+			{Name: "major", Current: "1.0.0", Wanted: "1.2.0", Latest: "2.0.0"},
+			{Name: "majors", Current: "1.0.0", Wanted: "1.0.0", Latest: "3.0.0"},
+			{Name: "in-range", Current: "2.0.0", Wanted: "2.0.1", Latest: "2.0.0"},
+			{Name: "ahead", Current: "3.0.0-beta.1", Wanted: "", Latest: "2.0.0"},
+		}
+
+		It("orders the outdated results by how far behind they are", func() {
+			var names []string
+			for _, r := range outdated.MostOutdated(results, 0) {
+				names = append(names, r.Name)
+			}
+			Expect(names).To(Equal([]string{"majors", "major", "minor", "patch", "in-range"}))
+		})
+
+		It("caps the results", func() {
+			Expect(outdated.MostOutdated(results, 2)).To(HaveLen(2))
+		})
+	})
+
+	DescribeTable("Outdated",
+		func(current, wanted, latest string, expected bool) {
+			Expect(outdated.Result{Current: current, Wanted: wanted, Latest: latest}.Outdated()).To(Equal(expected))
+		},
+		Entry("up to date", "1.0.0", "1.0.0", "1.0.0", false),
+		Entry("behind latest", "1.0.0", "1.0.0", "2.0.0", true),
+		Entry("behind wanted", "1.0.0", "1.0.1", "1.0.0", true),
+		Entry("a prerelease ahead of latest", "2.0.0-rc.1", "", "1.9.0", false),
+		Entry("not a range", "1.0.0", "", "1.0.0", false),
+	)
+
+	It("writes a table", func() {
+		buffer := new(bytes.Buffer)
+		Expect(outdated.Write(buffer, []outdated.Result{
+			{Name: "express", Current: "4.17.1", Wanted: "4.21.2", Latest: "5.0.1"},
+			{Name: "left-pad", Current: "1.2.0", Latest: "1.3.0"},
+		})).To(Succeed())
+		Expect(buffer.String()).To(Equal("" +
+			"PACKAGE   CURRENT  WANTED  LATEST\n" +
+			"express   4.17.1   4.21.2  5.0.1\n" +
+			"left-pad  1.2.0    -       1.3.0\n"))
+	})
+})
@@ -0,0 +1,167 @@
+// Package packagejson is the one model of the package.json of an app which
+// both phases read, so that they agree on what it says.
+package packagejson
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/cloudfoundry/libbuildpack"
+)
+
+const (
+	// File is the package.json of an app.
+	File = "package.json"
+	// ParsedFile keeps the package.json which supply parsed in the dep dir,
+	// so that finalize reads the same, even when the build scripts changed
+	// the file since.
+	ParsedFile = "package.json.parsed"
+)
+
+// Engines are the versions of node and the package managers the app asks for.
+type Engines struct {
+	Node   string `json:"node,omitempty"`
+	NPM    string `json:"npm,omitempty"`
+	Yarn   string `json:"yarn,omitempty"`
+	Iojs   string `json:"iojs,omitempty"`
+	Python string `json:"python,omitempty"`
+}
+
+// Volta are the versions of node and the package managers Volta pins the
+// app to.
+type Volta struct {
+	Node string `json:"node,omitempty"`
+	NPM  string `json:"npm,omitempty"`
+	Yarn string `json:"yarn,omitempty"`
+}
+
+// Workspaces are the globs of the workspaces of a package, written as a list
+// or as the packages of an object, like yarn 1 has it. Any other form is
+// taken as no workspaces.
+type Workspaces []string
+
+func (w *Workspaces) UnmarshalJSON(data []byte) error {
+	var patterns []string
+	if err := json.Unmarshal(data, &patterns); err != nil {
+		var object struct {
+			Packages []string `json:"packages"`
+		}
+		if err := json.Unmarshal(data, &object); err != nil {
+			*w = nil
+			return nil
+		}
+		patterns = object.Packages
+	}
+	*w = patterns
+	return nil
+}
+
+// Package is every field of package.json the buildpack reads.
+type Package struct {
+	Name                 string            `json:"name,omitempty"`
+	Version              string            `json:"version,omitempty"`
+	Type                 string            `json:"type,omitempty"`
+	Main                 string            `json:"main,omitempty"`
+	Exports              json.RawMessage   `json:"exports,omitempty"`
+	PackageManager       string            `json:"packageManager,omitempty"`
+	Engines              Engines           `json:"engines"`
+	Volta                Volta             `json:"volta"`
+	Scripts              map[string]string `json:"scripts,omitempty"`
+	Dependencies         map[string]string `json:"dependencies,omitempty"`
+	DevDependencies      map[string]string `json:"devDependencies,omitempty"`
+	OptionalDependencies map[string]string `json:"optionalDependencies,omitempty"`
+	Workspaces           Workspaces        `json:"workspaces,omitempty"`
+	// CFProcesses are the commands of the process types of the app by
+	// name, like those of a Procfile.
+	CFProcesses map[string]string `json:"cfProcesses,omitempty"`
+}
+
+// HasExports returns whether the package has exports, which make node
+// resolve the package by them rather than by main.
+func (p Package) HasExports() bool {
+	return len(p.Exports) > 0 && string(p.Exports) != "null"
+}
+
+// Depends returns whether the package depends on name, as a dependency or a
+// devDependency.
+func (p Package) Depends(name string) bool {
+	_, dependency := p.Dependencies[name]
+	_, devDependency := p.DevDependencies[name]
+	return dependency || devDependency
+}
+
+// Parse parses the package.json in contents. Its errors name the line and
+// column of invalid JSON, and the field of a value of the wrong type.
+func Parse(contents []byte) (Package, error) {
+	var p Package
+	// Editors on Windows save package.json with a byte order mark, which
+	// npm skips.
+	contents = bytes.TrimPrefix(contents, []byte("\xef\xbb\xbf"))
+	if err := json.Unmarshal(contents, &p); err != nil {
+		switch err := err.(type) {
+		case *json.SyntaxError:
+			line, column := position(contents, err.Offset)
+			return Package{}, fmt.Errorf("%s is not valid JSON at line %d, column %d: %s", File, line, column, err)
+		case *json.UnmarshalTypeError:
+			line, column := position(contents, err.Offset)
+			return Package{}, fmt.Errorf("%s has a %s for %s at line %d, column %d, where it needs a %s", File, err.Value, err.Field, line, column, err.Type)
+		}
+		return Package{}, fmt.Errorf("unable to parse %s: %s", File, err)
+	}
+	return p, nil
+}
+
+// position returns the line and column of the byte before offset in
+// contents, where encoding/json stopped, counting from one.
+func position(contents []byte, offset int64) (int, int) {
+	if offset > 0 {
+		offset--
+	}
+	if offset > int64(len(contents)) {
+		offset = int64(len(contents))
+	}
+	before := contents[:offset]
+	line := bytes.Count(before, []byte("\n")) + 1
+	column := len(before) - bytes.LastIndexByte(before, '\n')
+	return line, column
+}
+
+// Load parses the package.json of appDir. It returns whether there is one,
+// and an empty Package when there isn't.
+func Load(appDir string) (Package, bool, error) {
+	contents, err := ioutil.ReadFile(filepath.Join(appDir, File))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return Package{}, false, nil
+		}
+		return Package{}, false, err
+	}
+	p, err := Parse(contents)
+	return p, err == nil, err
+}
+
+// Save keeps p in depDir for LoadStaged.
+func Save(depDir string, p Package) error {
+	return libbuildpack.NewJSON().Write(filepath.Join(depDir, ParsedFile), p)
+}
+
+// LoadStaged returns the package.json which supply kept in depDir, or else
+// parses that of appDir.
+func LoadStaged(depDir, appDir string) (Package, bool, error) {
+	contents, err := ioutil.ReadFile(filepath.Join(depDir, ParsedFile))
+	if os.IsNotExist(err) {
+		return Load(appDir)
+	} else if err != nil {
+		return Package{}, false, err
+	}
+
+	var p Package
+	if err := json.Unmarshal(contents, &p); err != nil {
+		return Package{}, false, fmt.Errorf("unable to parse %s: %s", filepath.Join(depDir, ParsedFile), err)
+	}
+	return p, true, nil
+}
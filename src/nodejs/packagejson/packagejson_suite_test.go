@@ -0,0 +1,13 @@
+package packagejson_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestPackagejson(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Packagejson Suite")
+}
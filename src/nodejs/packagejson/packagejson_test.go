@@ -0,0 +1,137 @@
+package packagejson_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"nodejs/packagejson"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Package", func() {
+	const full = `{
+  "name": "app",
+  "version": "1.2.3",
+  "type": "module",
+  "main": "server.js",
+  "exports": {".": "./server.js"},
+  "packageManager": "yarn@4.1.0",
+  "engines": {"node": "20.x", "npm": "10.x", "yarn": "4.x", "python": "3.12"},
+  "volta": {"node": "20.11.1", "yarn": "4.1.0"},
+  "scripts": {"start": "node server.js", "heroku-postbuild": "npm run build"},
+  "dependencies": {"express": "^4.19.2"},
+  "devDependencies": {"typescript": "^5.4.0"},
+  "optionalDependencies": {"fsevents": "^2.3.3"},
+  "workspaces": ["packages/*"],
+  "cfProcesses": {"web": "node server.js", "worker": "node worker.js"},
+  "private": true
+}`
+
+	expected := packagejson.Package{
+		Name:                 "app",
+		Version:              "1.2.3",
+		Type:                 "module",
+		Main:                 "server.js",
+		Exports:              json.RawMessage(`{".": "./server.js"}`),
+		PackageManager:       "yarn@4.1.0",
+		Engines:              packagejson.Engines{Node: "20.x", NPM: "10.x", Yarn: "4.x", Python: "3.12"},
+		Volta:                packagejson.Volta{Node: "20.11.1", Yarn: "4.1.0"},
+		Scripts:              map[string]string{"start": "node server.js", "heroku-postbuild": "npm run build"},
+		Dependencies:         map[string]string{"express": "^4.19.2"},
+		DevDependencies:      map[string]string{"typescript": "^5.4.0"},
+		OptionalDependencies: map[string]string{"fsevents": "^2.3.3"},
+		Workspaces:           packagejson.Workspaces{"packages/*"},
+		CFProcesses:          map[string]string{"web": "node server.js", "worker": "node worker.js"},
+	}
+
+	It("parses every field the buildpack reads", func() {
+		Expect(packagejson.Parse([]byte(full))).To(Equal(expected))
+	})
+
+	It("skips a byte order mark", func() {
+		Expect(packagejson.Parse([]byte("\xef\xbb\xbf" + full))).To(Equal(expected))
+	})
+
+	DescribeTable("Workspaces",
+		func(workspaces string, expected packagejson.Workspaces) {
+			p, err := packagejson.Parse([]byte(`{"workspaces": ` + workspaces + `}`))
+			Expect(err).To(BeNil())
+			Expect(p.Workspaces).To(Equal(expected))
+		},
+		Entry("a list", `["packages/*", "apps/web"]`, packagejson.Workspaces{"packages/*", "apps/web"}),
+		Entry("the object of yarn 1", `{"packages": ["packages/*"], "nohoist": ["**/react"]}`, packagejson.Workspaces{"packages/*"}),
+		Entry("anything else", `"packages/*"`, packagejson.Workspaces(nil)),
+	)
+
+	It("tells exports and dependencies apart", func() {
+		p, err := packagejson.Parse([]byte(`{"exports": null, "dependencies": {"next": "14.2.3"}, "devDependencies": {"prisma": "5.13.0"}}`))
+		Expect(err).To(BeNil())
+		Expect(p.HasExports()).To(BeFalse())
+		Expect(expected.HasExports()).To(BeTrue())
+		Expect(p.Depends("next")).To(BeTrue())
+		Expect(p.Depends("prisma")).To(BeTrue())
+		Expect(p.Depends("react")).To(BeFalse())
+	})
+
+	DescribeTable("Parse reports where package.json is wrong",
+		func(contents, message string) {
+			_, err := packagejson.Parse([]byte(contents))
+			Expect(err).To(MatchError(ContainSubstring(message)))
+		},
+		Entry("invalid JSON", "{\n  \"name\": \"app\",\n}", `package.json is not valid JSON at line 3, column 1: invalid character '}' looking for beginning of object key string`),
+		Entry("a value of the wrong type", "{\n  \"scripts\": {\"start\": 1}\n}", "start at line 2, column 24, where it needs a string"),
+	)
+
+	Describe("Load", func() {
+		var (
+			err    error
+			appDir string
+			depDir string
+		)
+
+		BeforeEach(func() {
+			appDir, err = ioutil.TempDir("", "nodejs-buildpack.app.")
+			Expect(err).To(BeNil())
+			depDir, err = ioutil.TempDir("", "nodejs-buildpack.dep.")
+			Expect(err).To(BeNil())
+		})
+
+		AfterEach(func() {
+			Expect(os.RemoveAll(appDir)).To(Succeed())
+			Expect(os.RemoveAll(depDir)).To(Succeed())
+		})
+
+		It("returns an empty package without package.json", func() {
+			p, found, err := packagejson.Load(appDir)
+			Expect(err).To(BeNil())
+			Expect(found).To(BeFalse())
+			Expect(p).To(Equal(packagejson.Package{}))
+		})
+
+		It("reads the package.json supply kept rather than the app's", func() {
+			Expect(ioutil.WriteFile(filepath.Join(appDir, "package.json"), []byte(full), 0644)).To(Succeed())
+			p, found, err := packagejson.Load(appDir)
+			Expect(err).To(BeNil())
+			Expect(found).To(BeTrue())
+
+			staged, found, err := packagejson.LoadStaged(depDir, appDir)
+			Expect(err).To(BeNil())
+			Expect(found).To(BeTrue())
+			Expect(staged).To(Equal(expected))
+
+			Expect(packagejson.Save(depDir, p)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(appDir, "package.json"), []byte(`{"name": "changed by the build"}`), 0644)).To(Succeed())
+			staged, found, err = packagejson.LoadStaged(depDir, appDir)
+			Expect(err).To(BeNil())
+			Expect(found).To(BeTrue())
+			Expect(staged.Name).To(Equal("app"))
+			Expect(staged.Scripts).To(Equal(expected.Scripts))
+			Expect(staged.Workspaces).To(Equal(expected.Workspaces))
+			Expect(staged.HasExports()).To(BeTrue())
+		})
+	})
+})
@@ -0,0 +1,87 @@
+// Package pkgmanager describes the commands of the package managers as
+// data. npm and yarn build their commands from a typed request in one
+// place, their executors only run what was built, and the dry run renders
+// the commands exactly as staging runs them.
+package pkgmanager
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Install is a request to install the dependencies of the app in BuildDir.
+type Install struct {
+	BuildDir string
+	// CacheDir is where downloads are cached, or empty for the default of
+	// the package manager.
+	CacheDir string
+	// NodeDir has the headers native modules are built against.
+	NodeDir string
+	// Offline installs from the cache or offline mirror only.
+	Offline bool
+	// LegacyPeerDeps installs peer dependency conflicts the way npm 6 did.
+	LegacyPeerDeps bool
+	// Berry is a project of yarn 2 and later.
+	Berry bool
+	// Env is added to the environment of the install.
+	Env []string
+}
+
+// Rebuild is a request to rebuild the native modules of a vendored
+// node_modules, and then install what it lacks. A nil Packages rebuilds
+// every package, otherwise only those listed, and none for an empty list.
+type Rebuild struct {
+	Install
+	Packages []string
+}
+
+// Builder builds the commands of a package manager for a request.
+type Builder interface {
+	Install(req Install) []Invocation
+}
+
+// Invocation is a command of a package manager.
+type Invocation struct {
+	Program string
+	Args    []string
+	Dir     string
+	// Env is added to the environment of staging.
+	Env []string
+	// Quiet commands, like setting config, don't log their output.
+	Quiet bool
+}
+
+var shellSafe = regexp.MustCompile(`^[\w@%+=:,./-]+$`)
+
+// String renders the invocation as a shell command line, with its env.
+func (i Invocation) String() string {
+	var words []string
+	for _, env := range i.Env {
+		kv := strings.SplitN(env, "=", 2)
+		if len(kv) == 2 {
+			words = append(words, kv[0]+"="+quote(kv[1]))
+		}
+	}
+	words = append(words, quote(i.Program))
+	for _, arg := range i.Args {
+		words = append(words, quote(arg))
+	}
+	return strings.Join(words, " ")
+}
+
+// RenderPlan renders the invocations one per line, for the dry run and the
+// tests which assert the exact commands.
+func RenderPlan(invocations []Invocation) []string {
+	lines := []string{}
+	for _, invocation := range invocations {
+		lines = append(lines, invocation.String())
+	}
+	return lines
+}
+
+func quote(word string) string {
+	if shellSafe.MatchString(word) {
+		return word
+	}
+	return "'" + strings.Replace(word, "'", `'\''`, -1) + "'"
+}
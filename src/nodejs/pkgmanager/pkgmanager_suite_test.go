@@ -0,0 +1,13 @@
+package pkgmanager_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestPkgmanager(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Pkgmanager Suite")
+}
@@ -0,0 +1,29 @@
+package pkgmanager_test
+
+import (
+	"nodejs/pkgmanager"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Invocation", func() {
+	DescribeTable("String",
+		func(invocation pkgmanager.Invocation, expected string) {
+			Expect(invocation.String()).To(Equal(expected))
+		},
+		Entry("a plain command", pkgmanager.Invocation{Program: "npm", Args: []string{"install", "--cache", "/tmp/cache/.npm"}}, "npm install --cache /tmp/cache/.npm"),
+		Entry("with env", pkgmanager.Invocation{Program: "yarn", Args: []string{"install"}, Env: []string{"npm_config_nodedir=/deps/0/node", "YARN_ENABLE_GLOBAL_CACHE=false"}}, "npm_config_nodedir=/deps/0/node YARN_ENABLE_GLOBAL_CACHE=false yarn install"),
+		Entry("with words to quote", pkgmanager.Invocation{Program: "yarn", Args: []string{"config", "set", "cache-folder", "/app dir/it's"}, Env: []string{"A=$HOME"}}, `A='$HOME' yarn config set cache-folder '/app dir/it'\''s'`),
+		Entry("with scoped packages", pkgmanager.Invocation{Program: "npm", Args: []string{"rebuild", "@scope/pkg", "--nodedir=/deps/0/node"}}, "npm rebuild @scope/pkg --nodedir=/deps/0/node"),
+	)
+
+	It("renders a plan one command per line", func() {
+		Expect(pkgmanager.RenderPlan(nil)).To(Equal([]string{}))
+		Expect(pkgmanager.RenderPlan([]pkgmanager.Invocation{
+			{Program: "npm", Args: []string{"rebuild"}},
+			{Program: "npm", Args: []string{"install"}},
+		})).To(Equal([]string{"npm rebuild", "npm install"}))
+	})
+})
@@ -0,0 +1,197 @@
+package precompress
+
+import (
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// Extension is appended to the path of a file for its gzip variant, which
+// express-static-gzip, serve-static and nginx gzip_static look for.
+const Extension = ".gz"
+
+// MinSize is the size in bytes below which files are left alone, since the
+// gzip header and the extra request outweigh the savings.
+const MinSize = 1024
+
+// Skip lists the extensions of formats which are compressed already.
+var Skip = []string{
+	".png", ".jpg", ".jpeg", ".gif", ".webp", ".avif", ".ico",
+	".woff", ".woff2",
+	".mp3", ".mp4", ".webm", ".ogg",
+	".zip", ".gz", ".br", ".tgz", ".bz2", ".xz", ".7z",
+}
+
+// Report sums up the files compressed below the dirs.
+type Report struct {
+	// Files is how many files have a gzip variant now.
+	Files int
+	// Skipped is how many files are below MinSize, compressed already,
+	// have a gzip variant already or would not shrink.
+	Skipped int
+	// Size and Compressed are the sizes of the compressed files before and
+	// after.
+	Size       int64
+	Compressed int64
+}
+
+// Saved is how many bytes the gzip variants save.
+func (r Report) Saved() int64 {
+	return r.Size - r.Compressed
+}
+
+type job struct {
+	path string
+	size int64
+	// compressed is filled in by the workers, 0 when the file was skipped.
+	compressed int64
+}
+
+// Compress writes a gzip variant next to every regular file below root of at
+// least minSize bytes, leaving the originals as they are. Files of the Skip
+// extensions, files with a variant already and files which would not shrink
+// are skipped. Files are compressed by workers in parallel, a workers of 0
+// uses one per cpu.
+func Compress(root string, minSize int64, workers int) (Report, error) {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+
+	var report Report
+	var jobs []*job
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		if info.Size() < minSize || skipped(path) {
+			report.Skipped++
+			return nil
+		}
+		if _, err := os.Lstat(path + Extension); err == nil {
+			report.Skipped++
+			return nil
+		}
+		jobs = append(jobs, &job{path: path, size: info.Size()})
+		return nil
+	})
+	if err != nil {
+		return Report{}, err
+	}
+
+	if err := compressFiles(jobs, workers); err != nil {
+		return Report{}, err
+	}
+
+	for _, j := range jobs {
+		if j.compressed == 0 {
+			report.Skipped++
+			continue
+		}
+		report.Files++
+		report.Size += j.size
+		report.Compressed += j.compressed
+	}
+	return report, nil
+}
+
+func skipped(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	for _, skip := range Skip {
+		if ext == skip {
+			return true
+		}
+	}
+	return false
+}
+
+func compressFiles(files []*job, workers int) error {
+	jobs := make(chan *job)
+	errs := make(chan error, workers)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				compressed, err := compressFile(j.path, j.size)
+				if err != nil {
+					errs <- err
+					// Drain the remaining jobs so that the sender finishes.
+					for range jobs {
+					}
+					return
+				}
+				j.compressed = compressed
+			}
+		}()
+	}
+
+	for _, j := range files {
+		jobs <- j
+	}
+	close(jobs)
+	wg.Wait()
+	close(errs)
+	return <-errs
+}
+
+// compressFile writes the gzip variant of path through a temp file, so that
+// a failed build leaves no truncated variant behind, and returns its size, or
+// 0 when it would not be smaller than size. The variant gets the mtime of the
+// original, which servers compare to pick it.
+func compressFile(path string, size int64) (int64, error) {
+	source, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer source.Close()
+	info, err := source.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	temp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(temp.Name())
+	defer temp.Close()
+
+	writer, err := gzip.NewWriterLevel(temp, gzip.BestCompression)
+	if err != nil {
+		return 0, err
+	}
+	writer.Name = filepath.Base(path)
+	writer.ModTime = info.ModTime()
+	if _, err := io.Copy(writer, source); err != nil {
+		return 0, err
+	}
+	if err := writer.Close(); err != nil {
+		return 0, err
+	}
+	compressed, err := temp.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+	if compressed >= size {
+		return 0, nil
+	}
+	if err := temp.Chmod(info.Mode().Perm()); err != nil {
+		return 0, err
+	}
+	if err := temp.Close(); err != nil {
+		return 0, err
+	}
+	if err := os.Chtimes(temp.Name(), info.ModTime(), info.ModTime()); err != nil {
+		return 0, err
+	}
+	return compressed, os.Rename(temp.Name(), path+Extension)
+}
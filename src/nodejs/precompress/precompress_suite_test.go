@@ -0,0 +1,13 @@
+package precompress_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestPrecompress(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Precompress Suite")
+}
@@ -0,0 +1,133 @@
+package precompress_test
+
+import (
+	"compress/gzip"
+	"crypto/rand"
+	"fmt"
+	"io/ioutil"
+	"nodejs/precompress"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Compress", func() {
+	var (
+		err  error
+		root string
+	)
+
+	writeFile := func(path string, contents []byte) {
+		path = filepath.Join(root, path)
+		Expect(os.MkdirAll(filepath.Dir(path), 0755)).To(Succeed())
+		Expect(ioutil.WriteFile(path, contents, 0644)).To(Succeed())
+	}
+
+	text := func(size int) []byte {
+		return []byte(strings.Repeat("a", size))
+	}
+
+	gunzip := func(path string) []byte {
+		f, err := os.Open(path)
+		Expect(err).To(BeNil())
+		defer f.Close()
+		reader, err := gzip.NewReader(f)
+		Expect(err).To(BeNil())
+		contents, err := ioutil.ReadAll(reader)
+		Expect(err).To(BeNil())
+		return contents
+	}
+
+	BeforeEach(func() {
+		root, err = ioutil.TempDir("", "nodejs-buildpack.precompress.")
+		Expect(err).To(BeNil())
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(root)).To(Succeed())
+	})
+
+	It("compresses the files of at least the min size and leaves the originals alone", func() {
+		writeFile("app.js", text(4096))
+		writeFile("css/site.css", text(1024))
+		writeFile("robots.txt", text(1023))
+		mtime := time.Now().Add(-time.Hour).Truncate(time.Second)
+		Expect(os.Chtimes(filepath.Join(root, "app.js"), mtime, mtime)).To(Succeed())
+
+		report, err := precompress.Compress(root, 1024, 0)
+		Expect(err).To(BeNil())
+		Expect(report.Files).To(Equal(2))
+		Expect(report.Skipped).To(Equal(1))
+		Expect(report.Size).To(Equal(int64(5120)))
+		Expect(report.Saved()).To(Equal(report.Size - report.Compressed))
+		Expect(report.Compressed).To(BeNumerically("<", 200))
+
+		Expect(ioutil.ReadFile(filepath.Join(root, "app.js"))).To(Equal(text(4096)))
+		Expect(gunzip(filepath.Join(root, "app.js.gz"))).To(Equal(text(4096)))
+		info, err := os.Stat(filepath.Join(root, "app.js.gz"))
+		Expect(err).To(BeNil())
+		Expect(info.ModTime().Equal(mtime)).To(BeTrue())
+		Expect(gunzip(filepath.Join(root, "css", "site.css.gz"))).To(Equal(text(1024)))
+		Expect(filepath.Join(root, "robots.txt.gz")).NotTo(BeAnExistingFile())
+	})
+
+	It("skips compressed formats, existing variants and files which would not shrink", func() {
+		random := make([]byte, 4096)
+		_, err := rand.Read(random)
+		Expect(err).To(BeNil())
+		writeFile("logo.PNG", text(4096))
+		writeFile("fonts/inter.woff2", text(4096))
+		writeFile("bundle.js", text(4096))
+		writeFile("bundle.js.gz", []byte("committed"))
+		writeFile("data.bin", random)
+
+		report, err := precompress.Compress(root, 1024, 0)
+		Expect(err).To(BeNil())
+		Expect(report).To(Equal(precompress.Report{Skipped: 5}))
+		Expect(ioutil.ReadFile(filepath.Join(root, "bundle.js.gz"))).To(Equal([]byte("committed")))
+		for _, path := range []string{"logo.PNG.gz", "fonts/inter.woff2.gz", "data.bin.gz"} {
+			Expect(filepath.Join(root, path)).NotTo(BeAnExistingFile())
+		}
+
+		files, err := ioutil.ReadDir(root)
+		Expect(err).To(BeNil())
+		Expect(files).To(HaveLen(5))
+	})
+
+	It("compresses every file once across worker counts", func() {
+		for i := 0; i < 200; i++ {
+			writeFile(fmt.Sprintf("chunks/%03d.js", i), []byte(strings.Repeat(fmt.Sprintf("chunk %d;", i), 200)))
+		}
+
+		var first precompress.Report
+		for _, workers := range []int{1, 3, 16} {
+			matches, err := filepath.Glob(filepath.Join(root, "chunks", "*.gz"))
+			Expect(err).To(BeNil())
+			for _, match := range matches {
+				Expect(os.Remove(match)).To(Succeed())
+			}
+
+			report, err := precompress.Compress(root, 1024, workers)
+			Expect(err).To(BeNil())
+			Expect(report.Files).To(Equal(200))
+			if workers == 1 {
+				first = report
+			}
+			Expect(report).To(Equal(first))
+
+			for i := 0; i < 200; i++ {
+				path := filepath.Join(root, fmt.Sprintf("chunks/%03d.js", i))
+				Expect(gunzip(path + ".gz")).To(Equal([]byte(strings.Repeat(fmt.Sprintf("chunk %d;", i), 200))))
+			}
+		}
+	})
+
+	It("fails for a missing root", func() {
+		_, err := precompress.Compress(filepath.Join(root, "dist"), 1024, 0)
+		Expect(os.IsNotExist(err)).To(BeTrue())
+	})
+})
@@ -0,0 +1,115 @@
+// Package proctree reads the tree of processes below a process from /proc,
+// to tell what a command left running and to end all of it.
+package proctree
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// Process is a process of a Tree.
+type Process struct {
+	PID  int
+	Args []string
+	// Depth is how far below the root of the tree the process is.
+	Depth int
+}
+
+// Tree is a process and its descendants, each parent before its children.
+type Tree []Process
+
+// Snapshot returns the tree of pid below procDir, empty when pid has exited.
+// Processes which exit while they are read, or have exited but were not
+// reaped yet, are left out.
+func Snapshot(procDir string, pid int) Tree {
+	entries, err := ioutil.ReadDir(procDir)
+	if err != nil {
+		return nil
+	}
+	children := map[int][]int{}
+	running := map[int]bool{}
+	for _, entry := range entries {
+		child, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		if state, parent, ok := stat(procDir, child); ok && state != "Z" {
+			children[parent] = append(children[parent], child)
+			running[child] = true
+		}
+	}
+
+	var tree Tree
+	var walk func(pid, depth int)
+	walk = func(pid, depth int) {
+		args, ok := commandLine(procDir, pid)
+		if !running[pid] || !ok {
+			return
+		}
+		tree = append(tree, Process{PID: pid, Args: args, Depth: depth})
+		sort.Ints(children[pid])
+		for _, child := range children[pid] {
+			walk(child, depth+1)
+		}
+	}
+	walk(pid, 0)
+	return tree
+}
+
+// String lists the processes, one per line, indented below their parents.
+func (t Tree) String() string {
+	lines := make([]string, len(t))
+	for i, p := range t {
+		lines[i] = fmt.Sprintf("%s%d %s", strings.Repeat("  ", p.Depth), p.PID, strings.Join(p.Args, " "))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Kill sends signal to the process groups the processes lead and to each
+// process, so that what they started since the snapshot goes too.
+func (t Tree) Kill(signal syscall.Signal) {
+	for _, p := range t {
+		if pgid, err := syscall.Getpgid(p.PID); err == nil && pgid == p.PID {
+			syscall.Kill(-pgid, signal)
+		}
+		syscall.Kill(p.PID, signal)
+	}
+}
+
+// stat reads the state and the parent of pid from its stat, whose second
+// field, the name of the program, may have spaces and parentheses of its own.
+func stat(procDir string, pid int) (string, int, bool) {
+	contents, err := ioutil.ReadFile(filepath.Join(procDir, strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return "", 0, false
+	}
+	stat := string(contents)
+	fields := strings.Fields(stat[strings.LastIndex(stat, ")")+1:])
+	if len(fields) < 2 {
+		return "", 0, false
+	}
+	parent, err := strconv.Atoi(fields[1])
+	return fields[0], parent, err == nil
+}
+
+// commandLine reads the arguments of pid, or the name of the program in
+// brackets, like ps, for a process without any such as a kernel thread.
+func commandLine(procDir string, pid int) ([]string, bool) {
+	contents, err := ioutil.ReadFile(filepath.Join(procDir, strconv.Itoa(pid), "cmdline"))
+	if err != nil {
+		return nil, false
+	}
+	if args := strings.Split(strings.TrimRight(string(contents), "\x00"), "\x00"); args[0] != "" {
+		return args, true
+	}
+	comm, err := ioutil.ReadFile(filepath.Join(procDir, strconv.Itoa(pid), "comm"))
+	if err != nil {
+		return nil, false
+	}
+	return []string{"[" + strings.TrimSpace(string(comm)) + "]"}, true
+}
@@ -0,0 +1,13 @@
+package proctree_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestProcTree(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "ProcTree Suite")
+}
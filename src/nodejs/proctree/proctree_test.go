@@ -0,0 +1,83 @@
+package proctree_test
+
+import (
+	"bufio"
+	"io/ioutil"
+	"nodejs/proctree"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"syscall"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ProcTree", func() {
+	var cmd *exec.Cmd
+
+	BeforeEach(func() {
+		cmd = exec.Command("testdata/spawn.sh")
+		stdout, err := cmd.StdoutPipe()
+		Expect(err).To(BeNil())
+		Expect(cmd.Start()).To(Succeed())
+		line, err := bufio.NewReader(stdout).ReadString('\n')
+		Expect(err).To(BeNil())
+		Expect(line).To(Equal("started\n"))
+	})
+
+	AfterEach(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+
+	It("lists the descendants of a process", func() {
+		var tree proctree.Tree
+		Eventually(func() int {
+			tree = proctree.Snapshot("/proc", cmd.Process.Pid)
+			return len(tree)
+		}).Should(Equal(3))
+
+		Expect(tree[0]).To(Equal(proctree.Process{PID: cmd.Process.Pid, Args: []string{"/bin/sh", "testdata/spawn.sh"}, Depth: 0}))
+		Expect(tree[1].Args).To(Equal([]string{"sh", "-c", "sleep 600; echo done"}))
+		Expect(tree[1].Depth).To(Equal(1))
+		Expect(tree[2].Args).To(Equal([]string{"sleep", "600"}))
+		Expect(tree[2].Depth).To(Equal(2))
+
+		Expect(tree.String()).To(Equal(
+			strconv.Itoa(tree[0].PID) + " /bin/sh testdata/spawn.sh\n" +
+				"  " + strconv.Itoa(tree[1].PID) + " sh -c sleep 600; echo done\n" +
+				"    " + strconv.Itoa(tree[2].PID) + " sleep 600"))
+	})
+
+	It("kills the processes of the tree", func() {
+		var tree proctree.Tree
+		Eventually(func() int {
+			tree = proctree.Snapshot("/proc", cmd.Process.Pid)
+			return len(tree)
+		}).Should(Equal(3))
+
+		tree.Kill(syscall.SIGKILL)
+		Expect(cmd.Wait()).To(HaveOccurred())
+		Eventually(func() proctree.Tree {
+			return proctree.Snapshot("/proc", tree[2].PID)
+		}).Should(BeEmpty())
+	})
+
+	It("is empty for a process which exited", func() {
+		Expect(proctree.Snapshot("/proc", 1<<30)).To(BeEmpty())
+	})
+
+	It("names a process without arguments by its program", func() {
+		procDir, err := ioutil.TempDir("", "nodejs-buildpack.proc.")
+		Expect(err).To(BeNil())
+		defer os.RemoveAll(procDir)
+		Expect(os.MkdirAll(filepath.Join(procDir, "42"), 0755)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(procDir, "42", "stat"), []byte("42 (kworker/0:1) I 2 0 0"), 0644)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(procDir, "42", "cmdline"), nil, 0644)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(procDir, "42", "comm"), []byte("kworker/0:1\n"), 0644)).To(Succeed())
+
+		Expect(proctree.Snapshot(procDir, 42)).To(Equal(proctree.Tree{{PID: 42, Args: []string{"[kworker/0:1]"}}}))
+	})
+})
@@ -0,0 +1,5 @@
+#!/bin/sh
+# Starts a child, which starts a sleeping grandchild, and waits for it.
+sh -c 'sleep 600; echo done' &
+echo started
+wait
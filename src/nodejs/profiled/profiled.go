@@ -0,0 +1,151 @@
+package profiled
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Prefix names the scripts of this buildpack among those of the other
+// buildpacks, which all land in profile.d directories sourced in order.
+const Prefix = "nodejs_buildpack_"
+
+type Stager interface {
+	DepDir() string
+	DepsIdx() string
+}
+
+// Name returns the name of script in profile.d, prefixed with the deps index
+// padded to three digits and Prefix, so that it neither collides with the
+// scripts of other buildpacks nor changes order among ours.
+func Name(depsIdx, script string) string {
+	if idx, err := strconv.Atoi(depsIdx); err == nil {
+		depsIdx = fmt.Sprintf("%03d", idx)
+	}
+	return depsIdx + "_" + Prefix + script
+}
+
+// Write writes script to the profile.d directory of stager under Name. It
+// leaves a script with the same contents untouched, so that staging twice
+// with the same inputs produces the same droplet.
+func Write(stager Stager, script, contents string) error {
+	dir := filepath.Join(stager.DepDir(), "profile.d")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	path := filepath.Join(dir, Name(stager.DepsIdx(), script))
+	if existing, err := ioutil.ReadFile(path); err == nil && string(existing) == contents {
+		return os.Chmod(path, 0755)
+	}
+	return ioutil.WriteFile(path, []byte(contents), 0755)
+}
+
+// Collision is a variable which our profile.d scripts and those of another
+// buildpack both export, so that whichever is sourced last wins.
+type Collision struct {
+	Variable string
+	Ours     []string
+	Theirs   []string
+}
+
+var exportLine = regexp.MustCompile(`^\s*export\s+([A-Za-z_][A-Za-z0-9_]*)=(.*)$`)
+
+// Collisions compares the variables exported by the profile.d scripts of the
+// deps dir depsIdx with those of the other deps dirs in depsDir. PATH, and
+// variables which every script extends or defaults from their previous
+// value, are additions rather than collisions.
+func Collisions(depsDir, depsIdx string) ([]Collision, error) {
+	entries, err := ioutil.ReadDir(depsDir)
+	if err != nil {
+		return nil, err
+	}
+
+	ours := map[string][]string{}
+	theirs := map[string][]string{}
+	extends := map[string]bool{}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		exports := theirs
+		if entry.Name() == depsIdx {
+			exports = ours
+		}
+		if err := scanExports(depsDir, entry.Name(), exports, extends); err != nil {
+			return nil, err
+		}
+	}
+
+	var collisions []Collision
+	for _, variable := range sortedKeys(ours) {
+		if variable == "PATH" || extends[variable] || len(theirs[variable]) == 0 {
+			continue
+		}
+		collisions = append(collisions, Collision{Variable: variable, Ours: ours[variable], Theirs: theirs[variable]})
+	}
+	return collisions, nil
+}
+
+// scanExports records, for every variable the scripts in the profile.d of the
+// deps dir idx export, the scripts relative to depsDir. extends[variable]
+// stays true while every export refers to the previous value.
+func scanExports(depsDir, idx string, exports map[string][]string, extends map[string]bool) error {
+	dir := filepath.Join(depsDir, idx, "profile.d")
+	scripts, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	for _, script := range scripts {
+		if script.IsDir() || !strings.HasSuffix(script.Name(), ".sh") {
+			continue
+		}
+		contents, err := ioutil.ReadFile(filepath.Join(dir, script.Name()))
+		if err != nil {
+			return err
+		}
+		name := filepath.Join(idx, "profile.d", script.Name())
+
+		scanner := bufio.NewScanner(bytes.NewReader(contents))
+		for scanner.Scan() {
+			m := exportLine.FindStringSubmatch(scanner.Text())
+			if m == nil {
+				continue
+			}
+			variable, value := m[1], m[2]
+			self := strings.Contains(value, "$"+variable) || strings.Contains(value, "${"+variable)
+			if previous, found := extends[variable]; found {
+				extends[variable] = previous && self
+			} else {
+				extends[variable] = self
+			}
+			if n := len(exports[variable]); n == 0 || exports[variable][n-1] != name {
+				exports[variable] = append(exports[variable], name)
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func sortedKeys(m map[string][]string) []string {
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
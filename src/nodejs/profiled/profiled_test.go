@@ -0,0 +1,112 @@
+package profiled_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"nodejs/profiled"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/cloudfoundry/libbuildpack/ansicleaner"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Profiled", func() {
+	var depsDir string
+
+	writeFile := func(path, contents string) {
+		path = filepath.Join(depsDir, path)
+		Expect(os.MkdirAll(filepath.Dir(path), 0755)).To(Succeed())
+		Expect(ioutil.WriteFile(path, []byte(contents), 0755)).To(Succeed())
+	}
+
+	BeforeEach(func() {
+		var err error
+		depsDir, err = ioutil.TempDir("", "nodejs-buildpack.deps.")
+		Expect(err).To(BeNil())
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(depsDir)).To(Succeed())
+	})
+
+	DescribeTable("Name",
+		func(depsIdx, script, name string) {
+			Expect(profiled.Name(depsIdx, script)).To(Equal(name))
+		},
+		Entry("the first buildpack", "0", "node.sh", "000_nodejs_buildpack_node.sh"),
+		Entry("a later buildpack", "12", "browsers.sh", "012_nodejs_buildpack_browsers.sh"),
+		Entry("a padded index", "07", "node.sh", "007_nodejs_buildpack_node.sh"),
+		Entry("an index which is not a number", "x", "node.sh", "x_nodejs_buildpack_node.sh"),
+	)
+
+	Describe("Write", func() {
+		var (
+			stager *libbuildpack.Stager
+			path   string
+		)
+
+		BeforeEach(func() {
+			logger := libbuildpack.NewLogger(ansicleaner.New(new(bytes.Buffer)))
+			stager = libbuildpack.NewStager([]string{"", "", depsDir, "1"}, logger, &libbuildpack.Manifest{})
+			path = filepath.Join(depsDir, "1", "profile.d", "001_nodejs_buildpack_node.sh")
+		})
+
+		It("writes an executable script under the namespaced name", func() {
+			Expect(profiled.Write(stager, "node.sh", "export NODE_HOME=/node\n")).To(Succeed())
+			Expect(ioutil.ReadFile(path)).To(Equal([]byte("export NODE_HOME=/node\n")))
+			fi, err := os.Stat(path)
+			Expect(err).To(BeNil())
+			Expect(fi.Mode().Perm()).To(Equal(os.FileMode(0755)))
+			Expect(filepath.Join(depsDir, "1", "profile.d", "node.sh")).NotTo(BeAnExistingFile())
+		})
+
+		It("leaves a script with the same contents untouched", func() {
+			Expect(profiled.Write(stager, "node.sh", "export NODE_HOME=/node\n")).To(Succeed())
+			past := time.Now().Add(-time.Hour).Truncate(time.Second)
+			Expect(os.Chtimes(path, past, past)).To(Succeed())
+
+			Expect(profiled.Write(stager, "node.sh", "export NODE_HOME=/node\n")).To(Succeed())
+			fi, err := os.Stat(path)
+			Expect(err).To(BeNil())
+			Expect(fi.ModTime()).To(Equal(past))
+		})
+
+		It("rewrites a script with other contents", func() {
+			Expect(profiled.Write(stager, "node.sh", "export NODE_HOME=/node\n")).To(Succeed())
+			Expect(profiled.Write(stager, "node.sh", "export NODE_HOME=/other\n")).To(Succeed())
+			Expect(ioutil.ReadFile(path)).To(Equal([]byte("export NODE_HOME=/other\n")))
+		})
+	})
+
+	Describe("Collisions", func() {
+		BeforeEach(func() {
+			writeFile("1/profile.d/001_nodejs_buildpack_node.sh", "export NODE_HOME=$DEPS_DIR/1/node\nexport NODE_ENV=${NODE_ENV:-production}\nexport PATH=$PATH:$NODE_HOME/bin\n")
+			writeFile("1/profile.d/001_nodejs_buildpack_node_metrics.sh", `export NODE_OPTIONS="${NODE_OPTIONS:+$NODE_OPTIONS }--require preload.js"`+"\n")
+		})
+
+		It("finds nothing without other buildpacks", func() {
+			Expect(profiled.Collisions(depsDir, "1")).To(BeEmpty())
+		})
+
+		It("finds the variables which another buildpack exports as well", func() {
+			writeFile("0/profile.d/node.sh", "  export NODE_HOME=/opt/node\nexport PATH=/opt/node/bin:$PATH\n")
+			writeFile("2/profile.d/apm.sh", "export NODE_HOME=/apm/node\nexport NODE_OPTIONS=--require=apm\n")
+			writeFile("2/profile.d/README", "export NODE_ENV=development\n")
+
+			Expect(profiled.Collisions(depsDir, "1")).To(Equal([]profiled.Collision{
+				{Variable: "NODE_HOME", Ours: []string{"1/profile.d/001_nodejs_buildpack_node.sh"}, Theirs: []string{"0/profile.d/node.sh", "2/profile.d/apm.sh"}},
+				{Variable: "NODE_OPTIONS", Ours: []string{"1/profile.d/001_nodejs_buildpack_node_metrics.sh"}, Theirs: []string{"2/profile.d/apm.sh"}},
+			}))
+		})
+
+		It("ignores variables which every script extends", func() {
+			writeFile("2/profile.d/apm.sh", `export NODE_OPTIONS="$NODE_OPTIONS --require apm"`+"\nexport NODE_ENV=${NODE_ENV:-staging}\n")
+			Expect(profiled.Collisions(depsDir, "1")).To(BeEmpty())
+		})
+	})
+})
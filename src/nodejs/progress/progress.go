@@ -0,0 +1,116 @@
+// Package progress tells how far an install has come, counting the packages
+// npm or yarn have written to node_modules against those of the lockfile.
+package progress
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultInterval is how often a Reporter reports at most.
+	DefaultInterval = 10 * time.Second
+
+	// MaxDirs is how many dirs a count reads at most, so that counting stays
+	// cheap however large node_modules grows.
+	MaxDirs = 20000
+)
+
+// Count returns how many packages nodeModules has, those of scopes and of
+// nested node_modules included, reading at most maxDirs dirs. A package
+// counts once its package.json is written. Linked packages count without
+// what they have in their own node_modules.
+func Count(nodeModules string, maxDirs int) int {
+	count, dirs := 0, 0
+	var walk func(dir string)
+	walk = func(dir string) {
+		if dirs >= maxDirs {
+			return
+		}
+		dirs++
+		entries, err := ioutil.ReadDir(dir)
+		if err != nil {
+			return
+		}
+		for _, entry := range entries {
+			name := entry.Name()
+			// .bin, .cache and the metadata of npm and yarn.
+			if strings.HasPrefix(name, ".") {
+				continue
+			}
+			path := filepath.Join(dir, name)
+			if strings.HasPrefix(name, "@") && entry.IsDir() {
+				walk(path)
+				continue
+			}
+			if _, err := os.Stat(filepath.Join(path, "package.json")); err != nil {
+				continue
+			}
+			count++
+			nested := filepath.Join(path, "node_modules")
+			if info, err := os.Lstat(nested); err == nil && info.IsDir() && entry.IsDir() {
+				walk(nested)
+			}
+		}
+	}
+	walk(nodeModules)
+	return count
+}
+
+// Clock tells the time of the reports.
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+// Reporter reports how many of the packages of the lockfile are installed,
+// at most once per Interval and only when more were installed since.
+type Reporter struct {
+	NodeModules string
+	// Expected is the number of packages of the lockfile.
+	Expected int
+	Interval time.Duration
+	MaxDirs  int
+	Clock    Clock
+
+	mu        sync.Mutex
+	last      time.Time
+	installed int
+}
+
+// New returns a Reporter of the install into nodeModules of expected
+// packages.
+func New(nodeModules string, expected int) *Reporter {
+	return &Reporter{NodeModules: nodeModules, Expected: expected, Interval: DefaultInterval, MaxDirs: MaxDirs, Clock: systemClock{}}
+}
+
+// Line returns the line of progress, like "installed 412/1290 packages",
+// when a report is due. Installs which write nothing to node_modules, like
+// those of Plug'n'Play, have none.
+func (r *Reporter) Line() (string, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := r.Clock.Now()
+	if !r.last.IsZero() && now.Sub(r.last) < r.Interval {
+		return "", false
+	}
+	r.last = now
+
+	installed := Count(r.NodeModules, r.MaxDirs)
+	if installed > r.Expected {
+		installed = r.Expected
+	}
+	if installed <= r.installed {
+		return "", false
+	}
+	r.installed = installed
+	return fmt.Sprintf("installed %d/%d packages", installed, r.Expected), true
+}
@@ -0,0 +1,137 @@
+package progress_test
+
+import (
+	"io/ioutil"
+	"nodejs/progress"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+func (c *fakeClock) Advance(d time.Duration) { c.now = c.now.Add(d) }
+
+var _ = Describe("Progress", func() {
+	var nodeModules string
+
+	install := func(path string) {
+		dir := filepath.Join(nodeModules, filepath.FromSlash(path))
+		Expect(os.MkdirAll(dir, 0755)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(dir, "package.json"), []byte(`{}`), 0644)).To(Succeed())
+	}
+
+	BeforeEach(func() {
+		dir, err := ioutil.TempDir("", "nodejs-buildpack.progress.")
+		Expect(err).To(BeNil())
+		nodeModules = filepath.Join(dir, "node_modules")
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(filepath.Dir(nodeModules))).To(Succeed())
+	})
+
+	Describe("Count", func() {
+		It("counts the packages, those of scopes and nested node_modules included", func() {
+			install("express")
+			install("express/node_modules/debug")
+			install("@babel/core")
+			install("@babel/core/node_modules/@babel/types")
+			Expect(progress.Count(nodeModules, progress.MaxDirs)).To(Equal(4))
+		})
+
+		It("leaves out packages whose package.json is not written yet and the dirs of the tools", func() {
+			install("express")
+			Expect(os.MkdirAll(filepath.Join(nodeModules, "lodash"), 0755)).To(Succeed())
+			install(".cache/some-tool")
+			Expect(os.MkdirAll(filepath.Join(nodeModules, ".bin"), 0755)).To(Succeed())
+			Expect(progress.Count(nodeModules, progress.MaxDirs)).To(Equal(1))
+		})
+
+		It("counts linked packages without following them", func() {
+			install("express")
+			Expect(os.Symlink(filepath.Join(nodeModules, "express"), filepath.Join(nodeModules, "linked"))).To(Succeed())
+			Expect(os.Symlink(nodeModules, filepath.Join(nodeModules, "express", "node_modules"))).To(Succeed())
+			Expect(progress.Count(nodeModules, progress.MaxDirs)).To(Equal(2))
+		})
+
+		It("reads at most the given number of dirs", func() {
+			install("a")
+			install("a/node_modules/b")
+			install("a/node_modules/b/node_modules/c")
+			Expect(progress.Count(nodeModules, 2)).To(Equal(2))
+		})
+
+		It("is 0 without node_modules", func() {
+			Expect(progress.Count(nodeModules, progress.MaxDirs)).To(Equal(0))
+		})
+	})
+
+	Describe("Reporter", func() {
+		var (
+			clock    *fakeClock
+			reporter *progress.Reporter
+		)
+
+		BeforeEach(func() {
+			clock = &fakeClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+			reporter = progress.New(nodeModules, 3)
+			reporter.Clock = clock
+		})
+
+		line := func() string {
+			line, due := reporter.Line()
+			Expect(due).To(BeTrue())
+			return line
+		}
+
+		It("reports the installed packages against those of the lockfile", func() {
+			install("express")
+			Expect(line()).To(Equal("installed 1/3 packages"))
+		})
+
+		It("reports at most once per interval", func() {
+			install("express")
+			Expect(line()).To(Equal("installed 1/3 packages"))
+
+			install("lodash")
+			clock.Advance(9 * time.Second)
+			_, due := reporter.Line()
+			Expect(due).To(BeFalse())
+
+			clock.Advance(time.Second)
+			Expect(line()).To(Equal("installed 2/3 packages"))
+		})
+
+		It("reports only when more packages were installed", func() {
+			install("express")
+			Expect(line()).To(Equal("installed 1/3 packages"))
+
+			clock.Advance(time.Minute)
+			_, due := reporter.Line()
+			Expect(due).To(BeFalse())
+		})
+
+		It("reports no more packages than the lockfile has", func() {
+			for _, name := range []string{"a", "b", "c", "d"} {
+				install(name)
+			}
+			Expect(line()).To(Equal("installed 3/3 packages"))
+		})
+
+		It("reports nothing for installs without node_modules, like Plug'n'Play", func() {
+			_, due := reporter.Line()
+			Expect(due).To(BeFalse())
+			clock.Advance(time.Minute)
+			_, due = reporter.Line()
+			Expect(due).To(BeFalse())
+		})
+	})
+})
@@ -0,0 +1,249 @@
+package prune
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"nodejs/packagejson"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// BinRepair counts the changes RepairBinLinks made to the .bin dirs.
+type BinRepair struct {
+	Removed int
+	Linked  int
+}
+
+// RepairBinLinks fixes the .bin dirs in the node_modules of appDir and of its
+// workspaces once pruning is done. Pruning can leave links to the executables
+// of the packages it removed, and a node_modules restored from the cache can
+// lack the links of installed packages. Dangling links are removed and the
+// executables declared in the bin field of installed packages are linked
+// again, leaving alone the links which already exist.
+func RepairBinLinks(appDir string) (BinRepair, error) {
+	var repair BinRepair
+	workspaces, err := workspaceDirs(appDir)
+	if err != nil {
+		return repair, err
+	}
+	for _, dir := range append([]string{appDir}, workspaces...) {
+		if err := repair.nodeModules(filepath.Join(dir, "node_modules")); err != nil {
+			return repair, err
+		}
+	}
+	return repair, nil
+}
+
+// nodeModules repairs the .bin dir of one node_modules, then those of the
+// node_modules nested in its packages.
+func (r *BinRepair) nodeModules(dir string) error {
+	packages, err := packageDirs(dir)
+	if err != nil {
+		return err
+	}
+
+	binDir := filepath.Join(dir, ".bin")
+	removed, err := removeDanglingLinks(binDir)
+	if err != nil {
+		return err
+	}
+	r.Removed += removed
+
+	for _, pkg := range packages {
+		bins, err := readBin(pkg.path)
+		if err != nil {
+			return err
+		}
+		names := make([]string, 0, len(bins))
+		for name := range bins {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			linked, err := linkBin(binDir, name, filepath.Join(pkg.path, bins[name]))
+			if err != nil {
+				return err
+			}
+			if linked {
+				r.Linked++
+			}
+		}
+
+		// Linked packages, such as workspaces, are repaired from their own
+		// dir rather than through the link.
+		if !pkg.symlink {
+			if err := r.nodeModules(filepath.Join(pkg.path, "node_modules")); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+type packageDir struct {
+	path    string
+	symlink bool
+}
+
+// packageDirs returns the packages installed in nodeModules, including
+// scoped ones, in the order of their names.
+func packageDirs(nodeModules string) ([]packageDir, error) {
+	entries, err := ioutil.ReadDir(nodeModules)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var dirs []packageDir
+	for _, entry := range entries {
+		dir := filepath.Join(nodeModules, entry.Name())
+		if strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		if strings.HasPrefix(entry.Name(), "@") && entry.IsDir() {
+			scoped, err := packageDirs(dir)
+			if err != nil {
+				return nil, err
+			}
+			dirs = append(dirs, scoped...)
+			continue
+		}
+		symlink := entry.Mode()&os.ModeSymlink != 0
+		if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+			continue
+		}
+		dirs = append(dirs, packageDir{path: dir, symlink: symlink})
+	}
+	return dirs, nil
+}
+
+// removeDanglingLinks removes the links in binDir whose target is gone.
+func removeDanglingLinks(binDir string) (int, error) {
+	entries, err := ioutil.ReadDir(binDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	removed := 0
+	for _, entry := range entries {
+		if entry.Mode()&os.ModeSymlink == 0 {
+			continue
+		}
+		link := filepath.Join(binDir, entry.Name())
+		if _, err := os.Stat(link); err == nil {
+			continue
+		} else if !os.IsNotExist(err) {
+			return removed, err
+		}
+		if err := os.Remove(link); err != nil {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// readBin returns the executables a package declares in the bin field of its
+// package.json, by the name of their link. A single executable is named
+// after the package, without its scope.
+func readBin(pkgDir string) (map[string]string, error) {
+	contents, err := ioutil.ReadFile(filepath.Join(pkgDir, "package.json"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var pkg struct {
+		Name string          `json:"name"`
+		Bin  json.RawMessage `json:"bin"`
+	}
+	if err := json.Unmarshal(contents, &pkg); err != nil || len(pkg.Bin) == 0 {
+		return nil, nil
+	}
+
+	declared := map[string]string{}
+	var single string
+	if err := json.Unmarshal(pkg.Bin, &single); err == nil {
+		if pkg.Name == "" {
+			return nil, nil
+		}
+		declared[pkg.Name] = single
+	} else if err := json.Unmarshal(pkg.Bin, &declared); err != nil {
+		return nil, nil
+	}
+
+	bins := map[string]string{}
+	for name, target := range declared {
+		name = path.Base(name)
+		target = path.Clean(filepath.ToSlash(target))
+		if name == "." || name == ".." || name == "/" || target == "." || strings.HasPrefix(target, "../") || path.IsAbs(target) {
+			continue
+		}
+		bins[name] = filepath.FromSlash(target)
+	}
+	return bins, nil
+}
+
+// linkBin links name in binDir to the executable at target, unless the link
+// exists or the executable is missing, and makes the executable executable
+// like npm does.
+func linkBin(binDir, name, target string) (bool, error) {
+	link := filepath.Join(binDir, name)
+	if _, err := os.Lstat(link); err == nil {
+		return false, nil
+	} else if !os.IsNotExist(err) {
+		return false, err
+	}
+	info, err := os.Stat(target)
+	if err != nil || info.IsDir() {
+		return false, nil
+	}
+
+	if err := os.MkdirAll(binDir, 0755); err != nil {
+		return false, err
+	}
+	rel, err := filepath.Rel(binDir, target)
+	if err != nil {
+		return false, err
+	}
+	if err := os.Symlink(rel, link); err != nil {
+		return false, err
+	}
+	return true, os.Chmod(target, info.Mode().Perm()|0111)
+}
+
+// workspaceDirs returns the dirs matched by the workspaces field of the
+// package.json of appDir, in either its list or its object form.
+func workspaceDirs(appDir string) ([]string, error) {
+	pkg, _, err := packagejson.Load(appDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var dirs []string
+	seen := map[string]bool{}
+	for _, pattern := range pkg.Workspaces {
+		// Glob has no **, which matches a single level here.
+		matches, err := filepath.Glob(filepath.Join(appDir, filepath.FromSlash(strings.Replace(pattern, "**", "*", -1))))
+		if err != nil {
+			return nil, err
+		}
+		for _, match := range matches {
+			if _, err := os.Stat(filepath.Join(match, "package.json")); err != nil || seen[match] {
+				continue
+			}
+			seen[match] = true
+			dirs = append(dirs, match)
+		}
+	}
+	return dirs, nil
+}
@@ -0,0 +1,115 @@
+package prune_test
+
+import (
+	"io/ioutil"
+	"nodejs/prune"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("RepairBinLinks", func() {
+	var (
+		err    error
+		appDir string
+	)
+
+	write := func(path, contents string, mode os.FileMode) {
+		path = filepath.Join(appDir, path)
+		Expect(os.MkdirAll(filepath.Dir(path), 0755)).To(Succeed())
+		Expect(ioutil.WriteFile(path, []byte(contents), mode)).To(Succeed())
+	}
+	symlink := func(target, path string) {
+		path = filepath.Join(appDir, path)
+		Expect(os.MkdirAll(filepath.Dir(path), 0755)).To(Succeed())
+		Expect(os.Symlink(target, path)).To(Succeed())
+	}
+	readlink := func(path string) string {
+		target, err := os.Readlink(filepath.Join(appDir, path))
+		Expect(err).To(BeNil())
+		return target
+	}
+
+	BeforeEach(func() {
+		appDir, err = ioutil.TempDir("", "nodejs-buildpack.app.")
+		Expect(err).To(BeNil())
+		write("package.json", `{"name":"app"}`, 0644)
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(appDir)).To(Succeed())
+	})
+
+	It("removes dangling links and keeps the others", func() {
+		write("node_modules/express/package.json", `{"name":"express"}`, 0644)
+		write("node_modules/rimraf/package.json", `{"name":"rimraf","bin":"bin.js"}`, 0644)
+		write("node_modules/rimraf/bin.js", "", 0755)
+		symlink("../rimraf/bin.js", "node_modules/.bin/rimraf")
+		symlink("../mocha/bin/mocha", "node_modules/.bin/mocha")
+		symlink("../mocha/bin/_mocha", "node_modules/.bin/_mocha")
+		write("node_modules/.bin/script", "", 0755)
+
+		Expect(prune.RepairBinLinks(appDir)).To(Equal(prune.BinRepair{Removed: 2}))
+		Expect(filepath.Join(appDir, "node_modules", ".bin", "mocha")).NotTo(BeAnExistingFile())
+		Expect(filepath.Join(appDir, "node_modules", ".bin", "rimraf")).To(BeAnExistingFile())
+		Expect(filepath.Join(appDir, "node_modules", ".bin", "script")).To(BeAnExistingFile())
+	})
+
+	It("links missing executables of the string and map forms of bin", func() {
+		write("node_modules/rimraf/package.json", `{"name":"rimraf","bin":"./bin.js"}`, 0644)
+		write("node_modules/rimraf/bin.js", "", 0644)
+		write("node_modules/typescript/package.json", `{"name":"typescript","bin":{"tsc":"bin/tsc","tsserver":"bin/tsserver"}}`, 0644)
+		write("node_modules/typescript/bin/tsc", "", 0755)
+		write("node_modules/typescript/bin/tsserver", "", 0755)
+		symlink("../typescript/bin/tsc", "node_modules/.bin/tsc")
+
+		Expect(prune.RepairBinLinks(appDir)).To(Equal(prune.BinRepair{Linked: 2}))
+		Expect(readlink("node_modules/.bin/rimraf")).To(Equal(filepath.Join("..", "rimraf", "bin.js")))
+		Expect(readlink("node_modules/.bin/tsserver")).To(Equal(filepath.Join("..", "typescript", "bin", "tsserver")))
+
+		info, err := os.Stat(filepath.Join(appDir, "node_modules", "rimraf", "bin.js"))
+		Expect(err).To(BeNil())
+		Expect(info.Mode().Perm()).To(Equal(os.FileMode(0755)))
+	})
+
+	It("names the executable of a scoped package after the package without its scope", func() {
+		write("node_modules/@angular/cli/package.json", `{"name":"@angular/cli","bin":{"ng":"./bin/ng.js"}}`, 0644)
+		write("node_modules/@angular/cli/bin/ng.js", "", 0755)
+		write("node_modules/@acme/tool/package.json", `{"name":"@acme/tool","bin":"cli.js"}`, 0644)
+		write("node_modules/@acme/tool/cli.js", "", 0755)
+
+		Expect(prune.RepairBinLinks(appDir)).To(Equal(prune.BinRepair{Linked: 2}))
+		Expect(readlink("node_modules/.bin/ng")).To(Equal(filepath.Join("..", "@angular", "cli", "bin", "ng.js")))
+		Expect(readlink("node_modules/.bin/tool")).To(Equal(filepath.Join("..", "@acme", "tool", "cli.js")))
+	})
+
+	It("skips executables which are missing or outside the package", func() {
+		write("node_modules/broken/package.json", `{"name":"broken","bin":{"gone":"bin/gone","escape":"../rimraf/bin.js"}}`, 0644)
+		write("node_modules/rimraf/bin.js", "", 0755)
+
+		Expect(prune.RepairBinLinks(appDir)).To(Equal(prune.BinRepair{}))
+		Expect(filepath.Join(appDir, "node_modules", ".bin")).NotTo(BeAnExistingFile())
+	})
+
+	It("repairs nested node_modules and those of workspaces", func() {
+		write("package.json", `{"name":"app","workspaces":{"packages":["packages/*"]}}`, 0644)
+		write("packages/api/package.json", `{"name":"api"}`, 0644)
+		symlink("../packages/api", "node_modules/api")
+		symlink("../jest/bin/jest.js", "packages/api/node_modules/.bin/jest")
+		write("packages/api/node_modules/nodemon/package.json", `{"name":"nodemon","bin":{"nodemon":"bin/nodemon.js"}}`, 0644)
+		write("packages/api/node_modules/nodemon/bin/nodemon.js", "", 0755)
+		write("node_modules/webpack/package.json", `{"name":"webpack"}`, 0644)
+		write("node_modules/webpack/node_modules/semver/package.json", `{"name":"semver","bin":{"semver":"bin/semver.js"}}`, 0644)
+		write("node_modules/webpack/node_modules/semver/bin/semver.js", "", 0755)
+
+		Expect(prune.RepairBinLinks(appDir)).To(Equal(prune.BinRepair{Removed: 1, Linked: 2}))
+		Expect(readlink("packages/api/node_modules/.bin/nodemon")).To(Equal(filepath.Join("..", "nodemon", "bin", "nodemon.js")))
+		Expect(readlink("node_modules/webpack/node_modules/.bin/semver")).To(Equal(filepath.Join("..", "semver", "bin", "semver.js")))
+	})
+
+	It("does nothing without node_modules", func() {
+		Expect(prune.RepairBinLinks(appDir)).To(Equal(prune.BinRepair{}))
+	})
+})
@@ -0,0 +1,108 @@
+package prune
+
+import (
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// MaxLocalDepth is how many local packages deep FindLocalCycle follows the
+// dependencies of the app, well beyond the nesting of real apps.
+const MaxLocalDepth = 32
+
+// LocalCycle is a cycle of dependencies through local packages: the package
+// it starts from, then each dependency leading to the next package, the last
+// one back to the first.
+type LocalCycle []LocalPackage
+
+func (c LocalCycle) String() string {
+	steps := make([]string, len(c))
+	for i, step := range c {
+		steps[i] = step.Name
+		if step.Spec != "" {
+			steps[i] = step.String()
+		}
+	}
+	return strings.Join(steps, " -> ")
+}
+
+// FindLocalCycle returns the first cycle through the dependencies on local
+// packages of the app in appDir, which npm loops on or fails with ELOOP, or
+// nil. Besides file:, link: and portal: dirs, a dependency by name on the
+// app, or of a package on itself, counts as a step of a cycle. Local
+// packages without a package.json are left to CheckLocalPackages.
+func FindLocalCycle(appDir string) (LocalCycle, error) {
+	root := filepath.Clean(appDir)
+	app, err := loadPackage(root)
+	if err != nil {
+		return nil, err
+	}
+
+	stack := LocalCycle{{Name: app.Name, Dir: "."}}
+	onStack := map[string]int{root: 0}
+	done := map[string]bool{}
+	var visit func(dir, name string, deps map[string]string) (LocalCycle, error)
+	visit = func(dir, name string, deps map[string]string) (LocalCycle, error) {
+		for _, dep := range sortedKeys(deps) {
+			spec := deps[dep]
+			var target string
+			if localDir, ok := localDir(spec); ok {
+				target = filepath.Join(dir, filepath.FromSlash(localDir))
+				if filepath.IsAbs(filepath.FromSlash(localDir)) {
+					target = filepath.Clean(filepath.FromSlash(localDir))
+				}
+			} else if strings.Contains(strings.TrimPrefix(spec, WorkspaceProtocol), ":") {
+				// Aliases, git and URLs are other packages of that name.
+				continue
+			} else if dep == app.Name && app.Name != "" {
+				target = root
+			} else if dep == name && name != "" {
+				target = dir
+			} else {
+				continue
+			}
+
+			rel, err := filepath.Rel(root, target)
+			if err != nil {
+				rel = target
+			}
+			step := LocalPackage{Name: dep, Spec: spec, Dir: filepath.ToSlash(rel)}
+			if i, found := onStack[target]; found {
+				return append(append(LocalCycle{}, stack[i:]...), step), nil
+			}
+			if done[target] || len(stack) > MaxLocalDepth {
+				continue
+			}
+			done[target] = true
+
+			pkg, err := loadPackage(target)
+			if err != nil {
+				return nil, err
+			}
+			onStack[target] = len(stack)
+			stack = append(stack, step)
+			cycle, err := visit(target, pkg.Name, mergeMaps(pkg.Dependencies, pkg.OptionalDependencies))
+			if err != nil || cycle != nil {
+				return cycle, err
+			}
+			stack = stack[:len(stack)-1]
+			delete(onStack, target)
+		}
+		return nil, nil
+	}
+	return visit(root, app.Name, mergeMaps(app.Dependencies, app.OptionalDependencies, app.DevDependencies))
+}
+
+// localDir returns the dir of a file:, link: or portal: range, slash
+// separated, unless it is a tarball, which installs like a package from a
+// registry.
+func localDir(spec string) (string, bool) {
+	if !strings.HasPrefix(spec, FileProtocol) && !strings.HasPrefix(spec, LinkProtocol) && !strings.HasPrefix(spec, PortalProtocol) {
+		return "", false
+	}
+	dir := spec[strings.Index(spec, ":")+1:]
+	if strings.HasSuffix(dir, ".tgz") || strings.HasSuffix(dir, ".tar.gz") || strings.HasSuffix(dir, ".tar") {
+		return "", false
+	}
+	return path.Clean(filepath.ToSlash(dir)), true
+}
@@ -0,0 +1,122 @@
+package prune_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"nodejs/prune"
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("FindLocalCycle", func() {
+	var appDir string
+
+	// write writes the package.json of the package in dir of the app.
+	write := func(dir, packageJSON string) {
+		Expect(os.MkdirAll(filepath.Join(appDir, dir), 0755)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(appDir, dir, "package.json"), []byte(packageJSON), 0644)).To(Succeed())
+	}
+
+	BeforeEach(func() {
+		var err error
+		appDir, err = ioutil.TempDir("", "nodejs-buildpack.cycle.")
+		Expect(err).To(BeNil())
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(appDir)).To(Succeed())
+	})
+
+	It("finds two local packages which depend on each other", func() {
+		write(".", `{"name": "shop", "dependencies": {"a": "file:./packages/a", "express": "^4.18.2"}}`)
+		write("packages/a", `{"name": "a", "dependencies": {"b": "file:../b"}}`)
+		write("packages/b", `{"name": "b", "dependencies": {"a": "file:../a"}}`)
+
+		cycle, err := prune.FindLocalCycle(appDir)
+		Expect(err).To(BeNil())
+		Expect(cycle.String()).To(Equal("a (file:./packages/a) -> b (file:../b) -> a (file:../a)"))
+		Expect(cycle[len(cycle)-1].Dir).To(Equal("packages/a"))
+	})
+
+	It("finds a local package which depends on the app by its name", func() {
+		write(".", `{"name": "shop", "dependencies": {"mylib": "file:./packages/mylib"}}`)
+		write("packages/mylib", `{"name": "mylib", "dependencies": {"shop": "^1.0.0"}}`)
+
+		cycle, err := prune.FindLocalCycle(appDir)
+		Expect(err).To(BeNil())
+		Expect(cycle.String()).To(Equal("shop -> mylib (file:./packages/mylib) -> shop (^1.0.0)"))
+	})
+
+	It("finds a local package which depends on the app by its dir", func() {
+		write(".", `{"name": "shop", "devDependencies": {"mylib": "link:mylib"}}`)
+		write("mylib", `{"name": "mylib", "dependencies": {"app": "file:.."}}`)
+
+		cycle, err := prune.FindLocalCycle(appDir)
+		Expect(err).To(BeNil())
+		Expect(cycle.String()).To(Equal("shop -> mylib (link:mylib) -> app (file:..)"))
+	})
+
+	It("finds a package which depends on itself", func() {
+		write(".", `{"name": "shop", "dependencies": {"shop": "file:."}}`)
+
+		cycle, err := prune.FindLocalCycle(appDir)
+		Expect(err).To(BeNil())
+		Expect(cycle.String()).To(Equal("shop -> shop (file:.)"))
+	})
+
+	It("does not flag a diamond", func() {
+		write(".", `{"name": "shop", "dependencies": {"a": "file:packages/a", "b": "file:packages/b"}}`)
+		write("packages/a", `{"name": "a", "dependencies": {"c": "file:../c"}}`)
+		write("packages/b", `{"name": "b", "dependencies": {"c": "file:../c", "a": "file:../a"}}`)
+		write("packages/c", `{"name": "c", "dependencies": {"lodash": "^4.17.21"}}`)
+
+		Expect(prune.FindLocalCycle(appDir)).To(BeNil())
+	})
+
+	It("does not flag aliases and tarballs of the name of the app", func() {
+		write(".", `{"name": "shop", "dependencies": {"mylib": "file:mylib"}}`)
+		write("mylib", `{"name": "mylib", "dependencies": {"shop": "npm:shop-client@^2.0.0", "other": "file:../vendor/shop-1.0.0.tgz"}}`)
+
+		Expect(prune.FindLocalCycle(appDir)).To(BeNil())
+	})
+
+	It("skips local packages which are missing", func() {
+		write(".", `{"name": "shop", "dependencies": {"gone": "file:../gone", "lib": "file:lib"}}`)
+		write("lib", `{"name": "lib", "dependencies": {"missing": "file:./missing"}}`)
+
+		Expect(prune.FindLocalCycle(appDir)).To(BeNil())
+	})
+
+	It("stops following a chain of local packages after MaxLocalDepth", func() {
+		// chain makes p0 to p<length-1> depend on the next one, and the last
+		// one back on p0.
+		chain := func(length int) {
+			write(".", `{"name": "shop", "dependencies": {"p0": "file:p0"}}`)
+			for i := 0; i < length; i++ {
+				write(fmt.Sprintf("p%d", i), fmt.Sprintf(`{"name": "p%d", "dependencies": {"p%d": "file:../p%d"}}`, i, (i+1)%length, (i+1)%length))
+			}
+		}
+
+		chain(prune.MaxLocalDepth)
+		cycle, err := prune.FindLocalCycle(appDir)
+		Expect(err).To(BeNil())
+		Expect(cycle).To(HaveLen(prune.MaxLocalDepth + 1))
+
+		Expect(os.RemoveAll(appDir)).To(Succeed())
+		chain(prune.MaxLocalDepth + 2)
+		Expect(prune.FindLocalCycle(appDir)).To(BeNil())
+	})
+
+	It("fails for a package.json which is not valid", func() {
+		write(".", `{"name": "shop", "dependencies": {"lib": "file:lib"}}`)
+		write("lib", `{"name": `)
+
+		_, err := prune.FindLocalCycle(appDir)
+		Expect(err).To(MatchError(HavePrefix(filepath.Join(appDir, "lib", "package.json") + " is not valid JSON at line 1, column 9")))
+		Expect(strings.Count(err.Error(), "package.json")).To(Equal(1))
+	})
+})
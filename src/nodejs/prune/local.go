@@ -0,0 +1,426 @@
+package prune
+
+import (
+	"fmt"
+	"io/ioutil"
+	"nodejs/packagejson"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Protocols of the ranges of dependencies on packages in local dirs.
+const (
+	FileProtocol      = "file:"
+	LinkProtocol      = "link:"
+	PortalProtocol    = "portal:"
+	WorkspaceProtocol = "workspace:"
+)
+
+// LocalPackage is a dependency on a package in a dir of the app, or next to
+// it, rather than from a registry. Package managers link it into
+// node_modules, and its own node_modules stays in its dir.
+type LocalPackage struct {
+	Name string
+	// Spec is the range of the dependency, like file:../lib.
+	Spec string
+	// Dir is the dir of the package relative to the app, slash separated.
+	Dir string
+}
+
+func (p LocalPackage) String() string {
+	return p.Name + " (" + p.Spec + ")"
+}
+
+// LocalPackages returns the production dependencies on local packages in the
+// package.json of appDir, its workspaces and the local packages graph links,
+// sorted by name. A workspace: range resolves to the workspace of that name.
+// file: ranges of tarballs are left out, as they are installed like packages
+// from a registry.
+func LocalPackages(appDir string, graph *Graph) ([]LocalPackage, error) {
+	pkg, err := loadPackage(appDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var workspaces map[string]string
+	found := map[string]LocalPackage{}
+	deps := mergeMaps(pkg.Dependencies, pkg.OptionalDependencies)
+	for _, name := range sortedKeys(deps) {
+		spec := deps[name]
+		switch {
+		case strings.HasPrefix(spec, WorkspaceProtocol):
+			if workspaces == nil {
+				var err error
+				if workspaces, err = workspaceNames(appDir); err != nil {
+					return nil, err
+				}
+			}
+			if dir, ok := workspaces[name]; ok {
+				found[name] = LocalPackage{Name: name, Spec: spec, Dir: dir}
+			}
+		default:
+			if dir, ok := localDir(spec); ok {
+				found[name] = LocalPackage{Name: name, Spec: spec, Dir: dir}
+			}
+		}
+	}
+
+	if workspaces == nil {
+		var err error
+		if workspaces, err = workspaceNames(appDir); err != nil {
+			return nil, err
+		}
+	}
+	for name, dir := range workspaces {
+		if _, ok := found[name]; !ok {
+			found[name] = LocalPackage{Name: name, Spec: WorkspaceProtocol + dir, Dir: dir}
+		}
+	}
+
+	if graph != nil {
+		for _, link := range graph.links {
+			if _, ok := found[link.Name]; !ok {
+				found[link.Name] = link
+			}
+		}
+	}
+
+	locals := make([]LocalPackage, 0, len(found))
+	for _, local := range found {
+		locals = append(locals, local)
+	}
+	sort.Slice(locals, func(i, j int) bool { return locals[i].Name < locals[j].Name })
+	return locals, nil
+}
+
+// workspaceNames maps the names of the workspaces of appDir to their dirs.
+func workspaceNames(appDir string) (map[string]string, error) {
+	dirs, err := workspaceDirs(appDir)
+	if err != nil {
+		return nil, err
+	}
+	names := map[string]string{}
+	for _, dir := range dirs {
+		pkg, err := loadPackage(dir)
+		if err != nil {
+			return nil, err
+		}
+		if rel, err := filepath.Rel(appDir, dir); err == nil && pkg.Name != "" {
+			names[pkg.Name] = filepath.ToSlash(rel)
+		}
+	}
+	return names, nil
+}
+
+// LocalClosure returns the packages in the node_modules of appDir which the
+// local packages need at runtime and production does not, as the top level
+// dirs they are installed in, like node_modules/lodash: pruning takes them
+// for extraneous, since it does not follow the dependencies of linked
+// packages. The dependencies of a local package are those of its entry in
+// the lockfile, or else the production dependencies in its package.json.
+func (g *Graph) LocalClosure(appDir string, production map[string]string, locals []LocalPackage) ([]string, error) {
+	needed := map[string]bool{}
+	g.walk(production, func(id, parent string) bool {
+		if needed[id] {
+			return false
+		}
+		needed[id] = true
+		return true
+	})
+
+	var ids []string
+	for _, local := range locals {
+		if _, ok := g.packages[local.Dir]; ok {
+			ids = append(ids, g.deps[local.Dir]...)
+			continue
+		}
+		pkg, err := loadPackage(filepath.Join(appDir, filepath.FromSlash(local.Dir)))
+		if err != nil {
+			return nil, err
+		}
+		deps := mergeMaps(pkg.Dependencies, pkg.OptionalDependencies)
+		for _, name := range sortedKeys(deps) {
+			if id := g.root(name, deps[name]); id != "" {
+				ids = append(ids, id)
+			}
+		}
+	}
+
+	var dirs []string
+	seen, seenDirs := map[string]bool{}, map[string]bool{}
+	g.walkFrom(ids, func(id, parent string) bool {
+		if seen[id] || needed[id] {
+			return false
+		}
+		seen[id] = true
+		if dir := topLevelDir(g.installPath(id)); dir != "" && !seenDirs[dir] {
+			seenDirs[dir] = true
+			dirs = append(dirs, dir)
+		}
+		return true
+	})
+	sort.Strings(dirs)
+	return dirs, nil
+}
+
+// installPath is where the package id is installed, relative to the app:
+// the id itself in npm lockfiles, and the hoisted dir of the package for
+// yarn, whose lockfile does not record where packages go.
+func (g *Graph) installPath(id string) string {
+	if g.Lockfile == "yarn.lock" {
+		return "node_modules/" + g.packages[id].Name
+	}
+	return id
+}
+
+// topLevelDir returns the package dir in the node_modules of the app which
+// installPath is in, empty for packages installed in the dirs of local
+// packages.
+func topLevelDir(installPath string) string {
+	rest := strings.TrimPrefix(installPath, "node_modules/")
+	if rest == installPath {
+		return ""
+	}
+	parts := strings.SplitN(rest, "/", 3)
+	name := parts[0]
+	if strings.HasPrefix(name, "@") && len(parts) > 1 {
+		name += "/" + parts[1]
+	}
+	return "node_modules/" + name
+}
+
+// CheckLocalPackages fails for local packages outside appDir or missing from
+// it, and for relative links in its node_modules which point outside appDir
+// or to nothing. They resolve on the machine the app was pushed from, but
+// only appDir makes it into the droplet.
+func CheckLocalPackages(appDir string, locals []LocalPackage) error {
+	var problems []string
+	for _, local := range locals {
+		dir := filepath.Join(appDir, filepath.FromSlash(local.Dir))
+		if filepath.IsAbs(filepath.FromSlash(local.Dir)) {
+			dir = filepath.FromSlash(local.Dir)
+		}
+		if !within(appDir, dir) {
+			problems = append(problems, fmt.Sprintf("  %s is in %s, outside the app", local, local.Dir))
+		} else if _, err := os.Stat(filepath.Join(dir, "package.json")); os.IsNotExist(err) {
+			problems = append(problems, fmt.Sprintf("  %s is in %s, which has no package.json", local, local.Dir))
+		} else if err != nil {
+			return err
+		}
+	}
+
+	links, err := relativeLinks(filepath.Join(appDir, "node_modules"))
+	if err != nil {
+		return err
+	}
+	for _, link := range links {
+		target, err := os.Readlink(link)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(appDir, link)
+		if err != nil {
+			return err
+		}
+		resolved := filepath.Join(filepath.Dir(link), target)
+		if !within(appDir, resolved) {
+			problems = append(problems, fmt.Sprintf("  %s links to %s, outside the app", filepath.ToSlash(rel), target))
+		} else if _, err := os.Stat(resolved); os.IsNotExist(err) {
+			problems = append(problems, fmt.Sprintf("  %s links to %s, which does not exist", filepath.ToSlash(rel), target))
+		}
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+	sort.Strings(problems)
+	return fmt.Errorf("local packages are missing from the app, which would fail at runtime:\n%s\nPush the app from a dir which contains them, or publish them to a registry", strings.Join(problems, "\n"))
+}
+
+// relativeLinks returns the packages in nodeModules, scoped ones included,
+// which are relative symlinks.
+func relativeLinks(nodeModules string) ([]string, error) {
+	entries, err := ioutil.ReadDir(nodeModules)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var links []string
+	for _, entry := range entries {
+		path := filepath.Join(nodeModules, entry.Name())
+		if strings.HasPrefix(entry.Name(), "@") && entry.IsDir() {
+			scoped, err := relativeLinks(path)
+			if err != nil {
+				return nil, err
+			}
+			links = append(links, scoped...)
+			continue
+		}
+		if entry.Mode()&os.ModeSymlink == 0 || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		if target, err := os.Readlink(path); err != nil {
+			return nil, err
+		} else if !filepath.IsAbs(target) {
+			links = append(links, path)
+		}
+	}
+	return links, nil
+}
+
+func within(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// Preserved is the snapshot PreserveLocalPackages took before pruning.
+type Preserved struct {
+	appDir string
+	dir    string
+	locals []LocalPackage
+	// saved are the paths relative to appDir in the snapshot.
+	saved []string
+}
+
+// PreserveDir holds the snapshot of PreserveLocalPackages while pruning. It
+// is in the app, so that the snapshot can hard link the files.
+const PreserveDir = ".prune-preserved"
+
+// PreserveLocalPackages snapshots the node_modules of the local packages in
+// appDir and the dirs, relative to appDir, with hard links, so that what
+// pruning removes of them can be restored.
+func PreserveLocalPackages(appDir string, locals []LocalPackage, dirs []string) (*Preserved, error) {
+	p := &Preserved{appDir: appDir, dir: filepath.Join(appDir, PreserveDir), locals: locals}
+	if err := os.RemoveAll(p.dir); err != nil {
+		return nil, err
+	}
+
+	paths := append([]string{}, dirs...)
+	for _, local := range locals {
+		if within(appDir, filepath.Join(appDir, filepath.FromSlash(local.Dir))) {
+			paths = append(paths, path.Join(local.Dir, "node_modules"))
+		}
+	}
+	for _, rel := range paths {
+		source := filepath.Join(appDir, filepath.FromSlash(rel))
+		if _, err := os.Lstat(source); os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		if err := linkTree(source, filepath.Join(p.dir, filepath.FromSlash(rel))); err != nil {
+			os.RemoveAll(p.dir)
+			return nil, err
+		}
+		p.saved = append(p.saved, rel)
+	}
+	return p, nil
+}
+
+// Restore puts back what pruning removed of the snapshot: the dirs which are
+// gone, and the node_modules of local packages which are gone or empty. The
+// local packages pruning unlinked from node_modules are linked again. It
+// returns the number of dirs restored.
+func (p *Preserved) Restore() (int, error) {
+	restored := 0
+	for _, rel := range p.saved {
+		target := filepath.Join(p.appDir, filepath.FromSlash(rel))
+		if path.Base(rel) == "node_modules" {
+			if empty, err := IsEmpty(target); err != nil {
+				return restored, err
+			} else if !empty {
+				continue
+			}
+			if err := os.RemoveAll(target); err != nil {
+				return restored, err
+			}
+		} else if _, err := os.Lstat(target); err == nil {
+			continue
+		} else if !os.IsNotExist(err) {
+			return restored, err
+		}
+
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return restored, err
+		}
+		if err := os.Rename(filepath.Join(p.dir, filepath.FromSlash(rel)), target); err != nil {
+			return restored, err
+		}
+		restored++
+	}
+
+	for _, local := range p.locals {
+		dir := filepath.Join(p.appDir, filepath.FromSlash(local.Dir))
+		if !within(p.appDir, dir) {
+			continue
+		}
+		link := filepath.Join(p.appDir, "node_modules", filepath.FromSlash(local.Name))
+		if _, err := os.Lstat(link); err == nil {
+			continue
+		} else if !os.IsNotExist(err) {
+			return restored, err
+		}
+		target, err := filepath.Rel(filepath.Dir(link), dir)
+		if err != nil {
+			return restored, err
+		}
+		if err := os.MkdirAll(filepath.Dir(link), 0755); err != nil {
+			return restored, err
+		}
+		if err := os.Symlink(target, link); err != nil {
+			return restored, err
+		}
+	}
+
+	return restored, os.RemoveAll(p.dir)
+}
+
+// linkTree copies the tree at source to target, with hard links for files
+// and symlinks as they are.
+func linkTree(source, target string) error {
+	return filepath.Walk(source, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(source, file)
+		if err != nil {
+			return err
+		}
+		dest := filepath.Join(target, rel)
+		switch {
+		case info.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(file)
+			if err != nil {
+				return err
+			}
+			if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+				return err
+			}
+			return os.Symlink(link, dest)
+		case info.IsDir():
+			return os.MkdirAll(dest, info.Mode().Perm()|0700)
+		case info.Mode().IsRegular():
+			return os.Link(file, dest)
+		}
+		return nil
+	})
+}
+
+// loadPackage returns the package.json of dir, an empty one when there is
+// none. Its errors name the package.json by its path, as there are many.
+func loadPackage(dir string) (packagejson.Package, error) {
+	pkg, _, err := packagejson.Load(dir)
+	if err != nil {
+		file := filepath.Join(dir, packagejson.File)
+		if strings.HasPrefix(err.Error(), packagejson.File+" ") {
+			return packagejson.Package{}, fmt.Errorf("%s%s", file, strings.TrimPrefix(err.Error(), packagejson.File))
+		}
+		return packagejson.Package{}, fmt.Errorf("unable to parse %s: %s", file, err)
+	}
+	return pkg, nil
+}
@@ -0,0 +1,174 @@
+package prune_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"nodejs/prune"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Local packages", func() {
+	local := func(name, spec, dir string) prune.LocalPackage {
+		return prune.LocalPackage{Name: name, Spec: spec, Dir: dir}
+	}
+
+	DescribeTable("LocalPackages and LocalClosure",
+		func(fixture string, expected []prune.LocalPackage, closure []string) {
+			appDir := filepath.Join("testdata", "local", fixture)
+			graph, err := prune.LoadLockfile(appDir)
+			Expect(err).To(BeNil())
+
+			locals, err := prune.LocalPackages(appDir, graph)
+			Expect(err).To(BeNil())
+			Expect(locals).To(Equal(expected))
+
+			var pkg struct {
+				Dependencies map[string]string `json:"dependencies"`
+			}
+			contents, err := ioutil.ReadFile(filepath.Join(appDir, "package.json"))
+			Expect(err).To(BeNil())
+			Expect(json.Unmarshal(contents, &pkg)).To(Succeed())
+			Expect(graph.LocalClosure(appDir, pkg.Dependencies, locals)).To(Equal(closure))
+		},
+		// lib is a production dependency, whose lodash the lockfile lists,
+		// while only the workspace web needs uuid.
+		Entry("npm file: and workspaces", "npm", []prune.LocalPackage{
+			local("lib", "file:lib", "lib"),
+			local("web", "workspace:packages/web", "packages/web"),
+		}, []string{"node_modules/uuid"}),
+		// yarn 1 doesn't record the dependencies of linked packages.
+		Entry("yarn 1 link: and workspaces", "yarn", []prune.LocalPackage{
+			local("lib", "link:lib", "lib"),
+			local("web", "workspace:packages/web", "packages/web"),
+		}, []string{"node_modules/lodash", "node_modules/uuid"}),
+		// yarn 2+ records both as dependencies of the app.
+		Entry("yarn 2+ portal: and workspace:", "berry", []prune.LocalPackage{
+			local("lib", "portal:./lib", "lib"),
+			local("web", "workspace:*", "packages/web"),
+		}, []string(nil)),
+	)
+
+	It("leaves out tarballs and registry packages", func() {
+		appDir, err := ioutil.TempDir("", "nodejs-buildpack.local.")
+		Expect(err).To(BeNil())
+		defer os.RemoveAll(appDir)
+		Expect(ioutil.WriteFile(filepath.Join(appDir, "package.json"), []byte(`{"dependencies": {"express": "^4.18.2", "vendored": "file:vendor/vendored-1.0.0.tgz", "lib": "file:./libs/../lib"}}`), 0644)).To(Succeed())
+
+		Expect(prune.LocalPackages(appDir, nil)).To(Equal([]prune.LocalPackage{local("lib", "file:./libs/../lib", "lib")}))
+	})
+
+	Describe("CheckLocalPackages", func() {
+		var appDir string
+
+		BeforeEach(func() {
+			var err error
+			appDir, err = ioutil.TempDir("", "nodejs-buildpack.local.")
+			Expect(err).To(BeNil())
+			Expect(os.MkdirAll(filepath.Join(appDir, "lib"), 0755)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(appDir, "lib", "package.json"), []byte(`{"name": "lib"}`), 0644)).To(Succeed())
+			Expect(os.MkdirAll(filepath.Join(appDir, "node_modules", "@corp"), 0755)).To(Succeed())
+			Expect(os.Symlink("../lib", filepath.Join(appDir, "node_modules", "lib"))).To(Succeed())
+		})
+
+		AfterEach(func() {
+			Expect(os.RemoveAll(appDir)).To(Succeed())
+		})
+
+		It("accepts local packages in the app", func() {
+			Expect(prune.CheckLocalPackages(appDir, []prune.LocalPackage{local("lib", "file:lib", "lib")})).To(Succeed())
+		})
+
+		It("fails for local packages outside the app or missing from it", func() {
+			err := prune.CheckLocalPackages(appDir, []prune.LocalPackage{
+				local("lib", "file:lib", "lib"),
+				local("shared", "file:../shared", "../shared"),
+				local("ui", "link:packages/ui", "packages/ui"),
+			})
+			Expect(err).To(MatchError(`local packages are missing from the app, which would fail at runtime:
+  shared (file:../shared) is in ../shared, outside the app
+  ui (link:packages/ui) is in packages/ui, which has no package.json
+Push the app from a dir which contains them, or publish them to a registry`))
+		})
+
+		It("fails for relative links out of the app and dangling ones", func() {
+			Expect(os.Symlink("../../../shared", filepath.Join(appDir, "node_modules", "@corp", "shared"))).To(Succeed())
+			Expect(os.Symlink("../packages/ui", filepath.Join(appDir, "node_modules", "ui"))).To(Succeed())
+			Expect(os.Symlink("/usr/lib/node_modules/npm", filepath.Join(appDir, "node_modules", "npm"))).To(Succeed())
+
+			err := prune.CheckLocalPackages(appDir, nil)
+			Expect(err).To(MatchError(`local packages are missing from the app, which would fail at runtime:
+  node_modules/@corp/shared links to ../../../shared, outside the app
+  node_modules/ui links to ../packages/ui, which does not exist
+Push the app from a dir which contains them, or publish them to a registry`))
+		})
+	})
+
+	Describe("PreserveLocalPackages", func() {
+		var (
+			appDir string
+			locals []prune.LocalPackage
+		)
+
+		writePackage := func(dir string) {
+			Expect(os.MkdirAll(filepath.Join(appDir, dir), 0755)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(appDir, dir, "package.json"), []byte(`{"name": "`+filepath.Base(dir)+`"}`), 0644)).To(Succeed())
+		}
+
+		BeforeEach(func() {
+			var err error
+			appDir, err = ioutil.TempDir("", "nodejs-buildpack.local.")
+			Expect(err).To(BeNil())
+			writePackage("packages/web")
+			writePackage("packages/web/node_modules/left-pad")
+			writePackage("node_modules/uuid")
+			writePackage("node_modules/express")
+			Expect(os.Symlink("../packages/web", filepath.Join(appDir, "node_modules", "web"))).To(Succeed())
+			locals = []prune.LocalPackage{local("web", "workspace:packages/web", "packages/web")}
+		})
+
+		AfterEach(func() {
+			Expect(os.RemoveAll(appDir)).To(Succeed())
+		})
+
+		It("restores what pruning removed", func() {
+			preserved, err := prune.PreserveLocalPackages(appDir, locals, []string{"node_modules/uuid"})
+			Expect(err).To(BeNil())
+
+			for _, dir := range []string{"packages/web/node_modules", "node_modules/uuid", "node_modules/web"} {
+				Expect(os.RemoveAll(filepath.Join(appDir, dir))).To(Succeed())
+			}
+
+			Expect(preserved.Restore()).To(Equal(2))
+			Expect(filepath.Join(appDir, "packages/web/node_modules/left-pad/package.json")).To(BeAnExistingFile())
+			Expect(filepath.Join(appDir, "node_modules/uuid/package.json")).To(BeAnExistingFile())
+			Expect(os.Readlink(filepath.Join(appDir, "node_modules", "web"))).To(Equal("../packages/web"))
+			Expect(filepath.Join(appDir, prune.PreserveDir)).NotTo(BeAnExistingFile())
+		})
+
+		It("restores the node_modules of local packages pruning emptied", func() {
+			preserved, err := prune.PreserveLocalPackages(appDir, locals, nil)
+			Expect(err).To(BeNil())
+			Expect(os.RemoveAll(filepath.Join(appDir, "packages/web/node_modules/left-pad"))).To(Succeed())
+			Expect(os.MkdirAll(filepath.Join(appDir, "packages/web/node_modules/.bin"), 0755)).To(Succeed())
+
+			Expect(preserved.Restore()).To(Equal(1))
+			Expect(filepath.Join(appDir, "packages/web/node_modules/left-pad/package.json")).To(BeAnExistingFile())
+		})
+
+		It("leaves alone what pruning kept", func() {
+			preserved, err := prune.PreserveLocalPackages(appDir, locals, []string{"node_modules/uuid", "node_modules/missing"})
+			Expect(err).To(BeNil())
+			Expect(ioutil.WriteFile(filepath.Join(appDir, "node_modules/uuid/package.json"), []byte(`{"name": "uuid", "version": "9.0.1"}`), 0644)).To(Succeed())
+
+			Expect(preserved.Restore()).To(Equal(0))
+			Expect(ioutil.ReadFile(filepath.Join(appDir, "node_modules/uuid/package.json"))).To(ContainSubstring("9.0.1"))
+			Expect(filepath.Join(appDir, "node_modules/missing")).NotTo(BeAnExistingFile())
+			Expect(filepath.Join(appDir, prune.PreserveDir)).NotTo(BeAnExistingFile())
+		})
+	})
+})
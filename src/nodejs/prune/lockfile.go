@@ -0,0 +1,455 @@
+package prune
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Package is a package resolved in a lockfile.
+type Package struct {
+	Name    string
+	Version string
+}
+
+func (p Package) String() string {
+	return p.Name + "@" + p.Version
+}
+
+// Graph is the dependency graph recorded in a lockfile. Packages are keyed by
+// an id unique within the lockfile: their install path for npm and their
+// first descriptor for yarn.
+type Graph struct {
+	Lockfile string
+	packages map[string]Package
+	deps     map[string][]string
+	// root resolves a dependency of the app, by name and range, to an id.
+	root func(name, spec string) string
+	// links are the packages the lockfile links to local dirs.
+	links []LocalPackage
+}
+
+// LoadLockfile reads the dependency graph from package-lock.json,
+// npm-shrinkwrap.json or yarn.lock in appDir, or returns nil when there is
+// none.
+func LoadLockfile(appDir string) (*Graph, error) {
+	for _, name := range []string{"npm-shrinkwrap.json", "package-lock.json", "yarn.lock"} {
+		contents, err := ioutil.ReadFile(filepath.Join(appDir, name))
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		var g *Graph
+		if name == "yarn.lock" {
+			if bytes.Contains(contents, []byte("__metadata:")) {
+				g, err = parseBerryLock(contents)
+			} else {
+				g, err = parseYarnLock(contents)
+			}
+		} else {
+			g, err = parseNPMLock(contents)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse %s: %s", name, err)
+		}
+		g.Lockfile = name
+		return g, nil
+	}
+	return nil, nil
+}
+
+func newGraph() *Graph {
+	return &Graph{packages: map[string]Package{}, deps: map[string][]string{}}
+}
+
+// Kept is a package which pruning keeps because of BP_PRUNE_KEEP.
+type Kept struct {
+	Package
+	// RequiredBy is the package which pulled it in, empty for the packages
+	// listed in BP_PRUNE_KEEP.
+	RequiredBy string
+}
+
+// Keep returns the packages which pruning dev dependencies removes unless
+// keep, dependencies of the app, are promoted to production: keep and their
+// transitive dependencies, minus the closure of production. Each comes with
+// the package it is needed by, along the shortest path from keep.
+func (g *Graph) Keep(production, keep map[string]string) []Kept {
+	needed := map[string]bool{}
+	g.walk(production, func(id, parent string) bool {
+		if needed[id] {
+			return false
+		}
+		needed[id] = true
+		return true
+	})
+
+	var kept []Kept
+	seen := map[string]bool{}
+	g.walk(keep, func(id, parent string) bool {
+		if seen[id] {
+			return false
+		}
+		seen[id] = true
+		if !needed[id] {
+			k := Kept{Package: g.packages[id]}
+			if parent != "" {
+				k.RequiredBy = g.packages[parent].Name
+			}
+			kept = append(kept, k)
+		}
+		return true
+	})
+	return kept
+}
+
+// Chain is a dependency chain from a dependency of the app to a package.
+type Chain []Package
+
+func (c Chain) String() string {
+	parts := make([]string, len(c))
+	for i, pkg := range c {
+		parts[i] = pkg.String()
+	}
+	return strings.Join(parts, " > ")
+}
+
+// Chains returns, for every copy of the package name in the lockfile which
+// roots pull in, the shortest chain leading to it, ordered by version and
+// chain.
+func (g *Graph) Chains(roots map[string]string, name string) []Chain {
+	parents := map[string]string{}
+	var found []string
+	g.walk(roots, func(id, parent string) bool {
+		if _, seen := parents[id]; seen {
+			return false
+		}
+		parents[id] = parent
+		if g.packages[id].Name == name {
+			found = append(found, id)
+		}
+		return true
+	})
+
+	var chains []Chain
+	for _, id := range found {
+		var chain Chain
+		for ; id != ""; id = parents[id] {
+			chain = append(Chain{g.packages[id]}, chain...)
+		}
+		chains = append(chains, chain)
+	}
+	sort.Slice(chains, func(i, j int) bool {
+		a, b := chains[i][len(chains[i])-1].Version, chains[j][len(chains[j])-1].Version
+		if a != b {
+			return a < b
+		}
+		return chains[i].String() < chains[j].String()
+	})
+	return chains
+}
+
+// walk visits the packages reachable from roots breadth first, in the order
+// of the names of roots and of the dependencies in the lockfile. visit
+// returns whether to follow the dependencies of id.
+func (g *Graph) walk(roots map[string]string, visit func(id, parent string) bool) {
+	var ids []string
+	for _, name := range sortedKeys(roots) {
+		if id := g.root(name, roots[name]); id != "" {
+			ids = append(ids, id)
+		}
+	}
+	g.walkFrom(ids, visit)
+}
+
+// walkFrom visits the packages reachable from the packages ids breadth
+// first, like walk.
+func (g *Graph) walkFrom(ids []string, visit func(id, parent string) bool) {
+	type item struct{ id, parent string }
+	var queue []item
+	for _, id := range ids {
+		queue = append(queue, item{id, ""})
+	}
+	for len(queue) > 0 {
+		next := queue[0]
+		queue = queue[1:]
+		if !visit(next.id, next.parent) {
+			continue
+		}
+		for _, dep := range g.deps[next.id] {
+			queue = append(queue, item{dep, next.id})
+		}
+	}
+}
+
+type npmLockEntry struct {
+	Version              string            `json:"version"`
+	Link                 bool              `json:"link"`
+	Resolved             string            `json:"resolved"`
+	Dependencies         map[string]string `json:"dependencies"`
+	OptionalDependencies map[string]string `json:"optionalDependencies"`
+	PeerDependencies     map[string]string `json:"peerDependencies"`
+}
+
+type npmLockV1Entry struct {
+	Version      string                    `json:"version"`
+	Requires     map[string]string         `json:"requires"`
+	Dependencies map[string]npmLockV1Entry `json:"dependencies"`
+}
+
+// parseNPMLock reads the packages section of lockfile v2 and v3, or the
+// nested dependencies of v1, as install paths like
+// node_modules/a/node_modules/b.
+func parseNPMLock(contents []byte) (*Graph, error) {
+	var lock struct {
+		Packages     map[string]npmLockEntry   `json:"packages"`
+		Dependencies map[string]npmLockV1Entry `json:"dependencies"`
+	}
+	if err := json.Unmarshal(contents, &lock); err != nil {
+		return nil, err
+	}
+
+	entries := lock.Packages
+	if entries == nil {
+		entries = map[string]npmLockEntry{}
+		flattenNPMLockV1("", lock.Dependencies, entries)
+	}
+
+	g := newGraph()
+	resolve := func(from, name string) string {
+		dir := from
+		for {
+			id := path.Join(dir, "node_modules", name)
+			if entry, found := entries[id]; found {
+				if entry.Link {
+					return entry.Resolved
+				}
+				return id
+			}
+			if dir == "" {
+				return ""
+			}
+			if idx := strings.LastIndex(dir, "/node_modules/"); idx >= 0 {
+				dir = dir[:idx]
+			} else {
+				dir = ""
+			}
+		}
+	}
+
+	for id, entry := range entries {
+		if entry.Link && strings.LastIndex(id, "node_modules/") == 0 {
+			g.links = append(g.links, LocalPackage{Name: npmPackageName(id), Spec: FileProtocol + entry.Resolved, Dir: entry.Resolved})
+		}
+		if id == "" || entry.Link {
+			continue
+		}
+		g.packages[id] = Package{Name: npmPackageName(id), Version: entry.Version}
+		for _, name := range sortedKeys(mergeMaps(entry.Dependencies, entry.OptionalDependencies, entry.PeerDependencies)) {
+			if dep := resolve(id, name); dep != "" {
+				g.deps[id] = append(g.deps[id], dep)
+			}
+		}
+	}
+	g.root = func(name, spec string) string {
+		return resolve("", name)
+	}
+	return g, nil
+}
+
+func flattenNPMLockV1(parent string, deps map[string]npmLockV1Entry, entries map[string]npmLockEntry) {
+	for name, dep := range deps {
+		id := path.Join(parent, "node_modules", name)
+		entries[id] = npmLockEntry{Version: dep.Version, Dependencies: dep.Requires}
+		flattenNPMLockV1(id, dep.Dependencies, entries)
+	}
+}
+
+// npmPackageName returns the name of the package installed at id, the part
+// after the last node_modules/.
+func npmPackageName(id string) string {
+	if idx := strings.LastIndex(id, "node_modules/"); idx >= 0 {
+		return id[idx+len("node_modules/"):]
+	}
+	return id
+}
+
+// parseYarnLock reads a yarn 1 lockfile, where every entry is keyed by the
+// name@range descriptors which resolve to it.
+func parseYarnLock(contents []byte) (*Graph, error) {
+	g := newGraph()
+	ids := map[string]string{}
+	pending := map[string][]string{}
+
+	var id, section string
+	scanner := bufio.NewScanner(bytes.NewReader(contents))
+	for scanner.Scan() {
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		indent := len(line) - len(strings.TrimLeft(line, " "))
+
+		switch {
+		case indent == 0:
+			descriptors := strings.Split(strings.TrimSuffix(trimmed, ":"), ",")
+			id = unquote(strings.TrimSpace(descriptors[0]))
+			for _, descriptor := range descriptors {
+				ids[unquote(strings.TrimSpace(descriptor))] = id
+			}
+			g.packages[id] = Package{Name: descriptorName(id)}
+			section = ""
+		case indent == 2 && strings.HasSuffix(trimmed, ":"):
+			section = strings.TrimSuffix(trimmed, ":")
+		case indent == 2 && strings.HasPrefix(trimmed, "version "):
+			pkg := g.packages[id]
+			pkg.Version = unquote(strings.TrimPrefix(trimmed, "version "))
+			g.packages[id] = pkg
+		case indent == 4 && (section == "dependencies" || section == "optionalDependencies"):
+			fields := strings.SplitN(trimmed, " ", 2)
+			if len(fields) == 2 {
+				pending[id] = append(pending[id], unquote(fields[0])+"@"+unquote(fields[1]))
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	for from, descriptors := range pending {
+		for _, descriptor := range descriptors {
+			if dep, found := ids[descriptor]; found {
+				g.deps[from] = append(g.deps[from], dep)
+			}
+		}
+	}
+	g.root = func(name, spec string) string {
+		return ids[name+"@"+spec]
+	}
+	return g, nil
+}
+
+// parseBerryLock reads a yarn 2+ lockfile, which is YAML keyed like yarn 1,
+// with a protocol in every range, npm: by default.
+func parseBerryLock(contents []byte) (*Graph, error) {
+	var lock map[string]struct {
+		Version              string            `yaml:"version"`
+		Dependencies         map[string]string `yaml:"dependencies"`
+		OptionalDependencies map[string]string `yaml:"optionalDependencies"`
+	}
+	if err := yaml.Unmarshal(contents, &lock); err != nil {
+		return nil, err
+	}
+
+	g := newGraph()
+	ids := map[string]string{}
+	for key := range lock {
+		if key == "__metadata" {
+			continue
+		}
+		descriptors := strings.Split(key, ",")
+		id := strings.TrimSpace(descriptors[0])
+		for _, descriptor := range descriptors {
+			descriptor = strings.TrimSpace(descriptor)
+			ids[descriptor] = id
+			// Local packages are keyed with the locator of the workspace
+			// which depends on them, which the ranges leave out.
+			if idx := strings.Index(descriptor, "::"); idx >= 0 {
+				ids[descriptor[:idx]] = id
+			}
+			if local, found := berryLocalPackage(descriptor); found {
+				g.links = append(g.links, local)
+			}
+		}
+		g.packages[id] = Package{Name: descriptorName(id), Version: lock[key].Version}
+	}
+	lookup := func(name, spec string) string {
+		if !strings.Contains(spec, ":") {
+			spec = "npm:" + spec
+		}
+		return ids[name+"@"+spec]
+	}
+
+	for key, entry := range lock {
+		if key == "__metadata" {
+			continue
+		}
+		id := ids[strings.TrimSpace(strings.Split(key, ",")[0])]
+		deps := mergeMaps(entry.Dependencies, entry.OptionalDependencies)
+		for _, name := range sortedKeys(deps) {
+			if dep := lookup(name, deps[name]); dep != "" {
+				g.deps[id] = append(g.deps[id], dep)
+			}
+		}
+	}
+	g.root = lookup
+	return g, nil
+}
+
+// berryLocalPackage returns the local package a descriptor of a yarn 2+
+// lockfile resolves to, like web@workspace:packages/web or
+// lib@link:../lib::locator=shop%40workspace%3A., other than the app itself.
+func berryLocalPackage(descriptor string) (LocalPackage, bool) {
+	name := descriptorName(descriptor)
+	spec := strings.TrimPrefix(descriptor, name+"@")
+	if idx := strings.Index(spec, "::"); idx >= 0 {
+		spec = spec[:idx]
+	}
+	for _, protocol := range []string{WorkspaceProtocol, LinkProtocol, PortalProtocol} {
+		if !strings.HasPrefix(spec, protocol) {
+			continue
+		}
+		dir := strings.TrimPrefix(spec, protocol)
+		// workspace: takes ranges like * and ^1.0.0 as well as dirs.
+		if dir == "" || dir == "." || strings.ContainsAny(dir[:1], "*^~<>=0123456789") {
+			return LocalPackage{}, false
+		}
+		return LocalPackage{Name: name, Spec: spec, Dir: path.Clean(dir)}, true
+	}
+	return LocalPackage{}, false
+}
+
+// descriptorName returns the package name of a name@range descriptor.
+func descriptorName(descriptor string) string {
+	if idx := strings.Index(descriptor[1:], "@"); idx >= 0 {
+		return descriptor[:idx+1]
+	}
+	return descriptor
+}
+
+func unquote(s string) string {
+	return strings.Trim(s, `"`)
+}
+
+func mergeMaps(maps ...map[string]string) map[string]string {
+	merged := map[string]string{}
+	for _, m := range maps {
+		for k, v := range m {
+			if _, found := merged[k]; !found {
+				merged[k] = v
+			}
+		}
+	}
+	return merged
+}
+
+func sortedKeys(m map[string]string) []string {
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,131 @@
+package prune_test
+
+import (
+	"io/ioutil"
+	"nodejs/prune"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Lockfile", func() {
+	production := map[string]string{"express": "^4.18.2"}
+
+	kept := func(name, version, requiredBy string) prune.Kept {
+		return prune.Kept{Package: prune.Package{Name: name, Version: version}, RequiredBy: requiredBy}
+	}
+
+	DescribeTable("Keep",
+		func(fixture, lockfile string) {
+			graph, err := prune.LoadLockfile(filepath.Join("testdata", fixture))
+			Expect(err).To(BeNil())
+			Expect(graph.Lockfile).To(Equal(lockfile))
+
+			// ejs shares jake's chalk with jest and jake's debug with express:
+			// chalk is kept, debug and ms are production anyway, and jest,
+			// pretty-format and the minimatch and ansi-styles versions only
+			// jest and pretty-format use are still pruned.
+			Expect(graph.Keep(production, map[string]string{"ejs": "^3.1.9"})).To(Equal([]prune.Kept{
+				kept("ejs", "3.1.9", ""),
+				kept("jake", "10.8.7", "ejs"),
+				kept("async", "3.2.5", "jake"),
+				kept("chalk", "4.1.2", "jake"),
+				kept("minimatch", "3.1.2", "jake"),
+				kept("ansi-styles", "4.3.0", "chalk"),
+				kept("brace-expansion", "1.1.11", "minimatch"),
+			}))
+		},
+		Entry("npm lockfile v3", "npm", "package-lock.json"),
+		Entry("yarn 1", "yarn", "yarn.lock"),
+		Entry("yarn 2+", "berry", "yarn.lock"),
+	)
+
+	It("keeps nothing extra for a package production already needs", func() {
+		graph, err := prune.LoadLockfile(filepath.Join("testdata", "npm"))
+		Expect(err).To(BeNil())
+		Expect(graph.Keep(production, map[string]string{"debug": "2.6.9"})).To(BeEmpty())
+	})
+
+	It("keeps shared packages once, from the first package listed", func() {
+		graph, err := prune.LoadLockfile(filepath.Join("testdata", "yarn"))
+		Expect(err).To(BeNil())
+		Expect(graph.Keep(production, map[string]string{"ejs": "^3.1.9", "jest": "^29.7.0"})).To(Equal([]prune.Kept{
+			kept("ejs", "3.1.9", ""),
+			kept("jest", "29.7.0", ""),
+			kept("jake", "10.8.7", "ejs"),
+			kept("chalk", "4.1.2", "jest"),
+			kept("minimatch", "9.0.3", "jest"),
+			kept("pretty-format", "29.7.0", "jest"),
+			kept("async", "3.2.5", "jake"),
+			kept("minimatch", "3.1.2", "jake"),
+			kept("ansi-styles", "4.3.0", "chalk"),
+			kept("ansi-styles", "5.2.0", "pretty-format"),
+			kept("brace-expansion", "1.1.11", "minimatch"),
+		}))
+	})
+
+	DescribeTable("Chains",
+		func(fixture string) {
+			graph, err := prune.LoadLockfile(filepath.Join("testdata", fixture))
+			Expect(err).To(BeNil())
+
+			var chains []string
+			for _, chain := range graph.Chains(map[string]string{"ejs": "^3.1.9", "express": "^4.18.2", "jest": "^29.7.0"}, "ansi-styles") {
+				chains = append(chains, chain.String())
+			}
+			Expect(chains).To(Equal([]string{
+				"jest@29.7.0 > chalk@4.1.2 > ansi-styles@4.3.0",
+				"jest@29.7.0 > pretty-format@29.7.0 > ansi-styles@5.2.0",
+			}))
+		},
+		Entry("npm lockfile v3", "npm"),
+		Entry("yarn 1", "yarn"),
+		Entry("yarn 2+", "berry"),
+	)
+
+	Context("npm lockfile v1", func() {
+		var appDir string
+
+		BeforeEach(func() {
+			var err error
+			appDir, err = ioutil.TempDir("", "nodejs-buildpack.prune.")
+			Expect(err).To(BeNil())
+		})
+
+		AfterEach(func() {
+			Expect(os.RemoveAll(appDir)).To(Succeed())
+		})
+
+		It("resolves the nested dependencies", func() {
+			Expect(ioutil.WriteFile(filepath.Join(appDir, "package-lock.json"), []byte(`{
+				"lockfileVersion": 1,
+				"dependencies": {
+					"ejs": {"version": "3.1.9", "dev": true, "requires": {"jake": "^10.8.5"}},
+					"jake": {
+						"version": "10.8.7", "dev": true, "requires": {"minimatch": "^3.1.2"},
+						"dependencies": {"minimatch": {"version": "3.1.2", "dev": true}}
+					},
+					"minimatch": {"version": "9.0.3", "dev": true},
+					"express": {"version": "4.18.2"}
+				}
+			}`), 0644)).To(Succeed())
+
+			graph, err := prune.LoadLockfile(appDir)
+			Expect(err).To(BeNil())
+			Expect(graph.Keep(production, map[string]string{"ejs": "^3.1.9"})).To(Equal([]prune.Kept{
+				kept("ejs", "3.1.9", ""),
+				kept("jake", "10.8.7", "ejs"),
+				kept("minimatch", "3.1.2", "jake"),
+			}))
+		})
+	})
+
+	It("returns nil without a lockfile", func() {
+		graph, err := prune.LoadLockfile(filepath.Join("testdata", "missing"))
+		Expect(err).To(BeNil())
+		Expect(graph).To(BeNil())
+	})
+})
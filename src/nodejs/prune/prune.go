@@ -0,0 +1,203 @@
+package prune
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Dependency types which can be omitted from node_modules.
+const (
+	Dev      = "dev"
+	Optional = "optional"
+	Peer     = "peer"
+)
+
+// Package managers which know how to prune.
+const (
+	NPM  = "npm"
+	Yarn = "yarn"
+	PNPM = "pnpm"
+)
+
+// ParseOmit parses a comma separated BP_PRUNE_OMIT value.
+func ParseOmit(value string) ([]string, error) {
+	var omit []string
+	seen := map[string]bool{}
+	for _, kind := range strings.Split(value, ",") {
+		kind = strings.ToLower(strings.TrimSpace(kind))
+		if kind == "" || seen[kind] {
+			continue
+		}
+		if kind != Dev && kind != Optional && kind != Peer {
+			return nil, fmt.Errorf("unknown dependency type %q in BP_PRUNE_OMIT, expected a comma separated list of dev, optional and peer", kind)
+		}
+		seen[kind] = true
+		omit = append(omit, kind)
+	}
+	return omit, nil
+}
+
+// ParseKeep parses a comma separated BP_PRUNE_KEEP list of package names.
+func ParseKeep(value string) []string {
+	var keep []string
+	seen := map[string]bool{}
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+		keep = append(keep, name)
+	}
+	return keep
+}
+
+// Command returns the command line which prunes the omitted dependency types
+// with the given package manager version, or nil when there is nothing to
+// prune. Peer dependencies are never installed by yarn, so omitting them is a
+// no-op there.
+func Command(manager, version string, omit []string) ([]string, error) {
+	major, err := majorVersion(version)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse %s version %q", manager, version)
+	}
+
+	dev, optional, peer := contains(omit, Dev), contains(omit, Optional), contains(omit, Peer)
+
+	switch manager {
+	case NPM:
+		if major < 7 {
+			if optional || peer {
+				return nil, fmt.Errorf("npm %s can only omit dev dependencies when pruning, npm 7 or later is needed to omit optional or peer dependencies", version)
+			}
+			if !dev {
+				return nil, nil
+			}
+			return []string{"npm", "prune", "--production"}, nil
+		}
+		args := []string{"npm", "prune"}
+		for _, kind := range []string{Dev, Optional, Peer} {
+			if contains(omit, kind) {
+				args = append(args, "--omit="+kind)
+			}
+		}
+		if !dev {
+			args = append(args, "--include=dev")
+		}
+		return args, nil
+
+	case Yarn:
+		if major >= 2 {
+			if optional {
+				return nil, fmt.Errorf("yarn %s cannot omit optional dependencies when pruning", version)
+			}
+			if !dev {
+				return nil, nil
+			}
+			return []string{"yarn", "workspaces", "focus", "--all", "--production"}, nil
+		}
+		if !dev && !optional {
+			return nil, nil
+		}
+		args := []string{"yarn", "install", "--pure-lockfile", "--ignore-engines", "--production=" + strconv.FormatBool(dev)}
+		if optional {
+			args = append(args, "--ignore-optional")
+		}
+		return args, nil
+
+	case PNPM:
+		if peer {
+			return nil, fmt.Errorf("pnpm %s cannot omit peer dependencies when pruning", version)
+		}
+		if !dev && !optional {
+			return nil, nil
+		}
+		args := []string{"pnpm", "prune"}
+		if dev {
+			args = append(args, "--prod")
+		}
+		if optional {
+			args = append(args, "--no-optional")
+		}
+		return args, nil
+	}
+
+	return nil, fmt.Errorf("unsupported package manager %s", manager)
+}
+
+func majorVersion(version string) (int, error) {
+	return strconv.Atoi(strings.SplitN(strings.TrimPrefix(strings.TrimSpace(version), "v"), ".", 2)[0])
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// IsEmpty is whether nodeModules is missing or holds nothing but the dot
+// entries package managers leave behind, like .bin and .package-lock.json,
+// as after pruning an app with only devDependencies.
+func IsEmpty(nodeModules string) (bool, error) {
+	entries, err := ioutil.ReadDir(nodeModules)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return true, nil
+		}
+		return false, err
+	}
+	for _, entry := range entries {
+		if !strings.HasPrefix(entry.Name(), ".") {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// CountPackages returns the number of packages installed in nodeModules,
+// including scoped and nested packages.
+func CountPackages(nodeModules string) (int, error) {
+	entries, err := ioutil.ReadDir(nodeModules)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	count := 0
+	for _, entry := range entries {
+		path := filepath.Join(nodeModules, entry.Name())
+		if !entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		if strings.HasPrefix(entry.Name(), "@") {
+			n, err := CountPackages(path)
+			if err != nil {
+				return 0, err
+			}
+			count += n
+			continue
+		}
+
+		if _, err := os.Stat(filepath.Join(path, "package.json")); err == nil {
+			count++
+		} else if !os.IsNotExist(err) {
+			return 0, err
+		}
+
+		n, err := CountPackages(filepath.Join(path, "node_modules"))
+		if err != nil {
+			return 0, err
+		}
+		count += n
+	}
+	return count, nil
+}
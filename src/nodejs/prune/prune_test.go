@@ -0,0 +1,117 @@
+package prune_test
+
+import (
+	"io/ioutil"
+	"nodejs/prune"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Prune", func() {
+	Describe("ParseOmit", func() {
+		It("parses a comma separated list", func() {
+			omit, err := prune.ParseOmit(" dev, Optional,,dev ")
+			Expect(err).To(BeNil())
+			Expect(omit).To(Equal([]string{"dev", "optional"}))
+		})
+
+		It("rejects unknown types", func() {
+			_, err := prune.ParseOmit("dev,bundled")
+			Expect(err).To(MatchError(ContainSubstring(`unknown dependency type "bundled"`)))
+		})
+	})
+
+	DescribeTable("Command",
+		func(manager, version string, omit []string, expected []string) {
+			args, err := prune.Command(manager, version, omit)
+			Expect(err).To(BeNil())
+			Expect(args).To(Equal(expected))
+		},
+		Entry("npm 6 dev", "npm", "6.14.18", []string{"dev"}, []string{"npm", "prune", "--production"}),
+		Entry("npm 6 nothing", "npm", "6.14.18", []string{}, nil),
+		Entry("npm 8 dev", "npm", "8.19.4", []string{"dev"}, []string{"npm", "prune", "--omit=dev"}),
+		Entry("npm 8 dev and optional", "npm", "8.19.4", []string{"optional", "dev"}, []string{"npm", "prune", "--omit=dev", "--omit=optional"}),
+		Entry("npm 10 optional keeps dev", "npm", "10.2.4", []string{"optional"}, []string{"npm", "prune", "--omit=optional", "--include=dev"}),
+		Entry("npm 10 all", "npm", "10.2.4", []string{"dev", "optional", "peer"}, []string{"npm", "prune", "--omit=dev", "--omit=optional", "--omit=peer"}),
+		Entry("yarn classic dev", "yarn", "1.22.19", []string{"dev"}, []string{"yarn", "install", "--pure-lockfile", "--ignore-engines", "--production=true"}),
+		Entry("yarn classic optional", "yarn", "1.22.19", []string{"optional"}, []string{"yarn", "install", "--pure-lockfile", "--ignore-engines", "--production=false", "--ignore-optional"}),
+		Entry("yarn classic peer", "yarn", "1.22.19", []string{"peer"}, nil),
+		Entry("yarn berry dev", "yarn", "3.6.4", []string{"dev", "peer"}, []string{"yarn", "workspaces", "focus", "--all", "--production"}),
+		Entry("yarn berry peer", "yarn", "4.0.2", []string{"peer"}, nil),
+		Entry("pnpm dev", "pnpm", "8.10.0", []string{"dev"}, []string{"pnpm", "prune", "--prod"}),
+		Entry("pnpm dev and optional", "pnpm", "8.10.0", []string{"dev", "optional"}, []string{"pnpm", "prune", "--prod", "--no-optional"}),
+	)
+
+	DescribeTable("unsupported combinations",
+		func(manager, version string, omit []string, message string) {
+			_, err := prune.Command(manager, version, omit)
+			Expect(err).To(MatchError(ContainSubstring(message)))
+		},
+		Entry("npm 6 optional", "npm", "6.14.18", []string{"dev", "optional"}, "npm 7 or later is needed"),
+		Entry("yarn berry optional", "yarn", "3.6.4", []string{"optional"}, "cannot omit optional dependencies"),
+		Entry("pnpm peer", "pnpm", "8.10.0", []string{"peer"}, "cannot omit peer dependencies"),
+		Entry("unparseable version", "npm", "unknown", []string{"dev"}, `unable to parse npm version "unknown"`),
+		Entry("unknown package manager", "bun", "1.0.0", []string{"dev"}, "unsupported package manager bun"),
+	)
+
+	Describe("CountPackages", func() {
+		var dir string
+
+		BeforeEach(func() {
+			var err error
+			dir, err = ioutil.TempDir("", "nodejs-buildpack.prune.")
+			Expect(err).To(BeNil())
+		})
+
+		AfterEach(func() {
+			Expect(os.RemoveAll(dir)).To(Succeed())
+		})
+
+		It("counts top level, scoped and nested packages", func() {
+			for _, pkg := range []string{"express", "@scope/a", "@scope/b", "express/node_modules/debug", ".bin/fake"} {
+				Expect(os.MkdirAll(filepath.Join(dir, "node_modules", pkg), 0755)).To(Succeed())
+				Expect(ioutil.WriteFile(filepath.Join(dir, "node_modules", pkg, "package.json"), []byte("{}"), 0644)).To(Succeed())
+			}
+
+			Expect(prune.CountPackages(filepath.Join(dir, "node_modules"))).To(Equal(4))
+		})
+
+		It("returns 0 without node_modules", func() {
+			Expect(prune.CountPackages(filepath.Join(dir, "node_modules"))).To(Equal(0))
+		})
+	})
+
+	Describe("IsEmpty", func() {
+		var dir string
+
+		BeforeEach(func() {
+			var err error
+			dir, err = ioutil.TempDir("", "nodejs-buildpack.prune.")
+			Expect(err).To(BeNil())
+		})
+
+		AfterEach(func() {
+			Expect(os.RemoveAll(dir)).To(Succeed())
+		})
+
+		It("is empty with only the dot entries left by pruning", func() {
+			Expect(os.MkdirAll(filepath.Join(dir, "node_modules", ".bin"), 0755)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(dir, "node_modules", ".package-lock.json"), []byte("{}"), 0644)).To(Succeed())
+			Expect(prune.IsEmpty(filepath.Join(dir, "node_modules"))).To(BeTrue())
+		})
+
+		It("is empty without node_modules", func() {
+			Expect(prune.IsEmpty(filepath.Join(dir, "node_modules"))).To(BeTrue())
+		})
+
+		It("is not empty with a linked package", func() {
+			Expect(os.MkdirAll(filepath.Join(dir, "node_modules"), 0755)).To(Succeed())
+			Expect(os.Symlink("../packages/api", filepath.Join(dir, "node_modules", "api"))).To(Succeed())
+			Expect(prune.IsEmpty(filepath.Join(dir, "node_modules"))).To(BeFalse())
+		})
+	})
+})
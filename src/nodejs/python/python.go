@@ -0,0 +1,128 @@
+package python
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/Masterminds/semver"
+)
+
+type Command interface {
+	Execute(string, io.Writer, io.Writer, string, ...string) error
+}
+
+// Interpreter is a python found on the stack.
+type Interpreter struct {
+	Path    string
+	Version string
+}
+
+func (i Interpreter) String() string {
+	return fmt.Sprintf("%s (%s)", i.Version, i.Path)
+}
+
+var (
+	interpreterName = regexp.MustCompile(`^python(\d+(\.\d+)?)?$`)
+	versionOutput   = regexp.MustCompile(`Python (\d+\.\d+(\.\d+)?)`)
+	minorVersion    = regexp.MustCompile(`^\d+\.\d+$`)
+)
+
+// Find lists the python, pythonN and pythonN.M executables in dirs, searched
+// in order like PATH, with the versions they report. Names found earlier
+// shadow later ones, links to the same binary are listed once, and
+// interpreters which fail to report a version are skipped.
+func Find(command Command, dirs []string) ([]Interpreter, error) {
+	var interpreters []Interpreter
+	names := map[string]bool{}
+	binaries := map[string]bool{}
+	for _, dir := range dirs {
+		if dir == "" {
+			continue
+		}
+		entries, err := ioutil.ReadDir(dir)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+
+		for _, entry := range entries {
+			if !interpreterName.MatchString(entry.Name()) || names[entry.Name()] {
+				continue
+			}
+			path := filepath.Join(dir, entry.Name())
+			info, err := os.Stat(path)
+			if err != nil || info.IsDir() || info.Mode()&0111 == 0 {
+				continue
+			}
+			names[entry.Name()] = true
+
+			binary, err := filepath.EvalSymlinks(path)
+			if err != nil {
+				return nil, err
+			}
+			if binaries[binary] {
+				continue
+			}
+			binaries[binary] = true
+
+			// python 2 prints its version to stderr
+			output := new(bytes.Buffer)
+			if err := command.Execute("", output, output, path, "--version"); err != nil {
+				continue
+			}
+			if m := versionOutput.FindStringSubmatch(output.String()); m != nil {
+				interpreters = append(interpreters, Interpreter{Path: path, Version: m[1]})
+			}
+		}
+	}
+	return interpreters, nil
+}
+
+// Select returns the newest interpreter matching constraint, an npm style
+// range where a bare major.minor like 3.10 stands for 3.10.x, and whether
+// there is one. The first found wins between interpreters of the same
+// version.
+func Select(interpreters []Interpreter, constraint string) (Interpreter, bool, error) {
+	var sets []string
+	for _, set := range strings.Split(constraint, "||") {
+		comparators := strings.Fields(set)
+		for i, comparator := range comparators {
+			if minorVersion.MatchString(comparator) {
+				comparators[i] = "~" + comparator
+			}
+		}
+		sets = append(sets, strings.Join(comparators, ", "))
+	}
+	c, err := semver.NewConstraint(strings.Join(sets, " || "))
+	if err != nil {
+		return Interpreter{}, false, fmt.Errorf("invalid python version %q: %s", constraint, err)
+	}
+
+	var matching []Interpreter
+	versions := map[string]*semver.Version{}
+	for _, interpreter := range interpreters {
+		v, err := semver.NewVersion(interpreter.Version)
+		if err != nil || !c.Check(v) {
+			continue
+		}
+		versions[interpreter.Path] = v
+		matching = append(matching, interpreter)
+	}
+	if len(matching) == 0 {
+		return Interpreter{}, false, nil
+	}
+
+	sort.SliceStable(matching, func(i, j int) bool {
+		return versions[matching[j].Path].LessThan(versions[matching[i].Path])
+	})
+	return matching[0], true, nil
+}
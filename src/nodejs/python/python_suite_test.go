@@ -0,0 +1,13 @@
+package python_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestPython(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Python Suite")
+}
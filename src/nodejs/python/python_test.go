@@ -0,0 +1,139 @@
+package python_test
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"nodejs/python"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+// fakeCommand prints the version of the interpreters it knows about, python
+// 2 style on stderr for 2.x.
+type fakeCommand struct {
+	versions map[string]string
+	calls    []string
+}
+
+func (c *fakeCommand) Execute(dir string, stdout, stderr io.Writer, program string, args ...string) error {
+	c.calls = append(c.calls, program)
+	version, found := c.versions[program]
+	if !found {
+		return errors.New("exit status 127")
+	}
+	if version[0] == '2' {
+		fmt.Fprintf(stderr, "Python %s\n", version)
+	} else {
+		fmt.Fprintf(stdout, "Python %s\n", version)
+	}
+	return nil
+}
+
+var _ = Describe("Python", func() {
+	var (
+		root    string
+		usrBin  string
+		local   string
+		command *fakeCommand
+	)
+
+	executable := func(path string) {
+		Expect(os.MkdirAll(filepath.Dir(path), 0755)).To(Succeed())
+		Expect(ioutil.WriteFile(path, []byte("#!/bin/sh\n"), 0755)).To(Succeed())
+	}
+
+	BeforeEach(func() {
+		var err error
+		root, err = ioutil.TempDir("", "nodejs-buildpack.python.")
+		Expect(err).To(BeNil())
+		usrBin = filepath.Join(root, "usr", "bin")
+		local = filepath.Join(root, "usr", "local", "bin")
+
+		executable(filepath.Join(usrBin, "python3.10"))
+		Expect(os.Symlink("python3.10", filepath.Join(usrBin, "python3"))).To(Succeed())
+		executable(filepath.Join(usrBin, "python2.7"))
+		executable(filepath.Join(usrBin, "python3-config"))
+		Expect(ioutil.WriteFile(filepath.Join(usrBin, "python3.8"), []byte(""), 0644)).To(Succeed())
+		executable(filepath.Join(local, "python3"))
+		executable(filepath.Join(local, "python3.12"))
+		executable(filepath.Join(local, "python3.6"))
+
+		command = &fakeCommand{versions: map[string]string{
+			filepath.Join(usrBin, "python3.10"): "3.10.12",
+			filepath.Join(usrBin, "python3"):    "3.10.12",
+			filepath.Join(usrBin, "python2.7"):  "2.7.18",
+			filepath.Join(local, "python3"):     "3.12.1",
+			filepath.Join(local, "python3.12"):  "3.12.1",
+		}}
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(root)).To(Succeed())
+	})
+
+	Describe("Find", func() {
+		It("lists the interpreters in the order of the dirs", func() {
+			interpreters, err := python.Find(command, []string{local, filepath.Join(root, "missing"), "", usrBin})
+			Expect(err).To(BeNil())
+			Expect(interpreters).To(Equal([]python.Interpreter{
+				{Path: filepath.Join(local, "python3"), Version: "3.12.1"},
+				{Path: filepath.Join(local, "python3.12"), Version: "3.12.1"},
+				{Path: filepath.Join(usrBin, "python2.7"), Version: "2.7.18"},
+				{Path: filepath.Join(usrBin, "python3.10"), Version: "3.10.12"},
+			}))
+		})
+
+		It("skips shadowed names, links to listed binaries, non executables and broken interpreters", func() {
+			_, err := python.Find(command, []string{local, usrBin})
+			Expect(err).To(BeNil())
+			Expect(command.calls).To(Equal([]string{
+				filepath.Join(local, "python3"),
+				filepath.Join(local, "python3.12"),
+				filepath.Join(local, "python3.6"),
+				filepath.Join(usrBin, "python2.7"),
+				filepath.Join(usrBin, "python3.10"),
+			}))
+		})
+
+		It("lists a link when its target is not found", func() {
+			interpreters, err := python.Find(command, []string{usrBin})
+			Expect(err).To(BeNil())
+			Expect(interpreters).To(ContainElement(python.Interpreter{Path: filepath.Join(usrBin, "python3"), Version: "3.10.12"}))
+			Expect(interpreters).NotTo(ContainElement(python.Interpreter{Path: filepath.Join(usrBin, "python3.10"), Version: "3.10.12"}))
+		})
+	})
+
+	DescribeTable("Select",
+		func(constraint, path string) {
+			interpreters := []python.Interpreter{
+				{Path: "/usr/local/bin/python3", Version: "3.12.1"},
+				{Path: "/usr/bin/python2.7", Version: "2.7.18"},
+				{Path: "/usr/bin/python3", Version: "3.10.12"},
+				{Path: "/usr/bin/python3.12", Version: "3.12.1"},
+			}
+			selected, found, err := python.Select(interpreters, constraint)
+			Expect(err).To(BeNil())
+			Expect(found).To(Equal(path != ""))
+			Expect(selected.Path).To(Equal(path))
+		},
+		Entry("a major version, the newest", "3", "/usr/local/bin/python3"),
+		Entry("python 2", "2", "/usr/bin/python2.7"),
+		Entry("a minor version", "3.10", "/usr/bin/python3"),
+		Entry("a range", ">=3.8 <3.12", "/usr/bin/python3"),
+		Entry("alternatives", "2.7 || 3.10", "/usr/bin/python3"),
+		Entry("a wildcard", "2.x", "/usr/bin/python2.7"),
+		Entry("an exact version", "3.12.1", "/usr/local/bin/python3"),
+		Entry("a version which is not installed", "3.11", ""),
+	)
+
+	It("rejects invalid constraints", func() {
+		_, _, err := python.Select(nil, "three")
+		Expect(err).To(MatchError(ContainSubstring(`invalid python version "three"`)))
+	})
+})
@@ -0,0 +1,227 @@
+package resolved
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Store is a dir of tarballs, found by their integrity rather than their
+// names, which differ between registries and tools.
+type Store struct {
+	// paths maps the sha512 and sha1 integrity of every tarball, like
+	// sha512-<base64>, to its path.
+	paths map[string]string
+}
+
+// LoadStore hashes the files in dir and below.
+func LoadStore(dir string) (*Store, error) {
+	store := &Store{paths: map[string]string{}}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || !info.Mode().IsRegular() {
+			return err
+		}
+		hashes := map[string]hash.Hash{"sha512": sha512.New(), "sha1": sha1.New()}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if _, err := io.Copy(io.MultiWriter(hashes["sha512"], hashes["sha1"]), f); err != nil {
+			return err
+		}
+		for algorithm, h := range hashes {
+			store.paths[algorithm+"-"+base64.StdEncoding.EncodeToString(h.Sum(nil))] = path
+		}
+		return nil
+	})
+	return store, err
+}
+
+// Find returns the tarball matching one of the hashes of integrity, an SRI
+// string, and that hash.
+func (s *Store) Find(integrity string) (string, string, bool) {
+	for _, hash := range strings.Fields(integrity) {
+		if idx := strings.Index(hash, "?"); idx >= 0 {
+			hash = hash[:idx]
+		}
+		if path, found := s.paths[hash]; found {
+			return path, hash, true
+		}
+	}
+	return "", "", false
+}
+
+// Prime adds the tarballs found in store to the content-addressable cache of
+// npm at cacacheDir, the _cacache dir of its --cache: the content under its
+// integrity, which npm reads the tarballs of lockfile entries by, and an index
+// entry under its URL, which it falls back to. It returns the tarballs store
+// lacks.
+func Prime(cacacheDir string, store *Store, tarballs []Tarball) ([]Tarball, error) {
+	var missing []Tarball
+	for _, tarball := range tarballs {
+		path, integrity, found := store.Find(tarball.Integrity)
+		if !found {
+			missing = append(missing, tarball)
+			continue
+		}
+		size, err := writeContent(cacacheDir, integrity, path)
+		if err != nil {
+			return nil, err
+		}
+		if err := writeIndexEntry(cacacheDir, tarball.Resolved, integrity, size); err != nil {
+			return nil, err
+		}
+	}
+	return missing, nil
+}
+
+// ContentPath is where cacache keeps the content with integrity, like
+// sha512-<base64>: content-v2/sha512/ab/cd/<rest of the hex digest>.
+func ContentPath(cacacheDir, integrity string) (string, error) {
+	parts := strings.SplitN(integrity, "-", 2)
+	if len(parts) != 2 {
+		return "", fmt.Errorf("invalid integrity %s", integrity)
+	}
+	digest, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", fmt.Errorf("invalid integrity %s: %s", integrity, err)
+	}
+	hexDigest := hex.EncodeToString(digest)
+	return filepath.Join(cacacheDir, "content-v2", parts[0], hexDigest[0:2], hexDigest[2:4], hexDigest[4:]), nil
+}
+
+// IndexKey is the key make-fetch-happen caches the response for url under.
+func IndexKey(url string) string {
+	return "make-fetch-happen:request-cache:" + url
+}
+
+// IndexPath is the bucket cacache appends the entries for key to:
+// index-v5/ab/cd/<rest of the hex sha256 of key>.
+func IndexPath(cacacheDir, key string) string {
+	sum := sha256.Sum256([]byte(key))
+	hexKey := hex.EncodeToString(sum[:])
+	return filepath.Join(cacacheDir, "index-v5", hexKey[0:2], hexKey[2:4], hexKey[4:])
+}
+
+// IndexEntry is an entry of an index bucket of cacache.
+type IndexEntry struct {
+	Key       string        `json:"key"`
+	Integrity string        `json:"integrity"`
+	Time      int64         `json:"time"`
+	Size      int64         `json:"size"`
+	Metadata  IndexMetadata `json:"metadata"`
+}
+
+// IndexMetadata is what make-fetch-happen stores about the response.
+type IndexMetadata struct {
+	URL        string            `json:"url"`
+	ReqHeaders map[string]string `json:"reqHeaders"`
+	ResHeaders map[string]string `json:"resHeaders"`
+}
+
+// ReadIndex returns the entries in the bucket of key, oldest first.
+func ReadIndex(cacacheDir, key string) ([]IndexEntry, error) {
+	contents, err := ioutil.ReadFile(IndexPath(cacacheDir, key))
+	if err != nil {
+		return nil, err
+	}
+	var entries []IndexEntry
+	for _, line := range strings.Split(string(contents), "\n") {
+		fields := strings.SplitN(line, "\t", 2)
+		if len(fields) != 2 || fields[0] != hashEntry(fields[1]) {
+			continue
+		}
+		var entry IndexEntry
+		if err := json.Unmarshal([]byte(fields[1]), &entry); err != nil {
+			continue
+		}
+		if entry.Key == key {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+func writeContent(cacacheDir, integrity, source string) (int64, error) {
+	target, err := ContentPath(cacacheDir, integrity)
+	if err != nil {
+		return 0, err
+	}
+	if info, err := os.Stat(target); err == nil {
+		return info.Size(), nil
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return 0, err
+	}
+
+	in, err := os.Open(source)
+	if err != nil {
+		return 0, err
+	}
+	defer in.Close()
+	// cacache moves content into place, so that a reader never sees part of
+	// it.
+	out, err := ioutil.TempFile(filepath.Dir(target), ".tmp")
+	if err != nil {
+		return 0, err
+	}
+	size, err := io.Copy(out, in)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(out.Name())
+		return 0, err
+	}
+	if err := os.Chmod(out.Name(), 0444); err != nil {
+		return 0, err
+	}
+	return size, os.Rename(out.Name(), target)
+}
+
+func writeIndexEntry(cacacheDir, url, integrity string, size int64) error {
+	key := IndexKey(url)
+	entry, err := json.Marshal(IndexEntry{
+		Key:       key,
+		Integrity: integrity,
+		Time:      time.Now().UnixNano() / int64(time.Millisecond),
+		Size:      size,
+		Metadata:  IndexMetadata{URL: url, ReqHeaders: map[string]string{}, ResHeaders: map[string]string{}},
+	})
+	if err != nil {
+		return err
+	}
+
+	bucket := IndexPath(cacacheDir, key)
+	if err := os.MkdirAll(filepath.Dir(bucket), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(bucket, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(f, "\n%s\t%s", hashEntry(string(entry)), entry); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// hashEntry is the sha1 cacache prefixes index lines with to detect torn
+// writes.
+func hashEntry(entry string) string {
+	sum := sha1.Sum([]byte(entry))
+	return hex.EncodeToString(sum[:])
+}
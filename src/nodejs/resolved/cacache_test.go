@@ -0,0 +1,116 @@
+package resolved_test
+
+import (
+	"crypto/sha512"
+	"encoding/base64"
+	"io/ioutil"
+	"nodejs/resolved"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("cacache", func() {
+	var (
+		cacacheDir string
+		store      *resolved.Store
+	)
+
+	BeforeEach(func() {
+		var err error
+		cacacheDir, err = ioutil.TempDir("", "nodejs-buildpack.cacache.")
+		Expect(err).To(BeNil())
+		store, err = resolved.LoadStore(filepath.Join("testdata", "registry"))
+		Expect(err).To(BeNil())
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(cacacheDir)).To(Succeed())
+	})
+
+	It("lays out content by integrity and the index by key like cacache", func() {
+		Expect(resolved.ContentPath("/cache", "sha1-KDBOWlqx4H0KXYt0kkDOMUM05+g=")).To(Equal("/cache/content-v2/sha1/28/30/4e5a5ab1e07d0a5d8b749240ce314334e7e8"))
+		Expect(resolved.IndexPath("/cache", resolved.IndexKey("https://registry.npmjs.org/left-pad/-/left-pad-1.3.0.tgz"))).To(Equal("/cache/index-v5/14/63/7e3b1e91110df0fdc2730d3aa678c31a712ebfabe5349ea1e15fc71a45c2"))
+
+		_, err := resolved.ContentPath("/cache", "sha512")
+		Expect(err).To(MatchError("invalid integrity sha512"))
+	})
+
+	It("finds tarballs by any hash of an integrity", func() {
+		path, integrity, found := store.Find("sha256-AAAA sha1-KDBOWlqx4H0KXYt0kkDOMUM05+g=?foo")
+		Expect(found).To(BeTrue())
+		Expect(path).To(Equal(filepath.Join("testdata", "registry", "left-pad-1.3.0.tgz")))
+		Expect(integrity).To(Equal("sha1-KDBOWlqx4H0KXYt0kkDOMUM05+g="))
+
+		_, _, found = store.Find("sha512-AAAA")
+		Expect(found).To(BeFalse())
+	})
+
+	for _, fixture := range []string{"npm", "npm_v1", "yarn"} {
+		fixture := fixture
+
+		It("round trips the tarballs of the "+fixture+" lockfile through the cache", func() {
+			manifest, err := resolved.FromLockfile(filepath.Join("testdata", fixture))
+			Expect(err).To(BeNil())
+			Expect(manifest.Tarballs).To(HaveLen(3))
+
+			missing, err := resolved.Prime(cacacheDir, store, manifest.Tarballs)
+			Expect(err).To(BeNil())
+			Expect(missing).To(BeEmpty())
+
+			for _, tarball := range manifest.Tarballs {
+				path, _, _ := store.Find(tarball.Integrity)
+				expected, err := ioutil.ReadFile(path)
+				Expect(err).To(BeNil())
+
+				content, err := resolved.ContentPath(cacacheDir, tarball.Integrity)
+				Expect(err).To(BeNil())
+				Expect(ioutil.ReadFile(content)).To(Equal(expected))
+
+				entries, err := resolved.ReadIndex(cacacheDir, resolved.IndexKey(tarball.Resolved))
+				Expect(err).To(BeNil())
+				Expect(entries).To(HaveLen(1))
+				Expect(entries[0].Integrity).To(Equal(tarball.Integrity))
+				Expect(entries[0].Size).To(Equal(int64(len(expected))))
+				Expect(entries[0].Metadata.URL).To(Equal(tarball.Resolved))
+			}
+		})
+	}
+
+	It("stores the content under the integrity which matched", func() {
+		tarball := resolved.Tarball{Name: "left-pad", Version: "1.3.0", Resolved: "https://registry.npmjs.org/left-pad/-/left-pad-1.3.0.tgz", Integrity: "sha512-AAAA sha1-KDBOWlqx4H0KXYt0kkDOMUM05+g="}
+
+		Expect(resolved.Prime(cacacheDir, store, []resolved.Tarball{tarball})).To(BeEmpty())
+		Expect(filepath.Join(cacacheDir, "content-v2", "sha1", "28", "30", "4e5a5ab1e07d0a5d8b749240ce314334e7e8")).To(BeAnExistingFile())
+		entries, err := resolved.ReadIndex(cacacheDir, resolved.IndexKey(tarball.Resolved))
+		Expect(err).To(BeNil())
+		Expect(entries[0].Integrity).To(Equal("sha1-KDBOWlqx4H0KXYt0kkDOMUM05+g="))
+	})
+
+	It("returns the tarballs missing from the store", func() {
+		sum := sha512.Sum512([]byte("unpublished"))
+		gone := resolved.Tarball{Name: "gone", Version: "1.0.0", Resolved: "https://registry.npmjs.org/gone/-/gone-1.0.0.tgz", Integrity: "sha512-" + base64.StdEncoding.EncodeToString(sum[:])}
+		manifest, err := resolved.FromLockfile(filepath.Join("testdata", "npm"))
+		Expect(err).To(BeNil())
+
+		missing, err := resolved.Prime(cacacheDir, store, append(manifest.Tarballs, gone))
+		Expect(err).To(BeNil())
+		Expect(missing).To(Equal([]resolved.Tarball{gone}))
+		content, err := resolved.ContentPath(cacacheDir, gone.Integrity)
+		Expect(err).To(BeNil())
+		Expect(content).NotTo(BeAnExistingFile())
+	})
+
+	It("keeps content already in the cache", func() {
+		manifest, err := resolved.FromLockfile(filepath.Join("testdata", "npm"))
+		Expect(err).To(BeNil())
+
+		Expect(resolved.Prime(cacacheDir, store, manifest.Tarballs)).To(BeEmpty())
+		Expect(resolved.Prime(cacacheDir, store, manifest.Tarballs)).To(BeEmpty())
+		entries, err := resolved.ReadIndex(cacacheDir, resolved.IndexKey(manifest.Tarballs[0].Resolved))
+		Expect(err).To(BeNil())
+		Expect(entries).To(HaveLen(2))
+	})
+})
@@ -0,0 +1,204 @@
+package resolved
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ManifestFile lists the tarballs an install resolved to, in the dep dir and
+// the cache.
+const ManifestFile = "resolved-manifest.json"
+
+// Tarball is a package tarball the lockfile resolved, with the integrity
+// npm and yarn verify it by.
+type Tarball struct {
+	Name      string `json:"name"`
+	Version   string `json:"version"`
+	Resolved  string `json:"resolved"`
+	Integrity string `json:"integrity"`
+}
+
+func (t Tarball) String() string {
+	return t.Name + "@" + t.Version
+}
+
+// Manifest is the tarballs of the lockfile of an app.
+type Manifest struct {
+	Lockfile string    `json:"lockfile"`
+	Tarballs []Tarball `json:"tarballs"`
+}
+
+// FromLockfile reads the registry tarballs from npm-shrinkwrap.json,
+// package-lock.json or a yarn 1 yarn.lock in appDir, sorted by package. Git,
+// file and link dependencies, which have no tarball with an integrity, are
+// left out. Without a lockfile the manifest is empty.
+func FromLockfile(appDir string) (Manifest, error) {
+	for _, name := range []string{"npm-shrinkwrap.json", "package-lock.json", "yarn.lock"} {
+		contents, err := ioutil.ReadFile(filepath.Join(appDir, name))
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return Manifest{}, err
+		}
+
+		var tarballs []Tarball
+		if name == "yarn.lock" {
+			if bytes.Contains(contents, []byte("__metadata:")) {
+				return Manifest{}, fmt.Errorf("yarn 2+ lockfiles are not supported, their cache already pins the packages")
+			}
+			tarballs, err = parseYarnLock(contents)
+		} else {
+			tarballs, err = parseNPMLock(contents)
+		}
+		if err != nil {
+			return Manifest{}, fmt.Errorf("unable to parse %s: %s", name, err)
+		}
+		return Manifest{Lockfile: name, Tarballs: unique(tarballs)}, nil
+	}
+	return Manifest{}, nil
+}
+
+type npmLockEntry struct {
+	Name      string `json:"name"`
+	Version   string `json:"version"`
+	Resolved  string `json:"resolved"`
+	Integrity string `json:"integrity"`
+	Link      bool   `json:"link"`
+}
+
+type npmLockV1Entry struct {
+	Version      string                    `json:"version"`
+	Resolved     string                    `json:"resolved"`
+	Integrity    string                    `json:"integrity"`
+	Dependencies map[string]npmLockV1Entry `json:"dependencies"`
+}
+
+// parseNPMLock reads the packages section of lockfile v2 and v3, or the
+// nested dependencies of v1.
+func parseNPMLock(contents []byte) ([]Tarball, error) {
+	var lock struct {
+		Packages     map[string]npmLockEntry   `json:"packages"`
+		Dependencies map[string]npmLockV1Entry `json:"dependencies"`
+	}
+	if err := json.Unmarshal(contents, &lock); err != nil {
+		return nil, err
+	}
+
+	var tarballs []Tarball
+	if lock.Packages == nil {
+		flattenNPMLockV1(lock.Dependencies, &tarballs)
+		return tarballs, nil
+	}
+	for id, entry := range lock.Packages {
+		if id == "" || entry.Link {
+			continue
+		}
+		name := entry.Name
+		if name == "" {
+			name = id[strings.LastIndex(id, "node_modules/")+len("node_modules/"):]
+		}
+		tarballs = appendTarball(tarballs, Tarball{Name: name, Version: entry.Version, Resolved: entry.Resolved, Integrity: entry.Integrity})
+	}
+	return tarballs, nil
+}
+
+func flattenNPMLockV1(deps map[string]npmLockV1Entry, tarballs *[]Tarball) {
+	for name, dep := range deps {
+		*tarballs = appendTarball(*tarballs, Tarball{Name: name, Version: dep.Version, Resolved: dep.Resolved, Integrity: dep.Integrity})
+		flattenNPMLockV1(dep.Dependencies, tarballs)
+	}
+}
+
+// parseYarnLock reads a yarn 1 lockfile, whose entries are keyed by the
+// name@range descriptors which resolve to them.
+func parseYarnLock(contents []byte) ([]Tarball, error) {
+	var (
+		tarballs []Tarball
+		current  *Tarball
+	)
+	flush := func() {
+		if current != nil {
+			tarballs = appendTarball(tarballs, *current)
+		}
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(contents))
+	for scanner.Scan() {
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		indent := len(line) - len(strings.TrimLeft(line, " "))
+
+		switch {
+		case indent == 0:
+			flush()
+			descriptor := unquote(strings.TrimSpace(strings.Split(strings.TrimSuffix(trimmed, ":"), ",")[0]))
+			name := descriptor
+			if idx := strings.LastIndex(descriptor, "@"); idx > 0 {
+				name = descriptor[:idx]
+			}
+			current = &Tarball{Name: name}
+		case indent == 2 && current != nil:
+			fields := strings.SplitN(trimmed, " ", 2)
+			if len(fields) != 2 {
+				continue
+			}
+			switch value := unquote(fields[1]); fields[0] {
+			case "version":
+				current.Version = value
+			case "resolved":
+				current.Resolved = value
+			case "integrity":
+				current.Integrity = value
+			}
+		}
+	}
+	flush()
+	return tarballs, scanner.Err()
+}
+
+// appendTarball adds tarball unless it is not fetched from a registry, with
+// the #sha1 yarn 1 appends to URLs stripped.
+func appendTarball(tarballs []Tarball, tarball Tarball) []Tarball {
+	if idx := strings.Index(tarball.Resolved, "#"); idx >= 0 {
+		tarball.Resolved = tarball.Resolved[:idx]
+	}
+	if tarball.Integrity == "" || !(strings.HasPrefix(tarball.Resolved, "https://") || strings.HasPrefix(tarball.Resolved, "http://")) {
+		return tarballs
+	}
+	return append(tarballs, tarball)
+}
+
+func unique(tarballs []Tarball) []Tarball {
+	seen := map[Tarball]bool{}
+	var result []Tarball
+	for _, tarball := range tarballs {
+		if !seen[tarball] {
+			seen[tarball] = true
+			result = append(result, tarball)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Name != result[j].Name {
+			return result[i].Name < result[j].Name
+		}
+		if result[i].Version != result[j].Version {
+			return result[i].Version < result[j].Version
+		}
+		return result[i].Resolved < result[j].Resolved
+	})
+	return result
+}
+
+func unquote(s string) string {
+	return strings.Trim(s, `"`)
+}
@@ -0,0 +1,13 @@
+package resolved_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestResolved(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Resolved Suite")
+}
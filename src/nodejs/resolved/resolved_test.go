@@ -0,0 +1,63 @@
+package resolved_test
+
+import (
+	"io/ioutil"
+	"nodejs/resolved"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Resolved", func() {
+	const (
+		utilSHA512     = "sha512-2eMYPMcUonKgDdRkjilrqDy4p2n9+m7Fp2OycIwOWxfkxCQNTCrdmpQ0gzTEmm0H3hnNYVcmk6N1Dp0VNClwkw=="
+		isNumberSHA512 = "sha512-MSjtTCTwTnQxQi1Uxy/GeXBxgLvodBThbzK6Mmr8pkge+GK98m5TEcHQspLzRJzOEj1Qet7LOpK4LuhCJwzjaw=="
+		isNumberSHA1   = "sha1-2W+e+yRi7AzU6MR9q3AyijhVoh4="
+		leftPadSHA512  = "sha512-f4+znJtzX9sIkilHkCUtZmQC+ZA2AdawbiT/If50ed0t59U5Owk/TkrNAzrbSwA7EebVs9BTlr4EOJYRa1P0Sg=="
+	)
+
+	util := resolved.Tarball{Name: "@corp/util", Version: "2.0.0", Resolved: "https://npm.corp.example/@corp/util/-/util-2.0.0.tgz", Integrity: utilSHA512}
+	leftPad := func(registry string) resolved.Tarball {
+		return resolved.Tarball{Name: "left-pad", Version: "1.3.0", Resolved: "https://" + registry + "/left-pad/-/left-pad-1.3.0.tgz", Integrity: leftPadSHA512}
+	}
+	isNumber := func(registry, integrity string) resolved.Tarball {
+		return resolved.Tarball{Name: "is-number", Version: "7.0.0", Resolved: "https://" + registry + "/is-number/-/is-number-7.0.0.tgz", Integrity: integrity}
+	}
+
+	DescribeTable("FromLockfile",
+		func(fixture string, expected resolved.Manifest) {
+			Expect(resolved.FromLockfile(filepath.Join("testdata", fixture))).To(Equal(expected))
+		},
+		Entry("npm lockfile v3, without git deps, links and duplicate aliases", "npm", resolved.Manifest{
+			Lockfile: "package-lock.json",
+			Tarballs: []resolved.Tarball{util, isNumber("registry.npmjs.org", isNumberSHA512), leftPad("registry.npmjs.org")},
+		}),
+		Entry("npm lockfile v1, without file deps", "npm_v1", resolved.Manifest{
+			Lockfile: "package-lock.json",
+			Tarballs: []resolved.Tarball{util, isNumber("registry.npmjs.org", isNumberSHA1), leftPad("registry.npmjs.org")},
+		}),
+		Entry("yarn 1, without the #sha1 of URLs", "yarn", resolved.Manifest{
+			Lockfile: "yarn.lock",
+			Tarballs: []resolved.Tarball{util, isNumber("registry.yarnpkg.com", isNumberSHA512), leftPad("registry.yarnpkg.com")},
+		}),
+		Entry("no lockfile", "registry", resolved.Manifest{}),
+	)
+
+	It("refuses yarn 2+ lockfiles", func() {
+		_, err := resolved.FromLockfile(filepath.Join("testdata", "berry"))
+		Expect(err).To(MatchError("yarn 2+ lockfiles are not supported, their cache already pins the packages"))
+	})
+
+	It("fails for a broken lockfile", func() {
+		dir, err := ioutil.TempDir("", "nodejs-buildpack.resolved.")
+		Expect(err).To(BeNil())
+		defer os.RemoveAll(dir)
+		Expect(ioutil.WriteFile(filepath.Join(dir, "package-lock.json"), []byte("{"), 0644)).To(Succeed())
+
+		_, err = resolved.FromLockfile(dir)
+		Expect(err).To(MatchError(ContainSubstring("unable to parse package-lock.json")))
+	})
+})
@@ -0,0 +1,185 @@
+package singleton
+
+import (
+	"io/ioutil"
+	"nodejs/packagejson"
+	"nodejs/prune"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/Masterminds/semver"
+)
+
+// Default are packages which break in confusing ways, like failed instanceof
+// checks or hooks called outside a component, when two copies are loaded.
+var Default = []string{"react", "react-dom", "graphql", "rxjs", "prosemirror-model", "styled-components"}
+
+// ParseList parses BP_SINGLETON_PACKAGES, a comma separated list which
+// replaces Default. Empty means Default and none turns the check off.
+func ParseList(value string) []string {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return Default
+	}
+	if value == "none" {
+		return nil
+	}
+	var names []string
+	seen := map[string]bool{}
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		if name != "" && !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// Duplicate is a singleton package installed in more than one version.
+type Duplicate struct {
+	Name     string
+	Versions []string
+	// Chains are the dependency chains which pull in each version, from the
+	// lockfile. They are empty without a lockfile.
+	Chains map[string][]string
+}
+
+// Find returns the packages of watch which are installed in more than one
+// version in the node_modules of appDir, with the chains which pull in each
+// version according to the lockfile.
+func Find(appDir string, watch []string) ([]Duplicate, error) {
+	if len(watch) == 0 {
+		return nil, nil
+	}
+	watched := map[string]bool{}
+	for _, name := range watch {
+		watched[name] = true
+	}
+
+	versions := map[string]map[string]bool{}
+	if err := installedVersions(filepath.Join(appDir, "node_modules"), watched, versions); err != nil {
+		return nil, err
+	}
+
+	var duplicates []Duplicate
+	for _, name := range watch {
+		if len(versions[name]) < 2 {
+			continue
+		}
+		d := Duplicate{Name: name, Chains: map[string][]string{}}
+		for version := range versions[name] {
+			d.Versions = append(d.Versions, version)
+		}
+		sortVersions(d.Versions)
+		duplicates = append(duplicates, d)
+	}
+	if len(duplicates) == 0 {
+		return nil, nil
+	}
+
+	graph, err := prune.LoadLockfile(appDir)
+	if err != nil || graph == nil {
+		return duplicates, err
+	}
+	roots, err := appDependencies(appDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range duplicates {
+		for _, chain := range graph.Chains(roots, d.Name) {
+			version := chain[len(chain)-1].Version
+			d.Chains[version] = append(d.Chains[version], chain.String())
+		}
+	}
+	return duplicates, nil
+}
+
+// installedVersions records the versions of the watched packages below
+// nodeModules, including nested and scoped packages and the virtual store of
+// pnpm, whose node_modules/.pnpm/<name>@<version> directories hold every copy.
+func installedVersions(nodeModules string, watched map[string]bool, versions map[string]map[string]bool) error {
+	entries, err := ioutil.ReadDir(nodeModules)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	for _, entry := range entries {
+		path := filepath.Join(nodeModules, entry.Name())
+		if entry.Name() == ".pnpm" && entry.IsDir() {
+			stores, err := ioutil.ReadDir(path)
+			if err != nil {
+				return err
+			}
+			for _, store := range stores {
+				if err := installedVersions(filepath.Join(path, store.Name(), "node_modules"), watched, versions); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		// pnpm links packages into node_modules, read them but do not
+		// descend, the store holds their dependencies.
+		link := entry.Mode()&os.ModeSymlink != 0
+		if !entry.IsDir() && !link {
+			continue
+		}
+		if strings.HasPrefix(entry.Name(), "@") && !link {
+			if err := installedVersions(path, watched, versions); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if data, err := ioutil.ReadFile(filepath.Join(path, packagejson.File)); err == nil {
+			if pkg, err := packagejson.Parse(data); err == nil && watched[pkg.Name] && pkg.Version != "" {
+				if versions[pkg.Name] == nil {
+					versions[pkg.Name] = map[string]bool{}
+				}
+				versions[pkg.Name][pkg.Version] = true
+			}
+		} else if !os.IsNotExist(err) && !link {
+			return err
+		}
+
+		if !link {
+			if err := installedVersions(filepath.Join(path, "node_modules"), watched, versions); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func appDependencies(appDir string) (map[string]string, error) {
+	pkg, found, err := packagejson.Load(appDir)
+	if err != nil || !found {
+		return nil, err
+	}
+	roots := map[string]string{}
+	for _, deps := range []map[string]string{pkg.DevDependencies, pkg.OptionalDependencies, pkg.Dependencies} {
+		for name, spec := range deps {
+			roots[name] = spec
+		}
+	}
+	return roots, nil
+}
+
+func sortVersions(versions []string) {
+	sort.Slice(versions, func(i, j int) bool {
+		a, errA := semver.NewVersion(versions[i])
+		b, errB := semver.NewVersion(versions[j])
+		if errA != nil || errB != nil {
+			return versions[i] < versions[j]
+		}
+		return a.LessThan(b)
+	})
+}
@@ -0,0 +1,110 @@
+package singleton_test
+
+import (
+	"io/ioutil"
+	"nodejs/singleton"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Singleton", func() {
+	DescribeTable("ParseList",
+		func(value string, names []string) {
+			Expect(singleton.ParseList(value)).To(Equal(names))
+		},
+		Entry("empty", "", singleton.Default),
+		Entry("none", "none", []string(nil)),
+		Entry("a list", " vue, pinia,,vue ", []string{"vue", "pinia"}),
+	)
+
+	Describe("Find", func() {
+		var appDir string
+
+		writeFile := func(path, contents string) {
+			path = filepath.Join(appDir, path)
+			Expect(os.MkdirAll(filepath.Dir(path), 0755)).To(Succeed())
+			Expect(ioutil.WriteFile(path, []byte(contents), 0644)).To(Succeed())
+		}
+		install := func(dir, name, version string) {
+			writeFile(filepath.Join(dir, "package.json"), `{"name": "`+name+`", "version": "`+version+`"}`)
+		}
+
+		BeforeEach(func() {
+			var err error
+			appDir, err = ioutil.TempDir("", "nodejs-buildpack.singleton.")
+			Expect(err).To(BeNil())
+
+			writeFile("package.json", `{"dependencies": {"react": "^18.2.0", "old-ui": "^1.0.0"}, "devDependencies": {"@storybook/react": "^7.0.0"}}`)
+			install("node_modules/react", "react", "18.2.0")
+			install("node_modules/old-ui", "old-ui", "1.0.0")
+			install("node_modules/old-ui/node_modules/react", "react", "17.0.2")
+			install("node_modules/@storybook/react", "@storybook/react", "7.0.0")
+			install("node_modules/@storybook/react/node_modules/react", "react", "17.0.2")
+			install("node_modules/graphql", "graphql", "16.8.1")
+			install("node_modules/.cache/react", "react", "16.0.0")
+		})
+
+		AfterEach(func() {
+			Expect(os.RemoveAll(appDir)).To(Succeed())
+		})
+
+		It("finds nothing without node_modules", func() {
+			Expect(os.RemoveAll(filepath.Join(appDir, "node_modules"))).To(Succeed())
+			Expect(singleton.Find(appDir, singleton.Default)).To(BeEmpty())
+		})
+
+		It("finds nothing with an empty watch list", func() {
+			Expect(singleton.Find(appDir, nil)).To(BeEmpty())
+		})
+
+		It("finds the versions of watched packages, without the chains when there is no lockfile", func() {
+			Expect(singleton.Find(appDir, singleton.Default)).To(Equal([]singleton.Duplicate{
+				{Name: "react", Versions: []string{"17.0.2", "18.2.0"}, Chains: map[string][]string{}},
+			}))
+		})
+
+		It("only watches the listed packages", func() {
+			Expect(singleton.Find(appDir, []string{"graphql", "old-ui"})).To(BeEmpty())
+		})
+
+		It("attributes the versions to the chains from the lockfile", func() {
+			writeFile("package-lock.json", `{
+				"lockfileVersion": 3,
+				"packages": {
+					"": {"dependencies": {"react": "^18.2.0", "old-ui": "^1.0.0"}, "devDependencies": {"@storybook/react": "^7.0.0"}},
+					"node_modules/react": {"version": "18.2.0"},
+					"node_modules/old-ui": {"version": "1.0.0", "dependencies": {"react": "^17.0.0"}},
+					"node_modules/old-ui/node_modules/react": {"version": "17.0.2"},
+					"node_modules/@storybook/react": {"version": "7.0.0", "dev": true, "dependencies": {"react": "^17.0.0"}},
+					"node_modules/@storybook/react/node_modules/react": {"version": "17.0.2", "dev": true},
+					"node_modules/graphql": {"version": "16.8.1"}
+				}
+			}`)
+
+			Expect(singleton.Find(appDir, singleton.Default)).To(Equal([]singleton.Duplicate{{
+				Name:     "react",
+				Versions: []string{"17.0.2", "18.2.0"},
+				Chains: map[string][]string{
+					"17.0.2": {"@storybook/react@7.0.0 > react@17.0.2", "old-ui@1.0.0 > react@17.0.2"},
+					"18.2.0": {"react@18.2.0"},
+				},
+			}}))
+		})
+
+		It("finds the copies in the pnpm store", func() {
+			Expect(os.RemoveAll(filepath.Join(appDir, "node_modules"))).To(Succeed())
+			install("node_modules/.pnpm/react@18.2.0/node_modules/react", "react", "18.2.0")
+			install("node_modules/.pnpm/old-ui@1.0.0/node_modules/old-ui", "old-ui", "1.0.0")
+			install("node_modules/.pnpm/react@17.0.2/node_modules/react", "react", "17.0.2")
+			Expect(os.Symlink(filepath.Join(appDir, "node_modules", ".pnpm", "react@18.2.0", "node_modules", "react"), filepath.Join(appDir, "node_modules", "react"))).To(Succeed())
+
+			Expect(singleton.Find(appDir, []string{"react"})).To(Equal([]singleton.Duplicate{
+				{Name: "react", Versions: []string{"17.0.2", "18.2.0"}, Chains: map[string][]string{}},
+			}))
+		})
+	})
+})
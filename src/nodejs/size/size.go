@@ -0,0 +1,177 @@
+package size
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// MiB is a mebibyte, the unit sizes are reported in.
+const MiB = 1024 * 1024
+
+// Package is the disk usage of a top level entry of node_modules, with the
+// packages nested in it.
+type Package struct {
+	Name string
+	Size uint64
+}
+
+// Report is the disk usage of a node_modules dir.
+type Report struct {
+	Total    uint64
+	Packages []Package
+}
+
+// Top returns the n largest packages.
+func (r Report) Top(n int) []Package {
+	if len(r.Packages) < n {
+		return r.Packages
+	}
+	return r.Packages[:n]
+}
+
+// Lines renders the n largest packages, one per line.
+func (r Report) Lines(n int) string {
+	var lines []string
+	for _, pkg := range r.Top(n) {
+		lines = append(lines, fmt.Sprintf("  %s: %d MiB", pkg.Name, pkg.Size/MiB))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Measure adds up the regular files below root by the top level entry of
+// root they belong to, scoped packages by their full name, largest first. It
+// follows symlinks to dirs within root, like the links of pnpm, and counts
+// every dir once, so that a link back to a parent ends the walk rather than
+// looping. Links out of root, like those to workspaces, are not counted. A
+// missing root is empty.
+func Measure(root string) (Report, error) {
+	if resolved, err := filepath.EvalSymlinks(root); err == nil {
+		root = resolved
+	} else if os.IsNotExist(err) {
+		return Report{}, nil
+	} else {
+		return Report{}, err
+	}
+
+	w := walker{root: root, visited: map[string]bool{root: true}}
+	entries, err := w.entries(root)
+	if err != nil {
+		return Report{}, err
+	}
+
+	var report Report
+	for _, entry := range entries {
+		size, err := w.size(entry.path)
+		if err != nil {
+			return Report{}, err
+		}
+		report.Total += size
+		if size > 0 {
+			report.Packages = append(report.Packages, Package{Name: entry.name, Size: size})
+		}
+	}
+	sort.SliceStable(report.Packages, func(i, j int) bool { return report.Packages[i].Size > report.Packages[j].Size })
+	return report, nil
+}
+
+type walker struct {
+	root    string
+	visited map[string]bool
+}
+
+type topEntry struct {
+	name string
+	path string
+}
+
+// entries lists the top level entries of root, with the packages of scopes
+// on their own. Real dirs come before links, so that a dir reached both ways
+// is counted for its own name.
+func (w *walker) entries(root string) ([]topEntry, error) {
+	infos, err := ioutil.ReadDir(root)
+	if err != nil {
+		return nil, err
+	}
+	var dirs, links []topEntry
+	for _, info := range infos {
+		path := filepath.Join(root, info.Name())
+		if strings.HasPrefix(info.Name(), "@") && info.IsDir() {
+			scoped, err := ioutil.ReadDir(path)
+			if err != nil {
+				return nil, err
+			}
+			w.visited[path] = true
+			for _, pkg := range scoped {
+				entry := topEntry{name: info.Name() + "/" + pkg.Name(), path: filepath.Join(path, pkg.Name())}
+				if pkg.Mode()&os.ModeSymlink != 0 {
+					links = append(links, entry)
+				} else {
+					dirs = append(dirs, entry)
+				}
+			}
+			continue
+		}
+		entry := topEntry{name: info.Name(), path: path}
+		if info.Mode()&os.ModeSymlink != 0 {
+			links = append(links, entry)
+		} else {
+			dirs = append(dirs, entry)
+		}
+	}
+	return append(dirs, links...), nil
+}
+
+// size adds up the regular files below path which were not counted yet.
+func (w *walker) size(path string) (uint64, error) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	if info.Mode()&os.ModeSymlink != 0 {
+		resolved, err := filepath.EvalSymlinks(path)
+		if err != nil {
+			// Dangling links and links in a cycle have nothing to count.
+			return 0, nil
+		}
+		if rel, err := filepath.Rel(w.root, resolved); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return 0, nil
+		}
+		if info, err = os.Stat(resolved); err != nil {
+			return 0, err
+		}
+		if !info.IsDir() {
+			return 0, nil
+		}
+		path = resolved
+	}
+
+	if info.Mode().IsRegular() {
+		return uint64(info.Size()), nil
+	}
+	if !info.IsDir() || w.visited[path] {
+		return 0, nil
+	}
+	w.visited[path] = true
+
+	children, err := ioutil.ReadDir(path)
+	if err != nil {
+		return 0, err
+	}
+	var total uint64
+	for _, child := range children {
+		size, err := w.size(filepath.Join(path, child.Name()))
+		if err != nil {
+			return 0, err
+		}
+		total += size
+	}
+	return total, nil
+}
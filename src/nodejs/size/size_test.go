@@ -0,0 +1,105 @@
+package size_test
+
+import (
+	"io/ioutil"
+	"nodejs/size"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Measure", func() {
+	var (
+		err  error
+		root string
+	)
+
+	file := func(path string, length int64) {
+		path = filepath.Join(root, path)
+		Expect(os.MkdirAll(filepath.Dir(path), 0755)).To(Succeed())
+		Expect(ioutil.WriteFile(path, nil, 0644)).To(Succeed())
+		Expect(os.Truncate(path, length)).To(Succeed())
+	}
+
+	symlink := func(target, path string) {
+		path = filepath.Join(root, path)
+		Expect(os.MkdirAll(filepath.Dir(path), 0755)).To(Succeed())
+		Expect(os.Symlink(target, path)).To(Succeed())
+	}
+
+	BeforeEach(func() {
+		root, err = ioutil.TempDir("", "nodejs-buildpack.node_modules.")
+		Expect(err).To(BeNil())
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(root)).To(Succeed())
+	})
+
+	It("adds up the packages, scoped ones by their full name, largest first", func() {
+		file("express/index.js", 100)
+		file("express/node_modules/debug/index.js", 50)
+		file("@babel/core/lib/index.js", 400)
+		file("@babel/parser/lib/index.js", 200)
+		file("leftpad/index.js", 10)
+
+		Expect(size.Measure(root)).To(Equal(size.Report{
+			Total: 760,
+			Packages: []size.Package{
+				{Name: "@babel/core", Size: 400},
+				{Name: "@babel/parser", Size: 200},
+				{Name: "express", Size: 150},
+				{Name: "leftpad", Size: 10},
+			},
+		}))
+	})
+
+	It("counts a dir reached through links once, for its own name", func() {
+		file(".pnpm/lodash@4.17.21/node_modules/lodash/lodash.js", 500)
+		symlink(".pnpm/lodash@4.17.21/node_modules/lodash", "lodash")
+		symlink("../lodash/lodash.js", ".bin/lodash")
+
+		Expect(size.Measure(root)).To(Equal(size.Report{
+			Total:    500,
+			Packages: []size.Package{{Name: ".pnpm", Size: 500}},
+		}))
+	})
+
+	It("ends the walk at links back to a parent", func() {
+		file("a/index.js", 30)
+		symlink("..", "a/node_modules/loop")
+		symlink("../..", "a/deeper/parent")
+		symlink("self", "self")
+
+		Expect(size.Measure(root)).To(Equal(size.Report{
+			Total:    30,
+			Packages: []size.Package{{Name: "a", Size: 30}},
+		}))
+	})
+
+	It("leaves out links out of node_modules", func() {
+		outside, err := ioutil.TempDir("", "nodejs-buildpack.workspace.")
+		Expect(err).To(BeNil())
+		defer os.RemoveAll(outside)
+		Expect(ioutil.WriteFile(filepath.Join(outside, "index.js"), make([]byte, 70), 0644)).To(Succeed())
+		symlink(outside, "api")
+		file("express/index.js", 100)
+
+		Expect(size.Measure(root)).To(Equal(size.Report{
+			Total:    100,
+			Packages: []size.Package{{Name: "express", Size: 100}},
+		}))
+	})
+
+	It("is empty for a missing dir", func() {
+		Expect(size.Measure(filepath.Join(root, "missing"))).To(Equal(size.Report{}))
+	})
+
+	It("renders the largest packages", func() {
+		report := size.Report{Total: 5 * size.MiB, Packages: []size.Package{{Name: "next", Size: 3 * size.MiB}, {Name: "react", Size: 2 * size.MiB}}}
+		Expect(report.Lines(1)).To(Equal("  next: 3 MiB"))
+		Expect(report.Lines(10)).To(Equal("  next: 3 MiB\n  react: 2 MiB"))
+	})
+})
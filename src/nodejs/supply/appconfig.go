@@ -0,0 +1,30 @@
+package supply
+
+import (
+	"nodejs/appconfig"
+	"os"
+)
+
+// LoadAppConfig applies .cfnodejs.yml, or the nodejs section of
+// buildpack.yml, by exporting its keys as the env vars they mirror. Env vars
+// already set take precedence over the file.
+func (s *Supplier) LoadAppConfig() error {
+	config, err := appconfig.Load(s.Stager.BuildDir())
+	if err != nil || config == nil {
+		return err
+	}
+	for _, warning := range config.Warnings {
+		s.Log.Warning("%s", warning)
+	}
+
+	settings := appconfig.Resolve(config, os.LookupEnv)
+	for _, setting := range settings {
+		switch {
+		case setting.Overrides != "":
+			s.Log.Info("%s=%s from the environment overrides %s: %s in %s", setting.Env, setting.Value, setting.Name, setting.Overrides, config.Source)
+		case setting.Source == config.Source:
+			s.Log.Info("Using %s: %s from %s (%s)", setting.Name, setting.Value, config.Source, setting.Env)
+		}
+	}
+	return appconfig.Setenv(settings)
+}
@@ -0,0 +1,77 @@
+package supply_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"nodejs/supply"
+	"os"
+	"path/filepath"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/cloudfoundry/libbuildpack/ansicleaner"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("LoadAppConfig", func() {
+	var (
+		err      error
+		buildDir string
+		supplier *supply.Supplier
+		buffer   *bytes.Buffer
+		oldEnv   map[string]string
+	)
+
+	BeforeEach(func() {
+		buildDir, err = ioutil.TempDir("", "nodejs-buildpack.build.")
+		Expect(err).To(BeNil())
+
+		oldEnv = map[string]string{}
+		for _, key := range []string{"BP_NODE_VERSION", "BP_PRUNE_OMIT"} {
+			if value, found := os.LookupEnv(key); found {
+				oldEnv[key] = value
+			}
+			os.Unsetenv(key)
+		}
+
+		buffer = new(bytes.Buffer)
+		logger := libbuildpack.NewLogger(ansicleaner.New(buffer))
+		supplier = &supply.Supplier{
+			Stager: libbuildpack.NewStager([]string{buildDir, "", "", "0"}, logger, &libbuildpack.Manifest{}),
+			Log:    logger,
+		}
+	})
+
+	AfterEach(func() {
+		for _, key := range []string{"BP_NODE_VERSION", "BP_PRUNE_OMIT"} {
+			if value, found := oldEnv[key]; found {
+				os.Setenv(key, value)
+			} else {
+				os.Unsetenv(key)
+			}
+		}
+		Expect(os.RemoveAll(buildDir)).To(Succeed())
+	})
+
+	It("does nothing without a config file", func() {
+		Expect(supplier.LoadAppConfig()).To(Succeed())
+		Expect(buffer.String()).To(BeEmpty())
+	})
+
+	It("exports the keys of .cfnodejs.yml unless the environment sets them", func() {
+		Expect(ioutil.WriteFile(filepath.Join(buildDir, ".cfnodejs.yml"), []byte("version: 20.x\nprune:\n  omit: [dev]\n  kep: [ejs]\n"), 0644)).To(Succeed())
+		os.Setenv("BP_NODE_VERSION", "18.x")
+
+		Expect(supplier.LoadAppConfig()).To(Succeed())
+		Expect(os.Getenv("BP_NODE_VERSION")).To(Equal("18.x"))
+		Expect(os.Getenv("BP_PRUNE_OMIT")).To(Equal("dev"))
+		Expect(buffer.String()).To(ContainSubstring("BP_NODE_VERSION=18.x from the environment overrides version: 20.x in .cfnodejs.yml"))
+		Expect(buffer.String()).To(ContainSubstring("Using prune.omit: dev from .cfnodejs.yml (BP_PRUNE_OMIT)"))
+		Expect(buffer.String()).To(ContainSubstring("Ignoring unknown key prune.kep in .cfnodejs.yml, did you mean prune.keep?"))
+	})
+
+	It("fails on invalid values", func() {
+		Expect(ioutil.WriteFile(filepath.Join(buildDir, ".cfnodejs.yml"), []byte("prune:\n  omit: [tests]\n"), 0644)).To(Succeed())
+		Expect(supplier.LoadAppConfig()).To(MatchError(`invalid .cfnodejs.yml: prune.omit lists "tests", expected dev, optional and peer`))
+	})
+})
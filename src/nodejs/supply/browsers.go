@@ -0,0 +1,200 @@
+package supply
+
+import (
+	"fmt"
+	"nodejs/cache"
+	"nodejs/heartbeat"
+	"nodejs/packagejson"
+	"nodejs/profiled"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/cloudfoundry/libbuildpack"
+)
+
+type browserTool struct {
+	Name     string
+	SkipEnv  []string
+	CacheEnv string
+}
+
+var browserTools = []browserTool{
+	{Name: "puppeteer", SkipEnv: []string{"PUPPETEER_SKIP_DOWNLOAD", "PUPPETEER_SKIP_CHROMIUM_DOWNLOAD"}, CacheEnv: "PUPPETEER_CACHE_DIR"},
+	{Name: "playwright", SkipEnv: []string{"PLAYWRIGHT_SKIP_BROWSER_DOWNLOAD"}, CacheEnv: "PLAYWRIGHT_BROWSERS_PATH"},
+}
+
+var browserBinaries = map[string]bool{
+	"chrome":                true,
+	"chrome-headless-shell": true,
+	"headless_shell":        true,
+	"chromium":              true,
+	"firefox":               true,
+}
+
+// browserPackages are the packages of each tool which download browsers in
+// their install script. playwright, playwright-core and @playwright/test
+// leave that to `playwright install`.
+var browserPackages = map[string][]string{
+	"puppeteer":  {"puppeteer"},
+	"playwright": {"playwright-chromium", "playwright-firefox", "playwright-webkit"},
+}
+
+func isBrowserPackage(tool, pkg string) bool {
+	return containsString(browserPackages[tool], pkg)
+}
+
+// DetectBrowserTools returns the browser automation packages in package.json
+// which download browsers during install.
+func DetectBrowserTools(buildDir string) ([]string, error) {
+	p, _, err := packagejson.Load(buildDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var tools []string
+	for _, tool := range browserTools {
+		for pkg := range mergeMaps(p.Dependencies, p.DevDependencies) {
+			if isBrowserPackage(tool.Name, pkg) {
+				tools = append(tools, tool.Name)
+				break
+			}
+		}
+	}
+	return tools, nil
+}
+
+func (s *Supplier) browsersDir() string {
+	return filepath.Join(s.Stager.DepDir(), "browsers")
+}
+
+// SetupBrowserDownloads skips the browser downloads of puppeteer and
+// playwright during install, unless BP_DOWNLOAD_BROWSERS is set. When it is,
+// browsers are downloaded into the dep dir, seeded from the app cache.
+func (s *Supplier) SetupBrowserDownloads() error {
+	tools, err := DetectBrowserTools(s.Stager.BuildDir())
+	if err != nil || len(tools) == 0 {
+		return err
+	}
+
+	if os.Getenv("BP_DOWNLOAD_BROWSERS") != "true" {
+		for _, tool := range browserTools {
+			if !containsString(tools, tool.Name) {
+				continue
+			}
+			for _, env := range tool.SkipEnv {
+				if os.Getenv(env) == "" {
+					if err := os.Setenv(env, "1"); err != nil {
+						return err
+					}
+				}
+			}
+		}
+		s.Log.Warning("Skipping browser downloads for %s to keep staging fast\nSet BP_DOWNLOAD_BROWSERS=true to download and cache the browsers", strings.Join(tools, ", "))
+		return nil
+	}
+
+	if err := os.MkdirAll(s.browsersDir(), 0755); err != nil {
+		return err
+	}
+	if cached, found, err := cache.New(s.Stager.CacheDir()).Restore("browsers"); err != nil {
+		return err
+	} else if found {
+		s.Log.Info("Restoring cached browsers")
+		if err := heartbeat.Watch(s.Log, "restoring cached browsers", func() error {
+			return libbuildpack.CopyDirectory(cached, s.browsersDir())
+		}); err != nil {
+			return err
+		}
+	}
+
+	for _, tool := range browserTools {
+		if containsString(tools, tool.Name) {
+			if err := os.Setenv(tool.CacheEnv, filepath.Join(s.browsersDir(), tool.Name)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// FinishBrowserDownloads verifies the browsers were downloaded, saves them to
+// the app cache and exports their location at runtime.
+func (s *Supplier) FinishBrowserDownloads() error {
+	if os.Getenv("BP_DOWNLOAD_BROWSERS") != "true" || s.DryRun {
+		return nil
+	}
+	tools, err := DetectBrowserTools(s.Stager.BuildDir())
+	if err != nil || len(tools) == 0 {
+		return err
+	}
+
+	var exports []string
+	for _, tool := range browserTools {
+		if !containsString(tools, tool.Name) {
+			continue
+		}
+		dir := filepath.Join(s.browsersDir(), tool.Name)
+		binaries, err := findBrowserBinaries(dir)
+		if err != nil {
+			return err
+		}
+		if len(binaries) == 0 {
+			return fmt.Errorf("BP_DOWNLOAD_BROWSERS is set but %s did not download a browser into %s", tool.Name, dir)
+		}
+		s.Log.Info("Found %s browsers: %s", tool.Name, strings.Join(binaries, ", "))
+		exports = append(exports, fmt.Sprintf("export %s=%s", tool.CacheEnv, filepath.Join("$DEPS_DIR", s.Stager.DepsIdx(), "browsers", tool.Name)))
+	}
+
+	if err := heartbeat.Watch(s.Log, "caching browsers", func() error {
+		return cache.New(s.Stager.CacheDir()).Write("browsers", func(dir string) error {
+			return libbuildpack.CopyDirectory(s.browsersDir(), dir)
+		})
+	}); err != nil {
+		return err
+	}
+
+	return profiled.Write(s.Stager, "browsers.sh", strings.Join(exports, "\n")+"\n")
+}
+
+func findBrowserBinaries(dir string) ([]string, error) {
+	var binaries []string
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if !info.IsDir() && info.Mode()&0111 != 0 && browserBinaries[info.Name()] {
+			rel, err := filepath.Rel(dir, path)
+			if err != nil {
+				return err
+			}
+			binaries = append(binaries, rel)
+		}
+		return nil
+	})
+	sort.Strings(binaries)
+	return binaries, err
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func mergeMaps(maps ...map[string]string) map[string]string {
+	merged := map[string]string{}
+	for _, m := range maps {
+		for k, v := range m {
+			merged[k] = v
+		}
+	}
+	return merged
+}
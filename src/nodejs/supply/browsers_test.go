@@ -0,0 +1,158 @@
+package supply_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"nodejs/cache"
+	"nodejs/supply"
+	"os"
+	"path/filepath"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/cloudfoundry/libbuildpack/ansicleaner"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Browser downloads", func() {
+	var (
+		err      error
+		buildDir string
+		cacheDir string
+		depsDir  string
+		supplier *supply.Supplier
+		buffer   *bytes.Buffer
+		oldEnv   map[string]string
+	)
+
+	writePackageJSON := func(contents string) {
+		Expect(ioutil.WriteFile(filepath.Join(buildDir, "package.json"), []byte(contents), 0644)).To(Succeed())
+	}
+
+	writeBrowser := func(dir string) {
+		Expect(os.MkdirAll(dir, 0755)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(dir, "chrome"), []byte("#!/bin/sh\n"), 0755)).To(Succeed())
+	}
+
+	BeforeEach(func() {
+		buildDir, err = ioutil.TempDir("", "nodejs-buildpack.build.")
+		Expect(err).To(BeNil())
+		cacheDir, err = ioutil.TempDir("", "nodejs-buildpack.cache.")
+		Expect(err).To(BeNil())
+		depsDir, err = ioutil.TempDir("", "nodejs-buildpack.deps.")
+		Expect(err).To(BeNil())
+		Expect(os.MkdirAll(filepath.Join(depsDir, "2"), 0755)).To(Succeed())
+
+		oldEnv = map[string]string{}
+		for _, key := range []string{"BP_DOWNLOAD_BROWSERS", "PUPPETEER_SKIP_DOWNLOAD", "PUPPETEER_SKIP_CHROMIUM_DOWNLOAD", "PLAYWRIGHT_SKIP_BROWSER_DOWNLOAD", "PUPPETEER_CACHE_DIR", "PLAYWRIGHT_BROWSERS_PATH"} {
+			oldEnv[key] = os.Getenv(key)
+			os.Unsetenv(key)
+		}
+
+		buffer = new(bytes.Buffer)
+		logger := libbuildpack.NewLogger(ansicleaner.New(buffer))
+		supplier = &supply.Supplier{
+			Stager: libbuildpack.NewStager([]string{buildDir, cacheDir, depsDir, "2"}, logger, &libbuildpack.Manifest{}),
+			Log:    logger,
+		}
+	})
+
+	AfterEach(func() {
+		for key, value := range oldEnv {
+			os.Setenv(key, value)
+		}
+		Expect(os.RemoveAll(buildDir)).To(Succeed())
+		Expect(os.RemoveAll(cacheDir)).To(Succeed())
+		Expect(os.RemoveAll(depsDir)).To(Succeed())
+	})
+
+	Describe("DetectBrowserTools", func() {
+		It("finds puppeteer and playwright in dependencies and devDependencies", func() {
+			writePackageJSON(`{"dependencies":{"puppeteer":"^21.0.0"},"devDependencies":{"playwright-firefox":"^1.40.0"}}`)
+			Expect(supply.DetectBrowserTools(buildDir)).To(Equal([]string{"puppeteer", "playwright"}))
+		})
+
+		It("ignores packages which do not download browsers", func() {
+			writePackageJSON(`{"dependencies":{"puppeteer-core":"^21.0.0","playwright-core":"^1.40.0","express":"^4.0.0"}}`)
+			Expect(supply.DetectBrowserTools(buildDir)).To(BeEmpty())
+		})
+
+		It("ignores @playwright/test, which leaves the browsers to playwright install", func() {
+			writePackageJSON(`{"devDependencies":{"@playwright/test":"^1.40.0","playwright":"^1.40.0"}}`)
+			Expect(supply.DetectBrowserTools(buildDir)).To(BeEmpty())
+		})
+	})
+
+	Context("BP_DOWNLOAD_BROWSERS is not set", func() {
+		BeforeEach(func() {
+			writePackageJSON(`{"dependencies":{"puppeteer":"^21.0.0","playwright-chromium":"^1.40.0"}}`)
+		})
+
+		It("skips the browser downloads during install", func() {
+			Expect(supplier.SetupBrowserDownloads()).To(Succeed())
+			Expect(os.Getenv("PUPPETEER_SKIP_DOWNLOAD")).To(Equal("1"))
+			Expect(os.Getenv("PUPPETEER_SKIP_CHROMIUM_DOWNLOAD")).To(Equal("1"))
+			Expect(os.Getenv("PLAYWRIGHT_SKIP_BROWSER_DOWNLOAD")).To(Equal("1"))
+			Expect(buffer.String()).To(ContainSubstring("Skipping browser downloads for puppeteer, playwright"))
+			Expect(buffer.String()).To(ContainSubstring("Set BP_DOWNLOAD_BROWSERS=true"))
+		})
+
+		It("does not override values set by the user", func() {
+			os.Setenv("PUPPETEER_SKIP_DOWNLOAD", "false")
+			Expect(supplier.SetupBrowserDownloads()).To(Succeed())
+			Expect(os.Getenv("PUPPETEER_SKIP_DOWNLOAD")).To(Equal("false"))
+		})
+
+		It("does not export anything at runtime", func() {
+			Expect(supplier.FinishBrowserDownloads()).To(Succeed())
+			Expect(filepath.Join(depsDir, "2", "profile.d", "002_nodejs_buildpack_browsers.sh")).NotTo(BeAnExistingFile())
+		})
+	})
+
+	Context("BP_DOWNLOAD_BROWSERS is true", func() {
+		BeforeEach(func() {
+			os.Setenv("BP_DOWNLOAD_BROWSERS", "true")
+			writePackageJSON(`{"dependencies":{"puppeteer":"^21.0.0"}}`)
+		})
+
+		It("downloads into the dep dir", func() {
+			Expect(supplier.SetupBrowserDownloads()).To(Succeed())
+			Expect(os.Getenv("PUPPETEER_SKIP_DOWNLOAD")).To(Equal(""))
+			Expect(os.Getenv("PUPPETEER_CACHE_DIR")).To(Equal(filepath.Join(depsDir, "2", "browsers", "puppeteer")))
+		})
+
+		It("restores browsers from the cache", func() {
+			Expect(cache.New(cacheDir).Write("browsers", func(dir string) error {
+				writeBrowser(filepath.Join(dir, "puppeteer", "chrome", "linux-119"))
+				return nil
+			})).To(Succeed())
+			Expect(supplier.SetupBrowserDownloads()).To(Succeed())
+			Expect(filepath.Join(depsDir, "2", "browsers", "puppeteer", "chrome", "linux-119", "chrome")).To(BeAnExistingFile())
+		})
+
+		It("does not restore browsers from a cache write which did not complete", func() {
+			writeBrowser(filepath.Join(cacheDir, "browsers", "puppeteer", "chrome", "linux-119"))
+			Expect(supplier.SetupBrowserDownloads()).To(Succeed())
+			Expect(filepath.Join(depsDir, "2", "browsers", "puppeteer", "chrome", "linux-119")).NotTo(BeADirectory())
+			Expect(filepath.Join(cacheDir, "browsers")).NotTo(BeADirectory())
+		})
+
+		It("caches the browsers and exports their location at runtime", func() {
+			Expect(supplier.SetupBrowserDownloads()).To(Succeed())
+			writeBrowser(filepath.Join(os.Getenv("PUPPETEER_CACHE_DIR"), "chrome", "linux-120"))
+
+			Expect(supplier.FinishBrowserDownloads()).To(Succeed())
+			Expect(filepath.Join(cacheDir, "browsers", "puppeteer", "chrome", "linux-120", "chrome")).To(BeAnExistingFile())
+			Expect(buffer.String()).To(ContainSubstring("Found puppeteer browsers: chrome/linux-120/chrome"))
+
+			contents, err := ioutil.ReadFile(filepath.Join(depsDir, "2", "profile.d", "002_nodejs_buildpack_browsers.sh"))
+			Expect(err).To(BeNil())
+			Expect(string(contents)).To(Equal("export PUPPETEER_CACHE_DIR=$DEPS_DIR/2/browsers/puppeteer\n"))
+		})
+
+		It("fails when no browser was downloaded", func() {
+			Expect(supplier.SetupBrowserDownloads()).To(Succeed())
+			Expect(supplier.FinishBrowserDownloads()).To(MatchError(ContainSubstring("puppeteer did not download a browser")))
+		})
+	})
+})
@@ -0,0 +1,213 @@
+package supply
+
+import (
+	"fmt"
+	"nodejs/cache"
+	"nodejs/packagejson"
+	"nodejs/size"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/cloudfoundry/libbuildpack"
+)
+
+const (
+	// buildCacheEntries is the dir of the cache which keeps the build cache
+	// dirs of the app, each as an entry named after its path.
+	buildCacheEntries = "build-cache"
+	// DefaultBuildCacheMaxMB is how large the build cache dirs may be
+	// together, without BP_BUILD_CACHE_MAX_MB.
+	DefaultBuildCacheMaxMB = 1024
+)
+
+// frameworkCacheDirs are the build cache dirs of the frameworks, which are
+// cached without BP_BUILD_CACHE_DIRS when the app depends on the framework.
+var frameworkCacheDirs = []struct{ dependency, dir string }{
+	{"next", ".next/cache"},
+}
+
+// ParseBuildCacheDirs parses the comma-separated dirs of
+// BP_BUILD_CACHE_DIRS, relative to the app dir. Dirs outside the app dir,
+// and the app dir itself, are refused.
+func ParseBuildCacheDirs(value string) ([]string, error) {
+	var dirs []string
+	seen := map[string]bool{}
+	for _, dir := range strings.Split(value, ",") {
+		dir = strings.TrimSpace(dir)
+		if dir == "" {
+			continue
+		}
+		clean := filepath.Clean(dir)
+		if filepath.IsAbs(clean) || clean == "." || clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+			return nil, fmt.Errorf("BP_BUILD_CACHE_DIRS lists %s, which is not a dir inside the app", dir)
+		}
+		if !seen[clean] {
+			seen[clean] = true
+			dirs = append(dirs, clean)
+		}
+	}
+	return dirs, nil
+}
+
+// buildCacheEntry is the name of the cache entry of dir.
+func buildCacheEntry(dir string) string {
+	return filepath.Join(buildCacheEntries, strings.Replace(filepath.ToSlash(dir), "/", "__", -1))
+}
+
+// buildCacheDirs returns the dirs of BP_BUILD_CACHE_DIRS, or else those of
+// the frameworks the app depends on. BP_BUILD_CACHE_DIRS set empty caches
+// none.
+func (s *Supplier) buildCacheDirs() ([]string, error) {
+	if value, found := os.LookupEnv("BP_BUILD_CACHE_DIRS"); found {
+		return ParseBuildCacheDirs(value)
+	}
+
+	p, _, err := packagejson.Load(s.Stager.BuildDir())
+	if err != nil {
+		return nil, err
+	}
+	var dirs []string
+	for _, framework := range frameworkCacheDirs {
+		if p.Depends(framework.dependency) {
+			dirs = append(dirs, framework.dir)
+		}
+	}
+	return dirs, nil
+}
+
+// RestoreBuildCache restores the build cache dirs of the previous build,
+// like .next/cache, before the build scripts run. A dir the app was pushed
+// with is kept. What BP_CACHE_EXCLUDE excludes is removed from the cache of
+// a build before it was set.
+func (s *Supplier) RestoreBuildCache() error {
+	if s.Stager.CacheDir() == "" {
+		return nil
+	}
+	dirs, err := s.buildCacheDirs()
+	if err != nil {
+		return err
+	}
+	exclude, err := ParseCacheExclude(os.Getenv("BP_CACHE_EXCLUDE"))
+	if err != nil {
+		return err
+	}
+
+	c := cache.New(s.Stager.CacheDir())
+	for _, dir := range dirs {
+		entry, found, err := c.Restore(buildCacheEntry(dir))
+		if err != nil {
+			return err
+		}
+		if !found {
+			continue
+		}
+		if CacheExcluded(exclude, dir) {
+			s.Log.Info("Removing %s from the build cache (BP_CACHE_EXCLUDE)", dir)
+			if err := c.Remove(buildCacheEntry(dir)); err != nil {
+				return err
+			}
+			continue
+		}
+		paths, excluded, err := cacheExcludedPaths(entry, dir, exclude)
+		if err != nil {
+			return err
+		}
+		if err := removeAll(entry, paths); err != nil {
+			return err
+		}
+		s.logCacheExcluded("Removed from the build cache", dir, paths, excluded)
+
+		target := filepath.Join(s.Stager.BuildDir(), dir)
+		if exists, err := libbuildpack.FileExists(target); err != nil {
+			return err
+		} else if exists {
+			s.Log.Info("Keeping the %s the app was pushed with over the build cache", dir)
+			continue
+		}
+		if err := os.MkdirAll(target, 0755); err != nil {
+			return err
+		}
+		if err := libbuildpack.CopyDirectory(entry, target); err != nil {
+			return err
+		}
+		s.Log.Info("Restored %s from the build cache", dir)
+	}
+	return nil
+}
+
+// StoreBuildCache stores the build cache dirs for the next build, in order
+// until they reach BP_BUILD_CACHE_MAX_MB together. The entries of the dirs
+// over it are removed, so that the next build doesn't restore a stale one.
+// Dirs which don't exist are skipped, and so is what BP_CACHE_EXCLUDE
+// excludes.
+func (s *Supplier) StoreBuildCache() error {
+	if s.Stager.CacheDir() == "" {
+		return nil
+	}
+	dirs, err := s.buildCacheDirs()
+	if err != nil {
+		return err
+	}
+	exclude, err := ParseCacheExclude(os.Getenv("BP_CACHE_EXCLUDE"))
+	if err != nil {
+		return err
+	}
+
+	budget := uint64(DefaultBuildCacheMaxMB)
+	if value := strings.TrimSpace(os.Getenv("BP_BUILD_CACHE_MAX_MB")); value != "" {
+		if budget, err = strconv.ParseUint(value, 10, 64); err != nil || budget == 0 {
+			return fmt.Errorf("BP_BUILD_CACHE_MAX_MB=%s is not a positive number of MiB", value)
+		}
+	}
+	budget *= size.MiB
+
+	c := cache.New(s.Stager.CacheDir())
+	var total uint64
+	for _, dir := range dirs {
+		source := filepath.Join(s.Stager.BuildDir(), dir)
+		if info, err := os.Stat(source); os.IsNotExist(err) || (err == nil && !info.IsDir()) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		if CacheExcluded(exclude, dir) {
+			s.Log.Info("Not caching %s, BP_CACHE_EXCLUDE excludes it", dir)
+			if err := c.Remove(buildCacheEntry(dir)); err != nil {
+				return err
+			}
+			continue
+		}
+
+		used, err := dirSize(source)
+		if err != nil {
+			return err
+		}
+		paths, excluded, err := cacheExcludedPaths(source, dir, exclude)
+		if err != nil {
+			return err
+		}
+		used -= excluded
+		if total+used > budget {
+			s.Log.Warning("Not caching %s, at %d MiB it would take the build cache over the %d MiB of BP_BUILD_CACHE_MAX_MB", dir, used/size.MiB, budget/size.MiB)
+			if err := c.Remove(buildCacheEntry(dir)); err != nil {
+				return err
+			}
+			continue
+		}
+		total += used
+
+		if err := c.Write(buildCacheEntry(dir), func(entry string) error {
+			if err := libbuildpack.CopyDirectory(source, entry); err != nil {
+				return err
+			}
+			return removeAll(entry, paths)
+		}); err != nil {
+			return err
+		}
+		s.logCacheExcluded("Left out of the build cache", dir, paths, excluded)
+		s.Log.Info("Stored %s in the build cache (%d MiB)", dir, used/size.MiB)
+	}
+	return nil
+}
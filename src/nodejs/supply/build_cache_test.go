@@ -0,0 +1,260 @@
+package supply_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"nodejs/supply"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/cloudfoundry/libbuildpack/ansicleaner"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Build cache", func() {
+	var (
+		buildDir string
+		cacheDir string
+		supplier *supply.Supplier
+		buffer   *bytes.Buffer
+		oldEnv   map[string]string
+	)
+
+	writeFile := func(path, contents string) {
+		Expect(os.MkdirAll(filepath.Dir(path), 0755)).To(Succeed())
+		Expect(ioutil.WriteFile(path, []byte(contents), 0644)).To(Succeed())
+	}
+
+	// redeploy replaces the app dir with a fresh push of package.json, as
+	// the next build sees it.
+	redeploy := func() {
+		contents, err := ioutil.ReadFile(filepath.Join(buildDir, "package.json"))
+		Expect(err).To(BeNil())
+		Expect(os.RemoveAll(buildDir)).To(Succeed())
+		writeFile(filepath.Join(buildDir, "package.json"), string(contents))
+	}
+
+	BeforeEach(func() {
+		oldEnv = map[string]string{}
+		for _, key := range []string{"BP_BUILD_CACHE_DIRS", "BP_BUILD_CACHE_MAX_MB", "BP_CACHE_EXCLUDE"} {
+			oldEnv[key] = os.Getenv(key)
+			os.Unsetenv(key)
+		}
+
+		var err error
+		buildDir, err = ioutil.TempDir("", "nodejs-buildpack.build.")
+		Expect(err).To(BeNil())
+		cacheDir, err = ioutil.TempDir("", "nodejs-buildpack.cache.")
+		Expect(err).To(BeNil())
+		writeFile(filepath.Join(buildDir, "package.json"), `{"dependencies": {"next": "14.2.3"}}`)
+
+		buffer = new(bytes.Buffer)
+		logger := libbuildpack.NewLogger(ansicleaner.New(buffer))
+		supplier = &supply.Supplier{
+			Stager: libbuildpack.NewStager([]string{buildDir, cacheDir, "", "0"}, logger, &libbuildpack.Manifest{}),
+			Log:    logger,
+		}
+	})
+
+	AfterEach(func() {
+		for key, value := range oldEnv {
+			os.Setenv(key, value)
+		}
+		Expect(os.RemoveAll(buildDir)).To(Succeed())
+		Expect(os.RemoveAll(cacheDir)).To(Succeed())
+	})
+
+	DescribeTable("ParseBuildCacheDirs",
+		func(value string, expected []string) {
+			Expect(supply.ParseBuildCacheDirs(value)).To(Equal(expected))
+		},
+		Entry("empty", "", nil),
+		Entry("a list", ".next/cache, .cache,", []string{".next/cache", ".cache"}),
+		Entry("paths to clean", "./.cache/, .cache, tmp/../.parcel-cache", []string{".cache", ".parcel-cache"}),
+	)
+
+	DescribeTable("ParseBuildCacheDirs refuses dirs outside the app",
+		func(value string) {
+			_, err := supply.ParseBuildCacheDirs(value)
+			Expect(err).To(MatchError(ContainSubstring("which is not a dir inside the app")))
+		},
+		Entry("a parent dir", "../cache"),
+		Entry("a dir which escapes after cleaning", ".cache/../../cache"),
+		Entry("an absolute dir", "/home/vcap/.cache"),
+		Entry("the app dir", "."),
+	)
+
+	It("restores the stored dirs in the next build", func() {
+		os.Setenv("BP_BUILD_CACHE_DIRS", ".next/cache,.cache")
+		writeFile(filepath.Join(buildDir, ".next", "cache", "webpack", "client.pack"), "pack")
+		writeFile(filepath.Join(buildDir, ".cache", "babel.json"), "{}")
+
+		Expect(supplier.StoreBuildCache()).To(Succeed())
+		Expect(buffer.String()).To(ContainSubstring("Stored .next/cache in the build cache (0 MiB)"))
+		Expect(buffer.String()).To(ContainSubstring("Stored .cache in the build cache (0 MiB)"))
+
+		redeploy()
+		Expect(supplier.RestoreBuildCache()).To(Succeed())
+		Expect(ioutil.ReadFile(filepath.Join(buildDir, ".next", "cache", "webpack", "client.pack"))).To(Equal([]byte("pack")))
+		Expect(ioutil.ReadFile(filepath.Join(buildDir, ".cache", "babel.json"))).To(Equal([]byte("{}")))
+		Expect(buffer.String()).To(ContainSubstring("Restored .next/cache from the build cache"))
+	})
+
+	It("caches .next/cache of a Next.js app without BP_BUILD_CACHE_DIRS", func() {
+		writeFile(filepath.Join(buildDir, ".next", "cache", "images", "a.webp"), "webp")
+		writeFile(filepath.Join(buildDir, ".cache", "other"), "other")
+
+		Expect(supplier.StoreBuildCache()).To(Succeed())
+		redeploy()
+		Expect(supplier.RestoreBuildCache()).To(Succeed())
+		Expect(filepath.Join(buildDir, ".next", "cache", "images", "a.webp")).To(BeAnExistingFile())
+		Expect(filepath.Join(buildDir, ".cache")).NotTo(BeADirectory())
+	})
+
+	It("caches nothing for other apps or with BP_BUILD_CACHE_DIRS set empty", func() {
+		writeFile(filepath.Join(buildDir, ".next", "cache", "images", "a.webp"), "webp")
+
+		os.Setenv("BP_BUILD_CACHE_DIRS", "")
+		Expect(supplier.StoreBuildCache()).To(Succeed())
+
+		os.Unsetenv("BP_BUILD_CACHE_DIRS")
+		writeFile(filepath.Join(buildDir, "package.json"), `{"dependencies": {"express": "4.19.2"}}`)
+		Expect(supplier.StoreBuildCache()).To(Succeed())
+
+		Expect(buffer.String()).To(Equal(""))
+		Expect(filepath.Join(cacheDir, "build-cache")).NotTo(BeADirectory())
+	})
+
+	It("skips missing dirs silently", func() {
+		os.Setenv("BP_BUILD_CACHE_DIRS", ".next/cache,.cache")
+
+		Expect(supplier.StoreBuildCache()).To(Succeed())
+		Expect(supplier.RestoreBuildCache()).To(Succeed())
+		Expect(buffer.String()).To(Equal(""))
+	})
+
+	It("keeps a dir the app was pushed with", func() {
+		os.Setenv("BP_BUILD_CACHE_DIRS", ".cache")
+		writeFile(filepath.Join(buildDir, ".cache", "babel.json"), "cached")
+		Expect(supplier.StoreBuildCache()).To(Succeed())
+
+		writeFile(filepath.Join(buildDir, ".cache", "babel.json"), "pushed")
+		Expect(supplier.RestoreBuildCache()).To(Succeed())
+		Expect(ioutil.ReadFile(filepath.Join(buildDir, ".cache", "babel.json"))).To(Equal([]byte("pushed")))
+		Expect(buffer.String()).To(ContainSubstring("Keeping the .cache the app was pushed with over the build cache"))
+	})
+
+	It("fails for a dir outside the app before the build", func() {
+		os.Setenv("BP_BUILD_CACHE_DIRS", "../../etc")
+
+		Expect(supplier.RestoreBuildCache()).To(MatchError("BP_BUILD_CACHE_DIRS lists ../../etc, which is not a dir inside the app"))
+	})
+
+	It("drops the dirs over BP_BUILD_CACHE_MAX_MB", func() {
+		os.Setenv("BP_BUILD_CACHE_DIRS", ".next/cache,.cache")
+		os.Setenv("BP_BUILD_CACHE_MAX_MB", "1")
+		writeFile(filepath.Join(buildDir, ".next", "cache", "small"), "small")
+		writeFile(filepath.Join(buildDir, ".cache", "large"), strings.Repeat("x", 1024*1024))
+		Expect(supplier.StoreBuildCache()).To(Succeed())
+		Expect(buffer.String()).To(ContainSubstring("Not caching .cache, at 1 MiB it would take the build cache over the 1 MiB of BP_BUILD_CACHE_MAX_MB"))
+
+		redeploy()
+		Expect(supplier.RestoreBuildCache()).To(Succeed())
+		Expect(filepath.Join(buildDir, ".next", "cache", "small")).To(BeAnExistingFile())
+		Expect(filepath.Join(buildDir, ".cache")).NotTo(BeADirectory())
+	})
+
+	DescribeTable("CacheExcluded",
+		func(path string, expected bool) {
+			patterns, err := supply.ParseCacheExclude("node_modules/.cache/nx, node_modules/.cache/*-webpack-plugin, ./.next/cache/*.tmp")
+			Expect(err).To(BeNil())
+			Expect(supply.CacheExcluded(patterns, path)).To(Equal(expected))
+		},
+		Entry("a dir it lists", "node_modules/.cache/nx", true),
+		Entry("a file in a dir it lists", "node_modules/.cache/nx/d/project-graph.json", true),
+		Entry("a dir a glob matches", "node_modules/.cache/terser-webpack-plugin", true),
+		Entry("a cleaned glob", ".next/cache/a.tmp", true),
+		Entry("a sibling", "node_modules/.cache/babel-loader", false),
+		Entry("the parent", "node_modules/.cache", false),
+		Entry("a glob only matching within a segment", ".next/cache/images/a.tmp", false),
+	)
+
+	DescribeTable("ParseCacheExclude refuses",
+		func(value, message string) {
+			_, err := supply.ParseCacheExclude(value)
+			Expect(err).To(MatchError(ContainSubstring(message)))
+		},
+		Entry("a path outside the app", "../cache", "BP_CACHE_EXCLUDE lists ../cache, which is not a path inside the app"),
+		Entry("the app dir", ".", "BP_CACHE_EXCLUDE lists ., which is not a path inside the app"),
+		Entry("an invalid glob", ".cache/[nx", "BP_CACHE_EXCLUDE lists .cache/[nx, which is not a valid glob"),
+	)
+
+	It("leaves what BP_CACHE_EXCLUDE excludes out of the build cache", func() {
+		os.Setenv("BP_BUILD_CACHE_DIRS", "node_modules/.cache,.cache")
+		os.Setenv("BP_CACHE_EXCLUDE", "node_modules/.cache/nx,node_modules/.cache/terser-*,.cache")
+		writeFile(filepath.Join(buildDir, "node_modules", ".cache", "nx", "project-graph.json"), strings.Repeat("x", 4096))
+		writeFile(filepath.Join(buildDir, "node_modules", ".cache", "terser-webpack-plugin", "content"), strings.Repeat("x", 1024))
+		writeFile(filepath.Join(buildDir, "node_modules", ".cache", "babel-loader", "a.json"), "{}")
+		writeFile(filepath.Join(buildDir, ".cache", "babel.json"), "{}")
+
+		Expect(supplier.StoreBuildCache()).To(Succeed())
+		Expect(buffer.String()).To(ContainSubstring("Left out of the build cache node_modules/.cache/nx, node_modules/.cache/terser-webpack-plugin (BP_CACHE_EXCLUDE), saving 5 KiB"))
+		Expect(buffer.String()).To(ContainSubstring("Not caching .cache, BP_CACHE_EXCLUDE excludes it"))
+		Expect(filepath.Join(buildDir, "node_modules", ".cache", "nx", "project-graph.json")).To(BeAnExistingFile())
+
+		redeploy()
+		Expect(supplier.RestoreBuildCache()).To(Succeed())
+		Expect(filepath.Join(buildDir, "node_modules", ".cache", "babel-loader", "a.json")).To(BeAnExistingFile())
+		Expect(filepath.Join(buildDir, "node_modules", ".cache", "nx")).NotTo(BeADirectory())
+		Expect(filepath.Join(buildDir, "node_modules", ".cache", "terser-webpack-plugin")).NotTo(BeADirectory())
+		Expect(filepath.Join(buildDir, ".cache")).NotTo(BeADirectory())
+	})
+
+	It("removes what BP_CACHE_EXCLUDE excludes from the cache of an older build", func() {
+		os.Setenv("BP_BUILD_CACHE_DIRS", "node_modules/.cache,.cache")
+		writeFile(filepath.Join(buildDir, "node_modules", ".cache", "nx", "project-graph.json"), strings.Repeat("x", 2048))
+		writeFile(filepath.Join(buildDir, "node_modules", ".cache", "babel-loader", "a.json"), "{}")
+		writeFile(filepath.Join(buildDir, ".cache", "babel.json"), "{}")
+		Expect(supplier.StoreBuildCache()).To(Succeed())
+
+		redeploy()
+		os.Setenv("BP_CACHE_EXCLUDE", "node_modules/.cache/nx,.cache")
+		Expect(supplier.RestoreBuildCache()).To(Succeed())
+		Expect(buffer.String()).To(ContainSubstring("Removed from the build cache node_modules/.cache/nx (BP_CACHE_EXCLUDE), saving 2 KiB"))
+		Expect(buffer.String()).To(ContainSubstring("Removing .cache from the build cache (BP_CACHE_EXCLUDE)"))
+		Expect(filepath.Join(buildDir, "node_modules", ".cache", "babel-loader", "a.json")).To(BeAnExistingFile())
+		Expect(filepath.Join(buildDir, "node_modules", ".cache", "nx")).NotTo(BeADirectory())
+		Expect(filepath.Join(buildDir, ".cache")).NotTo(BeADirectory())
+
+		// The cache is clean for the builds after.
+		redeploy()
+		os.Unsetenv("BP_CACHE_EXCLUDE")
+		buffer.Reset()
+		Expect(supplier.RestoreBuildCache()).To(Succeed())
+		Expect(filepath.Join(buildDir, "node_modules", ".cache", "nx")).NotTo(BeADirectory())
+		Expect(filepath.Join(buildDir, ".cache")).NotTo(BeADirectory())
+		Expect(buffer.String()).To(Equal("       Restored node_modules/.cache from the build cache\n"))
+	})
+
+	It("caches everything for a BP_CACHE_EXCLUDE glob which matches nothing", func() {
+		os.Setenv("BP_BUILD_CACHE_DIRS", ".next/cache")
+		os.Setenv("BP_CACHE_EXCLUDE", "node_modules/.cache/nx")
+		writeFile(filepath.Join(buildDir, ".next", "cache", "webpack", "client.pack"), "pack")
+
+		Expect(supplier.StoreBuildCache()).To(Succeed())
+		redeploy()
+		Expect(supplier.RestoreBuildCache()).To(Succeed())
+		Expect(filepath.Join(buildDir, ".next", "cache", "webpack", "client.pack")).To(BeAnExistingFile())
+		Expect(buffer.String()).NotTo(ContainSubstring("BP_CACHE_EXCLUDE"))
+	})
+
+	It("refuses an invalid BP_BUILD_CACHE_MAX_MB", func() {
+		os.Setenv("BP_BUILD_CACHE_MAX_MB", "lots")
+
+		Expect(supplier.StoreBuildCache()).To(MatchError("BP_BUILD_CACHE_MAX_MB=lots is not a positive number of MiB"))
+	})
+})
@@ -0,0 +1,129 @@
+package supply
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"nodejs/packagejson"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// secretName matches the names of env vars which BP_BUILD_INFO_ENV refuses,
+// as build-info.json usually ends up in the bundle served to browsers.
+var secretName = regexp.MustCompile(`(?i)token|key|secret|passw|auth|credential|private`)
+
+// BuildInfo is the content of build-info.json.
+type BuildInfo struct {
+	BuildpackVersion string            `json:"buildpackVersion"`
+	NodeVersion      string            `json:"nodeVersion"`
+	NPMVersion       string            `json:"npmVersion"`
+	BuiltAt          string            `json:"builtAt"`
+	Env              map[string]string `json:"env,omitempty"`
+}
+
+// ParseBuildInfoEnv parses the comma-separated env var names of
+// BP_BUILD_INFO_ENV, refusing those which look like secrets.
+func ParseBuildInfoEnv(value string) ([]string, error) {
+	names := splitList(value)
+	for _, name := range names {
+		if secretName.MatchString(name) {
+			return nil, fmt.Errorf("BP_BUILD_INFO_ENV lists %s, which looks like a secret, build-info.json is no place for it", name)
+		}
+	}
+	return names, nil
+}
+
+// RenderBuildInfo returns build-info.json and build-info.js, a module which
+// exports the same object, as an ES module for packageType module and as a
+// CommonJS one otherwise.
+func RenderBuildInfo(info BuildInfo, packageType string) ([]byte, []byte, error) {
+	// The JSON is embedded in the module, so that it is escaped the same.
+	// encoding/json escapes U+2028 and U+2029, which older engines don't
+	// accept in JS strings.
+	contents, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	module := new(bytes.Buffer)
+	module.WriteString("// Generated by the Node.js buildpack from BP_GENERATE_BUILD_INFO.\n")
+	if packageType == "module" {
+		module.WriteString("export default ")
+	} else {
+		module.WriteString("module.exports = ")
+	}
+	module.Write(contents)
+	module.WriteString(";\n")
+
+	return append(contents, '\n'), module.Bytes(), nil
+}
+
+// WriteBuildInfo writes build-info.json into the app dir with
+// BP_GENERATE_BUILD_INFO=true, before the build scripts run, so that bundles
+// can show the buildpack, node and npm versions, when they were staged, and
+// the env vars listed in BP_BUILD_INFO_ENV, like a git SHA. With
+// BP_BUILD_INFO_MODULE=true it writes build-info.js too.
+func (s *Supplier) WriteBuildInfo() error {
+	if os.Getenv("BP_GENERATE_BUILD_INFO") != "true" {
+		return nil
+	}
+
+	names, err := ParseBuildInfoEnv(os.Getenv("BP_BUILD_INFO_ENV"))
+	if err != nil {
+		return err
+	}
+
+	version, err := ioutil.ReadFile(filepath.Join(s.Manifest.RootDir(), "VERSION"))
+	if err != nil {
+		return err
+	}
+	npmVersion := new(bytes.Buffer)
+	if err := s.Command.Execute(s.Stager.BuildDir(), npmVersion, ioutil.Discard, "npm", "--version"); err != nil {
+		return err
+	}
+
+	info := BuildInfo{
+		BuildpackVersion: strings.TrimSpace(string(version)),
+		NodeVersion:      s.ExactNodeVersion,
+		NPMVersion:       strings.TrimSpace(npmVersion.String()),
+		BuiltAt:          time.Now().UTC().Format(time.RFC3339),
+	}
+	for _, name := range names {
+		value, found := s.buildScriptEnv(name)
+		if !found {
+			s.Log.Warning("BP_BUILD_INFO_ENV lists %s, which is not set, leaving it out of build-info.json", name)
+			continue
+		}
+		if info.Env == nil {
+			info.Env = map[string]string{}
+		}
+		info.Env[name] = value
+	}
+
+	p, _, err := packagejson.Load(s.Stager.BuildDir())
+	if err != nil {
+		return err
+	}
+	contents, module, err := RenderBuildInfo(info, p.Type)
+	if err != nil {
+		return err
+	}
+
+	files := []string{"build-info.json"}
+	if err := ioutil.WriteFile(filepath.Join(s.Stager.BuildDir(), "build-info.json"), contents, 0644); err != nil {
+		return err
+	}
+	if os.Getenv("BP_BUILD_INFO_MODULE") == "true" {
+		files = append(files, "build-info.js")
+		if err := ioutil.WriteFile(filepath.Join(s.Stager.BuildDir(), "build-info.js"), module, 0644); err != nil {
+			return err
+		}
+	}
+	s.Log.Info("Wrote %s (BP_GENERATE_BUILD_INFO)", strings.Join(files, " and "))
+	return nil
+}
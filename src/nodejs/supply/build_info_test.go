@@ -0,0 +1,182 @@
+package supply_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"nodejs/supply"
+	"os"
+	"path/filepath"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/cloudfoundry/libbuildpack/ansicleaner"
+	"golang.google.cn/x/mock/gomock"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("BuildInfo", func() {
+	info := supply.BuildInfo{
+		BuildpackVersion: "1.6.31",
+		NodeVersion:      "20.11.1",
+		NPMVersion:       "10.2.4",
+		BuiltAt:          "2024-03-01T12:00:00Z",
+		Env:              map[string]string{"GIT_SHA": "abc123", "RELEASE": "say \"hi\"\u2028</script>"},
+	}
+
+	const expectedJSON = `{
+  "buildpackVersion": "1.6.31",
+  "nodeVersion": "20.11.1",
+  "npmVersion": "10.2.4",
+  "builtAt": "2024-03-01T12:00:00Z",
+  "env": {
+    "GIT_SHA": "abc123",
+    "RELEASE": "say \"hi\"\u2028\u003c/script\u003e"
+  }
+}`
+
+	DescribeTable("RenderBuildInfo",
+		func(packageType, expectedModule string) {
+			contents, module, err := supply.RenderBuildInfo(info, packageType)
+			Expect(err).To(BeNil())
+			Expect(string(contents)).To(Equal(expectedJSON + "\n"))
+			Expect(string(module)).To(Equal("// Generated by the Node.js buildpack from BP_GENERATE_BUILD_INFO.\n" + expectedModule + expectedJSON + ";\n"))
+
+			var parsed supply.BuildInfo
+			Expect(json.Unmarshal(contents, &parsed)).To(Succeed())
+			Expect(parsed).To(Equal(info))
+		},
+		Entry("a CommonJS package", "", "module.exports = "),
+		Entry("a commonjs type", "commonjs", "module.exports = "),
+		Entry("an ES module package", "module", "export default "),
+	)
+
+	DescribeTable("ParseBuildInfoEnv refuses secrets",
+		func(name string) {
+			_, err := supply.ParseBuildInfoEnv("GIT_SHA," + name)
+			Expect(err).To(MatchError("BP_BUILD_INFO_ENV lists " + name + ", which looks like a secret, build-info.json is no place for it"))
+		},
+		Entry("a token", "NPM_TOKEN"),
+		Entry("a key", "STRIPE_SECRET_KEY"),
+		Entry("a password", "DB_PASSWORD"),
+		Entry("auth", "npm_config__auth"),
+		Entry("a private one", "private_url"),
+	)
+
+	It("parses the names of BP_BUILD_INFO_ENV", func() {
+		Expect(supply.ParseBuildInfoEnv(" GIT_SHA, RELEASE ,")).To(Equal([]string{"GIT_SHA", "RELEASE"}))
+	})
+
+	Describe("WriteBuildInfo", func() {
+		var (
+			err          error
+			buildDir     string
+			bpDir        string
+			supplier     *supply.Supplier
+			buffer       *bytes.Buffer
+			mockCtrl     *gomock.Controller
+			mockCommand  *MockCommand
+			mockManifest *MockManifest
+			oldEnv       map[string]string
+		)
+
+		BeforeEach(func() {
+			buildDir, err = ioutil.TempDir("", "nodejs-buildpack.build.")
+			Expect(err).To(BeNil())
+			bpDir, err = ioutil.TempDir("", "nodejs-buildpack.bp.")
+			Expect(err).To(BeNil())
+			Expect(ioutil.WriteFile(filepath.Join(bpDir, "VERSION"), []byte("1.6.31\n"), 0644)).To(Succeed())
+
+			oldEnv = map[string]string{}
+			for _, key := range []string{"BP_GENERATE_BUILD_INFO", "BP_BUILD_INFO_ENV", "BP_BUILD_INFO_MODULE", "GIT_SHA", "RELEASE"} {
+				oldEnv[key] = os.Getenv(key)
+				os.Unsetenv(key)
+			}
+
+			mockCtrl = gomock.NewController(GinkgoT())
+			mockManifest = NewMockManifest(mockCtrl)
+			mockManifest.EXPECT().RootDir().Return(bpDir).AnyTimes()
+			mockCommand = NewMockCommand(mockCtrl)
+
+			buffer = new(bytes.Buffer)
+			logger := libbuildpack.NewLogger(ansicleaner.New(buffer))
+			supplier = &supply.Supplier{
+				Stager:           libbuildpack.NewStager([]string{buildDir, "", "", "0"}, logger, &libbuildpack.Manifest{}),
+				Manifest:         mockManifest,
+				Command:          mockCommand,
+				Log:              logger,
+				ExactNodeVersion: "20.11.1",
+			}
+		})
+
+		AfterEach(func() {
+			mockCtrl.Finish()
+			for key, value := range oldEnv {
+				os.Setenv(key, value)
+			}
+			Expect(os.RemoveAll(buildDir)).To(Succeed())
+			Expect(os.RemoveAll(bpDir)).To(Succeed())
+		})
+
+		expectNPMVersion := func() {
+			mockCommand.EXPECT().Execute(buildDir, gomock.Any(), gomock.Any(), "npm", "--version").Do(func(_ string, buffer io.Writer, _ io.Writer, _ string, _ string) {
+				buffer.Write([]byte("10.2.4\n"))
+			}).Return(nil)
+		}
+
+		readInfo := func() supply.BuildInfo {
+			var written supply.BuildInfo
+			contents, err := ioutil.ReadFile(filepath.Join(buildDir, "build-info.json"))
+			Expect(err).To(BeNil())
+			Expect(json.Unmarshal(contents, &written)).To(Succeed())
+			return written
+		}
+
+		It("writes nothing by default", func() {
+			Expect(supplier.WriteBuildInfo()).To(Succeed())
+			Expect(filepath.Join(buildDir, "build-info.json")).NotTo(BeAnExistingFile())
+		})
+
+		It("writes the versions and the env vars of BP_BUILD_INFO_ENV, from the dotenv file too", func() {
+			os.Setenv("BP_GENERATE_BUILD_INFO", "true")
+			os.Setenv("BP_BUILD_INFO_ENV", "GIT_SHA,RELEASE,MISSING")
+			os.Setenv("GIT_SHA", "abc123")
+			supplier.BuildScriptEnv = []string{"RELEASE=2024.3"}
+			expectNPMVersion()
+
+			Expect(supplier.WriteBuildInfo()).To(Succeed())
+			written := readInfo()
+			Expect(written.BuildpackVersion).To(Equal("1.6.31"))
+			Expect(written.NodeVersion).To(Equal("20.11.1"))
+			Expect(written.NPMVersion).To(Equal("10.2.4"))
+			Expect(written.BuiltAt).To(MatchRegexp(`^\d{4}-\d\d-\d\dT\d\d:\d\d:\d\dZ$`))
+			Expect(written.Env).To(Equal(map[string]string{"GIT_SHA": "abc123", "RELEASE": "2024.3"}))
+			Expect(buffer.String()).To(ContainSubstring("BP_BUILD_INFO_ENV lists MISSING, which is not set"))
+			Expect(buffer.String()).To(ContainSubstring("Wrote build-info.json (BP_GENERATE_BUILD_INFO)"))
+			Expect(filepath.Join(buildDir, "build-info.js")).NotTo(BeAnExistingFile())
+		})
+
+		It("writes a module of the type of the package with BP_BUILD_INFO_MODULE=true", func() {
+			os.Setenv("BP_GENERATE_BUILD_INFO", "true")
+			os.Setenv("BP_BUILD_INFO_MODULE", "true")
+			Expect(ioutil.WriteFile(filepath.Join(buildDir, "package.json"), []byte(`{"type": "module"}`), 0644)).To(Succeed())
+			expectNPMVersion()
+
+			Expect(supplier.WriteBuildInfo()).To(Succeed())
+			module, err := ioutil.ReadFile(filepath.Join(buildDir, "build-info.js"))
+			Expect(err).To(BeNil())
+			Expect(string(module)).To(ContainSubstring("\nexport default {\n"))
+			Expect(buffer.String()).To(ContainSubstring("Wrote build-info.json and build-info.js"))
+		})
+
+		It("fails for a secret in BP_BUILD_INFO_ENV", func() {
+			os.Setenv("BP_GENERATE_BUILD_INFO", "true")
+			os.Setenv("BP_BUILD_INFO_ENV", "NPM_TOKEN")
+
+			Expect(supplier.WriteBuildInfo()).To(MatchError(ContainSubstring("BP_BUILD_INFO_ENV lists NPM_TOKEN, which looks like a secret")))
+			Expect(filepath.Join(buildDir, "build-info.json")).NotTo(BeAnExistingFile())
+		})
+	})
+})
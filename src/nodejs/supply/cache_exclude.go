@@ -0,0 +1,99 @@
+package supply
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ParseCacheExclude parses the comma-separated globs of BP_CACHE_EXCLUDE,
+// relative to the app dir, like node_modules/.cache/nx. A glob matches
+// paths with filepath.Match, segment by segment, and excludes everything
+// below the paths it matches.
+func ParseCacheExclude(value string) ([]string, error) {
+	var patterns []string
+	for _, pattern := range splitList(value) {
+		clean := filepath.Clean(pattern)
+		if filepath.IsAbs(clean) || clean == "." || clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+			return nil, fmt.Errorf("BP_CACHE_EXCLUDE lists %s, which is not a path inside the app", pattern)
+		}
+		if _, err := filepath.Match(clean, ""); err != nil {
+			return nil, fmt.Errorf("BP_CACHE_EXCLUDE lists %s, which is not a valid glob: %s", pattern, err)
+		}
+		patterns = append(patterns, clean)
+	}
+	return patterns, nil
+}
+
+// CacheExcluded returns whether path, relative to the app dir, or a dir it
+// is in matches one of patterns.
+func CacheExcluded(patterns []string, path string) bool {
+	for ; path != "." && path != string(filepath.Separator); path = filepath.Dir(path) {
+		for _, pattern := range patterns {
+			if matched, _ := filepath.Match(pattern, path); matched {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// cacheExcludedPaths returns the paths below root, a copy of the dir of the
+// app at dir, which patterns exclude from the cache, relative to root, and
+// how many bytes they take.
+func cacheExcludedPaths(root, dir string, patterns []string) ([]string, uint64, error) {
+	var paths []string
+	var excluded uint64
+	if len(patterns) == 0 {
+		return nil, 0, nil
+	}
+
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		if rel == "." || !CacheExcluded(patterns, filepath.Join(dir, rel)) {
+			return nil
+		}
+
+		used, err := dirSize(path)
+		if err != nil {
+			return err
+		}
+		paths = append(paths, rel)
+		excluded += used
+		if info.IsDir() {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	return paths, excluded, err
+}
+
+// removeAll removes paths, relative to root.
+func removeAll(root string, paths []string) error {
+	for _, path := range paths {
+		if err := os.RemoveAll(filepath.Join(root, path)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// logCacheExcluded logs the paths of the app at dir which were excluded from
+// the cache, and the space it saved.
+func (s *Supplier) logCacheExcluded(verb, dir string, paths []string, excluded uint64) {
+	if len(paths) == 0 {
+		return
+	}
+	var full []string
+	for _, path := range paths {
+		full = append(full, filepath.ToSlash(filepath.Join(dir, path)))
+	}
+	s.Log.Info("%s %s (BP_CACHE_EXCLUDE), saving %d KiB", verb, strings.Join(full, ", "), excluded/1024)
+}
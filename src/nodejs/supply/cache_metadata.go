@@ -0,0 +1,60 @@
+package supply
+
+import (
+	"nodejs/cache"
+	"nodejs/digest"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/cloudfoundry/libbuildpack"
+)
+
+const cacheMetadataFile = "nodejs-buildpack-metadata.json"
+
+// CacheMetadata is kept in the app cache to carry facts about the previous
+// build over to the next one.
+type CacheMetadata struct {
+	NodeModulesSize   uint64             `json:"node_modules_size,omitempty"`
+	NodeModulesDigest *digest.Tree       `json:"node_modules_digest,omitempty"`
+	Stack             string             `json:"stack,omitempty"`
+	YarnCache         *YarnCacheMetadata `json:"yarn_cache,omitempty"`
+	Rebuild           *RebuildMetadata   `json:"rebuild,omitempty"`
+}
+
+func LoadCacheMetadata(cacheDir string) (CacheMetadata, error) {
+	var metadata CacheMetadata
+	if cacheDir == "" {
+		return metadata, nil
+	}
+	if err := libbuildpack.NewJSON().Load(filepath.Join(cacheDir, cacheMetadataFile), &metadata); err != nil && !os.IsNotExist(err) {
+		return CacheMetadata{}, err
+	}
+	return metadata, nil
+}
+
+func (m CacheMetadata) Save(cacheDir string) error {
+	if cacheDir == "" {
+		return nil
+	}
+	// Written next to its final name and renamed, so that an interrupted
+	// staging leaves the previous metadata.
+	path := filepath.Join(cacheDir, cacheMetadataFile)
+	if err := libbuildpack.NewJSON().Write(path+".tmp", m); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// SweepCache removes what stagings which were killed while writing to the
+// cache left behind.
+func (s *Supplier) SweepCache() error {
+	if s.Stager.CacheDir() == "" {
+		return nil
+	}
+	removed, err := cache.New(s.Stager.CacheDir()).Sweep(cache.OrphanAge, time.Now())
+	if len(removed) > 0 {
+		s.Log.Info("Removed %d temp directories left in the cache by interrupted stagings", len(removed))
+	}
+	return err
+}
@@ -1,13 +1,26 @@
 package main
 
 import (
+	"fmt"
 	"io"
 	"io/ioutil"
-	_ "nodejs/hooks"
+	"nodejs/archive"
+	"nodejs/exitstatus"
+	"nodejs/failure"
+	"nodejs/heartbeat"
+	"nodejs/hooks"
+	"nodejs/logdedup"
+	"nodejs/mirror"
+	"nodejs/network"
 	"nodejs/npm"
+	"nodejs/offline"
 	"nodejs/supply"
+	"nodejs/vendoring"
+	"nodejs/verify"
 	"nodejs/yarn"
 	"os"
+	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/cloudfoundry/libbuildpack"
@@ -22,7 +35,9 @@ func main() {
 		os.Exit(8)
 	}
 
-	stdout := io.MultiWriter(os.Stdout, logfile)
+	// The log file keeps every warning, the staging output each one once.
+	dedup := logdedup.New(os.Stdout)
+	stdout := io.MultiWriter(dedup, logfile)
 	logger := libbuildpack.NewLogger(stdout)
 
 	buildpackDir, err := libbuildpack.GetBuildpackDir()
@@ -36,13 +51,37 @@ func main() {
 		logger.Error("Unable to load buildpack manifest: %s", err.Error())
 		os.Exit(10)
 	}
-	installer := libbuildpack.NewInstaller(manifest)
+	installer := archive.NewInstaller(manifest, logger)
 
-	stager := libbuildpack.NewStager(os.Args[1:], logger, manifest)
+	args, verifyOnly := verifyArgs(os.Args[1:])
+	if verifyOnly {
+		os.Exit(verifyBuildpack(manifest, logger))
+	}
+
+	args, vendorOut, err := vendorArgs(args)
+	if err != nil {
+		logger.Error("Unable to vendor the app: %s", err)
+		os.Exit(22)
+	}
+	appDir := ""
+	if vendorOut != "" {
+		appDir = args[0]
+		if args, err = vendorBuildDirs(args); err != nil {
+			logger.Error("Unable to vendor the app: %s", err)
+			os.Exit(22)
+		}
+	}
+
+	args, dryRun := dryRunArgs(args)
+	stager := libbuildpack.NewStager(args, logger, manifest)
 	if err := stager.CheckBuildpackValid(); err != nil {
 		os.Exit(11)
 	}
 
+	if dryRun {
+		os.Exit(plan(stager, manifest, buildpackDir, logger))
+	}
+
 	if err = installer.SetAppCacheDir(stager.CacheDir()); err != nil {
 		logger.Error("Unable to setup appcache: %s", err)
 		os.Exit(18)
@@ -51,10 +90,31 @@ func main() {
 		logger.Error("Unable to apply override.yml files: %s", err)
 		os.Exit(17)
 	}
+	if mirrorConfig, err := mirror.LoadConfig(stager.DepsDir(), manifest.Language()); err != nil {
+		logger.Error("Unable to load dependencies mirror config: %s", err)
+		os.Exit(20)
+	} else if err := mirror.Apply(manifest, mirrorConfig, logger); err != nil {
+		logger.Error("Unable to apply dependencies mirror: %s", err)
+		os.Exit(20)
+	}
+
+	// Before the hooks, which download agents and look up their versions.
+	if reason, err := offline.Setup(manifest.IsCached(), offline.RegistryProbe(), stager); err != nil {
+		logger.Error("Unable to tell whether staging is offline: %s", err)
+		os.Exit(23)
+	} else if reason != "" {
+		logger.Info("Staging offline (%s), set BP_OFFLINE=false when the buildpack has access to the internet", reason)
+	}
+
+	if err := hooks.AddExternalHooks(buildpackDir, stager.DepsDir()); err != nil {
+		logger.Error("Unable to find the hooks in %s: %s", hooks.ExternalHooksDir, err)
+		os.Exit(12)
+	}
 
 	err = libbuildpack.RunBeforeCompile(stager)
 	if err != nil {
 		logger.Error("Before Compile: %s", err.Error())
+		failure.Report(logger, stager.DepDir(), failure.Wrap(failure.HookFailed, err))
 		os.Exit(12)
 	}
 
@@ -64,25 +124,29 @@ func main() {
 		os.Exit(13)
 	}
 
+	// exitstatus explains an install the OOM killer ended.
+	runner := heartbeat.New(exitstatus.New(), logger)
 	s := supply.Supplier{
 		Logfile: logfile,
 		Stager:  stager,
 		Yarn: &yarn.Yarn{
-			Command: &libbuildpack.Command{},
+			Command: runner,
 			Log:     logger,
 		},
 		NPM: &npm.NPM{
-			Command: &libbuildpack.Command{},
+			Command: runner,
 			Log:     logger,
 		},
 		Manifest:  manifest,
 		Installer: installer,
 		Log:       logger,
-		Command:   &libbuildpack.Command{},
+		Command:   runner,
 	}
 
 	err = supply.Run(&s)
+	dedup.Flush()
 	if err != nil {
+		failure.Report(logger, stager.DepDir(), err)
 		os.Exit(14)
 	}
 
@@ -94,4 +158,164 @@ func main() {
 		logger.Error("Unable to clean up app cache: %s", err)
 		os.Exit(19)
 	}
+
+	if vendorOut != "" {
+		os.Exit(vendorApp(appDir, stager.BuildDir(), vendorOut, logger))
+	}
+}
+
+// vendorArgs removes --vendor-out <dir> from the arguments, returning the
+// absolute out dir.
+func vendorArgs(args []string) ([]string, string, error) {
+	var rest []string
+	outDir := ""
+	for i := 0; i < len(args); i++ {
+		switch {
+		case args[i] == "--vendor-out":
+			if i+1 == len(args) {
+				return nil, "", fmt.Errorf("--vendor-out needs a dir")
+			}
+			i++
+			outDir = args[i]
+		case strings.HasPrefix(args[i], "--vendor-out="):
+			outDir = strings.TrimPrefix(args[i], "--vendor-out=")
+		default:
+			rest = append(rest, args[i])
+		}
+	}
+	if outDir == "" {
+		return rest, "", nil
+	}
+	if len(rest) == 0 {
+		return nil, "", fmt.Errorf("--vendor-out needs the dir of the app")
+	}
+	outDir, err := filepath.Abs(outDir)
+	return rest, outDir, err
+}
+
+// vendorBuildDirs stages a copy of the app, which stays as it is, and
+// temporary directories stand in for the cache and deps dirs unless given.
+func vendorBuildDirs(args []string) ([]string, error) {
+	buildDir, err := ioutil.TempDir("", "nodejs-buildpack.vendor")
+	if err != nil {
+		return nil, err
+	}
+	if err := libbuildpack.CopyDirectory(args[0], buildDir); err != nil {
+		return nil, err
+	}
+	rest := append([]string{buildDir}, args[1:]...)
+	for len(rest) < 3 {
+		dir, err := ioutil.TempDir("", "nodejs-buildpack.vendor")
+		if err != nil {
+			return nil, err
+		}
+		rest = append(rest, dir)
+	}
+	if len(rest) < 4 {
+		rest = append(rest, "0")
+	}
+	return rest, nil
+}
+
+// vendorApp vendors the tarballs the install of the app in appDir resolved
+// into outDir, to push it to a foundation without access to a registry.
+func vendorApp(appDir, installedDir, outDir string, logger *libbuildpack.Logger) int {
+	logger.BeginStep("Vendoring the app into %s", outDir)
+	result, err := vendoring.Vendor(network.Client, vendoring.Request{AppDir: appDir, InstalledDir: installedDir, OutDir: outDir})
+	if err != nil {
+		logger.Error("Unable to vendor the app: %s", err)
+		return 22
+	}
+	logger.Info("%s", result.Instructions(outDir))
+	return 0
+}
+
+// verifyArgs removes --verify from the arguments.
+func verifyArgs(args []string) ([]string, bool) {
+	var rest []string
+	found := false
+	for _, arg := range args {
+		if arg == "--verify" {
+			found = true
+		} else {
+			rest = append(rest, arg)
+		}
+	}
+	return rest, found
+}
+
+// verifyBuildpack checks the buildpack for operators before they roll it out,
+// without staging an app.
+func verifyBuildpack(manifest *libbuildpack.Manifest, logger *libbuildpack.Logger) int {
+	logger.BeginStep("Verifying the buildpack in %s", manifest.RootDir())
+	report, err := verify.Buildpack(manifest)
+	if err != nil {
+		logger.Error("Unable to verify the buildpack: %s", err)
+		return 21
+	}
+	if err := report.Write(os.Stdout); err != nil {
+		logger.Error("Unable to write the report: %s", err)
+		return 21
+	}
+	if len(report.Failed()) > 0 {
+		return 21
+	}
+	return 0
+}
+
+// dryRunArgs removes --dry-run from the arguments. A dry run only needs the
+// build dir, temporary directories stand in for the others.
+func dryRunArgs(args []string) ([]string, bool) {
+	dryRun := os.Getenv("BP_DRY_RUN") == "true"
+	var rest []string
+	for _, arg := range args {
+		if arg == "--dry-run" {
+			dryRun = true
+		} else {
+			rest = append(rest, arg)
+		}
+	}
+
+	if dryRun {
+		for len(rest) < 3 {
+			dir, err := ioutil.TempDir("", "nodejs-buildpack.dry-run")
+			if err != nil {
+				break
+			}
+			rest = append(rest, dir)
+		}
+		if len(rest) < 4 {
+			rest = append(rest, "0")
+		}
+	}
+	return rest, dryRun
+}
+
+func plan(stager *libbuildpack.Stager, manifest *libbuildpack.Manifest, buildpackDir string, logger *libbuildpack.Logger) int {
+	if err := manifest.ApplyOverride(stager.DepsDir()); err != nil {
+		logger.Error("Unable to apply override.yml files: %s", err)
+		return 17
+	}
+	if err := hooks.AddExternalHooks(buildpackDir, stager.DepsDir()); err != nil {
+		logger.Error("Unable to find the hooks in %s: %s", hooks.ExternalHooksDir, err)
+		return 12
+	}
+
+	s := supply.Supplier{
+		Stager:   stager,
+		Manifest: manifest,
+		Log:      logger,
+	}
+
+	p := supply.PlanRun(&s, hooks.ActiveHooks(libbuildpack.NewLogger(ioutil.Discard)))
+	p.Log(logger)
+	if err := p.WriteJSON(os.Stdout); err != nil {
+		logger.Error("Unable to write plan: %s", err)
+		return 14
+	}
+
+	if len(p.Errors) > 0 {
+		return 14
+	}
+	return 0
 }
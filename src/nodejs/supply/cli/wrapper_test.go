@@ -0,0 +1,75 @@
+package main_test
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// The wrapper is run end to end against a go which builds a supply binary
+// that records its arguments, in place of downloading go and building the
+// real one.
+var _ = Describe("bin/supply", func() {
+	var (
+		err          error
+		buildpackDir string
+		argsFile     string
+	)
+
+	BeforeEach(func() {
+		buildpackDir, err = ioutil.TempDir("", "nodejs-buildpack.bp.")
+		Expect(err).To(BeNil())
+		argsFile = filepath.Join(buildpackDir, "args")
+
+		wrapper, err := ioutil.ReadFile(filepath.Join("..", "..", "..", "..", "bin", "supply"))
+		Expect(err).To(BeNil())
+		Expect(os.MkdirAll(filepath.Join(buildpackDir, "bin"), 0755)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(buildpackDir, "bin", "supply"), wrapper, 0755)).To(Succeed())
+
+		goDir := filepath.Join(buildpackDir, "go")
+		Expect(os.MkdirAll(filepath.Join(buildpackDir, "scripts"), 0755)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(buildpackDir, "scripts", "install_go.sh"), []byte("export GoInstallDir="+goDir+"\n"), 0755)).To(Succeed())
+		Expect(os.MkdirAll(filepath.Join(goDir, "go", "bin"), 0755)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(goDir, "go", "bin", "go"), []byte(`#!/bin/bash
+set -e
+[ "$1 $2" = "build -o" ]
+printf '#!/bin/bash\nprintf "%%s\\n" "$@" > `+argsFile+`\n' > "$3"
+chmod +x "$3"
+`), 0755)).To(Succeed())
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(buildpackDir)).To(Succeed())
+	})
+
+	run := func(args ...string) []string {
+		cmd := exec.Command(filepath.Join(buildpackDir, "bin", "supply"), args...)
+		output, err := cmd.CombinedOutput()
+		Expect(err).To(BeNil(), string(output))
+
+		recorded, err := ioutil.ReadFile(argsFile)
+		Expect(err).To(BeNil())
+		return strings.Split(strings.TrimSuffix(string(recorded), "\n"), "\n")
+	}
+
+	It("passes the dirs of staging to the supply binary", func() {
+		Expect(run("/tmp/app", "/tmp/cache", "/tmp/deps", "0")).To(Equal([]string{"/tmp/app", "/tmp/cache", "/tmp/deps", "0"}))
+	})
+
+	It("passes --verify on its own", func() {
+		Expect(run("--verify")).To(Equal([]string{"--verify"}))
+	})
+
+	It("passes --vendor-out with fewer dirs than staging", func() {
+		Expect(run("--vendor-out", "/tmp/out", "/tmp/app")).To(Equal([]string{"--vendor-out", "/tmp/out", "/tmp/app"}))
+	})
+
+	It("passes every argument, not only the first four", func() {
+		Expect(run("/tmp/app", "/tmp/cache", "/tmp/deps", "0", "--vendor-out=/tmp/out")).To(Equal([]string{"/tmp/app", "/tmp/cache", "/tmp/deps", "0", "--vendor-out=/tmp/out"}))
+	})
+})
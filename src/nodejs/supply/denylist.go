@@ -0,0 +1,75 @@
+package supply
+
+import (
+	"fmt"
+	"nodejs/denylist"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cloudfoundry/libbuildpack"
+)
+
+func (s *Supplier) loadPackageDenylist() (denylist.Denylist, error) {
+	list := denylist.Denylist{}
+
+	shipped := filepath.Join(s.Manifest.RootDir(), "package_denylist.json")
+	if found, err := libbuildpack.FileExists(shipped); err != nil {
+		return nil, err
+	} else if found {
+		d, err := denylist.Load(shipped)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load %s: %s", shipped, err)
+		}
+		list.Merge(d)
+	}
+
+	extra := os.Getenv("BP_PACKAGE_DENYLIST")
+	if extra == "" {
+		return list, nil
+	}
+
+	var d denylist.Denylist
+	var err error
+	if strings.HasPrefix(extra, "http://") || strings.HasPrefix(extra, "https://") {
+		d, err = denylist.LoadURL(extra)
+	} else {
+		d, err = denylist.Load(filepath.Join(s.Stager.BuildDir(), extra))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to load BP_PACKAGE_DENYLIST %s: %s", extra, err)
+	}
+	list.Merge(d)
+
+	return list, nil
+}
+
+// CheckPackageDenylist reports installed packages on the denylist shipped
+// with the buildpack or named by BP_PACKAGE_DENYLIST, failing the build when
+// BP_PACKAGE_DENYLIST_ENFORCE is set.
+func (s *Supplier) CheckPackageDenylist() error {
+	list, err := s.loadPackageDenylist()
+	if err != nil {
+		return err
+	}
+
+	matches, err := denylist.Scan(filepath.Join(s.Stager.BuildDir(), "node_modules"), list)
+	if err != nil {
+		return err
+	}
+	if len(matches) == 0 {
+		return nil
+	}
+
+	var lines []string
+	for _, m := range matches {
+		lines = append(lines, "  "+m.String())
+	}
+	report := fmt.Sprintf("Found packages on the denylist:\n%s", strings.Join(lines, "\n"))
+
+	if os.Getenv("BP_PACKAGE_DENYLIST_ENFORCE") == "true" {
+		return fmt.Errorf("%s\nUpdate or remove these packages, BP_PACKAGE_DENYLIST_ENFORCE is set", report)
+	}
+	s.Log.Warning("%s\nSet BP_PACKAGE_DENYLIST_ENFORCE=true to fail the build instead", report)
+	return nil
+}
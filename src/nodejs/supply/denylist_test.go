@@ -0,0 +1,106 @@
+package supply_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"nodejs/supply"
+	"os"
+	"path/filepath"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/cloudfoundry/libbuildpack/ansicleaner"
+	"golang.google.cn/x/mock/gomock"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("CheckPackageDenylist", func() {
+	var (
+		err          error
+		buildDir     string
+		bpDir        string
+		supplier     *supply.Supplier
+		buffer       *bytes.Buffer
+		mockCtrl     *gomock.Controller
+		mockManifest *MockManifest
+		oldEnv       map[string]string
+	)
+
+	writeFile := func(path, contents string) {
+		Expect(os.MkdirAll(filepath.Dir(path), 0755)).To(Succeed())
+		Expect(ioutil.WriteFile(path, []byte(contents), 0644)).To(Succeed())
+	}
+
+	BeforeEach(func() {
+		buildDir, err = ioutil.TempDir("", "nodejs-buildpack.build.")
+		Expect(err).To(BeNil())
+		bpDir, err = ioutil.TempDir("", "nodejs-buildpack.bp.")
+		Expect(err).To(BeNil())
+
+		oldEnv = map[string]string{}
+		for _, key := range []string{"BP_PACKAGE_DENYLIST", "BP_PACKAGE_DENYLIST_ENFORCE"} {
+			oldEnv[key] = os.Getenv(key)
+			os.Unsetenv(key)
+		}
+
+		writeFile(filepath.Join(bpDir, "package_denylist.json"), `{"packages": {"event-stream": [{"versions": "3.3.6", "reason": "flatmap-stream"}]}}`)
+		writeFile(filepath.Join(buildDir, "node_modules", "event-stream", "package.json"), `{"name":"event-stream","version":"3.3.6"}`)
+		writeFile(filepath.Join(buildDir, "node_modules", "@corp", "leftpad", "package.json"), `{"name":"@corp/leftpad","version":"1.0.1"}`)
+
+		mockCtrl = gomock.NewController(GinkgoT())
+		mockManifest = NewMockManifest(mockCtrl)
+		mockManifest.EXPECT().RootDir().Return(bpDir).AnyTimes()
+
+		buffer = new(bytes.Buffer)
+		logger := libbuildpack.NewLogger(ansicleaner.New(buffer))
+		supplier = &supply.Supplier{
+			Stager:   libbuildpack.NewStager([]string{buildDir, "", "", "0"}, logger, &libbuildpack.Manifest{}),
+			Manifest: mockManifest,
+			Log:      logger,
+		}
+	})
+
+	AfterEach(func() {
+		mockCtrl.Finish()
+		for key, value := range oldEnv {
+			os.Setenv(key, value)
+		}
+		Expect(os.RemoveAll(buildDir)).To(Succeed())
+		Expect(os.RemoveAll(bpDir)).To(Succeed())
+	})
+
+	It("warns about packages on the shipped denylist", func() {
+		Expect(supplier.CheckPackageDenylist()).To(Succeed())
+		Expect(buffer.String()).To(ContainSubstring("Found packages on the denylist:"))
+		Expect(buffer.String()).To(ContainSubstring("event-stream@3.3.6 (node_modules/event-stream): flatmap-stream"))
+		Expect(buffer.String()).To(ContainSubstring("Set BP_PACKAGE_DENYLIST_ENFORCE=true"))
+	})
+
+	It("extends the denylist with a file from the app", func() {
+		writeFile(filepath.Join(buildDir, "denylist.json"), `{"packages": {"@corp/leftpad": [{"versions": "<1.1.0", "reason": "internal advisory"}]}}`)
+		os.Setenv("BP_PACKAGE_DENYLIST", "denylist.json")
+
+		Expect(supplier.CheckPackageDenylist()).To(Succeed())
+		Expect(buffer.String()).To(ContainSubstring("@corp/leftpad@1.0.1 (node_modules/@corp/leftpad): internal advisory"))
+		Expect(buffer.String()).To(ContainSubstring("event-stream@3.3.6"))
+	})
+
+	It("fails the build when enforcing", func() {
+		os.Setenv("BP_PACKAGE_DENYLIST_ENFORCE", "true")
+		err := supplier.CheckPackageDenylist()
+		Expect(err).To(MatchError(ContainSubstring("event-stream@3.3.6 (node_modules/event-stream): flatmap-stream")))
+		Expect(buffer.String()).To(Equal(""))
+	})
+
+	It("does nothing without matches", func() {
+		Expect(os.RemoveAll(filepath.Join(buildDir, "node_modules", "event-stream"))).To(Succeed())
+		os.Setenv("BP_PACKAGE_DENYLIST_ENFORCE", "true")
+		Expect(supplier.CheckPackageDenylist()).To(Succeed())
+		Expect(buffer.String()).To(Equal(""))
+	})
+
+	It("fails when BP_PACKAGE_DENYLIST cannot be loaded", func() {
+		os.Setenv("BP_PACKAGE_DENYLIST", "missing.json")
+		Expect(supplier.CheckPackageDenylist()).To(MatchError(ContainSubstring("unable to load BP_PACKAGE_DENYLIST missing.json")))
+	})
+})
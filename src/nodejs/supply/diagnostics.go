@@ -0,0 +1,83 @@
+package supply
+
+import (
+	"fmt"
+	"nodejs/profiled"
+	"os"
+	"strings"
+)
+
+// DiagnosticDir is where node writes the heap snapshots and reports of an
+// instance with BP_NODE_DIAGNOSTICS=true. It is outside the app dir, so that
+// the snapshots don't end up in a droplet built from the container.
+const DiagnosticDir = "/home/vcap/tmp/diag"
+
+// diagnosticOptions are the node flags BP_NODE_DIAGNOSTICS adds at runtime
+// and the node versions which accept them.
+var diagnosticOptions = []struct{ option, constraint string }{
+	{"--heapsnapshot-signal=SIGUSR2", ">=12.0.0"},
+	// An unknown flag in NODE_OPTIONS keeps node from starting, and
+	// --diagnostic-dir came with 16.4.0 and 14.18.0.
+	{"--diagnostic-dir=" + DiagnosticDir, ">=16.4.0 || >=14.18.0, <15.0.0"},
+}
+
+// DiagnosticOptions returns the flags of BP_NODE_DIAGNOSTICS which node
+// nodeVersion accepts.
+func DiagnosticOptions(nodeVersion string) ([]string, error) {
+	var options []string
+	for _, o := range diagnosticOptions {
+		supported, err := nodeVersionMatches(nodeVersion, o.constraint)
+		if err != nil {
+			return nil, err
+		}
+		if supported {
+			options = append(options, o.option)
+		}
+	}
+	return options, nil
+}
+
+// RuntimeNodeOptions returns the lines of a profile.d script which add each
+// of options to NODE_OPTIONS at boot, unless NODE_OPTIONS has the option
+// already, from the user or another profile.d script, with any value.
+func RuntimeNodeOptions(options ...string) string {
+	var lines []string
+	for _, option := range options {
+		name := strings.SplitN(option, "=", 2)[0]
+		lines = append(lines, fmt.Sprintf(`case " ${NODE_OPTIONS-} " in *" %[1]s "*|*" %[1]s="*) ;; *) export NODE_OPTIONS="${NODE_OPTIONS:+$NODE_OPTIONS }%[2]s" ;; esac`, name, option))
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// setupDiagnostics makes node write a heap snapshot on SIGUSR2 with
+// BP_NODE_DIAGNOSTICS=true, to debug memory leaks of a running instance
+// without deploying an instrumented build.
+func (s *Supplier) setupDiagnostics() error {
+	if os.Getenv("BP_NODE_DIAGNOSTICS") != "true" {
+		return nil
+	}
+
+	options, err := DiagnosticOptions(s.ExactNodeVersion)
+	if err != nil {
+		return err
+	}
+	if len(options) == 0 {
+		s.Log.Warning("Ignoring BP_NODE_DIAGNOSTICS: node %s does not support --heapsnapshot-signal, use node 12 or later", s.ExactNodeVersion)
+		return nil
+	}
+
+	// node before 14 writes the snapshots to the working dir, the app dir.
+	dir := DiagnosticDir
+	if len(options) < len(diagnosticOptions) {
+		dir = "/home/vcap/app"
+		s.Log.Warning("node %s does not support --diagnostic-dir, heap snapshots are written to the app dir and count against the disk quota of the app", s.ExactNodeVersion)
+	}
+
+	s.Log.Info("Running node with %s (BP_NODE_DIAGNOSTICS)", strings.Join(options, " "))
+	s.Log.Info("To capture a heap snapshot of an instance, which pauses it and takes about as much memory again as its heap, run\n"+
+		"  cf ssh <app> -i <instance> -c 'kill -USR2 $(pgrep -o -x node)'\n"+
+		"and once node has written it to %[1]s, copy it with\n"+
+		"  cf ssh <app> -i <instance> -c 'cat %[1]s/*.heapsnapshot' > app.heapsnapshot", dir)
+
+	return profiled.Write(s.Stager, "diagnostics.sh", "mkdir -p "+DiagnosticDir+"\n"+RuntimeNodeOptions(options...))
+}
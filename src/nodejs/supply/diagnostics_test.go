@@ -0,0 +1,164 @@
+package supply_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"nodejs/supply"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/cloudfoundry/libbuildpack/ansicleaner"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Diagnostics", func() {
+	var (
+		buildDir string
+		depsDir  string
+		supplier *supply.Supplier
+		buffer   *bytes.Buffer
+		oldEnv   map[string]string
+	)
+
+	// source runs the profile.d scripts in order with NODE_OPTIONS and a
+	// mkdir which records its arguments, and returns NODE_OPTIONS and the
+	// dirs created.
+	source := func(nodeOptions string, scripts ...string) (string, string) {
+		binDir := filepath.Join(depsDir, "fakebin")
+		Expect(os.MkdirAll(binDir, 0755)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(binDir, "mkdir"), []byte("#!/bin/sh\necho \"$@\" >> \"$(dirname \"$0\")/mkdir.log\"\n"), 0755)).To(Succeed())
+
+		command := []string{}
+		for _, script := range scripts {
+			command = append(command, ". "+script)
+		}
+		cmd := exec.Command("bash", "-c", strings.Join(append(command, `printf %s "$NODE_OPTIONS"`), " && "))
+		cmd.Env = []string{"PATH=" + binDir + ":" + os.Getenv("PATH")}
+		if nodeOptions != "" {
+			cmd.Env = append(cmd.Env, "NODE_OPTIONS="+nodeOptions)
+		}
+		output, err := cmd.CombinedOutput()
+		Expect(err).To(BeNil(), string(output))
+
+		dirs, _ := ioutil.ReadFile(filepath.Join(binDir, "mkdir.log"))
+		return string(output), string(dirs)
+	}
+
+	BeforeEach(func() {
+		oldEnv = map[string]string{}
+		for _, key := range []string{"BP_NODE_DIAGNOSTICS", "BP_RUNTIME_OPENSSL_LEGACY_PROVIDER", "NODE_OPTIONS"} {
+			oldEnv[key] = os.Getenv(key)
+			os.Unsetenv(key)
+		}
+
+		var err error
+		buildDir, err = ioutil.TempDir("", "nodejs-buildpack.build.")
+		Expect(err).To(BeNil())
+		depsDir, err = ioutil.TempDir("", "nodejs-buildpack.deps.")
+		Expect(err).To(BeNil())
+		Expect(os.MkdirAll(filepath.Join(depsDir, "0"), 0755)).To(Succeed())
+
+		buffer = new(bytes.Buffer)
+		logger := libbuildpack.NewLogger(ansicleaner.New(buffer))
+		supplier = &supply.Supplier{
+			Stager:           libbuildpack.NewStager([]string{buildDir, "", depsDir, "0"}, logger, &libbuildpack.Manifest{}),
+			Log:              logger,
+			ExactNodeVersion: "20.11.1",
+		}
+	})
+
+	AfterEach(func() {
+		for key, value := range oldEnv {
+			os.Setenv(key, value)
+		}
+		Expect(os.RemoveAll(buildDir)).To(Succeed())
+		Expect(os.RemoveAll(depsDir)).To(Succeed())
+	})
+
+	profileScript := func(name string) string {
+		return filepath.Join(depsDir, "0", "profile.d", "000_nodejs_buildpack_"+name)
+	}
+
+	DescribeTable("DiagnosticOptions",
+		func(nodeVersion string, expected []string) {
+			options, err := supply.DiagnosticOptions(nodeVersion)
+			Expect(err).To(BeNil())
+			Expect(options).To(Equal(expected))
+		},
+		Entry("node 10", "10.24.1", nil),
+		Entry("node 12", "12.0.0", []string{"--heapsnapshot-signal=SIGUSR2"}),
+		Entry("node 13", "13.14.0", []string{"--heapsnapshot-signal=SIGUSR2"}),
+		Entry("node 14.17", "14.17.6", []string{"--heapsnapshot-signal=SIGUSR2"}),
+		Entry("node 14.18", "14.18.0", []string{"--heapsnapshot-signal=SIGUSR2", "--diagnostic-dir=/home/vcap/tmp/diag"}),
+		Entry("node 15", "15.14.0", []string{"--heapsnapshot-signal=SIGUSR2"}),
+		Entry("node 16.3", "16.3.0", []string{"--heapsnapshot-signal=SIGUSR2"}),
+		Entry("node 16.4", "16.4.0", []string{"--heapsnapshot-signal=SIGUSR2", "--diagnostic-dir=/home/vcap/tmp/diag"}),
+		Entry("node 20", "20.11.1", []string{"--heapsnapshot-signal=SIGUSR2", "--diagnostic-dir=/home/vcap/tmp/diag"}),
+	)
+
+	DescribeTable("RuntimeNodeOptions",
+		func(nodeOptions, expected string) {
+			script := filepath.Join(depsDir, "options.sh")
+			Expect(ioutil.WriteFile(script, []byte(supply.RuntimeNodeOptions("--heapsnapshot-signal=SIGUSR2", "--openssl-legacy-provider")), 0644)).To(Succeed())
+
+			output, _ := source(nodeOptions, script)
+			Expect(output).To(Equal(expected))
+		},
+		Entry("without NODE_OPTIONS", "", "--heapsnapshot-signal=SIGUSR2 --openssl-legacy-provider"),
+		Entry("with other options", "--max-old-space-size=2048", "--max-old-space-size=2048 --heapsnapshot-signal=SIGUSR2 --openssl-legacy-provider"),
+		Entry("with the option already", "--openssl-legacy-provider", "--openssl-legacy-provider --heapsnapshot-signal=SIGUSR2"),
+		Entry("with another value of the option", "--heapsnapshot-signal=SIGUSR1", "--heapsnapshot-signal=SIGUSR1 --openssl-legacy-provider"),
+		Entry("with a longer option", "--openssl-legacy-provider-x", "--openssl-legacy-provider-x --heapsnapshot-signal=SIGUSR2 --openssl-legacy-provider"),
+	)
+
+	Describe("CreateDefaultEnv", func() {
+		It("adds no diagnostics by default", func() {
+			Expect(supplier.CreateDefaultEnv()).To(Succeed())
+			Expect(profileScript("diagnostics.sh")).NotTo(BeAnExistingFile())
+			Expect(buffer.String()).NotTo(ContainSubstring("BP_NODE_DIAGNOSTICS"))
+		})
+
+		It("creates the diagnostic dir and adds the flags to NODE_OPTIONS once", func() {
+			os.Setenv("BP_NODE_DIAGNOSTICS", "true")
+			os.Setenv("BP_RUNTIME_OPENSSL_LEGACY_PROVIDER", "true")
+
+			Expect(supplier.CreateDefaultEnv()).To(Succeed())
+			Expect(supplier.SetupOpenSSLLegacyProvider()).To(Succeed())
+			Expect(buffer.String()).To(ContainSubstring("Running node with --heapsnapshot-signal=SIGUSR2 --diagnostic-dir=/home/vcap/tmp/diag (BP_NODE_DIAGNOSTICS)"))
+			Expect(buffer.String()).To(ContainSubstring("cf ssh <app> -i <instance> -c 'kill -USR2 $(pgrep -o -x node)'"))
+			Expect(buffer.String()).To(ContainSubstring("cf ssh <app> -i <instance> -c 'cat /home/vcap/tmp/diag/*.heapsnapshot' > app.heapsnapshot"))
+
+			// Sourcing the scripts again, as a cf ssh session does, adds nothing.
+			diagnostics, openssl := profileScript("diagnostics.sh"), profileScript("openssl.sh")
+			output, dirs := source("--max-old-space-size=2048", diagnostics, openssl, diagnostics, openssl)
+			Expect(output).To(Equal("--max-old-space-size=2048 --heapsnapshot-signal=SIGUSR2 --diagnostic-dir=/home/vcap/tmp/diag --openssl-legacy-provider"))
+			Expect(dirs).To(HavePrefix("-p /home/vcap/tmp/diag\n"))
+		})
+
+		It("leaves out --diagnostic-dir for node before 14.18 and 16.4", func() {
+			os.Setenv("BP_NODE_DIAGNOSTICS", "true")
+			supplier.ExactNodeVersion = "12.22.12"
+
+			Expect(supplier.CreateDefaultEnv()).To(Succeed())
+			Expect(buffer.String()).To(ContainSubstring("node 12.22.12 does not support --diagnostic-dir, heap snapshots are written to the app dir"))
+			Expect(buffer.String()).To(ContainSubstring("cat /home/vcap/app/*.heapsnapshot"))
+
+			output, _ := source("", profileScript("diagnostics.sh"))
+			Expect(output).To(Equal("--heapsnapshot-signal=SIGUSR2"))
+		})
+
+		It("ignores BP_NODE_DIAGNOSTICS for node before 12", func() {
+			os.Setenv("BP_NODE_DIAGNOSTICS", "true")
+			supplier.ExactNodeVersion = "10.24.1"
+
+			Expect(supplier.CreateDefaultEnv()).To(Succeed())
+			Expect(profileScript("diagnostics.sh")).NotTo(BeAnExistingFile())
+			Expect(buffer.String()).To(ContainSubstring("Ignoring BP_NODE_DIAGNOSTICS: node 10.24.1 does not support --heapsnapshot-signal"))
+		})
+	})
+})
@@ -0,0 +1,440 @@
+package supply
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/cloudfoundry/libbuildpack"
+)
+
+const (
+	kibibyte = 1024
+	mebibyte = 1024 * kibibyte
+
+	// DefaultNodeDownloadSize is the size of a node tarball, for uncached
+	// buildpacks whose manifest has no file to measure.
+	DefaultNodeDownloadSize = 45 * mebibyte
+	// NodeExtractRatio is how much larger an extracted node install is than
+	// its tarball.
+	NodeExtractRatio = 3
+	// PackageInstallSize is the average installed size of one package,
+	// measured over the node_modules of typical apps: most packages are a
+	// few files of JavaScript, a few large ones skew the mean.
+	PackageInstallSize = 300 * kibibyte
+	// PreviousBuildMultiplier leaves headroom over the node_modules size of
+	// the previous build.
+	PreviousBuildMultiplier = 1.5
+
+	// NodeInstallInodes is the number of files and dirs of an extracted node
+	// install, npm included.
+	NodeInstallInodes = 5000
+	// InodesPerPackage is the average number of files and dirs one installed
+	// package takes, measured over the node_modules of typical apps.
+	InodesPerPackage = 60
+	// InodeHeadroom is how many times the estimate a filesystem should have
+	// free before the check stops warning: the file count of packages varies
+	// much more than their size.
+	InodeHeadroom = 2
+)
+
+// DiskRequirement is the space and inodes a build needs below Path.
+type DiskRequirement struct {
+	Path     string
+	Required uint64
+	Inodes   uint64
+}
+
+// FilesystemStat is the device id and the free space and inodes of the
+// filesystem of a path. TotalInodes is 0 on filesystems without a fixed
+// number of inodes, like btrfs.
+type FilesystemStat struct {
+	Device      uint64
+	Free        uint64
+	FreeInodes  uint64
+	TotalInodes uint64
+}
+
+// Filesystem is the total requirement of all paths on one filesystem.
+type Filesystem struct {
+	Paths          []string
+	Free           uint64
+	Required       uint64
+	FreeInodes     uint64
+	TotalInodes    uint64
+	RequiredInodes uint64
+}
+
+// InodesLimited is whether the filesystem has a fixed number of inodes to
+// run out of.
+func (fs Filesystem) InodesLimited() bool {
+	return fs.TotalInodes > 0
+}
+
+// EstimateNodeModulesSize estimates the size of node_modules from the
+// previous build when known, otherwise from the number of packages in the
+// lockfile.
+func EstimateNodeModulesSize(packageCount int, previousSize uint64) uint64 {
+	if previousSize > 0 {
+		return uint64(float64(previousSize) * PreviousBuildMultiplier)
+	}
+	return uint64(packageCount) * PackageInstallSize
+}
+
+// EstimateNodeModulesInodes estimates the number of files and dirs of
+// node_modules from the number of packages in the lockfile.
+func EstimateNodeModulesInodes(packageCount int) uint64 {
+	return uint64(packageCount) * InodesPerPackage
+}
+
+// DiskRequirements returns the space and inodes needed in the build dir
+// (node_modules), the dep dir (the extracted node) and the tmp dir (the node
+// download and package extraction).
+func DiskRequirements(buildDir, depDir, tmpDir string, nodeDownloadSize, nodeModulesSize, nodeModulesInodes uint64) []DiskRequirement {
+	return []DiskRequirement{
+		{Path: buildDir, Required: nodeModulesSize, Inodes: nodeModulesInodes},
+		{Path: depDir, Required: nodeDownloadSize * NodeExtractRatio, Inodes: NodeInstallInodes},
+		{Path: tmpDir, Required: nodeDownloadSize + nodeModulesSize/4, Inodes: nodeModulesInodes / 4},
+	}
+}
+
+// GroupByFilesystem adds up the requirements of paths which share a
+// filesystem.
+func GroupByFilesystem(requirements []DiskRequirement, stat func(string) (FilesystemStat, error)) ([]Filesystem, error) {
+	var filesystems []Filesystem
+	byDevice := map[uint64]int{}
+	for _, requirement := range requirements {
+		fsStat, err := stat(requirement.Path)
+		if err != nil {
+			return nil, err
+		}
+		idx, found := byDevice[fsStat.Device]
+		if !found {
+			idx = len(filesystems)
+			byDevice[fsStat.Device] = idx
+			filesystems = append(filesystems, Filesystem{Free: fsStat.Free, FreeInodes: fsStat.FreeInodes, TotalInodes: fsStat.TotalInodes})
+		}
+		filesystems[idx].Paths = append(filesystems[idx].Paths, requirement.Path)
+		filesystems[idx].Required += requirement.Required
+		filesystems[idx].RequiredInodes += requirement.Inodes
+	}
+	return filesystems, nil
+}
+
+// InsufficientSpace returns the filesystems with less free space or fewer
+// free inodes than required.
+func InsufficientSpace(filesystems []Filesystem) []Filesystem {
+	var short []Filesystem
+	for _, fs := range filesystems {
+		if fs.Free < fs.Required || (fs.InodesLimited() && fs.FreeInodes < fs.RequiredInodes) {
+			short = append(short, fs)
+		}
+	}
+	return short
+}
+
+// LowInodes returns the filesystems with enough free inodes for the estimate,
+// but less than InodeHeadroom times it.
+func LowInodes(filesystems []Filesystem) []Filesystem {
+	var low []Filesystem
+	for _, fs := range filesystems {
+		if fs.InodesLimited() && fs.FreeInodes >= fs.RequiredInodes && fs.FreeInodes < fs.RequiredInodes*InodeHeadroom {
+			low = append(low, fs)
+		}
+	}
+	return low
+}
+
+// statFilesystem statfs's the closest existing parent of path.
+func statFilesystem(path string) (FilesystemStat, error) {
+	for {
+		if _, err := os.Stat(path); err == nil || path == filepath.Dir(path) {
+			break
+		}
+		path = filepath.Dir(path)
+	}
+
+	var stat syscall.Stat_t
+	if err := syscall.Stat(path, &stat); err != nil {
+		return FilesystemStat{}, err
+	}
+	var statfs syscall.Statfs_t
+	if err := syscall.Statfs(path, &statfs); err != nil {
+		return FilesystemStat{}, err
+	}
+	return FilesystemStat{
+		Device:      uint64(stat.Dev),
+		Free:        uint64(statfs.Bavail) * uint64(statfs.Bsize),
+		FreeInodes:  uint64(statfs.Ffree),
+		TotalInodes: uint64(statfs.Files),
+	}, nil
+}
+
+// CountLockfilePackages returns the number of packages in package-lock.json,
+// npm-shrinkwrap.json or yarn.lock, or 0 without a lockfile.
+func CountLockfilePackages(buildDir string) (int, error) {
+	for _, name := range []string{"package-lock.json", "npm-shrinkwrap.json"} {
+		var lock struct {
+			Dependencies map[string]lockedDependency `json:"dependencies"`
+			Packages     map[string]interface{}      `json:"packages"`
+		}
+		path := filepath.Join(buildDir, name)
+		if found, err := libbuildpack.FileExists(path); err != nil {
+			return 0, err
+		} else if !found {
+			continue
+		}
+		if err := libbuildpack.NewJSON().Load(path, &lock); err != nil {
+			return 0, fmt.Errorf("Unable to read %s: %s", name, err)
+		}
+		if len(lock.Packages) > 0 {
+			_, hasRoot := lock.Packages[""]
+			if hasRoot {
+				return len(lock.Packages) - 1, nil
+			}
+			return len(lock.Packages), nil
+		}
+		return countLockedDependencies(lock.Dependencies), nil
+	}
+
+	f, err := os.Open(filepath.Join(buildDir, "yarn.lock"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	defer f.Close()
+
+	count := 0
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line != "" && !strings.HasPrefix(line, " ") && !strings.HasPrefix(line, "#") && strings.HasSuffix(line, ":") {
+			count++
+		}
+	}
+	return count, scanner.Err()
+}
+
+type lockedDependency struct {
+	Dependencies map[string]lockedDependency `json:"dependencies"`
+}
+
+func countLockedDependencies(deps map[string]lockedDependency) int {
+	count := len(deps)
+	for _, dep := range deps {
+		count += countLockedDependencies(dep.Dependencies)
+	}
+	return count
+}
+
+// SetupTmpDir points TMPDIR and npm_config_tmp at the cache filesystem when
+// BP_TMPDIR=cache, for stagers with a small /tmp.
+func (s *Supplier) SetupTmpDir() error {
+	if os.Getenv("BP_TMPDIR") != "cache" {
+		return nil
+	}
+
+	tmpDir := filepath.Join(s.Stager.CacheDir(), "tmp")
+	if err := os.RemoveAll(tmpDir); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(tmpDir, 0755); err != nil {
+		return err
+	}
+	s.Log.Info("Using %s as the tmp directory (BP_TMPDIR=cache)", tmpDir)
+	for _, env := range []string{"TMPDIR", "npm_config_tmp"} {
+		if err := os.Setenv(env, tmpDir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CleanupTmpDir keeps the tmp directory from BP_TMPDIR=cache out of the cache.
+func (s *Supplier) CleanupTmpDir() error {
+	if os.Getenv("BP_TMPDIR") != "cache" {
+		return nil
+	}
+	return os.RemoveAll(filepath.Join(s.Stager.CacheDir(), "tmp"))
+}
+
+// CheckDiskSpace fails early when the build, dep or tmp filesystem does not
+// have room for the install, instead of failing with ENOSPC halfway through.
+// Only the node_modules size of a previous build is reliable enough to fail
+// on, an estimate from the lockfile only warns.
+func (s *Supplier) CheckDiskSpace() error {
+	if os.Getenv("BP_SKIP_DISK_CHECK") == "true" {
+		return nil
+	}
+
+	packageCount, err := CountLockfilePackages(s.Stager.BuildDir())
+	if err != nil {
+		return err
+	}
+	metadata, err := LoadCacheMetadata(s.Stager.CacheDir())
+	if err != nil {
+		return err
+	}
+
+	nodeModulesSize := EstimateNodeModulesSize(packageCount, metadata.NodeModulesSize)
+	requirements := DiskRequirements(s.Stager.BuildDir(), s.Stager.DepDir(), os.TempDir(), s.nodeDownloadSize(), nodeModulesSize, EstimateNodeModulesInodes(packageCount))
+	filesystems, err := GroupByFilesystem(requirements, s.statFilesystem())
+	if err != nil {
+		return err
+	}
+
+	for _, fs := range LowInodes(filesystems) {
+		s.Log.Warning("%s: %d inodes free, about %d needed for the %d packages of the lockfile\nnode_modules takes an inode per file, the install may fail with ENOSPC with disk space left", strings.Join(fs.Paths, ", "), fs.FreeInodes, fs.RequiredInodes, packageCount)
+	}
+
+	short := InsufficientSpace(filesystems)
+	if len(short) == 0 {
+		return nil
+	}
+
+	var lines []string
+	for _, fs := range short {
+		line := fmt.Sprintf("  %s: %d MiB free, about %d MiB needed", strings.Join(fs.Paths, ", "), fs.Free/mebibyte, fs.Required/mebibyte)
+		if fs.InodesLimited() && fs.FreeInodes < fs.RequiredInodes {
+			line += fmt.Sprintf("; %d inodes free, about %d needed", fs.FreeInodes, fs.RequiredInodes)
+		}
+		lines = append(lines, line)
+	}
+	if metadata.NodeModulesSize == 0 {
+		s.Log.Warning("the dependencies may not fit on disk, estimated from the %d packages of the lockfile:\n%s\nIncrease the disk quota of the app or set BP_TMPDIR=cache when /tmp is small if the install fails with ENOSPC", packageCount, strings.Join(lines, "\n"))
+		return nil
+	}
+	return fmt.Errorf("not enough disk space to install dependencies:\n%s\nIncrease the disk quota of the app, set BP_TMPDIR=cache when /tmp is small, or set BP_SKIP_DISK_CHECK=true to skip this check", strings.Join(lines, "\n"))
+}
+
+// nodeDownloadSize returns the size of the cached node tarball of the
+// manifest entry InstallNode will install, or DefaultNodeDownloadSize when
+// the buildpack is uncached or the version does not resolve. InstallNode
+// reports resolve errors.
+func (s *Supplier) nodeDownloadSize() uint64 {
+	if s.Manifest == nil {
+		return DefaultNodeDownloadSize
+	}
+	dep, err := s.ResolveNode()
+	if err != nil {
+		return DefaultNodeDownloadSize
+	}
+
+	var manifest struct {
+		Entries []libbuildpack.ManifestEntry `yaml:"dependencies"`
+	}
+	if err := libbuildpack.NewYAML().Load(filepath.Join(s.Manifest.RootDir(), "manifest.yml"), &manifest); err != nil {
+		return DefaultNodeDownloadSize
+	}
+	for _, entry := range manifest.Entries {
+		if entry.Dependency != dep || entry.File == "" {
+			continue
+		}
+		if info, err := os.Stat(filepath.Join(s.Manifest.RootDir(), entry.File)); err == nil {
+			return uint64(info.Size())
+		}
+	}
+	return DefaultNodeDownloadSize
+}
+
+func (s *Supplier) statFilesystem() func(string) (FilesystemStat, error) {
+	if s.StatFilesystem != nil {
+		return s.StatFilesystem
+	}
+	return statFilesystem
+}
+
+// DiskFullHint explains an install which failed with ENOSPC, given the
+// filesystem of the build dir after the failure. Running out of inodes
+// leaves disk space free, which makes the npm message misleading.
+func DiskFullHint(output string, fs FilesystemStat) string {
+	if !strings.Contains(output, "ENOSPC") && !strings.Contains(output, "no space left on device") {
+		return ""
+	}
+	if fs.TotalInodes > 0 && fs.FreeInodes < fs.TotalInodes/100 {
+		return fmt.Sprintf("The filesystem ran out of inodes (ENOSPC with %d MiB free, %d of %d inodes free): node_modules takes an inode per file. Increase the disk quota of the app, which raises the inode limit, or trim dependencies", fs.Free/mebibyte, fs.FreeInodes, fs.TotalInodes)
+	}
+	return fmt.Sprintf("The filesystem ran out of space (ENOSPC with %d MiB free). Increase the disk quota of the app, or set BP_TMPDIR=cache when /tmp is small", fs.Free/mebibyte)
+}
+
+// logfileSize returns how much the log file holds so far, the offset the
+// output of the next command starts at.
+func (s *Supplier) logfileSize() int64 {
+	if s.Logfile == nil {
+		return 0
+	}
+	info, err := os.Stat(s.Logfile.Name())
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
+
+// diagnoseInstallFailure adds the DiskFullHint to the error of an install
+// which ran out of disk. Only the error and what the log file got from
+// installStart on are searched, the warnings of the buildpack before the
+// install mention ENOSPC too.
+func (s *Supplier) diagnoseInstallFailure(err error, installStart int64) error {
+	output := err.Error()
+	if s.Logfile != nil {
+		if contents, readErr := ioutil.ReadFile(s.Logfile.Name()); readErr == nil && int64(len(contents)) > installStart {
+			output += string(contents[installStart:])
+		}
+	}
+	fsStat, statErr := s.statFilesystem()(s.Stager.BuildDir())
+	if statErr != nil {
+		return err
+	}
+	if hint := DiskFullHint(output, fsStat); hint != "" {
+		return fmt.Errorf("%s\n%s", err.Error(), hint)
+	}
+	return err
+}
+
+// RecordNodeModulesSize saves the size of node_modules for the disk space
+// estimate of the next build.
+func (s *Supplier) RecordNodeModulesSize() error {
+	location, err := s.NodeModulesLocation()
+	if err != nil {
+		return err
+	}
+	nodeModules := filepath.Join(s.Stager.DepDir(), "node_modules")
+	if location == NodeModulesAppDir {
+		nodeModules = filepath.Join(s.Stager.BuildDir(), "node_modules")
+	}
+
+	size, err := dirSize(nodeModules)
+	if err != nil {
+		return err
+	}
+
+	metadata, err := LoadCacheMetadata(s.Stager.CacheDir())
+	if err != nil {
+		return err
+	}
+	metadata.NodeModulesSize = size
+	return metadata.Save(s.Stager.CacheDir())
+}
+
+func dirSize(dir string) (uint64, error) {
+	var size uint64
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.Mode().IsRegular() {
+			size += uint64(info.Size())
+		}
+		return nil
+	})
+	return size, err
+}
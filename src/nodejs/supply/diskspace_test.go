@@ -0,0 +1,289 @@
+package supply_test
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"nodejs/supply"
+	"os"
+	"path/filepath"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/cloudfoundry/libbuildpack/ansicleaner"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Disk space", func() {
+	const mib = 1024 * 1024
+
+	Describe("EstimateNodeModulesSize", func() {
+		It("estimates from the package count", func() {
+			Expect(supply.EstimateNodeModulesSize(300, 0)).To(Equal(uint64(300 * 300 * 1024)))
+		})
+
+		It("fits a 1,500 package lockfile and node into 1 GiB", func() {
+			var required uint64
+			for _, requirement := range supply.DiskRequirements("/build", "/deps/0", "/tmp", supply.DefaultNodeDownloadSize, supply.EstimateNodeModulesSize(1500, 0), 0) {
+				required += requirement.Required
+			}
+			Expect(required).To(BeNumerically("<", 1024*mib))
+		})
+
+		It("prefers the size of the previous build", func() {
+			Expect(supply.EstimateNodeModulesSize(300, 100*mib)).To(Equal(uint64(150 * mib)))
+		})
+	})
+
+	Describe("EstimateNodeModulesInodes", func() {
+		It("estimates from the package count", func() {
+			Expect(supply.EstimateNodeModulesInodes(300)).To(Equal(uint64(18000)))
+		})
+	})
+
+	Describe("GroupByFilesystem and InsufficientSpace", func() {
+		var stats map[string]supply.FilesystemStat
+
+		stat := func(path string) (supply.FilesystemStat, error) {
+			s, found := stats[path]
+			if !found {
+				return supply.FilesystemStat{}, errors.New("no such path")
+			}
+			return s, nil
+		}
+
+		requirements := supply.DiskRequirements("/build", "/deps/0", "/tmp", 50*mib, 400*mib, 40000)
+
+		It("adds up the requirements of paths on the same filesystem", func() {
+			stats = map[string]supply.FilesystemStat{
+				"/build":  {Device: 1, Free: 1000 * mib, FreeInodes: 500000, TotalInodes: 1000000},
+				"/deps/0": {Device: 1, Free: 1000 * mib, FreeInodes: 500000, TotalInodes: 1000000},
+				"/tmp":    {Device: 2, Free: 300 * mib},
+			}
+
+			filesystems, err := supply.GroupByFilesystem(requirements, stat)
+			Expect(err).To(BeNil())
+			Expect(filesystems).To(Equal([]supply.Filesystem{
+				{Paths: []string{"/build", "/deps/0"}, Free: 1000 * mib, Required: 550 * mib, FreeInodes: 500000, TotalInodes: 1000000, RequiredInodes: 45000},
+				{Paths: []string{"/tmp"}, Free: 300 * mib, Required: 150 * mib, RequiredInodes: 10000},
+			}))
+			Expect(supply.InsufficientSpace(filesystems)).To(BeEmpty())
+			Expect(supply.LowInodes(filesystems)).To(BeEmpty())
+		})
+
+		It("reports filesystems which are too small", func() {
+			stats = map[string]supply.FilesystemStat{
+				"/build":  {Device: 1, Free: 500 * mib},
+				"/deps/0": {Device: 1, Free: 500 * mib},
+				"/tmp":    {Device: 1, Free: 500 * mib},
+			}
+
+			filesystems, err := supply.GroupByFilesystem(requirements, stat)
+			Expect(err).To(BeNil())
+			Expect(supply.InsufficientSpace(filesystems)).To(Equal([]supply.Filesystem{
+				{Paths: []string{"/build", "/deps/0", "/tmp"}, Free: 500 * mib, Required: 700 * mib, RequiredInodes: 55000},
+			}))
+		})
+
+		It("reports filesystems with space but too few inodes", func() {
+			stats = map[string]supply.FilesystemStat{
+				"/build":  {Device: 1, Free: 8000 * mib, FreeInodes: 30000, TotalInodes: 1000000},
+				"/deps/0": {Device: 2, Free: 8000 * mib, FreeInodes: 8000, TotalInodes: 1000000},
+				"/tmp":    {Device: 3, Free: 8000 * mib, FreeInodes: 200, TotalInodes: 1000000},
+			}
+
+			filesystems, err := supply.GroupByFilesystem(requirements, stat)
+			Expect(err).To(BeNil())
+			Expect(supply.InsufficientSpace(filesystems)).To(Equal([]supply.Filesystem{
+				{Paths: []string{"/build"}, Free: 8000 * mib, Required: 400 * mib, FreeInodes: 30000, TotalInodes: 1000000, RequiredInodes: 40000},
+				{Paths: []string{"/tmp"}, Free: 8000 * mib, Required: 150 * mib, FreeInodes: 200, TotalInodes: 1000000, RequiredInodes: 10000},
+			}))
+			Expect(supply.LowInodes(filesystems)).To(Equal([]supply.Filesystem{
+				{Paths: []string{"/deps/0"}, Free: 8000 * mib, Required: 150 * mib, FreeInodes: 8000, TotalInodes: 1000000, RequiredInodes: 5000},
+			}))
+		})
+
+		It("ignores inodes on filesystems without an inode limit", func() {
+			stats = map[string]supply.FilesystemStat{
+				"/build":  {Device: 1, Free: 8000 * mib},
+				"/deps/0": {Device: 1, Free: 8000 * mib},
+				"/tmp":    {Device: 1, Free: 8000 * mib},
+			}
+
+			filesystems, err := supply.GroupByFilesystem(requirements, stat)
+			Expect(err).To(BeNil())
+			Expect(supply.InsufficientSpace(filesystems)).To(BeEmpty())
+			Expect(supply.LowInodes(filesystems)).To(BeEmpty())
+		})
+
+		It("returns stat errors", func() {
+			stats = map[string]supply.FilesystemStat{}
+			_, err := supply.GroupByFilesystem(requirements, stat)
+			Expect(err).To(MatchError("no such path"))
+		})
+	})
+
+	Describe("DiskFullHint", func() {
+		It("mentions inodes when the filesystem ran out of them", func() {
+			hint := supply.DiskFullHint("npm ERR! code ENOSPC\nnpm ERR! syscall open", supply.FilesystemStat{Free: 3000 * mib, FreeInodes: 12, TotalInodes: 262144})
+			Expect(hint).To(Equal("The filesystem ran out of inodes (ENOSPC with 3000 MiB free, 12 of 262144 inodes free): node_modules takes an inode per file. Increase the disk quota of the app, which raises the inode limit, or trim dependencies"))
+		})
+
+		It("mentions space when the filesystem has inodes left", func() {
+			hint := supply.DiskFullHint("error An unexpected error occurred: \"ENOSPC: no space left on device, write\".", supply.FilesystemStat{Free: 2 * mib, FreeInodes: 200000, TotalInodes: 262144})
+			Expect(hint).To(Equal("The filesystem ran out of space (ENOSPC with 2 MiB free). Increase the disk quota of the app, or set BP_TMPDIR=cache when /tmp is small"))
+		})
+
+		It("returns nothing for other failures", func() {
+			Expect(supply.DiskFullHint("npm ERR! code E404", supply.FilesystemStat{FreeInodes: 0, TotalInodes: 262144})).To(BeEmpty())
+		})
+	})
+
+	Describe("CountLockfilePackages", func() {
+		var buildDir string
+
+		BeforeEach(func() {
+			var err error
+			buildDir, err = ioutil.TempDir("", "nodejs-buildpack.build.")
+			Expect(err).To(BeNil())
+		})
+
+		AfterEach(func() {
+			Expect(os.RemoveAll(buildDir)).To(Succeed())
+		})
+
+		writeFile := func(name, contents string) {
+			Expect(ioutil.WriteFile(filepath.Join(buildDir, name), []byte(contents), 0644)).To(Succeed())
+		}
+
+		It("counts nested package-lock.json v1 dependencies", func() {
+			writeFile("package-lock.json", `{"dependencies":{"a":{"dependencies":{"b":{}}},"c":{}}}`)
+			Expect(supply.CountLockfilePackages(buildDir)).To(Equal(3))
+		})
+
+		It("counts package-lock.json v2 packages", func() {
+			writeFile("package-lock.json", `{"packages":{"":{},"node_modules/a":{},"node_modules/b":{}}}`)
+			Expect(supply.CountLockfilePackages(buildDir)).To(Equal(2))
+		})
+
+		It("counts yarn.lock entries", func() {
+			writeFile("yarn.lock", "# yarn lockfile v1\n\n\"@babel/code-frame@^7.0.0\":\n  version \"7.0.0\"\n\nleft-pad@^1.3.0, left-pad@~1.3.0:\n  version \"1.3.0\"\n")
+			Expect(supply.CountLockfilePackages(buildDir)).To(Equal(2))
+		})
+
+		It("returns 0 without a lockfile", func() {
+			Expect(supply.CountLockfilePackages(buildDir)).To(Equal(0))
+		})
+	})
+
+	Describe("Supplier", func() {
+		var (
+			err      error
+			buildDir string
+			cacheDir string
+			depsDir  string
+			supplier *supply.Supplier
+			buffer   *bytes.Buffer
+			oldEnv   map[string]string
+		)
+
+		BeforeEach(func() {
+			buildDir, err = ioutil.TempDir("", "nodejs-buildpack.build.")
+			Expect(err).To(BeNil())
+			cacheDir, err = ioutil.TempDir("", "nodejs-buildpack.cache.")
+			Expect(err).To(BeNil())
+			depsDir, err = ioutil.TempDir("", "nodejs-buildpack.deps.")
+			Expect(err).To(BeNil())
+			Expect(os.MkdirAll(filepath.Join(depsDir, "0"), 0755)).To(Succeed())
+
+			oldEnv = map[string]string{}
+			for _, key := range []string{"BP_TMPDIR", "BP_SKIP_DISK_CHECK", "TMPDIR", "npm_config_tmp"} {
+				oldEnv[key] = os.Getenv(key)
+			}
+			os.Unsetenv("BP_TMPDIR")
+			os.Unsetenv("BP_SKIP_DISK_CHECK")
+
+			buffer = new(bytes.Buffer)
+			logger := libbuildpack.NewLogger(ansicleaner.New(buffer))
+			supplier = &supply.Supplier{
+				Stager: libbuildpack.NewStager([]string{buildDir, cacheDir, depsDir, "0"}, logger, &libbuildpack.Manifest{}),
+				Log:    logger,
+			}
+		})
+
+		AfterEach(func() {
+			for key, value := range oldEnv {
+				os.Setenv(key, value)
+			}
+			Expect(os.RemoveAll(buildDir)).To(Succeed())
+			Expect(os.RemoveAll(cacheDir)).To(Succeed())
+			Expect(os.RemoveAll(depsDir)).To(Succeed())
+		})
+
+		It("moves the tmp dir onto the cache filesystem with BP_TMPDIR=cache", func() {
+			os.Setenv("BP_TMPDIR", "cache")
+			Expect(supplier.SetupTmpDir()).To(Succeed())
+			Expect(os.Getenv("TMPDIR")).To(Equal(filepath.Join(cacheDir, "tmp")))
+			Expect(os.Getenv("npm_config_tmp")).To(Equal(filepath.Join(cacheDir, "tmp")))
+			Expect(filepath.Join(cacheDir, "tmp")).To(BeADirectory())
+
+			Expect(supplier.CleanupTmpDir()).To(Succeed())
+			Expect(filepath.Join(cacheDir, "tmp")).NotTo(BeADirectory())
+		})
+
+		It("fails when the estimate does not fit", func() {
+			Expect(supply.CacheMetadata{NodeModulesSize: 1 << 60}.Save(cacheDir)).To(Succeed())
+			Expect(supplier.CheckDiskSpace()).To(MatchError(ContainSubstring("not enough disk space to install dependencies")))
+
+			os.Setenv("BP_SKIP_DISK_CHECK", "true")
+			Expect(supplier.CheckDiskSpace()).To(Succeed())
+		})
+
+		It("only warns when the estimate comes from the lockfile", func() {
+			Expect(ioutil.WriteFile(filepath.Join(buildDir, "package-lock.json"), []byte(`{"dependencies":{"a":{},"b":{},"c":{}}}`), 0644)).To(Succeed())
+			supplier.StatFilesystem = func(path string) (supply.FilesystemStat, error) {
+				return supply.FilesystemStat{Device: 1, Free: 10 * mib}, nil
+			}
+
+			Expect(supplier.CheckDiskSpace()).To(Succeed())
+			Expect(buffer.String()).To(ContainSubstring("the dependencies may not fit on disk, estimated from the 3 packages of the lockfile"))
+			Expect(buffer.String()).To(ContainSubstring(": 10 MiB free, about 181 MiB needed"))
+		})
+
+		It("fails when the lockfile needs more inodes than are free", func() {
+			Expect(supply.CacheMetadata{NodeModulesSize: 2 * mib}.Save(cacheDir)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(buildDir, "package-lock.json"), []byte(`{"dependencies":{"a":{},"b":{},"c":{}}}`), 0644)).To(Succeed())
+			supplier.StatFilesystem = func(path string) (supply.FilesystemStat, error) {
+				if path == buildDir {
+					return supply.FilesystemStat{Device: 1, Free: 8000 * mib, FreeInodes: 100, TotalInodes: 262144}, nil
+				}
+				return supply.FilesystemStat{Device: 2, Free: 8000 * mib, FreeInodes: 200000, TotalInodes: 262144}, nil
+			}
+
+			Expect(supplier.CheckDiskSpace()).To(MatchError(ContainSubstring(buildDir + ": 8000 MiB free, about 3 MiB needed; 100 inodes free, about 180 needed")))
+		})
+
+		It("warns when the free inodes are close to the estimate", func() {
+			Expect(ioutil.WriteFile(filepath.Join(buildDir, "package-lock.json"), []byte(`{"dependencies":{"a":{},"b":{},"c":{}}}`), 0644)).To(Succeed())
+			supplier.StatFilesystem = func(path string) (supply.FilesystemStat, error) {
+				if path == buildDir {
+					return supply.FilesystemStat{Device: 1, Free: 8000 * mib, FreeInodes: 300, TotalInodes: 262144}, nil
+				}
+				return supply.FilesystemStat{Device: 2, Free: 8000 * mib, FreeInodes: 200000, TotalInodes: 262144}, nil
+			}
+
+			Expect(supplier.CheckDiskSpace()).To(Succeed())
+			Expect(buffer.String()).To(ContainSubstring(buildDir + ": 300 inodes free, about 180 needed for the 3 packages of the lockfile"))
+		})
+
+		It("records the node_modules size for the next build", func() {
+			Expect(os.MkdirAll(filepath.Join(depsDir, "0", "node_modules", "a"), 0755)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(depsDir, "0", "node_modules", "a", "index.js"), make([]byte, 1000), 0644)).To(Succeed())
+
+			Expect(supplier.RecordNodeModulesSize()).To(Succeed())
+			metadata, err := supply.LoadCacheMetadata(cacheDir)
+			Expect(err).To(BeNil())
+			Expect(metadata.NodeModulesSize).To(Equal(uint64(1000)))
+		})
+	})
+})
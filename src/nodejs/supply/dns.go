@@ -0,0 +1,80 @@
+package supply
+
+import (
+	"fmt"
+	"net"
+	"nodejs/network"
+	"os"
+	"strings"
+
+	"github.com/Masterminds/semver"
+)
+
+// dnsResultOrders are the values of node's --dns-result-order and the node
+// versions which accept them.
+var dnsResultOrders = map[string]struct{ constraint, versions string }{
+	"ipv4first": {">=16.4.0 || >=14.18.0, <15.0.0", "14.18 or 16.4 and later"},
+	"verbatim":  {">=16.4.0 || >=14.18.0, <15.0.0", "14.18 or 16.4 and later"},
+	"ipv6first": {">=22.1.0 || >=20.13.0, <21.0.0", "20.13 or 22.1 and later"},
+}
+
+// SetupDNSResultOrder passes --dns-result-order to npm and yarn through
+// NODE_OPTIONS while staging. The order is BP_DNS_RESULT_ORDER, or verbatim
+// when the container is IPv6-only, since node before 17 sorts IPv4
+// addresses first and fails to reach registries it can't connect to over
+// IPv4. A --dns-result-order the user set in NODE_OPTIONS is kept.
+func (s *Supplier) SetupDNSResultOrder() error {
+	order, source := os.Getenv("BP_DNS_RESULT_ORDER"), "BP_DNS_RESULT_ORDER"
+	if order == "" {
+		addrs, err := net.InterfaceAddrs()
+		if err != nil || !network.IPv6Only(addrs) {
+			return nil
+		}
+		if defaultVerbatim, err := nodeVersionMatches(s.ExactNodeVersion, ">=17.0.0"); err != nil || defaultVerbatim {
+			return err
+		}
+		order, source = "verbatim", "the IPv6-only network"
+	}
+
+	supports, found := dnsResultOrders[order]
+	if !found {
+		return fmt.Errorf("BP_DNS_RESULT_ORDER=%s is not one of ipv4first, ipv6first or verbatim", order)
+	}
+
+	nodeOptions := os.Getenv("NODE_OPTIONS")
+	if strings.Contains(nodeOptions, "--dns-result-order") {
+		s.Log.Info("Keeping the --dns-result-order of NODE_OPTIONS over %s", source)
+		return nil
+	}
+
+	supported, err := nodeVersionMatches(s.ExactNodeVersion, supports.constraint)
+	if err != nil {
+		return err
+	}
+	if !supported {
+		if source == "BP_DNS_RESULT_ORDER" {
+			return fmt.Errorf("BP_DNS_RESULT_ORDER=%s needs node %s, not %s", order, supports.versions, s.ExactNodeVersion)
+		}
+		s.Log.Warning("This container only has IPv6 addresses, but node %s sorts IPv4 addresses first and can't be told otherwise: npm and yarn may fail to reach the registry.\nUse node 14.18 or later to fix this.", s.ExactNodeVersion)
+		return nil
+	}
+
+	flag := "--dns-result-order=" + order
+	if nodeOptions != "" {
+		flag = nodeOptions + " " + flag
+	}
+	s.Log.Info("Resolving host names for npm and yarn with --dns-result-order=%s, from %s", order, source)
+	return os.Setenv("NODE_OPTIONS", flag)
+}
+
+func nodeVersionMatches(version, constraint string) (bool, error) {
+	v, err := semver.NewVersion(version)
+	if err != nil {
+		return false, err
+	}
+	c, err := semver.NewConstraint(constraint)
+	if err != nil {
+		return false, err
+	}
+	return c.Check(v), nil
+}
@@ -0,0 +1,89 @@
+package supply_test
+
+import (
+	"bytes"
+	"nodejs/supply"
+	"os"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/cloudfoundry/libbuildpack/ansicleaner"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("SetupDNSResultOrder", func() {
+	var (
+		supplier *supply.Supplier
+		buffer   *bytes.Buffer
+		oldEnv   map[string]string
+	)
+
+	BeforeEach(func() {
+		oldEnv = map[string]string{}
+		for _, key := range []string{"BP_DNS_RESULT_ORDER", "NODE_OPTIONS"} {
+			oldEnv[key] = os.Getenv(key)
+			os.Unsetenv(key)
+		}
+
+		buffer = new(bytes.Buffer)
+		logger := libbuildpack.NewLogger(ansicleaner.New(buffer))
+		supplier = &supply.Supplier{
+			Stager:           libbuildpack.NewStager([]string{"", "", "", "0"}, logger, &libbuildpack.Manifest{}),
+			Log:              logger,
+			ExactNodeVersion: "20.13.1",
+		}
+	})
+
+	AfterEach(func() {
+		for key, value := range oldEnv {
+			os.Setenv(key, value)
+		}
+	})
+
+	It("passes BP_DNS_RESULT_ORDER to npm and yarn", func() {
+		os.Setenv("BP_DNS_RESULT_ORDER", "ipv6first")
+
+		Expect(supplier.SetupDNSResultOrder()).To(Succeed())
+		Expect(os.Getenv("NODE_OPTIONS")).To(Equal("--dns-result-order=ipv6first"))
+		Expect(buffer.String()).To(ContainSubstring("Resolving host names for npm and yarn with --dns-result-order=ipv6first, from BP_DNS_RESULT_ORDER"))
+	})
+
+	It("keeps the other NODE_OPTIONS", func() {
+		os.Setenv("BP_DNS_RESULT_ORDER", "verbatim")
+		os.Setenv("NODE_OPTIONS", "--max-old-space-size=2048")
+
+		Expect(supplier.SetupDNSResultOrder()).To(Succeed())
+		Expect(os.Getenv("NODE_OPTIONS")).To(Equal("--max-old-space-size=2048 --dns-result-order=verbatim"))
+	})
+
+	It("keeps a --dns-result-order set in NODE_OPTIONS", func() {
+		os.Setenv("BP_DNS_RESULT_ORDER", "ipv6first")
+		os.Setenv("NODE_OPTIONS", "--dns-result-order=ipv4first")
+
+		Expect(supplier.SetupDNSResultOrder()).To(Succeed())
+		Expect(os.Getenv("NODE_OPTIONS")).To(Equal("--dns-result-order=ipv4first"))
+		Expect(buffer.String()).To(ContainSubstring("Keeping the --dns-result-order of NODE_OPTIONS over BP_DNS_RESULT_ORDER"))
+	})
+
+	It("fails for a node which doesn't support the order", func() {
+		os.Setenv("BP_DNS_RESULT_ORDER", "ipv6first")
+		supplier.ExactNodeVersion = "18.20.4"
+
+		Expect(supplier.SetupDNSResultOrder()).To(MatchError("BP_DNS_RESULT_ORDER=ipv6first needs node 20.13 or 22.1 and later, not 18.20.4"))
+		Expect(os.Getenv("NODE_OPTIONS")).To(Equal(""))
+	})
+
+	It("accepts verbatim on node 14.18", func() {
+		os.Setenv("BP_DNS_RESULT_ORDER", "verbatim")
+		supplier.ExactNodeVersion = "14.21.3"
+
+		Expect(supplier.SetupDNSResultOrder()).To(Succeed())
+		Expect(os.Getenv("NODE_OPTIONS")).To(Equal("--dns-result-order=verbatim"))
+	})
+
+	It("fails for an unknown order", func() {
+		os.Setenv("BP_DNS_RESULT_ORDER", "ipv6")
+
+		Expect(supplier.SetupDNSResultOrder()).To(MatchError("BP_DNS_RESULT_ORDER=ipv6 is not one of ipv4first, ipv6first or verbatim"))
+	})
+})
@@ -0,0 +1,46 @@
+package supply
+
+import (
+	"fmt"
+	"nodejs/dotenv"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// LoadDotenv reads the dotenv file named by BP_LOAD_DOTENV for the build
+// scripts. Variables already in the environment take precedence, and nothing
+// is exported at runtime.
+func (s *Supplier) LoadDotenv() error {
+	name := os.Getenv("BP_LOAD_DOTENV")
+	if name == "" {
+		return nil
+	}
+
+	path := filepath.Join(s.Stager.BuildDir(), name)
+	if rel, err := filepath.Rel(s.Stager.BuildDir(), path); err != nil || strings.HasPrefix(rel, "..") {
+		return fmt.Errorf("BP_LOAD_DOTENV must name a file inside the app: %s", name)
+	}
+
+	vars, err := dotenv.Load(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			s.Log.Warning("BP_LOAD_DOTENV is set but %s does not exist", name)
+			return nil
+		}
+		return fmt.Errorf("unable to parse %s: %s", name, err)
+	}
+
+	s.BuildScriptEnv = nil
+	skipped := 0
+	for _, v := range vars {
+		if _, found := os.LookupEnv(v.Key); found {
+			skipped++
+			continue
+		}
+		s.BuildScriptEnv = append(s.BuildScriptEnv, v.Key+"="+v.Value)
+	}
+
+	s.Log.Info("Loaded %d variables from %s for build scripts (%d already set in the environment)", len(s.BuildScriptEnv), name, skipped)
+	return nil
+}
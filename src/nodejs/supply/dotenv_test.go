@@ -0,0 +1,91 @@
+package supply_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"nodejs/supply"
+	"os"
+	"path/filepath"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/cloudfoundry/libbuildpack/ansicleaner"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("LoadDotenv", func() {
+	var (
+		err      error
+		buildDir string
+		supplier *supply.Supplier
+		buffer   *bytes.Buffer
+		oldEnv   map[string]string
+	)
+
+	BeforeEach(func() {
+		buildDir, err = ioutil.TempDir("", "nodejs-buildpack.build.")
+		Expect(err).To(BeNil())
+
+		oldEnv = map[string]string{}
+		for _, key := range []string{"BP_LOAD_DOTENV", "DOTENV_TEST_API_URL", "DOTENV_TEST_SECRET"} {
+			oldEnv[key] = os.Getenv(key)
+			os.Unsetenv(key)
+		}
+		os.Setenv("BP_LOAD_DOTENV", ".env.production")
+
+		Expect(ioutil.WriteFile(filepath.Join(buildDir, ".env.production"), []byte("DOTENV_TEST_API_URL=https://api.example.com\nexport DOTENV_TEST_SECRET=\"s3cr3t\"\n"), 0644)).To(Succeed())
+
+		buffer = new(bytes.Buffer)
+		logger := libbuildpack.NewLogger(ansicleaner.New(buffer))
+		supplier = &supply.Supplier{
+			Stager: libbuildpack.NewStager([]string{buildDir, "", "", "0"}, logger, &libbuildpack.Manifest{}),
+			Log:    logger,
+		}
+	})
+
+	AfterEach(func() {
+		for key, value := range oldEnv {
+			if value == "" {
+				os.Unsetenv(key)
+			} else {
+				os.Setenv(key, value)
+			}
+		}
+		Expect(os.RemoveAll(buildDir)).To(Succeed())
+	})
+
+	It("loads the variables for build scripts without printing values", func() {
+		Expect(supplier.LoadDotenv()).To(Succeed())
+		Expect(supplier.BuildScriptEnv).To(Equal([]string{"DOTENV_TEST_API_URL=https://api.example.com", "DOTENV_TEST_SECRET=s3cr3t"}))
+		Expect(buffer.String()).To(ContainSubstring("Loaded 2 variables from .env.production for build scripts (0 already set in the environment)"))
+		Expect(buffer.String()).NotTo(ContainSubstring("s3cr3t"))
+	})
+
+	It("does not touch the environment of the buildpack", func() {
+		Expect(supplier.LoadDotenv()).To(Succeed())
+		Expect(os.Getenv("DOTENV_TEST_API_URL")).To(Equal(""))
+	})
+
+	It("lets existing environment variables take precedence", func() {
+		os.Setenv("DOTENV_TEST_SECRET", "from-env")
+		Expect(supplier.LoadDotenv()).To(Succeed())
+		Expect(supplier.BuildScriptEnv).To(Equal([]string{"DOTENV_TEST_API_URL=https://api.example.com"}))
+		Expect(buffer.String()).To(ContainSubstring("(1 already set in the environment)"))
+	})
+
+	It("warns when the file is missing", func() {
+		os.Setenv("BP_LOAD_DOTENV", ".env.missing")
+		Expect(supplier.LoadDotenv()).To(Succeed())
+		Expect(buffer.String()).To(ContainSubstring("BP_LOAD_DOTENV is set but .env.missing does not exist"))
+	})
+
+	It("rejects files outside the app", func() {
+		os.Setenv("BP_LOAD_DOTENV", "../../etc/passwd")
+		Expect(supplier.LoadDotenv()).To(MatchError(ContainSubstring("must name a file inside the app")))
+	})
+
+	It("reports parse errors", func() {
+		Expect(ioutil.WriteFile(filepath.Join(buildDir, ".env.production"), []byte("BROKEN\n"), 0644)).To(Succeed())
+		Expect(supplier.LoadDotenv()).To(MatchError("unable to parse .env.production: line 1: expected = after BROKEN"))
+	})
+})
@@ -0,0 +1,24 @@
+package supply
+
+import (
+	"path/filepath"
+
+	"github.com/cloudfoundry/libbuildpack"
+)
+
+// Entrypoints are the scripts an app without package.json is started with,
+// in order. bin/detect claims such an app when it has one of them.
+var Entrypoints = []string{"server.js", "index.js", "app.js"}
+
+// Entrypoint returns the first of Entrypoints in buildDir, or "" when it has
+// none.
+func Entrypoint(buildDir string) (string, error) {
+	for _, entrypoint := range Entrypoints {
+		if found, err := libbuildpack.FileExists(filepath.Join(buildDir, entrypoint)); err != nil {
+			return "", err
+		} else if found {
+			return entrypoint, nil
+		}
+	}
+	return "", nil
+}
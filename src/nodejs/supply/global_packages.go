@@ -0,0 +1,173 @@
+package supply
+
+import (
+	"fmt"
+	"io/ioutil"
+	"nodejs/cache"
+	"nodejs/failure"
+	"nodejs/npm"
+	"nodejs/profiled"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/cloudfoundry/libbuildpack"
+)
+
+const (
+	// globalPackagesEntry is the cache entry of the global prefix.
+	globalPackagesEntry = "npm-global"
+	// globalPackagesKeyFile records, in the global prefix, the packages and
+	// node version it was installed for.
+	globalPackagesKeyFile = ".bp-global-packages"
+)
+
+// GlobalPackage is a package of BP_NPM_GLOBAL_PACKAGES, like @angular/cli@17.
+type GlobalPackage struct {
+	Name    string
+	Version string
+}
+
+// Spec is the package as npm install takes it.
+func (p GlobalPackage) Spec() string {
+	if p.Version == "" {
+		return p.Name
+	}
+	return p.Name + "@" + p.Version
+}
+
+// Pinned is whether the package names a version or range, rather than
+// whatever is newest at each staging.
+func (p GlobalPackage) Pinned() bool {
+	switch p.Version {
+	case "", "latest", "*", "x":
+		return false
+	}
+	return true
+}
+
+// ParseGlobalPackages parses the comma-separated packages of
+// BP_NPM_GLOBAL_PACKAGES, each a name with an optional @version.
+func ParseGlobalPackages(value string) ([]GlobalPackage, error) {
+	var packages []GlobalPackage
+	for _, item := range splitList(value) {
+		p := GlobalPackage{Name: item}
+		if at := strings.LastIndex(item, "@"); at > 0 {
+			p = GlobalPackage{Name: item[:at], Version: item[at+1:]}
+		}
+		if p.Name == "" || strings.HasSuffix(item, "@") || (strings.HasPrefix(p.Name, "@") && !strings.Contains(p.Name, "/")) {
+			return nil, fmt.Errorf("BP_NPM_GLOBAL_PACKAGES lists %s, which is not a package like firebase-tools or @angular/cli@17", item)
+		}
+		packages = append(packages, p)
+	}
+	return packages, nil
+}
+
+// GlobalPackagesKey is what the cached global prefix is keyed on: the
+// packages, in any order, and the node version their native code, if any,
+// was built for.
+func GlobalPackagesKey(packages []GlobalPackage, nodeVersion string) string {
+	var specs []string
+	for _, p := range packages {
+		specs = append(specs, p.Spec())
+	}
+	sort.Strings(specs)
+	return "node " + nodeVersion + "\n" + strings.Join(specs, "\n") + "\n"
+}
+
+func (s *Supplier) globalPackagesDir() string {
+	return filepath.Join(s.Stager.DepDir(), "global")
+}
+
+// InstallGlobalPackages installs the packages of BP_NPM_GLOBAL_PACKAGES, CLIs
+// the build scripts shell out to, into <depDir>/global and puts its bin dir
+// on the PATH of staging, so of the build scripts. Only with
+// BP_NPM_GLOBAL_RUNTIME=true is it on the PATH of the app too. The prefix is
+// restored from the cache while the packages and node version are the same.
+func (s *Supplier) InstallGlobalPackages() error {
+	packages, err := ParseGlobalPackages(os.Getenv("BP_NPM_GLOBAL_PACKAGES"))
+	if err != nil || len(packages) == 0 {
+		return err
+	}
+
+	var specs []string
+	for _, p := range packages {
+		specs = append(specs, p.Spec())
+		if !p.Pinned() {
+			s.Log.Warning("BP_NPM_GLOBAL_PACKAGES lists %s without a version, so it changes with every release and stays at the version cached first, pin it like %s@1", p.Spec(), p.Name)
+		}
+	}
+
+	prefix := s.globalPackagesDir()
+	key := GlobalPackagesKey(packages, s.ExactNodeVersion)
+	restored, err := s.restoreGlobalPackages(key)
+	if err != nil {
+		return err
+	}
+	if restored {
+		s.Log.Info("Restored global packages %s from the cache", strings.Join(specs, ", "))
+	} else {
+		s.Log.Info("Installing global packages %s", strings.Join(specs, ", "))
+		if err := os.RemoveAll(prefix); err != nil {
+			return err
+		}
+		for _, invocation := range (npm.Builder{}).GlobalInstall(npm.InstallRequest(s.Stager.BuildDir(), s.Stager.CacheDir()), prefix, specs) {
+			if err := s.Command.Execute(invocation.Dir, s.Log.Output(), s.Log.Output(), invocation.Program, invocation.Args...); err != nil {
+				return failure.Wrap(failure.InstallFailed, fmt.Errorf("unable to install BP_NPM_GLOBAL_PACKAGES: %s", err))
+			}
+		}
+		if err := ioutil.WriteFile(filepath.Join(prefix, globalPackagesKeyFile), []byte(key), 0644); err != nil {
+			return err
+		}
+		if s.Stager.CacheDir() != "" {
+			if err := cache.New(s.Stager.CacheDir()).Write(globalPackagesEntry, func(dir string) error {
+				return libbuildpack.CopyDirectory(prefix, dir)
+			}); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := os.Setenv("PATH", fmt.Sprintf("%s:%s", os.Getenv("PATH"), filepath.Join(prefix, "bin"))); err != nil {
+		return err
+	}
+	if os.Getenv("BP_NPM_GLOBAL_RUNTIME") != "true" {
+		return nil
+	}
+	return profiled.Write(s.Stager, "global_packages.sh", fmt.Sprintf("export PATH=\"$PATH:%s\"\n", filepath.Join("$DEPS_DIR", s.Stager.DepsIdx(), "global", "bin")))
+}
+
+// restoreGlobalPackages copies the cached global prefix into the dep dir when
+// it was installed for key.
+func (s *Supplier) restoreGlobalPackages(key string) (bool, error) {
+	if s.Stager.CacheDir() == "" {
+		return false, nil
+	}
+	entry, found, err := cache.New(s.Stager.CacheDir()).Restore(globalPackagesEntry)
+	if err != nil || !found {
+		return false, err
+	}
+	if cached, err := ioutil.ReadFile(filepath.Join(entry, globalPackagesKeyFile)); err != nil || string(cached) != key {
+		return false, nil
+	}
+
+	prefix := s.globalPackagesDir()
+	if err := os.RemoveAll(prefix); err != nil {
+		return false, err
+	}
+	if err := os.MkdirAll(prefix, 0755); err != nil {
+		return false, err
+	}
+	return true, libbuildpack.CopyDirectory(entry, prefix)
+}
+
+// CleanupGlobalPackages removes the global prefix once the build scripts have
+// run, so that it doesn't weigh on the droplet, unless the app uses it at
+// runtime.
+func (s *Supplier) CleanupGlobalPackages() error {
+	if os.Getenv("BP_NPM_GLOBAL_RUNTIME") == "true" {
+		return nil
+	}
+	return os.RemoveAll(s.globalPackagesDir())
+}
@@ -0,0 +1,173 @@
+package supply_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"nodejs/supply"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/cloudfoundry/libbuildpack/ansicleaner"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+// fakeGlobalNpm logs its arguments and installs a binary into the --prefix.
+const fakeGlobalNpm = `#!/bin/sh
+echo "$@" >> "$(dirname "$0")/npm.log"
+while [ $# -gt 0 ]; do
+  if [ "$1" = "--prefix" ]; then prefix="$2"; fi
+  shift
+done
+mkdir -p "$prefix/bin" && touch "$prefix/bin/ng"
+`
+
+var _ = Describe("GlobalPackages", func() {
+	DescribeTable("ParseGlobalPackages",
+		func(value string, expected []supply.GlobalPackage) {
+			packages, err := supply.ParseGlobalPackages(value)
+			Expect(err).To(BeNil())
+			Expect(packages).To(Equal(expected))
+		},
+		Entry("nothing", "", nil),
+		Entry("unpinned", "firebase-tools", []supply.GlobalPackage{{Name: "firebase-tools"}}),
+		Entry("pinned and scoped", " @angular/cli@17 , firebase-tools@^13.1.0", []supply.GlobalPackage{{Name: "@angular/cli", Version: "17"}, {Name: "firebase-tools", Version: "^13.1.0"}}),
+		Entry("scoped and unpinned", "@angular/cli", []supply.GlobalPackage{{Name: "@angular/cli"}}),
+	)
+
+	DescribeTable("rejects what is not a package",
+		func(value string) {
+			_, err := supply.ParseGlobalPackages(value)
+			Expect(err).To(MatchError(ContainSubstring("which is not a package like firebase-tools or @angular/cli@17")))
+		},
+		Entry("an empty version", "firebase-tools@"),
+		Entry("a scope alone", "@angular"),
+		Entry("a scope with a version", "@angular@17"),
+	)
+
+	It("pins versions and ranges only", func() {
+		Expect(supply.GlobalPackage{Name: "ng", Version: "17"}.Pinned()).To(BeTrue())
+		Expect(supply.GlobalPackage{Name: "ng", Version: "~17.1"}.Pinned()).To(BeTrue())
+		Expect(supply.GlobalPackage{Name: "ng"}.Pinned()).To(BeFalse())
+		Expect(supply.GlobalPackage{Name: "ng", Version: "latest"}.Pinned()).To(BeFalse())
+	})
+
+	It("keys the cache on the packages in any order and the node version", func() {
+		a := []supply.GlobalPackage{{Name: "@angular/cli", Version: "17"}, {Name: "firebase-tools"}}
+		b := []supply.GlobalPackage{{Name: "firebase-tools"}, {Name: "@angular/cli", Version: "17"}}
+		Expect(supply.GlobalPackagesKey(a, "20.11.1")).To(Equal("node 20.11.1\n@angular/cli@17\nfirebase-tools\n"))
+		Expect(supply.GlobalPackagesKey(b, "20.11.1")).To(Equal(supply.GlobalPackagesKey(a, "20.11.1")))
+		Expect(supply.GlobalPackagesKey(a, "18.20.4")).NotTo(Equal(supply.GlobalPackagesKey(a, "20.11.1")))
+		Expect(supply.GlobalPackagesKey(a[:1], "20.11.1")).NotTo(Equal(supply.GlobalPackagesKey(a, "20.11.1")))
+	})
+
+	Describe("InstallGlobalPackages", func() {
+		var (
+			err      error
+			buildDir string
+			cacheDir string
+			depsDir  string
+			binDir   string
+			supplier *supply.Supplier
+			buffer   *bytes.Buffer
+			oldEnv   map[string]string
+		)
+
+		npmLog := func() []string {
+			contents, _ := ioutil.ReadFile(filepath.Join(binDir, "npm.log"))
+			return strings.Split(strings.TrimSpace(string(contents)), "\n")
+		}
+
+		BeforeEach(func() {
+			oldEnv = map[string]string{}
+			for _, key := range []string{"BP_NPM_GLOBAL_PACKAGES", "BP_NPM_GLOBAL_RUNTIME", "PATH"} {
+				oldEnv[key] = os.Getenv(key)
+			}
+			os.Unsetenv("BP_NPM_GLOBAL_RUNTIME")
+			os.Setenv("BP_NPM_GLOBAL_PACKAGES", "@angular/cli@17,firebase-tools")
+
+			buildDir, err = ioutil.TempDir("", "nodejs-buildpack.build.")
+			Expect(err).To(BeNil())
+			cacheDir, err = ioutil.TempDir("", "nodejs-buildpack.cache.")
+			Expect(err).To(BeNil())
+			depsDir, err = ioutil.TempDir("", "nodejs-buildpack.deps.")
+			Expect(err).To(BeNil())
+			Expect(os.MkdirAll(filepath.Join(depsDir, "0"), 0755)).To(Succeed())
+			binDir, err = ioutil.TempDir("", "nodejs-buildpack.bin.")
+			Expect(err).To(BeNil())
+
+			Expect(ioutil.WriteFile(filepath.Join(binDir, "npm"), []byte(fakeGlobalNpm), 0755)).To(Succeed())
+			os.Setenv("PATH", binDir+":"+oldEnv["PATH"])
+
+			buffer = new(bytes.Buffer)
+			logger := libbuildpack.NewLogger(ansicleaner.New(buffer))
+			supplier = &supply.Supplier{
+				Stager:           libbuildpack.NewStager([]string{buildDir, cacheDir, depsDir, "0"}, logger, &libbuildpack.Manifest{}),
+				Log:              logger,
+				Command:          &libbuildpack.Command{},
+				ExactNodeVersion: "20.11.1",
+			}
+		})
+
+		AfterEach(func() {
+			for key, value := range oldEnv {
+				os.Setenv(key, value)
+			}
+			for _, dir := range []string{buildDir, cacheDir, depsDir, binDir} {
+				Expect(os.RemoveAll(dir)).To(Succeed())
+			}
+		})
+
+		It("does nothing without BP_NPM_GLOBAL_PACKAGES", func() {
+			os.Unsetenv("BP_NPM_GLOBAL_PACKAGES")
+
+			Expect(supplier.InstallGlobalPackages()).To(Succeed())
+			Expect(filepath.Join(binDir, "npm.log")).NotTo(BeAnExistingFile())
+			Expect(os.Getenv("PATH")).To(Equal(binDir + ":" + oldEnv["PATH"]))
+		})
+
+		It("installs into the dep dir and puts it on the PATH of staging only", func() {
+			Expect(supplier.InstallGlobalPackages()).To(Succeed())
+
+			prefix := filepath.Join(depsDir, "0", "global")
+			Expect(npmLog()).To(Equal([]string{
+				"install --global --prefix " + prefix + " --userconfig " + filepath.Join(buildDir, ".npmrc") + " --cache " + filepath.Join(cacheDir, ".npm") + " @angular/cli@17 firebase-tools",
+			}))
+			Expect(filepath.Join(prefix, "bin", "ng")).To(BeAnExistingFile())
+			Expect(os.Getenv("PATH")).To(HaveSuffix(":" + filepath.Join(prefix, "bin")))
+			Expect(buffer.String()).To(ContainSubstring("BP_NPM_GLOBAL_PACKAGES lists firebase-tools without a version"))
+			Expect(buffer.String()).NotTo(ContainSubstring("lists @angular/cli@17 without"))
+
+			Expect(filepath.Join(depsDir, "0", "profile.d", "000_nodejs_buildpack_global_packages.sh")).NotTo(BeAnExistingFile())
+			Expect(supplier.CleanupGlobalPackages()).To(Succeed())
+			Expect(prefix).NotTo(BeADirectory())
+		})
+
+		It("keeps the packages on the PATH of the app with BP_NPM_GLOBAL_RUNTIME=true", func() {
+			os.Setenv("BP_NPM_GLOBAL_RUNTIME", "true")
+
+			Expect(supplier.InstallGlobalPackages()).To(Succeed())
+			Expect(ioutil.ReadFile(filepath.Join(depsDir, "0", "profile.d", "000_nodejs_buildpack_global_packages.sh"))).To(Equal([]byte("export PATH=\"$PATH:$DEPS_DIR/0/global/bin\"\n")))
+			Expect(supplier.CleanupGlobalPackages()).To(Succeed())
+			Expect(filepath.Join(depsDir, "0", "global", "bin", "ng")).To(BeAnExistingFile())
+		})
+
+		It("restores the packages from the cache while the list is the same", func() {
+			Expect(supplier.InstallGlobalPackages()).To(Succeed())
+			Expect(supplier.CleanupGlobalPackages()).To(Succeed())
+
+			os.Setenv("BP_NPM_GLOBAL_PACKAGES", "firebase-tools, @angular/cli@17")
+			Expect(supplier.InstallGlobalPackages()).To(Succeed())
+			Expect(npmLog()).To(HaveLen(1))
+			Expect(filepath.Join(depsDir, "0", "global", "bin", "ng")).To(BeAnExistingFile())
+			Expect(buffer.String()).To(ContainSubstring("Restored global packages firebase-tools, @angular/cli@17 from the cache"))
+
+			os.Setenv("BP_NPM_GLOBAL_PACKAGES", "@angular/cli@18,firebase-tools")
+			Expect(supplier.InstallGlobalPackages()).To(Succeed())
+			Expect(npmLog()).To(HaveLen(2))
+		})
+	})
+})
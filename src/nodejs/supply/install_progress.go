@@ -0,0 +1,24 @@
+package supply
+
+import (
+	"nodejs/heartbeat"
+	"nodejs/progress"
+	"os"
+	"path/filepath"
+)
+
+// reportInstallProgress logs how many of the packages of the lockfile are
+// installed while the install runs, with BP_INSTALL_PROGRESS=true, until
+// the returned stop is called. Installs without a lockfile report nothing,
+// as do those which don't write node_modules, like Plug'n'Play.
+func (s *Supplier) reportInstallProgress() (func(), error) {
+	if os.Getenv("BP_INSTALL_PROGRESS") != "true" {
+		return func() {}, nil
+	}
+	expected, err := CountLockfilePackages(s.Stager.BuildDir())
+	if err != nil || expected == 0 {
+		return func() {}, err
+	}
+	reporter := progress.New(filepath.Join(s.Stager.BuildDir(), "node_modules"), expected)
+	return heartbeat.Report(reporter.Line), nil
+}
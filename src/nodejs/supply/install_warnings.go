@@ -0,0 +1,247 @@
+package supply
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/cloudfoundry/libbuildpack"
+)
+
+// InstallWarningsFile is written to the dep dir with the warnings of the
+// install, for tooling to pick up.
+const InstallWarningsFile = "warnings.json"
+
+// The kinds of InstallWarning.
+const (
+	DeprecatedWarning = "deprecated"
+	PeerWarning       = "peer"
+	EngineWarning     = "engine"
+)
+
+// InstallWarning is a warning npm or yarn printed about a package during the
+// install.
+type InstallWarning struct {
+	Kind    string `json:"kind"`
+	Package string `json:"package"`
+	Message string `json:"message"`
+}
+
+const warningPackage = `((?:@[^@\s/"]+/)?[^@\s/"]+@[^\s:"]+)`
+
+var (
+	// npm 6, 8: npm WARN deprecated request@2.88.2: request has been deprecated, see ...
+	// npm 10: npm warn deprecated glob@7.2.3: Glob versions prior to v9 are no longer supported
+	npmDeprecated = regexp.MustCompile(`^npm (?:WARN|warn) deprecated ` + warningPackage + `: (.+)$`)
+	// npm 6: npm WARN react-dom@16.14.0 requires a peer of react@^16.14.0 but none is installed. ...
+	npmMissingPeer = regexp.MustCompile(`^npm WARN ` + warningPackage + ` requires a peer of (@?[^@\s]+)@(\S+) but none is installed`)
+	// npm 6: npm WARN notsup Unsupported engine for vite@5.0.10: wanted: {"node":"^18.0.0"} (current: {"node":"16.20.2","npm":"6.14.18"})
+	npmNotsup = regexp.MustCompile(`^npm WARN notsup Unsupported engine for ` + warningPackage + `: wanted: (\{.*\}) \(current: (\{.*\})\)`)
+	// npm 8, 10: npm WARN EBADENGINE Unsupported engine {, followed by the
+	// package, required and current lines and a closing brace.
+	npmEbadengine      = regexp.MustCompile(`^npm (?:WARN|warn) EBADENGINE (.*)$`)
+	ebadengineField    = regexp.MustCompile(`^\s*(package|required|current): (.*?),?$`)
+	ebadenginePackage  = regexp.MustCompile(`^'(.*)'$`)
+	ebadengineVersions = regexp.MustCompile(`(\w+): '([^']*)'`)
+	// npm 8, 10: npm WARN peer react@"17.0.2" from react-test-renderer@17.0.2, in
+	// the ERESOLVE overriding peer dependency block after Could not resolve
+	// dependency:, with the installed version in Found: react@18.2.0.
+	npmWarnLine     = regexp.MustCompile(`^npm (?:WARN|warn) ?(.*)$`)
+	npmOverriddenTo = regexp.MustCompile(`^(?:peer|peerOptional) (@?[^@\s"]+)@"([^"]*)" from (\S+)$`)
+	// yarn: warning vite@5.0.10: The engine "node" is incompatible with this module. Expected version "^18.0.0 || >=20.0.0". Got "16.20.2"
+	yarnEngine = regexp.MustCompile(`^warning ` + warningPackage + `: The engine "([^"]+)" is incompatible with this module\. Expected version "([^"]*)"\. Got "([^"]*)"`)
+	// yarn: warning request@2.88.2: request has been deprecated, see ...
+	// yarn: warning jest > jsdom > request@2.88.2: request has been deprecated, see ...
+	yarnDeprecated = regexp.MustCompile(`^warning (?:.+ > )?` + warningPackage + `: (.+)$`)
+	// yarn: warning " > react-dom@16.14.0" has unmet peer dependency "react@^16.14.0".
+	// yarn: warning "a > react-test-renderer@17.0.2" has incorrect peer dependency "react@17.0.2".
+	yarnPeer = regexp.MustCompile(`^warning "(?:.* > )?` + warningPackage + `" has (unmet|incorrect) peer dependency "(@?[^@\s"]+)@([^"]*)"`)
+	// yarn 2+: ➤ YN0002: │ shop@workspace:. doesn't provide @babel/core (p9c8d7), requested by babel-loader
+	berryMissingPeer = regexp.MustCompile(`YN0002: .*?(\S+) doesn't provide (\S+) \(p[0-9a-f]+\), requested by (\S+)`)
+	// yarn 2+: ➤ YN0060: │ react is listed by your project with version 18.2.0, which doesn't satisfy what react-dom (p4c5d6) requests (^17.0.0).
+	berryIncompatiblePeer = regexp.MustCompile(`YN0060: .*?(\S+) is listed by your project with version (\S+), which doesn't satisfy what (\S+) \(p[0-9a-f]+\) requests \((.*)\)\.?$`)
+)
+
+// ParseInstallWarnings returns the deprecated packages, unmet peer
+// dependencies and unsupported engines npm 6 to 10, yarn 1 and yarn 2+
+// reported in the install output, without duplicates, sorted by kind and
+// package.
+func ParseInstallWarnings(output string) []InstallWarning {
+	var (
+		warnings []InstallWarning
+		engine   map[string]string
+		section  string
+		found    string
+	)
+
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimRight(line, " \r")
+
+		if m := npmEbadengine.FindStringSubmatch(line); m != nil {
+			switch field := ebadengineField.FindStringSubmatch(m[1]); {
+			case strings.HasPrefix(m[1], "Unsupported engine"):
+				engine = map[string]string{}
+			case m[1] == "}" && engine != nil:
+				if pkg := ebadenginePackage.FindStringSubmatch(engine["package"]); pkg != nil {
+					warnings = append(warnings, InstallWarning{
+						Kind:    EngineWarning,
+						Package: pkg[1],
+						Message: engineMessage(jsVersions(engine["required"]), jsVersions(engine["current"])),
+					})
+				}
+				engine = nil
+			case field != nil && engine != nil:
+				engine[field[1]] = field[2]
+			}
+			continue
+		}
+
+		if m := npmWarnLine.FindStringSubmatch(line); m != nil {
+			switch {
+			case strings.HasPrefix(m[1], "Found: "):
+				found = strings.TrimPrefix(m[1], "Found: ")
+			case m[1] == "Could not resolve dependency:":
+				section = "dependency"
+				continue
+			case section == "dependency":
+				if peer := npmOverriddenTo.FindStringSubmatch(m[1]); peer != nil {
+					warnings = append(warnings, InstallWarning{Kind: PeerWarning, Package: peer[3], Message: peerConflictMessage(peer[1], peer[2], found)})
+				}
+			}
+			section = ""
+		}
+
+		if m := npmDeprecated.FindStringSubmatch(line); m != nil {
+			warnings = append(warnings, InstallWarning{Kind: DeprecatedWarning, Package: m[1], Message: m[2]})
+		} else if m := npmMissingPeer.FindStringSubmatch(line); m != nil {
+			warnings = append(warnings, InstallWarning{Kind: PeerWarning, Package: m[1], Message: fmt.Sprintf("peer %s@%s is not installed", m[2], m[3])})
+		} else if m := npmNotsup.FindStringSubmatch(line); m != nil {
+			warnings = append(warnings, InstallWarning{Kind: EngineWarning, Package: m[1], Message: engineMessage(jsonVersions(m[2]), jsonVersions(m[3]))})
+		} else if m := yarnEngine.FindStringSubmatch(line); m != nil {
+			warnings = append(warnings, InstallWarning{Kind: EngineWarning, Package: m[1], Message: engineMessage(map[string]string{m[2]: m[3]}, map[string]string{m[2]: m[4]})})
+		} else if m := yarnDeprecated.FindStringSubmatch(line); m != nil {
+			warnings = append(warnings, InstallWarning{Kind: DeprecatedWarning, Package: m[1], Message: m[2]})
+		} else if m := yarnPeer.FindStringSubmatch(line); m != nil {
+			message := fmt.Sprintf("peer %s@%s is not installed", m[3], m[4])
+			if m[2] == "incorrect" {
+				message = fmt.Sprintf("peer %s@%s does not match the installed version", m[3], m[4])
+			}
+			warnings = append(warnings, InstallWarning{Kind: PeerWarning, Package: m[1], Message: message})
+		} else if m := berryMissingPeer.FindStringSubmatch(line); m != nil {
+			warnings = append(warnings, InstallWarning{Kind: PeerWarning, Package: m[3], Message: fmt.Sprintf("peer %s is not provided by %s", m[2], m[1])})
+		} else if m := berryIncompatiblePeer.FindStringSubmatch(line); m != nil {
+			warnings = append(warnings, InstallWarning{Kind: PeerWarning, Package: m[3], Message: peerConflictMessage(m[1], m[4], m[1]+"@"+m[2])})
+		}
+	}
+
+	return uniqueInstallWarnings(warnings)
+}
+
+func peerConflictMessage(name, versionRange, installed string) string {
+	if installed == "" {
+		return fmt.Sprintf("peer %s@%s is not satisfied", name, versionRange)
+	}
+	return fmt.Sprintf("peer %s@%s conflicts with %s", name, versionRange, installed)
+}
+
+// engineMessage renders the engines a package requires against the current
+// versions, like: node ^18.0.0 || >=20.0.0 (current 16.20.2).
+func engineMessage(required, current map[string]string) string {
+	var engines []string
+	for engine := range required {
+		engines = append(engines, engine)
+	}
+	sort.Strings(engines)
+
+	var parts []string
+	for _, engine := range engines {
+		part := engine + " " + required[engine]
+		if version := strings.TrimPrefix(current[engine], "v"); version != "" {
+			part += " (current " + version + ")"
+		}
+		parts = append(parts, part)
+	}
+	return "requires " + strings.Join(parts, ", ")
+}
+
+// jsVersions reads the engine versions npm 8 and later print as a JavaScript
+// object: { node: '^18.0.0', npm: '>=8' }.
+func jsVersions(object string) map[string]string {
+	versions := map[string]string{}
+	for _, m := range ebadengineVersions.FindAllStringSubmatch(object, -1) {
+		versions[m[1]] = m[2]
+	}
+	return versions
+}
+
+// jsonVersions reads the engine versions npm 6 prints as JSON.
+func jsonVersions(object string) map[string]string {
+	versions := map[string]string{}
+	json.Unmarshal([]byte(object), &versions)
+	return versions
+}
+
+func uniqueInstallWarnings(warnings []InstallWarning) []InstallWarning {
+	seen := map[InstallWarning]bool{}
+	var unique []InstallWarning
+	for _, warning := range warnings {
+		if !seen[warning] {
+			seen[warning] = true
+			unique = append(unique, warning)
+		}
+	}
+	order := map[string]int{DeprecatedWarning: 0, PeerWarning: 1, EngineWarning: 2}
+	sort.SliceStable(unique, func(i, j int) bool {
+		if unique[i].Kind != unique[j].Kind {
+			return order[unique[i].Kind] < order[unique[j].Kind]
+		}
+		if unique[i].Package != unique[j].Package {
+			return unique[i].Package < unique[j].Package
+		}
+		return unique[i].Message < unique[j].Message
+	})
+	return unique
+}
+
+// SummarizeInstallWarnings prints the warnings of the install grouped by
+// kind, rather than scattered through its output, and writes them to
+// warnings.json in the dep dir.
+func (s *Supplier) SummarizeInstallWarnings() error {
+	output, err := ioutil.ReadFile(s.Logfile.Name())
+	if err != nil {
+		return err
+	}
+	warnings := ParseInstallWarnings(string(output))
+	if len(warnings) == 0 {
+		return nil
+	}
+
+	groups := []struct {
+		kind  string
+		title string
+	}{
+		{DeprecatedWarning, "Deprecated packages"},
+		{PeerWarning, "Peer dependencies"},
+		{EngineWarning, "Unsupported engines"},
+	}
+	lines := []string{fmt.Sprintf("The install reported %d warnings:", len(warnings))}
+	for _, group := range groups {
+		var entries []string
+		for _, warning := range warnings {
+			if warning.Kind == group.kind {
+				entries = append(entries, fmt.Sprintf("  %s: %s", warning.Package, warning.Message))
+			}
+		}
+		if len(entries) > 0 {
+			lines = append(lines, fmt.Sprintf("%s (%d):", group.title, len(entries)))
+			lines = append(lines, entries...)
+		}
+	}
+	s.Log.Warning("%s", strings.Join(lines, "\n"))
+
+	return libbuildpack.NewJSON().Write(filepath.Join(s.Stager.DepDir(), InstallWarningsFile), warnings)
+}
@@ -0,0 +1,153 @@
+package supply_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"nodejs/supply"
+	"os"
+	"path/filepath"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/cloudfoundry/libbuildpack/ansicleaner"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Install warnings", func() {
+	readFixture := func(name string) string {
+		contents, err := ioutil.ReadFile(filepath.Join("testdata", "warnings", name))
+		Expect(err).To(BeNil())
+		return string(contents)
+	}
+
+	deprecated := func(pkg, message string) supply.InstallWarning {
+		return supply.InstallWarning{Kind: supply.DeprecatedWarning, Package: pkg, Message: message}
+	}
+	peer := func(pkg, message string) supply.InstallWarning {
+		return supply.InstallWarning{Kind: supply.PeerWarning, Package: pkg, Message: message}
+	}
+	engine := func(pkg, message string) supply.InstallWarning {
+		return supply.InstallWarning{Kind: supply.EngineWarning, Package: pkg, Message: message}
+	}
+
+	const requestDeprecated = "request has been deprecated, see https://github.com/request/request/issues/3142"
+
+	DescribeTable("ParseInstallWarnings",
+		func(fixture string, expected []supply.InstallWarning) {
+			Expect(supply.ParseInstallWarnings(readFixture(fixture))).To(Equal(expected))
+		},
+		Entry("npm 6", "npm6.log", []supply.InstallWarning{
+			deprecated("har-validator@5.1.5", "this library is no longer supported"),
+			deprecated("request@2.88.2", requestDeprecated),
+			peer("react-dom@16.14.0", "peer react@^16.14.0 is not installed"),
+			engine("vite@5.0.10", "requires node ^18.0.0 || >=20.0.0 (current 16.20.2)"),
+		}),
+		Entry("npm 8", "npm8.log", []supply.InstallWarning{
+			deprecated("request@2.88.2", requestDeprecated),
+			deprecated("stable@0.1.8", "Modern JS already guarantees Array#sort() is a stable sort, so this library is deprecated. See the compatibility table on MDN: https://developer.mozilla.org/en-US/docs/Web/JavaScript/Reference/Global_Objects/Array/sort#browser_compatibility"),
+			peer("react-test-renderer@17.0.2", "peer react@17.0.2 conflicts with react@18.2.0"),
+			engine("vite@5.0.10", "requires node ^18.0.0 || >=20.0.0 (current 16.20.2)"),
+		}),
+		Entry("npm 10", "npm10.log", []supply.InstallWarning{
+			deprecated("glob@7.2.3", "Glob versions prior to v9 are no longer supported"),
+			deprecated("inflight@1.0.6", "This module is not supported, and leaks memory. Do not use it. Check out lru-cache if you want a good and tested way to coalesce async requests by a key value, which is much more comprehensive and powerful."),
+			peer("@testing-library/react-hooks@7.0.2", "peer @types/react@^16.9.0 || ^17.0.0 conflicts with @types/react@18.2.45"),
+			engine("@typescript-eslint/parser@8.0.0", "requires node ^18.18.0 || ^20.9.0 || >=21.1.0 (current 18.17.1)"),
+		}),
+		Entry("yarn", "yarn.log", []supply.InstallWarning{
+			deprecated("@babel/plugin-proposal-class-properties@7.18.6", "This proposal has been merged to the ECMAScript standard and thus this plugin is no longer maintained. Please use @babel/plugin-transform-class-properties instead."),
+			deprecated("request@2.88.2", requestDeprecated),
+			peer("react-dom@16.14.0", "peer react@^16.14.0 is not installed"),
+			peer("react-test-renderer@17.0.2", "peer react@17.0.2 does not match the installed version"),
+			engine("vite@5.0.10", "requires node ^18.0.0 || >=20.0.0 (current 16.20.2)"),
+		}),
+		Entry("yarn 2+", "berry.log", []supply.InstallWarning{
+			peer("@storybook/react", "peer react-dom@^16.8.0 || ^17.0.0 conflicts with react-dom@18.2.0"),
+			peer("babel-loader", "peer @babel/core is not provided by shop@workspace:."),
+			peer("react-test-renderer", "peer react@17.0.2 conflicts with react@18.2.0"),
+		}),
+	)
+
+	It("returns nothing for clean installs", func() {
+		Expect(supply.ParseInstallWarnings("added 12 packages in 1.2s\n")).To(BeEmpty())
+	})
+
+	It("dedupes a warning reported for several dependents", func() {
+		output := "warning request@2.88.2: deprecated\n" +
+			"warning jest > jsdom > request@2.88.2: deprecated\n" +
+			"warning gulp > request@2.88.2: deprecated\n" +
+			"warning request@2.87.0: deprecated\n"
+		Expect(supply.ParseInstallWarnings(output)).To(Equal([]supply.InstallWarning{
+			deprecated("request@2.87.0", "deprecated"),
+			deprecated("request@2.88.2", "deprecated"),
+		}))
+	})
+
+	Describe("SummarizeInstallWarnings", func() {
+		var (
+			depsDir  string
+			logfile  *os.File
+			supplier *supply.Supplier
+			buffer   *bytes.Buffer
+		)
+
+		BeforeEach(func() {
+			var err error
+			depsDir, err = ioutil.TempDir("", "nodejs-buildpack.deps.")
+			Expect(err).To(BeNil())
+			Expect(os.MkdirAll(filepath.Join(depsDir, "0"), 0755)).To(Succeed())
+			logfile, err = ioutil.TempFile("", "nodejs-buildpack.logfile")
+			Expect(err).To(BeNil())
+
+			buffer = new(bytes.Buffer)
+			logger := libbuildpack.NewLogger(ansicleaner.New(buffer))
+			supplier = &supply.Supplier{
+				Stager:  libbuildpack.NewStager([]string{"", "", depsDir, "0"}, logger, &libbuildpack.Manifest{}),
+				Log:     logger,
+				Logfile: logfile,
+			}
+		})
+
+		AfterEach(func() {
+			logfile.Close()
+			Expect(os.Remove(logfile.Name())).To(Succeed())
+			Expect(os.RemoveAll(depsDir)).To(Succeed())
+		})
+
+		It("prints the warnings grouped by kind", func() {
+			Expect(ioutil.WriteFile(logfile.Name(), []byte(readFixture("yarn.log")), 0644)).To(Succeed())
+
+			Expect(supplier.SummarizeInstallWarnings()).To(Succeed())
+			Expect(buffer.String()).To(ContainSubstring(`**WARNING** The install reported 5 warnings:
+       Deprecated packages (2):
+         @babel/plugin-proposal-class-properties@7.18.6: This proposal has been merged`))
+			Expect(buffer.String()).To(ContainSubstring(`
+         request@2.88.2: request has been deprecated, see https://github.com/request/request/issues/3142
+       Peer dependencies (2):
+         react-dom@16.14.0: peer react@^16.14.0 is not installed
+         react-test-renderer@17.0.2: peer react@17.0.2 does not match the installed version
+       Unsupported engines (1):
+         vite@5.0.10: requires node ^18.0.0 || >=20.0.0 (current 16.20.2)
+`))
+		})
+
+		It("writes warnings.json to the dep dir", func() {
+			Expect(ioutil.WriteFile(logfile.Name(), []byte(readFixture("npm6.log")), 0644)).To(Succeed())
+
+			Expect(supplier.SummarizeInstallWarnings()).To(Succeed())
+			var warnings []supply.InstallWarning
+			Expect(libbuildpack.NewJSON().Load(filepath.Join(depsDir, "0", "warnings.json"), &warnings)).To(Succeed())
+			Expect(warnings).To(HaveLen(4))
+			Expect(warnings[3]).To(Equal(engine("vite@5.0.10", "requires node ^18.0.0 || >=20.0.0 (current 16.20.2)")))
+		})
+
+		It("prints and writes nothing without warnings", func() {
+			Expect(ioutil.WriteFile(logfile.Name(), []byte("added 12 packages in 1.2s\n"), 0644)).To(Succeed())
+
+			Expect(supplier.SummarizeInstallWarnings()).To(Succeed())
+			Expect(buffer.String()).To(BeEmpty())
+			Expect(filepath.Join(depsDir, "0", "warnings.json")).NotTo(BeAnExistingFile())
+		})
+	})
+})
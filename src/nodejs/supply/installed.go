@@ -0,0 +1,48 @@
+package supply
+
+import (
+	"bytes"
+	"io/ioutil"
+	"nodejs/installed"
+	"path/filepath"
+	"strings"
+)
+
+// InstalledDependenciesFile lists the top level dependencies in the dep dir,
+// so the deployed versions are known without running npm in the container.
+const InstalledDependenciesFile = "installed-dependencies.txt"
+
+// ListLimit is the number of dependencies up to which the list is printed
+// in the staging log as well.
+const ListLimit = 50
+
+// WriteInstalledDependencies writes the top level dependencies with their
+// installed versions to InstalledDependenciesFile.
+func (s *Supplier) WriteInstalledDependencies() error {
+	deps, layout, err := installed.List(s.Stager.BuildDir())
+	if err != nil {
+		return err
+	}
+	if layout == "" {
+		return nil
+	}
+
+	buffer := new(bytes.Buffer)
+	if err := installed.Write(buffer, deps, layout); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(s.Stager.DepDir(), InstalledDependenciesFile), buffer.Bytes(), 0644); err != nil {
+		return err
+	}
+
+	if len(deps) == 0 {
+		s.Log.Info("Installed dependencies: none, the app has no production dependencies")
+		return nil
+	}
+	if len(deps) >= ListLimit {
+		s.Log.Info("Listed %d top level dependencies in %s", len(deps), InstalledDependenciesFile)
+		return nil
+	}
+	s.Log.Info("Installed dependencies:\n%s", strings.TrimRight(buffer.String(), "\n"))
+	return nil
+}
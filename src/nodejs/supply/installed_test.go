@@ -0,0 +1,101 @@
+package supply_test
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"nodejs/supply"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/cloudfoundry/libbuildpack/ansicleaner"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("WriteInstalledDependencies", func() {
+	var (
+		err      error
+		buildDir string
+		depsDir  string
+		supplier *supply.Supplier
+		buffer   *bytes.Buffer
+	)
+
+	writeFile := func(path, contents string) {
+		Expect(os.MkdirAll(filepath.Dir(path), 0755)).To(Succeed())
+		Expect(ioutil.WriteFile(path, []byte(contents), 0644)).To(Succeed())
+	}
+
+	writeApp := func(count int) {
+		var deps []string
+		for i := 0; i < count; i++ {
+			name := fmt.Sprintf("pkg%02d", i)
+			deps = append(deps, fmt.Sprintf(`"%s": "^1.0.0"`, name))
+			writeFile(filepath.Join(buildDir, "node_modules", name, "package.json"), fmt.Sprintf(`{"name":"%s","version":"1.0.%d"}`, name, i))
+		}
+		writeFile(filepath.Join(buildDir, "package.json"), `{"dependencies":{`+strings.Join(deps, ",")+`}}`)
+	}
+
+	BeforeEach(func() {
+		buildDir, err = ioutil.TempDir("", "nodejs-buildpack.build.")
+		Expect(err).To(BeNil())
+		depsDir, err = ioutil.TempDir("", "nodejs-buildpack.deps.")
+		Expect(err).To(BeNil())
+		Expect(os.MkdirAll(filepath.Join(depsDir, "0"), 0755)).To(Succeed())
+
+		buffer = new(bytes.Buffer)
+		logger := libbuildpack.NewLogger(ansicleaner.New(buffer))
+		supplier = &supply.Supplier{
+			Stager: libbuildpack.NewStager([]string{buildDir, "", depsDir, "0"}, logger, &libbuildpack.Manifest{}),
+			Log:    logger,
+		}
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(buildDir)).To(Succeed())
+		Expect(os.RemoveAll(depsDir)).To(Succeed())
+	})
+
+	It("writes and prints a short list", func() {
+		writeApp(2)
+		Expect(supplier.WriteInstalledDependencies()).To(Succeed())
+
+		contents, err := ioutil.ReadFile(filepath.Join(depsDir, "0", "installed-dependencies.txt"))
+		Expect(err).To(BeNil())
+		Expect(string(contents)).To(ContainSubstring("pkg01  1.0.1    ^1.0.0     prod"))
+		Expect(buffer.String()).To(ContainSubstring("Installed dependencies:"))
+		Expect(buffer.String()).To(ContainSubstring("pkg00  1.0.0    ^1.0.0     prod"))
+	})
+
+	It("only writes a long list", func() {
+		writeApp(supply.ListLimit)
+		Expect(supplier.WriteInstalledDependencies()).To(Succeed())
+
+		contents, err := ioutil.ReadFile(filepath.Join(depsDir, "0", "installed-dependencies.txt"))
+		Expect(err).To(BeNil())
+		Expect(string(contents)).To(ContainSubstring("pkg49"))
+		Expect(buffer.String()).To(ContainSubstring("Listed 50 top level dependencies in installed-dependencies.txt"))
+		Expect(buffer.String()).NotTo(ContainSubstring("pkg49"))
+	})
+
+	It("says so when the app has no production dependencies", func() {
+		writeFile(filepath.Join(buildDir, "package.json"), `{"devDependencies":{"vite":"^5.0.10"}}`)
+		Expect(os.MkdirAll(filepath.Join(buildDir, "node_modules"), 0755)).To(Succeed())
+		Expect(supplier.WriteInstalledDependencies()).To(Succeed())
+
+		contents, err := ioutil.ReadFile(filepath.Join(depsDir, "0", "installed-dependencies.txt"))
+		Expect(err).To(BeNil())
+		Expect(string(contents)).To(ContainSubstring("# 0 top level dependencies"))
+		Expect(buffer.String()).To(Equal("       Installed dependencies: none, the app has no production dependencies\n"))
+	})
+
+	It("writes nothing without a package.json", func() {
+		Expect(supplier.WriteInstalledDependencies()).To(Succeed())
+
+		_, err := os.Stat(filepath.Join(depsDir, "0", "installed-dependencies.txt"))
+		Expect(os.IsNotExist(err)).To(BeTrue())
+	})
+})
@@ -0,0 +1,93 @@
+package supply
+
+import (
+	"fmt"
+	"nodejs/profiled"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/cloudfoundry/libbuildpack"
+)
+
+// LegacyNodePaths are the paths in the app dir which older buildpacks, and
+// the Heroku buildpack apps were ported from, installed node and yarn into,
+// and the dirs of the dep dir which replace them.
+var LegacyNodePaths = []struct{ Path, Target string }{
+	{".heroku/node", "node"},
+	{".heroku/yarn", "yarn"},
+	{"vendor/node", "node"},
+}
+
+// legacyPathPatterns match the hardcoded paths to node which break when the
+// layout of the dep dir changes, and what to use instead.
+var legacyPathPatterns = []struct {
+	pattern *regexp.Regexp
+	why     string
+	instead string
+}{
+	{regexp.MustCompile(`\.heroku/node\b`), "only older buildpacks install node into", "$NODE_HOME"},
+	{regexp.MustCompile(`\.heroku/yarn\b`), "only older buildpacks install yarn into", "yarn from the PATH"},
+	{regexp.MustCompile(`(^|[\s"'=:/])vendor/node\b`), "only older buildpacks install node into", "$NODE_HOME"},
+	{regexp.MustCompile(`deps/\d+/node-v\d+\.\d+\.\d+`), "changes with every node update", "$NODE_HOME"},
+}
+
+// ScanLegacyNodePaths returns a warning for each hardcoded path to node in
+// command, the command of source, like the web process of the Procfile.
+func ScanLegacyNodePaths(source, command string) []string {
+	var warnings []string
+	for _, p := range legacyPathPatterns {
+		if match := p.pattern.FindString(command); match != "" {
+			match = strings.TrimLeft(match, " \t\"'=:/")
+			warnings = append(warnings, fmt.Sprintf("%s hardcodes %s, which %s, use %s instead", source, match, p.why, p.instead))
+		}
+	}
+	return warnings
+}
+
+// LinkLegacyNodePaths links the LegacyNodePaths to the node and yarn of the
+// dep dir with BP_LEGACY_NODE_PATHS=true, for the build scripts now and, by
+// a profile.d script, for the app at runtime, where the dep dir is elsewhere.
+// A path the app has a dir or file of its own at is left alone.
+func (s *Supplier) LinkLegacyNodePaths() error {
+	if os.Getenv("BP_LEGACY_NODE_PATHS") != "true" {
+		return nil
+	}
+
+	var linked, lines []string
+	for _, legacy := range LegacyNodePaths {
+		target := filepath.Join(s.Stager.DepDir(), legacy.Target)
+		if found, err := libbuildpack.FileExists(target); err != nil {
+			return err
+		} else if !found {
+			continue
+		}
+
+		link := filepath.Join(s.Stager.BuildDir(), legacy.Path)
+		if info, err := os.Lstat(link); err == nil && info.Mode()&os.ModeSymlink == 0 {
+			s.Log.Warning("Not linking %s to the installed %s, the app has a %s of its own", legacy.Path, legacy.Target, legacy.Path)
+			continue
+		} else if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(link), 0755); err != nil {
+			return err
+		}
+		if err := os.Remove(link); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if err := os.Symlink(target, link); err != nil {
+			return err
+		}
+
+		linked = append(linked, legacy.Path)
+		lines = append(lines, fmt.Sprintf(`mkdir -p "$HOME/%s" && ln -sfn "%s" "$HOME/%s"`, filepath.Dir(legacy.Path), filepath.Join("$DEPS_DIR", s.Stager.DepsIdx(), legacy.Target), legacy.Path))
+	}
+	if len(linked) == 0 {
+		return nil
+	}
+
+	s.Log.Info("Linked %s to the installed node and yarn (BP_LEGACY_NODE_PATHS)", strings.Join(linked, ", "))
+	return profiled.Write(s.Stager, "legacy_paths.sh", strings.Join(lines, "\n")+"\n")
+}
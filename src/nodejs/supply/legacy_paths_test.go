@@ -0,0 +1,113 @@
+package supply_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"nodejs/supply"
+	"os"
+	"path/filepath"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/cloudfoundry/libbuildpack/ansicleaner"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("LegacyNodePaths", func() {
+	DescribeTable("ScanLegacyNodePaths",
+		func(command string, expected []string) {
+			Expect(supply.ScanLegacyNodePaths("The web process", command)).To(Equal(expected))
+		},
+		Entry("node from the PATH", "node server.js", nil),
+		Entry("NODE_HOME", "$NODE_HOME/bin/node server.js", nil),
+		Entry("node_modules in .heroku or vendor", "node vendor/node_modules/x.js .heroku/node_modules", nil),
+		Entry("the node of the Heroku buildpack", "/app/.heroku/node/bin/node server.js", []string{
+			"The web process hardcodes .heroku/node, which only older buildpacks install node into, use $NODE_HOME instead",
+		}),
+		Entry("the yarn of the Heroku buildpack", "$HOME/.heroku/yarn/bin/yarn start", []string{
+			"The web process hardcodes .heroku/yarn, which only older buildpacks install yarn into, use yarn from the PATH instead",
+		}),
+		Entry("vendor/node", "vendor/node/bin/node server.js", []string{
+			"The web process hardcodes vendor/node, which only older buildpacks install node into, use $NODE_HOME instead",
+		}),
+		Entry("vendor/node in a path", `"$HOME/vendor/node/bin/node" server.js`, []string{
+			"The web process hardcodes vendor/node, which only older buildpacks install node into, use $NODE_HOME instead",
+		}),
+		Entry("a versioned node dir", "/home/vcap/deps/0/node-v20.11.1/bin/node server.js", []string{
+			"The web process hardcodes deps/0/node-v20.11.1, which changes with every node update, use $NODE_HOME instead",
+		}),
+	)
+
+	Describe("LinkLegacyNodePaths", func() {
+		var (
+			err       error
+			buildDir  string
+			depsDir   string
+			supplier  *supply.Supplier
+			buffer    *bytes.Buffer
+			oldLegacy string
+		)
+
+		profileScript := func() string {
+			return filepath.Join(depsDir, "0", "profile.d", "000_nodejs_buildpack_legacy_paths.sh")
+		}
+
+		BeforeEach(func() {
+			oldLegacy = os.Getenv("BP_LEGACY_NODE_PATHS")
+			os.Setenv("BP_LEGACY_NODE_PATHS", "true")
+
+			buildDir, err = ioutil.TempDir("", "nodejs-buildpack.build.")
+			Expect(err).To(BeNil())
+			depsDir, err = ioutil.TempDir("", "nodejs-buildpack.deps.")
+			Expect(err).To(BeNil())
+			Expect(os.MkdirAll(filepath.Join(depsDir, "0", "node", "bin"), 0755)).To(Succeed())
+
+			buffer = new(bytes.Buffer)
+			logger := libbuildpack.NewLogger(ansicleaner.New(buffer))
+			supplier = &supply.Supplier{
+				Stager: libbuildpack.NewStager([]string{buildDir, "", depsDir, "0"}, logger, &libbuildpack.Manifest{}),
+				Log:    logger,
+			}
+		})
+
+		AfterEach(func() {
+			os.Setenv("BP_LEGACY_NODE_PATHS", oldLegacy)
+			Expect(os.RemoveAll(buildDir)).To(Succeed())
+			Expect(os.RemoveAll(depsDir)).To(Succeed())
+		})
+
+		It("links nothing without BP_LEGACY_NODE_PATHS", func() {
+			os.Unsetenv("BP_LEGACY_NODE_PATHS")
+
+			Expect(supplier.LinkLegacyNodePaths()).To(Succeed())
+			Expect(filepath.Join(buildDir, ".heroku")).NotTo(BeAnExistingFile())
+			Expect(profileScript()).NotTo(BeAnExistingFile())
+		})
+
+		It("links the paths of what is installed, now and at runtime", func() {
+			Expect(supplier.LinkLegacyNodePaths()).To(Succeed())
+
+			Expect(os.Readlink(filepath.Join(buildDir, ".heroku", "node"))).To(Equal(filepath.Join(depsDir, "0", "node")))
+			Expect(os.Readlink(filepath.Join(buildDir, "vendor", "node"))).To(Equal(filepath.Join(depsDir, "0", "node")))
+			Expect(filepath.Join(buildDir, ".heroku", "yarn")).NotTo(BeAnExistingFile())
+			Expect(ioutil.ReadFile(profileScript())).To(Equal([]byte(
+				`mkdir -p "$HOME/.heroku" && ln -sfn "$DEPS_DIR/0/node" "$HOME/.heroku/node"` + "\n" +
+					`mkdir -p "$HOME/vendor" && ln -sfn "$DEPS_DIR/0/node" "$HOME/vendor/node"` + "\n")))
+			Expect(buffer.String()).To(ContainSubstring("Linked .heroku/node, vendor/node to the installed node and yarn (BP_LEGACY_NODE_PATHS)"))
+
+			// Staging again replaces the links.
+			Expect(supplier.LinkLegacyNodePaths()).To(Succeed())
+			Expect(os.Readlink(filepath.Join(buildDir, ".heroku", "node"))).To(Equal(filepath.Join(depsDir, "0", "node")))
+		})
+
+		It("leaves a dir the app has at a legacy path alone", func() {
+			Expect(os.MkdirAll(filepath.Join(buildDir, "vendor", "node", "lib"), 0755)).To(Succeed())
+
+			Expect(supplier.LinkLegacyNodePaths()).To(Succeed())
+			Expect(filepath.Join(buildDir, "vendor", "node", "lib")).To(BeADirectory())
+			Expect(buffer.String()).To(ContainSubstring("Not linking vendor/node to the installed node, the app has a vendor/node of its own"))
+			Expect(ioutil.ReadFile(profileScript())).NotTo(ContainSubstring("vendor"))
+		})
+	})
+})
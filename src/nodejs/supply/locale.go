@@ -0,0 +1,56 @@
+package supply
+
+import (
+	"fmt"
+	"nodejs/locale"
+	"nodejs/profiled"
+	"os"
+	"strings"
+)
+
+// SetupLocale exports LANG and LC_ALL to the build scripts, and at runtime
+// in profile.d, as the locale of BP_LOCALE or else en_US.UTF-8, leaving
+// those the app sets alone. A locale the stack does not have falls back to
+// C.UTF-8.
+func (s *Supplier) SetupLocale() error {
+	var unset []string
+	for _, name := range []string{"LANG", "LC_ALL"} {
+		if os.Getenv(name) == "" {
+			unset = append(unset, name)
+		}
+	}
+	if len(unset) == 0 {
+		return nil
+	}
+
+	requested, source := os.Getenv("BP_LOCALE"), "BP_LOCALE"
+	if requested == "" {
+		requested, source = locale.Default, "the default"
+	}
+	lister := s.Locales
+	if lister == nil {
+		lister = locale.CommandLister{Command: s.Command}
+	}
+	name, err := locale.Select(lister, requested)
+	if err != nil {
+		s.Log.Warning("Unable to list the locales of the stack, leaving %s unset: %s", strings.Join(unset, " and "), err)
+		return nil
+	}
+	if name == "" {
+		s.Log.Warning("The stack has neither the locale %s (%s) nor %s, leaving %s unset", requested, source, locale.Fallback, strings.Join(unset, " and "))
+		return nil
+	}
+	if name != requested {
+		s.Log.Warning("The stack has no locale %s (%s), using %s", requested, source, name)
+	}
+
+	var script []string
+	for _, variable := range unset {
+		if err := os.Setenv(variable, name); err != nil {
+			return err
+		}
+		script = append(script, fmt.Sprintf("export %[1]s=${%[1]s:-%[2]s}", variable, name))
+	}
+	s.Log.Debug("Using the locale %s for %s", name, strings.Join(unset, " and "))
+	return profiled.Write(s.Stager, "locale.sh", strings.Join(script, "\n")+"\n")
+}
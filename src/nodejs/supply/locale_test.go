@@ -0,0 +1,118 @@
+package supply_test
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"nodejs/supply"
+	"os"
+	"path/filepath"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/cloudfoundry/libbuildpack/ansicleaner"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type fakeLocales struct {
+	locales []string
+	err     error
+}
+
+func (l fakeLocales) Locales() ([]string, error) {
+	return l.locales, l.err
+}
+
+var _ = Describe("SetupLocale", func() {
+	var (
+		depsDir  string
+		supplier *supply.Supplier
+		buffer   *bytes.Buffer
+		oldEnv   map[string]string
+	)
+
+	BeforeEach(func() {
+		oldEnv = map[string]string{}
+		for _, key := range []string{"LANG", "LC_ALL", "BP_LOCALE"} {
+			oldEnv[key] = os.Getenv(key)
+			os.Unsetenv(key)
+		}
+
+		var err error
+		depsDir, err = ioutil.TempDir("", "nodejs-buildpack.deps.")
+		Expect(err).To(BeNil())
+		Expect(os.MkdirAll(filepath.Join(depsDir, "0"), 0755)).To(Succeed())
+
+		buffer = new(bytes.Buffer)
+		logger := libbuildpack.NewLogger(ansicleaner.New(buffer))
+		supplier = &supply.Supplier{
+			Stager:  libbuildpack.NewStager([]string{"", "", depsDir, "0"}, logger, &libbuildpack.Manifest{}),
+			Log:     logger,
+			Locales: fakeLocales{locales: []string{"C", "C.UTF-8", "en_US.utf8", "POSIX"}},
+		}
+	})
+
+	AfterEach(func() {
+		for key, value := range oldEnv {
+			os.Setenv(key, value)
+		}
+		Expect(os.RemoveAll(depsDir)).To(Succeed())
+	})
+
+	profileScript := func() string {
+		return filepath.Join(depsDir, "0", "profile.d", "000_nodejs_buildpack_locale.sh")
+	}
+
+	It("uses en_US.UTF-8 for the build scripts and at runtime", func() {
+		Expect(supplier.SetupLocale()).To(Succeed())
+		Expect(os.Getenv("LANG")).To(Equal("en_US.UTF-8"))
+		Expect(os.Getenv("LC_ALL")).To(Equal("en_US.UTF-8"))
+		Expect(ioutil.ReadFile(profileScript())).To(Equal([]byte("export LANG=${LANG:-en_US.UTF-8}\nexport LC_ALL=${LC_ALL:-en_US.UTF-8}\n")))
+		Expect(buffer.String()).To(BeEmpty())
+	})
+
+	It("uses the locale of BP_LOCALE", func() {
+		os.Setenv("BP_LOCALE", "C.UTF-8")
+		Expect(supplier.SetupLocale()).To(Succeed())
+		Expect(os.Getenv("LANG")).To(Equal("C.UTF-8"))
+	})
+
+	It("leaves the variables the app sets alone", func() {
+		os.Setenv("LANG", "fr_FR.UTF-8")
+		Expect(supplier.SetupLocale()).To(Succeed())
+		Expect(os.Getenv("LANG")).To(Equal("fr_FR.UTF-8"))
+		Expect(os.Getenv("LC_ALL")).To(Equal("en_US.UTF-8"))
+		Expect(ioutil.ReadFile(profileScript())).To(Equal([]byte("export LC_ALL=${LC_ALL:-en_US.UTF-8}\n")))
+	})
+
+	It("does nothing when the app sets both", func() {
+		os.Setenv("LANG", "fr_FR.UTF-8")
+		os.Setenv("LC_ALL", "fr_FR.UTF-8")
+		supplier.Locales = fakeLocales{err: errors.New("unexpected")}
+		Expect(supplier.SetupLocale()).To(Succeed())
+		Expect(profileScript()).NotTo(BeAnExistingFile())
+		Expect(buffer.String()).To(BeEmpty())
+	})
+
+	It("falls back to C.UTF-8 with a warning", func() {
+		os.Setenv("BP_LOCALE", "de_DE.UTF-8")
+		Expect(supplier.SetupLocale()).To(Succeed())
+		Expect(os.Getenv("LANG")).To(Equal("C.UTF-8"))
+		Expect(buffer.String()).To(ContainSubstring("The stack has no locale de_DE.UTF-8 (BP_LOCALE), using C.UTF-8"))
+	})
+
+	It("leaves the locale unset when the stack has no UTF-8 locale", func() {
+		supplier.Locales = fakeLocales{locales: []string{"C", "POSIX"}}
+		Expect(supplier.SetupLocale()).To(Succeed())
+		Expect(os.Getenv("LANG")).To(BeEmpty())
+		Expect(profileScript()).NotTo(BeAnExistingFile())
+		Expect(buffer.String()).To(ContainSubstring("The stack has neither the locale en_US.UTF-8 (the default) nor C.UTF-8, leaving LANG and LC_ALL unset"))
+	})
+
+	It("warns when the locales can't be listed", func() {
+		supplier.Locales = fakeLocales{err: errors.New("exit status 127")}
+		Expect(supplier.SetupLocale()).To(Succeed())
+		Expect(os.Getenv("LANG")).To(BeEmpty())
+		Expect(buffer.String()).To(ContainSubstring("Unable to list the locales of the stack, leaving LANG and LC_ALL unset: exit status 127"))
+	})
+})
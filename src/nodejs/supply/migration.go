@@ -0,0 +1,73 @@
+package supply
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"nodejs/migration"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+func (s *Supplier) lockfileDigest() (string, error) {
+	data, err := ioutil.ReadFile(filepath.Join(s.Stager.BuildDir(), "package-lock.json"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// RecordLockfile remembers package-lock.json before the dependencies are
+// installed, so that the migration report can tell whether npm rewrote it.
+func (s *Supplier) RecordLockfile() error {
+	var err error
+	s.LockfileDigest, err = s.lockfileDigest()
+	return err
+}
+
+// WriteMigrationReport evaluates the migration rules against the app, writes
+// the results to migration-report.json in the dep dir and warns about the
+// behaviors the app still relies on.
+func (s *Supplier) WriteMigrationReport() error {
+	app, err := migration.LoadApp(s.Stager.BuildDir())
+	if err != nil {
+		return err
+	}
+
+	digest, err := s.lockfileDigest()
+	if err != nil {
+		return err
+	}
+
+	deprecations, err := s.deprecations()
+	if err != nil {
+		return err
+	}
+
+	app.UseYarn = s.UseYarn
+	app.IsVendored = s.IsVendored
+	app.LockfileChanged = s.LockfileDigest != "" && digest != s.LockfileDigest
+	app.NodeVersion = s.ExactNodeVersion
+	app.Deprecations = deprecations
+	app.Now = time.Now()
+
+	results := migration.Evaluate(app, migration.Rules)
+	if err := migration.WriteReport(filepath.Join(s.Stager.DepDir(), "migration-report.json"), results); err != nil {
+		return err
+	}
+
+	warnings := migration.Warnings(results)
+	if len(warnings) == 0 {
+		return nil
+	}
+	s.Log.BeginStep("Migration report")
+	for _, warning := range warnings {
+		s.Log.Warning("[%s] %s", warning.ID, warning.Message)
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package supply_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"nodejs/supply"
+	"os"
+	"path/filepath"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/cloudfoundry/libbuildpack/ansicleaner"
+	"golang.google.cn/x/mock/gomock"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("WriteMigrationReport", func() {
+	var (
+		err          error
+		buildDir     string
+		depsDir      string
+		bpDir        string
+		supplier     *supply.Supplier
+		buffer       *bytes.Buffer
+		mockCtrl     *gomock.Controller
+		mockManifest *MockManifest
+	)
+
+	writeFile := func(path, contents string) {
+		Expect(os.MkdirAll(filepath.Dir(path), 0755)).To(Succeed())
+		Expect(ioutil.WriteFile(path, []byte(contents), 0644)).To(Succeed())
+	}
+
+	readReport := func() []map[string]string {
+		data, err := ioutil.ReadFile(filepath.Join(depsDir, "0", "migration-report.json"))
+		Expect(err).To(BeNil())
+		var report struct {
+			Results []map[string]string `json:"results"`
+		}
+		Expect(json.Unmarshal(data, &report)).To(Succeed())
+		return report.Results
+	}
+
+	BeforeEach(func() {
+		buildDir, err = ioutil.TempDir("", "nodejs-buildpack.build.")
+		Expect(err).To(BeNil())
+		depsDir, err = ioutil.TempDir("", "nodejs-buildpack.deps.")
+		Expect(err).To(BeNil())
+		Expect(os.MkdirAll(filepath.Join(depsDir, "0"), 0755)).To(Succeed())
+		bpDir, err = ioutil.TempDir("", "nodejs-buildpack.bp.")
+		Expect(err).To(BeNil())
+
+		writeFile(filepath.Join(bpDir, "manifest.yml"), "dependency_deprecation_dates:\n- version_line: 6.x\n  name: node\n  date: 2019-04-30\n  link: https://github.com/nodejs/LTS\n")
+		writeFile(filepath.Join(buildDir, "package.json"), `{"name":"app"}`)
+		writeFile(filepath.Join(buildDir, "package-lock.json"), `{"lockfileVersion":1}`)
+
+		mockCtrl = gomock.NewController(GinkgoT())
+		mockManifest = NewMockManifest(mockCtrl)
+		mockManifest.EXPECT().RootDir().Return(bpDir).AnyTimes()
+
+		buffer = new(bytes.Buffer)
+		logger := libbuildpack.NewLogger(ansicleaner.New(buffer))
+		supplier = &supply.Supplier{
+			Stager:           libbuildpack.NewStager([]string{buildDir, "", depsDir, "0"}, logger, &libbuildpack.Manifest{}),
+			Manifest:         mockManifest,
+			Log:              logger,
+			ExactNodeVersion: "10.16.3",
+		}
+	})
+
+	AfterEach(func() {
+		mockCtrl.Finish()
+		Expect(os.RemoveAll(buildDir)).To(Succeed())
+		Expect(os.RemoveAll(depsDir)).To(Succeed())
+		Expect(os.RemoveAll(bpDir)).To(Succeed())
+	})
+
+	It("writes passing results without warning", func() {
+		Expect(supplier.RecordLockfile()).To(Succeed())
+		Expect(supplier.WriteMigrationReport()).To(Succeed())
+
+		results := readReport()
+		Expect(results).To(HaveLen(3))
+		for _, result := range results {
+			Expect(result["status"]).To(Equal("pass"))
+		}
+		Expect(buffer.String()).NotTo(ContainSubstring("Migration report"))
+	})
+
+	It("warns when npm rewrote package-lock.json", func() {
+		Expect(supplier.RecordLockfile()).To(Succeed())
+		writeFile(filepath.Join(buildDir, "package-lock.json"), `{"lockfileVersion":2}`)
+		Expect(supplier.WriteMigrationReport()).To(Succeed())
+
+		result := readReport()[0]
+		Expect(result["id"]).To(Equal("npm-lockfile-mutation"))
+		Expect(result["status"]).To(Equal("warn"))
+		Expect(buffer.String()).To(ContainSubstring("Migration report"))
+		Expect(buffer.String()).To(ContainSubstring("[npm-lockfile-mutation] npm install changed package-lock.json"))
+	})
+
+	It("groups the warnings of several rules", func() {
+		supplier.UseYarn = true
+		supplier.ExactNodeVersion = "6.17.1"
+		Expect(supplier.RecordLockfile()).To(Succeed())
+		Expect(supplier.WriteMigrationReport()).To(Succeed())
+
+		Expect(buffer.String()).To(ContainSubstring("-----> Migration report"))
+		Expect(buffer.String()).To(ContainSubstring("[yarn-classic-engine] yarn classic is used without engines.yarn"))
+		Expect(buffer.String()).To(ContainSubstring("[node-leaving-manifest] node 6.x is deprecated since 2019-04-30"))
+		Expect(buffer.String()).NotTo(ContainSubstring("[npm-lockfile-mutation]"))
+	})
+})
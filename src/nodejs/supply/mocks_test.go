@@ -8,6 +8,7 @@ import (
 	libbuildpack "github.com/cloudfoundry/libbuildpack"
 	gomock "golang.google.cn/x/mock/gomock"
 	io "io"
+	exec "os/exec"
 	reflect "reflect"
 )
 
@@ -51,6 +52,18 @@ func (mr *MockCommandMockRecorder) Execute(arg0, arg1, arg2, arg3 interface{}, a
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Execute", reflect.TypeOf((*MockCommand)(nil).Execute), varargs...)
 }
 
+// Run mocks base method
+func (m *MockCommand) Run(arg0 *exec.Cmd) error {
+	ret := m.ctrl.Call(m, "Run", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Run indicates an expected call of Run
+func (mr *MockCommandMockRecorder) Run(arg0 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Run", reflect.TypeOf((*MockCommand)(nil).Run), arg0)
+}
+
 // MockManifest is a mock of Manifest interface
 type MockManifest struct {
 	ctrl     *gomock.Controller
@@ -99,6 +112,18 @@ func (mr *MockManifestMockRecorder) DefaultVersion(arg0 interface{}) *gomock.Cal
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DefaultVersion", reflect.TypeOf((*MockManifest)(nil).DefaultVersion), arg0)
 }
 
+// RootDir mocks base method
+func (m *MockManifest) RootDir() string {
+	ret := m.ctrl.Call(m, "RootDir")
+	ret0, _ := ret[0].(string)
+	return ret0
+}
+
+// RootDir indicates an expected call of RootDir
+func (mr *MockManifestMockRecorder) RootDir() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RootDir", reflect.TypeOf((*MockManifest)(nil).RootDir))
+}
+
 // MockInstaller is a mock of Installer interface
 type MockInstaller struct {
 	ctrl     *gomock.Controller
@@ -182,15 +207,15 @@ func (mr *MockNPMMockRecorder) Build(arg0, arg1 interface{}) *gomock.Call {
 }
 
 // Rebuild mocks base method
-func (m *MockNPM) Rebuild(arg0 string) error {
-	ret := m.ctrl.Call(m, "Rebuild", arg0)
+func (m *MockNPM) Rebuild(arg0 string, arg1 []string) error {
+	ret := m.ctrl.Call(m, "Rebuild", arg0, arg1)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // Rebuild indicates an expected call of Rebuild
-func (mr *MockNPMMockRecorder) Rebuild(arg0 interface{}) *gomock.Call {
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Rebuild", reflect.TypeOf((*MockNPM)(nil).Rebuild), arg0)
+func (mr *MockNPMMockRecorder) Rebuild(arg0, arg1 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Rebuild", reflect.TypeOf((*MockNPM)(nil).Rebuild), arg0, arg1)
 }
 
 // MockYarn is a mock of Yarn interface
@@ -228,6 +253,18 @@ func (mr *MockYarnMockRecorder) Build(arg0, arg1 interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Build", reflect.TypeOf((*MockYarn)(nil).Build), arg0, arg1)
 }
 
+// BuildWorkspace mocks base method
+func (m *MockYarn) BuildWorkspace(arg0, arg1, arg2 string, arg3 bool) error {
+	ret := m.ctrl.Call(m, "BuildWorkspace", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// BuildWorkspace indicates an expected call of BuildWorkspace
+func (mr *MockYarnMockRecorder) BuildWorkspace(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "BuildWorkspace", reflect.TypeOf((*MockYarn)(nil).BuildWorkspace), arg0, arg1, arg2, arg3)
+}
+
 // MockStager is a mock of Stager interface
 type MockStager struct {
 	ctrl     *gomock.Controller
@@ -323,18 +360,6 @@ func (mr *MockStagerMockRecorder) WriteEnvFile(arg0, arg1 interface{}) *gomock.C
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "WriteEnvFile", reflect.TypeOf((*MockStager)(nil).WriteEnvFile), arg0, arg1)
 }
 
-// WriteProfileD mocks base method
-func (m *MockStager) WriteProfileD(arg0, arg1 string) error {
-	ret := m.ctrl.Call(m, "WriteProfileD", arg0, arg1)
-	ret0, _ := ret[0].(error)
-	return ret0
-}
-
-// WriteProfileD indicates an expected call of WriteProfileD
-func (mr *MockStagerMockRecorder) WriteProfileD(arg0, arg1 interface{}) *gomock.Call {
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "WriteProfileD", reflect.TypeOf((*MockStager)(nil).WriteProfileD), arg0, arg1)
-}
-
 // SetStagingEnvironment mocks base method
 func (m *MockStager) SetStagingEnvironment() error {
 	ret := m.ctrl.Call(m, "SetStagingEnvironment")
@@ -0,0 +1,148 @@
+package supply
+
+import (
+	"fmt"
+	"nodejs/native"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// CheckStackChange discards the package manager caches when the app was last
+// built on another stack, since they hold prebuilt native binaries linked
+// against that stack's libraries. The current stack is recorded for the next
+// build.
+func (s *Supplier) CheckStackChange() error {
+	stack := os.Getenv("CF_STACK")
+	if stack == "" || s.Stager.CacheDir() == "" {
+		return nil
+	}
+
+	metadata, err := LoadCacheMetadata(s.Stager.CacheDir())
+	if err != nil {
+		return err
+	}
+
+	if metadata.Stack != "" && metadata.Stack != stack {
+		s.StackChanged = true
+		s.Log.Warning("The stack changed from %s to %s since the last build, discarding the package manager cache so native modules are rebuilt", metadata.Stack, stack)
+		for _, dir := range []string{".cache/yarn", ".npm"} {
+			if err := os.RemoveAll(filepath.Join(s.Stager.CacheDir(), dir)); err != nil {
+				return err
+			}
+		}
+	}
+
+	metadata.Stack = stack
+	return metadata.Save(s.Stager.CacheDir())
+}
+
+// RebuildNativeModules rebuilds vendored node_modules of yarn apps after a
+// stack change. npm apps are always rebuilt in BuildDependencies and all
+// other apps install from scratch.
+func (s *Supplier) RebuildNativeModules() error {
+	if !s.StackChanged || !s.IsVendored || !s.UseYarn {
+		return nil
+	}
+
+	s.Log.Info("Rebuilding native modules for %s", os.Getenv("CF_STACK"))
+	return s.Command.Execute(s.Stager.BuildDir(), s.Log.Output(), s.Log.Output(), "npm", "rebuild", "--nodedir="+os.Getenv("NODE_HOME"))
+}
+
+// CheckNativeModules lists the packages with native code after a stack
+// change and warns about compiled addons which need a newer glibc than the
+// stack provides.
+func (s *Supplier) CheckNativeModules() error {
+	nodeModules := filepath.Join(s.Stager.BuildDir(), "node_modules")
+	addons, err := native.FindAddons(nodeModules)
+	if err != nil {
+		return err
+	}
+	if len(addons) == 0 {
+		return nil
+	}
+
+	stack := os.Getenv("CF_STACK")
+	if s.StackChanged {
+		s.Log.Info("Packages with native code rebuilt for %s: %s", stack, strings.Join(native.Packages(addons), ", "))
+	}
+
+	stackGlibc, found := StackGlibcVersions[stack]
+	if !found {
+		return nil
+	}
+
+	var incompatible []string
+	for _, addon := range addons {
+		required, err := native.RequiredGlibc(filepath.Join(nodeModules, addon.Path))
+		if err == native.ErrNotELF {
+			continue
+		} else if err != nil {
+			return err
+		}
+		if required != "" && native.CompareVersions(required, stackGlibc) > 0 {
+			incompatible = append(incompatible, fmt.Sprintf("  %s (node_modules/%s) requires glibc %s", addon.Package, addon.Path, required))
+		}
+	}
+
+	if len(incompatible) > 0 {
+		s.Log.Warning("Native modules need a newer glibc than %s provides (%s) and will fail to load:\n%s\nRebuild them from source on %s, for example by removing them from a vendored node_modules or the npm cache", stack, stackGlibc, strings.Join(incompatible, "\n"), stack)
+	}
+	return nil
+}
+
+// RemoveForeignPackages removes the packages of a vendored node_modules which
+// were built for another platform, so that installing fetches or builds them
+// for this one. With BP_KEEP_FOREIGN_BINARIES=true they are only reported.
+func (s *Supplier) RemoveForeignPackages() error {
+	if !s.IsVendored {
+		return nil
+	}
+
+	foreign, err := native.FindForeignPackages(filepath.Join(s.Stager.BuildDir(), "node_modules"), native.HostPlatform())
+	if err != nil {
+		return err
+	}
+	if len(foreign) == 0 {
+		return nil
+	}
+
+	var lines []string
+	for _, f := range foreign {
+		lines = append(lines, "  "+f.String())
+	}
+	report := fmt.Sprintf("node_modules holds packages built for another platform, which fail to load at runtime:\n%s", strings.Join(lines, "\n"))
+
+	if os.Getenv("BP_KEEP_FOREIGN_BINARIES") == "true" {
+		s.Log.Warning("%s\nKeeping them as BP_KEEP_FOREIGN_BINARIES is set", report)
+		return nil
+	}
+	for _, f := range foreign {
+		if err := os.RemoveAll(filepath.Join(s.Stager.BuildDir(), f.Path)); err != nil {
+			return err
+		}
+	}
+	s.Log.Warning("%s\nRemoved them so that they are installed for %s, set BP_KEEP_FOREIGN_BINARIES=true to keep them", report, native.HostPlatform())
+	return nil
+}
+
+// CheckPlatformPackages fails the build when node_modules holds the
+// platform specific binary package of another platform without the one for
+// this platform, which happens when node_modules is copied from a developer
+// machine. The app would otherwise crash at startup.
+func (s *Supplier) CheckPlatformPackages() error {
+	platform := native.HostPlatform()
+	missing, err := native.FindMissingPlatformPackages(filepath.Join(s.Stager.BuildDir(), "node_modules"), platform)
+	if err != nil {
+		return fmt.Errorf("Unable to check platform specific packages: %s", err)
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+
+	var lines []string
+	for _, m := range missing {
+		lines = append(lines, "  "+m.String())
+	}
+	return fmt.Errorf("Platform specific packages for %s are missing:\n%s\nnode_modules was probably installed on another platform, remove it from the app so dependencies are installed during staging, or add the missing packages", platform, strings.Join(lines, "\n"))
+}
@@ -0,0 +1,190 @@
+package supply_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"nodejs/native"
+	"nodejs/supply"
+	"os"
+	"path/filepath"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/cloudfoundry/libbuildpack/ansicleaner"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Native modules", func() {
+	var (
+		err      error
+		buildDir string
+		cacheDir string
+		supplier *supply.Supplier
+		buffer   *bytes.Buffer
+		oldStack string
+	)
+
+	installAddon := func(pkg, fixture string) {
+		dir := filepath.Join(buildDir, "node_modules", pkg, "build", "Release")
+		Expect(os.MkdirAll(dir, 0755)).To(Succeed())
+		Expect(libbuildpack.CopyFile(filepath.Join("..", "native", "testdata", fixture), filepath.Join(dir, "addon.node"))).To(Succeed())
+	}
+
+	BeforeEach(func() {
+		buildDir, err = ioutil.TempDir("", "nodejs-buildpack.build.")
+		Expect(err).To(BeNil())
+		cacheDir, err = ioutil.TempDir("", "nodejs-buildpack.cache.")
+		Expect(err).To(BeNil())
+
+		oldStack = os.Getenv("CF_STACK")
+		os.Setenv("CF_STACK", "cflinuxfs4")
+
+		buffer = new(bytes.Buffer)
+		logger := libbuildpack.NewLogger(ansicleaner.New(buffer))
+		supplier = &supply.Supplier{
+			Stager: libbuildpack.NewStager([]string{buildDir, cacheDir, "", "0"}, logger, &libbuildpack.Manifest{}),
+			Log:    logger,
+		}
+	})
+
+	AfterEach(func() {
+		os.Setenv("CF_STACK", oldStack)
+		Expect(os.RemoveAll(buildDir)).To(Succeed())
+		Expect(os.RemoveAll(cacheDir)).To(Succeed())
+	})
+
+	Describe("CheckStackChange", func() {
+		BeforeEach(func() {
+			Expect(os.MkdirAll(filepath.Join(cacheDir, ".npm", "_prebuilds"), 0755)).To(Succeed())
+		})
+
+		It("records the stack on the first build", func() {
+			Expect(supplier.CheckStackChange()).To(Succeed())
+			Expect(supplier.StackChanged).To(BeFalse())
+			Expect(supply.LoadCacheMetadata(cacheDir)).To(Equal(supply.CacheMetadata{Stack: "cflinuxfs4"}))
+			Expect(filepath.Join(cacheDir, ".npm")).To(BeADirectory())
+		})
+
+		It("keeps the cache on the same stack", func() {
+			Expect(supply.CacheMetadata{Stack: "cflinuxfs4"}.Save(cacheDir)).To(Succeed())
+			Expect(supplier.CheckStackChange()).To(Succeed())
+			Expect(supplier.StackChanged).To(BeFalse())
+			Expect(buffer.String()).To(Equal(""))
+			Expect(filepath.Join(cacheDir, ".npm")).To(BeADirectory())
+		})
+
+		It("discards the package manager cache after a stack change", func() {
+			Expect(supply.CacheMetadata{Stack: "cflinuxfs3", NodeModulesSize: 42}.Save(cacheDir)).To(Succeed())
+			Expect(supplier.CheckStackChange()).To(Succeed())
+			Expect(supplier.StackChanged).To(BeTrue())
+			Expect(buffer.String()).To(ContainSubstring("The stack changed from cflinuxfs3 to cflinuxfs4 since the last build"))
+			Expect(filepath.Join(cacheDir, ".npm")).NotTo(BeADirectory())
+			Expect(supply.LoadCacheMetadata(cacheDir)).To(Equal(supply.CacheMetadata{Stack: "cflinuxfs4", NodeModulesSize: 42}))
+		})
+	})
+
+	Describe("CheckNativeModules", func() {
+		It("does nothing without addons", func() {
+			Expect(supplier.CheckNativeModules()).To(Succeed())
+			Expect(buffer.String()).To(Equal(""))
+		})
+
+		It("lists the packages with native code after a stack change", func() {
+			installAddon("bcrypt", "old.node")
+			supplier.StackChanged = true
+			Expect(supplier.CheckNativeModules()).To(Succeed())
+			Expect(buffer.String()).To(ContainSubstring("Packages with native code rebuilt for cflinuxfs4: bcrypt"))
+		})
+
+		It("warns about addons which need a newer glibc than the stack", func() {
+			installAddon("bcrypt", "old.node")
+			installAddon("sharp", "new.node")
+			Expect(supplier.CheckNativeModules()).To(Succeed())
+			Expect(buffer.String()).To(ContainSubstring("Native modules need a newer glibc than cflinuxfs4 provides (2.35)"))
+			Expect(buffer.String()).To(ContainSubstring("sharp (node_modules/sharp/build/Release/addon.node) requires glibc 2.36"))
+			Expect(buffer.String()).NotTo(ContainSubstring("bcrypt"))
+		})
+
+		It("skips the glibc check on unknown stacks", func() {
+			os.Setenv("CF_STACK", "custom")
+			installAddon("sharp", "new.node")
+			Expect(supplier.CheckNativeModules()).To(Succeed())
+			Expect(buffer.String()).To(Equal(""))
+		})
+	})
+
+	Describe("CheckPlatformPackages", func() {
+		installPackage := func(name, manifest string) {
+			dir := filepath.Join(buildDir, "node_modules", name)
+			Expect(os.MkdirAll(dir, 0755)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(dir, "package.json"), []byte(manifest), 0644)).To(Succeed())
+		}
+
+		It("succeeds when the platform package of the host is installed", func() {
+			installPackage("esbuild", `{"name":"esbuild"}`)
+			installPackage("@esbuild/darwin-arm64", `{"name":"@esbuild/darwin-arm64","os":["darwin"],"cpu":["arm64"]}`)
+			installPackage("@esbuild/linux-"+native.HostPlatform().CPU, `{"name":"@esbuild/linux-`+native.HostPlatform().CPU+`"}`)
+			Expect(supplier.CheckPlatformPackages()).To(Succeed())
+		})
+
+		It("names the missing platform package", func() {
+			installPackage("@esbuild/darwin-arm64", `{"name":"@esbuild/darwin-arm64","os":["darwin"],"cpu":["arm64"]}`)
+			err := supplier.CheckPlatformPackages()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("@esbuild/darwin-arm64 (node_modules/@esbuild/darwin-arm64) is built for darwin/arm64, but @esbuild/linux-" + native.HostPlatform().CPU + " is not installed"))
+		})
+	})
+
+	Describe("RemoveForeignPackages", func() {
+		var (
+			oldKeep string
+			hadKeep bool
+		)
+
+		BeforeEach(func() {
+			oldKeep, hadKeep = os.LookupEnv("BP_KEEP_FOREIGN_BINARIES")
+			os.Unsetenv("BP_KEEP_FOREIGN_BINARIES")
+
+			supplier.IsVendored = true
+			installAddon("bcrypt", "macho.node")
+			installAddon("sharp", "new.node")
+			Expect(os.MkdirAll(filepath.Join(buildDir, "node_modules", "fsevents"), 0755)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(buildDir, "node_modules", "fsevents", "package.json"), []byte(`{"name":"fsevents","os":["darwin"]}`), 0644)).To(Succeed())
+		})
+
+		AfterEach(func() {
+			if hadKeep {
+				os.Setenv("BP_KEEP_FOREIGN_BINARIES", oldKeep)
+			} else {
+				os.Unsetenv("BP_KEEP_FOREIGN_BINARIES")
+			}
+		})
+
+		It("removes the packages built for another platform", func() {
+			Expect(supplier.RemoveForeignPackages()).To(Succeed())
+			Expect(filepath.Join(buildDir, "node_modules", "bcrypt")).NotTo(BeADirectory())
+			Expect(filepath.Join(buildDir, "node_modules", "fsevents")).NotTo(BeADirectory())
+			Expect(filepath.Join(buildDir, "node_modules", "sharp")).To(BeADirectory())
+
+			Expect(buffer.String()).To(ContainSubstring("node_modules holds packages built for another platform, which fail to load at runtime:"))
+			Expect(buffer.String()).To(ContainSubstring("bcrypt (node_modules/bcrypt): build/Release/addon.node is a Mach-O binary"))
+			Expect(buffer.String()).To(ContainSubstring("fsevents (node_modules/fsevents): its os field is darwin"))
+			Expect(buffer.String()).To(ContainSubstring("Removed them so that they are installed for linux/"))
+		})
+
+		It("only reports them with BP_KEEP_FOREIGN_BINARIES=true", func() {
+			os.Setenv("BP_KEEP_FOREIGN_BINARIES", "true")
+			Expect(supplier.RemoveForeignPackages()).To(Succeed())
+			Expect(filepath.Join(buildDir, "node_modules", "bcrypt")).To(BeADirectory())
+			Expect(filepath.Join(buildDir, "node_modules", "fsevents")).To(BeADirectory())
+			Expect(buffer.String()).To(ContainSubstring("Keeping them as BP_KEEP_FOREIGN_BINARIES is set"))
+		})
+
+		It("leaves node_modules installed during staging alone", func() {
+			supplier.IsVendored = false
+			Expect(supplier.RemoveForeignPackages()).To(Succeed())
+			Expect(filepath.Join(buildDir, "node_modules", "bcrypt")).To(BeADirectory())
+			Expect(buffer.String()).To(BeEmpty())
+		})
+	})
+})
@@ -0,0 +1,224 @@
+package supply
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"nodejs/cache"
+	"nodejs/failure"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/cloudfoundry/libbuildpack"
+)
+
+// nestedInstallEntries is the dir of the cache which keeps the node_modules
+// of every path of BP_NESTED_INSTALL_PATHS, each as an entry named after
+// the path.
+const nestedInstallEntries = "nested-installs"
+
+// nestedKeyFiles make up the cache key of a nested install, along with its
+// path.
+var nestedKeyFiles = []string{"package.json", "package-lock.json", "npm-shrinkwrap.json", "yarn.lock"}
+
+// ParseNestedInstallPaths parses the comma-separated dirs of
+// BP_NESTED_INSTALL_PATHS, relative to the app dir, in order. Dirs outside
+// the app dir, and the app dir itself, are refused.
+func ParseNestedInstallPaths(value string) ([]string, error) {
+	var paths []string
+	seen := map[string]bool{}
+	for _, path := range strings.Split(value, ",") {
+		path = strings.TrimSpace(path)
+		if path == "" {
+			continue
+		}
+		clean := filepath.Clean(path)
+		if filepath.IsAbs(clean) || clean == "." || clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+			return nil, fmt.Errorf("BP_NESTED_INSTALL_PATHS lists %s, which is not a dir inside the app", path)
+		}
+		if !seen[clean] {
+			seen[clean] = true
+			paths = append(paths, clean)
+		}
+	}
+	return paths, nil
+}
+
+// nestedCacheKey is the sha256 of the package.json and lockfiles of dir, so
+// that the cached node_modules of a path is only restored for the same
+// dependencies.
+func nestedCacheKey(dir string) (string, error) {
+	hash := sha256.New()
+	for _, name := range nestedKeyFiles {
+		contents, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(hash, "%s %d\n", name, len(contents))
+		hash.Write(contents)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// nestedInstall is the outcome of the install of a path, for the report.
+type nestedInstall struct {
+	path     string
+	elapsed  time.Duration
+	restored bool
+	err      error
+}
+
+func (n nestedInstall) String() string {
+	line := fmt.Sprintf("  %s: %s", n.path, n.elapsed.Round(time.Millisecond))
+	switch {
+	case n.err != nil:
+		line += ", failed"
+	case n.restored:
+		line += ", node_modules restored from the cache"
+	}
+	return line
+}
+
+// InstallNestedPaths installs the dependencies of every path of
+// BP_NESTED_INSTALL_PATHS in order, for monorepos whose apps have a
+// package.json and lockfile of their own rather than workspaces. Every path
+// restores and stores its node_modules under a cache key of its own, and
+// all of them share the npm cache of the app install. The first path which
+// fails stops the installs, naming the path.
+func (s *Supplier) InstallNestedPaths() error {
+	paths, err := ParseNestedInstallPaths(os.Getenv("BP_NESTED_INSTALL_PATHS"))
+	if err != nil || len(paths) == 0 {
+		return err
+	}
+
+	s.Log.BeginStep("Installing nested paths (BP_NESTED_INSTALL_PATHS)")
+	var lines []string
+	installed := 0
+	start := time.Now()
+	for _, path := range paths {
+		install := s.installNestedPath(path)
+		lines = append(lines, install.String())
+		if err = install.err; err != nil {
+			break
+		}
+		installed++
+	}
+	s.Log.Info("Installed %d of %d nested paths in %s:\n%s", installed, len(paths), time.Since(start).Round(time.Millisecond), strings.Join(lines, "\n"))
+	return err
+}
+
+func (s *Supplier) installNestedPath(path string) nestedInstall {
+	start := time.Now()
+	install := nestedInstall{path: path}
+	install.restored, install.err = s.buildNestedPath(path)
+	install.elapsed = time.Since(start)
+	if install.err != nil {
+		install.err = failure.Wrap(failure.InstallFailed, fmt.Errorf("installing %s of BP_NESTED_INSTALL_PATHS failed: %s", path, install.err))
+	}
+	return install
+}
+
+// buildNestedPath installs the dependencies of path, and reports whether its
+// node_modules was restored from the cache.
+func (s *Supplier) buildNestedPath(path string) (bool, error) {
+	dir := filepath.Join(s.Stager.BuildDir(), path)
+	if found, err := libbuildpack.FileExists(filepath.Join(dir, "package.json")); err != nil {
+		return false, err
+	} else if !found {
+		return false, fmt.Errorf("%s has no package.json", path)
+	}
+	// A lockfile npm can't parse fails here, before the install names it
+	// in an error without the path.
+	if _, err := CountLockfilePackages(dir); err != nil {
+		return false, fmt.Errorf("unable to read the lockfile: %s", err)
+	}
+
+	key, err := nestedCacheKey(dir)
+	if err != nil {
+		return false, err
+	}
+	restored, err := s.restoreNestedNodeModules(path, key)
+	if err != nil {
+		return false, err
+	}
+
+	useYarn, err := libbuildpack.FileExists(filepath.Join(dir, "yarn.lock"))
+	if err != nil {
+		return restored, err
+	}
+	s.Log.Info("Installing the dependencies of %s", path)
+	if useYarn {
+		err = s.Yarn.Build(dir, s.Stager.CacheDir())
+	} else {
+		err = s.NPM.Build(dir, s.Stager.CacheDir())
+	}
+	if err != nil {
+		return restored, err
+	}
+	return restored, s.storeNestedNodeModules(path, key)
+}
+
+// nestedCacheEntry is the name of the cache entry of path.
+func nestedCacheEntry(path string) string {
+	return filepath.Join(nestedInstallEntries, strings.Replace(filepath.ToSlash(path), "/", "__", -1))
+}
+
+// restoreNestedNodeModules restores the node_modules of path which the
+// previous build stored under key. A node_modules the app was pushed with
+// is kept.
+func (s *Supplier) restoreNestedNodeModules(path, key string) (bool, error) {
+	if s.Stager.CacheDir() == "" {
+		return false, nil
+	}
+	target := filepath.Join(s.Stager.BuildDir(), path, "node_modules")
+	if exists, err := libbuildpack.FileExists(target); err != nil || exists {
+		return false, err
+	}
+
+	entry, found, err := cache.New(s.Stager.CacheDir()).Restore(nestedCacheEntry(path))
+	if err != nil || !found {
+		return false, err
+	}
+	cachedKey, err := ioutil.ReadFile(filepath.Join(entry, "key"))
+	if err != nil && !os.IsNotExist(err) {
+		return false, err
+	}
+	if string(cachedKey) != key {
+		s.Log.Info("Not restoring the node_modules of %s, its package.json or lockfile changed", path)
+		return false, nil
+	}
+
+	if err := os.MkdirAll(target, 0755); err != nil {
+		return false, err
+	}
+	if err := libbuildpack.CopyDirectory(filepath.Join(entry, "node_modules"), target); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// storeNestedNodeModules stores the node_modules of path under key for the
+// next build.
+func (s *Supplier) storeNestedNodeModules(path, key string) error {
+	if s.Stager.CacheDir() == "" {
+		return nil
+	}
+	source := filepath.Join(s.Stager.BuildDir(), path, "node_modules")
+	if exists, err := libbuildpack.FileExists(source); err != nil || !exists {
+		return err
+	}
+	return cache.New(s.Stager.CacheDir()).Write(nestedCacheEntry(path), func(entry string) error {
+		if err := os.MkdirAll(filepath.Join(entry, "node_modules"), 0755); err != nil {
+			return err
+		}
+		if err := libbuildpack.CopyDirectory(source, filepath.Join(entry, "node_modules")); err != nil {
+			return err
+		}
+		return ioutil.WriteFile(filepath.Join(entry, "key"), []byte(key), 0644)
+	})
+}
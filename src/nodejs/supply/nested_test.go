@@ -0,0 +1,154 @@
+package supply_test
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"nodejs/failure"
+	"nodejs/supply"
+	"os"
+	"path/filepath"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/cloudfoundry/libbuildpack/ansicleaner"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	"golang.google.cn/x/mock/gomock"
+)
+
+var _ = Describe("InstallNestedPaths", func() {
+	var (
+		err         error
+		buildDir    string
+		cacheDir    string
+		supplier    *supply.Supplier
+		buffer      *bytes.Buffer
+		mockCtrl    *gomock.Controller
+		mockNPM     *MockNPM
+		oldNested   string
+		api, worker string
+	)
+
+	// install writes the node_modules npm would into dir.
+	install := func(dir, _ string) {
+		Expect(os.MkdirAll(filepath.Join(dir, "node_modules", "leftpad"), 0755)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(dir, "node_modules", "leftpad", "package.json"), []byte(`{"name":"leftpad"}`), 0644)).To(Succeed())
+	}
+
+	fixWorkerLockfile := func() {
+		lockfile, err := ioutil.ReadFile(filepath.Join(api, "package-lock.json"))
+		Expect(err).To(BeNil())
+		Expect(ioutil.WriteFile(filepath.Join(worker, "package-lock.json"), lockfile, 0644)).To(Succeed())
+	}
+
+	BeforeEach(func() {
+		buildDir, err = ioutil.TempDir("", "nodejs-buildpack.build.")
+		Expect(err).To(BeNil())
+		cacheDir, err = ioutil.TempDir("", "nodejs-buildpack.cache.")
+		Expect(err).To(BeNil())
+		Expect(libbuildpack.CopyDirectory(filepath.Join("testdata", "nested"), buildDir)).To(Succeed())
+		api = filepath.Join(buildDir, "packages", "api")
+		worker = filepath.Join(buildDir, "packages", "worker")
+
+		oldNested = os.Getenv("BP_NESTED_INSTALL_PATHS")
+		os.Setenv("BP_NESTED_INSTALL_PATHS", "packages/api,packages/worker")
+
+		mockCtrl = gomock.NewController(GinkgoT())
+		mockNPM = NewMockNPM(mockCtrl)
+
+		buffer = new(bytes.Buffer)
+		logger := libbuildpack.NewLogger(ansicleaner.New(buffer))
+		supplier = &supply.Supplier{
+			Stager: libbuildpack.NewStager([]string{buildDir, cacheDir, "", "0"}, logger, &libbuildpack.Manifest{}),
+			Log:    logger,
+			NPM:    mockNPM,
+		}
+	})
+
+	AfterEach(func() {
+		mockCtrl.Finish()
+		os.Setenv("BP_NESTED_INSTALL_PATHS", oldNested)
+		Expect(os.RemoveAll(buildDir)).To(Succeed())
+		Expect(os.RemoveAll(cacheDir)).To(Succeed())
+	})
+
+	It("does nothing without BP_NESTED_INSTALL_PATHS", func() {
+		os.Setenv("BP_NESTED_INSTALL_PATHS", "")
+		Expect(supplier.InstallNestedPaths()).To(Succeed())
+		Expect(buffer.String()).To(Equal(""))
+	})
+
+	It("installs every path in order with the npm cache of the app", func() {
+		fixWorkerLockfile()
+		gomock.InOrder(
+			mockNPM.EXPECT().Build(api, cacheDir).Do(install),
+			mockNPM.EXPECT().Build(worker, cacheDir).Do(install),
+		)
+
+		Expect(supplier.InstallNestedPaths()).To(Succeed())
+		Expect(filepath.Join(api, "node_modules", "leftpad")).To(BeADirectory())
+		Expect(filepath.Join(worker, "node_modules", "leftpad")).To(BeADirectory())
+		Expect(buffer.String()).To(MatchRegexp(`Installed 2 of 2 nested paths in \S+:\n\s+packages/api: \S+\n\s+packages/worker: \S+\n`))
+	})
+
+	It("fails naming the path with a broken lockfile, after installing those before it", func() {
+		mockNPM.EXPECT().Build(api, cacheDir).Do(install)
+
+		err := supplier.InstallNestedPaths()
+		Expect(err).To(MatchError(HavePrefix("installing packages/worker of BP_NESTED_INSTALL_PATHS failed: unable to read the lockfile: ")))
+		Expect(failure.CodeOf(err)).To(Equal(failure.InstallFailed))
+		Expect(buffer.String()).To(MatchRegexp(`Installed 1 of 2 nested paths in \S+:\n\s+packages/api: \S+\n\s+packages/worker: \S+, failed\n`))
+	})
+
+	It("fails naming the path whose install fails", func() {
+		fixWorkerLockfile()
+		mockNPM.EXPECT().Build(api, cacheDir).Return(errors.New("npm ERR! code ERESOLVE"))
+
+		err := supplier.InstallNestedPaths()
+		Expect(err).To(MatchError("installing packages/api of BP_NESTED_INSTALL_PATHS failed: npm ERR! code ERESOLVE"))
+		Expect(buffer.String()).To(ContainSubstring("Installed 0 of 2 nested paths"))
+	})
+
+	Context("on the next build", func() {
+		BeforeEach(func() {
+			fixWorkerLockfile()
+			mockNPM.EXPECT().Build(gomock.Any(), cacheDir).Do(install).Times(2)
+			Expect(supplier.InstallNestedPaths()).To(Succeed())
+			buffer.Reset()
+
+			Expect(os.RemoveAll(filepath.Join(api, "node_modules"))).To(Succeed())
+			Expect(os.RemoveAll(filepath.Join(worker, "node_modules"))).To(Succeed())
+		})
+
+		It("restores the node_modules of every path under its own cache key", func() {
+			Expect(ioutil.WriteFile(filepath.Join(worker, "package.json"), []byte(`{"name":"worker","dependencies":{"leftpad":"0.0.2"}}`), 0644)).To(Succeed())
+			mockNPM.EXPECT().Build(api, cacheDir).Do(func(dir, _ string) {
+				Expect(filepath.Join(dir, "node_modules", "leftpad", "package.json")).To(BeAnExistingFile())
+			})
+			mockNPM.EXPECT().Build(worker, cacheDir).Do(func(dir, _ string) {
+				Expect(filepath.Join(dir, "node_modules")).NotTo(BeAnExistingFile())
+			})
+
+			Expect(supplier.InstallNestedPaths()).To(Succeed())
+			Expect(buffer.String()).To(MatchRegexp(`packages/api: \S+, node_modules restored from the cache\n`))
+			Expect(buffer.String()).To(ContainSubstring("Not restoring the node_modules of packages/worker, its package.json or lockfile changed"))
+		})
+	})
+
+	DescribeTable("ParseNestedInstallPaths",
+		func(value string, expected []string) {
+			Expect(supply.ParseNestedInstallPaths(value)).To(Equal(expected))
+		},
+		Entry("in order", "packages/worker, packages/api", []string{"packages/worker", "packages/api"}),
+		Entry("once", "packages/api,packages/api/", []string{"packages/api"}),
+		Entry("none", "", []string(nil)),
+	)
+
+	It("refuses paths outside the app", func() {
+		for _, value := range []string{"../other", "/srv/app", "."} {
+			_, err := supply.ParseNestedInstallPaths(value)
+			Expect(err).To(MatchError(ContainSubstring("which is not a dir inside the app")), value)
+		}
+	})
+})
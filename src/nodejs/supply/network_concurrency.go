@@ -0,0 +1,36 @@
+package supply
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// networkConcurrencyEnv are the variables npm and yarn take the number of
+// parallel downloads from.
+var networkConcurrencyEnv = []string{"npm_config_maxsockets", "YARN_NETWORK_CONCURRENCY"}
+
+// SetupNetworkConcurrency limits the parallel downloads of npm and yarn to
+// BP_NETWORK_CONCURRENCY, which bounds the memory an install takes too. A
+// limit the app sets in the environment is kept.
+func (s *Supplier) SetupNetworkConcurrency() error {
+	value := os.Getenv("BP_NETWORK_CONCURRENCY")
+	if value == "" {
+		return nil
+	}
+	if n, err := strconv.Atoi(value); err != nil || n < 1 {
+		return fmt.Errorf("invalid BP_NETWORK_CONCURRENCY %q, expected a positive number", value)
+	}
+
+	for _, name := range networkConcurrencyEnv {
+		if set := os.Getenv(name); set != "" {
+			s.Log.Info("Keeping %s=%s over BP_NETWORK_CONCURRENCY", name, set)
+			continue
+		}
+		if err := os.Setenv(name, value); err != nil {
+			return err
+		}
+	}
+	s.Log.Info("Downloading at most %s packages at a time (BP_NETWORK_CONCURRENCY)", value)
+	return nil
+}
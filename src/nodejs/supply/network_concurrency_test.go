@@ -0,0 +1,71 @@
+package supply_test
+
+import (
+	"bytes"
+	"nodejs/supply"
+	"os"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/cloudfoundry/libbuildpack/ansicleaner"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("SetupNetworkConcurrency", func() {
+	var (
+		supplier *supply.Supplier
+		buffer   *bytes.Buffer
+		oldEnv   map[string]string
+	)
+
+	BeforeEach(func() {
+		oldEnv = map[string]string{}
+		for _, key := range []string{"BP_NETWORK_CONCURRENCY", "npm_config_maxsockets", "YARN_NETWORK_CONCURRENCY"} {
+			oldEnv[key] = os.Getenv(key)
+			os.Unsetenv(key)
+		}
+
+		buffer = new(bytes.Buffer)
+		logger := libbuildpack.NewLogger(ansicleaner.New(buffer))
+		supplier = &supply.Supplier{
+			Stager: libbuildpack.NewStager([]string{"", "", "", "0"}, logger, &libbuildpack.Manifest{}),
+			Log:    logger,
+		}
+	})
+
+	AfterEach(func() {
+		for key, value := range oldEnv {
+			os.Setenv(key, value)
+		}
+	})
+
+	It("does nothing without BP_NETWORK_CONCURRENCY", func() {
+		Expect(supplier.SetupNetworkConcurrency()).To(Succeed())
+		Expect(os.Getenv("npm_config_maxsockets")).To(Equal(""))
+		Expect(buffer.String()).To(Equal(""))
+	})
+
+	It("limits the parallel downloads of npm and yarn", func() {
+		os.Setenv("BP_NETWORK_CONCURRENCY", "4")
+
+		Expect(supplier.SetupNetworkConcurrency()).To(Succeed())
+		Expect(os.Getenv("npm_config_maxsockets")).To(Equal("4"))
+		Expect(os.Getenv("YARN_NETWORK_CONCURRENCY")).To(Equal("4"))
+		Expect(buffer.String()).To(ContainSubstring("Downloading at most 4 packages at a time (BP_NETWORK_CONCURRENCY)"))
+	})
+
+	It("keeps a limit of the app", func() {
+		os.Setenv("BP_NETWORK_CONCURRENCY", "4")
+		os.Setenv("npm_config_maxsockets", "2")
+
+		Expect(supplier.SetupNetworkConcurrency()).To(Succeed())
+		Expect(os.Getenv("npm_config_maxsockets")).To(Equal("2"))
+		Expect(os.Getenv("YARN_NETWORK_CONCURRENCY")).To(Equal("4"))
+		Expect(buffer.String()).To(ContainSubstring("Keeping npm_config_maxsockets=2 over BP_NETWORK_CONCURRENCY"))
+	})
+
+	It("refuses an invalid value", func() {
+		os.Setenv("BP_NETWORK_CONCURRENCY", "0")
+		Expect(supplier.SetupNetworkConcurrency()).To(MatchError(`invalid BP_NETWORK_CONCURRENCY "0", expected a positive number`))
+	})
+})
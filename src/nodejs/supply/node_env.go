@@ -0,0 +1,79 @@
+package supply
+
+import (
+	"fmt"
+	"strings"
+)
+
+// NodeEnv is the NODE_ENV of the install and build subprocesses and the one
+// the app runs with, along with the setting each comes from.
+type NodeEnv struct {
+	Build         string
+	BuildSource   string
+	Runtime       string
+	RuntimeSource string
+}
+
+// ResolveNodeEnv decides the NODE_ENV values from environ. The runtime value
+// is BP_RUNTIME_NODE_ENV, else NODE_ENV, else production. The build value is
+// BP_BUILD_NODE_ENV, else the runtime value.
+func ResolveNodeEnv(environ []string) NodeEnv {
+	var e NodeEnv
+	if value, _ := envValue(environ, "BP_RUNTIME_NODE_ENV", false); value != "" {
+		e.Runtime, e.RuntimeSource = value, "BP_RUNTIME_NODE_ENV"
+	} else if value, _ := envValue(environ, "NODE_ENV", false); value != "" {
+		e.Runtime, e.RuntimeSource = value, "NODE_ENV"
+	} else {
+		e.Runtime, e.RuntimeSource = "production", "default"
+	}
+
+	if value, _ := envValue(environ, "BP_BUILD_NODE_ENV", false); value != "" {
+		e.Build, e.BuildSource = value, "BP_BUILD_NODE_ENV"
+	} else {
+		e.Build, e.BuildSource = e.Runtime, e.RuntimeSource
+	}
+	return e
+}
+
+// Split reports whether the build runs with another NODE_ENV than the app.
+func (e NodeEnv) Split() bool {
+	return e.Build != e.Runtime
+}
+
+func (e NodeEnv) String() string {
+	if !e.Split() {
+		return fmt.Sprintf("NODE_ENV=%s (%s) for the build and at runtime", e.Runtime, e.RuntimeSource)
+	}
+	return fmt.Sprintf("NODE_ENV=%s (%s) for the build, NODE_ENV=%s (%s) at runtime", e.Build, e.BuildSource, e.Runtime, e.RuntimeSource)
+}
+
+// PruneSkipReason tells why devDependencies must stay in node_modules after
+// the build, or returns "" when BP_PRUNE_OMIT may prune them. That depends on
+// the app at runtime: it runs in production with NODE_ENV=production, or
+// with NPM_CONFIG_PRODUCTION=true whatever its NODE_ENV.
+func (e NodeEnv) PruneSkipReason(environ []string) string {
+	if e.Runtime == "production" {
+		return ""
+	}
+	if production, _ := envValue(environ, "npm_config_production", true); production == "true" {
+		return ""
+	}
+	return fmt.Sprintf("the app runs with NODE_ENV=%s (%s), not production", e.Runtime, e.RuntimeSource)
+}
+
+// DeferProduction reports whether the install gets devDependencies although
+// the app sets NPM_CONFIG_PRODUCTION=true, because its build scripts may
+// need them, leaving them to pruning once the scripts ran, as Heroku does.
+// BP_INSTALL_PRODUCTION_ONLY=true installs the production dependencies
+// alone, as before.
+func DeferProduction(environ []string, buildScripts bool) bool {
+	if production, _ := envValue(environ, "npm_config_production", true); production != "true" || !buildScripts {
+		return false
+	}
+	productionOnly, _ := envValue(environ, "BP_INSTALL_PRODUCTION_ONLY", false)
+	return productionOnly != "true"
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
@@ -0,0 +1,69 @@
+package supply_test
+
+import (
+	"nodejs/supply"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("NodeEnv", func() {
+	DescribeTable("ResolveNodeEnv",
+		func(environ []string, expected supply.NodeEnv) {
+			Expect(supply.ResolveNodeEnv(environ)).To(Equal(expected))
+		},
+		Entry("defaults to production",
+			[]string{},
+			supply.NodeEnv{Build: "production", BuildSource: "default", Runtime: "production", RuntimeSource: "default"}),
+		Entry("NODE_ENV sets both",
+			[]string{"NODE_ENV=development"},
+			supply.NodeEnv{Build: "development", BuildSource: "NODE_ENV", Runtime: "development", RuntimeSource: "NODE_ENV"}),
+		Entry("an empty NODE_ENV is the default",
+			[]string{"NODE_ENV="},
+			supply.NodeEnv{Build: "production", BuildSource: "default", Runtime: "production", RuntimeSource: "default"}),
+		Entry("BP_BUILD_NODE_ENV sets the build alone",
+			[]string{"BP_BUILD_NODE_ENV=development"},
+			supply.NodeEnv{Build: "development", BuildSource: "BP_BUILD_NODE_ENV", Runtime: "production", RuntimeSource: "default"}),
+		Entry("BP_BUILD_NODE_ENV with NODE_ENV",
+			[]string{"BP_BUILD_NODE_ENV=development", "NODE_ENV=staging"},
+			supply.NodeEnv{Build: "development", BuildSource: "BP_BUILD_NODE_ENV", Runtime: "staging", RuntimeSource: "NODE_ENV"}),
+		Entry("BP_RUNTIME_NODE_ENV wins over NODE_ENV at runtime",
+			[]string{"NODE_ENV=development", "BP_RUNTIME_NODE_ENV=production"},
+			supply.NodeEnv{Build: "production", BuildSource: "BP_RUNTIME_NODE_ENV", Runtime: "production", RuntimeSource: "BP_RUNTIME_NODE_ENV"}),
+		Entry("all three",
+			[]string{"NODE_ENV=test", "BP_BUILD_NODE_ENV=development", "BP_RUNTIME_NODE_ENV=production"},
+			supply.NodeEnv{Build: "development", BuildSource: "BP_BUILD_NODE_ENV", Runtime: "production", RuntimeSource: "BP_RUNTIME_NODE_ENV"}),
+	)
+
+	DescribeTable("PruneSkipReason",
+		func(environ []string, expected string) {
+			Expect(supply.ResolveNodeEnv(environ).PruneSkipReason(environ)).To(Equal(expected))
+		},
+		Entry("prunes by default", []string{}, ""),
+		Entry("prunes a development build of a production app", []string{"BP_BUILD_NODE_ENV=development"}, ""),
+		Entry("keeps devDependencies of a development app", []string{"NODE_ENV=development"}, "the app runs with NODE_ENV=development (NODE_ENV), not production"),
+		Entry("keeps devDependencies when the runtime is not production", []string{"BP_BUILD_NODE_ENV=production", "BP_RUNTIME_NODE_ENV=test"}, "the app runs with NODE_ENV=test (BP_RUNTIME_NODE_ENV), not production"),
+		Entry("prunes with NPM_CONFIG_PRODUCTION=true", []string{"NODE_ENV=development", "NPM_CONFIG_PRODUCTION=true"}, ""),
+		Entry("keeps devDependencies with NPM_CONFIG_PRODUCTION=false", []string{"NODE_ENV=development", "NPM_CONFIG_PRODUCTION=false"}, "the app runs with NODE_ENV=development (NODE_ENV), not production"),
+	)
+
+	DescribeTable("DeferProduction",
+		func(environ []string, buildScripts bool, expected bool) {
+			Expect(supply.DeferProduction(environ, buildScripts)).To(Equal(expected))
+		},
+		Entry("not without NPM_CONFIG_PRODUCTION", []string{}, true, false),
+		Entry("not with NPM_CONFIG_PRODUCTION=false", []string{"NPM_CONFIG_PRODUCTION=false"}, true, false),
+		Entry("not without build scripts", []string{"NPM_CONFIG_PRODUCTION=true"}, false, false),
+		Entry("with build scripts", []string{"NPM_CONFIG_PRODUCTION=true"}, true, true),
+		Entry("with npm_config_production in lowercase", []string{"npm_config_production=true"}, true, true),
+		Entry("with build scripts and NODE_ENV=development", []string{"NPM_CONFIG_PRODUCTION=true", "NODE_ENV=development"}, true, true),
+		Entry("not with BP_INSTALL_PRODUCTION_ONLY=true", []string{"NPM_CONFIG_PRODUCTION=true", "BP_INSTALL_PRODUCTION_ONLY=true"}, true, false),
+		Entry("with BP_INSTALL_PRODUCTION_ONLY=false", []string{"NPM_CONFIG_PRODUCTION=true", "BP_INSTALL_PRODUCTION_ONLY=false"}, true, true),
+	)
+
+	It("describes the values and their sources", func() {
+		Expect(supply.ResolveNodeEnv([]string{}).String()).To(Equal("NODE_ENV=production (default) for the build and at runtime"))
+		Expect(supply.ResolveNodeEnv([]string{"BP_BUILD_NODE_ENV=development"}).String()).To(Equal("NODE_ENV=development (BP_BUILD_NODE_ENV) for the build, NODE_ENV=production (default) at runtime"))
+	})
+})
@@ -0,0 +1,164 @@
+package supply
+
+import (
+	"fmt"
+	"nodejs/profiled"
+	"os"
+	"path/filepath"
+
+	"github.com/cloudfoundry/libbuildpack"
+)
+
+// Locations of node_modules in the droplet, chosen with
+// BP_NODE_MODULES_LOCATION.
+const (
+	// NodeModulesAppDir keeps node_modules in the app dir.
+	NodeModulesAppDir = "appdir"
+	// NodeModulesDepDirSymlink moves node_modules into the dep dir, and
+	// profile.d links $HOME/node_modules to it at runtime.
+	NodeModulesDepDirSymlink = "depdir-symlink"
+)
+
+// NodeModulesLocation returns BP_NODE_MODULES_LOCATION. By default vendored
+// node_modules stay in the app dir and installed ones go to the dep dir,
+// which is how the buildpack has always placed them.
+func (s *Supplier) NodeModulesLocation() (string, error) {
+	switch location := os.Getenv("BP_NODE_MODULES_LOCATION"); location {
+	case NodeModulesAppDir, NodeModulesDepDirSymlink:
+		return location, nil
+	case "":
+		if s.IsVendored {
+			return NodeModulesAppDir, nil
+		}
+		return NodeModulesDepDirSymlink, nil
+	default:
+		return "", fmt.Errorf("invalid BP_NODE_MODULES_LOCATION %q, expected %s or %s", location, NodeModulesAppDir, NodeModulesDepDirSymlink)
+	}
+}
+
+// PrepareNodeModules makes node_modules a real directory in the app dir
+// before installing, whatever placement left it behind: a symlink into a
+// deps dir is replaced by a copy of its target, a dangling one is removed,
+// and node_modules left in the dep dir is moved back.
+func (s *Supplier) PrepareNodeModules() error {
+	if _, err := s.NodeModulesLocation(); err != nil {
+		return err
+	}
+
+	appNodeModules := filepath.Join(s.Stager.BuildDir(), "node_modules")
+	depNodeModules := filepath.Join(s.Stager.DepDir(), "node_modules")
+
+	info, err := os.Lstat(appNodeModules)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	if err == nil && info.Mode()&os.ModeSymlink != 0 {
+		target, evalErr := filepath.EvalSymlinks(appNodeModules)
+		if err := os.Remove(appNodeModules); err != nil {
+			return err
+		}
+		if evalErr != nil {
+			s.Log.Info("Removing dangling node_modules symlink")
+		} else if depTarget, _ := filepath.EvalSymlinks(depNodeModules); target != depTarget {
+			s.Log.Info("Replacing node_modules symlink with a copy of %s", target)
+			if err := os.MkdirAll(appNodeModules, 0755); err != nil {
+				return err
+			}
+			if err := libbuildpack.CopyDirectory(target, appNodeModules); err != nil {
+				return err
+			}
+		}
+	}
+
+	if _, err := os.Stat(depNodeModules); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	if exists, err := libbuildpack.FileExists(appNodeModules); err != nil {
+		return err
+	} else if exists {
+		return os.RemoveAll(depNodeModules)
+	}
+
+	s.Log.Info("Moving node_modules from the dep dir back into the app dir")
+	return os.Rename(depNodeModules, appNodeModules)
+}
+
+// MoveDependencyArtifacts places node_modules according to
+// NodeModulesLocation once dependencies are installed.
+func (s *Supplier) MoveDependencyArtifacts() error {
+	location, err := s.NodeModulesLocation()
+	if err != nil {
+		return err
+	}
+	if location == NodeModulesAppDir {
+		return nil
+	}
+
+	appNodeModules := filepath.Join(s.Stager.BuildDir(), "node_modules")
+
+	_, err = os.Stat(appNodeModules)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	nodePath := filepath.Join(s.Stager.DepDir(), "node_modules")
+
+	if err := os.RemoveAll(nodePath); err != nil {
+		return err
+	}
+
+	if err := os.Rename(appNodeModules, nodePath); err != nil {
+		return err
+	}
+
+	return s.exportNodePath(nodePath)
+}
+
+// nodeModulesScript links $HOME/node_modules to the node_modules of the dep
+// dir and adds it to the NODE_PATH of the app, once however often it runs.
+const nodeModulesScript = `if [ ! -d "$HOME/node_modules" ]; then
+	ln -sfn "%s" "$HOME/node_modules"
+fi
+case ":$NODE_PATH:" in
+	*":$HOME/node_modules:"*) ;;
+	*) export NODE_PATH="${NODE_PATH:+$NODE_PATH:}$HOME/node_modules" ;;
+esac
+`
+
+// exportNodePath adds nodePath, the node_modules moved to the dep dir, to
+// NODE_PATH for the rest of staging and writes the profile.d script which
+// does the same at runtime. NODE_PATH is only needed there because
+// node_modules is outside the app dir, and a NODE_PATH of the app is kept
+// in front of it.
+func (s *Supplier) exportNodePath(nodePath string) error {
+	merged := MergeNodePath(os.Getenv("NODE_PATH"), nodePath)
+	if err := s.Stager.WriteEnvFile("NODE_PATH", merged); err != nil {
+		return err
+	}
+	if err := os.Setenv("NODE_PATH", merged); err != nil {
+		return err
+	}
+	return profiled.Write(s.Stager, "node_modules.sh", fmt.Sprintf(nodeModulesScript, filepath.Join("$DEPS_DIR", s.Stager.DepsIdx(), "node_modules")))
+}
+
+// MergeNodePath appends dir to the NODE_PATH list nodePath, unless it is in
+// there already.
+func MergeNodePath(nodePath, dir string) string {
+	if nodePath == "" {
+		return dir
+	}
+	for _, entry := range filepath.SplitList(nodePath) {
+		if entry == dir {
+			return nodePath
+		}
+	}
+	return nodePath + string(os.PathListSeparator) + dir
+}
@@ -0,0 +1,221 @@
+package supply_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"nodejs/supply"
+	"os"
+	"path/filepath"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/cloudfoundry/libbuildpack/ansicleaner"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("node_modules location", func() {
+	var (
+		err      error
+		buildDir string
+		depsDir  string
+		depDir   string
+		supplier *supply.Supplier
+		buffer   *bytes.Buffer
+		oldEnv   map[string]string
+	)
+
+	BeforeEach(func() {
+		buildDir, err = ioutil.TempDir("", "nodejs-buildpack.build.")
+		Expect(err).To(BeNil())
+		depsDir, err = ioutil.TempDir("", "nodejs-buildpack.deps.")
+		Expect(err).To(BeNil())
+		depDir = filepath.Join(depsDir, "0")
+		Expect(os.MkdirAll(depDir, 0755)).To(Succeed())
+
+		oldEnv = map[string]string{}
+		for _, key := range []string{"BP_NODE_MODULES_LOCATION", "NODE_PATH"} {
+			if value, found := os.LookupEnv(key); found {
+				oldEnv[key] = value
+			}
+			Expect(os.Unsetenv(key)).To(Succeed())
+		}
+
+		buffer = new(bytes.Buffer)
+		logger := libbuildpack.NewLogger(ansicleaner.New(buffer))
+		supplier = &supply.Supplier{
+			Stager: libbuildpack.NewStager([]string{buildDir, "", depsDir, "0"}, logger, &libbuildpack.Manifest{}),
+			Log:    logger,
+		}
+	})
+
+	AfterEach(func() {
+		for _, key := range []string{"BP_NODE_MODULES_LOCATION", "NODE_PATH"} {
+			if value, found := oldEnv[key]; found {
+				Expect(os.Setenv(key, value)).To(Succeed())
+			} else {
+				Expect(os.Unsetenv(key)).To(Succeed())
+			}
+		}
+		Expect(os.RemoveAll(buildDir)).To(Succeed())
+		Expect(os.RemoveAll(depsDir)).To(Succeed())
+	})
+
+	Describe("NodeModulesLocation", func() {
+		It("keeps vendored node_modules in the app dir by default", func() {
+			supplier.IsVendored = true
+			Expect(supplier.NodeModulesLocation()).To(Equal(supply.NodeModulesAppDir))
+		})
+
+		It("moves installed node_modules to the dep dir by default", func() {
+			Expect(supplier.NodeModulesLocation()).To(Equal(supply.NodeModulesDepDirSymlink))
+		})
+
+		It("uses BP_NODE_MODULES_LOCATION", func() {
+			os.Setenv("BP_NODE_MODULES_LOCATION", "appdir")
+			Expect(supplier.NodeModulesLocation()).To(Equal(supply.NodeModulesAppDir))
+		})
+
+		It("rejects unknown locations", func() {
+			os.Setenv("BP_NODE_MODULES_LOCATION", "elsewhere")
+			_, err := supplier.NodeModulesLocation()
+			Expect(err).To(MatchError(`invalid BP_NODE_MODULES_LOCATION "elsewhere", expected appdir or depdir-symlink`))
+		})
+	})
+
+	Describe("MoveDependencyArtifacts", func() {
+		BeforeEach(func() {
+			Expect(os.MkdirAll(filepath.Join(buildDir, "node_modules", "a"), 0755)).To(Succeed())
+		})
+
+		It("keeps installed node_modules in the app dir with appdir", func() {
+			os.Setenv("BP_NODE_MODULES_LOCATION", "appdir")
+			Expect(supplier.MoveDependencyArtifacts()).To(Succeed())
+			Expect(filepath.Join(buildDir, "node_modules", "a")).To(BeADirectory())
+			Expect(filepath.Join(depDir, "node_modules")).NotTo(BeADirectory())
+		})
+
+		It("moves vendored node_modules to the dep dir with depdir-symlink", func() {
+			os.Setenv("BP_NODE_MODULES_LOCATION", "depdir-symlink")
+			supplier.IsVendored = true
+			Expect(supplier.MoveDependencyArtifacts()).To(Succeed())
+			Expect(filepath.Join(buildDir, "node_modules")).NotTo(BeADirectory())
+			Expect(filepath.Join(depDir, "node_modules", "a")).To(BeADirectory())
+			Expect(ioutil.ReadFile(filepath.Join(depDir, "env", "NODE_PATH"))).To(Equal([]byte(filepath.Join(depDir, "node_modules"))))
+		})
+
+		It("exports no NODE_PATH with appdir", func() {
+			os.Setenv("BP_NODE_MODULES_LOCATION", "appdir")
+			os.Setenv("NODE_PATH", "/home/vcap/app/lib")
+			Expect(supplier.MoveDependencyArtifacts()).To(Succeed())
+			Expect(os.Getenv("NODE_PATH")).To(Equal("/home/vcap/app/lib"))
+			Expect(filepath.Join(depDir, "env", "NODE_PATH")).NotTo(BeAnExistingFile())
+			Expect(filepath.Join(depDir, "profile.d", "000_nodejs_buildpack_node_modules.sh")).NotTo(BeAnExistingFile())
+		})
+
+		It("exports NODE_PATH and links node_modules at runtime with depdir-symlink", func() {
+			os.Setenv("BP_NODE_MODULES_LOCATION", "depdir-symlink")
+			Expect(supplier.MoveDependencyArtifacts()).To(Succeed())
+			Expect(os.Getenv("NODE_PATH")).To(Equal(filepath.Join(depDir, "node_modules")))
+
+			contents, err := ioutil.ReadFile(filepath.Join(depDir, "profile.d", "000_nodejs_buildpack_node_modules.sh"))
+			Expect(err).To(BeNil())
+			Expect(string(contents)).To(Equal(`if [ ! -d "$HOME/node_modules" ]; then
+	ln -sfn "$DEPS_DIR/0/node_modules" "$HOME/node_modules"
+fi
+case ":$NODE_PATH:" in
+	*":$HOME/node_modules:"*) ;;
+	*) export NODE_PATH="${NODE_PATH:+$NODE_PATH:}$HOME/node_modules" ;;
+esac
+`))
+		})
+
+		It("adds node_modules to the NODE_PATH of the app with depdir-symlink", func() {
+			os.Setenv("BP_NODE_MODULES_LOCATION", "depdir-symlink")
+			os.Setenv("NODE_PATH", "/home/vcap/app/lib")
+			Expect(supplier.MoveDependencyArtifacts()).To(Succeed())
+
+			merged := "/home/vcap/app/lib:" + filepath.Join(depDir, "node_modules")
+			Expect(os.Getenv("NODE_PATH")).To(Equal(merged))
+			Expect(ioutil.ReadFile(filepath.Join(depDir, "env", "NODE_PATH"))).To(Equal([]byte(merged)))
+		})
+
+		It("replaces node_modules left in the dep dir", func() {
+			Expect(os.MkdirAll(filepath.Join(depDir, "node_modules", "stale"), 0755)).To(Succeed())
+			Expect(supplier.MoveDependencyArtifacts()).To(Succeed())
+			Expect(filepath.Join(depDir, "node_modules", "a")).To(BeADirectory())
+			Expect(filepath.Join(depDir, "node_modules", "stale")).NotTo(BeADirectory())
+		})
+	})
+
+	DescribeTable("MergeNodePath",
+		func(nodePath, expected string) {
+			Expect(supply.MergeNodePath(nodePath, "/deps/0/node_modules")).To(Equal(expected))
+		},
+		Entry("without a NODE_PATH", "", "/deps/0/node_modules"),
+		Entry("after the NODE_PATH of the app", "/app/lib", "/app/lib:/deps/0/node_modules"),
+		Entry("once", "/app/lib:/deps/0/node_modules", "/app/lib:/deps/0/node_modules"),
+	)
+
+	Describe("PrepareNodeModules", func() {
+		It("does nothing without node_modules", func() {
+			Expect(supplier.PrepareNodeModules()).To(Succeed())
+			Expect(filepath.Join(buildDir, "node_modules")).NotTo(BeADirectory())
+			Expect(buffer.String()).To(Equal(""))
+		})
+
+		It("migrates from depdir-symlink to the app dir", func() {
+			Expect(os.MkdirAll(filepath.Join(depDir, "node_modules", "a"), 0755)).To(Succeed())
+			Expect(os.Symlink(filepath.Join(depDir, "node_modules"), filepath.Join(buildDir, "node_modules"))).To(Succeed())
+
+			Expect(supplier.PrepareNodeModules()).To(Succeed())
+
+			info, err := os.Lstat(filepath.Join(buildDir, "node_modules"))
+			Expect(err).To(BeNil())
+			Expect(info.IsDir()).To(BeTrue())
+			Expect(filepath.Join(buildDir, "node_modules", "a")).To(BeADirectory())
+			Expect(filepath.Join(depDir, "node_modules")).NotTo(BeADirectory())
+		})
+
+		It("migrates from appdir by removing node_modules left in the dep dir", func() {
+			Expect(os.MkdirAll(filepath.Join(buildDir, "node_modules", "a"), 0755)).To(Succeed())
+			Expect(os.MkdirAll(filepath.Join(depDir, "node_modules", "stale"), 0755)).To(Succeed())
+
+			Expect(supplier.PrepareNodeModules()).To(Succeed())
+
+			Expect(filepath.Join(buildDir, "node_modules", "a")).To(BeADirectory())
+			Expect(filepath.Join(depDir, "node_modules")).NotTo(BeADirectory())
+		})
+
+		It("removes dangling symlinks", func() {
+			Expect(os.Symlink("/home/vcap/deps/0/node_modules", filepath.Join(buildDir, "node_modules"))).To(Succeed())
+
+			Expect(supplier.PrepareNodeModules()).To(Succeed())
+
+			_, err := os.Lstat(filepath.Join(buildDir, "node_modules"))
+			Expect(os.IsNotExist(err)).To(BeTrue())
+			Expect(buffer.String()).To(ContainSubstring("Removing dangling node_modules symlink"))
+		})
+
+		It("replaces symlinks to other directories with a copy", func() {
+			other, err := ioutil.TempDir("", "nodejs-buildpack.other.")
+			Expect(err).To(BeNil())
+			defer os.RemoveAll(other)
+			Expect(os.MkdirAll(filepath.Join(other, "a"), 0755)).To(Succeed())
+			Expect(os.Symlink(other, filepath.Join(buildDir, "node_modules"))).To(Succeed())
+
+			Expect(supplier.PrepareNodeModules()).To(Succeed())
+
+			info, err := os.Lstat(filepath.Join(buildDir, "node_modules"))
+			Expect(err).To(BeNil())
+			Expect(info.IsDir()).To(BeTrue())
+			Expect(filepath.Join(buildDir, "node_modules", "a")).To(BeADirectory())
+			Expect(filepath.Join(other, "a")).To(BeADirectory())
+		})
+
+		It("fails for unknown locations", func() {
+			os.Setenv("BP_NODE_MODULES_LOCATION", "elsewhere")
+			Expect(supplier.PrepareNodeModules()).To(HaveOccurred())
+		})
+	})
+})
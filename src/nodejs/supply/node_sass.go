@@ -0,0 +1,136 @@
+package supply
+
+import (
+	"fmt"
+	"nodejs/failure"
+	"nodejs/npmrange"
+	"nodejs/packagejson"
+	"nodejs/resolved"
+	"os"
+
+	"github.com/Masterminds/semver"
+)
+
+// NodeSassSupport is the newest node major each release line of node-sass
+// ships or builds a binding for, newest line first, from the support table
+// in the README of node-sass.
+var NodeSassSupport = []struct {
+	Since   string
+	MaxNode int64
+}{
+	{"9.0.0", 20},
+	{"8.0.0", 19},
+	{"7.0.0", 17},
+	{"6.0.0", 16},
+	{"5.0.0", 15},
+	{"4.14.0", 14},
+	{"4.13.0", 13},
+	{"4.12.0", 12},
+	{"4.10.0", 11},
+	{"4.9.0", 10},
+	{"4.7.0", 9},
+	{"4.5.3", 8},
+	{"4.0.0", 7},
+	{"3.7.0", 6},
+}
+
+// NodeSassMaxNode returns the newest node major the node-sass of spec
+// supports. spec is a version, from a lockfile, or a range from package.json,
+// which npm installs the newest version of, so the newest release line the
+// range reaches counts. It returns false for a spec older than the table, or
+// one which is no range, like a git URL.
+func NodeSassMaxNode(spec string) (int64, bool) {
+	if v, err := semver.NewVersion(spec); err == nil {
+		for _, line := range NodeSassSupport {
+			if !v.LessThan(semver.MustParse(line.Since)) {
+				return line.MaxNode, true
+			}
+		}
+		return 0, false
+	}
+
+	c, err := npmrange.Constraint(spec)
+	if err != nil {
+		return 0, false
+	}
+	for _, line := range NodeSassSupport {
+		since := semver.MustParse(line.Since)
+		// Any patch of the first minor of the line will do, like ^4.14.1.
+		latestPatch := semver.MustParse(fmt.Sprintf("%d.%d.999", since.Major(), since.Minor()))
+		if c.Check(since) || c.Check(latestPatch) {
+			return line.MaxNode, true
+		}
+	}
+	return 0, false
+}
+
+// nodeSassSpecs returns the node-sass versions of the lockfile, or else the
+// spec of package.json.
+func (s *Supplier) nodeSassSpecs() ([]string, error) {
+	manifest, err := resolved.FromLockfile(s.Stager.BuildDir())
+	if err != nil {
+		// yarn 2+ lockfiles are not parsed, the spec is checked instead.
+		s.Log.Debug("Checking the node-sass spec of package.json: %s", err)
+		manifest = resolved.Manifest{}
+	}
+	if manifest.Lockfile != "" {
+		var versions []string
+		for _, tarball := range manifest.Tarballs {
+			if tarball.Name == "node-sass" {
+				versions = append(versions, tarball.Version)
+			}
+		}
+		return versions, nil
+	}
+
+	p, _, err := packagejson.Load(s.Stager.BuildDir())
+	if err != nil {
+		return nil, err
+	}
+	spec, found := p.Dependencies["node-sass"]
+	if !found {
+		spec, found = p.DevDependencies["node-sass"]
+	}
+	if !found {
+		return nil, nil
+	}
+	return []string{spec}, nil
+}
+
+// CheckNodeSass fails before the install when the app depends on a
+// node-sass which has no binding for the installed node and would fail to
+// build one, far into the install, with a wall of node-gyp errors.
+// BP_NODE_SASS_CHECK=warn only warns.
+func (s *Supplier) CheckNodeSass() error {
+	mode := os.Getenv("BP_NODE_SASS_CHECK")
+	if mode != "" && mode != "fail" && mode != "warn" {
+		return fmt.Errorf("BP_NODE_SASS_CHECK=%s is not one of fail, warn", mode)
+	}
+
+	specs, err := s.nodeSassSpecs()
+	if err != nil {
+		return err
+	}
+	node, err := semver.NewVersion(s.ExactNodeVersion)
+	if err != nil {
+		return err
+	}
+
+	for _, spec := range specs {
+		maxNode, found := NodeSassMaxNode(spec)
+		if !found || node.Major() <= maxNode {
+			continue
+		}
+
+		message := fmt.Sprintf("node-sass %s supports node %d at most, but node %s is installed, so its native binding will fail to build\n"+
+			"node-sass is deprecated, migrate to sass, its drop-in replacement without native code:\n"+
+			"  npm uninstall node-sass && npm install --save-dev sass\n"+
+			"or pin engines.node to %d.x in package.json", spec, maxNode, s.ExactNodeVersion, maxNode)
+		if mode == "warn" {
+			s.Log.Warning("%s", message)
+			continue
+		}
+		return failure.Wrap(failure.NodeSassIncompatible, fmt.Errorf("%s\nSet BP_NODE_SASS_CHECK=warn to install anyway", message))
+	}
+	return nil
+}
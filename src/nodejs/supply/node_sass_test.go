@@ -0,0 +1,127 @@
+package supply_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"nodejs/failure"
+	"nodejs/supply"
+	"os"
+	"path/filepath"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/cloudfoundry/libbuildpack/ansicleaner"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("NodeSass", func() {
+	var (
+		buildDir string
+		supplier *supply.Supplier
+		buffer   *bytes.Buffer
+		oldCheck string
+	)
+
+	writeFile := func(name, contents string) {
+		Expect(ioutil.WriteFile(filepath.Join(buildDir, name), []byte(contents), 0644)).To(Succeed())
+	}
+
+	BeforeEach(func() {
+		oldCheck = os.Getenv("BP_NODE_SASS_CHECK")
+		os.Unsetenv("BP_NODE_SASS_CHECK")
+
+		var err error
+		buildDir, err = ioutil.TempDir("", "nodejs-buildpack.build.")
+		Expect(err).To(BeNil())
+
+		buffer = new(bytes.Buffer)
+		logger := libbuildpack.NewLogger(ansicleaner.New(buffer))
+		supplier = &supply.Supplier{
+			Stager:           libbuildpack.NewStager([]string{buildDir, "", "", "0"}, logger, &libbuildpack.Manifest{}),
+			Log:              logger,
+			ExactNodeVersion: "18.20.4",
+		}
+	})
+
+	AfterEach(func() {
+		os.Setenv("BP_NODE_SASS_CHECK", oldCheck)
+		Expect(os.RemoveAll(buildDir)).To(Succeed())
+	})
+
+	DescribeTable("NodeSassMaxNode",
+		func(spec string, maxNode int64, found bool) {
+			actualMax, actualFound := supply.NodeSassMaxNode(spec)
+			Expect(actualFound).To(Equal(found))
+			Expect(actualMax).To(Equal(maxNode))
+		},
+		Entry("9.0.0", "9.0.0", int64(20), true),
+		Entry("8.0.0", "8.0.0", int64(19), true),
+		Entry("7.0.3", "7.0.3", int64(17), true),
+		Entry("6.0.1", "6.0.1", int64(16), true),
+		Entry("5.0.0", "5.0.0", int64(15), true),
+		Entry("4.14.1", "4.14.1", int64(14), true),
+		Entry("4.13.1", "4.13.1", int64(13), true),
+		Entry("4.12.0", "4.12.0", int64(12), true),
+		Entry("4.11.0", "4.11.0", int64(11), true),
+		Entry("4.9.4", "4.9.4", int64(10), true),
+		Entry("4.5.3", "4.5.3", int64(8), true),
+		Entry("older than the table", "3.4.2", int64(0), false),
+		Entry("a caret range", "^4.12.0", int64(14), true),
+		Entry("a caret range from a patch", "^4.14.1", int64(14), true),
+		Entry("a tilde range", "~4.13.1", int64(13), true),
+		Entry("a range over majors", ">=6 <8.0.0", int64(17), true),
+		Entry("a git URL", "git+https://github.com/sass/node-sass.git", int64(0), false),
+	)
+
+	Describe("CheckNodeSass", func() {
+		It("does nothing without node-sass", func() {
+			writeFile("package.json", `{"dependencies": {"sass": "^1.69.0"}}`)
+
+			Expect(supplier.CheckNodeSass()).To(Succeed())
+			Expect(buffer.String()).To(Equal(""))
+		})
+
+		It("accepts a node-sass which supports the node", func() {
+			writeFile("package.json", `{"devDependencies": {"node-sass": "^8.0.0"}}`)
+
+			Expect(supplier.CheckNodeSass()).To(Succeed())
+			Expect(buffer.String()).To(Equal(""))
+		})
+
+		It("fails for the node-sass of the lockfile over the spec of package.json", func() {
+			writeFile("package.json", `{"dependencies": {"node-sass": "*"}}`)
+			writeFile("package-lock.json", `{"lockfileVersion": 3, "packages": {
+				"": {"dependencies": {"node-sass": "*"}},
+				"node_modules/node-sass": {"version": "4.14.1", "resolved": "https://registry.npmjs.org/node-sass/-/node-sass-4.14.1.tgz", "integrity": "sha512-abc"}
+			}}`)
+
+			err := supplier.CheckNodeSass()
+			Expect(err).To(MatchError(ContainSubstring("node-sass 4.14.1 supports node 14 at most, but node 18.20.4 is installed")))
+			Expect(err).To(MatchError(ContainSubstring("npm uninstall node-sass && npm install --save-dev sass")))
+			Expect(err).To(MatchError(ContainSubstring("Set BP_NODE_SASS_CHECK=warn to install anyway")))
+			Expect(failure.CodeOf(err)).To(Equal(failure.NodeSassIncompatible))
+		})
+
+		It("fails for the spec of package.json without a lockfile", func() {
+			writeFile("package.json", `{"dependencies": {"node-sass": "^6.0.1"}}`)
+
+			Expect(supplier.CheckNodeSass()).To(MatchError(ContainSubstring("node-sass ^6.0.1 supports node 16 at most")))
+		})
+
+		It("only warns with BP_NODE_SASS_CHECK=warn", func() {
+			os.Setenv("BP_NODE_SASS_CHECK", "warn")
+			writeFile("package.json", `{"dependencies": {"node-sass": "^4.14.1"}}`)
+
+			Expect(supplier.CheckNodeSass()).To(Succeed())
+			Expect(buffer.String()).To(ContainSubstring("node-sass ^4.14.1 supports node 14 at most, but node 18.20.4 is installed"))
+			Expect(buffer.String()).To(ContainSubstring("or pin engines.node to 14.x in package.json"))
+		})
+
+		It("refuses an unknown BP_NODE_SASS_CHECK", func() {
+			os.Setenv("BP_NODE_SASS_CHECK", "off")
+
+			Expect(supplier.CheckNodeSass()).To(MatchError("BP_NODE_SASS_CHECK=off is not one of fail, warn"))
+		})
+	})
+})
@@ -0,0 +1,232 @@
+package supply
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"nodejs/network"
+	"nodejs/prune"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// npmConfigDefaults are the npm config values the buildpack sets unless the
+// user has set them. Runtime values are written to env files and also apply
+// when the app runs, the others only while staging. Extra holds further
+// environment variables which control the same feature.
+var npmConfigDefaults = []struct {
+	Key     string
+	Value   string
+	Runtime bool
+	Extra   []string
+}{
+	{Key: "production", Value: "true", Runtime: true},
+	{Key: "loglevel", Value: "error", Runtime: true},
+	{Key: "update-notifier", Value: "false", Extra: []string{"NO_UPDATE_NOTIFIER=1"}},
+	{Key: "fund", Value: "false"},
+	{Key: "audit", Value: "false"},
+}
+
+const defaultRegistry = "https://registry.npmjs.org/"
+
+// NPMConfigInput is what the npm config of a staging depends on.
+type NPMConfigInput struct {
+	// NPMRC is the app's .npmrc.
+	NPMRC   []byte
+	Environ []string
+	// Audit keeps npm audit on (BP_NPM_AUDIT).
+	Audit bool
+	// TokenAuth authenticates against the default registry (NPM_TOKEN).
+	TokenAuth bool
+	// PruneDev prunes devDependencies after the build (BP_PRUNE_OMIT).
+	PruneDev bool
+	// DeferProduction installs devDependencies although production=true is
+	// set in the environment, see DeferProduction.
+	DeferProduction bool
+	// Yarn apps are installed with yarn, which ignores the npm flags.
+	Yarn bool
+}
+
+// NPMConfig is the environment the buildpack adds for npm.
+type NPMConfig struct {
+	RuntimeEnv []string
+	StagingEnv []string
+	// Conflicts describe settings of the user which contradict each other or
+	// the buildpack, and how they were resolved.
+	Conflicts []string
+}
+
+// ResolveNPMConfig decides which npm config the buildpack sets. A value set
+// by the user in the environment, under any case of its npm_config_ name, or
+// in the app's .npmrc is never overridden.
+func ResolveNPMConfig(in NPMConfigInput) NPMConfig {
+	npmrc := npmrcValues(in.NPMRC)
+	// user returns the value npm sees for key, where the environment wins
+	// over .npmrc.
+	user := func(key string) (string, string, bool) {
+		name := "npm_config_" + strings.Replace(key, "-", "_", -1)
+		if value, found := envValue(in.Environ, name, true); found {
+			return value, strings.ToUpper(name), true
+		}
+		if value, found := npmrc[key]; found {
+			return value, ".npmrc", true
+		}
+		return "", "", false
+	}
+
+	var config NPMConfig
+	for _, setting := range npmConfigDefaults {
+		if setting.Key == "audit" && in.Audit {
+			continue
+		}
+		if _, _, found := user(setting.Key); found {
+			continue
+		}
+		if setting.Runtime {
+			config.RuntimeEnv = append(config.RuntimeEnv, "NPM_CONFIG_"+strings.ToUpper(strings.Replace(setting.Key, "-", "_", -1))+"="+setting.Value)
+			continue
+		}
+		config.StagingEnv = append(config.StagingEnv, "npm_config_"+strings.Replace(setting.Key, "-", "_", -1)+"="+setting.Value)
+		for _, extra := range setting.Extra {
+			if _, found := envValue(in.Environ, strings.SplitN(extra, "=", 2)[0], false); !found {
+				config.StagingEnv = append(config.StagingEnv, extra)
+			}
+		}
+	}
+
+	nodeEnv := ResolveNodeEnv(in.Environ).Build
+
+	production, source, found := user("production")
+	deferred := in.DeferProduction && found && production == "true" && source != ".npmrc"
+	if deferred {
+		// The variable of the user is overridden under its own name, as npm
+		// takes either of two spellings.
+		for _, env := range in.Environ {
+			if name := strings.SplitN(env, "=", 2)[0]; strings.EqualFold(name, "npm_config_production") {
+				config.StagingEnv = append(config.StagingEnv, name+"=false")
+			}
+		}
+		if _, found := envValue(in.Environ, "YARN_PRODUCTION", false); in.Yarn && !found {
+			config.StagingEnv = append(config.StagingEnv, "YARN_PRODUCTION=false")
+		}
+	}
+
+	if (deferred || found && production == "false") && nodeEnv == "production" && !in.Yarn {
+		_, _, include := user("include")
+		_, _, omit := user("omit")
+		if !include && !omit {
+			config.StagingEnv = append(config.StagingEnv, "npm_config_include=dev")
+			if !deferred {
+				conflict := fmt.Sprintf("production=false (%s) installs devDependencies, but npm 7 and later omit them when NODE_ENV=production: npm_config_include=dev is set so that they are installed", source)
+				if in.PruneDev {
+					conflict += ", and BP_PRUNE_OMIT prunes them after the build"
+				}
+				config.Conflicts = append(config.Conflicts, conflict)
+			}
+		}
+	}
+
+	if !in.Yarn {
+		for _, flag := range []struct{ key, reason string }{
+			{"cache", "npm install uses --cache in the app cache so that packages are kept between stagings"},
+			{"userconfig", "npm install uses --userconfig with the app's .npmrc"},
+		} {
+			if value, source, found := user(flag.key); found {
+				config.Conflicts = append(config.Conflicts, fmt.Sprintf("%s=%s (%s) is ignored: %s", flag.key, value, source, flag.reason))
+			}
+		}
+	}
+
+	if registry, source, found := user("registry"); found {
+		if _, err := network.ParseURL(registry); err != nil {
+			config.Conflicts = append(config.Conflicts, fmt.Sprintf("registry=%s (%s) is not a valid URL: %s", registry, source, err))
+		}
+	}
+
+	if registry, source, found := user("registry"); found && in.TokenAuth && strings.TrimSuffix(registry, "/") != strings.TrimSuffix(defaultRegistry, "/") {
+		config.Conflicts = append(config.Conflicts, fmt.Sprintf("NPM_TOKEN only authenticates against %s, not registry=%s (%s): configure the token for that registry in .npmrc", defaultRegistry, registry, source))
+	}
+
+	return config
+}
+
+// NPMRegistry returns the registry npm resolves a package from: the one
+// .npmrc sets for its scope, or else the registry set in the environment or
+// .npmrc, or the default one.
+func NPMRegistry(npmrc []byte, environ []string) func(name string) string {
+	values := npmrcValues(npmrc)
+	registry := defaultRegistry
+	if value, found := envValue(environ, "npm_config_registry", true); found && value != "" {
+		registry = value
+	} else if value := values["registry"]; value != "" {
+		registry = value
+	}
+
+	return func(name string) string {
+		if strings.HasPrefix(name, "@") {
+			scope := strings.SplitN(name, "/", 2)[0]
+			if value := values[strings.ToLower(scope)+":registry"]; value != "" {
+				return value
+			}
+		}
+		return registry
+	}
+}
+
+// npmrcValues returns the values set in an .npmrc, with npm's normalization
+// of _ to - in keys.
+func npmrcValues(contents []byte) map[string]string {
+	values := map[string]string{}
+	scanner := bufio.NewScanner(bytes.NewReader(contents))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		kv := strings.SplitN(line, "=", 2)
+		key := strings.ToLower(strings.Replace(strings.TrimSpace(kv[0]), "_", "-", -1))
+		value := ""
+		if len(kv) == 2 {
+			value = strings.Trim(strings.TrimSpace(kv[1]), `"'`)
+		}
+		values[key] = value
+	}
+	return values
+}
+
+func envValue(environ []string, name string, ignoreCase bool) (string, bool) {
+	for _, env := range environ {
+		kv := strings.SplitN(env, "=", 2)
+		if kv[0] == name || (ignoreCase && strings.EqualFold(kv[0], name)) {
+			if len(kv) == 2 {
+				return kv[1], true
+			}
+			return "", true
+		}
+	}
+	return "", false
+}
+
+func (s *Supplier) resolveNPMConfig() (NPMConfig, error) {
+	contents, err := ioutil.ReadFile(filepath.Join(s.Stager.BuildDir(), ".npmrc"))
+	if err != nil && !os.IsNotExist(err) {
+		return NPMConfig{}, err
+	}
+	omit, _ := prune.ParseOmit(os.Getenv("BP_PRUNE_OMIT"))
+	pruneDev := false
+	for _, kind := range omit {
+		pruneDev = pruneDev || kind == prune.Dev
+	}
+
+	return ResolveNPMConfig(NPMConfigInput{
+		NPMRC:           contents,
+		Environ:         os.Environ(),
+		Audit:           os.Getenv("BP_NPM_AUDIT") == "true" && !s.AuditSkipped,
+		TokenAuth:       os.Getenv("NPM_TOKEN") != "",
+		PruneDev:        pruneDev,
+		Yarn:            s.UseYarn,
+		DeferProduction: s.DeferredProduction,
+	}), nil
+}
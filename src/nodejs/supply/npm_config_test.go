@@ -0,0 +1,222 @@
+package supply_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"nodejs/supply"
+	"os"
+	"path/filepath"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/cloudfoundry/libbuildpack/ansicleaner"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("npm config", func() {
+	runtime := []string{"NPM_CONFIG_PRODUCTION=true", "NPM_CONFIG_LOGLEVEL=error"}
+	staging := []string{"npm_config_update_notifier=false", "NO_UPDATE_NOTIFIER=1", "npm_config_fund=false", "npm_config_audit=false"}
+
+	DescribeTable("ResolveNPMConfig",
+		func(in supply.NPMConfigInput, expected supply.NPMConfig) {
+			Expect(supply.ResolveNPMConfig(in)).To(Equal(expected))
+		},
+		Entry("nothing configured",
+			supply.NPMConfigInput{},
+			supply.NPMConfig{RuntimeEnv: runtime, StagingEnv: staging}),
+		Entry("audit requested",
+			supply.NPMConfigInput{Audit: true},
+			supply.NPMConfig{RuntimeEnv: runtime, StagingEnv: staging[:3]}),
+		Entry("production set in the environment",
+			supply.NPMConfigInput{Environ: []string{"NPM_CONFIG_PRODUCTION=true"}},
+			supply.NPMConfig{RuntimeEnv: runtime[1:], StagingEnv: staging}),
+		Entry("loglevel set in the environment in lowercase",
+			supply.NPMConfigInput{Environ: []string{"npm_config_loglevel=warn"}},
+			supply.NPMConfig{RuntimeEnv: runtime[:1], StagingEnv: staging}),
+		Entry("production and loglevel set in .npmrc",
+			supply.NPMConfigInput{NPMRC: []byte("production = true\nloglevel=http\n")},
+			supply.NPMConfig{StagingEnv: staging}),
+		Entry("settings commented out in .npmrc",
+			supply.NPMConfigInput{NPMRC: []byte("# production=false\n; loglevel=http\n")},
+			supply.NPMConfig{RuntimeEnv: runtime, StagingEnv: staging}),
+		Entry(".npmrc keys with underscores",
+			supply.NPMConfigInput{NPMRC: []byte("update_notifier=true\n")},
+			supply.NPMConfig{RuntimeEnv: runtime, StagingEnv: staging[2:]}),
+		Entry("NO_UPDATE_NOTIFIER set by the user",
+			supply.NPMConfigInput{Environ: []string{"NO_UPDATE_NOTIFIER=0"}},
+			supply.NPMConfig{RuntimeEnv: runtime, StagingEnv: []string{"npm_config_update_notifier=false", "npm_config_fund=false", "npm_config_audit=false"}}),
+		Entry("production=false with the default NODE_ENV",
+			supply.NPMConfigInput{Environ: []string{"NPM_CONFIG_PRODUCTION=false"}},
+			supply.NPMConfig{
+				RuntimeEnv: runtime[1:],
+				StagingEnv: append(append([]string{}, staging...), "npm_config_include=dev"),
+				Conflicts:  []string{"production=false (NPM_CONFIG_PRODUCTION) installs devDependencies, but npm 7 and later omit them when NODE_ENV=production: npm_config_include=dev is set so that they are installed"},
+			}),
+		Entry("production=false in .npmrc with NODE_ENV=production and pruning",
+			supply.NPMConfigInput{NPMRC: []byte("production=false\n"), Environ: []string{"NODE_ENV=production"}, PruneDev: true},
+			supply.NPMConfig{
+				RuntimeEnv: runtime[1:],
+				StagingEnv: append(append([]string{}, staging...), "npm_config_include=dev"),
+				Conflicts:  []string{"production=false (.npmrc) installs devDependencies, but npm 7 and later omit them when NODE_ENV=production: npm_config_include=dev is set so that they are installed, and BP_PRUNE_OMIT prunes them after the build"},
+			}),
+		Entry("production=false with another NODE_ENV",
+			supply.NPMConfigInput{Environ: []string{"NPM_CONFIG_PRODUCTION=false", "NODE_ENV=development"}},
+			supply.NPMConfig{RuntimeEnv: runtime[1:], StagingEnv: staging}),
+		Entry("production=false with a development build and a production runtime",
+			supply.NPMConfigInput{Environ: []string{"NPM_CONFIG_PRODUCTION=false", "BP_BUILD_NODE_ENV=development", "NODE_ENV=production"}},
+			supply.NPMConfig{RuntimeEnv: runtime[1:], StagingEnv: staging}),
+		Entry("production=false with a production build and a development runtime",
+			supply.NPMConfigInput{Environ: []string{"NPM_CONFIG_PRODUCTION=false", "BP_BUILD_NODE_ENV=production", "BP_RUNTIME_NODE_ENV=development"}},
+			supply.NPMConfig{
+				RuntimeEnv: runtime[1:],
+				StagingEnv: append(append([]string{}, staging...), "npm_config_include=dev"),
+				Conflicts:  []string{"production=false (NPM_CONFIG_PRODUCTION) installs devDependencies, but npm 7 and later omit them when NODE_ENV=production: npm_config_include=dev is set so that they are installed"},
+			}),
+		Entry("production=false with include set by the user",
+			supply.NPMConfigInput{Environ: []string{"NPM_CONFIG_PRODUCTION=false", "NPM_CONFIG_INCLUDE=dev"}},
+			supply.NPMConfig{RuntimeEnv: runtime[1:], StagingEnv: staging}),
+		Entry("production=false with omit set in .npmrc",
+			supply.NPMConfigInput{NPMRC: []byte("omit=optional\n"), Environ: []string{"NPM_CONFIG_PRODUCTION=false"}},
+			supply.NPMConfig{RuntimeEnv: runtime[1:], StagingEnv: staging}),
+		Entry("the environment wins over .npmrc for production",
+			supply.NPMConfigInput{NPMRC: []byte("production=false\n"), Environ: []string{"NPM_CONFIG_PRODUCTION=true"}},
+			supply.NPMConfig{RuntimeEnv: runtime[1:], StagingEnv: staging}),
+		Entry("production=false in a yarn app",
+			supply.NPMConfigInput{Environ: []string{"NPM_CONFIG_PRODUCTION=false"}, Yarn: true},
+			supply.NPMConfig{RuntimeEnv: runtime[1:], StagingEnv: staging}),
+		Entry("production=true deferred for the build scripts",
+			supply.NPMConfigInput{Environ: []string{"NPM_CONFIG_PRODUCTION=true"}, DeferProduction: true},
+			supply.NPMConfig{RuntimeEnv: runtime[1:], StagingEnv: append(append([]string{}, staging...), "NPM_CONFIG_PRODUCTION=false", "npm_config_include=dev")}),
+		Entry("production=true deferred under both spellings",
+			supply.NPMConfigInput{Environ: []string{"NPM_CONFIG_PRODUCTION=true", "npm_config_production=true", "NODE_ENV=development"}, DeferProduction: true},
+			supply.NPMConfig{RuntimeEnv: runtime[1:], StagingEnv: append(append([]string{}, staging...), "NPM_CONFIG_PRODUCTION=false", "npm_config_production=false")}),
+		Entry("production=true deferred in a yarn app",
+			supply.NPMConfigInput{Environ: []string{"NPM_CONFIG_PRODUCTION=true"}, DeferProduction: true, Yarn: true},
+			supply.NPMConfig{RuntimeEnv: runtime[1:], StagingEnv: append(append([]string{}, staging...), "NPM_CONFIG_PRODUCTION=false", "YARN_PRODUCTION=false")}),
+		Entry("production=true in .npmrc is not deferred",
+			supply.NPMConfigInput{NPMRC: []byte("production=true\n"), DeferProduction: true},
+			supply.NPMConfig{RuntimeEnv: runtime[1:], StagingEnv: staging}),
+		Entry("cache and userconfig set by the user",
+			supply.NPMConfigInput{NPMRC: []byte("cache=/tmp/npm\n"), Environ: []string{"NPM_CONFIG_USERCONFIG=/home/vcap/.npmrc"}},
+			supply.NPMConfig{
+				RuntimeEnv: runtime,
+				StagingEnv: staging,
+				Conflicts: []string{
+					"cache=/tmp/npm (.npmrc) is ignored: npm install uses --cache in the app cache so that packages are kept between stagings",
+					"userconfig=/home/vcap/.npmrc (NPM_CONFIG_USERCONFIG) is ignored: npm install uses --userconfig with the app's .npmrc",
+				},
+			}),
+		Entry("cache set by the user in a yarn app",
+			supply.NPMConfigInput{NPMRC: []byte("cache=/tmp/npm\n"), Yarn: true},
+			supply.NPMConfig{RuntimeEnv: runtime, StagingEnv: staging}),
+		Entry("NPM_TOKEN with a private registry",
+			supply.NPMConfigInput{NPMRC: []byte("registry=https://npm.example.com/\n"), TokenAuth: true},
+			supply.NPMConfig{
+				RuntimeEnv: runtime,
+				StagingEnv: staging,
+				Conflicts:  []string{"NPM_TOKEN only authenticates against https://registry.npmjs.org/, not registry=https://npm.example.com/ (.npmrc): configure the token for that registry in .npmrc"},
+			}),
+		Entry("NPM_TOKEN with the default registry",
+			supply.NPMConfigInput{Environ: []string{"npm_config_registry=https://registry.npmjs.org"}, TokenAuth: true},
+			supply.NPMConfig{RuntimeEnv: runtime, StagingEnv: staging}),
+		Entry("a private registry without NPM_TOKEN",
+			supply.NPMConfigInput{NPMRC: []byte("registry=https://npm.example.com/\n")},
+			supply.NPMConfig{RuntimeEnv: runtime, StagingEnv: staging}),
+		Entry("a registry on a bracketed IPv6 address",
+			supply.NPMConfigInput{Environ: []string{"npm_config_registry=http://[fd00::1]:4873/"}},
+			supply.NPMConfig{RuntimeEnv: runtime, StagingEnv: staging}),
+		Entry("a registry on an IPv6 address without brackets",
+			supply.NPMConfigInput{NPMRC: []byte("registry=http://fd00::1:4873/\n")},
+			supply.NPMConfig{
+				RuntimeEnv: runtime,
+				StagingEnv: staging,
+				Conflicts:  []string{"registry=http://fd00::1:4873/ (.npmrc) is not a valid URL: IPv6 addresses need brackets, like http://[fd00::1]:8080/"},
+			}),
+	)
+
+	DescribeTable("NPMRegistry",
+		func(npmrc string, environ []string, name, expected string) {
+			Expect(supply.NPMRegistry([]byte(npmrc), environ)(name)).To(Equal(expected))
+		},
+		Entry("the default registry", "", nil, "express", "https://registry.npmjs.org/"),
+		Entry("the .npmrc registry", "registry=https://npm.example.com/\n", nil, "express", "https://npm.example.com/"),
+		Entry("the environment over .npmrc", "registry=https://npm.example.com/\n", []string{"NPM_CONFIG_REGISTRY=https://env.example.com/"}, "express", "https://env.example.com/"),
+		Entry("the registry of a scope", "@corp:registry=https://corp.example.com/\n", nil, "@corp/api", "https://corp.example.com/"),
+		Entry("another scope", "@corp:registry=https://corp.example.com/\n", nil, "@types/node", "https://registry.npmjs.org/"),
+	)
+
+	Describe("Supplier", func() {
+		var (
+			buildDir string
+			depsDir  string
+			supplier *supply.Supplier
+			buffer   *bytes.Buffer
+			oldEnv   map[string]string
+		)
+
+		keys := []string{"NPM_CONFIG_PRODUCTION", "NPM_CONFIG_LOGLEVEL", "NODE_ENV", "npm_config_update_notifier", "NO_UPDATE_NOTIFIER", "npm_config_fund", "npm_config_audit", "npm_config_include", "BP_PRUNE_OMIT", "NPM_TOKEN"}
+
+		BeforeEach(func() {
+			var err error
+			buildDir, err = ioutil.TempDir("", "nodejs-buildpack.build.")
+			Expect(err).To(BeNil())
+			depsDir, err = ioutil.TempDir("", "nodejs-buildpack.deps.")
+			Expect(err).To(BeNil())
+			Expect(os.MkdirAll(filepath.Join(depsDir, "0"), 0755)).To(Succeed())
+
+			oldEnv = map[string]string{}
+			for _, key := range keys {
+				if value, found := os.LookupEnv(key); found {
+					oldEnv[key] = value
+				}
+				Expect(os.Unsetenv(key)).To(Succeed())
+			}
+
+			buffer = new(bytes.Buffer)
+			logger := libbuildpack.NewLogger(ansicleaner.New(buffer))
+			supplier = &supply.Supplier{
+				Stager: libbuildpack.NewStager([]string{buildDir, "", depsDir, "0"}, logger, &libbuildpack.Manifest{}),
+				Log:    logger,
+			}
+		})
+
+		AfterEach(func() {
+			for _, key := range keys {
+				if value, found := oldEnv[key]; found {
+					Expect(os.Setenv(key, value)).To(Succeed())
+				} else {
+					Expect(os.Unsetenv(key)).To(Succeed())
+				}
+			}
+			Expect(os.RemoveAll(buildDir)).To(Succeed())
+			Expect(os.RemoveAll(depsDir)).To(Succeed())
+		})
+
+		It("does not write runtime npm config set in .npmrc", func() {
+			Expect(ioutil.WriteFile(filepath.Join(buildDir, ".npmrc"), []byte("loglevel=warn\n"), 0644)).To(Succeed())
+
+			Expect(supplier.CreateDefaultEnv()).To(Succeed())
+
+			Expect(ioutil.ReadFile(filepath.Join(depsDir, "0", "env", "NPM_CONFIG_PRODUCTION"))).To(Equal([]byte("true")))
+			_, err := os.Stat(filepath.Join(depsDir, "0", "env", "NPM_CONFIG_LOGLEVEL"))
+			Expect(os.IsNotExist(err)).To(BeTrue())
+		})
+
+		It("reports all conflicts in a single warning", func() {
+			os.Setenv("NPM_CONFIG_PRODUCTION", "false")
+			os.Setenv("BP_PRUNE_OMIT", "dev")
+			Expect(ioutil.WriteFile(filepath.Join(buildDir, ".npmrc"), []byte("cache=/tmp/npm\n"), 0644)).To(Succeed())
+
+			Expect(supplier.SetupNPMStagingDefaults()).To(Succeed())
+			defer supplier.CleanupNPMStagingDefaults()
+
+			Expect(os.Getenv("npm_config_include")).To(Equal("dev"))
+			Expect(bytes.Count(buffer.Bytes(), []byte("**WARNING**"))).To(Equal(1))
+			Expect(buffer.String()).To(ContainSubstring("Resolved conflicting npm config:"))
+			Expect(buffer.String()).To(ContainSubstring("- production=false (NPM_CONFIG_PRODUCTION) installs devDependencies"))
+			Expect(buffer.String()).To(ContainSubstring("and BP_PRUNE_OMIT prunes them after the build"))
+			Expect(buffer.String()).To(ContainSubstring("- cache=/tmp/npm (.npmrc) is ignored"))
+		})
+	})
+})
@@ -0,0 +1,206 @@
+package supply
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"nodejs/netbudget"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const npmTokenAuthLine = "//registry.npmjs.org/:_authToken=${NPM_TOKEN}"
+
+// SetupNPMTokenAuth generates a build-time npm config which authenticates
+// against the default registry using $NPM_TOKEN. The token is referenced by
+// name rather than inlined, and the file is removed by CleanupNPMTokenAuth.
+func (s *Supplier) SetupNPMTokenAuth() error {
+	if os.Getenv("NPM_TOKEN") == "" {
+		return nil
+	}
+
+	if os.Getenv("YARN_NPM_AUTH_TOKEN") == "" {
+		if err := os.Setenv("YARN_NPM_AUTH_TOKEN", os.Getenv("NPM_TOKEN")); err != nil {
+			return err
+		}
+	}
+
+	userNpmrc := filepath.Join(s.Stager.BuildDir(), ".npmrc")
+	contents, err := ioutil.ReadFile(userNpmrc)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	userNpmrcExists := err == nil
+
+	if userNpmrcExists && npmrcHasAuth(contents) {
+		s.Log.Info("NPM_TOKEN is set but .npmrc already configures registry authentication, using .npmrc")
+		return nil
+	}
+
+	path := userNpmrc
+	if userNpmrcExists {
+		// Leave the app's .npmrc untouched and layer the auth config underneath it
+		tmpDir, err := ioutil.TempDir("", "nodejs-buildpack.npmrc")
+		if err != nil {
+			return err
+		}
+		path = filepath.Join(tmpDir, "npmrc")
+		if err := os.Setenv("NPM_CONFIG_GLOBALCONFIG", path); err != nil {
+			return err
+		}
+	}
+
+	if err := ioutil.WriteFile(path, []byte(npmTokenConfig(os.Getenv("BP_NPM_AUTH_SCOPES"))), 0600); err != nil {
+		return err
+	}
+	s.GeneratedNPMRC = path
+
+	s.Log.Info("Generated build-time npm config for NPM_TOKEN authentication")
+	return nil
+}
+
+// CleanupNPMTokenAuth removes the npm config written by SetupNPMTokenAuth so
+// that it never ends up in the droplet.
+func (s *Supplier) CleanupNPMTokenAuth() error {
+	if s.GeneratedNPMRC == "" {
+		return nil
+	}
+
+	path := s.GeneratedNPMRC
+	s.GeneratedNPMRC = ""
+
+	if filepath.Dir(path) != s.Stager.BuildDir() {
+		os.Unsetenv("NPM_CONFIG_GLOBALCONFIG")
+		return os.RemoveAll(filepath.Dir(path))
+	}
+
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func npmTokenConfig(scopes string) string {
+	var config bytes.Buffer
+
+	for _, scope := range strings.Split(scopes, ",") {
+		scope = strings.TrimSpace(scope)
+		if scope == "" {
+			continue
+		}
+		if !strings.HasPrefix(scope, "@") {
+			scope = "@" + scope
+		}
+		config.WriteString(scope + ":registry=https://registry.npmjs.org/\n")
+	}
+	config.WriteString(npmTokenAuthLine + "\n")
+
+	return config.String()
+}
+
+func npmrcHasAuth(contents []byte) bool {
+	scanner := bufio.NewScanner(bytes.NewReader(contents))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		for _, key := range []string{"_authToken", "_auth", "_password"} {
+			if strings.Contains(line, key+"=") || strings.Contains(line, key+" =") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// NPMStagingDefaults returns the environment which turns off npm's update
+// notifier, funding messages and, unless audit is requested, audits, leaving
+// out settings from the app's .npmrc or environ. See ResolveNPMConfig.
+func NPMStagingDefaults(npmrc []byte, environ []string, audit bool) []string {
+	return ResolveNPMConfig(NPMConfigInput{NPMRC: npmrc, Environ: environ, Audit: audit}).StagingEnv
+}
+
+// SetupNPMStagingDefaults applies the staging part of ResolveNPMConfig to
+// the environment of the staging process, and reports its conflicts. Nothing is written into the app, and
+// CleanupNPMStagingDefaults removes the variables again, or restores the values
+// the user had set.
+func (s *Supplier) SetupNPMStagingDefaults() error {
+	s.DeferredProduction = !s.IsVendored && DeferProduction(os.Environ(), s.hasBuildScripts())
+	if s.DeferredProduction {
+		s.Log.Info("NPM_CONFIG_PRODUCTION=true is deferred to pruning after the build, so that the build scripts get devDependencies\nSet BP_INSTALL_PRODUCTION_ONLY=true to install production dependencies only")
+	}
+	if os.Getenv("BP_NPM_AUDIT") == "true" {
+		if err := s.budgetAudit(); err != nil {
+			return err
+		}
+	}
+
+	config, err := s.resolveNPMConfig()
+	if err != nil {
+		return err
+	}
+	if len(config.Conflicts) > 0 {
+		s.Log.Warning("Resolved conflicting npm config:\n- %s", strings.Join(config.Conflicts, "\n- "))
+	}
+
+	env := config.StagingEnv
+	for _, e := range env {
+		kv := strings.SplitN(e, "=", 2)
+		if value, found := os.LookupEnv(kv[0]); found {
+			if s.NPMStagingSaved == nil {
+				s.NPMStagingSaved = map[string]string{}
+			}
+			s.NPMStagingSaved[kv[0]] = value
+		}
+		if err := os.Setenv(kv[0], kv[1]); err != nil {
+			return err
+		}
+		s.NPMStagingEnv = append(s.NPMStagingEnv, kv[0])
+	}
+	if len(env) > 0 {
+		s.Log.Debug("Applied npm staging defaults: %s", strings.Join(env, " "))
+	}
+	return nil
+}
+
+// budgetAudit takes the turn of the audit in the optional network budget.
+// npm audits as part of the install, whose time can't be told apart and is
+// not charged to the budget, so that only an open breaker or an exhausted
+// budget turn it off.
+func (s *Supplier) budgetAudit() error {
+	network, err := netbudget.Staging(s.Stager.DepDir())
+	if err != nil {
+		return err
+	}
+	call, err := network.Start("npm audit")
+	if err != nil {
+		s.Log.Info("Skipping npm audit: %s", err)
+		s.AuditSkipped = true
+	} else {
+		call.Unmeasured("it runs as part of npm install")
+	}
+	return network.Save()
+}
+
+func (s *Supplier) CleanupNPMStagingDefaults() {
+	s.restoreNPMStagingEnv(func(string) bool { return true })
+	s.NPMStagingSaved = nil
+}
+
+// restoreNPMStagingEnv removes or restores the variables of NPMStagingEnv
+// for which match returns true, ahead of the others.
+func (s *Supplier) restoreNPMStagingEnv(match func(name string) bool) {
+	var rest []string
+	for _, name := range s.NPMStagingEnv {
+		if !match(name) {
+			rest = append(rest, name)
+		} else if value, found := s.NPMStagingSaved[name]; found {
+			os.Setenv(name, value)
+		} else {
+			os.Unsetenv(name)
+		}
+	}
+	s.NPMStagingEnv = rest
+}
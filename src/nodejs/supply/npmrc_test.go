@@ -0,0 +1,281 @@
+package supply_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"nodejs/netbudget"
+	"nodejs/supply"
+	"os"
+	"path/filepath"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/cloudfoundry/libbuildpack/ansicleaner"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("NPM_TOKEN authentication", func() {
+	var (
+		err      error
+		buildDir string
+		depsDir  string
+		supplier *supply.Supplier
+		buffer   *bytes.Buffer
+		oldEnv   map[string]string
+	)
+
+	BeforeEach(func() {
+		buildDir, err = ioutil.TempDir("", "nodejs-buildpack.build.")
+		Expect(err).To(BeNil())
+		depsDir, err = ioutil.TempDir("", "nodejs-buildpack.deps.")
+		Expect(err).To(BeNil())
+
+		oldEnv = map[string]string{}
+		for _, key := range []string{"NPM_TOKEN", "YARN_NPM_AUTH_TOKEN", "BP_NPM_AUTH_SCOPES", "NPM_CONFIG_GLOBALCONFIG"} {
+			oldEnv[key] = os.Getenv(key)
+			os.Unsetenv(key)
+		}
+		os.Setenv("NPM_TOKEN", "abc-secret-123")
+
+		buffer = new(bytes.Buffer)
+		logger := libbuildpack.NewLogger(ansicleaner.New(buffer))
+		args := []string{buildDir, "", depsDir, "0"}
+		supplier = &supply.Supplier{
+			Stager: libbuildpack.NewStager(args, logger, &libbuildpack.Manifest{}),
+			Log:    logger,
+		}
+	})
+
+	AfterEach(func() {
+		for key, value := range oldEnv {
+			os.Setenv(key, value)
+		}
+		Expect(os.RemoveAll(buildDir)).To(Succeed())
+		Expect(os.RemoveAll(depsDir)).To(Succeed())
+	})
+
+	Context("NPM_TOKEN is not set", func() {
+		BeforeEach(func() {
+			os.Unsetenv("NPM_TOKEN")
+		})
+
+		It("does not generate an .npmrc", func() {
+			Expect(supplier.SetupNPMTokenAuth()).To(Succeed())
+			Expect(filepath.Join(buildDir, ".npmrc")).NotTo(BeAnExistingFile())
+			Expect(supplier.GeneratedNPMRC).To(Equal(""))
+		})
+	})
+
+	Context("the app has no .npmrc", func() {
+		It("generates an .npmrc referencing the token by name", func() {
+			Expect(supplier.SetupNPMTokenAuth()).To(Succeed())
+
+			contents, err := ioutil.ReadFile(filepath.Join(buildDir, ".npmrc"))
+			Expect(err).To(BeNil())
+			Expect(string(contents)).To(Equal("//registry.npmjs.org/:_authToken=${NPM_TOKEN}\n"))
+		})
+
+		It("never logs the token value", func() {
+			Expect(supplier.SetupNPMTokenAuth()).To(Succeed())
+			Expect(buffer.String()).NotTo(ContainSubstring("abc-secret-123"))
+		})
+
+		It("exposes the token to yarn berry", func() {
+			Expect(supplier.SetupNPMTokenAuth()).To(Succeed())
+			Expect(os.Getenv("YARN_NPM_AUTH_TOKEN")).To(Equal("abc-secret-123"))
+		})
+
+		It("removes the generated file on cleanup", func() {
+			Expect(supplier.SetupNPMTokenAuth()).To(Succeed())
+			Expect(supplier.CleanupNPMTokenAuth()).To(Succeed())
+			Expect(filepath.Join(buildDir, ".npmrc")).NotTo(BeAnExistingFile())
+		})
+
+		Context("BP_NPM_AUTH_SCOPES is set", func() {
+			BeforeEach(func() {
+				os.Setenv("BP_NPM_AUTH_SCOPES", "@acme, corp")
+			})
+
+			It("maps each scope to the default registry", func() {
+				Expect(supplier.SetupNPMTokenAuth()).To(Succeed())
+
+				contents, err := ioutil.ReadFile(filepath.Join(buildDir, ".npmrc"))
+				Expect(err).To(BeNil())
+				Expect(string(contents)).To(Equal("@acme:registry=https://registry.npmjs.org/\n" +
+					"@corp:registry=https://registry.npmjs.org/\n" +
+					"//registry.npmjs.org/:_authToken=${NPM_TOKEN}\n"))
+			})
+		})
+	})
+
+	Context("the app has an .npmrc which configures auth", func() {
+		const userNpmrc = "//registry.npmjs.org/:_authToken=${MY_TOKEN}\n"
+
+		BeforeEach(func() {
+			Expect(ioutil.WriteFile(filepath.Join(buildDir, ".npmrc"), []byte(userNpmrc), 0644)).To(Succeed())
+		})
+
+		It("leaves the .npmrc untouched", func() {
+			Expect(supplier.SetupNPMTokenAuth()).To(Succeed())
+			Expect(supplier.CleanupNPMTokenAuth()).To(Succeed())
+
+			contents, err := ioutil.ReadFile(filepath.Join(buildDir, ".npmrc"))
+			Expect(err).To(BeNil())
+			Expect(string(contents)).To(Equal(userNpmrc))
+			Expect(os.Getenv("NPM_CONFIG_GLOBALCONFIG")).To(Equal(""))
+		})
+	})
+
+	Context("the app has an .npmrc without auth", func() {
+		const userNpmrc = "save-exact=true\n"
+
+		BeforeEach(func() {
+			Expect(ioutil.WriteFile(filepath.Join(buildDir, ".npmrc"), []byte(userNpmrc), 0644)).To(Succeed())
+		})
+
+		It("layers the auth config outside the app dir", func() {
+			Expect(supplier.SetupNPMTokenAuth()).To(Succeed())
+
+			globalConfig := os.Getenv("NPM_CONFIG_GLOBALCONFIG")
+			Expect(globalConfig).NotTo(HavePrefix(buildDir))
+			contents, err := ioutil.ReadFile(globalConfig)
+			Expect(err).To(BeNil())
+			Expect(string(contents)).To(Equal("//registry.npmjs.org/:_authToken=${NPM_TOKEN}\n"))
+
+			contents, err = ioutil.ReadFile(filepath.Join(buildDir, ".npmrc"))
+			Expect(err).To(BeNil())
+			Expect(string(contents)).To(Equal(userNpmrc))
+
+			Expect(supplier.CleanupNPMTokenAuth()).To(Succeed())
+			Expect(globalConfig).NotTo(BeAnExistingFile())
+			Expect(filepath.Join(buildDir, ".npmrc")).To(BeAnExistingFile())
+		})
+	})
+})
+
+var _ = Describe("npm staging defaults", func() {
+	defaults := []string{"npm_config_update_notifier=false", "NO_UPDATE_NOTIFIER=1", "npm_config_fund=false", "npm_config_audit=false"}
+
+	DescribeTable("NPMStagingDefaults",
+		func(npmrc string, environ []string, audit bool, expected []string) {
+			Expect(supply.NPMStagingDefaults([]byte(npmrc), environ, audit)).To(Equal(expected))
+		},
+		Entry("nothing configured", "", nil, false, defaults),
+		Entry("audit requested with BP_NPM_AUDIT", "", nil, true, defaults[:3]),
+		Entry("the app's .npmrc wins over the defaults",
+			"registry=https://npm.example.com/\n# fund=true\nfund = true\naudit=true\n", nil, false,
+			[]string{"npm_config_update_notifier=false", "NO_UPDATE_NOTIFIER=1"}),
+		Entry(".npmrc keys with underscores", "update_notifier=true\n", nil, false, defaults[2:]),
+		Entry("the user's environment wins over the defaults",
+			"", []string{"NPM_CONFIG_FUND=true", "npm_config_audit=true"}, false,
+			[]string{"npm_config_update_notifier=false", "NO_UPDATE_NOTIFIER=1"}),
+		Entry("NO_UPDATE_NOTIFIER set by the user", "", []string{"NO_UPDATE_NOTIFIER=0"}, false,
+			[]string{"npm_config_update_notifier=false", "npm_config_fund=false", "npm_config_audit=false"}),
+		Entry("the app's .npmrc wins over the user's environment", "fund=true\n", []string{"npm_config_fund=false"}, false,
+			[]string{"npm_config_update_notifier=false", "NO_UPDATE_NOTIFIER=1", "npm_config_audit=false"}),
+	)
+
+	Describe("SetupNPMStagingDefaults", func() {
+		var (
+			buildDir string
+			depsDir  string
+			supplier *supply.Supplier
+			buffer   *bytes.Buffer
+			oldEnv   map[string]string
+		)
+
+		keys := []string{"npm_config_update_notifier", "NO_UPDATE_NOTIFIER", "npm_config_fund", "npm_config_audit", "BP_NPM_AUDIT", "BP_DEBUG", "BP_OPTIONAL_NETWORK_BUDGET", "BP_OFFLINE"}
+
+		BeforeEach(func() {
+			var err error
+			buildDir, err = ioutil.TempDir("", "nodejs-buildpack.build.")
+			Expect(err).To(BeNil())
+			depsDir, err = ioutil.TempDir("", "nodejs-buildpack.deps.")
+			Expect(err).To(BeNil())
+			Expect(os.MkdirAll(filepath.Join(depsDir, "0"), 0755)).To(Succeed())
+
+			oldEnv = map[string]string{}
+			for _, key := range keys {
+				if value, found := os.LookupEnv(key); found {
+					oldEnv[key] = value
+				}
+				Expect(os.Unsetenv(key)).To(Succeed())
+			}
+
+			buffer = new(bytes.Buffer)
+			logger := libbuildpack.NewLogger(ansicleaner.New(buffer))
+			supplier = &supply.Supplier{
+				Stager: libbuildpack.NewStager([]string{buildDir, "", depsDir, "0"}, logger, &libbuildpack.Manifest{}),
+				Log:    logger,
+			}
+		})
+
+		AfterEach(func() {
+			for _, key := range keys {
+				if value, found := oldEnv[key]; found {
+					Expect(os.Setenv(key, value)).To(Succeed())
+				} else {
+					Expect(os.Unsetenv(key)).To(Succeed())
+				}
+			}
+			Expect(os.RemoveAll(buildDir)).To(Succeed())
+			Expect(os.RemoveAll(depsDir)).To(Succeed())
+		})
+
+		It("sets the defaults until cleanup without writing into the app", func() {
+			os.Setenv("BP_DEBUG", "true")
+			Expect(ioutil.WriteFile(filepath.Join(buildDir, ".npmrc"), []byte("audit=true\n"), 0644)).To(Succeed())
+
+			Expect(supplier.SetupNPMStagingDefaults()).To(Succeed())
+			Expect(os.Getenv("npm_config_fund")).To(Equal("false"))
+			Expect(os.Getenv("NO_UPDATE_NOTIFIER")).To(Equal("1"))
+			_, found := os.LookupEnv("npm_config_audit")
+			Expect(found).To(BeFalse())
+			Expect(buffer.String()).To(ContainSubstring("Applied npm staging defaults: npm_config_update_notifier=false NO_UPDATE_NOTIFIER=1 npm_config_fund=false"))
+			Expect(ioutil.ReadFile(filepath.Join(buildDir, ".npmrc"))).To(Equal([]byte("audit=true\n")))
+
+			supplier.CleanupNPMStagingDefaults()
+			for _, key := range keys[:4] {
+				_, found := os.LookupEnv(key)
+				Expect(found).To(BeFalse())
+			}
+		})
+
+		It("only logs at debug level", func() {
+			Expect(supplier.SetupNPMStagingDefaults()).To(Succeed())
+			supplier.CleanupNPMStagingDefaults()
+			Expect(buffer.String()).To(Equal(""))
+		})
+
+		It("keeps the audit with BP_NPM_AUDIT", func() {
+			os.Setenv("BP_NPM_AUDIT", "true")
+			Expect(supplier.SetupNPMStagingDefaults()).To(Succeed())
+			_, found := os.LookupEnv("npm_config_audit")
+			Expect(found).To(BeFalse())
+			supplier.CleanupNPMStagingDefaults()
+
+			outcomes, err := netbudget.Finish(filepath.Join(depsDir, "0"))
+			Expect(err).To(BeNil())
+			Expect(outcomes).To(Equal([]netbudget.Outcome{{Feature: "npm audit", Status: netbudget.Unmeasured, Reason: "it runs as part of npm install"}}))
+		})
+
+		It("turns the audit off when the optional network budget is 0", func() {
+			os.Setenv("BP_NPM_AUDIT", "true")
+			os.Setenv("BP_OPTIONAL_NETWORK_BUDGET", "0")
+			Expect(supplier.SetupNPMStagingDefaults()).To(Succeed())
+			Expect(os.Getenv("npm_config_audit")).To(Equal("false"))
+			Expect(buffer.String()).To(ContainSubstring("Skipping npm audit: BP_OPTIONAL_NETWORK_BUDGET is 0"))
+			supplier.CleanupNPMStagingDefaults()
+		})
+
+		It("turns the audit off when staging is offline", func() {
+			os.Setenv("BP_NPM_AUDIT", "true")
+			os.Setenv("BP_OFFLINE", "true")
+			Expect(supplier.SetupNPMStagingDefaults()).To(Succeed())
+			Expect(os.Getenv("npm_config_audit")).To(Equal("false"))
+			Expect(buffer.String()).To(ContainSubstring("Skipping npm audit: staging is offline (BP_OFFLINE)"))
+			supplier.CleanupNPMStagingDefaults()
+		})
+	})
+})
@@ -0,0 +1,79 @@
+package supply
+
+import (
+	"nodejs/profiled"
+	"os"
+	"strings"
+)
+
+// OpenSSLLegacyProvider makes node 17 and later, which use OpenSSL 3, load
+// the legacy algorithms like md4 which webpack 4 hashes with.
+const OpenSSLLegacyProvider = "--openssl-legacy-provider"
+
+// openSSLFailure is how OpenSSL 3 fails legacy algorithms, in the lower case
+// of fileHasString.
+var openSSLFailure = []string{"err_ossl_evp_unsupported", "digital envelope routines::unsupported"}
+
+// SetupOpenSSLLegacyProvider adds --openssl-legacy-provider to NODE_OPTIONS
+// for the build scripts with BP_OPENSSL_LEGACY_PROVIDER=true, and at runtime
+// as well with BP_RUNTIME_OPENSSL_LEGACY_PROVIDER=true. node before 17 fails
+// on the flag, and has no need for it, so it is left out there.
+func (s *Supplier) SetupOpenSSLLegacyProvider() error {
+	build := os.Getenv("BP_OPENSSL_LEGACY_PROVIDER") == "true"
+	runtime := os.Getenv("BP_RUNTIME_OPENSSL_LEGACY_PROVIDER") == "true"
+	if !build && !runtime {
+		return nil
+	}
+
+	supported, err := nodeVersionMatches(s.ExactNodeVersion, ">=17.0.0")
+	if err != nil {
+		return err
+	}
+	if !supported {
+		s.Log.Warning("Ignoring BP_OPENSSL_LEGACY_PROVIDER: node %s does not support %s, and uses OpenSSL 1.1 which has the legacy algorithms", s.ExactNodeVersion, OpenSSLLegacyProvider)
+		return nil
+	}
+
+	if build {
+		s.Log.Info("Running node with %s while staging (BP_OPENSSL_LEGACY_PROVIDER)", OpenSSLLegacyProvider)
+		if err := os.Setenv("NODE_OPTIONS", AppendNodeOption(os.Getenv("NODE_OPTIONS"), OpenSSLLegacyProvider)); err != nil {
+			return err
+		}
+	}
+	if runtime {
+		s.Log.Info("Running node with %s at runtime (BP_RUNTIME_OPENSSL_LEGACY_PROVIDER)", OpenSSLLegacyProvider)
+		if err := profiled.Write(s.Stager, "openssl.sh", RuntimeNodeOptions(OpenSSLLegacyProvider)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AppendNodeOption adds option to the NODE_OPTIONS in options, unless it is
+// there already.
+func AppendNodeOption(options, option string) string {
+	for _, existing := range strings.Fields(options) {
+		if existing == option {
+			return options
+		}
+	}
+	if strings.TrimSpace(options) == "" {
+		return option
+	}
+	return options + " " + option
+}
+
+// WarnOpenSSLLegacyProvider explains the failure of build scripts which use
+// algorithms OpenSSL 3 dropped, usually webpack 4 on node 17 and later.
+func (s *Supplier) WarnOpenSSLLegacyProvider() error {
+	if strings.Contains(os.Getenv("NODE_OPTIONS"), OpenSSLLegacyProvider) {
+		return nil
+	}
+	if failed, err := fileHasString(s.Logfile.Name(), openSSLFailure...); err != nil || !failed {
+		return err
+	}
+
+	s.Log.Warning("The build failed with ERR_OSSL_EVP_UNSUPPORTED: node %s uses OpenSSL 3, which dropped the md4 hashes of webpack 4 and similar tools\n"+
+		"Upgrade to webpack 5, or set BP_OPENSSL_LEGACY_PROVIDER=true to build with %s", s.ExactNodeVersion, OpenSSLLegacyProvider)
+	return nil
+}
@@ -0,0 +1,179 @@
+package supply_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"nodejs/supply"
+	"os"
+	"path/filepath"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/cloudfoundry/libbuildpack/ansicleaner"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("OpenSSL legacy provider", func() {
+	var (
+		depsDir  string
+		logfile  *os.File
+		supplier *supply.Supplier
+		buffer   *bytes.Buffer
+		oldEnv   map[string]string
+	)
+
+	BeforeEach(func() {
+		oldEnv = map[string]string{}
+		for _, key := range []string{"BP_OPENSSL_LEGACY_PROVIDER", "BP_RUNTIME_OPENSSL_LEGACY_PROVIDER", "NODE_OPTIONS"} {
+			oldEnv[key] = os.Getenv(key)
+			os.Unsetenv(key)
+		}
+
+		var err error
+		depsDir, err = ioutil.TempDir("", "nodejs-buildpack.deps.")
+		Expect(err).To(BeNil())
+		Expect(os.MkdirAll(filepath.Join(depsDir, "0"), 0755)).To(Succeed())
+		logfile, err = ioutil.TempFile("", "nodejs-buildpack.logfile")
+		Expect(err).To(BeNil())
+
+		buffer = new(bytes.Buffer)
+		logger := libbuildpack.NewLogger(ansicleaner.New(buffer))
+		supplier = &supply.Supplier{
+			Stager:           libbuildpack.NewStager([]string{"", "", depsDir, "0"}, logger, &libbuildpack.Manifest{}),
+			Log:              logger,
+			Logfile:          logfile,
+			ExactNodeVersion: "18.20.4",
+		}
+	})
+
+	AfterEach(func() {
+		for key, value := range oldEnv {
+			os.Setenv(key, value)
+		}
+		logfile.Close()
+		Expect(os.Remove(logfile.Name())).To(Succeed())
+		Expect(os.RemoveAll(depsDir)).To(Succeed())
+	})
+
+	profileScript := func() string {
+		return filepath.Join(depsDir, "0", "profile.d", "000_nodejs_buildpack_openssl.sh")
+	}
+
+	DescribeTable("AppendNodeOption",
+		func(options, expected string) {
+			Expect(supply.AppendNodeOption(options, "--openssl-legacy-provider")).To(Equal(expected))
+		},
+		Entry("without NODE_OPTIONS", "", "--openssl-legacy-provider"),
+		Entry("with blank NODE_OPTIONS", "  ", "--openssl-legacy-provider"),
+		Entry("with other options", "--max-old-space-size=2048", "--max-old-space-size=2048 --openssl-legacy-provider"),
+		Entry("with the option already", "--openssl-legacy-provider --max-old-space-size=2048", "--openssl-legacy-provider --max-old-space-size=2048"),
+		Entry("with a longer option", "--openssl-legacy-provider-x", "--openssl-legacy-provider-x --openssl-legacy-provider"),
+	)
+
+	Describe("SetupOpenSSLLegacyProvider", func() {
+		It("does nothing by default", func() {
+			Expect(supplier.SetupOpenSSLLegacyProvider()).To(Succeed())
+			Expect(os.Getenv("NODE_OPTIONS")).To(Equal(""))
+			Expect(profileScript()).NotTo(BeAnExistingFile())
+			Expect(buffer.String()).To(BeEmpty())
+		})
+
+		It("adds the flag to NODE_OPTIONS while staging", func() {
+			os.Setenv("BP_OPENSSL_LEGACY_PROVIDER", "true")
+
+			Expect(supplier.SetupOpenSSLLegacyProvider()).To(Succeed())
+			Expect(os.Getenv("NODE_OPTIONS")).To(Equal("--openssl-legacy-provider"))
+			Expect(buffer.String()).To(ContainSubstring("Running node with --openssl-legacy-provider while staging (BP_OPENSSL_LEGACY_PROVIDER)"))
+			Expect(profileScript()).NotTo(BeAnExistingFile())
+		})
+
+		It("keeps the other NODE_OPTIONS", func() {
+			os.Setenv("BP_OPENSSL_LEGACY_PROVIDER", "true")
+			os.Setenv("NODE_OPTIONS", "--max-old-space-size=2048")
+
+			Expect(supplier.SetupOpenSSLLegacyProvider()).To(Succeed())
+			Expect(os.Getenv("NODE_OPTIONS")).To(Equal("--max-old-space-size=2048 --openssl-legacy-provider"))
+		})
+
+		It("adds the flag at runtime with BP_RUNTIME_OPENSSL_LEGACY_PROVIDER", func() {
+			os.Setenv("BP_RUNTIME_OPENSSL_LEGACY_PROVIDER", "true")
+			os.Setenv("NODE_OPTIONS", "--max-old-space-size=2048")
+
+			Expect(supplier.SetupOpenSSLLegacyProvider()).To(Succeed())
+			Expect(os.Getenv("NODE_OPTIONS")).To(Equal("--max-old-space-size=2048"))
+			contents, err := ioutil.ReadFile(profileScript())
+			Expect(err).To(BeNil())
+			Expect(string(contents)).To(Equal(supply.RuntimeNodeOptions("--openssl-legacy-provider")))
+		})
+
+		It("leaves the flag out for node before 17", func() {
+			os.Setenv("BP_OPENSSL_LEGACY_PROVIDER", "true")
+			os.Setenv("BP_RUNTIME_OPENSSL_LEGACY_PROVIDER", "true")
+			os.Setenv("NODE_OPTIONS", "--max-old-space-size=2048")
+			supplier.ExactNodeVersion = "16.20.2"
+
+			Expect(supplier.SetupOpenSSLLegacyProvider()).To(Succeed())
+			Expect(os.Getenv("NODE_OPTIONS")).To(Equal("--max-old-space-size=2048"))
+			Expect(profileScript()).NotTo(BeAnExistingFile())
+			Expect(buffer.String()).To(ContainSubstring("Ignoring BP_OPENSSL_LEGACY_PROVIDER: node 16.20.2 does not support --openssl-legacy-provider"))
+		})
+
+		It("adds the flag for node 17", func() {
+			os.Setenv("BP_OPENSSL_LEGACY_PROVIDER", "true")
+			supplier.ExactNodeVersion = "17.0.0"
+
+			Expect(supplier.SetupOpenSSLLegacyProvider()).To(Succeed())
+			Expect(os.Getenv("NODE_OPTIONS")).To(Equal("--openssl-legacy-provider"))
+		})
+	})
+
+	Describe("WarnOpenSSLLegacyProvider", func() {
+		const webpackFailure = `> shop@1.0.0 build
+> webpack --mode production
+
+node:internal/crypto/hash:69
+  this[kHandle] = new _Hash(algorithm, xofLen);
+                  ^
+
+Error: error:0308010C:digital envelope routines::unsupported
+    at new Hash (node:internal/crypto/hash:69:19)
+    at Object.createHash (node:crypto:133:10) {
+  opensslErrorStack: [ 'error:03000086:digital envelope routines::initialization error' ],
+  library: 'digital envelope routines',
+  reason: 'unsupported',
+  code: 'ERR_OSSL_EVP_UNSUPPORTED'
+}
+`
+
+		It("points build failures of OpenSSL 3 to the flag", func() {
+			Expect(ioutil.WriteFile(logfile.Name(), []byte(webpackFailure), 0644)).To(Succeed())
+
+			Expect(supplier.WarnOpenSSLLegacyProvider()).To(Succeed())
+			Expect(buffer.String()).To(ContainSubstring("**WARNING** The build failed with ERR_OSSL_EVP_UNSUPPORTED: node 18.20.4 uses OpenSSL 3"))
+			Expect(buffer.String()).To(ContainSubstring("Upgrade to webpack 5, or set BP_OPENSSL_LEGACY_PROVIDER=true to build with --openssl-legacy-provider"))
+		})
+
+		It("recognizes the message without the code", func() {
+			Expect(ioutil.WriteFile(logfile.Name(), []byte("Error: error:0308010C:digital envelope routines::unsupported\n"), 0644)).To(Succeed())
+
+			Expect(supplier.WarnOpenSSLLegacyProvider()).To(Succeed())
+			Expect(buffer.String()).To(ContainSubstring("ERR_OSSL_EVP_UNSUPPORTED"))
+		})
+
+		It("says nothing when the flag was set", func() {
+			Expect(ioutil.WriteFile(logfile.Name(), []byte(webpackFailure), 0644)).To(Succeed())
+			os.Setenv("NODE_OPTIONS", "--openssl-legacy-provider")
+
+			Expect(supplier.WarnOpenSSLLegacyProvider()).To(Succeed())
+			Expect(buffer.String()).To(BeEmpty())
+		})
+
+		It("says nothing for other output", func() {
+			Expect(ioutil.WriteFile(logfile.Name(), []byte("webpack 4.46.0 compiled successfully\n"), 0644)).To(Succeed())
+
+			Expect(supplier.WarnOpenSSLLegacyProvider()).To(Succeed())
+			Expect(buffer.String()).To(BeEmpty())
+		})
+	})
+})
@@ -0,0 +1,134 @@
+package supply
+
+import (
+	"fmt"
+	"nodejs/nodeoptions"
+	"nodejs/profiled"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/cloudfoundry/libbuildpack"
+)
+
+// OperatorNodeOptionsFile is where a platform operator packages the
+// NODE_OPTIONS every app runs with into the buildpack, listing it in the
+// include_files of manifest.yml, like
+//
+//	node_options:
+//	- --max-http-header-size=16384
+//	- --tls-cipher-list="TLS_AES_256_GCM_SHA384:ECDHE-RSA-AES256-GCM-SHA384"
+const OperatorNodeOptionsFile = "operator/node_options.yml"
+
+// operatorOptionName matches the names of the flags the profile.d script can
+// safely put into its sed expressions.
+var operatorOptionName = regexp.MustCompile(`^--?[A-Za-z0-9][A-Za-z0-9_-]*$`)
+
+// OperatorNodeOptions returns the NODE_OPTIONS of the platform operator:
+// those of OperatorNodeOptionsFile in rootDir, the buildpack dir, followed
+// by those of BP_OPERATOR_NODE_OPTIONS in the staging environment.
+func OperatorNodeOptions(rootDir string) ([]nodeoptions.Option, error) {
+	var file struct {
+		NodeOptions []string `yaml:"node_options"`
+	}
+	path := filepath.Join(rootDir, OperatorNodeOptionsFile)
+	if found, err := libbuildpack.FileExists(path); err != nil {
+		return nil, err
+	} else if found {
+		if err := libbuildpack.NewYAML().Load(path, &file); err != nil {
+			return nil, fmt.Errorf("%s is not valid: %s", OperatorNodeOptionsFile, err)
+		}
+	}
+
+	var options []nodeoptions.Option
+	for _, s := range []struct {
+		source string
+		values []string
+	}{
+		{OperatorNodeOptionsFile, file.NodeOptions},
+		{"BP_OPERATOR_NODE_OPTIONS", []string{os.Getenv("BP_OPERATOR_NODE_OPTIONS")}},
+	} {
+		for _, value := range s.values {
+			parsed, err := nodeoptions.Parse(value)
+			if err != nil {
+				return nil, fmt.Errorf("the NODE_OPTIONS of %s are not valid: %s", s.source, err)
+			}
+			for _, option := range parsed {
+				if !operatorOptionName.MatchString(option.Name) {
+					return nil, fmt.Errorf("the NODE_OPTIONS of %s have %s, which is not the name of a node flag", s.source, option.Name)
+				}
+			}
+			options = append(options, parsed...)
+		}
+	}
+	return options, nil
+}
+
+// OperatorNodeOptionsScript returns the profile.d script which merges the
+// NODE_OPTIONS of the operator into those of the app at boot, as
+// nodeoptions.Merge does: it removes each single value flag of the operator
+// from NODE_OPTIONS, whatever its value, and appends those of the operator,
+// leaving out the repeatable ones NODE_OPTIONS has already.
+func OperatorNodeOptionsScript(options []nodeoptions.Option) string {
+	var removals []string
+	var lines []string
+	for _, option := range options {
+		if option.Repeatable() {
+			lines = append(lines, fmt.Sprintf(`case " ${NODE_OPTIONS-} " in *" %[1]s "*) ;; *) export NODE_OPTIONS="${NODE_OPTIONS:+$NODE_OPTIONS }%[1]s" ;; esac`, shellDoubleQuoteEscape(option.String())))
+			continue
+		}
+
+		// V8 flags are spelled with dashes or underscores alike.
+		name := option.Key()
+		if strings.HasPrefix(name, "--") {
+			name = "--" + strings.Replace(name[2:], "-", "[-_]", -1)
+		}
+		value := `(=("[^"]*"|[^[:space:]]*))?`
+		if option.SeparateValue() {
+			value = `(=("[^"]*"|[^[:space:]]*)|[[:space:]]+("[^"]*"|[^-[:space:]][^[:space:]]*))?`
+		}
+		removals = append(removals, fmt.Sprintf(`-e 's/[[:space:]]%s%s[[:space:]]/ /'`, name, value))
+		lines = append(lines, fmt.Sprintf(`export NODE_OPTIONS="${NODE_OPTIONS:+$NODE_OPTIONS }%s"`, shellDoubleQuoteEscape(option.String())))
+	}
+
+	script := "# The NODE_OPTIONS of the platform operator, which win over those of the app.\n"
+	if len(removals) > 0 {
+		script += fmt.Sprintf(`NODE_OPTIONS=$(printf ' %%s ' "${NODE_OPTIONS-}" | sed -E -e ':a' %s -e 'ta' -e 's/^[[:space:]]+//' -e 's/[[:space:]]+$//')`, strings.Join(removals, " ")) + "\n"
+	}
+	return script + strings.Join(lines, "\n") + "\n"
+}
+
+// shellDoubleQuoteEscape escapes s for a double quoted shell string.
+func shellDoubleQuoteEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "$", `\$`, "`", "\\`").Replace(s)
+}
+
+// SetupOperatorNodeOptions appends the NODE_OPTIONS of the platform
+// operator, from OperatorNodeOptionsFile or BP_OPERATOR_NODE_OPTIONS, to
+// those of the app at runtime. A flag the app sets to another value is
+// overridden, which is logged.
+func (s *Supplier) SetupOperatorNodeOptions() error {
+	options, err := OperatorNodeOptions(s.Manifest.RootDir())
+	if err != nil {
+		return err
+	}
+	if len(options) == 0 {
+		return nil
+	}
+	options, _ = nodeoptions.Merge(nil, options)
+
+	if appOptions, err := nodeoptions.Parse(os.Getenv("NODE_OPTIONS")); err != nil {
+		s.Log.Warning("Unable to check NODE_OPTIONS against those of the platform operator: %s", err)
+	} else {
+		_, conflicts := nodeoptions.Merge(appOptions, options)
+		for _, conflict := range conflicts {
+			s.Log.Info("NODE_OPTIONS sets %s, but the platform operator sets %s, which wins", conflict.Option, conflict.Override)
+		}
+	}
+
+	s.Log.Info("Running node with %s at runtime, as the platform operator requires", nodeoptions.Format(options))
+	// The name sorts after the other scripts of the buildpack, so that the
+	// operator has the last word on NODE_OPTIONS.
+	return profiled.Write(s.Stager, "zz_operator_node_options.sh", OperatorNodeOptionsScript(options))
+}
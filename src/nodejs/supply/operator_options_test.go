@@ -0,0 +1,136 @@
+package supply_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"nodejs/supply"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/cloudfoundry/libbuildpack/ansicleaner"
+	"golang.google.cn/x/mock/gomock"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("OperatorNodeOptions", func() {
+	var (
+		err          error
+		bpDir        string
+		depsDir      string
+		supplier     *supply.Supplier
+		buffer       *bytes.Buffer
+		mockCtrl     *gomock.Controller
+		mockManifest *MockManifest
+		oldEnv       map[string]string
+	)
+
+	const operatorFile = `node_options:
+- --max-http-header-size=16384
+- --tls-cipher-list="TLS_AES_256_GCM_SHA384:ECDHE-RSA-AES256-GCM-SHA384"
+- -r /opt/audit.js
+`
+
+	profileScript := func() string {
+		return filepath.Join(depsDir, "0", "profile.d", "000_nodejs_buildpack_zz_operator_node_options.sh")
+	}
+
+	// boot sources the profile.d script with NODE_OPTIONS as the app sets
+	// them, and returns the NODE_OPTIONS node runs with.
+	boot := func(nodeOptions string) string {
+		cmd := exec.Command("sh", "-c", `. "$0" && printf '%s' "$NODE_OPTIONS"`, profileScript())
+		cmd.Env = []string{"PATH=" + os.Getenv("PATH"), "NODE_OPTIONS=" + nodeOptions}
+		output, err := cmd.CombinedOutput()
+		Expect(err).To(BeNil(), string(output))
+		return string(output)
+	}
+
+	BeforeEach(func() {
+		oldEnv = map[string]string{}
+		for _, key := range []string{"BP_OPERATOR_NODE_OPTIONS", "NODE_OPTIONS"} {
+			oldEnv[key] = os.Getenv(key)
+			os.Unsetenv(key)
+		}
+
+		bpDir, err = ioutil.TempDir("", "nodejs-buildpack.bp.")
+		Expect(err).To(BeNil())
+		depsDir, err = ioutil.TempDir("", "nodejs-buildpack.deps.")
+		Expect(err).To(BeNil())
+		Expect(os.MkdirAll(filepath.Join(depsDir, "0"), 0755)).To(Succeed())
+
+		mockCtrl = gomock.NewController(GinkgoT())
+		mockManifest = NewMockManifest(mockCtrl)
+		mockManifest.EXPECT().RootDir().Return(bpDir).AnyTimes()
+
+		buffer = new(bytes.Buffer)
+		logger := libbuildpack.NewLogger(ansicleaner.New(buffer))
+		supplier = &supply.Supplier{
+			Stager:   libbuildpack.NewStager([]string{"", "", depsDir, "0"}, logger, &libbuildpack.Manifest{}),
+			Manifest: mockManifest,
+			Log:      logger,
+		}
+	})
+
+	AfterEach(func() {
+		mockCtrl.Finish()
+		for key, value := range oldEnv {
+			os.Setenv(key, value)
+		}
+		Expect(os.RemoveAll(bpDir)).To(Succeed())
+		Expect(os.RemoveAll(depsDir)).To(Succeed())
+	})
+
+	writeOperatorFile := func(contents string) {
+		Expect(os.MkdirAll(filepath.Join(bpDir, "operator"), 0755)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(bpDir, supply.OperatorNodeOptionsFile), []byte(contents), 0644)).To(Succeed())
+	}
+
+	It("does nothing without operator NODE_OPTIONS", func() {
+		Expect(supplier.SetupOperatorNodeOptions()).To(Succeed())
+		Expect(profileScript()).NotTo(BeAnExistingFile())
+		Expect(buffer.String()).To(Equal(""))
+	})
+
+	It("logs the flags of the app the operator overrides", func() {
+		writeOperatorFile(operatorFile)
+		os.Setenv("NODE_OPTIONS", "--max-http-header-size=8192 --enable-source-maps")
+
+		Expect(supplier.SetupOperatorNodeOptions()).To(Succeed())
+		Expect(buffer.String()).To(ContainSubstring("NODE_OPTIONS sets --max-http-header-size=8192, but the platform operator sets --max-http-header-size=16384, which wins"))
+		Expect(buffer.String()).To(ContainSubstring("Running node with --max-http-header-size=16384 --tls-cipher-list=TLS_AES_256_GCM_SHA384:ECDHE-RSA-AES256-GCM-SHA384 -r /opt/audit.js at runtime, as the platform operator requires"))
+	})
+
+	DescribeTable("merges into the NODE_OPTIONS of the app at boot",
+		func(nodeOptions, expected string) {
+			writeOperatorFile(operatorFile)
+			os.Setenv("BP_OPERATOR_NODE_OPTIONS", `--title="platform app"`)
+
+			Expect(supplier.SetupOperatorNodeOptions()).To(Succeed())
+			Expect(boot(nodeOptions)).To(Equal(expected))
+		},
+		Entry("no NODE_OPTIONS", "",
+			`--max-http-header-size=16384 --tls-cipher-list=TLS_AES_256_GCM_SHA384:ECDHE-RSA-AES256-GCM-SHA384 -r /opt/audit.js --title="platform app"`),
+		Entry("other flags", "--enable-source-maps -r ./tracing.js",
+			`--enable-source-maps -r ./tracing.js --max-http-header-size=16384 --tls-cipher-list=TLS_AES_256_GCM_SHA384:ECDHE-RSA-AES256-GCM-SHA384 -r /opt/audit.js --title="platform app"`),
+		Entry("overridden flags in either form", `--max_http_header_size=8192 --enable-source-maps --title "my app" --tls-cipher-list=DEFAULT --max-http-header-size 4096`,
+			`--enable-source-maps --max-http-header-size=16384 --tls-cipher-list=TLS_AES_256_GCM_SHA384:ECDHE-RSA-AES256-GCM-SHA384 -r /opt/audit.js --title="platform app"`),
+		Entry("a repeatable flag the app has already", "-r /opt/audit.js --inspect",
+			`-r /opt/audit.js --inspect --max-http-header-size=16384 --tls-cipher-list=TLS_AES_256_GCM_SHA384:ECDHE-RSA-AES256-GCM-SHA384 --title="platform app"`),
+	)
+
+	DescribeTable("refuses invalid operator NODE_OPTIONS",
+		func(file, env, message string) {
+			if file != "" {
+				writeOperatorFile(file)
+			}
+			os.Setenv("BP_OPERATOR_NODE_OPTIONS", env)
+
+			Expect(supplier.SetupOperatorNodeOptions()).To(MatchError(message))
+		},
+		Entry("an unterminated quote", "", `--title="x`, `the NODE_OPTIONS of BP_OPERATOR_NODE_OPTIONS are not valid: --title="x has an unterminated quote`),
+		Entry("a name the script can't match", "node_options:\n- --max-http-header-size=1 --a.b\n", "", "the NODE_OPTIONS of operator/node_options.yml have --a.b, which is not the name of a node flag"),
+	)
+})
@@ -0,0 +1,347 @@
+package supply
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"nodejs/native"
+	"nodejs/packagejson"
+	"nodejs/yarn"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/cloudfoundry/libbuildpack"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// BenignOptionalDependencies never build on linux and are expected to fail.
+// BP_BENIGN_OPTIONAL_DEPS adds to this list.
+var BenignOptionalDependencies = []string{"fsevents"}
+
+const optionalPackageName = `((?:@[^@\s/"]+/)?[^@\s/"]+)@[^\s:"]+`
+
+var optionalFailurePatterns = []*regexp.Regexp{
+	// npm: SKIPPING OPTIONAL DEPENDENCY: fsevents@1.2.4 (node_modules/fsevents):
+	regexp.MustCompile(`SKIPPING OPTIONAL DEPENDENCY: (?:Unsupported platform for )?` + optionalPackageName),
+	// yarn: info "fsevents@1.2.4" is an optional dependency and failed compatibility check.
+	regexp.MustCompile(`"?` + optionalPackageName + `"? is an optional dependency and failed`),
+}
+
+var optionalFailedPathPatterns = []*regexp.Regexp{
+	// npm: Skipping failed optional dependency /chokidar/fsevents:
+	// npm: verb reify failed optional dependency /app/node_modules/fsevents
+	regexp.MustCompile(`(?i)failed optional dependency:? "?(\S+)`),
+	// yarn: warning Error running install script for optional dependency: "/app/node_modules/foo: Command failed.
+	regexp.MustCompile(`install script for optional dependency: "?(\S+)`),
+}
+
+// ParseOptionalFailures returns the names of the optional dependencies npm or
+// yarn reported as skipped or failed in the install output.
+func ParseOptionalFailures(output string) []string {
+	var names []string
+	for _, line := range strings.Split(output, "\n") {
+		for _, re := range optionalFailurePatterns {
+			if m := re.FindStringSubmatch(line); m != nil {
+				names = append(names, m[1])
+			}
+		}
+		for _, re := range optionalFailedPathPatterns {
+			if m := re.FindStringSubmatch(line); m != nil {
+				if name := packageNameFromPath(m[1]); name != "" {
+					names = append(names, name)
+				}
+			}
+		}
+	}
+	return uniqueSorted(names)
+}
+
+func packageNameFromPath(path string) string {
+	path = strings.TrimRight(path, ":\"")
+	if idx := strings.LastIndex(path, "node_modules/"); idx >= 0 {
+		path = path[idx+len("node_modules/"):]
+	}
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(parts) == 0 || parts[len(parts)-1] == "" {
+		return ""
+	}
+	if len(parts) >= 2 && strings.HasPrefix(parts[len(parts)-2], "@") {
+		return parts[len(parts)-2] + "/" + parts[len(parts)-1]
+	}
+	return parts[len(parts)-1]
+}
+
+type lockedOptionalPackage struct {
+	Optional bool     `json:"optional"`
+	OS       []string `json:"os"`
+	CPU      []string `json:"cpu"`
+	Libc     []string `json:"libc"`
+}
+
+// MissingOptionalDependencies returns the top level optional dependencies
+// from package.json and the lockfile which are not in node_modules.
+// Lockfile entries built for another platform than the one staging runs on
+// are ignored. Lockfiles v2 and v3 are read from their packages only, the
+// dependencies next to them are for npm 6. Yarn 1 lockfiles record no
+// platforms, so only the optional dependencies of package.json count for
+// them. Plug'n'Play installs have no node_modules to check.
+func MissingOptionalDependencies(buildDir string) ([]string, error) {
+	for _, name := range []string{yarn.PnPFile, ".pnp.js"} {
+		if found, err := libbuildpack.FileExists(filepath.Join(buildDir, name)); err != nil || found {
+			return nil, err
+		}
+	}
+
+	expected := map[string]bool{}
+
+	pkg, _, err := packagejson.Load(buildDir)
+	if err != nil {
+		return nil, err
+	}
+	for name := range pkg.OptionalDependencies {
+		expected[name] = true
+	}
+
+	locked, err := lockedNPMPackages(buildDir)
+	if err != nil {
+		return nil, err
+	}
+	if locked == nil {
+		if locked, err = lockedBerryPackages(buildDir); err != nil {
+			return nil, err
+		}
+	}
+
+	platform := native.HostPlatform()
+	for name, dep := range locked {
+		// The stacks are all glibc based.
+		if !matchesPlatform(dep.OS, "linux") || !matchesPlatform(dep.CPU, platform.CPU) || !matchesPlatform(dep.Libc, "glibc") {
+			delete(expected, name)
+		} else if dep.Optional {
+			expected[name] = true
+		}
+	}
+
+	var missing []string
+	for name := range expected {
+		if found, err := libbuildpack.FileExists(filepath.Join(buildDir, "node_modules", name, "package.json")); err != nil {
+			return nil, err
+		} else if !found {
+			missing = append(missing, name)
+		}
+	}
+	return uniqueSorted(missing), nil
+}
+
+// lockedNPMPackages returns the top level packages of package-lock.json, or
+// nil without one.
+func lockedNPMPackages(buildDir string) (map[string]lockedOptionalPackage, error) {
+	var lock struct {
+		Dependencies map[string]lockedOptionalPackage `json:"dependencies"`
+		Packages     map[string]lockedOptionalPackage `json:"packages"`
+	}
+	if err := loadJSONIfExists(filepath.Join(buildDir, "package-lock.json"), &lock); err != nil {
+		return nil, err
+	}
+	if len(lock.Packages) == 0 {
+		return lock.Dependencies, nil
+	}
+	locked := map[string]lockedOptionalPackage{}
+	for path, dep := range lock.Packages {
+		name := strings.TrimPrefix(path, "node_modules/")
+		if name == path || strings.Contains(name, "/node_modules/") {
+			continue
+		}
+		locked[name] = dep
+	}
+	return locked, nil
+}
+
+type berryLockedPackage struct {
+	Resolution           string            `yaml:"resolution"`
+	Conditions           string            `yaml:"conditions"`
+	OptionalDependencies map[string]string `yaml:"optionalDependencies"`
+	DependenciesMeta     map[string]struct {
+		Optional bool `yaml:"optional"`
+	} `yaml:"dependenciesMeta"`
+}
+
+// lockedBerryPackages returns the packages of a yarn 2+ yarn.lock, with the
+// platform of their conditions, like os=linux & cpu=x64, or nil without
+// one. Yarn hoists a package unless several versions of it are locked, so
+// those are left out, as are the workspaces.
+func lockedBerryPackages(buildDir string) (map[string]lockedOptionalPackage, error) {
+	contents, err := ioutil.ReadFile(filepath.Join(buildDir, "yarn.lock"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if !bytes.Contains(contents, []byte("__metadata:")) {
+		return nil, nil
+	}
+	var lock map[string]berryLockedPackage
+	if err := yaml.Unmarshal(contents, &lock); err != nil {
+		return nil, fmt.Errorf("unable to parse yarn.lock: %s", err)
+	}
+
+	optional := map[string]bool{}
+	for _, entry := range lock {
+		for name := range entry.OptionalDependencies {
+			optional[name] = true
+		}
+		for name, meta := range entry.DependenciesMeta {
+			if meta.Optional {
+				optional[berryPackageName(name)] = true
+			}
+		}
+	}
+
+	locked := map[string]lockedOptionalPackage{}
+	versions := map[string]int{}
+	for key, entry := range lock {
+		if key == "__metadata" || entry.Resolution == "" || strings.Contains(entry.Resolution, "@workspace:") {
+			continue
+		}
+		name := berryPackageName(entry.Resolution)
+		versions[name]++
+		dep := lockedOptionalPackage{Optional: optional[name]}
+		for _, condition := range strings.Split(entry.Conditions, "&") {
+			for _, alternative := range strings.Split(strings.Trim(strings.TrimSpace(condition), "()"), "|") {
+				parts := strings.SplitN(strings.TrimSpace(alternative), "=", 2)
+				if len(parts) != 2 {
+					continue
+				}
+				switch parts[0] {
+				case "os":
+					dep.OS = append(dep.OS, parts[1])
+				case "cpu":
+					dep.CPU = append(dep.CPU, parts[1])
+				case "libc":
+					dep.Libc = append(dep.Libc, parts[1])
+				}
+			}
+		}
+		locked[name] = dep
+	}
+	for name, count := range versions {
+		if count > 1 {
+			delete(locked, name)
+		}
+	}
+	return locked, nil
+}
+
+// berryPackageName returns the package name of a yarn 2+ locator or
+// descriptor like @esbuild/linux-x64@npm:0.19.0.
+func berryPackageName(locator string) string {
+	if idx := strings.Index(locator[1:], "@"); idx >= 0 {
+		return locator[:idx+1]
+	}
+	return locator
+}
+
+func matchesPlatform(allowed []string, current string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+	positive := false
+	for _, value := range allowed {
+		if value == "!"+current {
+			return false
+		}
+		if !strings.HasPrefix(value, "!") {
+			positive = true
+			if value == current {
+				return true
+			}
+		}
+	}
+	return !positive
+}
+
+// SummarizeOptionalDependencies reports the optional dependencies which did
+// not install, separating the ones known to never build on linux from the
+// unexpected ones. It only fails when one of the packages listed in
+// BP_FAIL_ON_OPTIONAL_DEPS is missing.
+func (s *Supplier) SummarizeOptionalDependencies() error {
+	output, err := ioutil.ReadFile(s.Logfile.Name())
+	if err != nil {
+		return err
+	}
+	failed := ParseOptionalFailures(string(output))
+
+	missing, err := MissingOptionalDependencies(s.Stager.BuildDir())
+	if err != nil {
+		return err
+	}
+	failed = uniqueSorted(append(failed, missing...))
+
+	benign := map[string]bool{}
+	for _, name := range append(BenignOptionalDependencies, splitList(os.Getenv("BP_BENIGN_OPTIONAL_DEPS"))...) {
+		benign[name] = true
+	}
+
+	var expected, unexpected []string
+	for _, name := range failed {
+		if benign[name] {
+			expected = append(expected, name)
+		} else {
+			unexpected = append(unexpected, name)
+		}
+	}
+
+	if len(expected) > 0 {
+		s.Log.Info("Optional dependencies not installed (expected on linux): %s", strings.Join(expected, ", "))
+	}
+	if len(unexpected) > 0 {
+		s.Log.Warning("Optional dependencies failed to install: %s\nThe app may crash at runtime if it needs them. To fail the build instead, list them in BP_FAIL_ON_OPTIONAL_DEPS", strings.Join(unexpected, ", "))
+	}
+
+	var required []string
+	for _, name := range splitList(os.Getenv("BP_FAIL_ON_OPTIONAL_DEPS")) {
+		if found, err := libbuildpack.FileExists(filepath.Join(s.Stager.BuildDir(), "node_modules", name, "package.json")); err != nil {
+			return err
+		} else if !found {
+			required = append(required, name)
+		}
+	}
+	if len(required) > 0 {
+		return fmt.Errorf("optional dependencies listed in BP_FAIL_ON_OPTIONAL_DEPS did not install: %s", strings.Join(required, ", "))
+	}
+
+	return nil
+}
+
+func loadJSONIfExists(file string, obj interface{}) error {
+	if found, err := libbuildpack.FileExists(file); err != nil || !found {
+		return err
+	}
+	return libbuildpack.NewJSON().Load(file, obj)
+}
+
+func splitList(value string) []string {
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+func uniqueSorted(names []string) []string {
+	seen := map[string]bool{}
+	var unique []string
+	for _, name := range names {
+		if !seen[name] {
+			seen[name] = true
+			unique = append(unique, name)
+		}
+	}
+	sort.Strings(unique)
+	return unique
+}
@@ -0,0 +1,223 @@
+package supply_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"nodejs/supply"
+	"os"
+	"path/filepath"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/cloudfoundry/libbuildpack/ansicleaner"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Optional dependencies", func() {
+	readFixture := func(name string) string {
+		contents, err := ioutil.ReadFile(filepath.Join("testdata", "optional", name))
+		Expect(err).To(BeNil())
+		return string(contents)
+	}
+
+	installModule := func(buildDir, name string) {
+		Expect(os.MkdirAll(filepath.Join(buildDir, "node_modules", name), 0755)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(buildDir, "node_modules", name, "package.json"), []byte(`{}`), 0644)).To(Succeed())
+	}
+
+	Describe("ParseOptionalFailures", func() {
+		It("parses npm output", func() {
+			Expect(supply.ParseOptionalFailures(readFixture("npm.log"))).To(Equal([]string{"@esbuild/linux-x64", "fsevents"}))
+		})
+
+		It("parses yarn output", func() {
+			Expect(supply.ParseOptionalFailures(readFixture("yarn.log"))).To(Equal([]string{"@swc/core-linux-x64-gnu", "fsevents"}))
+		})
+
+		It("parses npm 7+ verbose output", func() {
+			Expect(supply.ParseOptionalFailures("npm verb reify failed optional dependency /tmp/app/node_modules/@parcel/watcher\n")).To(Equal([]string{"@parcel/watcher"}))
+		})
+
+		It("returns nothing for clean installs", func() {
+			Expect(supply.ParseOptionalFailures("added 12 packages in 1.2s\n")).To(BeEmpty())
+		})
+	})
+
+	Describe("MissingOptionalDependencies", func() {
+		var buildDir string
+
+		BeforeEach(func() {
+			var err error
+			buildDir, err = ioutil.TempDir("", "nodejs-buildpack.build.")
+			Expect(err).To(BeNil())
+		})
+
+		AfterEach(func() {
+			Expect(os.RemoveAll(buildDir)).To(Succeed())
+		})
+
+		It("compares package.json and lockfile optional entries against node_modules", func() {
+			Expect(ioutil.WriteFile(filepath.Join(buildDir, "package.json"), []byte(`{"optionalDependencies":{"bufferutil":"^4.0.0","utf-8-validate":"^5.0.0"}}`), 0644)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(buildDir, "package-lock.json"), []byte(`{
+				"lockfileVersion": 2,
+				"packages": {
+					"": {},
+					"node_modules/@esbuild/linux-x64": {"optional": true, "os": ["linux"], "cpu": ["x64"]},
+					"node_modules/@esbuild/darwin-arm64": {"optional": true, "os": ["darwin"], "cpu": ["arm64"]},
+					"node_modules/esbuild/node_modules/nested": {"optional": true}
+				},
+				"dependencies": {
+					"fsevents": {"version": "1.2.4", "optional": true}
+				}
+			}`), 0644)).To(Succeed())
+			installModule(buildDir, "bufferutil")
+
+			missing, err := supply.MissingOptionalDependencies(buildDir)
+			Expect(err).To(BeNil())
+			Expect(missing).To(Equal([]string{"@esbuild/linux-x64", "utf-8-validate"}))
+		})
+
+		It("leaves out the optional dependencies of package.json locked for another platform", func() {
+			Expect(ioutil.WriteFile(filepath.Join(buildDir, "package.json"), []byte(`{"optionalDependencies":{"fsevents":"^2.3.0","bufferutil":"^4.0.0"}}`), 0644)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(buildDir, "package-lock.json"), []byte(`{
+				"lockfileVersion": 3,
+				"packages": {
+					"": {},
+					"node_modules/fsevents": {"optional": true, "os": ["darwin"]},
+					"node_modules/bufferutil": {"optional": true},
+					"node_modules/@esbuild/linux-s390x": {"optional": true, "os": ["linux"], "cpu": ["s390x"]},
+					"node_modules/@esbuild/win32-x64": {"optional": true, "os": ["win32"], "cpu": ["x64"]}
+				}
+			}`), 0644)).To(Succeed())
+
+			missing, err := supply.MissingOptionalDependencies(buildDir)
+			Expect(err).To(BeNil())
+			Expect(missing).To(Equal([]string{"bufferutil"}))
+		})
+
+		It("reads the dependencies of a lockfile v1", func() {
+			Expect(ioutil.WriteFile(filepath.Join(buildDir, "package.json"), []byte(`{}`), 0644)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(buildDir, "package-lock.json"), []byte(`{
+				"lockfileVersion": 1,
+				"dependencies": {
+					"bufferutil": {"version": "4.0.8", "optional": true},
+					"express": {"version": "4.18.2"}
+				}
+			}`), 0644)).To(Succeed())
+
+			missing, err := supply.MissingOptionalDependencies(buildDir)
+			Expect(err).To(BeNil())
+			Expect(missing).To(Equal([]string{"bufferutil"}))
+		})
+
+		Context("with yarn", func() {
+			BeforeEach(func() {
+				Expect(ioutil.WriteFile(filepath.Join(buildDir, "package.json"), []byte(`{"dependencies":{"@swc/core":"^1.4.0","esbuild":"^0.19.0"},"optionalDependencies":{"bufferutil":"^4.0.0","fsevents":"^2.3.0"}}`), 0644)).To(Succeed())
+				installModule(buildDir, "@swc/core")
+				installModule(buildDir, "@swc/core-linux-x64-gnu")
+				installModule(buildDir, "esbuild")
+			})
+
+			It("reads the optional dependencies and their conditions from a yarn 2+ lockfile", func() {
+				Expect(ioutil.WriteFile(filepath.Join(buildDir, "yarn.lock"), []byte(readFixture("yarn-berry.lock")), 0644)).To(Succeed())
+
+				missing, err := supply.MissingOptionalDependencies(buildDir)
+				Expect(err).To(BeNil())
+				Expect(missing).To(Equal([]string{"@esbuild/linux-x64", "bufferutil"}))
+			})
+
+			It("only counts the optional dependencies of package.json with a yarn 1 lockfile", func() {
+				Expect(ioutil.WriteFile(filepath.Join(buildDir, "yarn.lock"), []byte(readFixture("yarn-classic.lock")), 0644)).To(Succeed())
+
+				missing, err := supply.MissingOptionalDependencies(buildDir)
+				Expect(err).To(BeNil())
+				Expect(missing).To(Equal([]string{"bufferutil", "fsevents"}))
+			})
+
+			It("skips Plug'n'Play installs", func() {
+				Expect(ioutil.WriteFile(filepath.Join(buildDir, "yarn.lock"), []byte(readFixture("yarn-berry.lock")), 0644)).To(Succeed())
+				Expect(ioutil.WriteFile(filepath.Join(buildDir, ".pnp.cjs"), []byte(""), 0644)).To(Succeed())
+
+				missing, err := supply.MissingOptionalDependencies(buildDir)
+				Expect(err).To(BeNil())
+				Expect(missing).To(BeEmpty())
+			})
+		})
+
+		It("handles apps without a lockfile", func() {
+			Expect(ioutil.WriteFile(filepath.Join(buildDir, "package.json"), []byte(`{}`), 0644)).To(Succeed())
+
+			missing, err := supply.MissingOptionalDependencies(buildDir)
+			Expect(err).To(BeNil())
+			Expect(missing).To(BeEmpty())
+		})
+	})
+
+	Describe("SummarizeOptionalDependencies", func() {
+		var (
+			buildDir string
+			logfile  *os.File
+			supplier *supply.Supplier
+			buffer   *bytes.Buffer
+			oldEnv   map[string]string
+		)
+
+		BeforeEach(func() {
+			var err error
+			buildDir, err = ioutil.TempDir("", "nodejs-buildpack.build.")
+			Expect(err).To(BeNil())
+			logfile, err = ioutil.TempFile("", "nodejs-buildpack.logfile")
+			Expect(err).To(BeNil())
+
+			oldEnv = map[string]string{}
+			for _, key := range []string{"BP_BENIGN_OPTIONAL_DEPS", "BP_FAIL_ON_OPTIONAL_DEPS"} {
+				oldEnv[key] = os.Getenv(key)
+				os.Unsetenv(key)
+			}
+
+			Expect(ioutil.WriteFile(logfile.Name(), []byte(readFixture("yarn.log")), 0644)).To(Succeed())
+
+			buffer = new(bytes.Buffer)
+			logger := libbuildpack.NewLogger(ansicleaner.New(buffer))
+			supplier = &supply.Supplier{
+				Stager:  libbuildpack.NewStager([]string{buildDir, "", "", "0"}, logger, &libbuildpack.Manifest{}),
+				Log:     logger,
+				Logfile: logfile,
+			}
+		})
+
+		AfterEach(func() {
+			for key, value := range oldEnv {
+				os.Setenv(key, value)
+			}
+			logfile.Close()
+			Expect(os.Remove(logfile.Name())).To(Succeed())
+			Expect(os.RemoveAll(buildDir)).To(Succeed())
+		})
+
+		It("separates expected failures from unexpected ones", func() {
+			Expect(supplier.SummarizeOptionalDependencies()).To(Succeed())
+			Expect(buffer.String()).To(ContainSubstring("Optional dependencies not installed (expected on linux): fsevents"))
+			Expect(buffer.String()).To(ContainSubstring("**WARNING** Optional dependencies failed to install: @swc/core-linux-x64-gnu"))
+		})
+
+		It("accepts a configurable list of benign packages", func() {
+			os.Setenv("BP_BENIGN_OPTIONAL_DEPS", "@swc/core-linux-x64-gnu")
+			Expect(supplier.SummarizeOptionalDependencies()).To(Succeed())
+			Expect(buffer.String()).To(ContainSubstring("(expected on linux): @swc/core-linux-x64-gnu, fsevents"))
+			Expect(buffer.String()).NotTo(ContainSubstring("WARNING"))
+		})
+
+		It("fails when a package in BP_FAIL_ON_OPTIONAL_DEPS did not install", func() {
+			os.Setenv("BP_FAIL_ON_OPTIONAL_DEPS", "@swc/core-linux-x64-gnu, fsevents")
+			installModule(buildDir, "fsevents")
+			Expect(supplier.SummarizeOptionalDependencies()).To(MatchError("optional dependencies listed in BP_FAIL_ON_OPTIONAL_DEPS did not install: @swc/core-linux-x64-gnu"))
+		})
+
+		It("does not fail when the listed packages installed", func() {
+			os.Setenv("BP_FAIL_ON_OPTIONAL_DEPS", "@swc/core-linux-x64-gnu")
+			installModule(buildDir, "@swc/core-linux-x64-gnu")
+			Expect(supplier.SummarizeOptionalDependencies()).To(Succeed())
+		})
+	})
+})
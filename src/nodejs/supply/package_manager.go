@@ -0,0 +1,65 @@
+package supply
+
+import (
+	"fmt"
+	"nodejs/packagejson"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cloudfoundry/libbuildpack"
+)
+
+// PackageManagers is which package managers staging installs next to node,
+// and why.
+type PackageManagers struct {
+	// NPM is whether to replace the npm bundled with node.
+	NPM       bool
+	NPMReason string
+	Yarn      bool
+	// YarnReason names the trigger of the yarn install, or why there is none.
+	YarnReason string
+}
+
+// SelectPackageManagers decides which package managers the app needs. yarn
+// is installed for a yarn.lock, a packageManager of yarn in package.json, or
+// with BP_ALWAYS_INSTALL_YARN=true for buildpacks after this one which expect
+// it. A separate npm is installed only when engines.npm (requestedNPM) does
+// not match the npm bundled with node.
+func SelectPackageManagers(buildDir, requestedNPM, bundledNPM string) (PackageManagers, error) {
+	var managers PackageManagers
+
+	switch _, err := libbuildpack.FindMatchingVersion(requestedNPM, []string{bundledNPM}); {
+	case requestedNPM == "":
+		managers.NPMReason = fmt.Sprintf("Using default npm version: %s", bundledNPM)
+	case err == nil:
+		managers.NPMReason = fmt.Sprintf("npm %s already installed with node", bundledNPM)
+	default:
+		managers.NPM = true
+		managers.NPMReason = fmt.Sprintf("engines.npm %s does not match npm %s bundled with node", requestedNPM, bundledNPM)
+	}
+
+	hasYarnLock, err := libbuildpack.FileExists(filepath.Join(buildDir, "yarn.lock"))
+	if err != nil {
+		return PackageManagers{}, err
+	}
+	pkg, _, err := packagejson.Load(buildDir)
+	if err != nil {
+		return PackageManagers{}, err
+	}
+
+	managers.Yarn = true
+	switch {
+	case hasYarnLock:
+		managers.YarnReason = "yarn.lock"
+	case strings.HasPrefix(pkg.PackageManager, "yarn@"):
+		managers.YarnReason = "packageManager " + pkg.PackageManager
+	case os.Getenv("BP_ALWAYS_INSTALL_YARN") == "true":
+		managers.YarnReason = "BP_ALWAYS_INSTALL_YARN"
+	default:
+		managers.Yarn = false
+		managers.YarnReason = "the app uses npm (no yarn.lock or packageManager yarn)"
+	}
+
+	return managers, nil
+}
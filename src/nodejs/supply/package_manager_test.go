@@ -0,0 +1,99 @@
+package supply_test
+
+import (
+	"io/ioutil"
+	"nodejs/supply"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("SelectPackageManagers", func() {
+	var (
+		err           error
+		buildDir      string
+		oldAlwaysYarn string
+	)
+
+	BeforeEach(func() {
+		buildDir, err = ioutil.TempDir("", "nodejs-buildpack.build.")
+		Expect(err).To(BeNil())
+		Expect(ioutil.WriteFile(filepath.Join(buildDir, "package.json"), []byte(`{"name":"app"}`), 0644)).To(Succeed())
+
+		oldAlwaysYarn = os.Getenv("BP_ALWAYS_INSTALL_YARN")
+		os.Unsetenv("BP_ALWAYS_INSTALL_YARN")
+	})
+
+	AfterEach(func() {
+		os.Setenv("BP_ALWAYS_INSTALL_YARN", oldAlwaysYarn)
+		Expect(os.RemoveAll(buildDir)).To(Succeed())
+	})
+
+	It("installs neither for an npm app happy with the bundled npm", func() {
+		managers, err := supply.SelectPackageManagers(buildDir, "", "10.2.4")
+		Expect(err).To(BeNil())
+		Expect(managers).To(Equal(supply.PackageManagers{
+			NPMReason:  "Using default npm version: 10.2.4",
+			YarnReason: "the app uses npm (no yarn.lock or packageManager yarn)",
+		}))
+	})
+
+	It("keeps the bundled npm when it matches engines.npm", func() {
+		managers, err := supply.SelectPackageManagers(buildDir, "10.x", "10.2.4")
+		Expect(err).To(BeNil())
+		Expect(managers.NPM).To(BeFalse())
+		Expect(managers.NPMReason).To(Equal("npm 10.2.4 already installed with node"))
+	})
+
+	It("installs npm when engines.npm does not match the bundled npm", func() {
+		managers, err := supply.SelectPackageManagers(buildDir, "9.x", "10.2.4")
+		Expect(err).To(BeNil())
+		Expect(managers.NPM).To(BeTrue())
+		Expect(managers.NPMReason).To(Equal("engines.npm 9.x does not match npm 10.2.4 bundled with node"))
+	})
+
+	It("installs yarn for a yarn.lock", func() {
+		Expect(ioutil.WriteFile(filepath.Join(buildDir, "yarn.lock"), []byte{}, 0644)).To(Succeed())
+
+		managers, err := supply.SelectPackageManagers(buildDir, "", "10.2.4")
+		Expect(err).To(BeNil())
+		Expect(managers.Yarn).To(BeTrue())
+		Expect(managers.YarnReason).To(Equal("yarn.lock"))
+	})
+
+	It("installs yarn when packageManager declares it", func() {
+		Expect(ioutil.WriteFile(filepath.Join(buildDir, "package.json"), []byte(`{"packageManager":"yarn@3.6.4"}`), 0644)).To(Succeed())
+
+		managers, err := supply.SelectPackageManagers(buildDir, "", "10.2.4")
+		Expect(err).To(BeNil())
+		Expect(managers.Yarn).To(BeTrue())
+		Expect(managers.YarnReason).To(Equal("packageManager yarn@3.6.4"))
+	})
+
+	It("does not install yarn when packageManager declares another one", func() {
+		Expect(ioutil.WriteFile(filepath.Join(buildDir, "package.json"), []byte(`{"packageManager":"pnpm@8.15.1"}`), 0644)).To(Succeed())
+
+		managers, err := supply.SelectPackageManagers(buildDir, "", "10.2.4")
+		Expect(err).To(BeNil())
+		Expect(managers.Yarn).To(BeFalse())
+	})
+
+	It("installs yarn with BP_ALWAYS_INSTALL_YARN=true", func() {
+		os.Setenv("BP_ALWAYS_INSTALL_YARN", "true")
+
+		managers, err := supply.SelectPackageManagers(buildDir, "", "10.2.4")
+		Expect(err).To(BeNil())
+		Expect(managers.Yarn).To(BeTrue())
+		Expect(managers.YarnReason).To(Equal("BP_ALWAYS_INSTALL_YARN"))
+	})
+
+	It("handles apps without package.json", func() {
+		Expect(os.Remove(filepath.Join(buildDir, "package.json"))).To(Succeed())
+
+		managers, err := supply.SelectPackageManagers(buildDir, "", "10.2.4")
+		Expect(err).To(BeNil())
+		Expect(managers.Yarn).To(BeFalse())
+	})
+})
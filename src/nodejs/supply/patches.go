@@ -0,0 +1,69 @@
+package supply
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"nodejs/packagejson"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/cloudfoundry/libbuildpack"
+)
+
+// patchFileLine is how patch-package names the patch file which failed to
+// apply.
+var patchFileLine = regexp.MustCompile(`Patch file: (\S+\.patch)`)
+
+// usesPatchPackage reports whether the app depends on patch-package and has
+// patches for it in patches/, where it looks by default, and whether
+// patch-package is a devDependency.
+func (s *Supplier) usesPatchPackage() (bool, bool, error) {
+	p, _, err := packagejson.Load(s.Stager.BuildDir())
+	if err != nil {
+		return false, false, err
+	}
+	_, prod := p.Dependencies["patch-package"]
+	_, dev := p.DevDependencies["patch-package"]
+	if !prod && !dev {
+		return false, false, nil
+	}
+	patches, err := filepath.Glob(filepath.Join(s.Stager.BuildDir(), "patches", "*.patch"))
+	return len(patches) > 0, dev && !prod, err
+}
+
+// ApplyPatches runs patch-package for apps which have patches for it, so
+// that they apply whether or not a postinstall script runs it. patch-package
+// skips patches which are applied already, which makes it safe to run again
+// after steps which reinstall packages. It fails naming the patch files
+// which don't apply cleanly.
+func (s *Supplier) ApplyPatches() error {
+	if found, _, err := s.usesPatchPackage(); err != nil || !found {
+		return err
+	}
+
+	bin := filepath.Join(s.Stager.BuildDir(), "node_modules", ".bin", "patch-package")
+	if found, err := libbuildpack.FileExists(bin); err != nil {
+		return err
+	} else if !found {
+		return fmt.Errorf("patches/ holds patches for patch-package, which is not installed\nMove patch-package to dependencies, or install devDependencies with NPM_CONFIG_PRODUCTION=false and BP_PRUNE_OMIT=dev so that they are pruned after the patches are applied")
+	}
+
+	s.Log.Info("Applying patches with patch-package")
+	output := new(bytes.Buffer)
+	out := io.MultiWriter(s.Log.Output(), output)
+	if err := s.Command.Execute(s.Stager.BuildDir(), out, out, bin, "--error-on-fail"); err != nil {
+		var failed []string
+		for _, m := range patchFileLine.FindAllStringSubmatch(output.String(), -1) {
+			if !containsString(failed, m[1]) {
+				failed = append(failed, m[1])
+			}
+		}
+		if len(failed) == 0 {
+			return fmt.Errorf("patch-package failed: %s", err)
+		}
+		return fmt.Errorf("Patches failed to apply cleanly:\n  %s\nThe patched packages changed since the patches were made, recreate the patches with npx patch-package <package>", strings.Join(failed, "\n  "))
+	}
+	return nil
+}
@@ -0,0 +1,168 @@
+package supply_test
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"nodejs/supply"
+	"os"
+	"path/filepath"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/cloudfoundry/libbuildpack/ansicleaner"
+	"golang.google.cn/x/mock/gomock"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// fakePatchPackage applies patches/ like patch-package does, skipping the
+// patches which are applied already and naming the patch file of those which
+// fail.
+const fakePatchPackage = `#!/bin/sh
+echo "$@" >> node_modules/.patch-package-runs
+status=0
+for patch in patches/*.patch; do
+  if patch -p1 -R -s -f --dry-run < "$patch" > /dev/null 2>&1; then
+    continue
+  fi
+  if patch -p1 -s -f --dry-run < "$patch" > /dev/null 2>&1 && patch -p1 -s -f < "$patch" > /dev/null 2>&1; then
+    echo "$patch applied"
+  else
+    echo "**ERROR** Failed to apply patch"
+    echo "    Patch file: $patch"
+    status=1
+  fi
+done
+exit $status
+`
+
+var _ = Describe("ApplyPatches", func() {
+	var (
+		err      error
+		buildDir string
+		supplier *supply.Supplier
+		buffer   *bytes.Buffer
+	)
+
+	runs := func() string {
+		contents, err := ioutil.ReadFile(filepath.Join(buildDir, "node_modules", ".patch-package-runs"))
+		if os.IsNotExist(err) {
+			return ""
+		}
+		Expect(err).To(BeNil())
+		return string(contents)
+	}
+
+	BeforeEach(func() {
+		buildDir, err = ioutil.TempDir("", "nodejs-buildpack.build.")
+		Expect(err).To(BeNil())
+		Expect(libbuildpack.CopyDirectory(filepath.Join("testdata", "patch_package"), buildDir)).To(Succeed())
+		Expect(os.MkdirAll(filepath.Join(buildDir, "node_modules", ".bin"), 0755)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(buildDir, "node_modules", ".bin", "patch-package"), []byte(fakePatchPackage), 0755)).To(Succeed())
+
+		buffer = new(bytes.Buffer)
+		logger := libbuildpack.NewLogger(ansicleaner.New(buffer))
+		supplier = &supply.Supplier{
+			Stager:  libbuildpack.NewStager([]string{buildDir, "", "", "0"}, logger, &libbuildpack.Manifest{}),
+			Log:     logger,
+			Command: &libbuildpack.Command{},
+		}
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(buildDir)).To(Succeed())
+	})
+
+	Context("with a valid patch", func() {
+		BeforeEach(func() {
+			Expect(os.Remove(filepath.Join(buildDir, "patches", "is-odd+3.0.1.patch"))).To(Succeed())
+		})
+
+		It("applies it", func() {
+			Expect(supplier.ApplyPatches()).To(Succeed())
+			Expect(runs()).To(Equal("--error-on-fail\n"))
+			Expect(buffer.String()).To(ContainSubstring("Applying patches with patch-package"))
+			Expect(ioutil.ReadFile(filepath.Join(buildDir, "node_modules", "left-pad", "index.js"))).To(ContainSubstring("ch = ' '; // patched"))
+		})
+
+		It("can apply it again", func() {
+			Expect(supplier.ApplyPatches()).To(Succeed())
+			Expect(supplier.ApplyPatches()).To(Succeed())
+			Expect(runs()).To(Equal("--error-on-fail\n--error-on-fail\n"))
+		})
+	})
+
+	It("fails naming the stale patch", func() {
+		err := supplier.ApplyPatches()
+		Expect(err).NotTo(BeNil())
+		Expect(err.Error()).To(Equal("Patches failed to apply cleanly:\n  patches/is-odd+3.0.1.patch\nThe patched packages changed since the patches were made, recreate the patches with npx patch-package <package>"))
+		Expect(ioutil.ReadFile(filepath.Join(buildDir, "node_modules", "left-pad", "index.js"))).To(ContainSubstring("// patched"))
+	})
+
+	It("does nothing for apps which don't depend on patch-package", func() {
+		Expect(ioutil.WriteFile(filepath.Join(buildDir, "package.json"), []byte(`{"dependencies":{"left-pad":"^1.3.0"}}`), 0644)).To(Succeed())
+		Expect(supplier.ApplyPatches()).To(Succeed())
+		Expect(runs()).To(Equal(""))
+		Expect(buffer.String()).To(Equal(""))
+	})
+
+	It("does nothing without patches", func() {
+		Expect(os.RemoveAll(filepath.Join(buildDir, "patches"))).To(Succeed())
+		Expect(supplier.ApplyPatches()).To(Succeed())
+		Expect(runs()).To(Equal(""))
+	})
+
+	It("fails when patch-package is not installed", func() {
+		Expect(os.Remove(filepath.Join(buildDir, "node_modules", ".bin", "patch-package"))).To(Succeed())
+		Expect(supplier.ApplyPatches()).To(MatchError(ContainSubstring("patches/ holds patches for patch-package, which is not installed")))
+	})
+
+	Context("when pruning dev dependencies", func() {
+		var (
+			mockCtrl    *gomock.Controller
+			mockCommand *MockCommand
+			oldEnv      map[string]string
+		)
+
+		BeforeEach(func() {
+			oldEnv = map[string]string{}
+			for _, key := range []string{"BP_PRUNE_OMIT", "BP_PRUNE_KEEP", "NODE_ENV"} {
+				oldEnv[key] = os.Getenv(key)
+			}
+			os.Setenv("BP_PRUNE_OMIT", "dev")
+			os.Setenv("BP_PRUNE_KEEP", "")
+			os.Setenv("NODE_ENV", "production")
+
+			mockCtrl = gomock.NewController(GinkgoT())
+			mockCommand = NewMockCommand(mockCtrl)
+			supplier.Command = mockCommand
+			supplier.UseYarn = true
+		})
+
+		AfterEach(func() {
+			mockCtrl.Finish()
+			for key, value := range oldEnv {
+				os.Setenv(key, value)
+			}
+		})
+
+		It("keeps patch-package and applies the patches again", func() {
+			mockCommand.EXPECT().Execute(buildDir, gomock.Any(), gomock.Any(), "yarn", "--version").Do(func(_ string, stdout io.Writer, _ io.Writer, _ string, _ ...string) {
+				stdout.Write([]byte("1.22.19\n"))
+			}).Return(nil)
+			gomock.InOrder(
+				mockCommand.EXPECT().Execute(buildDir, gomock.Any(), gomock.Any(), "yarn", "install", "--pure-lockfile", "--ignore-engines", "--production=true").Do(func(_ string, _ io.Writer, _ io.Writer, _ string, _ ...string) {
+					var pkg struct {
+						Dependencies map[string]string `json:"dependencies"`
+					}
+					Expect(libbuildpack.NewJSON().Load(filepath.Join(buildDir, "package.json"), &pkg)).To(Succeed())
+					Expect(pkg.Dependencies).To(HaveKey("patch-package"))
+				}).Return(nil),
+				mockCommand.EXPECT().Execute(buildDir, gomock.Any(), gomock.Any(), filepath.Join(buildDir, "node_modules", ".bin", "patch-package"), "--error-on-fail").Return(nil),
+			)
+
+			Expect(supplier.PruneDependencies()).To(Succeed())
+			Expect(buffer.String()).To(ContainSubstring("Keeping patch-package to re-apply the patches in patches/ after pruning"))
+		})
+	})
+})
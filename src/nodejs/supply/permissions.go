@@ -0,0 +1,152 @@
+package supply
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+const maxReportedPermissionProblems = 20
+
+type PermissionProblem struct {
+	Path string
+	Mode os.FileMode
+	UID  uint32
+	GID  uint32
+}
+
+func (p PermissionProblem) String() string {
+	return fmt.Sprintf("%s (mode %s, owner uid=%d gid=%d)", p.Path, p.Mode, p.UID, p.GID)
+}
+
+// FindUnwritablePaths walks the parts of the app dir touched by an install
+// (the app root, package manager files and node_modules) and returns every
+// path the current user is not able to write to.
+func FindUnwritablePaths(buildDir string, uid int, gids []int) ([]PermissionProblem, error) {
+	var problems []PermissionProblem
+
+	check := func(path string, info os.FileInfo) {
+		if info.Mode()&os.ModeSymlink != 0 {
+			return
+		}
+		stat, ok := info.Sys().(*syscall.Stat_t)
+		if !ok || isWritableBy(info.Mode(), stat.Uid, stat.Gid, uid, gids) {
+			return
+		}
+		rel, err := filepath.Rel(buildDir, path)
+		if err != nil {
+			rel = path
+		}
+		problems = append(problems, PermissionProblem{Path: rel, Mode: info.Mode(), UID: stat.Uid, GID: stat.Gid})
+	}
+
+	info, err := os.Lstat(buildDir)
+	if err != nil {
+		return nil, err
+	}
+	check(buildDir, info)
+
+	for _, name := range []string{"package.json", "package-lock.json", "npm-shrinkwrap.json", "yarn.lock"} {
+		path := filepath.Join(buildDir, name)
+		if info, err := os.Lstat(path); err == nil {
+			check(path, info)
+		} else if !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+
+	nodeModules := filepath.Join(buildDir, "node_modules")
+	if _, err := os.Lstat(nodeModules); os.IsNotExist(err) {
+		return problems, nil
+	}
+
+	err = filepath.Walk(nodeModules, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsPermission(err) {
+				if info != nil {
+					check(path, info)
+				}
+				return filepath.SkipDir
+			}
+			return err
+		}
+		check(path, info)
+		return nil
+	})
+
+	return problems, err
+}
+
+// FixPermissions adds u+w to everything below the app dir. Paths owned by
+// another user cannot be fixed and are left for CheckPermissions to report.
+func FixPermissions(buildDir string) error {
+	return filepath.Walk(buildDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsPermission(err) {
+				return nil
+			}
+			return err
+		}
+		if info.Mode()&os.ModeSymlink != 0 || info.Mode().Perm()&0200 != 0 {
+			return nil
+		}
+		if err := os.Chmod(path, info.Mode().Perm()|0200); err != nil && !os.IsPermission(err) {
+			return err
+		}
+		return nil
+	})
+}
+
+func isWritableBy(mode os.FileMode, ownerUID, ownerGID uint32, uid int, gids []int) bool {
+	perm := mode.Perm()
+	if uint32(uid) == ownerUID {
+		return perm&0200 != 0
+	}
+	for _, gid := range gids {
+		if uint32(gid) == ownerGID {
+			return perm&0020 != 0
+		}
+	}
+	return perm&0002 != 0
+}
+
+// CheckPermissions reports unwritable paths in one consolidated message, and
+// fixes them when BP_FIX_PERMISSIONS is set.
+func (s *Supplier) CheckPermissions() error {
+	gids, err := os.Getgroups()
+	if err != nil {
+		return err
+	}
+	gids = append(gids, os.Getegid())
+
+	problems, err := FindUnwritablePaths(s.Stager.BuildDir(), os.Geteuid(), gids)
+	if err != nil || len(problems) == 0 {
+		return err
+	}
+
+	if os.Getenv("BP_FIX_PERMISSIONS") == "true" {
+		s.Log.Info("BP_FIX_PERMISSIONS is set, adding write permission to %d path(s) in the app directory", len(problems))
+		if err := FixPermissions(s.Stager.BuildDir()); err != nil {
+			return err
+		}
+		if problems, err = FindUnwritablePaths(s.Stager.BuildDir(), os.Geteuid(), gids); err != nil || len(problems) == 0 {
+			return err
+		}
+	}
+
+	lines := []string{fmt.Sprintf("The following paths in the app are not writable by the staging user (uid=%d):", os.Geteuid())}
+	for i, problem := range problems {
+		if i == maxReportedPermissionProblems {
+			lines = append(lines, fmt.Sprintf("  ... and %d more", len(problems)-i))
+			break
+		}
+		lines = append(lines, "  "+problem.String())
+	}
+	lines = append(lines, "Installing dependencies may fail with EACCES.")
+	lines = append(lines, "Fix the permissions before pushing (e.g. chmod -R u+w .) or set BP_FIX_PERMISSIONS=true")
+	s.Log.Warning("%s", strings.Join(lines, "\n"))
+
+	return nil
+}
@@ -0,0 +1,156 @@
+package supply_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"nodejs/supply"
+	"os"
+	"path/filepath"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/cloudfoundry/libbuildpack/ansicleaner"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Permissions", func() {
+	var (
+		err      error
+		buildDir string
+		uid      int
+		gids     []int
+	)
+
+	BeforeEach(func() {
+		buildDir, err = ioutil.TempDir("", "nodejs-buildpack.build.")
+		Expect(err).To(BeNil())
+
+		uid = os.Geteuid()
+		gids = []int{os.Getegid()}
+
+		Expect(ioutil.WriteFile(filepath.Join(buildDir, "package.json"), []byte("{}"), 0644)).To(Succeed())
+		Expect(os.MkdirAll(filepath.Join(buildDir, "node_modules", "left-pad", "lib"), 0755)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(buildDir, "node_modules", "left-pad", "index.js"), []byte(""), 0644)).To(Succeed())
+	})
+
+	AfterEach(func() {
+		Expect(makeWritable(buildDir)).To(Succeed())
+		Expect(os.RemoveAll(buildDir)).To(Succeed())
+	})
+
+	Describe("FindUnwritablePaths", func() {
+		It("reports nothing for a writable tree", func() {
+			problems, err := supply.FindUnwritablePaths(buildDir, uid, gids)
+			Expect(err).To(BeNil())
+			Expect(problems).To(BeEmpty())
+		})
+
+		It("reports read-only files and directories with their modes", func() {
+			Expect(os.Chmod(filepath.Join(buildDir, "package.json"), 0444)).To(Succeed())
+			Expect(os.Chmod(filepath.Join(buildDir, "node_modules", "left-pad", "lib"), 0555)).To(Succeed())
+
+			problems, err := supply.FindUnwritablePaths(buildDir, uid, gids)
+			Expect(err).To(BeNil())
+			Expect(problems).To(HaveLen(2))
+			Expect(problems[0].Path).To(Equal("package.json"))
+			Expect(problems[0].Mode.Perm()).To(Equal(os.FileMode(0444)))
+			Expect(problems[1].Path).To(Equal(filepath.Join("node_modules", "left-pad", "lib")))
+			Expect(problems[1].String()).To(ContainSubstring("mode dr-xr-xr-x"))
+		})
+
+		It("reports the app root", func() {
+			Expect(os.Chmod(buildDir, 0555)).To(Succeed())
+
+			problems, err := supply.FindUnwritablePaths(buildDir, uid, gids)
+			Expect(err).To(BeNil())
+			Expect(problems).To(HaveLen(1))
+			Expect(problems[0].Path).To(Equal("."))
+		})
+
+		It("treats files owned by another user as unwritable unless world writable", func() {
+			problems, err := supply.FindUnwritablePaths(buildDir, uid+1, []int{})
+			Expect(err).To(BeNil())
+			Expect(problems).NotTo(BeEmpty())
+			Expect(problems[0].String()).To(ContainSubstring("owner uid="))
+		})
+
+		It("ignores files outside the install footprint", func() {
+			Expect(ioutil.WriteFile(filepath.Join(buildDir, "README.md"), []byte(""), 0444)).To(Succeed())
+
+			problems, err := supply.FindUnwritablePaths(buildDir, uid, gids)
+			Expect(err).To(BeNil())
+			Expect(problems).To(BeEmpty())
+		})
+	})
+
+	Describe("FixPermissions", func() {
+		It("adds u+w recursively", func() {
+			Expect(os.Chmod(filepath.Join(buildDir, "node_modules", "left-pad", "index.js"), 0444)).To(Succeed())
+			Expect(os.Chmod(filepath.Join(buildDir, "node_modules", "left-pad"), 0555)).To(Succeed())
+
+			Expect(supply.FixPermissions(buildDir)).To(Succeed())
+
+			problems, err := supply.FindUnwritablePaths(buildDir, uid, gids)
+			Expect(err).To(BeNil())
+			Expect(problems).To(BeEmpty())
+
+			info, err := os.Stat(filepath.Join(buildDir, "node_modules", "left-pad", "index.js"))
+			Expect(err).To(BeNil())
+			Expect(info.Mode().Perm()).To(Equal(os.FileMode(0644)))
+		})
+	})
+
+	Describe("CheckPermissions", func() {
+		var (
+			buffer      *bytes.Buffer
+			supplier    *supply.Supplier
+			oldFixPerms string
+		)
+
+		BeforeEach(func() {
+			oldFixPerms = os.Getenv("BP_FIX_PERMISSIONS")
+			os.Unsetenv("BP_FIX_PERMISSIONS")
+
+			buffer = new(bytes.Buffer)
+			logger := libbuildpack.NewLogger(ansicleaner.New(buffer))
+			supplier = &supply.Supplier{
+				Stager: libbuildpack.NewStager([]string{buildDir, "", "", ""}, logger, &libbuildpack.Manifest{}),
+				Log:    logger,
+			}
+
+			Expect(os.Chmod(filepath.Join(buildDir, "package.json"), 0444)).To(Succeed())
+		})
+
+		AfterEach(func() {
+			os.Setenv("BP_FIX_PERMISSIONS", oldFixPerms)
+		})
+
+		It("warns with a consolidated message", func() {
+			Expect(supplier.CheckPermissions()).To(Succeed())
+			Expect(buffer.String()).To(ContainSubstring("not writable by the staging user"))
+			Expect(buffer.String()).To(ContainSubstring("package.json (mode -r--r--r--"))
+			Expect(buffer.String()).To(ContainSubstring("BP_FIX_PERMISSIONS=true"))
+		})
+
+		Context("BP_FIX_PERMISSIONS is true", func() {
+			BeforeEach(func() {
+				os.Setenv("BP_FIX_PERMISSIONS", "true")
+			})
+
+			It("fixes the permissions and does not warn", func() {
+				Expect(supplier.CheckPermissions()).To(Succeed())
+				Expect(buffer.String()).To(ContainSubstring("adding write permission to 1 path(s)"))
+				Expect(buffer.String()).NotTo(ContainSubstring("WARNING"))
+			})
+		})
+	})
+})
+
+func makeWritable(dir string) error {
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		return os.Chmod(path, 0755)
+	})
+}
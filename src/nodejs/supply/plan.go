@@ -0,0 +1,412 @@
+package supply
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"nodejs/locale"
+	"nodejs/npm"
+	"nodejs/packagejson"
+	"nodejs/pkgmanager"
+	"nodejs/versionresolver"
+	"nodejs/yarn"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/cloudfoundry/libbuildpack"
+)
+
+// Plan is what staging would do, worked out without downloading or running
+// anything. Errors are the steps which would fail.
+type Plan struct {
+	Node           PlannedDependency  `json:"node"`
+	NPM            string             `json:"npm,omitempty"`
+	Yarn           *PlannedDependency `json:"yarn,omitempty"`
+	PackageManager string             `json:"package_manager"`
+	Commands       []string           `json:"commands"`
+	Scripts        []string           `json:"scripts"`
+	Hooks          []string           `json:"hooks"`
+	Warnings       []string           `json:"warnings"`
+	Errors         []string           `json:"errors"`
+}
+
+type PlannedDependency struct {
+	Version   string `json:"version,omitempty"`
+	Requested string `json:"requested,omitempty"`
+	Source    string `json:"source,omitempty"`
+}
+
+// PlanRun stages a scratch copy of the app with Run, with a recorder in
+// place of the installer and of the commands, and returns the downloads,
+// installs and scripts staging would perform. Nothing is downloaded or
+// run, and the app, its cache and the env of the process are left as they
+// were. hooks are the names of the hooks which would be activated.
+func PlanRun(s *Supplier, hooks []string) Plan {
+	plan := Plan{
+		Commands: []string{},
+		Scripts:  []string{},
+		Hooks:    append([]string{}, hooks...),
+		Warnings: []string{},
+		Errors:   []string{},
+	}
+
+	// The recorded install can't fail on a lockfile out of sync, while
+	// staging would install other versions than the lockfile pins. Run
+	// reports a lockfile it can't read.
+	if useYarn, err := libbuildpack.FileExists(filepath.Join(s.Stager.BuildDir(), "yarn.lock")); err == nil {
+		if problems, err := ValidateLockfile(s.Stager.BuildDir(), useYarn); err == nil {
+			plan.Errors = append(plan.Errors, problems...)
+		}
+	}
+
+	dir, err := ioutil.TempDir("", "nodejs-buildpack.plan")
+	if err != nil {
+		plan.Errors = append(plan.Errors, err.Error())
+		return plan
+	}
+	defer os.RemoveAll(dir)
+	defer restoreEnviron(os.Environ())
+
+	log := &planLog{}
+	dry, rec, err := dryRunSupplier(s, dir, log)
+	if err != nil {
+		plan.Errors = append(plan.Errors, err.Error())
+		return plan
+	}
+	defer dry.Logfile.Close()
+
+	if err := Run(dry); err != nil && len(log.errors) == 0 {
+		log.errors = append(log.errors, err.Error())
+	}
+
+	plan.Node = PlannedDependency{Version: dry.ExactNodeVersion, Requested: dry.NodeVersion, Source: dry.NodeVersionSource}
+	if dry.PackageManagers.NPM {
+		plan.NPM = dry.NPMVersion
+	}
+	if version, found := rec.installed["yarn"]; found {
+		plan.Yarn = &PlannedDependency{Version: version, Requested: dry.YarnVersion}
+	}
+	if dry.HasPackageJSON {
+		plan.PackageManager = "npm"
+		if dry.UseYarn {
+			plan.PackageManager = "yarn"
+		}
+	}
+	plan.Commands = append(plan.Commands, rec.commands...)
+	plan.Scripts = append(plan.Scripts, rec.scripts...)
+	for _, warning := range log.warnings {
+		plan.Warnings = append(plan.Warnings, rec.paths.Replace(warning))
+	}
+	for _, err := range log.errors {
+		plan.Errors = append(plan.Errors, rec.paths.Replace(err))
+	}
+	return plan
+}
+
+// dryRunSupplier returns a Supplier for Run which stages a copy of the app
+// of s in dir, with a recorder for its installer and commands. Its log goes
+// to log and a log file in dir.
+func dryRunSupplier(s *Supplier, dir string, log *planLog) (*Supplier, *recorder, error) {
+	buildDir, cacheDir, depsDir := filepath.Join(dir, "build"), filepath.Join(dir, "cache"), filepath.Join(dir, "deps")
+	for _, d := range []string{buildDir, cacheDir, filepath.Join(depsDir, s.Stager.DepsIdx())} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			return nil, nil, err
+		}
+	}
+	if err := libbuildpack.CopyDirectory(s.Stager.BuildDir(), buildDir); err != nil {
+		return nil, nil, err
+	}
+	logfile, err := ioutil.TempFile(dir, "log")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	logger := libbuildpack.NewLogger(io.MultiWriter(log, logfile))
+	locales := s.Locales
+	if locales == nil {
+		// locale -a only reads the stack, it runs.
+		locales = locale.CommandLister{Command: &libbuildpack.Command{}}
+	}
+	rec := &recorder{
+		manifest:  s.Manifest,
+		installed: map[string]string{},
+		paths: strings.NewReplacer(
+			buildDir, s.Stager.BuildDir(),
+			cacheDir, s.Stager.CacheDir(),
+			depsDir, filepath.Dir(s.Stager.DepDir()),
+		),
+	}
+	return &Supplier{
+		Stager:         libbuildpack.NewStager([]string{buildDir, cacheDir, depsDir, s.Stager.DepsIdx()}, logger, &libbuildpack.Manifest{}),
+		Manifest:       s.Manifest,
+		Installer:      rec,
+		Log:            logger,
+		Logfile:        logfile,
+		Command:        rec,
+		NPM:            &npm.NPM{Command: rec, Log: logger},
+		Yarn:           &yarn.Yarn{Command: rec, Log: logger},
+		Locales:        locales,
+		StatFilesystem: s.StatFilesystem,
+		DryRun:         true,
+	}, rec, nil
+}
+
+// restoreEnviron replaces the env of the process with environ.
+func restoreEnviron(environ []string) {
+	os.Clearenv()
+	for _, kv := range environ {
+		if parts := strings.SplitN(kv, "=", 2); len(parts) == 2 {
+			os.Setenv(parts[0], parts[1])
+		}
+	}
+}
+
+// recorder stands in for the installer and the commands of a dry run. It
+// records the commands, in the paths of the app, and installs empty
+// dependencies. Of the version queries, those of the tools of the stack,
+// which are asked by absolute path, run, npm answers nothing, as the npm
+// bundled with node is only known once node was downloaded, and the other
+// tools answer the version the recorder installed.
+type recorder struct {
+	manifest  Manifest
+	paths     *strings.Replacer
+	installed map[string]string
+	commands  []string
+	scripts   []string
+}
+
+func (r *recorder) Execute(dir string, stdout, stderr io.Writer, program string, args ...string) error {
+	if len(args) == 1 && args[0] == "--version" {
+		if filepath.IsAbs(program) {
+			cmd := exec.Command(program, args...)
+			cmd.Dir = dir
+			cmd.Stdout = stdout
+			cmd.Stderr = stderr
+			return cmd.Run()
+		}
+		if version, found := r.installed[program]; found {
+			fmt.Fprintln(stdout, version)
+		}
+		return nil
+	}
+	r.record(pkgmanager.Invocation{Program: program, Args: args})
+	return nil
+}
+
+func (r *recorder) Run(cmd *exec.Cmd) error {
+	invocation := pkgmanager.Invocation{Program: cmd.Args[0], Args: cmd.Args[1:]}
+	if cmd.Env != nil {
+		environ := map[string]bool{}
+		for _, kv := range os.Environ() {
+			environ[kv] = true
+		}
+		for _, kv := range cmd.Env {
+			if !environ[kv] {
+				invocation.Env = append(invocation.Env, kv)
+			}
+		}
+	}
+	r.record(invocation)
+	return nil
+}
+
+// record adds the scripts of package.json to the scripts, and the other
+// invocations to the commands.
+func (r *recorder) record(invocation pkgmanager.Invocation) {
+	if (invocation.Program == "npm" || invocation.Program == "yarn") && len(invocation.Args) >= 2 && invocation.Args[0] == "run" {
+		r.scripts = append(r.scripts, invocation.Args[1])
+		return
+	}
+	r.commands = append(r.commands, r.paths.Replace(invocation.String()))
+}
+
+// InstallDependency installs an empty dependency where the archive of node
+// or of yarn extracts to.
+func (r *recorder) InstallDependency(dep libbuildpack.Dependency, dir string) error {
+	r.installed[dep.Name] = dep.Version
+	extracted := dep.Name + "-v" + dep.Version
+	if dep.Name == "node" {
+		extracted += "-linux-x64"
+	}
+	return os.MkdirAll(filepath.Join(dir, extracted, "bin"), 0755)
+}
+
+func (r *recorder) InstallOnlyVersion(name, dir string) error {
+	versions := r.manifest.AllDependencyVersions(name)
+	if len(versions) != 1 {
+		return fmt.Errorf("expected one version of %s, found %d", name, len(versions))
+	}
+	return r.InstallDependency(libbuildpack.Dependency{Name: name, Version: versions[0]}, dir)
+}
+
+// planLog collects the warnings and errors a dry run logs. The logger
+// writes each message in a single call.
+type planLog struct {
+	warnings []string
+	errors   []string
+}
+
+func (l *planLog) Write(p []byte) (int, error) {
+	for _, kind := range []struct {
+		header   string
+		messages *[]string
+	}{
+		{"**WARNING**", &l.warnings},
+		{"**ERROR**", &l.errors},
+	} {
+		prefix := "       \033[31;1m" + kind.header + "\033[0m "
+		if message := string(p); strings.HasPrefix(message, prefix) {
+			message = strings.TrimSuffix(strings.TrimPrefix(message, prefix), "\n")
+			*kind.messages = append(*kind.messages, strings.Replace(message, "\n       ", "\n", -1))
+		}
+	}
+	return len(p), nil
+}
+
+// Log prints the plan for people.
+func (p Plan) Log(log *libbuildpack.Logger) {
+	log.BeginStep("Planned build (dry run)")
+
+	node := p.Node.Version
+	if node == "" {
+		node = "(unresolved)"
+	}
+	if p.Node.Source != "" && p.Node.Source != versionresolver.SourceDefault {
+		log.Info("Install node %s (requested %s in %s)", node, p.Node.Requested, p.Node.Source)
+	} else {
+		log.Info("Install node %s (default)", node)
+	}
+	if p.NPM != "" {
+		log.Info("Install npm %s unless the npm bundled with node matches", p.NPM)
+	}
+	if p.Yarn != nil {
+		log.Info("Install yarn %s", p.Yarn.Version)
+	}
+	for _, command := range p.Commands {
+		log.Info("Run %s", command)
+	}
+	for _, script := range p.Scripts {
+		log.Info("Run script %s (%s)", script, p.PackageManager)
+	}
+	for _, hook := range p.Hooks {
+		log.Info("Activate hook %s", hook)
+	}
+	for _, warning := range p.Warnings {
+		log.Warning("%s", warning)
+	}
+	for _, err := range p.Errors {
+		log.Error("%s", err)
+	}
+}
+
+// WriteJSON writes the plan as JSON for tools.
+func (p Plan) WriteJSON(w io.Writer) error {
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+// ValidateLockfile returns the problems of a lockfile which misses
+// dependencies of package.json, and an error when the lockfile cannot be
+// read.
+func ValidateLockfile(buildDir string, useYarn bool) ([]string, error) {
+	p, found, err := packagejson.Load(buildDir)
+	if err != nil || !found {
+		return nil, err
+	}
+
+	deps := map[string]string{}
+	for _, m := range []map[string]string{p.DevDependencies, p.OptionalDependencies, p.Dependencies} {
+		for name, version := range m {
+			deps[name] = version
+		}
+	}
+	var names []string
+	for name := range deps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	if useYarn {
+		entries, err := yarnLockEntries(filepath.Join(buildDir, "yarn.lock"))
+		if err != nil {
+			return nil, fmt.Errorf("Unable to read yarn.lock: %s", err)
+		}
+		var missing []string
+		for _, name := range names {
+			descriptor := name + "@" + deps[name]
+			// yarn 2 and later key a range without a protocol with npm:,
+			// like name@npm:^1.2.3.
+			if !entries[descriptor] && (strings.Contains(deps[name], ":") || !entries[name+"@npm:"+deps[name]]) {
+				missing = append(missing, descriptor)
+			}
+		}
+		if len(missing) > 0 {
+			return []string{fmt.Sprintf("yarn.lock is outdated, it has no entry for %s", strings.Join(missing, ", "))}, nil
+		}
+		return nil, nil
+	}
+
+	for _, lockfile := range []string{"npm-shrinkwrap.json", "package-lock.json"} {
+		path := filepath.Join(buildDir, lockfile)
+		if found, err := libbuildpack.FileExists(path); err != nil {
+			return nil, err
+		} else if !found {
+			continue
+		}
+
+		var lock struct {
+			Dependencies map[string]interface{} `json:"dependencies"`
+			Packages     map[string]interface{} `json:"packages"`
+		}
+		if err := libbuildpack.NewJSON().Load(path, &lock); err != nil {
+			return nil, fmt.Errorf("Unable to read %s: %s", lockfile, err)
+		}
+
+		var missing []string
+		for _, name := range names {
+			_, inPackages := lock.Packages["node_modules/"+name]
+			_, inDependencies := lock.Dependencies[name]
+			if !inPackages && !inDependencies {
+				missing = append(missing, name)
+			}
+		}
+		if len(missing) > 0 {
+			return []string{fmt.Sprintf("%s is out of sync with package.json, it does not contain %s", lockfile, strings.Join(missing, ", "))}, nil
+		}
+		return nil, nil
+	}
+
+	return nil, nil
+}
+
+// yarnLockEntries returns the name@range keys of a yarn.lock, of yarn 1 or
+// of yarn 2 and later.
+func yarnLockEntries(path string) (map[string]bool, error) {
+	entries := map[string]bool{}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" || strings.HasPrefix(line, " ") || strings.HasPrefix(line, "#") || !strings.HasSuffix(line, ":") {
+			continue
+		}
+		for _, key := range strings.Split(strings.TrimSuffix(line, ":"), ",") {
+			entries[strings.Trim(strings.TrimSpace(key), `"`)] = true
+		}
+	}
+	return entries, scanner.Err()
+}
@@ -0,0 +1,143 @@
+package supply_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"nodejs/supply"
+	"os"
+	"path/filepath"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/cloudfoundry/libbuildpack/ansicleaner"
+	"golang.google.cn/x/mock/gomock"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("PlanRun", func() {
+	var (
+		buffer       *bytes.Buffer
+		logger       *libbuildpack.Logger
+		mockCtrl     *gomock.Controller
+		mockManifest *MockManifest
+		oldEnv       map[string]string
+	)
+	planEnv := []string{"CF_STACK", "BP_NODE_VERSION", "BP_PRUNE_OMIT", "BP_NODE_RUN_SCRIPTS", "BP_ALWAYS_INSTALL_YARN", "NODE_ENV", "NPM_CONFIG_PRODUCTION", "BP_INSTALL_PRODUCTION_ONLY"}
+
+	BeforeEach(func() {
+		oldEnv = map[string]string{}
+		for _, key := range planEnv {
+			if value, found := os.LookupEnv(key); found {
+				oldEnv[key] = value
+			}
+			os.Unsetenv(key)
+		}
+
+		buffer = new(bytes.Buffer)
+		logger = libbuildpack.NewLogger(ansicleaner.New(buffer))
+
+		mockCtrl = gomock.NewController(GinkgoT())
+		mockManifest = NewMockManifest(mockCtrl)
+		mockManifest.EXPECT().AllDependencyVersions("node").Return([]string{"6.14.4", "8.12.0", "10.12.0"}).AnyTimes()
+		mockManifest.EXPECT().AllDependencyVersions("yarn").Return([]string{"1.10.1"}).AnyTimes()
+		mockManifest.EXPECT().DefaultVersion("node").Return(libbuildpack.Dependency{Name: "node", Version: "8.12.0"}, nil).AnyTimes()
+		mockManifest.EXPECT().RootDir().Return(filepath.Join("testdata", "plan", "buildpack")).AnyTimes()
+	})
+
+	AfterEach(func() {
+		mockCtrl.Finish()
+		for _, key := range planEnv {
+			if value, found := oldEnv[key]; found {
+				os.Setenv(key, value)
+			} else {
+				os.Unsetenv(key)
+			}
+		}
+	})
+
+	planFor := func(app string, hooks []string) supply.Plan {
+		supplier := &supply.Supplier{
+			Stager:   libbuildpack.NewStager([]string{filepath.Join("testdata", "plan", app), "/tmp/cache", "/tmp/deps", "0"}, logger, &libbuildpack.Manifest{}),
+			Manifest: mockManifest,
+			Log:      logger,
+			Locales:  fakeLocales{locales: []string{"C", "C.UTF-8", "en_US.utf8", "POSIX"}},
+		}
+		return supply.PlanRun(supplier, hooks)
+	}
+
+	DescribeTable("planned actions of fixture apps",
+		func(app string, hooks []string) {
+			output := new(bytes.Buffer)
+			Expect(planFor(app, hooks).WriteJSON(output)).To(Succeed())
+
+			expected, err := ioutil.ReadFile(filepath.Join("testdata", "plan", app+".json"))
+			Expect(err).To(BeNil())
+			Expect(output.String()).To(MatchJSON(expected))
+		},
+		Entry("npm app", "npm_app", []string{"dynatrace"}),
+		Entry("yarn app", "yarn_app", []string{}),
+		Entry("yarn 2+ app", "berry_app", []string{}),
+		Entry("vendored app", "vendored_app", []string{}),
+		Entry("app which would fail", "broken_app", []string{}),
+		Entry("app requesting a node the buildpack lacks", "unresolvable_app", []string{}),
+		Entry("app whose node-sass does not support its node", "node_sass_app", []string{}),
+		Entry("app without package.json", "single_file_app", []string{}),
+	)
+
+	It("prints the plan for people", func() {
+		planFor("npm_app", []string{"dynatrace"}).Log(logger)
+		Expect(buffer.String()).To(ContainSubstring("-----> Planned build (dry run)"))
+		Expect(buffer.String()).To(ContainSubstring("Install node 10.12.0 (requested 10.x in engines.node)"))
+		Expect(buffer.String()).To(ContainSubstring("Install npm 6.x unless the npm bundled with node matches"))
+		Expect(buffer.String()).To(ContainSubstring("Run npm install --unsafe-perm"))
+		Expect(buffer.String()).To(ContainSubstring("Run script heroku-prebuild (npm)"))
+		Expect(buffer.String()).To(ContainSubstring("Activate hook dynatrace"))
+		Expect(buffer.String()).To(ContainSubstring("**ERROR** package-lock.json is out of sync with package.json, it does not contain lodash"))
+	})
+
+	It("plans the scripts in BP_NODE_RUN_SCRIPTS", func() {
+		os.Setenv("BP_NODE_RUN_SCRIPTS", "build, lint")
+		Expect(planFor("npm_app", nil).Scripts).To(Equal([]string{"heroku-prebuild", "build", "lint"}))
+	})
+
+	It("fails for a script in BP_NODE_RUN_SCRIPTS which package.json lacks", func() {
+		os.Setenv("BP_NODE_RUN_SCRIPTS", "build, deploy")
+		Expect(planFor("npm_app", nil).Errors).To(ContainElement(ContainSubstring("BP_NODE_RUN_SCRIPTS lists deploy, which is not a script in package.json")))
+	})
+
+	It("leaves the app, the cache and the env as they were", func() {
+		cacheDir, err := ioutil.TempDir("", "nodejs-buildpack.cache.")
+		Expect(err).To(BeNil())
+		defer os.RemoveAll(cacheDir)
+		depsDir, err := ioutil.TempDir("", "nodejs-buildpack.deps.")
+		Expect(err).To(BeNil())
+		defer os.RemoveAll(depsDir)
+		environ := os.Environ()
+
+		supplier := &supply.Supplier{
+			Stager:   libbuildpack.NewStager([]string{filepath.Join("testdata", "plan", "yarn_app"), cacheDir, depsDir, "0"}, logger, &libbuildpack.Manifest{}),
+			Manifest: mockManifest,
+			Log:      logger,
+			Locales:  fakeLocales{locales: []string{"C.UTF-8"}},
+		}
+		supply.PlanRun(supplier, nil)
+
+		Expect(os.Environ()).To(ConsistOf(environ))
+		Expect(ioutil.ReadDir(cacheDir)).To(BeEmpty())
+		Expect(filepath.Join("testdata", "plan", "yarn_app", "node_modules")).NotTo(BeADirectory())
+		Expect(ioutil.ReadDir(depsDir)).To(BeEmpty())
+	})
+
+	It("plans yarn for an npm app with BP_ALWAYS_INSTALL_YARN=true", func() {
+		os.Setenv("BP_ALWAYS_INSTALL_YARN", "true")
+		plan := planFor("npm_app", nil)
+		Expect(plan.PackageManager).To(Equal("npm"))
+		Expect(plan.Yarn).To(Equal(&supply.PlannedDependency{Version: "1.10.1"}))
+	})
+
+	It("fails for an invalid BP_PRUNE_OMIT", func() {
+		os.Setenv("BP_PRUNE_OMIT", "bundled")
+		Expect(planFor("npm_app", nil).Errors).To(ContainElement(ContainSubstring(`unknown dependency type "bundled"`)))
+	})
+})
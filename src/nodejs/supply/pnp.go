@@ -0,0 +1,30 @@
+package supply
+
+import (
+	"nodejs/profiled"
+	"nodejs/yarn"
+	"path/filepath"
+
+	"github.com/cloudfoundry/libbuildpack"
+)
+
+// WritePnPProfile makes node load the Plug'n'Play loader of yarn 2+ apps at
+// runtime, which resolves the packages yarn would otherwise install in
+// node_modules. yarn run does this during staging, but the start command may
+// run node directly.
+func (s *Supplier) WritePnPProfile() error {
+	found, err := libbuildpack.FileExists(filepath.Join(s.Stager.BuildDir(), yarn.PnPFile))
+	if err != nil || !found {
+		return err
+	}
+
+	options := "--require $HOME/" + yarn.PnPFile
+	if found, err := libbuildpack.FileExists(filepath.Join(s.Stager.BuildDir(), yarn.PnPLoaderFile)); err != nil {
+		return err
+	} else if found {
+		options += " --experimental-loader $HOME/" + yarn.PnPLoaderFile
+	}
+
+	s.Log.Info("Loading %s at runtime with NODE_OPTIONS", yarn.PnPFile)
+	return profiled.Write(s.Stager, "pnp.sh", `export NODE_OPTIONS="${NODE_OPTIONS:+$NODE_OPTIONS }`+options+`"`+"\n")
+}
@@ -0,0 +1,67 @@
+package supply_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"nodejs/supply"
+	"os"
+	"path/filepath"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/cloudfoundry/libbuildpack/ansicleaner"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("WritePnPProfile", func() {
+	var (
+		err      error
+		buildDir string
+		depsDir  string
+		supplier *supply.Supplier
+		buffer   *bytes.Buffer
+	)
+
+	profile := func() string {
+		return filepath.Join(depsDir, "0", "profile.d", "000_nodejs_buildpack_pnp.sh")
+	}
+
+	BeforeEach(func() {
+		buildDir, err = ioutil.TempDir("", "nodejs-buildpack.build.")
+		Expect(err).To(BeNil())
+		depsDir, err = ioutil.TempDir("", "nodejs-buildpack.deps.")
+		Expect(err).To(BeNil())
+		Expect(os.MkdirAll(filepath.Join(depsDir, "0"), 0755)).To(Succeed())
+
+		buffer = new(bytes.Buffer)
+		logger := libbuildpack.NewLogger(ansicleaner.New(buffer))
+		supplier = &supply.Supplier{
+			Stager: libbuildpack.NewStager([]string{buildDir, "", depsDir, "0"}, logger, &libbuildpack.Manifest{}),
+			Log:    logger,
+		}
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(buildDir)).To(Succeed())
+		Expect(os.RemoveAll(depsDir)).To(Succeed())
+	})
+
+	It("requires .pnp.cjs at runtime", func() {
+		Expect(ioutil.WriteFile(filepath.Join(buildDir, ".pnp.cjs"), []byte(""), 0644)).To(Succeed())
+		Expect(supplier.WritePnPProfile()).To(Succeed())
+		Expect(ioutil.ReadFile(profile())).To(Equal([]byte(`export NODE_OPTIONS="${NODE_OPTIONS:+$NODE_OPTIONS }--require $HOME/.pnp.cjs"` + "\n")))
+		Expect(buffer.String()).To(ContainSubstring("Loading .pnp.cjs at runtime with NODE_OPTIONS"))
+	})
+
+	It("loads ES modules through .pnp.loader.mjs when there is one", func() {
+		Expect(ioutil.WriteFile(filepath.Join(buildDir, ".pnp.cjs"), []byte(""), 0644)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(buildDir, ".pnp.loader.mjs"), []byte(""), 0644)).To(Succeed())
+		Expect(supplier.WritePnPProfile()).To(Succeed())
+		Expect(ioutil.ReadFile(profile())).To(ContainSubstring("--require $HOME/.pnp.cjs --experimental-loader $HOME/.pnp.loader.mjs\""))
+	})
+
+	It("does nothing without .pnp.cjs", func() {
+		Expect(supplier.WritePnPProfile()).To(Succeed())
+		Expect(profile()).NotTo(BeAnExistingFile())
+	})
+})
@@ -0,0 +1,191 @@
+package supply
+
+import (
+	"fmt"
+	"io/ioutil"
+	"nodejs/offline"
+	"nodejs/packagejson"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/cloudfoundry/libbuildpack"
+)
+
+// prismaBinaryTargets maps each stack onto the Prisma engine build which
+// links against its OpenSSL.
+var prismaBinaryTargets = map[string]string{
+	"cflinuxfs2": "debian-openssl-1.0.x",
+	"cflinuxfs3": "debian-openssl-1.1.x",
+	"cflinuxfs4": "debian-openssl-3.0.x",
+}
+
+func prismaBinaryTarget(stack string) string {
+	if target, found := prismaBinaryTargets[stack]; found {
+		return target
+	}
+	return "native"
+}
+
+func (s *Supplier) usesPrisma() (bool, error) {
+	p, _, err := packagejson.Load(s.Stager.BuildDir())
+	if err != nil {
+		return false, err
+	}
+	if !p.Depends("@prisma/client") {
+		return false, nil
+	}
+	return libbuildpack.FileExists(filepath.Join(s.Stager.BuildDir(), "prisma", "schema.prisma"))
+}
+
+func (s *Supplier) prismaVersion() (string, error) {
+	for _, name := range []string{"prisma", "@prisma/client"} {
+		var p struct {
+			Version string `json:"version"`
+		}
+		if err := loadJSONIfExists(filepath.Join(s.Stager.BuildDir(), "node_modules", name, "package.json"), &p); err != nil {
+			return "", err
+		}
+		if p.Version != "" {
+			return p.Version, nil
+		}
+	}
+	return "unknown", nil
+}
+
+// prismaMirror returns the mirror of the Prisma engines the app sets.
+func prismaMirror() string {
+	if mirror := os.Getenv("PRISMA_ENGINES_MIRROR"); mirror != "" {
+		return mirror
+	}
+	return os.Getenv("PRISMA_BINARIES_MIRROR")
+}
+
+// npxArgs returns the arguments of npx running args, which only runs the
+// packages installed already when staging is offline.
+func npxArgs(args ...string) []string {
+	if offline.Enabled() {
+		return append([]string{"--offline"}, args...)
+	}
+	return args
+}
+
+// GeneratePrismaClient runs `prisma generate` for apps depending on
+// @prisma/client with a prisma/schema.prisma. The downloaded engines are
+// kept in the app cache, keyed by prisma version, so later builds do not
+// need to fetch them again. Offline, it needs a mirror of the engines or
+// the engines cached by an earlier staging, and fails without.
+func (s *Supplier) GeneratePrismaClient() error {
+	if found, err := s.usesPrisma(); err != nil || !found {
+		return err
+	}
+
+	version, err := s.prismaVersion()
+	if err != nil {
+		return err
+	}
+
+	prismaCache := filepath.Join(s.Stager.CacheDir(), "prisma")
+	engineCache := filepath.Join(prismaCache, version)
+	if err := removeAllExcept(prismaCache, version); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(engineCache, 0755); err != nil {
+		return err
+	}
+
+	if offline.Enabled() && prismaMirror() == "" {
+		if cached, err := ioutil.ReadDir(engineCache); err != nil {
+			return err
+		} else if len(cached) == 0 {
+			return fmt.Errorf("Unable to run prisma generate, staging is offline (BP_OFFLINE) and the engines of prisma %s are not cached\nSet PRISMA_BINARIES_MIRROR (PRISMA_ENGINES_MIRROR for Prisma 4+) to the URL of an internal mirror of the Prisma engines", version)
+		}
+	}
+
+	target := prismaBinaryTarget(os.Getenv("CF_STACK"))
+	s.Log.Info("Running prisma generate (prisma %s, binary target %s)", version, target)
+
+	cmd := exec.Command("npx", npxArgs("prisma", "generate")...)
+	cmd.Dir = s.Stager.BuildDir()
+	cmd.Stdout = s.Log.Output()
+	cmd.Stderr = s.Log.Output()
+	cmd.Env = append(os.Environ(), "XDG_CACHE_HOME="+engineCache)
+	if os.Getenv("PRISMA_CLI_BINARY_TARGETS") == "" {
+		cmd.Env = append(cmd.Env, "PRISMA_CLI_BINARY_TARGETS="+target)
+	}
+	if offline.Enabled() {
+		// The checksums of the engines come from binaries.prisma.sh too.
+		cmd.Env = append(cmd.Env, "PRISMA_ENGINES_CHECKSUM_IGNORE_MISSING=1")
+	}
+	if err := s.Command.Run(cmd); err != nil {
+		return fmt.Errorf("prisma generate failed: %s\nFoundations without access to binaries.prisma.sh need an internal mirror of the Prisma engines, set PRISMA_BINARIES_MIRROR (PRISMA_ENGINES_MIRROR for Prisma 4+) to its URL", err)
+	}
+
+	if found, err := libbuildpack.FileExists(filepath.Join(s.Stager.BuildDir(), "node_modules", ".prisma", "client")); err != nil {
+		return err
+	} else if !found {
+		s.Log.Warning("prisma generate did not write node_modules/.prisma/client, the Prisma client may fail at runtime")
+	}
+
+	return nil
+}
+
+func removeAllExcept(dir, keep string) error {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	for _, entry := range entries {
+		if entry.Name() != keep {
+			if err := os.RemoveAll(filepath.Join(dir, entry.Name())); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// snapshotPrismaClient copies node_modules/.prisma, the client prisma
+// generate wrote, which is no package of the lockfile, so that pruning may
+// take it for extraneous. It returns "" when the app has no Prisma client.
+func (s *Supplier) snapshotPrismaClient() (string, error) {
+	client := filepath.Join(s.Stager.BuildDir(), "node_modules", ".prisma")
+	if found, err := libbuildpack.FileExists(client); err != nil || !found {
+		return "", err
+	}
+	snapshot, err := ioutil.TempDir("", "prisma-client")
+	if err != nil {
+		return "", err
+	}
+	if err := libbuildpack.CopyDirectory(client, snapshot); err != nil {
+		os.RemoveAll(snapshot)
+		return "", err
+	}
+	return snapshot, nil
+}
+
+// restorePrismaClient copies the snapshot of node_modules/.prisma back when
+// pruning removed the client.
+func (s *Supplier) restorePrismaClient(snapshot string) error {
+	if snapshot == "" {
+		return nil
+	}
+	client := filepath.Join(s.Stager.BuildDir(), "node_modules", ".prisma")
+	if found, err := libbuildpack.FileExists(filepath.Join(client, "client")); err != nil || found {
+		return err
+	}
+	if err := os.RemoveAll(client); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(client, 0755); err != nil {
+		return err
+	}
+	if err := libbuildpack.CopyDirectory(snapshot, client); err != nil {
+		return err
+	}
+	s.Log.Info("Restored the Prisma client in node_modules/.prisma which pruning removed")
+	return nil
+}
@@ -0,0 +1,195 @@
+package supply_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"nodejs/supply"
+	"os"
+	"path/filepath"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/cloudfoundry/libbuildpack/ansicleaner"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+const fakeNpx = `#!/bin/sh
+mkdir -p node_modules/.prisma/client
+echo "$@" > node_modules/.prisma/client/args
+echo "$XDG_CACHE_HOME" > node_modules/.prisma/client/cache
+echo "$PRISMA_CLI_BINARY_TARGETS" > node_modules/.prisma/client/target
+mkdir -p "$XDG_CACHE_HOME/prisma" && touch "$XDG_CACHE_HOME/prisma/engine"
+`
+
+var _ = Describe("GeneratePrismaClient", func() {
+	var (
+		err      error
+		buildDir string
+		cacheDir string
+		binDir   string
+		supplier *supply.Supplier
+		buffer   *bytes.Buffer
+		oldPath  string
+		oldStack string
+		oldEnv   map[string]string
+	)
+
+	writeFile := func(path, contents string) {
+		Expect(os.MkdirAll(filepath.Dir(path), 0755)).To(Succeed())
+		Expect(ioutil.WriteFile(path, []byte(contents), 0755)).To(Succeed())
+	}
+
+	readMarker := func(name string) string {
+		contents, err := ioutil.ReadFile(filepath.Join(buildDir, "node_modules", ".prisma", "client", name))
+		Expect(err).To(BeNil())
+		return string(contents)
+	}
+
+	BeforeEach(func() {
+		buildDir, err = ioutil.TempDir("", "nodejs-buildpack.build.")
+		Expect(err).To(BeNil())
+		cacheDir, err = ioutil.TempDir("", "nodejs-buildpack.cache.")
+		Expect(err).To(BeNil())
+		binDir, err = ioutil.TempDir("", "nodejs-buildpack.bin.")
+		Expect(err).To(BeNil())
+
+		writeFile(filepath.Join(binDir, "npx"), fakeNpx)
+		oldPath = os.Getenv("PATH")
+		os.Setenv("PATH", binDir+":"+oldPath)
+		oldStack = os.Getenv("CF_STACK")
+		os.Setenv("CF_STACK", "cflinuxfs3")
+		oldEnv = map[string]string{}
+		for _, key := range []string{"BP_OFFLINE", "PRISMA_ENGINES_MIRROR", "PRISMA_BINARIES_MIRROR"} {
+			oldEnv[key] = os.Getenv(key)
+			os.Setenv(key, "")
+		}
+
+		writeFile(filepath.Join(buildDir, "package.json"), `{"dependencies":{"@prisma/client":"^5.0.0"},"devDependencies":{"prisma":"^5.0.0"}}`)
+		writeFile(filepath.Join(buildDir, "prisma", "schema.prisma"), "generator client {\n  provider = \"prisma-client-js\"\n}\n")
+		writeFile(filepath.Join(buildDir, "node_modules", "prisma", "package.json"), `{"version":"5.1.0"}`)
+
+		buffer = new(bytes.Buffer)
+		logger := libbuildpack.NewLogger(ansicleaner.New(buffer))
+		supplier = &supply.Supplier{
+			Stager:  libbuildpack.NewStager([]string{buildDir, cacheDir, "", "0"}, logger, &libbuildpack.Manifest{}),
+			Log:     logger,
+			Command: &libbuildpack.Command{},
+		}
+	})
+
+	AfterEach(func() {
+		os.Setenv("PATH", oldPath)
+		os.Setenv("CF_STACK", oldStack)
+		for key, value := range oldEnv {
+			os.Setenv(key, value)
+		}
+		Expect(os.RemoveAll(buildDir)).To(Succeed())
+		Expect(os.RemoveAll(cacheDir)).To(Succeed())
+		Expect(os.RemoveAll(binDir)).To(Succeed())
+	})
+
+	It("runs prisma generate for the stack's binary target", func() {
+		Expect(supplier.GeneratePrismaClient()).To(Succeed())
+		Expect(readMarker("args")).To(Equal("prisma generate\n"))
+		Expect(readMarker("target")).To(Equal("debian-openssl-1.1.x\n"))
+		Expect(buffer.String()).To(ContainSubstring("Running prisma generate (prisma 5.1.0, binary target debian-openssl-1.1.x)"))
+	})
+
+	It("caches engines keyed by prisma version", func() {
+		Expect(os.MkdirAll(filepath.Join(cacheDir, "prisma", "4.16.0"), 0755)).To(Succeed())
+
+		Expect(supplier.GeneratePrismaClient()).To(Succeed())
+		Expect(readMarker("cache")).To(Equal(filepath.Join(cacheDir, "prisma", "5.1.0") + "\n"))
+		Expect(filepath.Join(cacheDir, "prisma", "5.1.0", "prisma", "engine")).To(BeAnExistingFile())
+		Expect(filepath.Join(cacheDir, "prisma", "4.16.0")).NotTo(BeADirectory())
+	})
+
+	It("points at the engines mirror when generate fails", func() {
+		writeFile(filepath.Join(binDir, "npx"), "#!/bin/sh\nexit 1\n")
+		Expect(supplier.GeneratePrismaClient()).To(MatchError(ContainSubstring("PRISMA_BINARIES_MIRROR")))
+	})
+
+	Context("when staging is offline", func() {
+		BeforeEach(func() {
+			os.Setenv("BP_OFFLINE", "true")
+		})
+
+		It("fails without a mirror or cached engines", func() {
+			Expect(supplier.GeneratePrismaClient()).To(MatchError(ContainSubstring("Unable to run prisma generate, staging is offline (BP_OFFLINE) and the engines of prisma 5.1.0 are not cached")))
+			Expect(filepath.Join(buildDir, "node_modules", ".prisma")).NotTo(BeADirectory())
+		})
+
+		It("runs npx offline with a mirror of the engines", func() {
+			os.Setenv("PRISMA_ENGINES_MIRROR", "https://prisma-mirror.internal")
+			Expect(supplier.GeneratePrismaClient()).To(Succeed())
+			Expect(readMarker("args")).To(Equal("--offline prisma generate\n"))
+		})
+
+		It("runs npx offline with the engines cached by an earlier staging", func() {
+			writeFile(filepath.Join(cacheDir, "prisma", "5.1.0", "prisma", "engine"), "")
+			Expect(supplier.GeneratePrismaClient()).To(Succeed())
+			Expect(readMarker("args")).To(Equal("--offline prisma generate\n"))
+		})
+	})
+
+	Context("when pruning removes node_modules/.prisma", func() {
+		var pruneEnv map[string]*string
+
+		BeforeEach(func() {
+			writeFile(filepath.Join(binDir, "npm"), "#!/bin/sh\nif [ \"$1\" = --version ]; then echo 8.19.4; else rm -rf node_modules/.prisma; fi\n")
+			pruneEnv = map[string]*string{}
+			for _, key := range []string{"BP_PRUNE_OMIT", "BP_PRUNE_KEEP", "NODE_ENV", "BP_RUNTIME_NODE_ENV", "NPM_CONFIG_PRODUCTION"} {
+				if value, found := os.LookupEnv(key); found {
+					pruneEnv[key] = &value
+				} else {
+					pruneEnv[key] = nil
+				}
+			}
+			os.Unsetenv("BP_RUNTIME_NODE_ENV")
+			os.Unsetenv("NPM_CONFIG_PRODUCTION")
+			os.Setenv("NODE_ENV", "production")
+			os.Setenv("BP_PRUNE_KEEP", "")
+			os.Setenv("BP_PRUNE_OMIT", "dev")
+		})
+
+		AfterEach(func() {
+			for key, value := range pruneEnv {
+				if value == nil {
+					os.Unsetenv(key)
+				} else {
+					os.Setenv(key, *value)
+				}
+			}
+		})
+
+		It("restores the generated client", func() {
+			Expect(supplier.GeneratePrismaClient()).To(Succeed())
+			Expect(supplier.PruneDependencies()).To(Succeed())
+			Expect(readMarker("args")).To(Equal("prisma generate\n"))
+			Expect(buffer.String()).To(ContainSubstring("Restored the Prisma client in node_modules/.prisma which pruning removed"))
+		})
+	})
+
+	Context("without a prisma schema", func() {
+		BeforeEach(func() {
+			Expect(os.RemoveAll(filepath.Join(buildDir, "prisma"))).To(Succeed())
+		})
+
+		It("does nothing", func() {
+			Expect(supplier.GeneratePrismaClient()).To(Succeed())
+			Expect(filepath.Join(buildDir, "node_modules", ".prisma")).NotTo(BeADirectory())
+			Expect(buffer.String()).To(Equal(""))
+		})
+	})
+
+	Context("without @prisma/client", func() {
+		BeforeEach(func() {
+			writeFile(filepath.Join(buildDir, "package.json"), `{"dependencies":{}}`)
+		})
+
+		It("does nothing", func() {
+			Expect(supplier.GeneratePrismaClient()).To(Succeed())
+			Expect(filepath.Join(buildDir, "node_modules", ".prisma")).NotTo(BeADirectory())
+		})
+	})
+})
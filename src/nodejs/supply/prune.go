@@ -0,0 +1,315 @@
+package supply
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"nodejs/failure"
+	"nodejs/packagejson"
+	"nodejs/prune"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// PruneDependencies removes the dependency types listed in BP_PRUNE_OMIT from
+// node_modules once the build scripts have run. Without BP_PRUNE_OMIT only
+// NPM_CONFIG_PRODUCTION=true keeps dev dependencies out: the production
+// install, or pruning dev when it was deferred for the build scripts.
+// The dev dependencies listed in BP_PRUNE_KEEP survive pruning dev, and so
+// does patch-package, to apply the patches of the app again afterwards.
+func (s *Supplier) PruneDependencies() error {
+	value := os.Getenv("BP_PRUNE_OMIT")
+	if s.DeferredProduction {
+		// The install is done, so NPM_CONFIG_PRODUCTION=true applies again.
+		s.restoreNPMStagingEnv(func(name string) bool {
+			return strings.EqualFold(name, "npm_config_production") || name == "npm_config_include" || name == "YARN_PRODUCTION"
+		})
+		if omit, _ := prune.ParseOmit(value); !containsString(omit, prune.Dev) {
+			s.Log.Info("Pruning devDependencies for NPM_CONFIG_PRODUCTION=true")
+			value = strings.Trim(value+","+prune.Dev, ",")
+		}
+	}
+	if value == "" {
+		return nil
+	}
+
+	if reason := ResolveNodeEnv(os.Environ()).PruneSkipReason(os.Environ()); reason != "" {
+		s.Log.Info("Skipping pruning (BP_PRUNE_OMIT): %s", reason)
+		return nil
+	}
+
+	omit, err := prune.ParseOmit(value)
+	if err != nil {
+		return err
+	}
+
+	manager := prune.NPM
+	if s.UseYarn {
+		manager = prune.Yarn
+	}
+
+	buffer := new(bytes.Buffer)
+	if err := s.Command.Execute(s.Stager.BuildDir(), buffer, buffer, manager, "--version"); err != nil {
+		return err
+	}
+
+	version := strings.TrimSpace(buffer.String())
+	if version == "" && s.DryRun {
+		// The version of the npm bundled with node, which picks the
+		// command, is only known once node was downloaded.
+		s.Log.Info("Pruning %s with %s", strings.Join(omit, ", "), manager)
+		return nil
+	}
+	args, err := prune.Command(manager, version, omit)
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		s.Log.Info("Nothing to prune with %s for BP_PRUNE_OMIT=%s", manager, value)
+		return nil
+	}
+
+	patched, patchPackageDev, err := s.usesPatchPackage()
+	if err != nil {
+		return err
+	}
+	keep := prune.ParseKeep(os.Getenv("BP_PRUNE_KEEP"))
+	if patched && patchPackageDev && containsString(omit, prune.Dev) && !containsString(keep, "patch-package") {
+		s.Log.Info("Keeping patch-package to re-apply the patches in patches/ after pruning")
+		keep = append(keep, "patch-package")
+	}
+
+	if len(keep) > 0 && containsString(omit, prune.Dev) {
+		restore, err := s.keepDevDependencies(keep)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if err := restore(); err != nil {
+				s.Log.Warning("Unable to restore package.json after pruning: %s", err.Error())
+			}
+		}()
+	}
+
+	preserved, err := s.preserveLocalPackages()
+	if err != nil {
+		return err
+	}
+
+	prismaClient, err := s.snapshotPrismaClient()
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(prismaClient)
+
+	nodeModules := filepath.Join(s.Stager.BuildDir(), "node_modules")
+	before, err := prune.CountPackages(nodeModules)
+	if err != nil {
+		return err
+	}
+
+	s.Log.Info("Pruning dependencies: %s", strings.Join(args, " "))
+	if err := s.Command.Execute(s.Stager.BuildDir(), s.Log.Output(), s.Log.Output(), args[0], args[1:]...); err != nil {
+		return failure.Wrap(failure.PruneFailed, err)
+	}
+
+	if preserved != nil {
+		restored, err := preserved.Restore()
+		if err != nil {
+			return err
+		}
+		if restored > 0 {
+			s.Log.Info("Restored %d dirs of local packages and their dependencies which pruning removed", restored)
+		}
+	}
+	if err := s.restorePrismaClient(prismaClient); err != nil {
+		return err
+	}
+
+	after, err := prune.CountPackages(nodeModules)
+	if err != nil {
+		return err
+	}
+	if empty, err := prune.IsEmpty(nodeModules); err != nil {
+		return err
+	} else if empty {
+		s.Log.Info("Pruned node_modules from %d packages to none, the app has no production dependencies", before)
+	} else {
+		s.Log.Info("Pruned node_modules from %d to %d packages", before, after)
+	}
+
+	repair, err := prune.RepairBinLinks(s.Stager.BuildDir())
+	if err != nil {
+		return err
+	}
+	s.Log.Info("Repaired node_modules/.bin: removed %d dangling links, linked %d missing executables", repair.Removed, repair.Linked)
+
+	// Pruning with yarn reinstalls the remaining packages, which reverts
+	// the patches.
+	if patched {
+		return failure.Wrap(failure.PruneFailed, s.ApplyPatches())
+	}
+	return nil
+}
+
+// preserveLocalPackages fails for dependencies on local packages, through
+// file:, link:, portal: or workspace: ranges, which are not in the app, and
+// otherwise snapshots the node_modules of the local packages and the packages
+// only they need, which pruning would take for extraneous. It returns nil
+// when the app has no local packages.
+func (s *Supplier) preserveLocalPackages() (*prune.Preserved, error) {
+	graph, err := prune.LoadLockfile(s.Stager.BuildDir())
+	if err != nil {
+		return nil, err
+	}
+	locals, err := prune.LocalPackages(s.Stager.BuildDir(), graph)
+	if err != nil || len(locals) == 0 {
+		return nil, err
+	}
+	if err := prune.CheckLocalPackages(s.Stager.BuildDir(), locals); err != nil {
+		return nil, failure.Wrap(failure.LocalPackageMissing, err)
+	}
+
+	var dirs []string
+	if graph != nil {
+		pkg, _, err := packagejson.Load(s.Stager.BuildDir())
+		if err != nil {
+			return nil, err
+		}
+		if dirs, err = graph.LocalClosure(s.Stager.BuildDir(), mergeMaps(pkg.Dependencies, pkg.OptionalDependencies), locals); err != nil {
+			return nil, err
+		}
+	}
+
+	names := make([]string, len(locals))
+	for i, local := range locals {
+		names[i] = local.String()
+	}
+	s.Log.Info("Preserving the local packages %s and %d packages only they need while pruning", strings.Join(names, ", "), len(dirs))
+	return prune.PreserveLocalPackages(s.Stager.BuildDir(), locals, dirs)
+}
+
+// CheckLocalPackageCycles fails before the install for dependencies on local
+// packages which lead back to a package of the chain, or to the app by its
+// name: npm loops on them, or fails with ELOOP without naming them.
+func (s *Supplier) CheckLocalPackageCycles() error {
+	cycle, err := prune.FindLocalCycle(s.Stager.BuildDir())
+	if err != nil || cycle == nil {
+		return err
+	}
+	return failure.Wrap(failure.LocalPackageCycle, fmt.Errorf("the dependencies on local packages form a cycle, which npm can't install:\n  %s\nRemove one of the dependencies of the cycle", cycle))
+}
+
+// pruneLockfiles are restored along with package.json, in case the package
+// manager rewrites them for the promoted packages.
+var pruneLockfiles = []string{"package-lock.json", "npm-shrinkwrap.json", "yarn.lock"}
+
+// keepDevDependencies promotes the dev dependencies in keep to production
+// dependencies in package.json, so that pruning leaves them and their
+// dependencies in place, and logs the packages this preserves according to
+// the lockfile. The returned function restores package.json and the
+// lockfiles.
+func (s *Supplier) keepDevDependencies(keep []string) (func() error, error) {
+	noop := func() error { return nil }
+
+	path := filepath.Join(s.Stager.BuildDir(), "package.json")
+	original, err := ioutil.ReadFile(path)
+	if err != nil {
+		return noop, err
+	}
+	var pkg map[string]json.RawMessage
+	if err := json.Unmarshal(original, &pkg); err != nil {
+		return noop, err
+	}
+	deps := map[string]map[string]string{}
+	for _, field := range []string{"dependencies", "optionalDependencies", "devDependencies"} {
+		values := map[string]string{}
+		if raw, found := pkg[field]; found {
+			if err := json.Unmarshal(raw, &values); err != nil {
+				return noop, fmt.Errorf("unable to parse %s in package.json: %s", field, err)
+			}
+		}
+		deps[field] = values
+	}
+
+	promote := map[string]string{}
+	for _, name := range keep {
+		if spec, found := deps["devDependencies"][name]; found {
+			promote[name] = spec
+			continue
+		}
+		if _, found := deps["dependencies"][name]; found {
+			s.Log.Info("%s from BP_PRUNE_KEEP is a production dependency already", name)
+			continue
+		}
+		s.Log.Warning("BP_PRUNE_KEEP lists %s, which is not a devDependency in package.json", name)
+	}
+	if len(promote) == 0 {
+		return noop, nil
+	}
+
+	production := mergeMaps(deps["dependencies"], deps["optionalDependencies"])
+	graph, err := prune.LoadLockfile(s.Stager.BuildDir())
+	if err != nil {
+		return noop, err
+	}
+	if graph == nil {
+		var names []string
+		for name := range promote {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		s.Log.Info("Keeping %s (BP_PRUNE_KEEP) and their dependencies, there is no lockfile to list them", strings.Join(names, ", "))
+	} else {
+		kept := graph.Keep(production, promote)
+		lines := make([]string, len(kept))
+		for i, k := range kept {
+			reason := "listed in BP_PRUNE_KEEP"
+			if k.RequiredBy != "" {
+				reason = "required by " + k.RequiredBy
+			}
+			lines[i] = fmt.Sprintf("  %s (%s)", k.Package, reason)
+		}
+		s.Log.Info("Keeping %d packages which pruning would remove (BP_PRUNE_KEEP, resolved from %s):\n%s", len(kept), graph.Lockfile, strings.Join(lines, "\n"))
+	}
+
+	saved := map[string][]byte{"package.json": original}
+	for _, name := range pruneLockfiles {
+		contents, err := ioutil.ReadFile(filepath.Join(s.Stager.BuildDir(), name))
+		if err == nil {
+			saved[name] = contents
+		} else if !os.IsNotExist(err) {
+			return noop, err
+		}
+	}
+	restore := func() error {
+		for name, contents := range saved {
+			if err := ioutil.WriteFile(filepath.Join(s.Stager.BuildDir(), name), contents, 0644); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for name, spec := range promote {
+		deps["dependencies"][name] = spec
+		delete(deps["devDependencies"], name)
+	}
+	for _, field := range []string{"dependencies", "devDependencies"} {
+		if pkg[field], err = json.Marshal(deps[field]); err != nil {
+			return noop, err
+		}
+	}
+	promoted, err := json.MarshalIndent(pkg, "", "  ")
+	if err != nil {
+		return noop, err
+	}
+	if err := ioutil.WriteFile(path, promoted, 0644); err != nil {
+		restore()
+		return noop, err
+	}
+	return restore, nil
+}
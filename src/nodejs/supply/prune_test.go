@@ -0,0 +1,314 @@
+package supply_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"nodejs/failure"
+	"nodejs/supply"
+	"os"
+	"path/filepath"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/cloudfoundry/libbuildpack/ansicleaner"
+	"golang.google.cn/x/mock/gomock"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("PruneDependencies", func() {
+	var (
+		err         error
+		buildDir    string
+		supplier    *supply.Supplier
+		buffer      *bytes.Buffer
+		mockCtrl    *gomock.Controller
+		mockCommand *MockCommand
+		oldEnv      map[string]string
+	)
+
+	BeforeEach(func() {
+		buildDir, err = ioutil.TempDir("", "nodejs-buildpack.build.")
+		Expect(err).To(BeNil())
+
+		oldEnv = map[string]string{}
+		for _, key := range []string{"BP_PRUNE_OMIT", "BP_PRUNE_KEEP", "NODE_ENV", "BP_RUNTIME_NODE_ENV", "NPM_CONFIG_PRODUCTION", "BP_NODE_RUN_SCRIPTS"} {
+			oldEnv[key] = os.Getenv(key)
+		}
+		os.Unsetenv("BP_RUNTIME_NODE_ENV")
+		os.Unsetenv("NPM_CONFIG_PRODUCTION")
+		os.Setenv("NODE_ENV", "production")
+		os.Setenv("BP_PRUNE_KEEP", "")
+		os.Setenv("BP_NODE_RUN_SCRIPTS", "")
+
+		for _, pkg := range []string{"express", "mocha"} {
+			Expect(os.MkdirAll(filepath.Join(buildDir, "node_modules", pkg), 0755)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(buildDir, "node_modules", pkg, "package.json"), []byte("{}"), 0644)).To(Succeed())
+		}
+
+		mockCtrl = gomock.NewController(GinkgoT())
+		mockCommand = NewMockCommand(mockCtrl)
+
+		buffer = new(bytes.Buffer)
+		logger := libbuildpack.NewLogger(ansicleaner.New(buffer))
+		supplier = &supply.Supplier{
+			Stager:  libbuildpack.NewStager([]string{buildDir, "", "", "0"}, logger, &libbuildpack.Manifest{}),
+			Command: mockCommand,
+			Log:     logger,
+		}
+	})
+
+	AfterEach(func() {
+		mockCtrl.Finish()
+		for key, value := range oldEnv {
+			os.Setenv(key, value)
+		}
+		Expect(os.RemoveAll(buildDir)).To(Succeed())
+	})
+
+	version := func(program, v string) {
+		mockCommand.EXPECT().Execute(buildDir, gomock.Any(), gomock.Any(), program, "--version").Do(func(_ string, buffer io.Writer, _ io.Writer, _ string, _ string) {
+			buffer.Write([]byte(v + "\n"))
+		}).Return(nil)
+	}
+
+	It("does nothing without BP_PRUNE_OMIT", func() {
+		os.Setenv("BP_PRUNE_OMIT", "")
+		Expect(supplier.PruneDependencies()).To(Succeed())
+		Expect(buffer.String()).To(Equal(""))
+	})
+
+	It("skips pruning when NODE_ENV is not production", func() {
+		os.Setenv("BP_PRUNE_OMIT", "dev")
+		os.Setenv("NODE_ENV", "development")
+		Expect(supplier.PruneDependencies()).To(Succeed())
+		Expect(buffer.String()).To(Equal("       Skipping pruning (BP_PRUNE_OMIT): the app runs with NODE_ENV=development (NODE_ENV), not production\n"))
+	})
+
+	It("skips pruning when the app runs with another NODE_ENV than the build", func() {
+		os.Setenv("BP_PRUNE_OMIT", "dev")
+		os.Setenv("BP_RUNTIME_NODE_ENV", "test")
+		Expect(supplier.PruneDependencies()).To(Succeed())
+		Expect(buffer.String()).To(Equal("       Skipping pruning (BP_PRUNE_OMIT): the app runs with NODE_ENV=test (BP_RUNTIME_NODE_ENV), not production\n"))
+	})
+
+	It("repairs node_modules/.bin after pruning", func() {
+		os.Setenv("BP_PRUNE_OMIT", "dev")
+		binDir := filepath.Join(buildDir, "node_modules", ".bin")
+		Expect(os.MkdirAll(binDir, 0755)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(buildDir, "node_modules", "mocha", "mocha.js"), []byte("#!/usr/bin/env node\n"), 0755)).To(Succeed())
+		Expect(os.Symlink("../mocha/mocha.js", filepath.Join(binDir, "mocha"))).To(Succeed())
+		version("npm", "8.19.4")
+		mockCommand.EXPECT().Execute(buildDir, gomock.Any(), gomock.Any(), "npm", "prune", "--omit=dev").Do(func(_ string, _ io.Writer, _ io.Writer, _ string, _ ...string) {
+			Expect(os.RemoveAll(filepath.Join(buildDir, "node_modules", "mocha"))).To(Succeed())
+		}).Return(nil)
+
+		Expect(supplier.PruneDependencies()).To(Succeed())
+		Expect(buffer.String()).To(ContainSubstring("Repaired node_modules/.bin: removed 1 dangling links, linked 0 missing executables"))
+		Expect(filepath.Join(binDir, "mocha")).NotTo(BeAnExistingFile())
+	})
+
+	It("prunes a development build of an app that runs in production", func() {
+		os.Setenv("BP_PRUNE_OMIT", "dev")
+		os.Setenv("NODE_ENV", "development")
+		os.Setenv("BP_RUNTIME_NODE_ENV", "production")
+		version("npm", "8.19.4")
+		mockCommand.EXPECT().Execute(buildDir, gomock.Any(), gomock.Any(), "npm", "prune", "--omit=dev").Return(nil)
+
+		Expect(supplier.PruneDependencies()).To(Succeed())
+		Expect(buffer.String()).To(ContainSubstring("Pruning dependencies: npm prune --omit=dev"))
+	})
+
+	It("prunes with the flags of the installed npm and logs package counts", func() {
+		os.Setenv("BP_PRUNE_OMIT", "dev,optional")
+		version("npm", "8.19.4")
+		mockCommand.EXPECT().Execute(buildDir, gomock.Any(), gomock.Any(), "npm", "prune", "--omit=dev", "--omit=optional").Do(func(_ string, _ io.Writer, _ io.Writer, _ string, _ ...string) {
+			Expect(os.RemoveAll(filepath.Join(buildDir, "node_modules", "mocha"))).To(Succeed())
+		}).Return(nil)
+
+		Expect(supplier.PruneDependencies()).To(Succeed())
+		Expect(buffer.String()).To(ContainSubstring("Pruning dependencies: npm prune --omit=dev --omit=optional"))
+		Expect(buffer.String()).To(ContainSubstring("Pruned node_modules from 2 to 1 packages"))
+	})
+
+	It("says so when pruning leaves no production dependencies", func() {
+		os.Setenv("BP_PRUNE_OMIT", "dev")
+		version("npm", "8.19.4")
+		mockCommand.EXPECT().Execute(buildDir, gomock.Any(), gomock.Any(), "npm", "prune", "--omit=dev").Do(func(_ string, _ io.Writer, _ io.Writer, _ string, _ ...string) {
+			Expect(os.RemoveAll(filepath.Join(buildDir, "node_modules", "mocha"))).To(Succeed())
+			Expect(os.RemoveAll(filepath.Join(buildDir, "node_modules", "express"))).To(Succeed())
+		}).Return(nil)
+
+		Expect(supplier.PruneDependencies()).To(Succeed())
+		Expect(buffer.String()).To(ContainSubstring("Pruned node_modules from 2 packages to none, the app has no production dependencies"))
+		Expect(buffer.String()).NotTo(ContainSubstring("WARNING"))
+	})
+
+	It("prunes with yarn", func() {
+		os.Setenv("BP_PRUNE_OMIT", "dev")
+		supplier.UseYarn = true
+		version("yarn", "1.22.19")
+		mockCommand.EXPECT().Execute(buildDir, gomock.Any(), gomock.Any(), "yarn", "install", "--pure-lockfile", "--ignore-engines", "--production=true").Return(nil)
+
+		Expect(supplier.PruneDependencies()).To(Succeed())
+		Expect(buffer.String()).To(ContainSubstring("Pruning dependencies: yarn install --pure-lockfile --ignore-engines --production=true"))
+	})
+
+	Context("when NPM_CONFIG_PRODUCTION=true is deferred for the build scripts", func() {
+		BeforeEach(func() {
+			os.Setenv("BP_PRUNE_OMIT", "")
+			os.Setenv("NPM_CONFIG_PRODUCTION", "true")
+			supplier.PostBuild = "webpack"
+		})
+
+		It("installs devDependencies and prunes them after the build without BP_PRUNE_OMIT", func() {
+			Expect(supplier.SetupNPMStagingDefaults()).To(Succeed())
+			defer supplier.CleanupNPMStagingDefaults()
+			Expect(supplier.DeferredProduction).To(BeTrue())
+			Expect(os.Getenv("NPM_CONFIG_PRODUCTION")).To(Equal("false"))
+			Expect(os.Getenv("npm_config_include")).To(Equal("dev"))
+			Expect(buffer.String()).To(ContainSubstring("NPM_CONFIG_PRODUCTION=true is deferred to pruning after the build, so that the build scripts get devDependencies"))
+
+			version("npm", "8.19.4")
+			mockCommand.EXPECT().Execute(buildDir, gomock.Any(), gomock.Any(), "npm", "prune", "--omit=dev").Do(func(_ string, _ io.Writer, _ io.Writer, _ string, _ ...string) {
+				Expect(os.Getenv("NPM_CONFIG_PRODUCTION")).To(Equal("true"))
+				Expect(os.Getenv("npm_config_include")).To(Equal(""))
+			}).Return(nil)
+
+			Expect(supplier.PruneDependencies()).To(Succeed())
+			Expect(buffer.String()).To(ContainSubstring("Pruning devDependencies for NPM_CONFIG_PRODUCTION=true"))
+			Expect(buffer.String()).To(ContainSubstring("Pruning dependencies: npm prune --omit=dev"))
+
+			supplier.CleanupNPMStagingDefaults()
+			Expect(os.Getenv("NPM_CONFIG_PRODUCTION")).To(Equal("true"))
+		})
+
+		It("adds dev to the types of BP_PRUNE_OMIT", func() {
+			os.Setenv("BP_PRUNE_OMIT", "optional")
+			supplier.DeferredProduction = true
+			version("npm", "8.19.4")
+			mockCommand.EXPECT().Execute(buildDir, gomock.Any(), gomock.Any(), "npm", "prune", "--omit=dev", "--omit=optional").Return(nil)
+
+			Expect(supplier.PruneDependencies()).To(Succeed())
+		})
+
+		It("installs the production dependencies only with BP_INSTALL_PRODUCTION_ONLY=true", func() {
+			oldProductionOnly := os.Getenv("BP_INSTALL_PRODUCTION_ONLY")
+			defer os.Setenv("BP_INSTALL_PRODUCTION_ONLY", oldProductionOnly)
+			os.Setenv("BP_INSTALL_PRODUCTION_ONLY", "true")
+
+			Expect(supplier.SetupNPMStagingDefaults()).To(Succeed())
+			defer supplier.CleanupNPMStagingDefaults()
+			Expect(supplier.DeferredProduction).To(BeFalse())
+			Expect(os.Getenv("NPM_CONFIG_PRODUCTION")).To(Equal("true"))
+
+			Expect(supplier.PruneDependencies()).To(Succeed())
+			Expect(buffer.String()).To(Equal(""))
+		})
+	})
+
+	It("fails for dependency types the package manager cannot omit", func() {
+		os.Setenv("BP_PRUNE_OMIT", "optional")
+		version("npm", "6.14.18")
+		Expect(supplier.PruneDependencies()).To(MatchError(ContainSubstring("npm 7 or later is needed")))
+	})
+
+	Context("with local packages", func() {
+		BeforeEach(func() {
+			os.Setenv("BP_PRUNE_OMIT", "dev")
+			Expect(os.MkdirAll(filepath.Join(buildDir, "lib", "node_modules", "lodash"), 0755)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(buildDir, "lib", "package.json"), []byte(`{"name": "lib", "dependencies": {"lodash": "^4.17.21"}}`), 0644)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(buildDir, "lib", "node_modules", "lodash", "package.json"), []byte("{}"), 0644)).To(Succeed())
+		})
+
+		It("restores the node_modules of local packages which pruning removed", func() {
+			Expect(ioutil.WriteFile(filepath.Join(buildDir, "package.json"), []byte(`{"dependencies": {"express": "^4.18.2", "lib": "file:lib"}}`), 0644)).To(Succeed())
+			version("npm", "8.19.4")
+			mockCommand.EXPECT().Execute(buildDir, gomock.Any(), gomock.Any(), "npm", "prune", "--omit=dev").Do(func(_ string, _ io.Writer, _ io.Writer, _ string, _ ...string) {
+				Expect(os.RemoveAll(filepath.Join(buildDir, "lib", "node_modules"))).To(Succeed())
+			}).Return(nil)
+
+			Expect(supplier.PruneDependencies()).To(Succeed())
+			Expect(filepath.Join(buildDir, "lib", "node_modules", "lodash", "package.json")).To(BeAnExistingFile())
+			Expect(buffer.String()).To(ContainSubstring("Preserving the local packages lib (file:lib) and 0 packages only they need while pruning"))
+			Expect(buffer.String()).To(ContainSubstring("Restored 1 dirs of local packages and their dependencies which pruning removed"))
+		})
+
+		It("fails for local packages outside the app", func() {
+			Expect(ioutil.WriteFile(filepath.Join(buildDir, "package.json"), []byte(`{"dependencies": {"lib": "file:../lib"}}`), 0644)).To(Succeed())
+			version("npm", "8.19.4")
+
+			err := supplier.PruneDependencies()
+			Expect(err).To(MatchError(ContainSubstring("lib (file:../lib) is in ../lib, outside the app")))
+			Expect(failure.CodeOf(err)).To(Equal(failure.LocalPackageMissing))
+		})
+	})
+
+	Context("with BP_PRUNE_KEEP", func() {
+		var packageJSON []byte
+
+		BeforeEach(func() {
+			for _, name := range []string{"package.json", "package-lock.json"} {
+				contents, err := ioutil.ReadFile(filepath.Join("..", "prune", "testdata", "npm", name))
+				Expect(err).To(BeNil())
+				Expect(ioutil.WriteFile(filepath.Join(buildDir, name), contents, 0644)).To(Succeed())
+			}
+			packageJSON, err = ioutil.ReadFile(filepath.Join(buildDir, "package.json"))
+			Expect(err).To(BeNil())
+
+			os.Setenv("BP_PRUNE_OMIT", "dev")
+			version("npm", "8.19.4")
+		})
+
+		It("promotes the kept packages while pruning and logs what they preserve", func() {
+			os.Setenv("BP_PRUNE_KEEP", "ejs, express,left-pad")
+			mockCommand.EXPECT().Execute(buildDir, gomock.Any(), gomock.Any(), "npm", "prune", "--omit=dev").Do(func(_ string, _ io.Writer, _ io.Writer, _ string, _ ...string) {
+				var pkg struct {
+					Dependencies    map[string]string `json:"dependencies"`
+					DevDependencies map[string]string `json:"devDependencies"`
+				}
+				Expect(libbuildpack.NewJSON().Load(filepath.Join(buildDir, "package.json"), &pkg)).To(Succeed())
+				Expect(pkg.Dependencies).To(Equal(map[string]string{"express": "^4.18.2", "ejs": "^3.1.9"}))
+				Expect(pkg.DevDependencies).To(Equal(map[string]string{"jest": "^29.7.0"}))
+			}).Return(nil)
+
+			Expect(supplier.PruneDependencies()).To(Succeed())
+
+			Expect(ioutil.ReadFile(filepath.Join(buildDir, "package.json"))).To(Equal(packageJSON))
+			Expect(buffer.String()).To(ContainSubstring("express from BP_PRUNE_KEEP is a production dependency already"))
+			Expect(buffer.String()).To(ContainSubstring("BP_PRUNE_KEEP lists left-pad, which is not a devDependency in package.json"))
+			Expect(buffer.String()).To(ContainSubstring("Keeping 7 packages which pruning would remove (BP_PRUNE_KEEP, resolved from package-lock.json):"))
+			Expect(buffer.String()).To(ContainSubstring("ejs@3.1.9 (listed in BP_PRUNE_KEEP)"))
+			Expect(buffer.String()).To(ContainSubstring("chalk@4.1.2 (required by jake)"))
+			Expect(buffer.String()).NotTo(ContainSubstring("pretty-format"))
+		})
+
+		It("restores package.json when pruning fails", func() {
+			os.Setenv("BP_PRUNE_KEEP", "ejs")
+			mockCommand.EXPECT().Execute(buildDir, gomock.Any(), gomock.Any(), "npm", "prune", "--omit=dev").Return(errors.New("prune failed"))
+
+			err := supplier.PruneDependencies()
+			Expect(err).To(MatchError("prune failed"))
+			Expect(failure.CodeOf(err)).To(Equal(failure.PruneFailed))
+			Expect(ioutil.ReadFile(filepath.Join(buildDir, "package.json"))).To(Equal(packageJSON))
+		})
+	})
+
+	Describe("CheckLocalPackageCycles", func() {
+		It("names the cycle of local packages", func() {
+			Expect(ioutil.WriteFile(filepath.Join(buildDir, "package.json"), []byte(`{"name": "shop", "dependencies": {"mylib": "file:./packages/mylib"}}`), 0644)).To(Succeed())
+			Expect(os.MkdirAll(filepath.Join(buildDir, "packages", "mylib"), 0755)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(buildDir, "packages", "mylib", "package.json"), []byte(`{"name": "mylib", "dependencies": {"shop": "*"}}`), 0644)).To(Succeed())
+
+			err := supplier.CheckLocalPackageCycles()
+			Expect(err).To(MatchError("the dependencies on local packages form a cycle, which npm can't install:\n  shop -> mylib (file:./packages/mylib) -> shop (*)\nRemove one of the dependencies of the cycle"))
+			Expect(failure.CodeOf(err)).To(Equal(failure.LocalPackageCycle))
+		})
+
+		It("passes an app without local packages", func() {
+			Expect(ioutil.WriteFile(filepath.Join(buildDir, "package.json"), []byte(`{"name": "shop", "dependencies": {"express": "^4.18.2"}}`), 0644)).To(Succeed())
+			Expect(supplier.CheckLocalPackageCycles()).To(Succeed())
+		})
+	})
+})
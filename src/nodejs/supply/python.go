@@ -0,0 +1,50 @@
+package supply
+
+import (
+	"fmt"
+	"nodejs/python"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// SetupNodeGypPython points node-gyp at the python on the stack matching
+// BP_NODE_GYP_PYTHON or else engines.python, by exporting npm_config_python
+// and PYTHON to the install subprocesses. It fails when no python matches,
+// since native builds would otherwise fail later with a less helpful error.
+func (s *Supplier) SetupNodeGypPython() error {
+	constraint, source := os.Getenv("BP_NODE_GYP_PYTHON"), "BP_NODE_GYP_PYTHON"
+	if constraint == "" {
+		constraint, source = s.PythonVersion, "engines.python"
+	}
+	if constraint == "" {
+		return nil
+	}
+
+	interpreters, err := python.Find(s.Command, filepath.SplitList(os.Getenv("PATH")))
+	if err != nil {
+		return fmt.Errorf("Unable to find python: %s", err)
+	}
+	interpreter, found, err := python.Select(interpreters, constraint)
+	if err != nil {
+		return fmt.Errorf("Unable to resolve %s: %s", source, err)
+	}
+	if !found {
+		available := []string{"  none"}
+		if len(interpreters) > 0 {
+			available = nil
+			for _, i := range interpreters {
+				available = append(available, "  "+i.String())
+			}
+		}
+		return fmt.Errorf("No python on the stack matches %s from %s, available:\n%s", constraint, source, strings.Join(available, "\n"))
+	}
+
+	for _, name := range []string{"npm_config_python", "PYTHON"} {
+		if err := os.Setenv(name, interpreter.Path); err != nil {
+			return err
+		}
+	}
+	s.Log.Info("Using python %s for node-gyp, %s asks for %s", interpreter, source, constraint)
+	return nil
+}
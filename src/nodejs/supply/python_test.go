@@ -0,0 +1,143 @@
+package supply_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"nodejs/supply"
+	"os"
+	"path/filepath"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/cloudfoundry/libbuildpack/ansicleaner"
+	"golang.google.cn/x/mock/gomock"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("SetupNodeGypPython", func() {
+	var (
+		err         error
+		binDir      string
+		supplier    *supply.Supplier
+		buffer      *bytes.Buffer
+		mockCtrl    *gomock.Controller
+		mockCommand *MockCommand
+		oldEnv      map[string]string
+	)
+
+	interpreter := func(name, version string) {
+		path := filepath.Join(binDir, name)
+		Expect(ioutil.WriteFile(path, []byte("#!/bin/sh\n"), 0755)).To(Succeed())
+		mockCommand.EXPECT().Execute("", gomock.Any(), gomock.Any(), path, "--version").Do(func(_ string, stdout io.Writer, _ io.Writer, _ string, _ string) {
+			stdout.Write([]byte("Python " + version + "\n"))
+		}).Return(nil).AnyTimes()
+	}
+
+	BeforeEach(func() {
+		binDir, err = ioutil.TempDir("", "nodejs-buildpack.bin.")
+		Expect(err).To(BeNil())
+
+		oldEnv = map[string]string{}
+		for _, key := range []string{"PATH", "BP_NODE_GYP_PYTHON", "npm_config_python", "PYTHON"} {
+			oldEnv[key] = os.Getenv(key)
+			os.Unsetenv(key)
+		}
+		os.Setenv("PATH", binDir)
+
+		mockCtrl = gomock.NewController(GinkgoT())
+		mockCommand = NewMockCommand(mockCtrl)
+
+		buffer = new(bytes.Buffer)
+		logger := libbuildpack.NewLogger(ansicleaner.New(buffer))
+		supplier = &supply.Supplier{
+			Stager:  libbuildpack.NewStager([]string{"", "", "", "0"}, logger, &libbuildpack.Manifest{}),
+			Command: mockCommand,
+			Log:     logger,
+		}
+
+		interpreter("python3.10", "3.10.12")
+		interpreter("python3.12", "3.12.3")
+		Expect(os.Symlink("python3.12", filepath.Join(binDir, "python3"))).To(Succeed())
+		interpreter("python3", "3.12.3")
+	})
+
+	AfterEach(func() {
+		mockCtrl.Finish()
+		for key, value := range oldEnv {
+			if value == "" {
+				os.Unsetenv(key)
+			} else {
+				os.Setenv(key, value)
+			}
+		}
+		Expect(os.RemoveAll(binDir)).To(Succeed())
+	})
+
+	It("does nothing when no python is requested", func() {
+		Expect(supplier.SetupNodeGypPython()).To(Succeed())
+		Expect(os.Getenv("npm_config_python")).To(Equal(""))
+		Expect(os.Getenv("PYTHON")).To(Equal(""))
+		Expect(buffer.String()).To(Equal(""))
+	})
+
+	It("exposes the python matching engines.python to node-gyp", func() {
+		supplier.PythonVersion = "3.10"
+		Expect(supplier.SetupNodeGypPython()).To(Succeed())
+		Expect(os.Getenv("npm_config_python")).To(Equal(filepath.Join(binDir, "python3.10")))
+		Expect(os.Getenv("PYTHON")).To(Equal(filepath.Join(binDir, "python3.10")))
+		Expect(buffer.String()).To(ContainSubstring("Using python 3.10.12 (" + filepath.Join(binDir, "python3.10") + ") for node-gyp, engines.python asks for 3.10"))
+	})
+
+	It("picks the newest python matching a range", func() {
+		supplier.PythonVersion = ">=3.8"
+		Expect(supplier.SetupNodeGypPython()).To(Succeed())
+		Expect(os.Getenv("npm_config_python")).To(Equal(filepath.Join(binDir, "python3")))
+	})
+
+	It("prefers BP_NODE_GYP_PYTHON over engines.python", func() {
+		os.Setenv("BP_NODE_GYP_PYTHON", "3.12")
+		supplier.PythonVersion = "3.10"
+		Expect(supplier.SetupNodeGypPython()).To(Succeed())
+		Expect(os.Getenv("PYTHON")).To(Equal(filepath.Join(binDir, "python3")))
+		Expect(buffer.String()).To(ContainSubstring("BP_NODE_GYP_PYTHON asks for 3.12"))
+	})
+
+	It("skips interpreters which fail to report their version", func() {
+		path := filepath.Join(binDir, "python2")
+		Expect(ioutil.WriteFile(path, []byte("#!/bin/sh\n"), 0755)).To(Succeed())
+		mockCommand.EXPECT().Execute("", gomock.Any(), gomock.Any(), path, "--version").Return(errors.New("exit status 127"))
+
+		supplier.PythonVersion = "2"
+		err := supplier.SetupNodeGypPython()
+		Expect(err).NotTo(BeNil())
+		Expect(err.Error()).To(ContainSubstring("No python on the stack matches 2 from engines.python, available:"))
+		Expect(err.Error()).NotTo(ContainSubstring("python2"))
+	})
+
+	It("fails listing the available interpreters when none matches", func() {
+		supplier.PythonVersion = "2.7"
+		err := supplier.SetupNodeGypPython()
+		Expect(err).NotTo(BeNil())
+		Expect(err.Error()).To(Equal("No python on the stack matches 2.7 from engines.python, available:\n" +
+			"  3.12.3 (" + filepath.Join(binDir, "python3") + ")\n" +
+			"  3.10.12 (" + filepath.Join(binDir, "python3.10") + ")"))
+		Expect(os.Getenv("npm_config_python")).To(Equal(""))
+	})
+
+	It("fails listing none when the stack has no python", func() {
+		os.Setenv("PATH", filepath.Join(binDir, "missing"))
+		supplier.PythonVersion = "3"
+		err := supplier.SetupNodeGypPython()
+		Expect(err).NotTo(BeNil())
+		Expect(err.Error()).To(HaveSuffix("available:\n  none"))
+	})
+
+	It("fails on an invalid version", func() {
+		os.Setenv("BP_NODE_GYP_PYTHON", "three")
+		err := supplier.SetupNodeGypPython()
+		Expect(err).NotTo(BeNil())
+		Expect(err.Error()).To(ContainSubstring("Unable to resolve BP_NODE_GYP_PYTHON"))
+	})
+})
@@ -0,0 +1,99 @@
+package supply
+
+import (
+	"nodejs/native"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// RebuildMetadata records the packages of a vendored node_modules which npm
+// rebuild builds, for the Node.js ABI they were built for.
+type RebuildMetadata struct {
+	ModuleVersion string   `json:"module_version"`
+	Packages      []string `json:"packages"`
+	// FullSeconds is how long the last rebuild of every package took.
+	FullSeconds int `json:"full_seconds,omitempty"`
+}
+
+// rebuildVendored rebuilds a vendored node_modules with npm. When the
+// previous build recorded the packages with native code for the same
+// Node.js ABI on the same stack, only those and the ones node_modules has
+// now are rebuilt, instead of the whole tree. The packages are recorded for
+// the next build.
+func (s *Supplier) rebuildVendored() error {
+	nodeModules := filepath.Join(s.Stager.BuildDir(), "node_modules")
+	moduleVersion := native.ModuleVersion(filepath.Join(s.Stager.DepDir(), "node"))
+	metadata, err := LoadCacheMetadata(s.Stager.CacheDir())
+	if err != nil {
+		return err
+	}
+
+	var packages []string
+	previous := metadata.Rebuild
+	switch {
+	case previous == nil:
+		s.Log.Info("Rebuilding every package, the cache has no record of the packages with native code")
+	case s.StackChanged:
+		s.Log.Info("Rebuilding every package, the stack changed")
+	case moduleVersion == "" || previous.ModuleVersion != moduleVersion:
+		s.Log.Info("Rebuilding every package, the Node.js ABI changed from %s to %s", previous.ModuleVersion, unknownIfEmpty(moduleVersion))
+	default:
+		found, err := native.FindRebuildPackages(nodeModules)
+		if err != nil {
+			return err
+		}
+		packages = mergePackages(previous.Packages, found)
+		s.Log.Info("Rebuilding %d packages with native code or install scripts instead of every package, the Node.js ABI %s is unchanged", len(packages), moduleVersion)
+	}
+
+	start := time.Now()
+	if err := s.NPM.Rebuild(s.Stager.BuildDir(), packages); err != nil {
+		return err
+	}
+	elapsed := time.Since(start)
+
+	found, err := native.FindRebuildPackages(nodeModules)
+	if err != nil {
+		return err
+	}
+	record := &RebuildMetadata{ModuleVersion: moduleVersion, Packages: found}
+	if packages == nil {
+		record.FullSeconds = int(elapsed.Seconds())
+	} else {
+		record.FullSeconds = previous.FullSeconds
+		if previous.FullSeconds > 0 {
+			s.Log.Info("Rebuilding and installing took %s, the last rebuild of every package took %s", elapsed.Round(time.Second), (time.Duration(previous.FullSeconds) * time.Second).String())
+		}
+	}
+
+	// The install may have changed the metadata.
+	if metadata, err = LoadCacheMetadata(s.Stager.CacheDir()); err != nil {
+		return err
+	}
+	metadata.Rebuild = record
+	return metadata.Save(s.Stager.CacheDir())
+}
+
+// mergePackages returns the sorted union of the package lists.
+func mergePackages(lists ...[]string) []string {
+	seen := map[string]bool{}
+	merged := []string{}
+	for _, list := range lists {
+		for _, name := range list {
+			if !seen[name] {
+				seen[name] = true
+				merged = append(merged, name)
+			}
+		}
+	}
+	sort.Strings(merged)
+	return merged
+}
+
+func unknownIfEmpty(value string) string {
+	if value == "" {
+		return "unknown"
+	}
+	return value
+}
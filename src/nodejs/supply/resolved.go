@@ -0,0 +1,89 @@
+package supply
+
+import (
+	"fmt"
+	"nodejs/resolved"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cloudfoundry/libbuildpack"
+)
+
+// missingTarballsLimit is the number of missing tarballs the replay lists.
+const missingTarballsLimit = 10
+
+// RecordResolved writes the tarball URLs and integrity hashes of the lockfile
+// after the install to resolved-manifest.json in the dep dir and the cache
+// with BP_RECORD_RESOLVED=true, so that the droplet can be rebuilt from the
+// same tarballs with BP_REPLAY_RESOLVED once the registry no longer has them.
+func (s *Supplier) RecordResolved() error {
+	if os.Getenv("BP_RECORD_RESOLVED") != "true" {
+		return nil
+	}
+
+	manifest, err := resolved.FromLockfile(s.Stager.BuildDir())
+	if err != nil {
+		return err
+	}
+	if manifest.Lockfile == "" {
+		s.Log.Warning("BP_RECORD_RESOLVED needs a lockfile to record the resolved tarballs from")
+		return nil
+	}
+
+	for _, dir := range []string{s.Stager.DepDir(), s.Stager.CacheDir()} {
+		if err := libbuildpack.NewJSON().Write(filepath.Join(dir, resolved.ManifestFile), manifest); err != nil {
+			return err
+		}
+	}
+	s.Log.Info("Recorded %d resolved tarballs from %s in %s", len(manifest.Tarballs), manifest.Lockfile, resolved.ManifestFile)
+	return nil
+}
+
+// ReplayResolved primes the npm cache with the tarballs of the lockfile from
+// the dir in BP_REPLAY_RESOLVED, relative to the app, matched by integrity,
+// so that npm installs them offline. It fails listing the tarballs the dir
+// lacks rather than letting npm fail on the first.
+func (s *Supplier) ReplayResolved() error {
+	dir := os.Getenv("BP_REPLAY_RESOLVED")
+	if dir == "" {
+		return nil
+	}
+	if s.UseYarn {
+		return fmt.Errorf("BP_REPLAY_RESOLVED only replays npm installs, yarn installs offline from npm-packages-offline-cache")
+	}
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(s.Stager.BuildDir(), dir)
+	}
+
+	manifest, err := resolved.FromLockfile(s.Stager.BuildDir())
+	if err != nil {
+		return err
+	}
+	if manifest.Lockfile == "" {
+		return fmt.Errorf("BP_REPLAY_RESOLVED needs package-lock.json or npm-shrinkwrap.json to match the tarballs by")
+	}
+
+	store, err := resolved.LoadStore(dir)
+	if err != nil {
+		return fmt.Errorf("unable to read the tarballs of BP_REPLAY_RESOLVED: %s", err)
+	}
+	missing, err := resolved.Prime(filepath.Join(s.Stager.CacheDir(), ".npm", "_cacache"), store, manifest.Tarballs)
+	if err != nil {
+		return err
+	}
+	if len(missing) > 0 {
+		var lines []string
+		for i, tarball := range missing {
+			if i == missingTarballsLimit {
+				lines = append(lines, fmt.Sprintf("  and %d more", len(missing)-missingTarballsLimit))
+				break
+			}
+			lines = append(lines, fmt.Sprintf("  %s (%s)", tarball, tarball.Integrity))
+		}
+		return fmt.Errorf("%d tarballs of %s are missing from %s:\n%s", len(missing), manifest.Lockfile, os.Getenv("BP_REPLAY_RESOLVED"), strings.Join(lines, "\n"))
+	}
+
+	s.Log.Info("Primed the npm cache with %d tarballs of %s from %s", len(manifest.Tarballs), manifest.Lockfile, os.Getenv("BP_REPLAY_RESOLVED"))
+	return nil
+}
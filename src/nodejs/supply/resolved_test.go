@@ -0,0 +1,147 @@
+package supply_test
+
+import (
+	"bytes"
+	"crypto/sha512"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"nodejs/resolved"
+	"nodejs/supply"
+	"os"
+	"path/filepath"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/cloudfoundry/libbuildpack/ansicleaner"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Resolved tarballs", func() {
+	var (
+		buildDir    string
+		cacheDir    string
+		depsDir     string
+		tarballsDir string
+		supplier    *supply.Supplier
+		buffer      *bytes.Buffer
+		oldEnv      map[string]string
+	)
+
+	integrity := func(contents string) string {
+		sum := sha512.Sum512([]byte(contents))
+		return "sha512-" + base64.StdEncoding.EncodeToString(sum[:])
+	}
+
+	writeLockfile := func(names ...string) {
+		packages := `"": {"name": "app"}`
+		for _, name := range names {
+			packages += fmt.Sprintf(`, "node_modules/%s": {"version": "1.0.0", "resolved": "https://registry.npmjs.org/%s/-/%s-1.0.0.tgz", "integrity": "%s"}`, name, name, name, integrity(name+" tarball"))
+		}
+		Expect(ioutil.WriteFile(filepath.Join(buildDir, "package-lock.json"), []byte(`{"lockfileVersion": 3, "packages": {`+packages+`}}`), 0644)).To(Succeed())
+	}
+
+	BeforeEach(func() {
+		oldEnv = map[string]string{}
+		for _, key := range []string{"BP_RECORD_RESOLVED", "BP_REPLAY_RESOLVED"} {
+			oldEnv[key] = os.Getenv(key)
+			os.Unsetenv(key)
+		}
+
+		var err error
+		buildDir, err = ioutil.TempDir("", "nodejs-buildpack.build.")
+		Expect(err).To(BeNil())
+		cacheDir, err = ioutil.TempDir("", "nodejs-buildpack.cache.")
+		Expect(err).To(BeNil())
+		depsDir, err = ioutil.TempDir("", "nodejs-buildpack.deps.")
+		Expect(err).To(BeNil())
+		Expect(os.MkdirAll(filepath.Join(depsDir, "0"), 0755)).To(Succeed())
+
+		tarballsDir = filepath.Join(buildDir, "tarballs")
+		Expect(os.MkdirAll(tarballsDir, 0755)).To(Succeed())
+		for _, name := range []string{"express", "lodash"} {
+			Expect(ioutil.WriteFile(filepath.Join(tarballsDir, name+".tgz"), []byte(name+" tarball"), 0644)).To(Succeed())
+		}
+
+		buffer = new(bytes.Buffer)
+		logger := libbuildpack.NewLogger(ansicleaner.New(buffer))
+		supplier = &supply.Supplier{
+			Stager: libbuildpack.NewStager([]string{buildDir, cacheDir, depsDir, "0"}, logger, &libbuildpack.Manifest{}),
+			Log:    logger,
+		}
+	})
+
+	AfterEach(func() {
+		for key, value := range oldEnv {
+			os.Setenv(key, value)
+		}
+		Expect(os.RemoveAll(buildDir)).To(Succeed())
+		Expect(os.RemoveAll(cacheDir)).To(Succeed())
+		Expect(os.RemoveAll(depsDir)).To(Succeed())
+	})
+
+	Describe("RecordResolved", func() {
+		It("does nothing by default", func() {
+			writeLockfile("express")
+
+			Expect(supplier.RecordResolved()).To(Succeed())
+			Expect(filepath.Join(depsDir, "0", resolved.ManifestFile)).NotTo(BeAnExistingFile())
+		})
+
+		It("writes the manifest to the dep dir and the cache", func() {
+			os.Setenv("BP_RECORD_RESOLVED", "true")
+			writeLockfile("express", "lodash")
+
+			Expect(supplier.RecordResolved()).To(Succeed())
+			for _, dir := range []string{filepath.Join(depsDir, "0"), cacheDir} {
+				var manifest resolved.Manifest
+				Expect(libbuildpack.NewJSON().Load(filepath.Join(dir, resolved.ManifestFile), &manifest)).To(Succeed())
+				Expect(manifest.Lockfile).To(Equal("package-lock.json"))
+				Expect(manifest.Tarballs).To(Equal([]resolved.Tarball{
+					{Name: "express", Version: "1.0.0", Resolved: "https://registry.npmjs.org/express/-/express-1.0.0.tgz", Integrity: integrity("express tarball")},
+					{Name: "lodash", Version: "1.0.0", Resolved: "https://registry.npmjs.org/lodash/-/lodash-1.0.0.tgz", Integrity: integrity("lodash tarball")},
+				}))
+			}
+			Expect(buffer.String()).To(ContainSubstring("Recorded 2 resolved tarballs from package-lock.json in resolved-manifest.json"))
+		})
+
+		It("warns without a lockfile", func() {
+			os.Setenv("BP_RECORD_RESOLVED", "true")
+
+			Expect(supplier.RecordResolved()).To(Succeed())
+			Expect(buffer.String()).To(ContainSubstring("BP_RECORD_RESOLVED needs a lockfile to record the resolved tarballs from"))
+		})
+	})
+
+	Describe("ReplayResolved", func() {
+		BeforeEach(func() {
+			os.Setenv("BP_REPLAY_RESOLVED", "tarballs")
+		})
+
+		It("primes the npm cache with the tarballs of the lockfile", func() {
+			writeLockfile("express", "lodash")
+
+			Expect(supplier.ReplayResolved()).To(Succeed())
+			content, err := resolved.ContentPath(filepath.Join(cacheDir, ".npm", "_cacache"), integrity("lodash tarball"))
+			Expect(err).To(BeNil())
+			Expect(ioutil.ReadFile(content)).To(Equal([]byte("lodash tarball")))
+			Expect(buffer.String()).To(ContainSubstring("Primed the npm cache with 2 tarballs of package-lock.json from tarballs"))
+		})
+
+		It("lists the tarballs missing from the dir", func() {
+			writeLockfile("express", "left-pad", "lodash", "react")
+
+			Expect(supplier.ReplayResolved()).To(MatchError(fmt.Sprintf("2 tarballs of package-lock.json are missing from tarballs:\n  left-pad@1.0.0 (%s)\n  react@1.0.0 (%s)", integrity("left-pad tarball"), integrity("react tarball"))))
+		})
+
+		It("needs a lockfile", func() {
+			Expect(supplier.ReplayResolved()).To(MatchError("BP_REPLAY_RESOLVED needs package-lock.json or npm-shrinkwrap.json to match the tarballs by"))
+		})
+
+		It("refuses yarn apps", func() {
+			supplier.UseYarn = true
+
+			Expect(supplier.ReplayResolved()).To(MatchError(ContainSubstring("BP_REPLAY_RESOLVED only replays npm installs")))
+		})
+	})
+})
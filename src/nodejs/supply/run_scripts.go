@@ -0,0 +1,132 @@
+package supply
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"nodejs/packagejson"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// lifecycleShell runs the scripts of npm and yarn in place of the script
+// shell and records the lifecycle scripts of the app itself, which run in
+// its root, to $BP_LIFECYCLE_LOG.
+const lifecycleShell = `#!/bin/sh
+if [ -n "$npm_lifecycle_event" ] && [ "$(pwd -P)" = "$BP_LIFECYCLE_ROOT" ]; then
+  printf '%s\t%s\n' "$npm_lifecycle_event" "$(printf '%s' "$npm_lifecycle_script" | tr '\n\t' '  ')" >> "$BP_LIFECYCLE_LOG"
+fi
+exec "${BP_LIFECYCLE_SHELL:-/bin/sh}" "$@"
+`
+
+// trackLifecycle makes npm and yarn record the lifecycle scripts of the app
+// they run, like prepare, until the returned function is called. It returns
+// the scripts which ran, by name.
+func (s *Supplier) trackLifecycle() (func() (map[string]string, error), error) {
+	dir, err := ioutil.TempDir("", "lifecycle")
+	if err != nil {
+		return nil, err
+	}
+	shell := filepath.Join(dir, "sh")
+	if err := ioutil.WriteFile(shell, []byte(lifecycleShell), 0755); err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	root, err := filepath.EvalSymlinks(s.Stager.BuildDir())
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+
+	env := map[string]string{
+		"npm_config_script_shell": shell,
+		"BP_LIFECYCLE_SHELL":      os.Getenv("npm_config_script_shell"),
+		"BP_LIFECYCLE_ROOT":       root,
+		"BP_LIFECYCLE_LOG":        filepath.Join(dir, "log"),
+	}
+	restoreEnv := setEnv(env)
+
+	return func() (map[string]string, error) {
+		defer os.RemoveAll(dir)
+		restoreEnv()
+
+		ran := map[string]string{}
+		f, err := os.Open(env["BP_LIFECYCLE_LOG"])
+		if os.IsNotExist(err) {
+			return ran, nil
+		} else if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			if fields := strings.SplitN(scanner.Text(), "\t", 2); len(fields) == 2 {
+				ran[fields[0]] = fields[1]
+			}
+		}
+		return ran, scanner.Err()
+	}, nil
+}
+
+// RunScripts runs the package.json scripts listed in BP_NODE_RUN_SCRIPTS, in
+// order. A script which already ran as a lifecycle script during the
+// install, with the same command, is skipped: npm and yarn run prepare on
+// install, which often is the build the app lists.
+func (s *Supplier) RunScripts(tool string, ran map[string]string) error {
+	value := os.Getenv("BP_NODE_RUN_SCRIPTS")
+	if strings.TrimSpace(value) == "" {
+		return nil
+	}
+
+	p, _, err := packagejson.Load(s.Stager.BuildDir())
+	if err != nil {
+		return err
+	}
+
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		command, found := p.Scripts[name]
+		if !found {
+			return fmt.Errorf("BP_NODE_RUN_SCRIPTS lists %s, which is not a script in package.json", name)
+		}
+		if previous, found := ran[name]; found && sameCommand(previous, command) {
+			s.Log.Info("Skipping %s from BP_NODE_RUN_SCRIPTS, %s ran it during the install", name, tool)
+			continue
+		}
+		if err := s.runScript(name, tool); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sameCommand compares script commands, ignoring differences in whitespace
+// the lifecycle log doesn't keep.
+func sameCommand(a, b string) bool {
+	return strings.Join(strings.Fields(a), " ") == strings.Join(strings.Fields(b), " ")
+}
+
+// setEnv sets the variables of env, and returns a func which restores them,
+// unsetting again those which were not set.
+func setEnv(env map[string]string) func() {
+	old := map[string]string{}
+	for key, value := range env {
+		if value, found := os.LookupEnv(key); found {
+			old[key] = value
+		}
+		os.Setenv(key, value)
+	}
+	return func() {
+		for key := range env {
+			if value, found := old[key]; found {
+				os.Setenv(key, value)
+			} else {
+				os.Unsetenv(key)
+			}
+		}
+	}
+}
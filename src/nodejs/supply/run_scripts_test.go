@@ -0,0 +1,163 @@
+package supply_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"nodejs/supply"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/cloudfoundry/libbuildpack/ansicleaner"
+	"golang.google.cn/x/mock/gomock"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("RunScripts", func() {
+	var (
+		err         error
+		buildDir    string
+		supplier    *supply.Supplier
+		buffer      *bytes.Buffer
+		mockCtrl    *gomock.Controller
+		mockCommand *MockCommand
+		mockNPM     *MockNPM
+		mockYarn    *MockYarn
+		oldEnv      map[string]string
+	)
+
+	// lifecycle runs a script like npm and yarn do, through the script shell
+	// in the directory of the package.
+	lifecycle := func(dir, event, script string) {
+		shell := os.Getenv("npm_config_script_shell")
+		Expect(shell).NotTo(BeEmpty())
+		cmd := exec.Command(shell, "-c", script)
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(), "npm_lifecycle_event="+event, "npm_lifecycle_script="+script)
+		output, err := cmd.CombinedOutput()
+		Expect(err).To(BeNil(), string(output))
+	}
+
+	install := func(string, string) error {
+		dep := filepath.Join(buildDir, "node_modules", "esbuild")
+		Expect(os.MkdirAll(dep, 0755)).To(Succeed())
+		lifecycle(dep, "postinstall", "node install.js || true")
+		lifecycle(buildDir, "prepare", "touch built")
+		return nil
+	}
+
+	BeforeEach(func() {
+		buildDir, err = ioutil.TempDir("", "nodejs-buildpack.build.")
+		Expect(err).To(BeNil())
+		Expect(ioutil.WriteFile(filepath.Join(buildDir, "package.json"), []byte(`{"scripts":{"prepare":"touch built","lint":"eslint ."}}`), 0644)).To(Succeed())
+
+		oldEnv = map[string]string{}
+		for _, key := range []string{"BP_NODE_RUN_SCRIPTS", "BP_NODE_WORKSPACE", "npm_config_script_shell"} {
+			oldEnv[key] = os.Getenv(key)
+			os.Unsetenv(key)
+		}
+		os.Setenv("BP_NODE_RUN_SCRIPTS", "prepare,lint")
+
+		buffer = new(bytes.Buffer)
+		logger := libbuildpack.NewLogger(ansicleaner.New(buffer))
+		mockCtrl = gomock.NewController(GinkgoT())
+		mockCommand = NewMockCommand(mockCtrl)
+		mockNPM = NewMockNPM(mockCtrl)
+		mockYarn = NewMockYarn(mockCtrl)
+		supplier = &supply.Supplier{
+			Stager:  libbuildpack.NewStager([]string{buildDir, "", "", "0"}, logger, &libbuildpack.Manifest{}),
+			Log:     logger,
+			Command: mockCommand,
+			NPM:     mockNPM,
+			Yarn:    mockYarn,
+		}
+	})
+
+	AfterEach(func() {
+		mockCtrl.Finish()
+		for key, value := range oldEnv {
+			os.Setenv(key, value)
+		}
+		Expect(os.RemoveAll(buildDir)).To(Succeed())
+	})
+
+	Context("with npm", func() {
+		It("skips the scripts npm ran during the install", func() {
+			mockNPM.EXPECT().Build(buildDir, gomock.Any()).DoAndReturn(install)
+			mockCommand.EXPECT().Execute(buildDir, gomock.Any(), gomock.Any(), "npm", "run", "lint", "--if-present")
+
+			Expect(supplier.BuildDependencies()).To(Succeed())
+			Expect(filepath.Join(buildDir, "built")).To(BeAnExistingFile())
+			Expect(buffer.String()).To(ContainSubstring("Skipping prepare from BP_NODE_RUN_SCRIPTS, npm ran it during the install"))
+			Expect(buffer.String()).To(ContainSubstring("Running lint (npm)"))
+		})
+
+		It("runs the scripts when npm ran none", func() {
+			mockNPM.EXPECT().Build(buildDir, gomock.Any())
+			gomock.InOrder(
+				mockCommand.EXPECT().Execute(buildDir, gomock.Any(), gomock.Any(), "npm", "run", "prepare", "--if-present"),
+				mockCommand.EXPECT().Execute(buildDir, gomock.Any(), gomock.Any(), "npm", "run", "lint", "--if-present"),
+			)
+
+			Expect(supplier.BuildDependencies()).To(Succeed())
+			Expect(buffer.String()).NotTo(ContainSubstring("Skipping"))
+		})
+
+		It("restores the script shell after the install", func() {
+			os.Setenv("npm_config_script_shell", "/bin/bash")
+			mockNPM.EXPECT().Build(buildDir, gomock.Any()).DoAndReturn(func(string, string) error {
+				Expect(os.Getenv("npm_config_script_shell")).NotTo(Equal("/bin/bash"))
+				Expect(os.Getenv("BP_LIFECYCLE_SHELL")).To(Equal("/bin/bash"))
+				return nil
+			})
+			mockCommand.EXPECT().Execute(buildDir, gomock.Any(), gomock.Any(), "npm", gomock.Any()).AnyTimes()
+
+			Expect(supplier.BuildDependencies()).To(Succeed())
+			Expect(os.Getenv("npm_config_script_shell")).To(Equal("/bin/bash"))
+		})
+
+		It("unsets the variables of the install which were not set", func() {
+			mockNPM.EXPECT().Build(buildDir, gomock.Any())
+			mockCommand.EXPECT().Execute(buildDir, gomock.Any(), gomock.Any(), "npm", gomock.Any()).AnyTimes()
+
+			Expect(supplier.BuildDependencies()).To(Succeed())
+			for _, key := range []string{"npm_config_script_shell", "BP_LIFECYCLE_SHELL", "BP_LIFECYCLE_ROOT", "BP_LIFECYCLE_LOG"} {
+				_, found := os.LookupEnv(key)
+				Expect(found).To(BeFalse(), key)
+			}
+		})
+	})
+
+	Context("with yarn", func() {
+		BeforeEach(func() {
+			supplier.UseYarn = true
+		})
+
+		It("skips the scripts yarn ran during the install", func() {
+			mockYarn.EXPECT().Build(buildDir, gomock.Any()).DoAndReturn(install)
+			mockCommand.EXPECT().Execute(buildDir, gomock.Any(), gomock.Any(), "yarn", "run", "lint")
+
+			Expect(supplier.BuildDependencies()).To(Succeed())
+			Expect(buffer.String()).To(ContainSubstring("Skipping prepare from BP_NODE_RUN_SCRIPTS, yarn ran it during the install"))
+		})
+	})
+
+	It("runs a lifecycle script again when its command differs", func() {
+		mockCommand.EXPECT().Execute(buildDir, gomock.Any(), gomock.Any(), "npm", "run", "prepare", "--if-present")
+		os.Setenv("BP_NODE_RUN_SCRIPTS", "prepare")
+
+		Expect(supplier.RunScripts("npm", map[string]string{"prepare": "tsc -p ."})).To(Succeed())
+	})
+
+	It("ignores differences in whitespace", func() {
+		os.Setenv("BP_NODE_RUN_SCRIPTS", "prepare")
+		Expect(supplier.RunScripts("npm", map[string]string{"prepare": "touch  built "})).To(Succeed())
+	})
+
+	It("fails for a script which is not in package.json", func() {
+		os.Setenv("BP_NODE_RUN_SCRIPTS", "build")
+		Expect(supplier.RunScripts("npm", nil)).To(MatchError("BP_NODE_RUN_SCRIPTS lists build, which is not a script in package.json"))
+	})
+})
@@ -0,0 +1,54 @@
+package supply
+
+import (
+	"nodejs/vcap"
+	"os"
+	"strings"
+)
+
+// AddBuildScriptMetadata passes the app name, space, organization and
+// version from VCAP_APPLICATION and the installed node version to the build
+// scripts, for example to name assets or tag releases. The CF_ variables are
+// left out without VCAP_APPLICATION, and variables the user has set in the
+// environment or the dotenv file are never overridden.
+func (s *Supplier) AddBuildScriptMetadata() {
+	vars := [][2]string{{"BUILDPACK_NODE_VERSION", s.ExactNodeVersion}}
+
+	app, found, err := vcap.LoadApplication()
+	if err != nil {
+		s.Log.Warning("Unable to parse VCAP_APPLICATION, build scripts will not get the CF_ app variables: %s", err)
+	} else if found {
+		vars = append(vars,
+			[2]string{"CF_APP_NAME", app.Name},
+			[2]string{"CF_SPACE_NAME", app.SpaceName},
+			[2]string{"CF_ORGANIZATION_NAME", app.OrganizationName},
+			[2]string{"CF_APPLICATION_VERSION", app.Version},
+		)
+	}
+
+	for _, v := range vars {
+		if v[1] == "" || s.buildScriptEnvSet(v[0]) {
+			continue
+		}
+		s.BuildScriptEnv = append(s.BuildScriptEnv, v[0]+"="+v[1])
+	}
+}
+
+func (s *Supplier) buildScriptEnvSet(key string) bool {
+	_, found := s.buildScriptEnv(key)
+	return found
+}
+
+// buildScriptEnv returns the value of the env var key as the build scripts
+// see it, from the environment or else the dotenv file.
+func (s *Supplier) buildScriptEnv(key string) (string, bool) {
+	if value, found := os.LookupEnv(key); found {
+		return value, true
+	}
+	for _, env := range s.BuildScriptEnv {
+		if strings.HasPrefix(env, key+"=") {
+			return strings.TrimPrefix(env, key+"="), true
+		}
+	}
+	return "", false
+}
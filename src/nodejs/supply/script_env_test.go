@@ -0,0 +1,86 @@
+package supply_test
+
+import (
+	"bytes"
+	"nodejs/supply"
+	"os"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/cloudfoundry/libbuildpack/ansicleaner"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("AddBuildScriptMetadata", func() {
+	var (
+		supplier *supply.Supplier
+		buffer   *bytes.Buffer
+		oldEnv   map[string]string
+	)
+
+	keys := []string{"VCAP_APPLICATION", "BUILDPACK_NODE_VERSION", "CF_APP_NAME", "CF_SPACE_NAME", "CF_ORGANIZATION_NAME", "CF_APPLICATION_VERSION"}
+
+	BeforeEach(func() {
+		oldEnv = map[string]string{}
+		for _, key := range keys {
+			if value, found := os.LookupEnv(key); found {
+				oldEnv[key] = value
+			}
+			Expect(os.Unsetenv(key)).To(Succeed())
+		}
+
+		buffer = new(bytes.Buffer)
+		supplier = &supply.Supplier{
+			Log:              libbuildpack.NewLogger(ansicleaner.New(buffer)),
+			ExactNodeVersion: "18.17.1",
+		}
+	})
+
+	AfterEach(func() {
+		for _, key := range keys {
+			if value, found := oldEnv[key]; found {
+				Expect(os.Setenv(key, value)).To(Succeed())
+			} else {
+				Expect(os.Unsetenv(key)).To(Succeed())
+			}
+		}
+	})
+
+	It("adds the app metadata and node version", func() {
+		os.Setenv("VCAP_APPLICATION", `{"application_name":"shop","space_name":"prod","organization_name":"acme","application_version":"0c8a"}`)
+		supplier.AddBuildScriptMetadata()
+		Expect(supplier.BuildScriptEnv).To(Equal([]string{
+			"BUILDPACK_NODE_VERSION=18.17.1",
+			"CF_APP_NAME=shop",
+			"CF_SPACE_NAME=prod",
+			"CF_ORGANIZATION_NAME=acme",
+			"CF_APPLICATION_VERSION=0c8a",
+		}))
+	})
+
+	It("leaves out missing fields", func() {
+		os.Setenv("VCAP_APPLICATION", `{"name":"shop"}`)
+		supplier.AddBuildScriptMetadata()
+		Expect(supplier.BuildScriptEnv).To(Equal([]string{"BUILDPACK_NODE_VERSION=18.17.1", "CF_APP_NAME=shop"}))
+	})
+
+	It("only adds the node version without VCAP_APPLICATION", func() {
+		supplier.AddBuildScriptMetadata()
+		Expect(supplier.BuildScriptEnv).To(Equal([]string{"BUILDPACK_NODE_VERSION=18.17.1"}))
+	})
+
+	It("does not override variables set by the user", func() {
+		os.Setenv("VCAP_APPLICATION", `{"application_name":"shop","space_name":"prod"}`)
+		os.Setenv("CF_APP_NAME", "storefront")
+		supplier.BuildScriptEnv = []string{"CF_SPACE_NAME=staging"}
+		supplier.AddBuildScriptMetadata()
+		Expect(supplier.BuildScriptEnv).To(Equal([]string{"CF_SPACE_NAME=staging", "BUILDPACK_NODE_VERSION=18.17.1"}))
+	})
+
+	It("warns about an invalid VCAP_APPLICATION", func() {
+		os.Setenv("VCAP_APPLICATION", `{"application_name":`)
+		supplier.AddBuildScriptMetadata()
+		Expect(supplier.BuildScriptEnv).To(Equal([]string{"BUILDPACK_NODE_VERSION=18.17.1"}))
+		Expect(buffer.String()).To(ContainSubstring("Unable to parse VCAP_APPLICATION"))
+	})
+})
@@ -0,0 +1,239 @@
+package supply
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"nodejs/duration"
+	"nodejs/failure"
+	"nodejs/proctree"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// timeoutShell runs the scripts of npm and yarn in place of the script shell
+// and records the pid of each one to $BP_SCRIPT_PIDS before it runs.
+const timeoutShell = `#!/bin/sh
+if [ -n "$npm_lifecycle_event" ]; then
+  printf '%s\t%s\t%s\n' "$$" "$npm_lifecycle_event" "$npm_package_name" >> "$BP_SCRIPT_PIDS"
+fi
+exec "${BP_SCRIPT_SHELL:-/bin/sh}" "$@"
+`
+
+// scriptPollInterval is how often the scripts are checked against their
+// timeout, at most.
+const scriptPollInterval = time.Second
+
+// scriptTimeout is BP_SCRIPT_TIMEOUT, how long each script may run by name:
+// like 10m for every script, or 10m,postinstall=2m for postinstall scripts to
+// have less. The key of every script is "", and 0 is no timeout.
+type scriptTimeout map[string]time.Duration
+
+func parseScriptTimeout(value string) (scriptTimeout, error) {
+	timeout := scriptTimeout{}
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		name, spec := "", item
+		if i := strings.Index(item, "="); i >= 0 {
+			name, spec = strings.TrimSpace(item[:i]), strings.TrimSpace(item[i+1:])
+		}
+		d, err := duration.Parse(spec)
+		if err != nil || (name == "" && spec != item) {
+			return nil, fmt.Errorf("invalid BP_SCRIPT_TIMEOUT %q, expected a duration like 10m, or durations by script like 10m,postinstall=2m", value)
+		}
+		timeout[name] = d
+	}
+	return timeout, nil
+}
+
+// of returns the timeout of the script, 0 when it has none.
+func (t scriptTimeout) of(script string) time.Duration {
+	if d, found := t[script]; found {
+		return d
+	}
+	return t[""]
+}
+
+// poll returns how often to check the scripts, often enough for the
+// shortest timeout, or 0 when no script has one.
+func (t scriptTimeout) poll() time.Duration {
+	var poll time.Duration
+	for _, d := range t {
+		if d > 0 && (poll == 0 || d/4 < poll) {
+			poll = d / 4
+		}
+	}
+	if poll > scriptPollInterval {
+		return scriptPollInterval
+	}
+	return poll
+}
+
+// runningScript is a script the timeout shell recorded.
+type runningScript struct {
+	pid     int
+	name    string
+	pkg     string
+	started time.Time
+}
+
+// scriptSupervisor kills the scripts of npm and yarn, those of the app and
+// of its dependencies, which run longer than BP_SCRIPT_TIMEOUT, along with
+// what they started.
+type scriptSupervisor struct {
+	s          *Supplier
+	timeout    scriptTimeout
+	dir        string
+	restoreEnv func()
+	done       chan struct{}
+	stopped    chan struct{}
+
+	mu       sync.Mutex
+	timedOut []string
+}
+
+// superviseScripts starts supervising the scripts which run until Stop is
+// called, unless BP_SCRIPT_TIMEOUT sets no timeout.
+func (s *Supplier) superviseScripts() (*scriptSupervisor, error) {
+	timeout, err := parseScriptTimeout(os.Getenv("BP_SCRIPT_TIMEOUT"))
+	if err != nil {
+		return nil, err
+	}
+	if timeout.poll() == 0 {
+		return &scriptSupervisor{}, nil
+	}
+
+	dir, err := ioutil.TempDir("", "scripts")
+	if err != nil {
+		return nil, err
+	}
+	shell := filepath.Join(dir, "sh")
+	if err := ioutil.WriteFile(shell, []byte(timeoutShell), 0755); err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+
+	v := &scriptSupervisor{
+		s:       s,
+		timeout: timeout,
+		dir:     dir,
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	env := map[string]string{
+		"npm_config_script_shell": shell,
+		"BP_SCRIPT_SHELL":         os.Getenv("npm_config_script_shell"),
+		"BP_SCRIPT_PIDS":          filepath.Join(dir, "pids"),
+	}
+	v.restoreEnv = setEnv(env)
+	go v.watch(env["BP_SCRIPT_PIDS"])
+	return v, nil
+}
+
+// Stop stops supervising. The error of the scripts, err, is explained with
+// the scripts which were killed for running too long.
+func (v *scriptSupervisor) Stop(err error) error {
+	if v.done == nil {
+		return err
+	}
+	close(v.done)
+	<-v.stopped
+	v.restoreEnv()
+	os.RemoveAll(v.dir)
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if err == nil || len(v.timedOut) == 0 {
+		return err
+	}
+	return failure.Wrap(failure.ScriptTimedOut, fmt.Errorf("%s\n%s", err, strings.Join(v.timedOut, "\n")))
+}
+
+func (v *scriptSupervisor) watch(pidsFile string) {
+	defer close(v.stopped)
+	running := map[int]*runningScript{}
+	var offset int64
+	for {
+		select {
+		case <-v.done:
+			return
+		case <-time.After(v.timeout.poll()):
+		}
+
+		var started []*runningScript
+		started, offset = readScriptPids(pidsFile, offset)
+		for _, script := range started {
+			running[script.pid] = script
+		}
+		for pid, script := range running {
+			if _, err := os.Stat(filepath.Join("/proc", strconv.Itoa(pid))); err != nil {
+				delete(running, pid)
+				continue
+			}
+			timeout := v.timeout.of(script.name)
+			if timeout <= 0 || time.Since(script.started) < timeout {
+				continue
+			}
+			delete(running, pid)
+			v.kill(script, timeout)
+		}
+	}
+}
+
+// kill kills the script and what it started, logging what still ran.
+func (v *scriptSupervisor) kill(script *runningScript, timeout time.Duration) {
+	tree := proctree.Snapshot("/proc", script.pid)
+	if len(tree) == 0 {
+		return
+	}
+	name := script.name + " script"
+	if script.pkg != "" {
+		name += " of " + script.pkg
+	}
+	message := fmt.Sprintf("The %s ran longer than BP_SCRIPT_TIMEOUT (%s) and was killed, with what it still ran:\n%s", name, timeout, tree)
+	v.s.Log.Warning("%s", message)
+	tree.Kill(syscall.SIGKILL)
+
+	v.mu.Lock()
+	v.timedOut = append(v.timedOut, message)
+	v.mu.Unlock()
+}
+
+// readScriptPids reads the scripts recorded to file after offset, returning
+// the offset to read from next.
+func readScriptPids(file string, offset int64) ([]*runningScript, int64) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, offset
+	}
+	defer f.Close()
+	if _, err := f.Seek(offset, 0); err != nil {
+		return nil, offset
+	}
+
+	var scripts []*runningScript
+	reader := bufio.NewReader(f)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			// A line without its end is still being written.
+			return scripts, offset
+		}
+		offset += int64(len(line))
+		fields := strings.SplitN(strings.TrimSuffix(line, "\n"), "\t", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		if pid, err := strconv.Atoi(fields[0]); err == nil {
+			scripts = append(scripts, &runningScript{pid: pid, name: fields[1], pkg: fields[2], started: time.Now()})
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package supply_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"nodejs/failure"
+	"nodejs/proctree"
+	"nodejs/supply"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/cloudfoundry/libbuildpack/ansicleaner"
+	"golang.google.cn/x/mock/gomock"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("BP_SCRIPT_TIMEOUT", func() {
+	var (
+		err      error
+		buildDir string
+		supplier *supply.Supplier
+		buffer   *bytes.Buffer
+		mockCtrl *gomock.Controller
+		mockNPM  *MockNPM
+		oldEnv   map[string]string
+	)
+
+	// lifecycle runs a script like npm does, through the script shell in the
+	// directory of the package.
+	lifecycle := func(dir, pkg, event, script string) error {
+		cmd := exec.Command(os.Getenv("npm_config_script_shell"), "-c", script)
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(), "npm_lifecycle_event="+event, "npm_lifecycle_script="+script, "npm_package_name="+pkg)
+		return cmd.Run()
+	}
+
+	BeforeEach(func() {
+		buildDir, err = ioutil.TempDir("", "nodejs-buildpack.build.")
+		Expect(err).To(BeNil())
+		Expect(ioutil.WriteFile(filepath.Join(buildDir, "package.json"), []byte(`{}`), 0644)).To(Succeed())
+
+		oldEnv = map[string]string{}
+		for _, key := range []string{"BP_SCRIPT_TIMEOUT", "BP_NODE_RUN_SCRIPTS", "BP_NODE_WORKSPACE", "npm_config_script_shell"} {
+			oldEnv[key] = os.Getenv(key)
+			os.Unsetenv(key)
+		}
+
+		buffer = new(bytes.Buffer)
+		logger := libbuildpack.NewLogger(ansicleaner.New(buffer))
+		mockCtrl = gomock.NewController(GinkgoT())
+		mockNPM = NewMockNPM(mockCtrl)
+		supplier = &supply.Supplier{
+			Stager: libbuildpack.NewStager([]string{buildDir, "", "", "0"}, logger, &libbuildpack.Manifest{}),
+			Log:    logger,
+			NPM:    mockNPM,
+		}
+	})
+
+	AfterEach(func() {
+		mockCtrl.Finish()
+		for key, value := range oldEnv {
+			os.Setenv(key, value)
+		}
+		Expect(os.RemoveAll(buildDir)).To(Succeed())
+	})
+
+	It("kills a hung script with what it started and lists them in the error", func() {
+		os.Setenv("BP_SCRIPT_TIMEOUT", "10m,postinstall=1s")
+		hang, err := filepath.Abs(filepath.Join("testdata", "hang", "postinstall.sh"))
+		Expect(err).To(BeNil())
+		mockNPM.EXPECT().Build(buildDir, gomock.Any()).DoAndReturn(func(string, string) error {
+			start := time.Now()
+			err := lifecycle(buildDir, "sqlite3", "postinstall", hang)
+			Expect(time.Since(start)).To(BeNumerically("<", 10*time.Second))
+			return err
+		})
+
+		err = supplier.BuildDependencies()
+		Expect(err).To(HaveOccurred())
+		Expect(failure.CodeOf(err)).To(Equal(failure.ScriptTimedOut))
+		Expect(err.Error()).To(ContainSubstring("The postinstall script of sqlite3 ran longer than BP_SCRIPT_TIMEOUT (1s) and was killed, with what it still ran:\n"))
+		Expect(err.Error()).To(MatchRegexp(`\n\d+ /bin/sh -c ` + regexp.QuoteMeta(hang) + `\n  \d+ /bin/sh ` + regexp.QuoteMeta(hang) + `\n    \d+ sh -c sleep 600; echo done\n      \d+ sleep 600`))
+		Expect(buffer.String()).To(ContainSubstring("The postinstall script of sqlite3 ran longer than BP_SCRIPT_TIMEOUT (1s)"))
+
+		sleep := regexp.MustCompile(`(\d+) sleep 600`).FindStringSubmatch(err.Error())
+		pid, _ := strconv.Atoi(sleep[1])
+		Eventually(func() proctree.Tree { return proctree.Snapshot("/proc", pid) }).Should(BeEmpty())
+		for _, key := range []string{"npm_config_script_shell", "BP_SCRIPT_SHELL", "BP_SCRIPT_PIDS"} {
+			_, found := os.LookupEnv(key)
+			Expect(found).To(BeFalse(), key)
+		}
+	})
+
+	It("leaves the scripts which finish in time alone", func() {
+		os.Setenv("BP_SCRIPT_TIMEOUT", "10")
+		mockNPM.EXPECT().Build(buildDir, gomock.Any()).DoAndReturn(func(string, string) error {
+			return lifecycle(buildDir, "shop", "prepare", "touch built")
+		})
+
+		Expect(supplier.BuildDependencies()).To(Succeed())
+		Expect(filepath.Join(buildDir, "built")).To(BeAnExistingFile())
+		Expect(buffer.String()).NotTo(ContainSubstring("BP_SCRIPT_TIMEOUT"))
+	})
+
+	It("keeps the script shell of the app", func() {
+		os.Setenv("BP_SCRIPT_TIMEOUT", "10m")
+		os.Setenv("npm_config_script_shell", "/bin/bash")
+		mockNPM.EXPECT().Build(buildDir, gomock.Any()).DoAndReturn(func(string, string) error {
+			return lifecycle(buildDir, "shop", "prepare", `[ -n "$BASH_VERSION" ] && touch built`)
+		})
+
+		Expect(supplier.BuildDependencies()).To(Succeed())
+		Expect(filepath.Join(buildDir, "built")).To(BeAnExistingFile())
+		Expect(os.Getenv("npm_config_script_shell")).To(Equal("/bin/bash"))
+	})
+
+	It("fails for an invalid timeout", func() {
+		os.Setenv("BP_SCRIPT_TIMEOUT", "10m,postinstall")
+		Expect(supplier.BuildDependencies()).To(MatchError(`invalid BP_SCRIPT_TIMEOUT "10m,postinstall", expected a duration like 10m, or durations by script like 10m,postinstall=2m`))
+	})
+})
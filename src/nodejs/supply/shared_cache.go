@@ -0,0 +1,23 @@
+package supply
+
+import (
+	"nodejs/cache"
+	"os"
+)
+
+// PrimeNPMCache copies the npm cache entries of the read-only shared cache in
+// BP_SHARED_CACHE_DIR which the build cache lacks, so that npm finds the
+// tarballs which similar apps downloaded before going to the registry. The
+// shared cache is the platform's, an absent or unreadable one primes nothing.
+func (s *Supplier) PrimeNPMCache() error {
+	shared := os.Getenv("BP_SHARED_CACHE_DIR")
+	if shared == "" || s.UseYarn || s.Stager.CacheDir() == "" {
+		return nil
+	}
+
+	copied, err := cache.NewLayered(shared, s.Stager.CacheDir()).Prime(".npm")
+	if copied > 0 {
+		s.Log.Info("Primed the npm cache with %d files from %s", copied, shared)
+	}
+	return err
+}
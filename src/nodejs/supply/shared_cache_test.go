@@ -0,0 +1,76 @@
+package supply_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"nodejs/supply"
+	"os"
+	"path/filepath"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/cloudfoundry/libbuildpack/ansicleaner"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("PrimeNPMCache", func() {
+	var (
+		err       error
+		cacheDir  string
+		sharedDir string
+		supplier  *supply.Supplier
+		buffer    *bytes.Buffer
+		oldShared string
+	)
+
+	BeforeEach(func() {
+		cacheDir, err = ioutil.TempDir("", "nodejs-buildpack.cache.")
+		Expect(err).To(BeNil())
+		sharedDir, err = ioutil.TempDir("", "nodejs-buildpack.shared.")
+		Expect(err).To(BeNil())
+
+		Expect(os.MkdirAll(filepath.Join(sharedDir, ".npm", "_cacache"), 0755)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(sharedDir, ".npm", "_cacache", "tarball"), []byte("tarball"), 0644)).To(Succeed())
+
+		oldShared = os.Getenv("BP_SHARED_CACHE_DIR")
+		os.Setenv("BP_SHARED_CACHE_DIR", sharedDir)
+
+		buffer = new(bytes.Buffer)
+		logger := libbuildpack.NewLogger(ansicleaner.New(buffer))
+		supplier = &supply.Supplier{
+			Stager: libbuildpack.NewStager([]string{"", cacheDir, "", "0"}, logger, &libbuildpack.Manifest{}),
+			Log:    logger,
+		}
+	})
+
+	AfterEach(func() {
+		os.Setenv("BP_SHARED_CACHE_DIR", oldShared)
+		Expect(os.RemoveAll(cacheDir)).To(Succeed())
+		Expect(os.RemoveAll(sharedDir)).To(Succeed())
+	})
+
+	It("copies the shared npm cache into the build cache", func() {
+		Expect(supplier.PrimeNPMCache()).To(Succeed())
+		Expect(ioutil.ReadFile(filepath.Join(cacheDir, ".npm", "_cacache", "tarball"))).To(Equal([]byte("tarball")))
+		Expect(buffer.String()).To(ContainSubstring("Primed the npm cache with 1 files from " + sharedDir))
+	})
+
+	It("does nothing for yarn apps", func() {
+		supplier.UseYarn = true
+		Expect(supplier.PrimeNPMCache()).To(Succeed())
+		Expect(filepath.Join(cacheDir, ".npm")).NotTo(BeADirectory())
+	})
+
+	It("does nothing without BP_SHARED_CACHE_DIR", func() {
+		os.Setenv("BP_SHARED_CACHE_DIR", "")
+		Expect(supplier.PrimeNPMCache()).To(Succeed())
+		Expect(filepath.Join(cacheDir, ".npm")).NotTo(BeADirectory())
+		Expect(buffer.String()).To(Equal(""))
+	})
+
+	It("tolerates a missing shared cache", func() {
+		os.Setenv("BP_SHARED_CACHE_DIR", filepath.Join(sharedDir, "missing"))
+		Expect(supplier.PrimeNPMCache()).To(Succeed())
+		Expect(buffer.String()).To(Equal(""))
+	})
+})
@@ -0,0 +1,35 @@
+package supply
+
+import (
+	"fmt"
+	"nodejs/singleton"
+	"os"
+	"strings"
+)
+
+// WarnDuplicateSingletons warns about the packages which must be loaded once,
+// BP_SINGLETON_PACKAGES or singleton.Default, when node_modules holds more
+// than one version of them, with the dependency chains which pull each in.
+func (s *Supplier) WarnDuplicateSingletons() error {
+	duplicates, err := singleton.Find(s.Stager.BuildDir(), singleton.ParseList(os.Getenv("BP_SINGLETON_PACKAGES")))
+	if err != nil {
+		return err
+	}
+
+	for _, d := range duplicates {
+		lines := []string{fmt.Sprintf("Found %d versions of %s, which must be installed once:", len(d.Versions), d.Name)}
+		for _, version := range d.Versions {
+			chains := d.Chains[version]
+			if len(chains) == 0 {
+				lines = append(lines, fmt.Sprintf("  %s@%s", d.Name, version))
+				continue
+			}
+			for _, chain := range chains {
+				lines = append(lines, fmt.Sprintf("  %s@%s: package.json > %s", d.Name, version, chain))
+			}
+		}
+		lines = append(lines, "Align the versions these packages require, or dedupe them with overrides (npm) or resolutions (yarn)")
+		s.Log.Warning("%s", strings.Join(lines, "\n"))
+	}
+	return nil
+}
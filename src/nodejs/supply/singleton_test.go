@@ -0,0 +1,95 @@
+package supply_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"nodejs/supply"
+	"os"
+	"path/filepath"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/cloudfoundry/libbuildpack/ansicleaner"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("WarnDuplicateSingletons", func() {
+	var (
+		err      error
+		buildDir string
+		supplier *supply.Supplier
+		buffer   *bytes.Buffer
+		oldEnv   string
+		hadEnv   bool
+	)
+
+	writeFile := func(path, contents string) {
+		path = filepath.Join(buildDir, path)
+		Expect(os.MkdirAll(filepath.Dir(path), 0755)).To(Succeed())
+		Expect(ioutil.WriteFile(path, []byte(contents), 0644)).To(Succeed())
+	}
+
+	BeforeEach(func() {
+		oldEnv, hadEnv = os.LookupEnv("BP_SINGLETON_PACKAGES")
+		os.Unsetenv("BP_SINGLETON_PACKAGES")
+
+		buildDir, err = ioutil.TempDir("", "nodejs-buildpack.build.")
+		Expect(err).To(BeNil())
+
+		writeFile("package.json", `{"dependencies": {"react": "^18.2.0", "old-ui": "^1.0.0"}}`)
+		writeFile("package-lock.json", `{
+			"lockfileVersion": 3,
+			"packages": {
+				"": {"dependencies": {"react": "^18.2.0", "old-ui": "^1.0.0"}},
+				"node_modules/react": {"version": "18.2.0"},
+				"node_modules/old-ui": {"version": "1.0.0", "dependencies": {"react": "^17.0.0"}},
+				"node_modules/old-ui/node_modules/react": {"version": "17.0.2"}
+			}
+		}`)
+		writeFile("node_modules/react/package.json", `{"name": "react", "version": "18.2.0"}`)
+		writeFile("node_modules/old-ui/package.json", `{"name": "old-ui", "version": "1.0.0"}`)
+		writeFile("node_modules/old-ui/node_modules/react/package.json", `{"name": "react", "version": "17.0.2"}`)
+
+		buffer = new(bytes.Buffer)
+		logger := libbuildpack.NewLogger(ansicleaner.New(buffer))
+		supplier = &supply.Supplier{
+			Stager: libbuildpack.NewStager([]string{buildDir, "", "", "0"}, logger, &libbuildpack.Manifest{}),
+			Log:    logger,
+		}
+	})
+
+	AfterEach(func() {
+		if hadEnv {
+			os.Setenv("BP_SINGLETON_PACKAGES", oldEnv)
+		} else {
+			os.Unsetenv("BP_SINGLETON_PACKAGES")
+		}
+		Expect(os.RemoveAll(buildDir)).To(Succeed())
+	})
+
+	It("warns about the versions of a singleton and the chains pulling them in", func() {
+		Expect(supplier.WarnDuplicateSingletons()).To(Succeed())
+		Expect(buffer.String()).To(ContainSubstring("Found 2 versions of react, which must be installed once:"))
+		Expect(buffer.String()).To(ContainSubstring("react@17.0.2: package.json > old-ui@1.0.0 > react@17.0.2"))
+		Expect(buffer.String()).To(ContainSubstring("react@18.2.0: package.json > react@18.2.0"))
+	})
+
+	It("lists the versions alone without a lockfile", func() {
+		Expect(os.Remove(filepath.Join(buildDir, "package-lock.json"))).To(Succeed())
+		Expect(supplier.WarnDuplicateSingletons()).To(Succeed())
+		Expect(buffer.String()).To(ContainSubstring("Found 2 versions of react"))
+		Expect(buffer.String()).NotTo(ContainSubstring("package.json >"))
+	})
+
+	It("watches the packages in BP_SINGLETON_PACKAGES instead", func() {
+		os.Setenv("BP_SINGLETON_PACKAGES", "vue")
+		Expect(supplier.WarnDuplicateSingletons()).To(Succeed())
+		Expect(buffer.String()).To(BeEmpty())
+	})
+
+	It("is off with BP_SINGLETON_PACKAGES=none", func() {
+		os.Setenv("BP_SINGLETON_PACKAGES", "none")
+		Expect(supplier.WarnDuplicateSingletons()).To(Succeed())
+		Expect(buffer.String()).To(BeEmpty())
+	})
+})
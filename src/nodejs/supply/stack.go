@@ -0,0 +1,77 @@
+package supply
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type NodeStackSupport struct {
+	Stack string
+	// MaxNodeMajor is the newest Node.js major whose binaries run on the stack
+	MaxNodeMajor int
+	Reason       string
+}
+
+// NodeStackSupportTable lists known stack limitations. Stacks which are not
+// listed support every Node.js version in the manifest.
+var NodeStackSupportTable = []NodeStackSupport{
+	{Stack: "cflinuxfs2", MaxNodeMajor: 16, Reason: "Node.js 18 and later require glibc 2.28, cflinuxfs2 ships glibc 2.19"},
+	{Stack: "cflinuxfs3", MaxNodeMajor: 16, Reason: "Node.js 18 and later require glibc 2.28, cflinuxfs3 ships glibc 2.27"},
+}
+
+// StackGlibcVersions is the glibc version shipped by each stack.
+var StackGlibcVersions = map[string]string{
+	"cflinuxfs2": "2.19",
+	"cflinuxfs3": "2.27",
+	"cflinuxfs4": "2.35",
+}
+
+func nodeMajor(version string) (int, error) {
+	return strconv.Atoi(strings.SplitN(strings.TrimPrefix(version, "v"), ".", 2)[0])
+}
+
+func nodeStackSupport(stack string) (NodeStackSupport, bool) {
+	for _, support := range NodeStackSupportTable {
+		if support.Stack == stack {
+			return support, true
+		}
+	}
+	return NodeStackSupport{}, false
+}
+
+// CheckNodeStackSupport returns an error naming the stack, the requested
+// version and the supported alternatives when the resolved Node.js version
+// cannot run on the stack.
+func CheckNodeStackSupport(stack, requested, resolved string, available []string) error {
+	support, found := nodeStackSupport(stack)
+	if !found || support.MaxNodeMajor == 0 {
+		return nil
+	}
+
+	major, err := nodeMajor(resolved)
+	if err != nil {
+		return err
+	}
+	if major <= support.MaxNodeMajor {
+		return nil
+	}
+
+	var alternatives []string
+	for _, version := range available {
+		if m, err := nodeMajor(version); err == nil && m <= support.MaxNodeMajor {
+			alternatives = append(alternatives, version)
+		}
+	}
+
+	if requested == "" {
+		requested = "default"
+	}
+	msg := fmt.Sprintf("Node.js %s (requested: %s) is not supported on stack %s: %s", resolved, requested, stack, support.Reason)
+	if len(alternatives) > 0 {
+		msg += fmt.Sprintf("\nVersions supported on %s in this buildpack: %s", stack, strings.Join(alternatives, ", "))
+	} else {
+		msg += fmt.Sprintf("\nThis buildpack contains no Node.js versions supported on %s", stack)
+	}
+	return fmt.Errorf("%s", msg)
+}
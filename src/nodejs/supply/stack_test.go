@@ -0,0 +1,35 @@
+package supply_test
+
+import (
+	"nodejs/supply"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("CheckNodeStackSupport", func() {
+	available := []string{"6.14.4", "8.12.0", "16.20.2", "18.19.0", "20.11.1"}
+
+	It("allows versions the stack supports", func() {
+		Expect(supply.CheckNodeStackSupport("cflinuxfs3", "16.x", "16.20.2", available)).To(Succeed())
+	})
+
+	It("allows anything on stacks without known limits", func() {
+		Expect(supply.CheckNodeStackSupport("cflinuxfs4", "20.x", "20.11.1", available)).To(Succeed())
+		Expect(supply.CheckNodeStackSupport("", "20.x", "20.11.1", available)).To(Succeed())
+	})
+
+	It("names the stack, the request and the alternatives", func() {
+		err := supply.CheckNodeStackSupport("cflinuxfs3", "20.x", "20.11.1", available)
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("Node.js 20.11.1 (requested: 20.x) is not supported on stack cflinuxfs3"))
+		Expect(err.Error()).To(ContainSubstring("glibc 2.28"))
+		Expect(err.Error()).To(ContainSubstring("Versions supported on cflinuxfs3 in this buildpack: 6.14.4, 8.12.0, 16.20.2"))
+	})
+
+	It("reports the default version when nothing was requested", func() {
+		err := supply.CheckNodeStackSupport("cflinuxfs2", "", "18.19.0", []string{"18.19.0"})
+		Expect(err).To(MatchError(ContainSubstring("(requested: default)")))
+		Expect(err).To(MatchError(ContainSubstring("no Node.js versions supported on cflinuxfs2")))
+	})
+})
@@ -7,9 +7,20 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"nodejs/cache"
+	"nodejs/failure"
+	"nodejs/heartbeat"
+	"nodejs/locale"
+	"nodejs/operatordefaults"
+	"nodejs/packagejson"
+	"nodejs/profiled"
+	"nodejs/versionresolver"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/cloudfoundry/libbuildpack"
 	"github.com/cloudfoundry/libbuildpack/checksum"
@@ -17,11 +28,13 @@ import (
 
 type Command interface {
 	Execute(string, io.Writer, io.Writer, string, ...string) error
+	Run(*exec.Cmd) error
 }
 
 type Manifest interface {
 	AllDependencyVersions(string) []string
 	DefaultVersion(string) (libbuildpack.Dependency, error)
+	RootDir() string
 }
 
 type Installer interface {
@@ -31,11 +44,12 @@ type Installer interface {
 
 type NPM interface {
 	Build(string, string) error
-	Rebuild(string) error
+	Rebuild(string, []string) error
 }
 
 type Yarn interface {
 	Build(string, string) error
+	BuildWorkspace(string, string, string, bool) error
 }
 
 type Stager interface {
@@ -45,7 +59,6 @@ type Stager interface {
 	DepsIdx() string
 	LinkDirectoryInDepDir(string, string) error
 	WriteEnvFile(string, string) error
-	WriteProfileD(string, string) error
 	SetStagingEnvironment() error
 }
 
@@ -57,7 +70,11 @@ type Supplier struct {
 	Logfile            *os.File
 	Command            Command
 	NodeVersion        string
+	NodeVersionSource  string
+	NodeWarnings       []string
+	ExactNodeVersion   string
 	YarnVersion        string
+	PythonVersion      string
 	NPMVersion         string
 	PreBuild           string
 	StartScript        string
@@ -65,36 +82,111 @@ type Supplier struct {
 	PostBuild          string
 	UseYarn            bool
 	IsVendored         bool
+	HasPackageJSON     bool
 	Yarn               Yarn
 	NPM                NPM
-}
-
-type packageJSON struct {
-	Engines engines `json:"engines"`
-}
-
-type engines struct {
-	Node string `json:"node"`
-	Yarn string `json:"yarn"`
-	NPM  string `json:"npm"`
-	Iojs string `json:"iojs"`
+	GeneratedNPMRC     string
+	NPMStagingEnv      []string
+	// NPMStagingSaved are the values the user had set for variables of
+	// NPMStagingEnv, which cleanup restores.
+	NPMStagingSaved map[string]string
+	// DeferredProduction installs devDependencies for the build scripts
+	// although NPM_CONFIG_PRODUCTION=true, see DeferProduction.
+	DeferredProduction bool
+	BuildScriptEnv     []string
+	StackChanged       bool
+	LockfileDigest     string
+	PackageManagers    PackageManagers
+	// AuditSkipped turns off the audit BP_NPM_AUDIT asks for, when the
+	// optional network budget has no room for it.
+	AuditSkipped bool
+	// Locales lists the locales of the stack, locale -a unless set.
+	Locales locale.Lister
+	// StatFilesystem replaces statfs in the disk checks, for tests.
+	StatFilesystem func(string) (FilesystemStat, error)
+	// DryRun is set by PlanRun, whose commands are only recorded: the
+	// checks of what they download have nothing to check.
+	DryRun bool
 }
 
 func Run(s *Supplier) error {
 	return checksum.Do(s.Stager.BuildDir(), s.Log.Debug, func() error {
 		s.Log.BeginStep("Installing binaries")
+		if err := s.LoadAppConfig(); err != nil {
+			s.Log.Error("Unable to load the buildpack config: %s", err.Error())
+			return err
+		}
+
 		if err := s.LoadPackageJSON(); err != nil {
 			s.Log.Error("Unable to load package.json: %s", err.Error())
 			return err
 		}
 
-		s.WarnNodeEngine()
+		if err := s.CheckPermissions(); err != nil {
+			s.Log.Error("Unable to check app directory permissions: %s", err.Error())
+			return err
+		}
+
+		if err := s.SetupTmpDir(); err != nil {
+			s.Log.Error("Unable to setup tmp directory: %s", err.Error())
+			return err
+		}
+
+		defer func() {
+			if err := s.CleanupTmpDir(); err != nil {
+				s.Log.Warning("Unable to remove tmp directory: %s", err.Error())
+			}
+		}()
+
+		if err := s.SweepCache(); err != nil {
+			s.Log.Warning("Unable to clean up the cache: %s", err.Error())
+		}
+
+		if err := s.CheckDiskSpace(); err != nil {
+			s.Log.Error(err.Error())
+			return err
+		}
+
+		if err := s.SetupNodeGypPython(); err != nil {
+			s.Log.Error(err.Error())
+			return err
+		}
 
 		if err := s.InstallNode("/tmp/node"); err != nil {
 			s.Log.Error("Unable to install node: %s", err.Error())
 			return err
 		}
 
+		if err := s.WarnNodeEngine(); err != nil {
+			s.Log.Error("Unable to check the requested node version: %s", err.Error())
+			return err
+		}
+
+		if err := s.CheckNodeSass(); err != nil {
+			s.Log.Error(err.Error())
+			return err
+		}
+
+		if err := s.SetupDNSResultOrder(); err != nil {
+			s.Log.Error(err.Error())
+			return err
+		}
+
+		if err := s.SetupOpenSSLLegacyProvider(); err != nil {
+			s.Log.Error("Unable to setup %s: %s", OpenSSLLegacyProvider, err.Error())
+			return err
+		}
+
+		if err := s.SetupOperatorNodeOptions(); err != nil {
+			s.Log.Error("Unable to setup the NODE_OPTIONS of the platform operator: %s", err.Error())
+			return err
+		}
+
+		if err := s.SetupLocale(); err != nil {
+			s.Log.Error("Unable to setup the locale: %s", err.Error())
+			return err
+		}
+
 		if err := s.InstallNPM(); err != nil {
 			s.Log.Error("Unable to install npm: %s", err.Error())
 			return err
@@ -105,6 +197,11 @@ func Run(s *Supplier) error {
 			return err
 		}
 
+		if err := s.LinkLegacyNodePaths(); err != nil {
+			s.Log.Error("Unable to link the legacy node paths: %s", err.Error())
+			return err
+		}
+
 		if err := s.CreateDefaultEnv(); err != nil {
 			s.Log.Error("Unable to setup default environment: %s", err.Error())
 			return err
@@ -115,16 +212,32 @@ func Run(s *Supplier) error {
 			os.Exit(11)
 		}
 
+		if err := s.PrepareNodeModules(); err != nil {
+			s.Log.Error("Unable to prepare node_modules: %s", err.Error())
+			return err
+		}
+
 		if err := s.ReadPackageJSON(); err != nil {
 			s.Log.Error("Failed parsing package.json: %s", err.Error())
 			return err
 		}
 
+		if !s.HasPackageJSON {
+			// A single file app gets node and its environment only, finalize
+			// starts it with node.
+			return nil
+		}
+
 		if err := s.TipVendorDependencies(); err != nil {
 			s.Log.Error(err.Error())
 			return err
 		}
 
+		if err := s.CheckLocalPackageCycles(); err != nil {
+			s.Log.Error(err.Error())
+			return err
+		}
+
 		s.ListNodeConfig(os.Environ())
 
 		if err := s.OverrideCacheFromApp(); err != nil {
@@ -132,17 +245,159 @@ func Run(s *Supplier) error {
 			return err
 		}
 
+		if err := s.CheckStackChange(); err != nil {
+			s.Log.Error("Unable to check for a stack change: %s", err.Error())
+			return err
+		}
+
+		if err := s.PrimeNPMCache(); err != nil {
+			s.Log.Warning("Unable to prime the npm cache from the shared cache: %s", err.Error())
+		}
+
+		if err := s.SetupNPMTokenAuth(); err != nil {
+			s.Log.Error("Unable to setup NPM_TOKEN authentication: %s", err.Error())
+			return err
+		}
+
+		defer func() {
+			if err := s.CleanupNPMTokenAuth(); err != nil {
+				s.Log.Warning("Unable to remove generated npm config: %s", err.Error())
+			}
+		}()
+
+		if err := s.SetupNPMStagingDefaults(); err != nil {
+			s.Log.Error("Unable to setup npm staging defaults: %s", err.Error())
+			return err
+		}
+		defer s.CleanupNPMStagingDefaults()
+
+		if err := s.SetupNetworkConcurrency(); err != nil {
+			s.Log.Error(err.Error())
+			return err
+		}
+
+		if err := s.LoadDotenv(); err != nil {
+			s.Log.Error("Unable to load dotenv file: %s", err.Error())
+			return err
+		}
+
+		s.AddBuildScriptMetadata()
+
+		if err := s.WriteBuildInfo(); err != nil {
+			s.Log.Error("Unable to write the build info: %s", err.Error())
+			return err
+		}
+
+		if err := s.SetupBrowserDownloads(); err != nil {
+			s.Log.Error("Unable to setup browser downloads: %s", err.Error())
+			return err
+		}
+
+		if err := s.InstallGlobalPackages(); err != nil {
+			s.Log.Error("Unable to install global packages: %s", err.Error())
+			return err
+		}
+
+		defer func() {
+			if err := s.CleanupGlobalPackages(); err != nil {
+				s.Log.Warning("Unable to remove global packages: %s", err.Error())
+			}
+		}()
+
 		defer func() {
 			s.Logfile.Sync()
 			s.WarnUntrackedDependencies()
 			s.WarnMissingDevDeps()
+			s.WarnOpenSSLLegacyProvider()
 		}()
 
+		if err := s.RecordLockfile(); err != nil {
+			s.Log.Error("Unable to read package-lock.json: %s", err.Error())
+			return err
+		}
+
+		if err := s.RemoveForeignPackages(); err != nil {
+			s.Log.Error("Unable to check node_modules for other platforms: %s", err.Error())
+			return err
+		}
+
+		if err := s.ReplayResolved(); err != nil {
+			s.Log.Error("Unable to replay resolved tarballs: %s", err.Error())
+			return err
+		}
+
+		if err := s.RestoreBuildCache(); err != nil {
+			s.Log.Error("Unable to restore the build cache: %s", err.Error())
+			return err
+		}
+
 		if err := s.BuildDependencies(); err != nil {
 			s.Log.Error("Unable to build dependencies: %s", err.Error())
 			return err
 		}
 
+		if err := s.StoreBuildCache(); err != nil {
+			s.Log.Warning("Unable to store the build cache: %s", err.Error())
+		}
+
+		if err := s.WritePnPProfile(); err != nil {
+			s.Log.Error("Unable to setup Plug'n'Play: %s", err.Error())
+			return err
+		}
+
+		if err := s.RebuildNativeModules(); err != nil {
+			s.Log.Error("Unable to rebuild native modules: %s", err.Error())
+			return err
+		}
+
+		if err := s.CheckNativeModules(); err != nil {
+			s.Log.Error("Unable to check native modules: %s", err.Error())
+			return err
+		}
+
+		if err := s.CheckPlatformPackages(); err != nil {
+			s.Log.Error(err.Error())
+			return err
+		}
+
+		if err := s.Logfile.Sync(); err != nil {
+			s.Log.Error(err.Error())
+			return err
+		}
+
+		if err := s.SummarizeOptionalDependencies(); err != nil {
+			s.Log.Error(err.Error())
+			return err
+		}
+
+		if err := s.PruneDependencies(); err != nil {
+			s.Log.Error("Unable to prune dependencies: %s", err.Error())
+			return err
+		}
+
+		if err := s.WriteInstalledDependencies(); err != nil {
+			s.Log.Warning("Unable to list installed dependencies: %s", err.Error())
+		}
+
+		if err := s.RecordResolved(); err != nil {
+			s.Log.Error("Unable to record resolved tarballs: %s", err.Error())
+			return err
+		}
+
+		if err := s.WarnDuplicateSingletons(); err != nil {
+			s.Log.Warning("Unable to check for duplicate singleton packages: %s", err.Error())
+		}
+
+		if err := s.CheckPackageDenylist(); err != nil {
+			s.Log.Error(err.Error())
+			return err
+		}
+
+		if err := s.FinishBrowserDownloads(); err != nil {
+			s.Log.Error(err.Error())
+			return err
+		}
+
 		if err := s.MoveDependencyArtifacts(); err != nil {
 			s.Log.Error("Unable to move dependencies: %s", err.Error())
 			return err
@@ -150,6 +405,10 @@ func Run(s *Supplier) error {
 
 		s.ListDependencies()
 
+		if err := s.RecordNodeModulesSize(); err != nil {
+			s.Log.Warning("Unable to record node_modules size: %s", err.Error())
+		}
+
 		if err := s.Logfile.Sync(); err != nil {
 			s.Log.Error(err.Error())
 			return err
@@ -160,6 +419,14 @@ func Run(s *Supplier) error {
 			return err
 		}
 
+		if err := s.SummarizeInstallWarnings(); err != nil {
+			s.Log.Warning("Unable to summarize install warnings: %s", err.Error())
+		}
+
+		if err := s.WriteMigrationReport(); err != nil {
+			s.Log.Warning("Unable to write migration report: %s", err.Error())
+		}
+
 		return nil
 	})
 }
@@ -210,6 +477,15 @@ func (s *Supplier) runScript(script, tool string) error {
 
 	s.Log.Info("Running %s (%s)", script, tool)
 
+	if len(s.BuildScriptEnv) > 0 {
+		cmd := exec.Command(tool, args...)
+		cmd.Dir = s.Stager.BuildDir()
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		cmd.Env = append(os.Environ(), s.BuildScriptEnv...)
+		return s.Command.Run(cmd)
+	}
+
 	return s.Command.Execute(s.Stager.BuildDir(), os.Stdout, os.Stderr, tool, args...)
 
 }
@@ -222,7 +498,17 @@ func (s *Supplier) runPrebuild(tool string) error {
 	return s.runScript("heroku-prebuild", tool)
 }
 
+// BuildDependencies installs the dependencies and runs the build scripts,
+// killing the scripts which run longer than BP_SCRIPT_TIMEOUT.
 func (s *Supplier) BuildDependencies() error {
+	supervisor, err := s.superviseScripts()
+	if err != nil {
+		return failure.Wrap(failure.BuildScriptFailed, err)
+	}
+	return supervisor.Stop(s.buildDependencies())
+}
+
+func (s *Supplier) buildDependencies() error {
 	var tool string
 	if s.UseYarn {
 		tool = "yarn"
@@ -233,70 +519,83 @@ func (s *Supplier) BuildDependencies() error {
 	s.Log.BeginStep("Building dependencies")
 
 	if err := s.runPrebuild(tool); err != nil {
-		return err
+		return failure.Wrap(failure.BuildScriptFailed, err)
 	}
 
-	if s.UseYarn {
-		if err := s.Yarn.Build(s.Stager.BuildDir(), s.Stager.CacheDir()); err != nil {
-			return err
-		}
-	} else if s.IsVendored {
-		s.Log.Info("Prebuild detected (node_modules already exists)")
-		if err := s.NPM.Rebuild(s.Stager.BuildDir()); err != nil {
-			return err
-		}
-	} else {
-		if err := s.NPM.Build(s.Stager.BuildDir(), s.Stager.CacheDir()); err != nil {
-			return err
-		}
+	recordYarnCache, err := s.trackYarnCache()
+	if err != nil {
+		return err
 	}
-
-	if err := s.runPostbuild(tool); err != nil {
+	stopTracking, err := s.trackLifecycle()
+	if err != nil {
+		return err
+	}
+	stopProgress, err := s.reportInstallProgress()
+	if err != nil {
+		return err
+	}
+	installStart := s.logfileSize()
+	err = s.installDependencies()
+	stopProgress()
+	lifecycle, trackErr := stopTracking()
+	if err != nil {
+		return failure.Wrap(failure.InstallFailed, s.diagnoseInstallFailure(err, installStart))
+	}
+	if trackErr != nil {
+		return trackErr
+	}
+	if err := recordYarnCache(); err != nil {
 		return err
 	}
 
-	return nil
-}
-
-func (s *Supplier) MoveDependencyArtifacts() error {
-	if s.IsVendored {
-		return nil
+	if err := s.InstallNestedPaths(); err != nil {
+		return err
 	}
 
-	appNodeModules := filepath.Join(s.Stager.BuildDir(), "node_modules")
+	if err := s.ApplyPatches(); err != nil {
+		return failure.Wrap(failure.InstallFailed, err)
+	}
 
-	_, err := os.Stat(appNodeModules)
-	if err != nil {
-		if os.IsNotExist(err) {
-			return nil
-		}
+	// The build scripts and postbuild may import the Prisma client.
+	if err := s.GeneratePrismaClient(); err != nil {
 		return err
 	}
 
-	nodePath := filepath.Join(s.Stager.DepDir(), "node_modules")
+	if err := s.RunScripts(tool, lifecycle); err != nil {
+		return failure.Wrap(failure.BuildScriptFailed, err)
+	}
 
-	if err := os.Rename(appNodeModules, nodePath); err != nil {
-		return err
+	if err := s.runPostbuild(tool); err != nil {
+		return failure.Wrap(failure.BuildScriptFailed, err)
 	}
 
-	if err := s.Stager.WriteEnvFile("NODE_PATH", nodePath); err != nil {
-		return err
+	return nil
+}
+
+// installDependencies installs the dependencies with the package manager of
+// the app.
+func (s *Supplier) installDependencies() error {
+	if workspace := os.Getenv("BP_NODE_WORKSPACE"); s.UseYarn && workspace != "" {
+		// Build scripts may need devDependencies, which focusing on the
+		// production dependencies would leave out.
+		return s.Yarn.BuildWorkspace(s.Stager.BuildDir(), s.Stager.CacheDir(), workspace, s.hasBuildScripts())
+	} else if s.UseYarn {
+		return s.Yarn.Build(s.Stager.BuildDir(), s.Stager.CacheDir())
+	} else if s.IsVendored {
+		s.Log.Info("Prebuild detected (node_modules already exists)")
+		return s.rebuildVendored()
 	}
+	return s.NPM.Build(s.Stager.BuildDir(), s.Stager.CacheDir())
+}
 
-	return os.Setenv("NODE_PATH", nodePath)
+// hasBuildScripts returns whether the app has scripts which run after the
+// install, and so may need its devDependencies.
+func (s *Supplier) hasBuildScripts() bool {
+	return s.PreBuild != "" || s.PostBuild != "" || strings.TrimSpace(os.Getenv("BP_NODE_RUN_SCRIPTS")) != ""
 }
 
 func (s *Supplier) ReadPackageJSON() error {
 	var err error
-	var p struct {
-		Scripts struct {
-			PreBuild    string `json:"heroku-prebuild"`
-			PostBuild   string `json:"heroku-postbuild"`
-			StartScript string `json:"start"`
-		} `json:"scripts"`
-		DevDependencies map[string]string `json:"devDependencies"`
-	}
-
 	if s.UseYarn, err = libbuildpack.FileExists(filepath.Join(s.Stager.BuildDir(), "yarn.lock")); err != nil {
 		return err
 	}
@@ -305,21 +604,22 @@ func (s *Supplier) ReadPackageJSON() error {
 		return err
 	}
 
-	if err := libbuildpack.NewJSON().Load(filepath.Join(s.Stager.BuildDir(), "package.json"), &p); err != nil {
-		if os.IsNotExist(err) {
-			s.Log.Warning("No package.json found")
-			return nil
-		} else {
-			return err
-		}
+	p, found, err := packagejson.Load(s.Stager.BuildDir())
+	if err != nil {
+		return err
+	} else if s.HasPackageJSON = found; !found {
+		s.Log.Warning("No package.json found, so no dependencies are installed\nAdd a package.json listing the dependencies of the app to install them")
+		return nil
 	}
 
 	s.HasDevDependencies = (len(p.DevDependencies) > 0)
-	s.PreBuild = p.Scripts.PreBuild
-	s.PostBuild = p.Scripts.PostBuild
-	s.StartScript = p.Scripts.StartScript
+	s.PreBuild = p.Scripts["heroku-prebuild"]
+	s.PostBuild = p.Scripts["heroku-postbuild"]
+	s.StartScript = p.Scripts["start"]
 
-	return nil
+	// Finalize reads this parse, rather than package.json as the build
+	// scripts left it.
+	return packagejson.Save(s.Stager.DepDir(), p)
 }
 
 func (s *Supplier) TipVendorDependencies() error {
@@ -360,7 +660,7 @@ func (s *Supplier) ListNodeConfig(environment []string) {
 
 	for _, env := range environment {
 		if strings.HasPrefix(env, "NPM_CONFIG_") || strings.HasPrefix(env, "YARN_") || strings.HasPrefix(env, "NODE_") {
-			s.Log.Info(env)
+			s.Log.Info("%s", redactEnv(env))
 		}
 
 		if env == "NPM_CONFIG_PRODUCTION=true" {
@@ -377,6 +677,15 @@ func (s *Supplier) ListNodeConfig(environment []string) {
 	}
 }
 
+func redactEnv(env string) string {
+	parts := strings.SplitN(env, "=", 2)
+	key := strings.ToUpper(parts[0])
+	if len(parts) == 2 && (strings.Contains(key, "TOKEN") || strings.Contains(key, "_AUTH") || strings.Contains(key, "PASSWORD")) {
+		return parts[0] + "=[REDACTED]"
+	}
+	return env
+}
+
 func (s *Supplier) WarnUntrackedDependencies() error {
 	for _, command := range []string{"grunt", "bower", "gulp"} {
 		if notFound, err := fileHasString(s.Logfile.Name(), command+": not found", command+": command not found"); err != nil {
@@ -432,10 +741,8 @@ func fileHasString(file string, patterns ...string) (bool, error) {
 }
 
 func (s *Supplier) LoadPackageJSON() error {
-	var p packageJSON
-
-	err := libbuildpack.NewJSON().Load(filepath.Join(s.Stager.BuildDir(), "package.json"), &p)
-	if err != nil && !os.IsNotExist(err) {
+	p, _, err := packagejson.Load(s.Stager.BuildDir())
+	if err != nil {
 		return err
 	}
 
@@ -455,55 +762,142 @@ func (s *Supplier) LoadPackageJSON() error {
 		s.Log.Info("engines.npm (package.json): unspecified (use default)")
 	}
 
-	s.NodeVersion = p.Engines.Node
 	s.NPMVersion = p.Engines.NPM
 	s.YarnVersion = p.Engines.Yarn
+	s.PythonVersion = p.Engines.Python
 
+	if s.NodeVersion, s.NodeVersionSource, err = versionresolver.Requested(s.Stager.BuildDir(), environMap()); err != nil {
+		return err
+	}
+	if s.NodeVersionSource != versionresolver.SourceEngines && s.NodeVersionSource != versionresolver.SourceDefault {
+		s.Log.Info("Using node version %s from %s", s.NodeVersion, s.NodeVersionSource)
+	}
+	return s.applyOperatorDefaults()
+}
+
+// applyOperatorDefaults uses the defaults of the platform operator for what
+// the app left unset. A default node version which no version of the
+// manifest matches is ignored, rather than failing every app without one,
+// and legacy-peer-deps is left to the .npmrc or env of an app which sets it.
+func (s *Supplier) applyOperatorDefaults() error {
+	defaults, warnings := operatordefaults.Load()
+	for _, warning := range warnings {
+		s.Log.Warning("%s", warning)
+	}
+
+	if defaults.Node != "" && s.NodeVersionSource == versionresolver.SourceDefault {
+		if _, err := versionresolver.Match(defaults.Node, s.Manifest.AllDependencyVersions("node")); err != nil {
+			s.Log.Warning("Ignoring the default node version %s of %s: %s", defaults.Node, defaults.Source, err)
+		} else {
+			s.NodeVersion, s.NodeVersionSource = defaults.Node, versionresolver.SourceOperator
+			s.Log.Info("Using node version %s from the operator defaults in %s", s.NodeVersion, defaults.Source)
+		}
+	}
+	if defaults.NPM.Version != "" && s.NPMVersion == "" {
+		s.NPMVersion = defaults.NPM.Version
+		s.Log.Info("Using npm version %s from the operator defaults in %s", s.NPMVersion, defaults.Source)
+	}
+	if defaults.NPM.LegacyPeerDeps != nil && os.Getenv("BP_NPM_LEGACY_PEER_DEPS") == "" {
+		contents, err := ioutil.ReadFile(filepath.Join(s.Stager.BuildDir(), ".npmrc"))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		_, inNPMRC := npmrcValues(contents)["legacy-peer-deps"]
+		_, inEnv := envValue(os.Environ(), "npm_config_legacy_peer_deps", true)
+		if !inNPMRC && !inEnv {
+			os.Setenv("BP_NPM_LEGACY_PEER_DEPS", strconv.FormatBool(*defaults.NPM.LegacyPeerDeps))
+		}
+	}
 	return nil
 }
 
-func (s *Supplier) WarnNodeEngine() {
-	docsLink := "http://docs.cloudfoundry.org/buildpacks/node/node-tips.html"
+func environMap() map[string]string {
+	env := map[string]string{}
+	for _, kv := range os.Environ() {
+		if parts := strings.SplitN(kv, "=", 2); len(parts) == 2 {
+			env[parts[0]] = parts[1]
+		}
+	}
+	return env
+}
 
-	if s.NodeVersion == "" {
-		s.Log.Warning("Node version not specified in package.json. See: %s", docsLink)
+// WarnNodeEngine warns in one block about the node version the app
+// requested, when it is missing, matches any new major or resolved to an
+// unmaintained one, and suggests the setting which pins the major the build
+// resolved, or the nearest maintained LTS. ResolveNode works the warning out.
+func (s *Supplier) WarnNodeEngine() error {
+	for _, warning := range s.NodeWarnings {
+		s.Log.Warning("%s", warning)
 	}
-	if s.NodeVersion == "*" {
-		s.Log.Warning("Dangerous semver range (*) in engines.node. See: %s", docsLink)
+	return nil
+}
+
+// deprecations returns the dependency deprecation dates of manifest.yml,
+// which the Manifest interface does not expose.
+func (s *Supplier) deprecations() ([]libbuildpack.DeprecationDate, error) {
+	var manifest struct {
+		Deprecations []libbuildpack.DeprecationDate `yaml:"dependency_deprecation_dates"`
 	}
-	if strings.HasPrefix(s.NodeVersion, ">") {
-		s.Log.Warning("Dangerous semver range (>) in engines.node. See: %s", docsLink)
+	if err := libbuildpack.NewYAML().Load(filepath.Join(s.Manifest.RootDir(), "manifest.yml"), &manifest); err != nil {
+		return nil, err
 	}
-	return
+	return manifest.Deprecations, nil
 }
 
-func (s *Supplier) InstallNode(tempDir string) error {
-	var dep libbuildpack.Dependency
+// ResolveNode returns the node dependency matching the requested version, or
+// the default version of the operator or of the manifest, which runs on the
+// stack. It keeps the requested version and the warnings about it.
+func (s *Supplier) ResolveNode() (libbuildpack.Dependency, error) {
+	deprecations, err := s.deprecations()
+	if err != nil {
+		return libbuildpack.Dependency{}, err
+	}
 
-	nodeInstallDir := filepath.Join(s.Stager.DepDir(), "node")
+	versions := s.Manifest.AllDependencyVersions("node")
+	resolver := versionresolver.Resolver{
+		Versions:     versions,
+		Unmaintained: versionresolver.UnmaintainedMajors(deprecations, time.Now()),
+	}
+	if s.NodeVersionSource == versionresolver.SourceOperator {
+		resolver.OperatorDefault = s.NodeVersion
+	}
+	// The manifest needs no default when the app requests a version.
+	defaultDep, defaultErr := s.Manifest.DefaultVersion("node")
+	resolver.Default = defaultDep.Version
 
-	if s.NodeVersion != "" {
-		versions := s.Manifest.AllDependencyVersions("node")
-		ver, err := libbuildpack.FindMatchingVersion(s.NodeVersion, versions)
-		if err != nil {
-			return err
-		}
-		dep.Name = "node"
-		dep.Version = ver
-	} else {
-		var err error
+	resolution, err := resolver.Resolve(s.Stager.BuildDir(), environMap())
+	if err == nil && resolution.Version == "" {
+		err = defaultErr
+	}
+	if err != nil {
+		return libbuildpack.Dependency{}, failure.Wrap(failure.NodeEngineUnresolvable, err)
+	}
 
-		dep, err = s.Manifest.DefaultVersion("node")
-		if err != nil {
-			return err
-		}
+	if err := CheckNodeStackSupport(os.Getenv("CF_STACK"), resolution.Constraint, resolution.Version, versions); err != nil {
+		return libbuildpack.Dependency{}, failure.Wrap(failure.NodeEngineUnresolvable, err)
 	}
 
-	if err := s.Installer.InstallDependency(dep, tempDir); err != nil {
+	s.NodeVersion, s.NodeVersionSource, s.NodeWarnings = resolution.Constraint, resolution.Source, resolution.Warnings
+	return libbuildpack.Dependency{Name: "node", Version: resolution.Version}, nil
+}
+
+// InstallNode installs node into <depdir>/node-v<version> and points the
+// <depdir>/node symlink at it. NODE_HOME, PATH and the profile.d scripts all
+// go through the symlink, so they never name a version.
+func (s *Supplier) InstallNode(tempDir string) error {
+	nodeInstallDir := filepath.Join(s.Stager.DepDir(), "node")
+
+	dep, err := s.ResolveNode()
+	if err != nil {
 		return err
 	}
 
-	if err := os.Rename(filepath.Join(tempDir, fmt.Sprintf("node-v%s-linux-x64", dep.Version)), nodeInstallDir); err != nil {
+	if err := s.Installer.InstallDependency(dep, tempDir); err != nil {
+		return failure.Download(err)
+	}
+	s.ExactNodeVersion = dep.Version
+
+	if err := activateNode(s.Stager.DepDir(), dep.Version, filepath.Join(tempDir, fmt.Sprintf("node-v%s-linux-x64", dep.Version))); err != nil {
 		return err
 	}
 
@@ -514,6 +908,53 @@ func (s *Supplier) InstallNode(tempDir string) error {
 	return os.Setenv("PATH", fmt.Sprintf("%s:%s", os.Getenv("PATH"), filepath.Join(s.Stager.DepDir(), "bin")))
 }
 
+// activateNode moves the node extracted to extracted into
+// <depDir>/node-v<version>, replaces the <depDir>/node symlink with one to it
+// in a single rename, and removes the other versions. A node dir from the
+// flat layout of older buildpacks is removed first, as the symlink cannot
+// replace a dir, and with it the global packages installed into it.
+func activateNode(depDir, version, extracted string) error {
+	versionDir := "node-v" + version
+	if err := os.RemoveAll(filepath.Join(depDir, versionDir)); err != nil {
+		return err
+	}
+	if err := os.Rename(extracted, filepath.Join(depDir, versionDir)); err != nil {
+		return err
+	}
+
+	link := filepath.Join(depDir, "node")
+	if info, err := os.Lstat(link); err == nil && info.Mode()&os.ModeSymlink == 0 {
+		if err := os.RemoveAll(link); err != nil {
+			return err
+		}
+	} else if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	tmpLink := link + ".tmp"
+	if err := os.RemoveAll(tmpLink); err != nil {
+		return err
+	}
+	if err := os.Symlink(versionDir, tmpLink); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpLink, link); err != nil {
+		return err
+	}
+
+	previous, err := filepath.Glob(filepath.Join(depDir, "node-v*"))
+	if err != nil {
+		return err
+	}
+	for _, dir := range previous {
+		if filepath.Base(dir) != versionDir {
+			if err := os.RemoveAll(dir); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
 func (s *Supplier) InstallNPM() error {
 	buffer := new(bytes.Buffer)
 	if err := s.Command.Execute(s.Stager.BuildDir(), buffer, buffer, "npm", "--version"); err != nil {
@@ -522,14 +963,14 @@ func (s *Supplier) InstallNPM() error {
 
 	npmVersion := strings.TrimSpace(buffer.String())
 
-	if s.NPMVersion == "" {
-		s.Log.Info("Using default npm version: %s", npmVersion)
-		return nil
+	managers, err := SelectPackageManagers(s.Stager.BuildDir(), s.NPMVersion, npmVersion)
+	if err != nil {
+		return err
 	}
+	s.PackageManagers = managers
 
-	_, err := libbuildpack.FindMatchingVersion(s.NPMVersion, []string{npmVersion})
-	if err == nil {
-		s.Log.Info("npm %s already installed with node", npmVersion)
+	if !managers.NPM {
+		s.Log.Info("%s", managers.NPMReason)
 		return nil
 	}
 
@@ -542,7 +983,9 @@ func (s *Supplier) InstallNPM() error {
 	return nil
 }
 
-func (s *Supplier) InstallYarn() error {
+// CheckYarnVersion fails when package.json requests a yarn version the
+// buildpack does not include.
+func (s *Supplier) CheckYarnVersion() error {
 	if s.YarnVersion != "" {
 		versions := s.Manifest.AllDependencyVersions("yarn")
 		_, err := libbuildpack.FindMatchingVersion(s.YarnVersion, versions)
@@ -550,11 +993,24 @@ func (s *Supplier) InstallYarn() error {
 			return fmt.Errorf("package.json requested %s, buildpack only includes yarn version %s", s.YarnVersion, strings.Join(versions, ", "))
 		}
 	}
+	return nil
+}
+
+// InstallYarn installs yarn when InstallNPM selected it.
+func (s *Supplier) InstallYarn() error {
+	if !s.PackageManagers.Yarn {
+		s.Log.Info("Skipping yarn install: %s", s.PackageManagers.YarnReason)
+		return nil
+	}
+
+	if err := s.CheckYarnVersion(); err != nil {
+		return err
+	}
 
 	yarnInstallDir := filepath.Join(s.Stager.DepDir(), "yarn")
 
 	if err := s.Installer.InstallOnlyVersion("yarn", yarnInstallDir); err != nil {
-		return err
+		return failure.Download(err)
 	}
 
 	if paths, err := filepath.Glob(filepath.Join(yarnInstallDir, "yarn-v*")); err != nil {
@@ -575,24 +1031,27 @@ func (s *Supplier) InstallYarn() error {
 	}
 
 	yarnVersion := strings.TrimSpace(buffer.String())
-	s.Log.Info("Installed yarn %s", yarnVersion)
+	s.Log.Info("Installed yarn %s (%s)", yarnVersion, s.PackageManagers.YarnReason)
 
 	return nil
 }
 
 func (s *Supplier) CreateDefaultEnv() error {
 	var environmentDefaults = map[string]string{
-		"NODE_ENV":              "production",
-		"NPM_CONFIG_PRODUCTION": "true",
-		"NPM_CONFIG_LOGLEVEL":   "error",
-		"NODE_MODULES_CACHE":    "true",
-		"NODE_VERBOSE":          "false",
-		"WEB_MEMORY":            "512",
-		"WEB_CONCURRENCY":       "1",
+		"NODE_MODULES_CACHE": "true",
+		"NODE_VERBOSE":       "false",
+		"WEB_MEMORY":         "512",
+		"WEB_CONCURRENCY":    "1",
 	}
 
 	s.Log.BeginStep("Creating runtime environment")
 
+	nodeEnv := ResolveNodeEnv(os.Environ())
+	s.Log.Info("%s", nodeEnv)
+	if err := s.writeNodeEnv(nodeEnv); err != nil {
+		return err
+	}
+
 	for envVar, envDefault := range environmentDefaults {
 		if os.Getenv(envVar) == "" {
 			if err := s.Stager.WriteEnvFile(envVar, envDefault); err != nil {
@@ -601,27 +1060,57 @@ func (s *Supplier) CreateDefaultEnv() error {
 		}
 	}
 
+	// npm config is left to the user when set in the environment or .npmrc
+	config, err := s.resolveNPMConfig()
+	if err != nil {
+		return err
+	}
+	for _, env := range config.RuntimeEnv {
+		kv := strings.SplitN(env, "=", 2)
+		if err := s.Stager.WriteEnvFile(kv[0], kv[1]); err != nil {
+			return err
+		}
+	}
+
 	if err := s.Stager.WriteEnvFile("NODE_HOME", filepath.Join(s.Stager.DepDir(), "node")); err != nil {
 		return err
 	}
 
+	runtimeNodeEnv := "${NODE_ENV:-production}"
+	if nodeEnv.RuntimeSource == "BP_RUNTIME_NODE_ENV" {
+		runtimeNodeEnv = shellQuote(nodeEnv.Runtime)
+	}
+
 	scriptContents := `export NODE_HOME=%[1]s
-export NODE_ENV=${NODE_ENV:-production}
+export NODE_ENV=%[2]s
 export MEMORY_AVAILABLE=$(echo $VCAP_APPLICATION | jq '.limits.mem')
 export WEB_MEMORY=${WEB_MEMORY:-512}
 export WEB_CONCURRENCY=${WEB_CONCURRENCY:-1}
-if [ ! -d "$HOME/node_modules" ]; then
-	export NODE_PATH=${NODE_PATH:-"%[2]s"}
-	ln -s "%[2]s" "$HOME/node_modules"
-else
-	export NODE_PATH=${NODE_PATH:-"$HOME/node_modules"}
-fi
-export PATH=$PATH:"$HOME/bin":$NODE_PATH/.bin
+export PATH=$PATH:"$HOME/bin":"$HOME/node_modules/.bin"
 `
-	return s.Stager.WriteProfileD("node.sh",
+	if err := profiled.Write(s.Stager, "node.sh",
 		fmt.Sprintf(scriptContents,
 			filepath.Join("$DEPS_DIR", s.Stager.DepsIdx(), "node"),
-			filepath.Join("$DEPS_DIR", s.Stager.DepsIdx(), "node_modules")))
+			runtimeNodeEnv)); err != nil {
+		return err
+	}
+
+	return s.setupDiagnostics()
+}
+
+// writeNodeEnv gives the rest of staging the build NODE_ENV. When the app
+// runs with another NODE_ENV, BP_RUNTIME_NODE_ENV keeps that value for the
+// later phases, which decide about pruning with it.
+func (s *Supplier) writeNodeEnv(nodeEnv NodeEnv) error {
+	if os.Getenv("NODE_ENV") != nodeEnv.Build {
+		if err := s.Stager.WriteEnvFile("NODE_ENV", nodeEnv.Build); err != nil {
+			return err
+		}
+	}
+	if nodeEnv.Split() && os.Getenv("BP_RUNTIME_NODE_ENV") != nodeEnv.Runtime {
+		return s.Stager.WriteEnvFile("BP_RUNTIME_NODE_ENV", nodeEnv.Runtime)
+	}
+	return nil
 }
 
 func copyAll(srcDir, destDir string, files []string) error {
@@ -634,13 +1123,12 @@ func copyAll(srcDir, destDir string, files []string) error {
 			return err
 		}
 		if fi.IsDir() {
-			if err := os.RemoveAll(filepath.Join(destDir, filename)); err != nil {
-				return err
-			}
-			if err := os.MkdirAll(filepath.Join(destDir, filename), 0755); err != nil {
-				return err
-			}
-			if err := libbuildpack.CopyDirectory(filepath.Join(srcDir, filename), filepath.Join(destDir, filename)); err != nil && !os.IsNotExist(err) {
+			if err := cache.New(destDir).Write(filename, func(dir string) error {
+				if err := libbuildpack.CopyDirectory(filepath.Join(srcDir, filename), dir); err != nil && !os.IsNotExist(err) {
+					return err
+				}
+				return nil
+			}); err != nil {
 				return err
 			}
 		} else {
@@ -659,9 +1147,7 @@ func (s *Supplier) OverrideCacheFromApp() error {
 	}
 
 	pkgMgrCacheDirs := []string{".cache/yarn", ".npm"}
-	if err := copyAll(s.Stager.BuildDir(), s.Stager.CacheDir(), pkgMgrCacheDirs); err != nil {
-		return err
-	}
-
-	return nil
+	return heartbeat.Watch(s.Log, "copying the package manager cache from the app", func() error {
+		return copyAll(s.Stager.BuildDir(), s.Stager.CacheDir(), pkgMgrCacheDirs)
+	})
 }
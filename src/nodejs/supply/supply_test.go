@@ -5,9 +5,13 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"nodejs/failure"
+	"nodejs/packagejson"
 	"nodejs/supply"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"strings"
 
 	"github.com/cloudfoundry/libbuildpack"
 	"github.com/cloudfoundry/libbuildpack/ansicleaner"
@@ -225,6 +229,101 @@ var _ = Describe("Supply", func() {
 					Expect(buffer.String()).To(ContainSubstring("engines.node (package.json): unspecified"))
 					Expect(buffer.String()).To(ContainSubstring("engines.npm (package.json): unspecified (use default)"))
 				})
+
+				It("falls back to .nvmrc", func() {
+					Expect(ioutil.WriteFile(filepath.Join(buildDir, ".nvmrc"), []byte("lts/carbon\n"), 0644)).To(Succeed())
+
+					err = supplier.LoadPackageJSON()
+					Expect(err).To(BeNil())
+
+					Expect(supplier.NodeVersion).To(Equal("lts/carbon"))
+					Expect(supplier.NodeVersionSource).To(Equal(".nvmrc"))
+					Expect(buffer.String()).To(ContainSubstring("Using node version lts/carbon from .nvmrc"))
+				})
+			})
+
+			Context("with operator defaults", func() {
+				var oldEnv map[string]string
+
+				BeforeEach(func() {
+					oldEnv = map[string]string{}
+					for _, key := range []string{"BP_DEFAULTS_FILE", "BP_NODE_VERSION", "BP_NPM_LEGACY_PEER_DEPS", "npm_config_legacy_peer_deps"} {
+						if value, found := os.LookupEnv(key); found {
+							oldEnv[key] = value
+						}
+						os.Unsetenv(key)
+					}
+					defaultsFile := filepath.Join(depsDir, "defaults.yml")
+					Expect(ioutil.WriteFile(defaultsFile, []byte("node: 20.x\nnpm:\n  version: 10.x\n  legacy_peer_deps: true\n"), 0644)).To(Succeed())
+					os.Setenv("BP_DEFAULTS_FILE", defaultsFile)
+					packageJSON = `{"name": "app"}`
+					mockManifest.EXPECT().AllDependencyVersions("node").Return([]string{"18.20.4", "20.17.0"}).AnyTimes()
+				})
+
+				AfterEach(func() {
+					for _, key := range []string{"BP_DEFAULTS_FILE", "BP_NODE_VERSION", "BP_NPM_LEGACY_PEER_DEPS", "npm_config_legacy_peer_deps"} {
+						if value, found := oldEnv[key]; found {
+							os.Setenv(key, value)
+						} else {
+							os.Unsetenv(key)
+						}
+					}
+				})
+
+				It("uses them when the app sets nothing", func() {
+					Expect(supplier.LoadPackageJSON()).To(Succeed())
+					Expect(supplier.NodeVersion).To(Equal("20.x"))
+					Expect(supplier.NodeVersionSource).To(Equal("operator defaults"))
+					Expect(supplier.NPMVersion).To(Equal("10.x"))
+					Expect(os.Getenv("BP_NPM_LEGACY_PEER_DEPS")).To(Equal("true"))
+					Expect(buffer.String()).To(ContainSubstring("Using node version 20.x from the operator defaults in " + filepath.Join(depsDir, "defaults.yml")))
+				})
+
+				It("leaves what the app sets alone", func() {
+					Expect(ioutil.WriteFile(filepath.Join(buildDir, "package.json"), []byte(`{"engines": {"node": "18.x", "npm": "9.x"}}`), 0644)).To(Succeed())
+					os.Setenv("BP_NPM_LEGACY_PEER_DEPS", "false")
+					Expect(supplier.LoadPackageJSON()).To(Succeed())
+					Expect(supplier.NodeVersion).To(Equal("18.x"))
+					Expect(supplier.NPMVersion).To(Equal("9.x"))
+					Expect(os.Getenv("BP_NPM_LEGACY_PEER_DEPS")).To(Equal("false"))
+				})
+
+				It("leaves legacy-peer-deps to the .npmrc of the app", func() {
+					Expect(ioutil.WriteFile(filepath.Join(buildDir, ".npmrc"), []byte("legacy-peer-deps=false\n"), 0644)).To(Succeed())
+					Expect(supplier.LoadPackageJSON()).To(Succeed())
+					_, found := os.LookupEnv("BP_NPM_LEGACY_PEER_DEPS")
+					Expect(found).To(BeFalse())
+				})
+
+				It("leaves legacy-peer-deps to npm_config_legacy_peer_deps", func() {
+					os.Setenv("npm_config_legacy_peer_deps", "false")
+					Expect(supplier.LoadPackageJSON()).To(Succeed())
+					_, found := os.LookupEnv("BP_NPM_LEGACY_PEER_DEPS")
+					Expect(found).To(BeFalse())
+				})
+
+				It("prefers BP_NODE_VERSION and .nvmrc", func() {
+					Expect(ioutil.WriteFile(filepath.Join(buildDir, ".nvmrc"), []byte("18\n"), 0644)).To(Succeed())
+					Expect(supplier.LoadPackageJSON()).To(Succeed())
+					Expect(supplier.NodeVersion).To(Equal("18"))
+					Expect(supplier.NodeVersionSource).To(Equal(".nvmrc"))
+				})
+
+				It("falls back to the default of the manifest for a node version it does not have", func() {
+					Expect(ioutil.WriteFile(os.Getenv("BP_DEFAULTS_FILE"), []byte("node: 16.x\n"), 0644)).To(Succeed())
+					Expect(supplier.LoadPackageJSON()).To(Succeed())
+					Expect(supplier.NodeVersion).To(Equal(""))
+					Expect(supplier.NodeVersionSource).To(Equal("default"))
+					Expect(buffer.String()).To(ContainSubstring("Ignoring the default node version 16.x of " + os.Getenv("BP_DEFAULTS_FILE")))
+				})
+
+				It("falls back to the defaults of the buildpack for a file which is not valid", func() {
+					Expect(ioutil.WriteFile(os.Getenv("BP_DEFAULTS_FILE"), []byte("node: [20.x\n"), 0644)).To(Succeed())
+					Expect(supplier.LoadPackageJSON()).To(Succeed())
+					Expect(supplier.NodeVersionSource).To(Equal("default"))
+					Expect(supplier.NPMVersion).To(Equal(""))
+					Expect(buffer.String()).To(ContainSubstring("Ignoring the defaults of the platform operator"))
+				})
 			})
 
 			Context("package.json does not exist", func() {
@@ -252,34 +351,77 @@ var _ = Describe("Supply", func() {
 		})
 	})
 
+	requestNode := func(constraint string) {
+		Expect(ioutil.WriteFile(filepath.Join(buildDir, "package.json"), []byte(`{"engines": {"node": "`+constraint+`"}}`), 0644)).To(Succeed())
+	}
+
 	Describe("WarnNodeEngine", func() {
+		var bpDir string
+
+		BeforeEach(func() {
+			bpDir, err = ioutil.TempDir("", "nodejs-buildpack.bp.")
+			Expect(err).To(BeNil())
+			Expect(ioutil.WriteFile(filepath.Join(bpDir, "manifest.yml"), []byte("dependency_deprecation_dates:\n- version_line: 6.x\n  name: node\n  date: 2019-04-30\n"), 0644)).To(Succeed())
+			mockManifest.EXPECT().RootDir().Return(bpDir).AnyTimes()
+			mockManifest.EXPECT().AllDependencyVersions("node").Return([]string{"6.14.4", "8.11.4", "10.16.3"}).AnyTimes()
+			mockManifest.EXPECT().DefaultVersion("node").Return(libbuildpack.Dependency{Name: "node", Version: "10.16.3"}, nil).AnyTimes()
+		})
+
+		AfterEach(func() {
+			Expect(os.RemoveAll(bpDir)).To(Succeed())
+		})
+
 		Context("node version not specified", func() {
-			It("warns that node version hasn't been set", func() {
-				supplier.WarnNodeEngine()
-				Expect(buffer.String()).To(ContainSubstring("**WARNING** Node version not specified in package.json. See: http://docs.cloudfoundry.org/buildpacks/node/node-tips.html"))
+			It("suggests the engines stanza of the version the build resolved", func() {
+				_, err = supplier.ResolveNode()
+				Expect(err).To(BeNil())
+				Expect(supplier.WarnNodeEngine()).To(Succeed())
+				Expect(buffer.String()).To(ContainSubstring("**WARNING** Node version not specified in package.json, so the build picked Node.js 10.16.3."))
+				Expect(buffer.String()).To(ContainSubstring(`"engines": {"node": "10.x"}`))
+			})
+		})
+
+		Context("node version from the operator defaults", func() {
+			It("resolves the default of the operator and names it", func() {
+				supplier.NodeVersion = "8.x"
+				supplier.NodeVersionSource = "operator defaults"
+				dep, err := supplier.ResolveNode()
+				Expect(err).To(BeNil())
+				Expect(dep.Version).To(Equal("8.11.4"))
+				Expect(supplier.WarnNodeEngine()).To(Succeed())
+				Expect(buffer.String()).To(ContainSubstring("**WARNING** Node version not specified in package.json, so the build picked Node.js 8.11.4 from the operator defaults."))
 			})
 		})
 
 		Context("node version is *", func() {
 			It("warns that the node semver is dangerous", func() {
-				supplier.NodeVersion = "*"
-				supplier.WarnNodeEngine()
-				Expect(buffer.String()).To(ContainSubstring("**WARNING** Dangerous semver range (*) in engines.node. See: http://docs.cloudfoundry.org/buildpacks/node/node-tips.html"))
+				requestNode("*")
+				_, err = supplier.ResolveNode()
+				Expect(err).To(BeNil())
+				Expect(supplier.WarnNodeEngine()).To(Succeed())
+				Expect(buffer.String()).To(ContainSubstring("**WARNING** Dangerous semver range (*) in engines.node: any version matches"))
+				Expect(buffer.String()).To(ContainSubstring(`"engines": {"node": "10.x"}`))
 			})
 		})
 
-		Context("node version is >x", func() {
-			It("warns that the node semver is dangerous", func() {
-				supplier.NodeVersion = ">5"
-				supplier.WarnNodeEngine()
-				Expect(buffer.String()).To(ContainSubstring("**WARNING** Dangerous semver range (>) in engines.node. See: http://docs.cloudfoundry.org/buildpacks/node/node-tips.html"))
+		Context("node version pins an unmaintained major", func() {
+			It("warns once and suggests the nearest maintained LTS", func() {
+				requestNode("6.x")
+				_, err = supplier.ResolveNode()
+				Expect(err).To(BeNil())
+				Expect(supplier.WarnNodeEngine()).To(Succeed())
+				Expect(strings.Count(buffer.String(), "**WARNING**")).To(Equal(1))
+				Expect(buffer.String()).To(ContainSubstring("Node.js 6, which engines.node resolves to, is no longer maintained. Node.js 8 is the nearest maintained LTS in this buildpack."))
+				Expect(buffer.String()).To(ContainSubstring(`"engines": {"node": "8.x"}`))
 			})
 		})
 
-		Context("node version is 'safe' semver", func() {
-			It("does not log anything", func() {
-				supplier.NodeVersion = "~>6"
-				supplier.WarnNodeEngine()
+		Context("node version is pinned to a maintained major", func() {
+			It("does not warn", func() {
+				requestNode("10.x")
+				_, err = supplier.ResolveNode()
+				Expect(err).To(BeNil())
+				Expect(supplier.WarnNodeEngine()).To(Succeed())
 				Expect(buffer.String()).To(Equal(""))
 			})
 		})
@@ -288,15 +430,22 @@ var _ = Describe("Supply", func() {
 	Describe("InstallNode", func() {
 		var nodeInstallDir string
 		var nodeTmpDir string
+		var bpDir string
 
 		BeforeEach(func() {
 			nodeInstallDir = filepath.Join(depsDir, depsIdx, "node")
 			nodeTmpDir, err = ioutil.TempDir("", "nodejs-buildpack.temp")
 			Expect(err).To(BeNil())
+			bpDir, err = ioutil.TempDir("", "nodejs-buildpack.bp.")
+			Expect(err).To(BeNil())
+			Expect(ioutil.WriteFile(filepath.Join(bpDir, "manifest.yml"), []byte("dependency_deprecation_dates: []\n"), 0644)).To(Succeed())
+			mockManifest.EXPECT().RootDir().Return(bpDir).AnyTimes()
+			mockManifest.EXPECT().DefaultVersion("node").Return(libbuildpack.Dependency{Name: "node", Version: "6.10.2"}, nil).AnyTimes()
 		})
 
 		AfterEach(func() {
 			Expect(os.RemoveAll(nodeTmpDir)).To(Succeed())
+			Expect(os.RemoveAll(bpDir)).To(Succeed())
 		})
 
 		Context("node version use semver", func() {
@@ -305,61 +454,136 @@ var _ = Describe("Supply", func() {
 				mockManifest.EXPECT().AllDependencyVersions("node").Return(versions)
 			})
 
-			It("installs the correct version from the manifest", func() {
-				dep := libbuildpack.Dependency{Name: "node", Version: "4.8.3"}
+			It("creates a symlink in <depDir>/bin", func() {
+				dep := libbuildpack.Dependency{Name: "node", Version: "6.10.2"}
 				mockInstaller.EXPECT().InstallDependency(dep, nodeTmpDir).Do(installNode).Return(nil)
 
-				supplier.NodeVersion = "~>4"
+				requestNode("6.10.*")
 				err = supplier.InstallNode(nodeTmpDir)
 				Expect(err).To(BeNil())
+
+				link, err := os.Readlink(filepath.Join(depsDir, depsIdx, "bin", "node"))
+				Expect(err).To(BeNil())
+
+				Expect(link).To(Equal("../node/bin/node"))
+
+				link, err = os.Readlink(filepath.Join(depsDir, depsIdx, "bin", "npm"))
+				Expect(err).To(BeNil())
+
+				Expect(link).To(Equal("../node/bin/npm"))
 			})
 
-			It("handles '>=6.11.1 <7.0'", func() {
-				dep := libbuildpack.Dependency{Name: "node", Version: "6.11.1"}
+			It("installs into a versioned dir behind the node symlink", func() {
+				dep := libbuildpack.Dependency{Name: "node", Version: "6.10.2"}
 				mockInstaller.EXPECT().InstallDependency(dep, nodeTmpDir).Do(installNode).Return(nil)
 
-				supplier.NodeVersion = ">=6.11.1 <7.0.0"
-				err = supplier.InstallNode(nodeTmpDir)
+				requestNode("6.10.*")
+				Expect(supplier.InstallNode(nodeTmpDir)).To(Succeed())
+
+				link, err := os.Readlink(nodeInstallDir)
 				Expect(err).To(BeNil())
+				Expect(link).To(Equal("node-v6.10.2"))
+				Expect(filepath.Join(depsDir, depsIdx, "node-v6.10.2", "bin", "node")).To(BeAnExistingFile())
+				Expect(filepath.Join(nodeInstallDir, "bin", "node")).To(BeAnExistingFile())
+				Expect(filepath.Join(depsDir, depsIdx, "node.tmp")).NotTo(BeAnExistingFile())
 			})
 
-			It("handles '>=6.11.1, <7.0'", func() {
-				dep := libbuildpack.Dependency{Name: "node", Version: "6.11.1"}
+			It("replaces the flat node dir of older buildpacks and other versions", func() {
+				Expect(os.MkdirAll(filepath.Join(nodeInstallDir, "lib", "node_modules", "left-over"), 0755)).To(Succeed())
+				Expect(os.MkdirAll(filepath.Join(depsDir, depsIdx, "node-v4.8.2", "bin"), 0755)).To(Succeed())
+				dep := libbuildpack.Dependency{Name: "node", Version: "6.10.2"}
 				mockInstaller.EXPECT().InstallDependency(dep, nodeTmpDir).Do(installNode).Return(nil)
 
-				supplier.NodeVersion = ">=6.11.1, <7.0"
-				err = supplier.InstallNode(nodeTmpDir)
+				requestNode("6.10.*")
+				Expect(supplier.InstallNode(nodeTmpDir)).To(Succeed())
+
+				link, err := os.Readlink(nodeInstallDir)
 				Expect(err).To(BeNil())
+				Expect(link).To(Equal("node-v6.10.2"))
+				Expect(filepath.Join(nodeInstallDir, "lib", "node_modules", "left-over")).NotTo(BeAnExistingFile())
+				Expect(filepath.Join(depsDir, depsIdx, "node-v4.8.2")).NotTo(BeAnExistingFile())
 			})
 
-			It("creates a symlink in <depDir>/bin", func() {
+			It("switches the node symlink on an upgrade", func() {
+				Expect(os.MkdirAll(filepath.Join(depsDir, depsIdx, "node-v4.8.2", "bin"), 0755)).To(Succeed())
+				Expect(os.Symlink("node-v4.8.2", nodeInstallDir)).To(Succeed())
 				dep := libbuildpack.Dependency{Name: "node", Version: "6.10.2"}
 				mockInstaller.EXPECT().InstallDependency(dep, nodeTmpDir).Do(installNode).Return(nil)
 
-				supplier.NodeVersion = "6.10.*"
-				err = supplier.InstallNode(nodeTmpDir)
-				Expect(err).To(BeNil())
+				requestNode("6.10.*")
+				Expect(supplier.InstallNode(nodeTmpDir)).To(Succeed())
 
-				link, err := os.Readlink(filepath.Join(depsDir, depsIdx, "bin", "node"))
+				link, err := os.Readlink(nodeInstallDir)
 				Expect(err).To(BeNil())
+				Expect(link).To(Equal("node-v6.10.2"))
+				Expect(filepath.Join(depsDir, depsIdx, "node-v4.8.2")).NotTo(BeAnExistingFile())
+			})
+		})
 
-				Expect(link).To(Equal("../node/bin/node"))
+		Context("node version is not supported on the stack", func() {
+			var oldStack string
 
-				link, err = os.Readlink(filepath.Join(depsDir, depsIdx, "bin", "npm"))
-				Expect(err).To(BeNil())
+			BeforeEach(func() {
+				oldStack = os.Getenv("CF_STACK")
+				os.Setenv("CF_STACK", "cflinuxfs3")
+				mockManifest.EXPECT().AllDependencyVersions("node").Return([]string{"16.20.2", "20.11.1"})
+			})
 
-				Expect(link).To(Equal("../node/bin/npm"))
+			AfterEach(func() {
+				os.Setenv("CF_STACK", oldStack)
+			})
+
+			It("fails before downloading node", func() {
+				requestNode("20.x")
+				err = supplier.InstallNode(nodeTmpDir)
+				Expect(err).To(MatchError(ContainSubstring("Node.js 20.11.1 (requested: 20.x) is not supported on stack cflinuxfs3")))
+				Expect(err).To(MatchError(ContainSubstring("16.20.2")))
+				Expect(failure.CodeOf(err)).To(Equal(failure.NodeEngineUnresolvable))
+			})
+		})
+
+		Context("no node version matches", func() {
+			It("fails as unresolvable", func() {
+				mockManifest.EXPECT().AllDependencyVersions("node").Return([]string{"6.10.2"})
+
+				requestNode("~99.1.0")
+				err = supplier.InstallNode(nodeTmpDir)
+				Expect(err).NotTo(BeNil())
+				Expect(failure.CodeOf(err)).To(Equal(failure.NodeEngineUnresolvable))
+			})
+		})
+
+		Context("installing node fails", func() {
+			dep := libbuildpack.Dependency{Name: "node", Version: "6.10.2"}
+
+			BeforeEach(func() {
+				mockManifest.EXPECT().AllDependencyVersions("node").Return([]string{"6.10.2"})
+				requestNode("6.10.2")
+			})
+
+			It("fails as a download failure", func() {
+				mockInstaller.EXPECT().InstallDependency(dep, nodeTmpDir).Return(fmt.Errorf("could not download: 503"))
+
+				err = supplier.InstallNode(nodeTmpDir)
+				Expect(err).To(MatchError("could not download: 503"))
+				Expect(failure.CodeOf(err)).To(Equal(failure.DownloadFailed))
+			})
+
+			It("fails as a checksum mismatch", func() {
+				mockInstaller.EXPECT().InstallDependency(dep, nodeTmpDir).Return(fmt.Errorf("dependency sha256 mismatch: expected sha256 abc, actual sha256 def"))
+
+				err = supplier.InstallNode(nodeTmpDir)
+				Expect(err).To(MatchError(ContainSubstring("sha256 mismatch")))
+				Expect(failure.CodeOf(err)).To(Equal(failure.ChecksumMismatch))
 			})
 		})
 
 		Context("node version is unset", func() {
 			It("installs the default version from the manifest", func() {
 				dep := libbuildpack.Dependency{Name: "node", Version: "6.10.2"}
-				mockManifest.EXPECT().DefaultVersion("node").Return(dep, nil)
+				mockManifest.EXPECT().AllDependencyVersions("node").Return([]string{"6.10.2"})
 				mockInstaller.EXPECT().InstallDependency(dep, nodeTmpDir).Do(installNode).Return(nil)
 
-				supplier.NodeVersion = ""
-
 				err = supplier.InstallNode(nodeTmpDir)
 				Expect(err).To(BeNil())
 			})
@@ -371,6 +595,15 @@ var _ = Describe("Supply", func() {
 
 		BeforeEach(func() {
 			yarnInstallDir = filepath.Join(depsDir, depsIdx, "yarn")
+			supplier.PackageManagers = supply.PackageManagers{Yarn: true, YarnReason: "yarn.lock"}
+		})
+
+		It("skips yarn when the app does not need it", func() {
+			supplier.PackageManagers = supply.PackageManagers{YarnReason: "the app uses npm (no yarn.lock or packageManager yarn)"}
+
+			Expect(supplier.InstallYarn()).To(Succeed())
+			Expect(buffer.String()).To(ContainSubstring("Skipping yarn install: the app uses npm (no yarn.lock or packageManager yarn)"))
+			Expect(filepath.Join(depsDir, depsIdx, "yarn")).NotTo(BeAnExistingFile())
 		})
 
 		Context("yarn version is unset", func() {
@@ -387,7 +620,7 @@ var _ = Describe("Supply", func() {
 
 				err = supplier.InstallYarn()
 				Expect(err).To(BeNil())
-				Expect(buffer.String()).To(ContainSubstring("Installed yarn 0.32.5"))
+				Expect(buffer.String()).To(ContainSubstring("Installed yarn 0.32.5 (yarn.lock)"))
 			})
 
 			It("creates a symlink in <depDir>/bin", func() {
@@ -488,6 +721,13 @@ var _ = Describe("Supply", func() {
 				Expect(buffer.String()).To(ContainSubstring("Downloading and installing npm 4.5.6 (replacing version 1.2.3)..."))
 			})
 		})
+
+		It("selects yarn for an app with a yarn.lock", func() {
+			Expect(ioutil.WriteFile(filepath.Join(buildDir, "yarn.lock"), []byte{}, 0644)).To(Succeed())
+
+			Expect(supplier.InstallNPM()).To(Succeed())
+			Expect(supplier.PackageManagers).To(Equal(supply.PackageManagers{NPMReason: "Using default npm version: 1.2.3", Yarn: true, YarnReason: "yarn.lock"}))
+		})
 	})
 
 	Describe("ReadPackageJSON", func() {
@@ -550,12 +790,35 @@ var _ = Describe("Supply", func() {
 				Expect(supplier.ReadPackageJSON()).To(Succeed())
 				Expect(supplier.StartScript).To(Equal("start-my-app"))
 			})
+
+			It("sets HasPackageJSON", func() {
+				Expect(supplier.ReadPackageJSON()).To(Succeed())
+				Expect(supplier.HasPackageJSON).To(BeTrue())
+			})
+
+			It("keeps its parse for finalize", func() {
+				Expect(supplier.ReadPackageJSON()).To(Succeed())
+				Expect(ioutil.WriteFile(filepath.Join(buildDir, "package.json"), []byte(`{}`), 0644)).To(Succeed())
+
+				p, found, err := packagejson.LoadStaged(filepath.Join(depsDir, depsIdx), buildDir)
+				Expect(err).To(BeNil())
+				Expect(found).To(BeTrue())
+				Expect(p.Scripts["start"]).To(Equal("start-my-app"))
+			})
 		})
 
 		Context("package.json does not exist", func() {
 			It("warns user", func() {
 				Expect(supplier.ReadPackageJSON()).To(Succeed())
-				Expect(buffer.String()).To(ContainSubstring("**WARNING** No package.json found"))
+				Expect(buffer.String()).To(ContainSubstring("**WARNING** No package.json found, so no dependencies are installed"))
+				Expect(supplier.HasPackageJSON).To(BeFalse())
+			})
+		})
+
+		Context("package.json is invalid", func() {
+			It("says where", func() {
+				Expect(ioutil.WriteFile(filepath.Join(buildDir, "package.json"), []byte("{\n  \"scripts\": {\"start\": \"node server.js\",}\n}"), 0644)).To(Succeed())
+				Expect(supplier.ReadPackageJSON()).To(MatchError(ContainSubstring("package.json is not valid JSON at line 2, column 41")))
 			})
 		})
 
@@ -663,6 +926,7 @@ var _ = Describe("Supply", func() {
 			Entry("YARN_", "YARN_KEY", "aval", "       YARN_KEY=aval\n"),
 			Entry("NODE_", "NODE_EXCITING", "newval", "       NODE_EXCITING=newval\n"),
 			Entry("NOT_RELEVANT", "NOT_RELEVANT", "anything", ""),
+			Entry("token", "YARN_NPM_AUTH_TOKEN", "s3cr3t", "       YARN_NPM_AUTH_TOKEN=[REDACTED]\n"),
 		)
 
 		It("warns about NODE_ENV override", func() {
@@ -891,6 +1155,44 @@ var _ = Describe("Supply", func() {
 				Expect(supplier.BuildDependencies()).To(Succeed())
 				Expect(buffer.String()).To(ContainSubstring("Running heroku-postbuild (yarn)"))
 			})
+
+			It("passes the dotenv variables to the build scripts only", func() {
+				supplier.PostBuild = "descriptive"
+				supplier.BuildScriptEnv = []string{"API_URL=https://api.example.com"}
+				mockCommand.EXPECT().Run(gomock.Any()).DoAndReturn(func(cmd *exec.Cmd) error {
+					Expect(cmd.Args).To(Equal([]string{"yarn", "run", "heroku-postbuild"}))
+					Expect(cmd.Dir).To(Equal(buildDir))
+					Expect(cmd.Env).To(ContainElement("API_URL=https://api.example.com"))
+					return nil
+				})
+				Expect(supplier.BuildDependencies()).To(Succeed())
+			})
+		})
+
+		Context("using yarn with BP_NODE_WORKSPACE", func() {
+			var oldWorkspace string
+
+			BeforeEach(func() {
+				supplier.UseYarn = true
+				oldWorkspace = os.Getenv("BP_NODE_WORKSPACE")
+				os.Setenv("BP_NODE_WORKSPACE", "@acme/api")
+			})
+
+			AfterEach(func() {
+				os.Setenv("BP_NODE_WORKSPACE", oldWorkspace)
+			})
+
+			It("installs only the workspace without build scripts", func() {
+				mockYarn.EXPECT().BuildWorkspace(buildDir, cacheDir, "@acme/api", false)
+				Expect(supplier.BuildDependencies()).To(Succeed())
+			})
+
+			It("installs devDependencies for build scripts", func() {
+				supplier.PostBuild = "tsc"
+				mockYarn.EXPECT().BuildWorkspace(buildDir, cacheDir, "@acme/api", true)
+				mockCommand.EXPECT().Execute(buildDir, gomock.Any(), gomock.Any(), "yarn", "run", "heroku-postbuild")
+				Expect(supplier.BuildDependencies()).To(Succeed())
+			})
 		})
 
 		Describe("using npm", func() {
@@ -907,10 +1209,79 @@ var _ = Describe("Supply", func() {
 				Expect(supplier.BuildDependencies()).To(Succeed())
 			})
 
-			It("runs npm rebuild, when node_modules exists", func() {
-				supplier.IsVendored = true
-				mockNPM.EXPECT().Rebuild(buildDir).Return(nil)
-				Expect(supplier.BuildDependencies()).To(Succeed())
+			Context("when node_modules exists", func() {
+				var header string
+
+				saveRebuild := func(rebuild *supply.RebuildMetadata) {
+					metadata, err := supply.LoadCacheMetadata(cacheDir)
+					Expect(err).To(BeNil())
+					metadata.Rebuild = rebuild
+					Expect(metadata.Save(cacheDir)).To(Succeed())
+				}
+
+				BeforeEach(func() {
+					supplier.IsVendored = true
+					header = filepath.Join(depsDir, depsIdx, "node", "include", "node", "node_version.h")
+					Expect(os.MkdirAll(filepath.Dir(header), 0755)).To(Succeed())
+					Expect(ioutil.WriteFile(header, []byte("#define NODE_MODULE_VERSION 115\n"), 0644)).To(Succeed())
+					for path, contents := range map[string]string{
+						"bcrypt/package.json":  `{"name": "bcrypt"}`,
+						"bcrypt/binding.gyp":   `{}`,
+						"express/package.json": `{"name": "express"}`,
+					} {
+						Expect(os.MkdirAll(filepath.Dir(filepath.Join(buildDir, "node_modules", path)), 0755)).To(Succeed())
+						Expect(ioutil.WriteFile(filepath.Join(buildDir, "node_modules", path), []byte(contents), 0644)).To(Succeed())
+					}
+				})
+
+				It("rebuilds every package without a record and records the native ones", func() {
+					mockNPM.EXPECT().Rebuild(buildDir, nil).Return(nil)
+					Expect(supplier.BuildDependencies()).To(Succeed())
+					Expect(buffer.String()).To(ContainSubstring("Rebuilding every package, the cache has no record of the packages with native code"))
+
+					metadata, err := supply.LoadCacheMetadata(cacheDir)
+					Expect(err).To(BeNil())
+					Expect(metadata.Rebuild).To(Equal(&supply.RebuildMetadata{ModuleVersion: "115", Packages: []string{"bcrypt"}}))
+				})
+
+				It("rebuilds only the recorded and found native packages for the same ABI", func() {
+					saveRebuild(&supply.RebuildMetadata{ModuleVersion: "115", Packages: []string{"sharp"}, FullSeconds: 75})
+					mockNPM.EXPECT().Rebuild(buildDir, []string{"bcrypt", "sharp"}).Return(nil)
+
+					Expect(supplier.BuildDependencies()).To(Succeed())
+					Expect(buffer.String()).To(ContainSubstring("Rebuilding 2 packages with native code or install scripts instead of every package, the Node.js ABI 115 is unchanged"))
+					Expect(buffer.String()).To(ContainSubstring("the last rebuild of every package took 1m15s"))
+
+					metadata, err := supply.LoadCacheMetadata(cacheDir)
+					Expect(err).To(BeNil())
+					Expect(metadata.Rebuild).To(Equal(&supply.RebuildMetadata{ModuleVersion: "115", Packages: []string{"bcrypt"}, FullSeconds: 75}))
+				})
+
+				It("rebuilds every package when the ABI changed", func() {
+					saveRebuild(&supply.RebuildMetadata{ModuleVersion: "108", Packages: []string{"bcrypt"}})
+					mockNPM.EXPECT().Rebuild(buildDir, nil).Return(nil)
+
+					Expect(supplier.BuildDependencies()).To(Succeed())
+					Expect(buffer.String()).To(ContainSubstring("Rebuilding every package, the Node.js ABI changed from 108 to 115"))
+				})
+
+				It("rebuilds every package when the ABI is unknown", func() {
+					saveRebuild(&supply.RebuildMetadata{ModuleVersion: "115", Packages: []string{"bcrypt"}})
+					Expect(os.Remove(header)).To(Succeed())
+					mockNPM.EXPECT().Rebuild(buildDir, nil).Return(nil)
+
+					Expect(supplier.BuildDependencies()).To(Succeed())
+					Expect(buffer.String()).To(ContainSubstring("Rebuilding every package, the Node.js ABI changed from 115 to unknown"))
+				})
+
+				It("rebuilds every package when the stack changed", func() {
+					saveRebuild(&supply.RebuildMetadata{ModuleVersion: "115", Packages: []string{"bcrypt"}})
+					supplier.StackChanged = true
+					mockNPM.EXPECT().Rebuild(buildDir, nil).Return(nil)
+
+					Expect(supplier.BuildDependencies()).To(Succeed())
+					Expect(buffer.String()).To(ContainSubstring("Rebuilding every package, the stack changed"))
+				})
 			})
 
 			It("runs the prebuild script, when prebuild is specified", func() {
@@ -934,10 +1305,97 @@ var _ = Describe("Supply", func() {
 				Expect(supplier.BuildDependencies()).To(Succeed())
 				Expect(buffer.String()).To(ContainSubstring("Running heroku-postbuild (npm)"))
 			})
+
+			It("fails as an install failure when npm fails", func() {
+				mockNPM.EXPECT().Build(buildDir, cacheDir).Return(fmt.Errorf("npm install exited with 1"))
+
+				err := supplier.BuildDependencies()
+				Expect(err).To(MatchError("npm install exited with 1"))
+				Expect(failure.CodeOf(err)).To(Equal(failure.InstallFailed))
+			})
+
+			It("explains an install which ran out of inodes", func() {
+				supplier.StatFilesystem = func(string) (supply.FilesystemStat, error) {
+					return supply.FilesystemStat{Free: 3000 * 1024 * 1024, FreeInodes: 0, TotalInodes: 262144}, nil
+				}
+				mockNPM.EXPECT().Build(buildDir, cacheDir).Return(fmt.Errorf("npm ERR! code ENOSPC"))
+
+				err := supplier.BuildDependencies()
+				Expect(err).To(MatchError("npm ERR! code ENOSPC\nThe filesystem ran out of inodes (ENOSPC with 3000 MiB free, 0 of 262144 inodes free): node_modules takes an inode per file. Increase the disk quota of the app, which raises the inode limit, or trim dependencies"))
+				Expect(failure.CodeOf(err)).To(Equal(failure.InstallFailed))
+			})
+
+			Context("with the output of the install in the log file", func() {
+				var logfile *os.File
+
+				BeforeEach(func() {
+					supplier.StatFilesystem = func(string) (supply.FilesystemStat, error) {
+						return supply.FilesystemStat{Free: 3000 * 1024 * 1024, FreeInodes: 100, TotalInodes: 262144}, nil
+					}
+					logfile, err = ioutil.TempFile("", "nodejs-buildpack.log")
+					Expect(err).To(BeNil())
+					_, err = logfile.WriteString("       **WARNING** /tmp/app: 100 inodes free, about 40000 needed for the 800 packages of the lockfile\nnode_modules takes an inode per file, the install may fail with ENOSPC with disk space left\n")
+					Expect(err).To(BeNil())
+					supplier.Logfile = logfile
+				})
+
+				AfterEach(func() {
+					Expect(logfile.Close()).To(Succeed())
+					Expect(os.Remove(logfile.Name())).To(Succeed())
+				})
+
+				It("explains an install whose output has ENOSPC", func() {
+					mockNPM.EXPECT().Build(buildDir, cacheDir).DoAndReturn(func(string, string) error {
+						_, err := logfile.WriteString("npm ERR! code ENOSPC\n")
+						Expect(err).To(BeNil())
+						return fmt.Errorf("npm install exited with 1")
+					})
+
+					err := supplier.BuildDependencies()
+					Expect(err).To(MatchError(HavePrefix("npm install exited with 1\nThe filesystem ran out of inodes")))
+				})
+
+				It("does not take the disk space warning of the buildpack for the failure", func() {
+					mockNPM.EXPECT().Build(buildDir, cacheDir).DoAndReturn(func(string, string) error {
+						_, err := logfile.WriteString("npm ERR! code E404\n")
+						Expect(err).To(BeNil())
+						return fmt.Errorf("npm install exited with 1")
+					})
+
+					Expect(supplier.BuildDependencies()).To(MatchError("npm install exited with 1"))
+				})
+			})
+
+			It("fails as a build script failure when a build script fails", func() {
+				supplier.PreBuild = "prescriptive"
+				mockCommand.EXPECT().Execute(buildDir, gomock.Any(), gomock.Any(), "npm", "run", "heroku-prebuild", "--if-present").Return(fmt.Errorf("exit status 2"))
+
+				err := supplier.BuildDependencies()
+				Expect(err).To(MatchError("exit status 2"))
+				Expect(failure.CodeOf(err)).To(Equal(failure.BuildScriptFailed))
+			})
 		})
 	})
 
 	Describe("MoveDependencyArtifacts", func() {
+		var (
+			oldNodePath   string
+			nodePathFound bool
+		)
+
+		BeforeEach(func() {
+			oldNodePath, nodePathFound = os.LookupEnv("NODE_PATH")
+			os.Unsetenv("NODE_PATH")
+		})
+
+		AfterEach(func() {
+			if nodePathFound {
+				os.Setenv("NODE_PATH", oldNodePath)
+			} else {
+				os.Unsetenv("NODE_PATH")
+			}
+		})
+
 		Context("when app is already vendored", func() {
 			BeforeEach(func() {
 				supplier.IsVendored = true
@@ -1168,25 +1626,65 @@ var _ = Describe("Supply", func() {
 			Entry("WEB_CONCURRENCY", "WEB_CONCURRENCY", "1"),
 		)
 
+		Describe("with a build NODE_ENV of its own", func() {
+			var oldEnv map[string]string
+
+			BeforeEach(func() {
+				oldEnv = map[string]string{}
+				for _, key := range []string{"NODE_ENV", "BP_BUILD_NODE_ENV", "BP_RUNTIME_NODE_ENV"} {
+					oldEnv[key] = os.Getenv(key)
+					os.Unsetenv(key)
+				}
+				os.Setenv("BP_BUILD_NODE_ENV", "development")
+			})
+
+			AfterEach(func() {
+				for key, value := range oldEnv {
+					os.Setenv(key, value)
+				}
+			})
+
+			envFile := func(key string) string {
+				contents, err := ioutil.ReadFile(filepath.Join(depsDir, depsIdx, "env", key))
+				Expect(err).To(BeNil())
+				return string(contents)
+			}
+
+			It("stages with the build NODE_ENV and keeps the runtime one", func() {
+				Expect(supplier.CreateDefaultEnv()).To(Succeed())
+				Expect(envFile("NODE_ENV")).To(Equal("development"))
+				Expect(envFile("BP_RUNTIME_NODE_ENV")).To(Equal("production"))
+				Expect(buffer.String()).To(ContainSubstring("NODE_ENV=development (BP_BUILD_NODE_ENV) for the build, NODE_ENV=production (default) at runtime"))
+
+				contents, err := ioutil.ReadFile(filepath.Join(depsDir, depsIdx, "profile.d", "014_nodejs_buildpack_node.sh"))
+				Expect(err).To(BeNil())
+				Expect(string(contents)).To(ContainSubstring("export NODE_ENV=${NODE_ENV:-production}"))
+			})
+
+			It("exports BP_RUNTIME_NODE_ENV for the app", func() {
+				os.Setenv("NODE_ENV", "development")
+				os.Setenv("BP_RUNTIME_NODE_ENV", "production")
+				Expect(supplier.CreateDefaultEnv()).To(Succeed())
+				Expect(filepath.Join(depsDir, depsIdx, "env", "NODE_ENV")).NotTo(BeAnExistingFile())
+				Expect(filepath.Join(depsDir, depsIdx, "env", "BP_RUNTIME_NODE_ENV")).NotTo(BeAnExistingFile())
+
+				contents, err := ioutil.ReadFile(filepath.Join(depsDir, depsIdx, "profile.d", "014_nodejs_buildpack_node.sh"))
+				Expect(err).To(BeNil())
+				Expect(string(contents)).To(ContainSubstring("export NODE_ENV='production'\n"))
+			})
+		})
+
 		It("writes profile.d script for runtime", func() {
 			err = supplier.CreateDefaultEnv()
 			Expect(err).To(BeNil())
 
-			contents, err := ioutil.ReadFile(filepath.Join(depsDir, depsIdx, "profile.d", "node.sh"))
+			contents, err := ioutil.ReadFile(filepath.Join(depsDir, depsIdx, "profile.d", "014_nodejs_buildpack_node.sh"))
 			Expect(err).To(BeNil())
 
 			Expect(string(contents)).To(ContainSubstring("export NODE_HOME=" + filepath.Join("$DEPS_DIR", depsIdx, "node")))
 			Expect(string(contents)).To(ContainSubstring("export NODE_ENV=${NODE_ENV:-production}"))
-			nodePathString := `
-if [ ! -d "$HOME/node_modules" ]; then
-	export NODE_PATH=${NODE_PATH:-"$DEPS_DIR/14/node_modules"}
-	ln -s "$DEPS_DIR/14/node_modules" "$HOME/node_modules"
-else
-	export NODE_PATH=${NODE_PATH:-"$HOME/node_modules"}
-fi
-export PATH=$PATH:"$HOME/bin":$NODE_PATH/.bin
-`
-			Expect(string(contents)).To(ContainSubstring(nodePathString))
+			Expect(string(contents)).To(ContainSubstring(`export PATH=$PATH:"$HOME/bin":"$HOME/node_modules/.bin"`))
+			Expect(string(contents)).NotTo(ContainSubstring("NODE_PATH"))
 		})
 	})
 })
@@ -0,0 +1,5 @@
+#!/bin/sh
+# Hangs like a postinstall script waiting for input it never gets, in a
+# grandchild.
+sh -c 'sleep 600; echo done' &
+wait
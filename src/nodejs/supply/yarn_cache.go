@@ -0,0 +1,115 @@
+package supply
+
+import (
+	"nodejs/yarn"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// YarnCacheMetadata describes the yarn cache in the buildpack cache as the
+// last build left it.
+type YarnCacheMetadata struct {
+	Folder   string `json:"folder"`
+	Size     uint64 `json:"size"`
+	Packages int    `json:"packages"`
+}
+
+// yarnCacheFolder returns the folder of the buildpack cache the yarn install
+// of the app caches its packages in.
+func (s *Supplier) yarnCacheFolder() (string, error) {
+	berry, err := yarn.IsBerryProject(s.Stager.BuildDir())
+	if err != nil {
+		return "", err
+	}
+	if workspace := os.Getenv("BP_NODE_WORKSPACE"); workspace != "" && berry {
+		return yarn.CacheFolder(s.Stager.CacheDir(), workspace), nil
+	}
+	mode, err := yarn.CacheMode()
+	if err != nil {
+		return "", err
+	}
+	return yarn.InstallCacheFolder(s.Stager.CacheDir(), berry, mode), nil
+}
+
+// trackYarnCache notes the packages in the yarn cache before the install.
+// The returned function, called after the install, reports how many of the
+// installed packages came from the cache of the previous build and records
+// the cache in the cache metadata.
+func (s *Supplier) trackYarnCache() (func() error, error) {
+	if !s.UseYarn || s.Stager.CacheDir() == "" {
+		return func() error { return nil }, nil
+	}
+
+	folder, err := s.yarnCacheFolder()
+	if err != nil {
+		return nil, err
+	}
+	before, err := yarn.CachedPackages(folder)
+	if err != nil {
+		return nil, err
+	}
+	start := time.Now()
+
+	return func() error {
+		elapsed := time.Since(start).Round(time.Second)
+		if _, err := os.Stat(folder); os.IsNotExist(err) {
+			return nil
+		}
+		if err := fixCachePermissions(folder); err != nil {
+			return err
+		}
+
+		after, err := yarn.CachedPackages(folder)
+		if err != nil {
+			return err
+		}
+		size, err := dirSize(folder)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(s.Stager.CacheDir(), folder)
+		if err != nil {
+			return err
+		}
+
+		if len(after) > 0 {
+			hits := 0
+			for name := range after {
+				if before[name] {
+					hits++
+				}
+			}
+			s.Log.Info("Installed node modules in %s, %d of %d packages in the yarn cache came from the previous build (%d%%), %d MiB in %s", elapsed, hits, len(after), hits*100/len(after), size/mebibyte, rel)
+		}
+
+		metadata, err := LoadCacheMetadata(s.Stager.CacheDir())
+		if err != nil {
+			return err
+		}
+		metadata.YarnCache = &YarnCacheMetadata{Folder: rel, Size: size, Packages: len(after)}
+		return metadata.Save(s.Stager.CacheDir())
+	}, nil
+}
+
+// fixCachePermissions makes the cache readable and writable by its owner, so
+// that the next build can read the packages and replace stale ones. Yarn
+// keeps the modes of the files in package tarballs, which may be read-only.
+func fixCachePermissions(folder string) error {
+	return filepath.Walk(folder, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return nil
+		}
+		want := info.Mode().Perm() | 0600
+		if info.IsDir() {
+			want |= 0100
+		}
+		if want == info.Mode().Perm() {
+			return nil
+		}
+		return os.Chmod(path, want)
+	})
+}
@@ -0,0 +1,145 @@
+package supply_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"nodejs/supply"
+	"os"
+	"path/filepath"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/cloudfoundry/libbuildpack/ansicleaner"
+	"golang.google.cn/x/mock/gomock"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Yarn cache", func() {
+	var (
+		err      error
+		buildDir string
+		cacheDir string
+		supplier *supply.Supplier
+		buffer   *bytes.Buffer
+		mockCtrl *gomock.Controller
+		mockYarn *MockYarn
+		oldEnv   map[string]string
+	)
+
+	cachePackage := func(folder, name string) {
+		path := filepath.Join(folder, name)
+		Expect(os.MkdirAll(filepath.Dir(path), 0755)).To(Succeed())
+		Expect(ioutil.WriteFile(path, []byte("zip"), 0644)).To(Succeed())
+	}
+
+	BeforeEach(func() {
+		buildDir, err = ioutil.TempDir("", "nodejs-buildpack.build.")
+		Expect(err).To(BeNil())
+		cacheDir, err = ioutil.TempDir("", "nodejs-buildpack.cache.")
+		Expect(err).To(BeNil())
+		Expect(ioutil.WriteFile(filepath.Join(buildDir, "package.json"), []byte(`{}`), 0644)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(buildDir, "yarn.lock"), []byte("__metadata:\n  version: 6\n"), 0644)).To(Succeed())
+
+		oldEnv = map[string]string{}
+		for _, key := range []string{"BP_YARN_CACHE", "BP_NODE_WORKSPACE", "BP_NODE_RUN_SCRIPTS"} {
+			oldEnv[key] = os.Getenv(key)
+			os.Unsetenv(key)
+		}
+
+		buffer = new(bytes.Buffer)
+		logger := libbuildpack.NewLogger(ansicleaner.New(buffer))
+		mockCtrl = gomock.NewController(GinkgoT())
+		mockYarn = NewMockYarn(mockCtrl)
+		supplier = &supply.Supplier{
+			Stager:  libbuildpack.NewStager([]string{buildDir, cacheDir, "", "0"}, logger, &libbuildpack.Manifest{}),
+			Log:     logger,
+			Yarn:    mockYarn,
+			UseYarn: true,
+		}
+	})
+
+	AfterEach(func() {
+		mockCtrl.Finish()
+		for key, value := range oldEnv {
+			os.Setenv(key, value)
+		}
+		Expect(os.RemoveAll(buildDir)).To(Succeed())
+		Expect(os.RemoveAll(cacheDir)).To(Succeed())
+	})
+
+	It("reports the packages the cache of the previous build held and records the cache", func() {
+		folder := filepath.Join(cacheDir, ".cache", "yarn", "berry-global")
+		cachePackage(folder, "cache/left-pad-npm-1.3.0-7d8a0b3a1c.zip")
+		cachePackage(folder, "cache/is-number-npm-6.0.0-1c2d3e4f5a.zip")
+		cachePackage(folder, "cache/is-odd-npm-3.0.1-9f8e7d6c5b.zip")
+		mockYarn.EXPECT().Build(buildDir, cacheDir).DoAndReturn(func(string, string) error {
+			cachePackage(folder, "cache/is-even-npm-1.0.0-0a1b2c3d4e.zip")
+			return nil
+		})
+
+		Expect(supplier.BuildDependencies()).To(Succeed())
+		Expect(buffer.String()).To(ContainSubstring("3 of 4 packages in the yarn cache came from the previous build (75%), 0 MiB in .cache/yarn/berry-global"))
+
+		metadata, err := supply.LoadCacheMetadata(cacheDir)
+		Expect(err).To(BeNil())
+		Expect(metadata.YarnCache).To(Equal(&supply.YarnCacheMetadata{Folder: ".cache/yarn/berry-global", Size: 12, Packages: 4}))
+	})
+
+	It("records the project cache with BP_YARN_CACHE=project", func() {
+		os.Setenv("BP_YARN_CACHE", "project")
+		folder := filepath.Join(cacheDir, ".cache", "yarn", "berry-project")
+		mockYarn.EXPECT().Build(buildDir, cacheDir).DoAndReturn(func(string, string) error {
+			cachePackage(folder, "left-pad-npm-1.3.0-7d8a0b3a1c.zip")
+			return nil
+		})
+
+		Expect(supplier.BuildDependencies()).To(Succeed())
+		Expect(buffer.String()).To(ContainSubstring("0 of 1 packages in the yarn cache came from the previous build (0%)"))
+
+		metadata, err := supply.LoadCacheMetadata(cacheDir)
+		Expect(err).To(BeNil())
+		Expect(metadata.YarnCache.Folder).To(Equal(".cache/yarn/berry-project"))
+	})
+
+	It("records the cache of yarn 1", func() {
+		Expect(ioutil.WriteFile(filepath.Join(buildDir, "yarn.lock"), []byte("# yarn lockfile v1\n"), 0644)).To(Succeed())
+		folder := filepath.Join(cacheDir, ".cache", "yarn")
+		mockYarn.EXPECT().Build(buildDir, cacheDir).DoAndReturn(func(string, string) error {
+			cachePackage(folder, "v6/npm-left-pad-1.3.0-abc/node_modules/left-pad/package.json")
+			return nil
+		})
+
+		Expect(supplier.BuildDependencies()).To(Succeed())
+
+		metadata, err := supply.LoadCacheMetadata(cacheDir)
+		Expect(err).To(BeNil())
+		Expect(metadata.YarnCache).To(Equal(&supply.YarnCacheMetadata{Folder: ".cache/yarn", Size: 3, Packages: 1}))
+	})
+
+	It("makes the cache writable for the next build", func() {
+		folder := filepath.Join(cacheDir, ".cache", "yarn", "berry-global")
+		mockYarn.EXPECT().Build(buildDir, cacheDir).DoAndReturn(func(string, string) error {
+			cachePackage(folder, "cache/left-pad-npm-1.3.0-7d8a0b3a1c.zip")
+			Expect(os.Chmod(filepath.Join(folder, "cache", "left-pad-npm-1.3.0-7d8a0b3a1c.zip"), 0444)).To(Succeed())
+			Expect(os.Chmod(filepath.Join(folder, "cache"), 0555)).To(Succeed())
+			return nil
+		})
+
+		Expect(supplier.BuildDependencies()).To(Succeed())
+
+		info, err := os.Stat(filepath.Join(folder, "cache"))
+		Expect(err).To(BeNil())
+		Expect(info.Mode().Perm()).To(Equal(os.FileMode(0755)))
+		info, err = os.Stat(filepath.Join(folder, "cache", "left-pad-npm-1.3.0-7d8a0b3a1c.zip"))
+		Expect(err).To(BeNil())
+		Expect(info.Mode().Perm()).To(Equal(os.FileMode(0644)))
+	})
+
+	It("leaves the metadata alone when yarn cached nothing", func() {
+		mockYarn.EXPECT().Build(buildDir, cacheDir)
+
+		Expect(supplier.BuildDependencies()).To(Succeed())
+		Expect(supply.LoadCacheMetadata(cacheDir)).To(Equal(supply.CacheMetadata{}))
+		Expect(buffer.String()).NotTo(ContainSubstring("yarn cache"))
+	})
+})
@@ -0,0 +1,59 @@
+package vcap
+
+import (
+	"encoding/json"
+	"os"
+)
+
+// Application holds the fields of VCAP_APPLICATION the buildpack uses. Fields
+// missing from VCAP_APPLICATION are empty.
+type Application struct {
+	Name             string
+	SpaceName        string
+	OrganizationName string
+	Version          string
+}
+
+// ParseApplication parses VCAP_APPLICATION. Older platforms only set name
+// and version, newer ones also application_name and application_version.
+func ParseApplication(data string) (Application, error) {
+	var raw struct {
+		ApplicationName    string `json:"application_name"`
+		Name               string `json:"name"`
+		SpaceName          string `json:"space_name"`
+		OrganizationName   string `json:"organization_name"`
+		ApplicationVersion string `json:"application_version"`
+		Version            string `json:"version"`
+	}
+	if err := json.Unmarshal([]byte(data), &raw); err != nil {
+		return Application{}, err
+	}
+
+	app := Application{
+		Name:             raw.ApplicationName,
+		SpaceName:        raw.SpaceName,
+		OrganizationName: raw.OrganizationName,
+		Version:          raw.ApplicationVersion,
+	}
+	if app.Name == "" {
+		app.Name = raw.Name
+	}
+	if app.Version == "" {
+		app.Version = raw.Version
+	}
+	return app, nil
+}
+
+// LoadApplication parses VCAP_APPLICATION from the environment. It returns
+// false when VCAP_APPLICATION is not set, as on local stagers.
+func LoadApplication() (Application, bool, error) {
+	data, found := os.LookupEnv("VCAP_APPLICATION")
+	if !found || data == "" {
+		return Application{}, false, nil
+	}
+	app, err := ParseApplication(data)
+	if err != nil {
+		return Application{}, false, err
+	}
+	return app, true, nil
+}
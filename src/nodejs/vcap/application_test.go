@@ -0,0 +1,62 @@
+package vcap_test
+
+import (
+	"nodejs/vcap"
+	"os"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Application", func() {
+	DescribeTable("ParseApplication",
+		func(data string, expected vcap.Application) {
+			Expect(vcap.ParseApplication(data)).To(Equal(expected))
+		},
+		Entry("all fields",
+			`{"application_name":"shop","name":"shop","space_name":"prod","organization_name":"acme","application_version":"0c8a","version":"0c8a","limits":{"mem":1024}}`,
+			vcap.Application{Name: "shop", SpaceName: "prod", OrganizationName: "acme", Version: "0c8a"}),
+		Entry("only the old name and version", `{"name":"shop","version":"0c8a"}`, vcap.Application{Name: "shop", Version: "0c8a"}),
+		Entry("no space or organization", `{"application_name":"shop"}`, vcap.Application{Name: "shop"}),
+		Entry("no fields", `{}`, vcap.Application{}),
+		Entry("null fields", `{"application_name":"shop","space_name":null}`, vcap.Application{Name: "shop"}),
+	)
+
+	It("rejects invalid JSON", func() {
+		_, err := vcap.ParseApplication(`{"name":`)
+		Expect(err).To(HaveOccurred())
+	})
+
+	Describe("LoadApplication", func() {
+		var oldVcapApplication string
+		var wasSet bool
+
+		BeforeEach(func() {
+			oldVcapApplication, wasSet = os.LookupEnv("VCAP_APPLICATION")
+		})
+
+		AfterEach(func() {
+			if wasSet {
+				os.Setenv("VCAP_APPLICATION", oldVcapApplication)
+			} else {
+				os.Unsetenv("VCAP_APPLICATION")
+			}
+		})
+
+		It("reads VCAP_APPLICATION", func() {
+			os.Setenv("VCAP_APPLICATION", `{"application_name":"shop"}`)
+			app, found, err := vcap.LoadApplication()
+			Expect(err).To(BeNil())
+			Expect(found).To(BeTrue())
+			Expect(app.Name).To(Equal("shop"))
+		})
+
+		It("returns false without VCAP_APPLICATION", func() {
+			os.Unsetenv("VCAP_APPLICATION")
+			_, found, err := vcap.LoadApplication()
+			Expect(err).To(BeNil())
+			Expect(found).To(BeFalse())
+		})
+	})
+})
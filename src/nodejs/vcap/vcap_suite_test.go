@@ -0,0 +1,13 @@
+package vcap_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestVcap(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Vcap Suite")
+}
@@ -0,0 +1 @@
+console.log(require("left-pad"), require("pad"))
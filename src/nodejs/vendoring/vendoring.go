@@ -0,0 +1,238 @@
+// Package vendoring turns an app which installs its dependencies from the
+// registry into one which installs them offline, for foundations without
+// access to a registry: the tarballs its lockfile resolved are downloaded
+// into the offline mirror of yarn 1, or into a dir which package-lock.json is
+// rewritten to resolve to.
+package vendoring
+
+import (
+	"crypto/sha1"
+	"crypto/sha512"
+	"encoding/base64"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"nodejs/resolved"
+	"nodejs/yarn"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/cloudfoundry/libbuildpack"
+)
+
+// NPMTarballs is the dir of a vendored npm app with the tarballs its
+// lockfile resolves to.
+const NPMTarballs = "npm-tarballs"
+
+var lockfiles = []string{"npm-shrinkwrap.json", "package-lock.json", "yarn.lock"}
+
+// Request is the vendoring of the app in AppDir into OutDir.
+type Request struct {
+	AppDir string
+	// InstalledDir is the app after its install, whose lockfile is vendored
+	// when AppDir has none.
+	InstalledDir string
+	OutDir       string
+}
+
+// Result is what Vendor vendored.
+type Result struct {
+	Lockfile string
+	// Dir has the tarballs, relative to OutDir.
+	Dir      string
+	Tarballs int
+}
+
+// Instructions tells what to do with the vendored app in outDir.
+func (r Result) Instructions(outDir string) string {
+	if r.Lockfile == "yarn.lock" {
+		return fmt.Sprintf("Vendored %d tarballs of yarn.lock into %s\n"+
+			"Push %s, yarn installs offline from %s", r.Tarballs, filepath.Join(outDir, r.Dir), outDir, r.Dir)
+	}
+	return fmt.Sprintf("Vendored %d tarballs of %s into %s, which %s resolves to\n"+
+		"Push %s, npm installs from %s without the registry", r.Tarballs, r.Lockfile, filepath.Join(outDir, r.Dir), r.Lockfile, outDir, r.Dir)
+}
+
+// Vendor copies the app to OutDir, without node_modules, and downloads the
+// registry tarballs of its lockfile with client, checked against their
+// integrity. Git and file dependencies are left as they are.
+func Vendor(client *http.Client, req Request) (Result, error) {
+	if entries, err := ioutil.ReadDir(req.OutDir); err == nil && len(entries) > 0 {
+		return Result{}, fmt.Errorf("%s is not empty", req.OutDir)
+	} else if err != nil && !os.IsNotExist(err) {
+		return Result{}, err
+	}
+	if err := copyApp(req.AppDir, req.OutDir); err != nil {
+		return Result{}, err
+	}
+	if err := copyLockfile(req.InstalledDir, req.OutDir); err != nil {
+		return Result{}, err
+	}
+
+	manifest, err := resolved.FromLockfile(req.OutDir)
+	if err != nil {
+		return Result{}, err
+	}
+	if manifest.Lockfile == "" {
+		return Result{}, fmt.Errorf("the app has no package-lock.json, npm-shrinkwrap.json or yarn.lock to vendor the tarballs of")
+	}
+
+	result := Result{Lockfile: manifest.Lockfile, Dir: NPMTarballs}
+	if manifest.Lockfile == "yarn.lock" {
+		result.Dir = yarn.OfflineMirror
+	}
+	files, urls := map[string]string{}, map[string]string{}
+	for _, tarball := range manifest.Tarballs {
+		if files[tarball.Resolved] != "" {
+			continue
+		}
+		name, err := MirrorName(tarball.Resolved)
+		if err != nil {
+			return Result{}, err
+		}
+		if other, found := urls[name]; found {
+			return Result{}, fmt.Errorf("unable to vendor both %s and %s, as %s", other, tarball.Resolved, name)
+		}
+		urls[name] = tarball.Resolved
+		if err := download(client, tarball, filepath.Join(req.OutDir, result.Dir, name)); err != nil {
+			return Result{}, fmt.Errorf("unable to vendor %s: %s", tarball, err)
+		}
+		files[tarball.Resolved] = name
+		result.Tarballs++
+	}
+
+	if manifest.Lockfile != "yarn.lock" {
+		if err := rewriteLockfile(filepath.Join(req.OutDir, manifest.Lockfile), result.Dir, files); err != nil {
+			return Result{}, err
+		}
+	}
+	return result, nil
+}
+
+// tarballURL is how registries lay out tarball URLs, like
+// /@scope/name/-/name-1.0.0.tgz.
+var tarballURL = regexp.MustCompile(`(?:(@[^/]+)(?:/|%2f|%2F))?[^/]+/(?:-|_attachments)/(?:@[^/]+/)?([^/]+)$`)
+
+// MirrorName is the name yarn 1 looks the tarball of a URL up by in its
+// offline mirror: the name of the tarball, prefixed with the scope of the
+// package.
+func MirrorName(rawURL string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	if match := tarballURL.FindStringSubmatch(u.Path); match != nil {
+		if match[1] != "" {
+			return match[1] + "-" + match[2], nil
+		}
+		return match[2], nil
+	}
+	return path.Base(u.Path), nil
+}
+
+func copyApp(appDir, outDir string) error {
+	return filepath.Walk(appDir, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(appDir, file)
+		if err != nil {
+			return err
+		}
+		if info.IsDir() && (rel == "node_modules" || file == outDir) {
+			return filepath.SkipDir
+		}
+		dest := filepath.Join(outDir, rel)
+		switch {
+		case info.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(file)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, dest)
+		case info.IsDir():
+			return os.MkdirAll(dest, info.Mode().Perm()|0700)
+		case info.Mode().IsRegular():
+			return libbuildpack.CopyFile(file, dest)
+		}
+		return nil
+	})
+}
+
+// copyLockfile copies the lockfile the install wrote in installedDir to
+// outDir, when the app came without one.
+func copyLockfile(installedDir, outDir string) error {
+	if installedDir == "" {
+		return nil
+	}
+	for _, name := range lockfiles {
+		if exists, err := libbuildpack.FileExists(filepath.Join(outDir, name)); err != nil || exists {
+			return err
+		}
+	}
+	for _, name := range lockfiles {
+		source := filepath.Join(installedDir, name)
+		if exists, err := libbuildpack.FileExists(source); err != nil {
+			return err
+		} else if exists {
+			return libbuildpack.CopyFile(source, filepath.Join(outDir, name))
+		}
+	}
+	return nil
+}
+
+func download(client *http.Client, tarball resolved.Tarball, dest string) error {
+	resp, err := client.Get(tarball.Resolved)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s", tarball.Resolved, resp.Status)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+	out, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	hashes := map[string]hash.Hash{"sha512": sha512.New(), "sha1": sha1.New()}
+	_, err = io.Copy(io.MultiWriter(out, hashes["sha512"], hashes["sha1"]), resp.Body)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, sri := range strings.Fields(tarball.Integrity) {
+		parts := strings.SplitN(strings.SplitN(sri, "?", 2)[0], "-", 2)
+		if h, found := hashes[parts[0]]; found && len(parts) == 2 && base64.StdEncoding.EncodeToString(h.Sum(nil)) == parts[1] {
+			return nil
+		}
+	}
+	os.Remove(dest)
+	return fmt.Errorf("%s does not match the integrity %s of the lockfile", tarball.Resolved, tarball.Integrity)
+}
+
+// rewriteLockfile resolves the tarballs of the npm lockfile at path to their
+// files in dir, keeping its formatting.
+func rewriteLockfile(lockfile, dir string, files map[string]string) error {
+	contents, err := ioutil.ReadFile(lockfile)
+	if err != nil {
+		return err
+	}
+	rewritten := string(contents)
+	for resolvedURL, name := range files {
+		rewritten = strings.Replace(rewritten, `"`+resolvedURL+`"`, `"file:`+path.Join(dir, name)+`"`, -1)
+	}
+	return ioutil.WriteFile(lockfile, []byte(rewritten), 0644)
+}
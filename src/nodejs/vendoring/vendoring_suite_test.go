@@ -0,0 +1,13 @@
+package vendoring_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestVendoring(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Vendoring Suite")
+}
@@ -0,0 +1,229 @@
+package vendoring_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"nodejs/npm"
+	"nodejs/vendoring"
+	"nodejs/yarn"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/cloudfoundry/libbuildpack"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Vendor", func() {
+	var (
+		err      error
+		tmpDir   string
+		appDir   string
+		outDir   string
+		server   *httptest.Server
+		requests []string
+		corrupt  bool
+	)
+
+	// fixture copies the app in testdata/name, with its lockfile resolved to
+	// the test registry.
+	fixture := func(name string) {
+		Expect(libbuildpack.CopyDirectory(filepath.Join("testdata", name), appDir)).To(Succeed())
+		for _, lockfile := range []string{"package-lock.json", "yarn.lock"} {
+			file := filepath.Join(appDir, lockfile)
+			if contents, err := ioutil.ReadFile(file); err == nil {
+				Expect(ioutil.WriteFile(file, []byte(strings.Replace(string(contents), "REGISTRY", server.URL, -1)), 0644)).To(Succeed())
+			}
+		}
+	}
+
+	BeforeEach(func() {
+		tmpDir, err = ioutil.TempDir("", "nodejs-buildpack.vendoring.")
+		Expect(err).To(BeNil())
+		appDir = filepath.Join(tmpDir, "app")
+		outDir = filepath.Join(tmpDir, "offline")
+		Expect(os.MkdirAll(appDir, 0755)).To(Succeed())
+
+		requests, corrupt = nil, false
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests = append(requests, r.URL.Path)
+			name := path.Base(r.URL.Path)
+			if strings.HasPrefix(r.URL.Path, "/@corp/") {
+				name = "corp-" + name
+			}
+			if corrupt {
+				w.Write([]byte("not a tarball"))
+				return
+			}
+			http.ServeFile(w, r, filepath.Join("testdata", "registry", name))
+		}))
+	})
+
+	AfterEach(func() {
+		server.Close()
+		Expect(os.RemoveAll(tmpDir)).To(Succeed())
+	})
+
+	Context("an npm app", func() {
+		BeforeEach(func() {
+			fixture("npm")
+			Expect(os.MkdirAll(filepath.Join(appDir, "node_modules", "left-pad"), 0755)).To(Succeed())
+		})
+
+		It("vendors the tarballs and resolves the lockfile to them", func() {
+			result, err := vendoring.Vendor(http.DefaultClient, vendoring.Request{AppDir: appDir, OutDir: outDir})
+			Expect(err).To(BeNil())
+			Expect(result).To(Equal(vendoring.Result{Lockfile: "package-lock.json", Dir: "npm-tarballs", Tarballs: 3}))
+			Expect(requests).To(ConsistOf("/@corp/util/-/util-2.0.0.tgz", "/is-number/-/is-number-7.0.0.tgz", "/left-pad/-/left-pad-1.3.0.tgz"))
+
+			for _, name := range []string{"@corp-util-2.0.0.tgz", "is-number-7.0.0.tgz", "left-pad-1.3.0.tgz"} {
+				Expect(filepath.Join(outDir, "npm-tarballs", name)).To(BeAnExistingFile())
+			}
+			Expect(filepath.Join(outDir, "index.js")).To(BeAnExistingFile())
+			Expect(filepath.Join(outDir, "node_modules")).NotTo(BeAnExistingFile())
+
+			lockfile, err := ioutil.ReadFile(filepath.Join(outDir, "package-lock.json"))
+			Expect(err).To(BeNil())
+			Expect(string(lockfile)).NotTo(ContainSubstring(server.URL))
+			Expect(string(lockfile)).To(ContainSubstring(`"resolved": "file:npm-tarballs/@corp-util-2.0.0.tgz",`))
+			Expect(strings.Count(string(lockfile), `"resolved": "file:npm-tarballs/left-pad-1.3.0.tgz",`)).To(Equal(2))
+		})
+
+		It("stages offline with npm once vendored", func() {
+			if _, err := exec.LookPath("npm"); err != nil {
+				Skip("npm is not installed")
+			}
+			_, err := vendoring.Vendor(http.DefaultClient, vendoring.Request{AppDir: appDir, OutDir: outDir})
+			Expect(err).To(BeNil())
+
+			// The registry is gone, as it is on a foundation without access
+			// to one.
+			server.Close()
+			cacheDir := filepath.Join(tmpDir, "cache")
+			for _, invocation := range (npm.Builder{}).Install(npm.InstallRequest(outDir, cacheDir)) {
+				cmd := exec.Command(invocation.Program, invocation.Args...)
+				cmd.Dir = invocation.Dir
+				cmd.Env = append(os.Environ(), "npm_config_registry="+server.URL+"/", "npm_config_audit=false", "npm_config_fund=false", "npm_config_update_notifier=false")
+				output, err := cmd.CombinedOutput()
+				Expect(err).To(BeNil(), string(output))
+			}
+
+			for _, pkg := range []string{"@corp/util", "is-number", "left-pad", "pad"} {
+				Expect(filepath.Join(outDir, "node_modules", pkg, "package.json")).To(BeAnExistingFile())
+			}
+			output, err := exec.Command("node", filepath.Join(outDir, "index.js")).CombinedOutput()
+			Expect(err).To(BeNil(), string(output))
+			Expect(string(output)).To(Equal("left-pad left-pad\n"))
+		})
+
+		It("fails a tarball which does not match its integrity", func() {
+			corrupt = true
+			_, err := vendoring.Vendor(http.DefaultClient, vendoring.Request{AppDir: appDir, OutDir: outDir})
+			Expect(err).To(MatchError(ContainSubstring("unable to vendor @corp/util@2.0.0: " + server.URL + "/@corp/util/-/util-2.0.0.tgz does not match the integrity sha512-")))
+		})
+
+		It("fails a tarball the registry does not have", func() {
+			Expect(os.Remove(filepath.Join(appDir, "package-lock.json"))).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(appDir, "package-lock.json"), []byte(`{"lockfileVersion": 3, "packages": {"node_modules/gone": {"version": "1.0.0", "resolved": "`+server.URL+`/gone/-/gone-1.0.0.tgz", "integrity": "sha512-AAAA"}}}`), 0644)).To(Succeed())
+			_, err := vendoring.Vendor(http.DefaultClient, vendoring.Request{AppDir: appDir, OutDir: outDir})
+			Expect(err).To(MatchError("unable to vendor gone@1.0.0: " + server.URL + "/gone/-/gone-1.0.0.tgz returned 404 Not Found"))
+		})
+
+		It("vendors the lockfile the install wrote for an app without one", func() {
+			installedDir := filepath.Join(tmpDir, "installed")
+			Expect(os.MkdirAll(installedDir, 0755)).To(Succeed())
+			Expect(os.Rename(filepath.Join(appDir, "package-lock.json"), filepath.Join(installedDir, "package-lock.json"))).To(Succeed())
+
+			result, err := vendoring.Vendor(http.DefaultClient, vendoring.Request{AppDir: appDir, InstalledDir: installedDir, OutDir: outDir})
+			Expect(err).To(BeNil())
+			Expect(result.Tarballs).To(Equal(3))
+			Expect(filepath.Join(appDir, "package-lock.json")).NotTo(BeAnExistingFile())
+		})
+
+		It("refuses an out dir which is not empty", func() {
+			Expect(os.MkdirAll(filepath.Join(outDir, "src"), 0755)).To(Succeed())
+			_, err := vendoring.Vendor(http.DefaultClient, vendoring.Request{AppDir: appDir, OutDir: outDir})
+			Expect(err).To(MatchError(outDir + " is not empty"))
+		})
+
+		It("fails an app without a lockfile", func() {
+			Expect(os.Remove(filepath.Join(appDir, "package-lock.json"))).To(Succeed())
+			_, err := vendoring.Vendor(http.DefaultClient, vendoring.Request{AppDir: appDir, OutDir: outDir})
+			Expect(err).To(MatchError("the app has no package-lock.json, npm-shrinkwrap.json or yarn.lock to vendor the tarballs of"))
+		})
+	})
+
+	Context("a yarn app", func() {
+		BeforeEach(func() {
+			fixture("yarn")
+		})
+
+		It("vendors the tarballs into the offline mirror yarn installs from", func() {
+			result, err := vendoring.Vendor(http.DefaultClient, vendoring.Request{AppDir: appDir, OutDir: outDir})
+			Expect(err).To(BeNil())
+			Expect(result).To(Equal(vendoring.Result{Lockfile: "yarn.lock", Dir: "npm-packages-offline-cache", Tarballs: 3}))
+			for _, name := range []string{"@corp-util-2.0.0.tgz", "is-number-7.0.0.tgz", "left-pad-1.3.0.tgz"} {
+				Expect(filepath.Join(outDir, "npm-packages-offline-cache", name)).To(BeAnExistingFile())
+			}
+			lockfile, err := ioutil.ReadFile(filepath.Join(outDir, "yarn.lock"))
+			Expect(err).To(BeNil())
+			Expect(string(lockfile)).To(ContainSubstring(`resolved "` + server.URL + `/left-pad/-/left-pad-1.3.0.tgz#`))
+
+		})
+
+		It("stages offline with yarn once vendored", func() {
+			if _, err := exec.LookPath("yarn"); err != nil {
+				Skip("yarn is not installed")
+			}
+			_, err := vendoring.Vendor(http.DefaultClient, vendoring.Request{AppDir: appDir, OutDir: outDir})
+			Expect(err).To(BeNil())
+
+			// The registry is gone, as it is on a foundation without access
+			// to one.
+			server.Close()
+			install, err := yarn.InstallRequest(outDir, filepath.Join(tmpDir, "cache"))
+			Expect(err).To(BeNil())
+			Expect(install.Offline).To(BeTrue())
+
+			// yarn config set writes to the .yarnrc of HOME.
+			home := filepath.Join(tmpDir, "home")
+			Expect(os.MkdirAll(home, 0755)).To(Succeed())
+			for _, invocation := range (yarn.Builder{}).Install(install) {
+				cmd := exec.Command(invocation.Program, invocation.Args...)
+				cmd.Dir = invocation.Dir
+				cmd.Env = append(os.Environ(), "HOME="+home, "YARN_CACHE_FOLDER="+filepath.Join(tmpDir, "yarn-cache"))
+				cmd.Env = append(cmd.Env, invocation.Env...)
+				output, err := cmd.CombinedOutput()
+				Expect(err).To(BeNil(), string(output))
+			}
+
+			for _, pkg := range []string{"@corp/util", "is-number", "left-pad"} {
+				Expect(filepath.Join(outDir, "node_modules", pkg, "package.json")).To(BeAnExistingFile())
+			}
+			Expect(requests).To(HaveLen(3))
+		})
+
+		It("tells how to push the vendored app", func() {
+			result, err := vendoring.Vendor(http.DefaultClient, vendoring.Request{AppDir: appDir, OutDir: outDir})
+			Expect(err).To(BeNil())
+			Expect(result.Instructions(outDir)).To(Equal("Vendored 3 tarballs of yarn.lock into " + filepath.Join(outDir, "npm-packages-offline-cache") + "\n" +
+				"Push " + outDir + ", yarn installs offline from npm-packages-offline-cache"))
+		})
+	})
+
+	DescribeTable("MirrorName",
+		func(url, expected string) {
+			Expect(vendoring.MirrorName(url)).To(Equal(expected))
+		},
+		Entry("a package", "https://registry.yarnpkg.com/left-pad/-/left-pad-1.3.0.tgz", "left-pad-1.3.0.tgz"),
+		Entry("a scoped package", "https://registry.npmjs.org/@corp/util/-/util-2.0.0.tgz", "@corp-util-2.0.0.tgz"),
+		Entry("an escaped scope", "https://npm.corp.example/@corp%2futil/-/util-2.0.0.tgz", "@corp-util-2.0.0.tgz"),
+		Entry("a registry below a path", "https://artifacts.corp.example/api/npm/npm/left-pad/-/left-pad-1.3.0.tgz", "left-pad-1.3.0.tgz"),
+		Entry("another URL", "https://codeload.github.com/corp/themes/tar.gz/5b2c8e1", "5b2c8e1"),
+	)
+})
@@ -0,0 +1 @@
+export NODE_HOME="$DEPS_DIR/0/node"
@@ -0,0 +1,255 @@
+// Package verify checks a packaged buildpack before an operator rolls it out:
+// that a cached buildpack holds every dependency of its manifest with the
+// checksum the manifest records, that the default versions resolve on every
+// stack, and that the files the manifest includes are intact.
+package verify
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"nodejs/digest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/cloudfoundry/libbuildpack"
+)
+
+// Check is one check of a buildpack.
+type Check struct {
+	Name string
+	// Detail is what the check found when it passed.
+	Detail string
+	// Err is why the check failed, or nil.
+	Err error
+}
+
+// Report is the checks of a buildpack, in the order they ran.
+type Report struct {
+	Checks []Check
+}
+
+// Failed returns the checks which failed.
+func (r Report) Failed() []Check {
+	var failed []Check
+	for _, check := range r.Checks {
+		if check.Err != nil {
+			failed = append(failed, check)
+		}
+	}
+	return failed
+}
+
+// Write writes a line for each check to w, followed by a summary.
+func (r Report) Write(w io.Writer) error {
+	for _, check := range r.Checks {
+		line := "PASS " + check.Name
+		if check.Err != nil {
+			line = fmt.Sprintf("FAIL %s: %s", check.Name, check.Err)
+		} else if check.Detail != "" {
+			line += ": " + check.Detail
+		}
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+
+	summary := fmt.Sprintf("%d checks passed", len(r.Checks))
+	if failed := len(r.Failed()); failed > 0 {
+		summary = fmt.Sprintf("%d of %d checks failed", failed, len(r.Checks))
+	}
+	_, err := fmt.Fprintln(w, summary)
+	return err
+}
+
+func (r *Report) add(name, detail string, err error) {
+	r.Checks = append(r.Checks, Check{Name: name, Detail: detail, Err: err})
+}
+
+var sha256Pattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// Buildpack checks the buildpack of manifest.
+func Buildpack(manifest *libbuildpack.Manifest) (Report, error) {
+	var included struct {
+		IncludeFiles []string `yaml:"include_files"`
+	}
+	if err := libbuildpack.NewYAML().Load(filepath.Join(manifest.RootDir(), "manifest.yml"), &included); err != nil {
+		return Report{}, err
+	}
+
+	var report Report
+	Dependencies(&report, manifest)
+	DefaultVersions(&report, manifest)
+	IncludedFiles(&report, manifest.RootDir(), included.IncludeFiles)
+	return report, nil
+}
+
+// Dependencies checks that each dependency of manifest is packaged with
+// the sha256 of the manifest when the buildpack is cached, and has a uri
+// and a sha256 to download it with when it isn't.
+func Dependencies(report *Report, manifest *libbuildpack.Manifest) {
+	cached := manifest.IsCached()
+	for _, entry := range manifest.ManifestEntries {
+		name := fmt.Sprintf("dependency %s %s", entry.Dependency.Name, entry.Dependency.Version)
+		if len(entry.CFStacks) > 0 {
+			name += " for " + strings.Join(entry.CFStacks, ", ")
+		}
+
+		switch {
+		case !sha256Pattern.MatchString(entry.SHA256):
+			report.add(name, "", fmt.Errorf("the manifest has %q for its sha256", entry.SHA256))
+		case !cached && entry.URI == "":
+			report.add(name, "", fmt.Errorf("the manifest has no uri for it"))
+		case !cached:
+			report.add(name, "downloaded at staging from "+entry.URI, nil)
+		case entry.File == "":
+			report.add(name, "", fmt.Errorf("the manifest has no file for it in the dependencies of the cached buildpack"))
+		default:
+			report.add(name, entry.File, packagedFile(manifest.RootDir(), entry))
+		}
+	}
+}
+
+// packagedFile checks the file of entry in a cached buildpack against its
+// sha256, like the installer does when it copies it.
+func packagedFile(rootDir string, entry libbuildpack.ManifestEntry) error {
+	path := entry.File
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(rootDir, path)
+	}
+	actual, err := digest.File(path)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("%s is missing", entry.File)
+	} else if err != nil {
+		return err
+	}
+	if actual != entry.SHA256 {
+		return fmt.Errorf("%s does not match its sha256: expected %s, actual %s", entry.File, entry.SHA256, actual)
+	}
+	return nil
+}
+
+// DefaultVersions checks that each default version of manifest resolves to
+// a dependency on each stack which has the dependency, as
+// Manifest.DefaultVersion does for the stack of staging.
+func DefaultVersions(report *Report, manifest *libbuildpack.Manifest) {
+	defaults := map[string]int{}
+	for _, dependency := range manifest.DefaultVersions {
+		defaults[dependency.Name]++
+	}
+
+	for _, dependency := range manifest.DefaultVersions {
+		name := fmt.Sprintf("default version %s %s", dependency.Name, dependency.Version)
+		if defaults[dependency.Name] > 1 {
+			report.add(name, "", fmt.Errorf("the manifest has %d default versions for %s", defaults[dependency.Name], dependency.Name))
+			continue
+		}
+
+		versions := stackVersions(manifest, dependency.Name)
+		if len(versions) == 0 {
+			report.add(name, "", fmt.Errorf("the manifest has no %s dependency", dependency.Name))
+			continue
+		}
+
+		var stacks []string
+		for stack := range versions {
+			stacks = append(stacks, stack)
+		}
+		sort.Strings(stacks)
+
+		var resolved []string
+		var unresolved []string
+		for _, stack := range stacks {
+			version, err := libbuildpack.FindMatchingVersion(dependency.Version, versions[stack])
+			if err != nil {
+				unresolved = append(unresolved, stackName(stack))
+			} else {
+				resolved = append(resolved, fmt.Sprintf("%s on %s", version, stackName(stack)))
+			}
+		}
+		if len(unresolved) > 0 {
+			report.add(name, "", fmt.Errorf("no %s dependency matches it on %s", dependency.Name, strings.Join(unresolved, ", ")))
+		} else {
+			report.add(name, "resolves to "+strings.Join(resolved, ", "), nil)
+		}
+	}
+}
+
+// stackVersions returns the versions of the dependency name by the stacks
+// they are for. A manifest for a single stack lists no stacks with its
+// dependencies, which are all for its stack.
+func stackVersions(manifest *libbuildpack.Manifest, name string) map[string][]string {
+	versions := map[string][]string{}
+	for _, entry := range manifest.ManifestEntries {
+		if entry.Dependency.Name != name {
+			continue
+		}
+		stacks := entry.CFStacks
+		if manifest.Stack != "" {
+			stacks = []string{manifest.Stack}
+		}
+		for _, stack := range stacks {
+			versions[stack] = append(versions[stack], entry.Dependency.Version)
+		}
+	}
+	return versions
+}
+
+func stackName(stack string) string {
+	if stack == "" {
+		return "any stack"
+	}
+	return stack
+}
+
+// IncludedFiles checks the files of rootDir the manifest includes: that
+// they exist, that those in bin are executable, and that the profile.d
+// scripts and the JSON and YAML files parse.
+func IncludedFiles(report *Report, rootDir string, files []string) {
+	for _, file := range files {
+		report.add("file "+file, "", includedFile(rootDir, file))
+	}
+}
+
+func includedFile(rootDir, file string) error {
+	path := filepath.Join(rootDir, file)
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("it is missing")
+	} else if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("it is not a file")
+	}
+	if strings.HasPrefix(filepath.ToSlash(file), "bin/") && info.Mode().Perm()&0111 == 0 {
+		return fmt.Errorf("it is not executable")
+	}
+
+	switch filepath.Ext(file) {
+	case ".sh":
+		if output, err := exec.Command("bash", "-n", path).CombinedOutput(); err != nil {
+			return fmt.Errorf("it is not a valid shell script: %s", strings.TrimSpace(string(output)))
+		}
+	case ".json":
+		contents, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		var value interface{}
+		if err := json.Unmarshal(contents, &value); err != nil {
+			return fmt.Errorf("it is not valid JSON: %s", err)
+		}
+	case ".yml", ".yaml":
+		var value interface{}
+		if err := libbuildpack.NewYAML().Load(path, &value); err != nil {
+			return fmt.Errorf("it is not valid YAML: %s", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+package verify_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"nodejs/verify"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/cloudfoundry/libbuildpack/ansicleaner"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Buildpack", func() {
+	var (
+		err          error
+		buildpackDir string
+	)
+
+	// testdata/buildpack is a cached buildpack whose node 10.2.0 is
+	// truncated.
+	const corruptedSHA256 = "2298ace0278e0764ef45d77a079f226303322708254ad6df5af1d32cd4a2a79c"
+
+	run := func() verify.Report {
+		logger := libbuildpack.NewLogger(ansicleaner.New(new(bytes.Buffer)))
+		manifest, err := libbuildpack.NewManifest(buildpackDir, logger, time.Now())
+		Expect(err).To(BeNil())
+		report, err := verify.Buildpack(manifest)
+		Expect(err).To(BeNil())
+		return report
+	}
+
+	failures := func(report verify.Report) []string {
+		var lines []string
+		for _, check := range report.Failed() {
+			lines = append(lines, check.Name+": "+check.Err.Error())
+		}
+		return lines
+	}
+
+	replaceInFile := func(file, old, new string) {
+		path := filepath.Join(buildpackDir, file)
+		contents, err := ioutil.ReadFile(path)
+		Expect(err).To(BeNil())
+		Expect(string(contents)).To(ContainSubstring(old))
+		Expect(ioutil.WriteFile(path, []byte(strings.Replace(string(contents), old, new, -1)), 0644)).To(Succeed())
+	}
+
+	BeforeEach(func() {
+		buildpackDir, err = ioutil.TempDir("", "nodejs-buildpack.verify.")
+		Expect(err).To(BeNil())
+		Expect(libbuildpack.CopyDirectory("testdata/buildpack", buildpackDir)).To(Succeed())
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(buildpackDir)).To(Succeed())
+	})
+
+	It("fails the dependency which does not match its sha256", func() {
+		report := run()
+		Expect(failures(report)).To(HaveLen(1))
+		Expect(failures(report)[0]).To(HavePrefix("dependency node 10.2.0 for cflinuxfs3: dependencies/corrupted/node-10.2.0-linux-x64.tgz does not match its sha256: expected " + corruptedSHA256 + ", actual "))
+	})
+
+	It("reports every check", func() {
+		buffer := new(bytes.Buffer)
+		Expect(run().Write(buffer)).To(Succeed())
+
+		lines := strings.Split(strings.TrimSpace(buffer.String()), "\n")
+		Expect(lines).To(HaveLen(9))
+		Expect(lines[0]).To(Equal("PASS dependency node 10.1.0 for cflinuxfs3: dependencies/good/node-10.1.0-linux-x64.tgz"))
+		Expect(lines[1]).To(HavePrefix("FAIL dependency node 10.2.0 for cflinuxfs3: "))
+		Expect(lines[2]).To(Equal("PASS default version node 10.x: resolves to 10.2.0 on cflinuxfs3"))
+		Expect(lines[3:8]).To(Equal([]string{
+			"PASS file VERSION",
+			"PASS file bin/supply",
+			"PASS file manifest.yml",
+			"PASS file package_denylist.json",
+			"PASS file profile/nodejs.sh",
+		}))
+		Expect(lines[8]).To(Equal("1 of 8 checks failed"))
+	})
+
+	It("passes a buildpack whose dependencies match", func() {
+		Expect(os.RemoveAll(filepath.Join(buildpackDir, "dependencies", "corrupted"))).To(Succeed())
+		replaceInFile("manifest.yml", "file: dependencies/corrupted/", "file: dependencies/good/")
+		Expect(ioutil.WriteFile(filepath.Join(buildpackDir, "dependencies", "good", "node-10.2.0-linux-x64.tgz"), []byte("node 10.2.0\n"), 0644)).To(Succeed())
+
+		report := run()
+		Expect(failures(report)).To(BeEmpty())
+
+		buffer := new(bytes.Buffer)
+		Expect(report.Write(buffer)).To(Succeed())
+		Expect(buffer.String()).To(HaveSuffix("\n8 checks passed\n"))
+	})
+
+	It("fails a dependency missing from the cached buildpack", func() {
+		Expect(os.RemoveAll(filepath.Join(buildpackDir, "dependencies", "good"))).To(Succeed())
+
+		Expect(failures(run())).To(ContainElement("dependency node 10.1.0 for cflinuxfs3: dependencies/good/node-10.1.0-linux-x64.tgz is missing"))
+	})
+
+	It("only checks the uri and sha256 of the dependencies of an uncached buildpack", func() {
+		Expect(os.RemoveAll(filepath.Join(buildpackDir, "dependencies"))).To(Succeed())
+
+		report := run()
+		Expect(failures(report)).To(BeEmpty())
+		Expect(report.Checks[1]).To(Equal(verify.Check{
+			Name:   "dependency node 10.2.0 for cflinuxfs3",
+			Detail: "downloaded at staging from https://buildpacks.example.com/dependencies/node/node-10.2.0-linux-x64.tgz",
+		}))
+	})
+
+	It("fails a default version which resolves on no stack", func() {
+		replaceInFile("manifest.yml", "version: 10.x", "version: 12.x")
+
+		Expect(failures(run())).To(ContainElement("default version node 12.x: no node dependency matches it on cflinuxfs3"))
+	})
+
+	It("fails a default version for a dependency the manifest does not have", func() {
+		replaceInFile("manifest.yml", "default_versions:\n", "default_versions:\n- name: yarn\n  version: 1.x\n")
+
+		Expect(failures(run())).To(ContainElement("default version yarn 1.x: the manifest has no yarn dependency"))
+	})
+
+	DescribeTable("included files",
+		func(breakFile func(dir string), expected string) {
+			breakFile(buildpackDir)
+			Expect(failures(run())).To(ContainElement(HavePrefix(expected)))
+		},
+		Entry("missing", func(dir string) {
+			Expect(os.Remove(filepath.Join(dir, "VERSION"))).To(Succeed())
+		}, "file VERSION: it is missing"),
+		Entry("not executable", func(dir string) {
+			Expect(os.Chmod(filepath.Join(dir, "bin", "supply"), 0644)).To(Succeed())
+		}, "file bin/supply: it is not executable"),
+		Entry("an invalid profile.d script", func(dir string) {
+			Expect(ioutil.WriteFile(filepath.Join(dir, "profile", "nodejs.sh"), []byte("if [ -n \"$NODE_HOME\" ]; then\n"), 0644)).To(Succeed())
+		}, "file profile/nodejs.sh: it is not a valid shell script: "),
+		Entry("invalid JSON", func(dir string) {
+			Expect(ioutil.WriteFile(filepath.Join(dir, "package_denylist.json"), []byte(`{"packages": [`), 0644)).To(Succeed())
+		}, "file package_denylist.json: it is not valid JSON: unexpected end of JSON input"),
+	)
+})
@@ -0,0 +1,183 @@
+package versionresolver
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cloudfoundry/libbuildpack"
+)
+
+// Classes of a requested version constraint, by how safe it is to stage
+// with.
+const (
+	// RangeUnspecified is no constraint at all, the buildpack picks.
+	RangeUnspecified = "unspecified"
+	// RangeAny matches every version, like * or latest.
+	RangeAny = "any"
+	// RangeUnbounded has a lower bound only, like >=18.
+	RangeUnbounded = "unbounded"
+	// RangeBounded stays within a known set of majors, like 20.x or ^18.17.
+	RangeBounded = "bounded"
+)
+
+// ClassifyRange returns the class of constraint.
+func ClassifyRange(constraint string) string {
+	constraint = strings.TrimSpace(constraint)
+	switch strings.ToLower(constraint) {
+	case "":
+		return RangeUnspecified
+	case "*", "x", "node", "stable", "latest", "current":
+		return RangeAny
+	}
+	if strings.HasPrefix(constraint, ">") && !strings.Contains(constraint, "<") {
+		return RangeUnbounded
+	}
+	return RangeBounded
+}
+
+// EngineAdvice is what is wrong with the Node.js version an app requested,
+// and the setting which fixes it.
+type EngineAdvice struct {
+	Problems []string
+	// Suggestion sets the version in the source it came from, or in
+	// engines.node when the app requested none, like
+	// "engines": {"node": "22.x"}.
+	Suggestion string
+}
+
+// String returns the advice as one warning, or "" when there is none.
+func (a EngineAdvice) String() string {
+	if len(a.Problems) == 0 {
+		return ""
+	}
+	lines := append([]string{}, a.Problems...)
+	if a.Suggestion != "" {
+		lines = append(lines, "To pin the major version, set:", "  "+a.Suggestion)
+	}
+	return strings.Join(append(lines, "See: "+docsLink), "\n")
+}
+
+// AdviseEngine works out the advice for constraint of source, given the
+// version the build resolved it to and the versions of the manifest. A
+// resolved major in unmaintained is replaced in the suggestion by the
+// nearest maintained LTS major among versions.
+func AdviseEngine(constraint, source, resolved string, versions []string, unmaintained map[int]bool) EngineAdvice {
+	var advice EngineAdvice
+
+	class := ClassifyRange(constraint)
+	if source == SourceDefault || source == SourceOperator {
+		class = RangeUnspecified
+	}
+	switch class {
+	case RangeUnspecified:
+		problem := "Node version not specified in package.json"
+		if resolved != "" {
+			problem += fmt.Sprintf(", so the build picked Node.js %s", resolved)
+			if source == SourceOperator {
+				problem += " from the operator defaults"
+			}
+		}
+		advice.Problems = append(advice.Problems, problem+". A later buildpack may pick another major.")
+	case RangeAny:
+		advice.Problems = append(advice.Problems, fmt.Sprintf("Dangerous semver range (%s) in %s: any version matches, so every new major of Node.js is picked up on the next staging.", constraint, sourceName(source)))
+	case RangeUnbounded:
+		advice.Problems = append(advice.Problems, fmt.Sprintf("Dangerous semver range (%s) in %s: it has no upper bound, so every new major of Node.js is picked up on the next staging.", constraint, sourceName(source)))
+	}
+
+	major, err := versionMajor(resolved)
+	if err != nil {
+		return advice
+	}
+	if unmaintained[major] {
+		problem := fmt.Sprintf("Node.js %d, which %s resolves to, is no longer maintained", major, sourceName(source))
+		if class == RangeUnspecified {
+			problem = fmt.Sprintf("Node.js %d is no longer maintained", major)
+		}
+		if lts, found := nearestMaintainedLTS(major, versions, unmaintained); found {
+			major = lts
+			problem += fmt.Sprintf(". Node.js %d is the nearest maintained LTS in this buildpack.", lts)
+		} else {
+			problem += ", and this buildpack has no maintained LTS."
+		}
+		advice.Problems = append(advice.Problems, problem)
+	}
+	if len(advice.Problems) > 0 {
+		advice.Suggestion = suggestion(source, major)
+	}
+	return advice
+}
+
+func suggestion(source string, major int) string {
+	switch source {
+	case SourceEnv:
+		return fmt.Sprintf("BP_NODE_VERSION=%d.x", major)
+	case SourceNvmrc, SourceNodeVersion:
+		return fmt.Sprintf("%d in %s", major, source)
+	}
+	return fmt.Sprintf(`"engines": {"node": "%d.x"}`, major)
+}
+
+// nearestMaintainedLTS returns the maintained LTS major among versions
+// closest to major, the newer one of two equally close.
+func nearestMaintainedLTS(major int, versions []string, unmaintained map[int]bool) (int, bool) {
+	lts := map[int]bool{}
+	for _, ltsMajor := range LTSCodenames {
+		lts[ltsMajor] = true
+	}
+
+	var candidates []int
+	seen := map[int]bool{}
+	for _, version := range versions {
+		candidate, err := versionMajor(version)
+		if err != nil || seen[candidate] || !lts[candidate] || unmaintained[candidate] {
+			continue
+		}
+		seen[candidate] = true
+		candidates = append(candidates, candidate)
+	}
+	if len(candidates) == 0 {
+		return 0, false
+	}
+
+	sort.Sort(sort.Reverse(sort.IntSlice(candidates)))
+	nearest := candidates[0]
+	for _, candidate := range candidates[1:] {
+		if distance(candidate, major) < distance(nearest, major) {
+			nearest = candidate
+		}
+	}
+	return nearest, true
+}
+
+func distance(a, b int) int {
+	if a > b {
+		return a - b
+	}
+	return b - a
+}
+
+func versionMajor(version string) (int, error) {
+	return strconv.Atoi(strings.SplitN(strings.TrimPrefix(version, "v"), ".", 2)[0])
+}
+
+// UnmaintainedMajors returns the Node.js majors whose deprecation date in
+// the manifest is past at now.
+func UnmaintainedMajors(deprecations []libbuildpack.DeprecationDate, now time.Time) map[int]bool {
+	unmaintained := map[int]bool{}
+	for _, deprecation := range deprecations {
+		if deprecation.Name != "node" {
+			continue
+		}
+		major, err := strconv.Atoi(strings.TrimSuffix(deprecation.VersionLine, ".x"))
+		if err != nil {
+			continue
+		}
+		if date, err := time.Parse("2006-01-02", deprecation.Date); err == nil && !date.After(now) {
+			unmaintained[major] = true
+		}
+	}
+	return unmaintained
+}
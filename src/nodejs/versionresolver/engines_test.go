@@ -0,0 +1,109 @@
+package versionresolver_test
+
+import (
+	"nodejs/versionresolver"
+	"time"
+
+	"github.com/cloudfoundry/libbuildpack"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Engines", func() {
+	var (
+		versions     = []string{"16.20.2", "18.20.4", "20.17.0", "21.7.3", "22.9.0"}
+		unmaintained = map[int]bool{16: true, 21: true}
+	)
+
+	DescribeTable("ClassifyRange",
+		func(constraint, class string) {
+			Expect(versionresolver.ClassifyRange(constraint)).To(Equal(class))
+		},
+		Entry("empty", "", versionresolver.RangeUnspecified),
+		Entry("blank", "  ", versionresolver.RangeUnspecified),
+		Entry("*", "*", versionresolver.RangeAny),
+		Entry("x", "x", versionresolver.RangeAny),
+		Entry("latest", "latest", versionresolver.RangeAny),
+		Entry(">", ">18", versionresolver.RangeUnbounded),
+		Entry(">=", ">=18.0.0", versionresolver.RangeUnbounded),
+		Entry(">= with an upper bound", ">=18 <21", versionresolver.RangeBounded),
+		Entry("a major", "20.x", versionresolver.RangeBounded),
+		Entry("a caret", "^18.17", versionresolver.RangeBounded),
+		Entry("an exact version", "22.9.0", versionresolver.RangeBounded),
+	)
+
+	DescribeTable("AdviseEngine",
+		func(constraint, source, resolved string, problems []string, suggestion string) {
+			advice := versionresolver.AdviseEngine(constraint, source, resolved, versions, unmaintained)
+			Expect(advice.Problems).To(Equal(problems))
+			Expect(advice.Suggestion).To(Equal(suggestion))
+		},
+		Entry("unspecified", "", versionresolver.SourceDefault, "22.9.0",
+			[]string{"Node version not specified in package.json, so the build picked Node.js 22.9.0. A later buildpack may pick another major."},
+			`"engines": {"node": "22.x"}`),
+		Entry("unspecified with an operator default", "20.x", versionresolver.SourceOperator, "20.17.0",
+			[]string{"Node version not specified in package.json, so the build picked Node.js 20.17.0 from the operator defaults. A later buildpack may pick another major."},
+			`"engines": {"node": "20.x"}`),
+		Entry("unspecified before the build resolved a version", "", versionresolver.SourceDefault, "",
+			[]string{"Node version not specified in package.json. A later buildpack may pick another major."},
+			""),
+		Entry("*", "*", versionresolver.SourceEngines, "22.9.0",
+			[]string{"Dangerous semver range (*) in engines.node: any version matches, so every new major of Node.js is picked up on the next staging."},
+			`"engines": {"node": "22.x"}`),
+		Entry(">=", ">=18", versionresolver.SourceEngines, "22.9.0",
+			[]string{"Dangerous semver range (>=18) in engines.node: it has no upper bound, so every new major of Node.js is picked up on the next staging."},
+			`"engines": {"node": "22.x"}`),
+		Entry(">", ">5", versionresolver.SourceEngines, "22.9.0",
+			[]string{"Dangerous semver range (>5) in engines.node: it has no upper bound, so every new major of Node.js is picked up on the next staging."},
+			`"engines": {"node": "22.x"}`),
+		Entry("> in .nvmrc", ">18", versionresolver.SourceNvmrc, "22.9.0",
+			[]string{"Dangerous semver range (>18) in .nvmrc: it has no upper bound, so every new major of Node.js is picked up on the next staging."},
+			"22 in .nvmrc"),
+		Entry("* in BP_NODE_VERSION", "*", versionresolver.SourceEnv, "22.9.0",
+			[]string{"Dangerous semver range (*) in BP_NODE_VERSION: any version matches, so every new major of Node.js is picked up on the next staging."},
+			"BP_NODE_VERSION=22.x"),
+		Entry("a maintained major", "20.x", versionresolver.SourceEngines, "20.17.0",
+			[]string(nil), ""),
+		Entry("a ~> range", "~>20", versionresolver.SourceEngines, "20.17.0",
+			[]string(nil), ""),
+		Entry("a pinned unmaintained LTS", "16.x", versionresolver.SourceEngines, "16.20.2",
+			[]string{"Node.js 16, which engines.node resolves to, is no longer maintained. Node.js 18 is the nearest maintained LTS in this buildpack."},
+			`"engines": {"node": "18.x"}`),
+		Entry("a pinned unmaintained odd major between two LTS", "^21", versionresolver.SourceEngines, "21.7.3",
+			[]string{"Node.js 21, which engines.node resolves to, is no longer maintained. Node.js 22 is the nearest maintained LTS in this buildpack."},
+			`"engines": {"node": "22.x"}`),
+		Entry("a range which resolves to an unmaintained major", ">=16 <17", versionresolver.SourceEngines, "16.20.2",
+			[]string{"Node.js 16, which engines.node resolves to, is no longer maintained. Node.js 18 is the nearest maintained LTS in this buildpack."},
+			`"engines": {"node": "18.x"}`),
+		Entry("an unknown resolved version", "*", versionresolver.SourceEngines, "",
+			[]string{"Dangerous semver range (*) in engines.node: any version matches, so every new major of Node.js is picked up on the next staging."},
+			""),
+	)
+
+	It("keeps the resolved major when no maintained LTS is available", func() {
+		advice := versionresolver.AdviseEngine("16.x", versionresolver.SourceEngines, "16.20.2", []string{"16.20.2", "21.7.3"}, unmaintained)
+		Expect(advice.Problems).To(Equal([]string{"Node.js 16, which engines.node resolves to, is no longer maintained, and this buildpack has no maintained LTS."}))
+		Expect(advice.Suggestion).To(Equal(`"engines": {"node": "16.x"}`))
+	})
+
+	It("renders every problem and the suggestion as one block", func() {
+		advice := versionresolver.AdviseEngine(">=16", versionresolver.SourceEngines, "16.20.2", versions, unmaintained)
+		Expect(advice.String()).To(Equal(`Dangerous semver range (>=16) in engines.node: it has no upper bound, so every new major of Node.js is picked up on the next staging.
+Node.js 16, which engines.node resolves to, is no longer maintained. Node.js 18 is the nearest maintained LTS in this buildpack.
+To pin the major version, set:
+  "engines": {"node": "18.x"}
+See: http://docs.cloudfoundry.org/buildpacks/node/node-tips.html`))
+		Expect(versionresolver.EngineAdvice{}.String()).To(Equal(""))
+	})
+
+	It("counts the majors whose deprecation date is past as unmaintained", func() {
+		now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+		Expect(versionresolver.UnmaintainedMajors([]libbuildpack.DeprecationDate{
+			{Name: "node", VersionLine: "16.x", Date: "2023-09-11"},
+			{Name: "node", VersionLine: "18.x", Date: "2025-04-30"},
+			{Name: "node", VersionLine: "19.x", Date: "2024-06-01"},
+			{Name: "python", VersionLine: "2.x", Date: "2020-01-01"},
+		}, now)).To(Equal(map[int]bool{16: true, 19: true}))
+	})
+})
@@ -0,0 +1,192 @@
+package versionresolver
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"nodejs/packagejson"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/cloudfoundry/libbuildpack"
+)
+
+const docsLink = "http://docs.cloudfoundry.org/buildpacks/node/node-tips.html"
+
+// Sources of the requested Node.js version, in order of precedence.
+const (
+	SourceEnv         = "BP_NODE_VERSION"
+	SourceEngines     = "engines.node"
+	SourceNvmrc       = ".nvmrc"
+	SourceNodeVersion = ".node-version"
+	// SourceOperator is the default of the platform operator, which the
+	// supplier uses in place of SourceDefault when there is one.
+	SourceOperator = "operator defaults"
+	SourceDefault  = "default"
+)
+
+// LTSCodenames maps nvm style lts/<codename> aliases onto their major version.
+var LTSCodenames = map[string]int{
+	"argon":    4,
+	"boron":    6,
+	"carbon":   8,
+	"dubnium":  10,
+	"erbium":   12,
+	"fermium":  14,
+	"gallium":  16,
+	"hydrogen": 18,
+	"iron":     20,
+	"jod":      22,
+}
+
+// Resolution is the Node.js version picked for an app. When nothing was
+// requested Source is SourceDefault and Version the Default of the Resolver,
+// which Resolve leaves empty for callers to use the default version of their
+// manifest. Warnings is the advice of AdviseEngine, one block at most.
+type Resolution struct {
+	Version    string
+	Constraint string
+	Source     string
+	Warnings   []string
+}
+
+// Resolver resolves versions with what it knows of the buildpack besides
+// its versions.
+type Resolver struct {
+	Versions []string
+	// Default is the version picked when the app requests none.
+	Default string
+	// OperatorDefault is the constraint of the platform operator, which
+	// takes precedence over Default.
+	OperatorDefault string
+	// Unmaintained are the majors AdviseEngine warns about, see
+	// UnmaintainedMajors.
+	Unmaintained map[int]bool
+}
+
+// Resolve picks the Node.js version for the app in appDir out of
+// manifestVersions, using the first of BP_NODE_VERSION (from env),
+// engines.node, .nvmrc and .node-version which is set.
+func Resolve(appDir string, manifestVersions []string, env map[string]string) (Resolution, error) {
+	return Resolver{Versions: manifestVersions}.Resolve(appDir, env)
+}
+
+// Resolve picks the Node.js version for the app in appDir like the Resolve
+// function, falling back on the defaults of r.
+func (r Resolver) Resolve(appDir string, env map[string]string) (Resolution, error) {
+	constraint, source, err := Requested(appDir, env)
+	if err != nil {
+		return Resolution{}, err
+	}
+	if source == SourceDefault && r.OperatorDefault != "" {
+		constraint, source = r.OperatorDefault, SourceOperator
+	}
+
+	resolution := Resolution{Constraint: constraint, Source: source, Version: r.Default}
+	if source != SourceDefault {
+		if resolution.Version, err = Match(constraint, r.Versions); err != nil {
+			return resolution, err
+		}
+	}
+
+	if warning := AdviseEngine(constraint, source, resolution.Version, r.Versions, r.Unmaintained).String(); warning != "" {
+		resolution.Warnings = append(resolution.Warnings, warning)
+	}
+	return resolution, nil
+}
+
+// Requested returns the Node.js version constraint requested by the app and
+// where it came from.
+func Requested(appDir string, env map[string]string) (string, string, error) {
+	if version := strings.TrimSpace(env["BP_NODE_VERSION"]); version != "" {
+		return version, SourceEnv, nil
+	}
+
+	p, _, err := packagejson.Load(appDir)
+	if err != nil {
+		return "", "", err
+	}
+	if p.Engines.Node != "" {
+		return p.Engines.Node, SourceEngines, nil
+	}
+
+	for _, source := range []string{SourceNvmrc, SourceNodeVersion} {
+		contents, err := ioutil.ReadFile(filepath.Join(appDir, source))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return "", "", err
+		}
+		if version := firstLine(string(contents)); version != "" {
+			return version, source, nil
+		}
+	}
+
+	return "", SourceDefault, nil
+}
+
+func firstLine(contents string) string {
+	for _, line := range strings.Split(contents, "\n") {
+		if idx := strings.Index(line, "#"); idx >= 0 {
+			line = line[:idx]
+		}
+		if line = strings.TrimSpace(line); line != "" {
+			return line
+		}
+	}
+	return ""
+}
+
+// Match returns the newest of versions satisfying constraint. Besides semver
+// ranges it understands a leading v and the nvm aliases lts/*,
+// lts/<codename>, node, stable and latest.
+func Match(constraint string, versions []string) (string, error) {
+	constraint = strings.TrimSpace(constraint)
+	alias := strings.ToLower(constraint)
+
+	switch {
+	case alias == "node" || alias == "stable" || alias == "latest" || alias == "current":
+		constraint = "*"
+	case alias == "lts/*":
+		return matchLatestLTS(versions)
+	case strings.HasPrefix(alias, "lts/"):
+		major, found := LTSCodenames[strings.TrimPrefix(alias, "lts/")]
+		if !found {
+			return "", fmt.Errorf("unknown Node.js LTS alias %s", constraint)
+		}
+		constraint = fmt.Sprintf("%d.x", major)
+	case strings.HasPrefix(alias, "v") && len(alias) > 1 && alias[1] >= '0' && alias[1] <= '9':
+		constraint = constraint[1:]
+	}
+
+	return libbuildpack.FindMatchingVersion(constraint, versions)
+}
+
+func matchLatestLTS(versions []string) (string, error) {
+	var lts []string
+	for _, version := range versions {
+		major, err := strconv.Atoi(strings.SplitN(version, ".", 2)[0])
+		if err != nil {
+			continue
+		}
+		for _, ltsMajor := range LTSCodenames {
+			if major == ltsMajor {
+				lts = append(lts, version)
+			}
+		}
+	}
+	if len(lts) == 0 {
+		return "", errors.New("no LTS version of Node.js available")
+	}
+	return libbuildpack.FindMatchingVersion("*", lts)
+}
+
+func sourceName(source string) string {
+	if source == "" {
+		return SourceEngines
+	}
+	return source
+}
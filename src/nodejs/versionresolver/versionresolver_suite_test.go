@@ -0,0 +1,13 @@
+package versionresolver_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestVersionresolver(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Versionresolver Suite")
+}
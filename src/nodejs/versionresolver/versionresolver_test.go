@@ -0,0 +1,142 @@
+package versionresolver_test
+
+import (
+	"io/ioutil"
+	"nodejs/versionresolver"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Versionresolver", func() {
+	var versions = []string{"6.10.2", "6.11.1", "4.8.2", "4.8.3", "7.0.0"}
+
+	Describe("Match", func() {
+		It("installs the correct version from the manifest", func() {
+			Expect(versionresolver.Match("~>4", versions)).To(Equal("4.8.3"))
+		})
+
+		It("handles '>=6.11.1 <7.0'", func() {
+			Expect(versionresolver.Match(">=6.11.1 <7.0.0", versions)).To(Equal("6.11.1"))
+		})
+
+		It("handles '>=6.11.1, <7.0'", func() {
+			Expect(versionresolver.Match(">=6.11.1, <7.0", versions)).To(Equal("6.11.1"))
+		})
+
+		It("handles wildcards", func() {
+			Expect(versionresolver.Match("6.10.*", versions)).To(Equal("6.10.2"))
+		})
+
+		It("handles a leading v", func() {
+			Expect(versionresolver.Match("v6.11.1", versions)).To(Equal("6.11.1"))
+		})
+
+		It("handles LTS aliases", func() {
+			Expect(versionresolver.Match("lts/boron", versions)).To(Equal("6.11.1"))
+			Expect(versionresolver.Match("lts/argon", versions)).To(Equal("4.8.3"))
+			Expect(versionresolver.Match("lts/*", versions)).To(Equal("6.11.1"))
+			Expect(versionresolver.Match("node", versions)).To(Equal("7.0.0"))
+		})
+
+		It("fails for unknown aliases and unmatched constraints", func() {
+			_, err := versionresolver.Match("lts/unicorn", versions)
+			Expect(err).To(MatchError("unknown Node.js LTS alias lts/unicorn"))
+
+			_, err = versionresolver.Match("~>9", versions)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("Resolve", func() {
+		var appDir string
+
+		BeforeEach(func() {
+			var err error
+			appDir, err = ioutil.TempDir("", "nodejs-buildpack.app.")
+			Expect(err).To(BeNil())
+		})
+
+		AfterEach(func() {
+			Expect(os.RemoveAll(appDir)).To(Succeed())
+		})
+
+		writeFile := func(name, contents string) {
+			Expect(ioutil.WriteFile(filepath.Join(appDir, name), []byte(contents), 0644)).To(Succeed())
+		}
+
+		It("uses engines.node", func() {
+			writeFile("package.json", `{"engines":{"node":"~>4"}}`)
+			writeFile(".nvmrc", "6\n")
+
+			resolution, err := versionresolver.Resolve(appDir, versions, map[string]string{})
+			Expect(err).To(BeNil())
+			Expect(resolution).To(Equal(versionresolver.Resolution{Version: "4.8.3", Constraint: "~>4", Source: versionresolver.SourceEngines}))
+		})
+
+		It("prefers BP_NODE_VERSION", func() {
+			writeFile("package.json", `{"engines":{"node":"~>4"}}`)
+
+			resolution, err := versionresolver.Resolve(appDir, versions, map[string]string{"BP_NODE_VERSION": "7.x"})
+			Expect(err).To(BeNil())
+			Expect(resolution.Version).To(Equal("7.0.0"))
+			Expect(resolution.Source).To(Equal(versionresolver.SourceEnv))
+		})
+
+		It("falls back to .nvmrc and .node-version", func() {
+			writeFile("package.json", `{}`)
+			writeFile(".node-version", "4.8.2\n")
+
+			resolution, err := versionresolver.Resolve(appDir, versions, nil)
+			Expect(err).To(BeNil())
+			Expect(resolution.Version).To(Equal("4.8.2"))
+			Expect(resolution.Source).To(Equal(versionresolver.SourceNodeVersion))
+
+			writeFile(".nvmrc", "# pinned by ops\nlts/boron\n")
+			resolution, err = versionresolver.Resolve(appDir, versions, nil)
+			Expect(err).To(BeNil())
+			Expect(resolution.Version).To(Equal("6.11.1"))
+			Expect(resolution.Source).To(Equal(versionresolver.SourceNvmrc))
+		})
+
+		It("leaves the version to the manifest default when nothing is requested", func() {
+			resolution, err := versionresolver.Resolve(appDir, versions, nil)
+			Expect(err).To(BeNil())
+			Expect(resolution.Version).To(Equal(""))
+			Expect(resolution.Source).To(Equal(versionresolver.SourceDefault))
+			Expect(resolution.Warnings).To(HaveLen(1))
+		})
+
+		It("falls back on the defaults of a Resolver and warns about unmaintained majors", func() {
+			resolver := versionresolver.Resolver{Versions: versions, Default: "6.11.1", Unmaintained: map[int]bool{4: true}}
+			resolution, err := resolver.Resolve(appDir, nil)
+			Expect(err).To(BeNil())
+			Expect(resolution.Version).To(Equal("6.11.1"))
+			Expect(resolution.Source).To(Equal(versionresolver.SourceDefault))
+
+			resolver.OperatorDefault = "4.x"
+			resolution, err = resolver.Resolve(appDir, nil)
+			Expect(err).To(BeNil())
+			Expect(resolution.Version).To(Equal("4.8.3"))
+			Expect(resolution.Source).To(Equal(versionresolver.SourceOperator))
+			Expect(resolution.Warnings).To(ConsistOf(ContainSubstring("Node.js 4 is no longer maintained")))
+
+			writeFile("package.json", `{"engines":{"node":"7.x"}}`)
+			resolution, err = resolver.Resolve(appDir, nil)
+			Expect(err).To(BeNil())
+			Expect(resolution.Version).To(Equal("7.0.0"))
+			Expect(resolution.Source).To(Equal(versionresolver.SourceEngines))
+		})
+
+		It("returns warnings for dangerous constraints", func() {
+			writeFile("package.json", `{"engines":{"node":">5"}}`)
+
+			resolution, err := versionresolver.Resolve(appDir, versions, nil)
+			Expect(err).To(BeNil())
+			Expect(resolution.Version).To(Equal("7.0.0"))
+			Expect(resolution.Warnings).To(ConsistOf(ContainSubstring("Dangerous semver range (>5) in engines.node")))
+		})
+	})
+})
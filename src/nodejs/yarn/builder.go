@@ -0,0 +1,82 @@
+package yarn
+
+import (
+	"nodejs/pkgmanager"
+	"os"
+	"path/filepath"
+
+	"github.com/cloudfoundry/libbuildpack"
+)
+
+// OfflineMirror is the dir of an app which vendors the tarballs of its
+// dependencies for yarn 1 to install offline.
+const OfflineMirror = "npm-packages-offline-cache"
+
+// Builder builds the yarn commands of staging.
+type Builder struct{}
+
+var _ pkgmanager.Builder = Builder{}
+
+// InstallRequest is the install of the app in buildDir: with yarn 2 and
+// later when yarn.lock was written by it, offline when the app has an
+// offline mirror, and with the cache of BP_YARN_CACHE.
+func InstallRequest(buildDir, cacheDir string) (pkgmanager.Install, error) {
+	berry, err := IsBerryProject(buildDir)
+	if err != nil {
+		return pkgmanager.Install{}, err
+	}
+	return installRequest(buildDir, cacheDir, berry)
+}
+
+func installRequest(buildDir, cacheDir string, berry bool) (pkgmanager.Install, error) {
+	mode, err := CacheMode()
+	if err != nil {
+		return pkgmanager.Install{}, err
+	}
+	offline := false
+	if !berry {
+		if offline, err = libbuildpack.FileExists(filepath.Join(buildDir, OfflineMirror)); err != nil {
+			return pkgmanager.Install{}, err
+		}
+	}
+	return pkgmanager.Install{
+		BuildDir: buildDir,
+		CacheDir: cacheDir,
+		NodeDir:  os.Getenv("NODE_HOME"),
+		Offline:  offline,
+		Berry:    berry,
+		Env:      CacheEnv(cacheDir, berry, mode),
+	}, nil
+}
+
+// Install returns yarn install --immutable for yarn 2 and later. For yarn 1
+// it returns the config of the offline mirror, which is the mirror of the
+// app offline and one in the cache online, and yarn install.
+func (Builder) Install(req pkgmanager.Install) []pkgmanager.Invocation {
+	env := append([]string{"npm_config_nodedir=" + req.NodeDir}, req.Env...)
+	if req.Berry {
+		return []pkgmanager.Invocation{{Program: "yarn", Args: []string{"install", "--immutable"}, Dir: req.BuildDir, Env: env}}
+	}
+
+	mirror, pruning := filepath.Join(req.CacheDir, OfflineMirror), "true"
+	args := []string{"install", "--pure-lockfile", "--ignore-engines", "--modules-folder", filepath.Join(req.BuildDir, "node_modules")}
+	if req.Offline {
+		mirror, pruning = filepath.Join(req.BuildDir, OfflineMirror), "false"
+		args = append(args, "--offline")
+	}
+	return []pkgmanager.Invocation{
+		{Program: "yarn", Args: []string{"config", "set", "yarn-offline-mirror", mirror}, Dir: req.BuildDir, Quiet: true},
+		{Program: "yarn", Args: []string{"config", "set", "yarn-offline-mirror-pruning", pruning}, Dir: req.BuildDir, Quiet: true},
+		{Program: "yarn", Args: args, Dir: req.BuildDir, Env: env},
+	}
+}
+
+// Check returns yarn check, which fails when yarn.lock does not match
+// package.json.
+func (Builder) Check(req pkgmanager.Install) pkgmanager.Invocation {
+	args := []string{"check"}
+	if req.Offline {
+		args = append(args, "--offline")
+	}
+	return pkgmanager.Invocation{Program: "yarn", Args: args, Dir: req.BuildDir, Quiet: true}
+}
@@ -0,0 +1,37 @@
+package yarn_test
+
+import (
+	"nodejs/pkgmanager"
+	"nodejs/yarn"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Builder", func() {
+	DescribeTable("Install and Check",
+		func(req pkgmanager.Install, expected []string) {
+			invocations := yarn.Builder{}.Install(req)
+			if !req.Berry {
+				invocations = append(invocations, yarn.Builder{}.Check(req))
+			}
+			Expect(pkgmanager.RenderPlan(invocations)).To(Equal(expected))
+		},
+		Entry("online", pkgmanager.Install{BuildDir: "/tmp/app", CacheDir: "/tmp/cache", NodeDir: "/deps/0/node", Env: []string{"YARN_CACHE_FOLDER=/tmp/cache/.cache/yarn"}}, []string{
+			"yarn config set yarn-offline-mirror /tmp/cache/npm-packages-offline-cache",
+			"yarn config set yarn-offline-mirror-pruning true",
+			"npm_config_nodedir=/deps/0/node YARN_CACHE_FOLDER=/tmp/cache/.cache/yarn yarn install --pure-lockfile --ignore-engines --modules-folder /tmp/app/node_modules",
+			"yarn check",
+		}),
+		Entry("offline", pkgmanager.Install{BuildDir: "/tmp/app", CacheDir: "/tmp/cache", NodeDir: "/deps/0/node", Offline: true}, []string{
+			"yarn config set yarn-offline-mirror /tmp/app/npm-packages-offline-cache",
+			"yarn config set yarn-offline-mirror-pruning false",
+			"npm_config_nodedir=/deps/0/node yarn install --pure-lockfile --ignore-engines --modules-folder /tmp/app/node_modules --offline",
+			"yarn check --offline",
+		}),
+		Entry("yarn 2 and later", pkgmanager.Install{BuildDir: "/tmp/app", CacheDir: "/tmp/cache", NodeDir: "/deps/0/node", Berry: true, Env: []string{"YARN_CACHE_FOLDER=/tmp/cache/.cache/yarn/berry", "YARN_ENABLE_GLOBAL_CACHE=false"}}, []string{
+			"npm_config_nodedir=/deps/0/node YARN_CACHE_FOLDER=/tmp/cache/.cache/yarn/berry YARN_ENABLE_GLOBAL_CACHE=false yarn install --immutable",
+		}),
+	)
+})
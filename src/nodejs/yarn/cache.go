@@ -0,0 +1,92 @@
+package yarn
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// Where yarn 2+ caches the packages of an app, set with BP_YARN_CACHE. Both
+// caches live in the buildpack cache, which is kept between builds. Yarn 1
+// only has a global cache.
+const (
+	// CacheGlobal shares one cache among every install, in the global
+	// folder of yarn.
+	CacheGlobal = "global"
+	// CacheProject keeps a cache for the app alone, like the .yarn/cache of a
+	// zero-install.
+	CacheProject = "project"
+)
+
+// CacheMode returns BP_YARN_CACHE, which defaults to CacheGlobal.
+func CacheMode() (string, error) {
+	switch mode := os.Getenv("BP_YARN_CACHE"); mode {
+	case "":
+		return CacheGlobal, nil
+	case CacheGlobal, CacheProject:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("BP_YARN_CACHE=%s is not %s or %s", mode, CacheGlobal, CacheProject)
+	}
+}
+
+// IsBerryProject reports whether the yarn.lock of buildDir was written by
+// yarn 2 or later, which adds a __metadata entry.
+func IsBerryProject(buildDir string) (bool, error) {
+	lockfile, err := ioutil.ReadFile(filepath.Join(buildDir, "yarn.lock"))
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return bytes.Contains(lockfile, []byte("\n__metadata:")) || bytes.HasPrefix(lockfile, []byte("__metadata:")), nil
+}
+
+// InstallCacheFolder returns the folder in cacheDir holding the packages
+// yarn installs from.
+func InstallCacheFolder(cacheDir string, berry bool, mode string) string {
+	switch {
+	case !berry:
+		return filepath.Join(cacheDir, ".cache", "yarn")
+	case mode == CacheProject:
+		return filepath.Join(cacheDir, ".cache", "yarn", "berry-project")
+	default:
+		return filepath.Join(cacheDir, ".cache", "yarn", "berry-global")
+	}
+}
+
+// CacheEnv points the cache of a yarn install at InstallCacheFolder. Yarn 2+
+// keeps its global cache in the cache subfolder of YARN_GLOBAL_FOLDER.
+func CacheEnv(cacheDir string, berry bool, mode string) []string {
+	folder := InstallCacheFolder(cacheDir, berry, mode)
+	switch {
+	case !berry:
+		return []string{"YARN_CACHE_FOLDER=" + folder}
+	case mode == CacheProject:
+		return []string{"YARN_ENABLE_GLOBAL_CACHE=false", "YARN_CACHE_FOLDER=" + folder}
+	default:
+		return []string{"YARN_ENABLE_GLOBAL_CACHE=true", "YARN_GLOBAL_FOLDER=" + folder}
+	}
+}
+
+// CachedPackages returns the packages in a cache folder of yarn: the zip
+// archives of yarn 2+ and the unpacked package dirs of yarn 1.
+func CachedPackages(folder string) (map[string]bool, error) {
+	packages := map[string]bool{}
+	for _, pattern := range []string{"*.zip", filepath.Join("cache", "*.zip"), filepath.Join("v*", "npm-*")} {
+		matches, err := filepath.Glob(filepath.Join(folder, pattern))
+		if err != nil {
+			return nil, err
+		}
+		for _, match := range matches {
+			rel, err := filepath.Rel(folder, match)
+			if err != nil {
+				return nil, err
+			}
+			packages[rel] = true
+		}
+	}
+	return packages, nil
+}
@@ -0,0 +1,151 @@
+package yarn_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"nodejs/yarn"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/cloudfoundry/libbuildpack/ansicleaner"
+	"golang.google.cn/x/mock/gomock"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Cache", func() {
+	var (
+		err          error
+		buildDir     string
+		cacheDir     string
+		oldYarnCache string
+	)
+
+	BeforeEach(func() {
+		buildDir, err = ioutil.TempDir("", "nodejs-buildpack.build.")
+		Expect(err).To(BeNil())
+		cacheDir, err = ioutil.TempDir("", "nodejs-buildpack.cache.")
+		Expect(err).To(BeNil())
+
+		oldYarnCache = os.Getenv("BP_YARN_CACHE")
+		os.Unsetenv("BP_YARN_CACHE")
+	})
+
+	AfterEach(func() {
+		os.Setenv("BP_YARN_CACHE", oldYarnCache)
+		Expect(os.RemoveAll(buildDir)).To(Succeed())
+		Expect(os.RemoveAll(cacheDir)).To(Succeed())
+	})
+
+	Describe("CacheMode", func() {
+		It("defaults to the global cache", func() {
+			Expect(yarn.CacheMode()).To(Equal(yarn.CacheGlobal))
+		})
+
+		It("reads BP_YARN_CACHE", func() {
+			os.Setenv("BP_YARN_CACHE", "project")
+			Expect(yarn.CacheMode()).To(Equal(yarn.CacheProject))
+		})
+
+		It("fails for other values", func() {
+			os.Setenv("BP_YARN_CACHE", "local")
+			_, err := yarn.CacheMode()
+			Expect(err).To(MatchError("BP_YARN_CACHE=local is not global or project"))
+		})
+	})
+
+	DescribeTable("CacheEnv",
+		func(berry bool, mode string, expected []string) {
+			Expect(yarn.CacheEnv("/cache", berry, mode)).To(Equal(expected))
+		},
+		Entry("yarn 1", false, yarn.CacheGlobal, []string{"YARN_CACHE_FOLDER=/cache/.cache/yarn"}),
+		Entry("yarn 1 has no project cache", false, yarn.CacheProject, []string{"YARN_CACHE_FOLDER=/cache/.cache/yarn"}),
+		Entry("yarn 2+ global cache", true, yarn.CacheGlobal, []string{"YARN_ENABLE_GLOBAL_CACHE=true", "YARN_GLOBAL_FOLDER=/cache/.cache/yarn/berry-global"}),
+		Entry("yarn 2+ project cache", true, yarn.CacheProject, []string{"YARN_ENABLE_GLOBAL_CACHE=false", "YARN_CACHE_FOLDER=/cache/.cache/yarn/berry-project"}),
+	)
+
+	Describe("IsBerryProject", func() {
+		It("recognizes the lockfile of yarn 2+", func() {
+			Expect(ioutil.WriteFile(filepath.Join(buildDir, "yarn.lock"), []byte("# This file is generated by running \"yarn install\"\n\n__metadata:\n  version: 6\n"), 0644)).To(Succeed())
+			Expect(yarn.IsBerryProject(buildDir)).To(BeTrue())
+		})
+
+		It("is false for the lockfile of yarn 1", func() {
+			Expect(ioutil.WriteFile(filepath.Join(buildDir, "yarn.lock"), []byte("# yarn lockfile v1\n\nleft-pad@^1.3.0:\n  version \"1.3.0\"\n"), 0644)).To(Succeed())
+			Expect(yarn.IsBerryProject(buildDir)).To(BeFalse())
+		})
+
+		It("is false without yarn.lock", func() {
+			Expect(yarn.IsBerryProject(buildDir)).To(BeFalse())
+		})
+	})
+
+	Describe("CachedPackages", func() {
+		It("lists the packages of every layout", func() {
+			for _, path := range []string{"cache/left-pad-npm-1.3.0-7d8a0b3a1c.zip", "is-number-npm-6.0.0-1c2d3e4f5a.zip", "v6/npm-left-pad-1.3.0-abc/node_modules/left-pad/package.json", "v6/.tmp/unrelated"} {
+				Expect(os.MkdirAll(filepath.Dir(filepath.Join(cacheDir, path)), 0755)).To(Succeed())
+				Expect(ioutil.WriteFile(filepath.Join(cacheDir, path), nil, 0644)).To(Succeed())
+			}
+
+			Expect(yarn.CachedPackages(cacheDir)).To(Equal(map[string]bool{
+				"cache/left-pad-npm-1.3.0-7d8a0b3a1c.zip": true,
+				"is-number-npm-6.0.0-1c2d3e4f5a.zip":      true,
+				"v6/npm-left-pad-1.3.0-abc":               true,
+			}))
+		})
+
+		It("is empty for a missing folder", func() {
+			Expect(yarn.CachedPackages(filepath.Join(cacheDir, "missing"))).To(BeEmpty())
+		})
+	})
+
+	Describe("installing a yarn 2+ app", func() {
+		var (
+			y           *yarn.Yarn
+			buffer      *bytes.Buffer
+			mockCtrl    *gomock.Controller
+			mockCommand *MockCommand
+		)
+
+		BeforeEach(func() {
+			Expect(ioutil.WriteFile(filepath.Join(buildDir, "yarn.lock"), []byte("__metadata:\n  version: 6\n"), 0644)).To(Succeed())
+
+			buffer = new(bytes.Buffer)
+			mockCtrl = gomock.NewController(GinkgoT())
+			mockCommand = NewMockCommand(mockCtrl)
+			y = &yarn.Yarn{
+				Log:     libbuildpack.NewLogger(ansicleaner.New(buffer)),
+				Command: mockCommand,
+			}
+		})
+
+		AfterEach(func() {
+			mockCtrl.Finish()
+		})
+
+		It("runs yarn install --immutable with the global folder in the buildpack cache", func() {
+			mockCommand.EXPECT().Run(gomock.Any()).Do(func(cmd *exec.Cmd) {
+				Expect(cmd.Args).To(Equal([]string{"yarn", "install", "--immutable"}))
+				Expect(cmd.Dir).To(Equal(buildDir))
+				Expect(cmd.Env).To(ContainElement("YARN_ENABLE_GLOBAL_CACHE=true"))
+				Expect(cmd.Env).To(ContainElement("YARN_GLOBAL_FOLDER=" + filepath.Join(cacheDir, ".cache", "yarn", "berry-global")))
+			})
+
+			Expect(y.Build(buildDir, cacheDir)).To(Succeed())
+			Expect(buffer.String()).To(ContainSubstring("Installing node modules (yarn.lock, yarn 2+)"))
+		})
+
+		It("keeps a project cache with BP_YARN_CACHE=project", func() {
+			os.Setenv("BP_YARN_CACHE", "project")
+			mockCommand.EXPECT().Run(gomock.Any()).Do(func(cmd *exec.Cmd) {
+				Expect(cmd.Env).To(ContainElement("YARN_ENABLE_GLOBAL_CACHE=false"))
+				Expect(cmd.Env).To(ContainElement("YARN_CACHE_FOLDER=" + filepath.Join(cacheDir, ".cache", "yarn", "berry-project")))
+			})
+
+			Expect(y.Build(buildDir, cacheDir)).To(Succeed())
+		})
+	})
+})
@@ -0,0 +1,8 @@
+/* eslint-disable */
+//prettier-ignore
+module.exports = {
+name: "@yarnpkg/plugin-workspace-tools",
+factory: function (require) {
+return { default: { commands: [] } };
+}
+};
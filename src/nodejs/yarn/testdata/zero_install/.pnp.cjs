@@ -0,0 +1,4 @@
+#!/usr/bin/env node
+/* eslint-disable */
+"use strict";
+// Plug'n'Play runtime stand-in for tests.
@@ -0,0 +1,131 @@
+package yarn
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/cloudfoundry/libbuildpack"
+)
+
+const workspaceToolsPlugin = ".yarn/plugins/@yarnpkg/plugin-workspace-tools.cjs"
+
+// Version returns the version of yarn used in dir, which for yarn 2 and later
+// is the release the app pins with yarnPath in .yarnrc.yml.
+func (y *Yarn) Version(dir string) (string, error) {
+	buffer := new(bytes.Buffer)
+	if err := y.Command.Execute(dir, buffer, buffer, "yarn", "--version"); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(buffer.String()), nil
+}
+
+// IsBerry reports whether version is yarn 2 or later.
+func IsBerry(version string) bool {
+	return majorVersion(version) >= 2
+}
+
+func majorVersion(version string) int {
+	major, err := strconv.Atoi(strings.SplitN(version, ".", 2)[0])
+	if err != nil {
+		return 0
+	}
+	return major
+}
+
+// CacheFolder is the yarn cache of a workspace, kept apart from the caches of
+// the other workspaces deployed from the same monorepo.
+func CacheFolder(cacheDir, workspace string) string {
+	return filepath.Join(cacheDir, ".cache", "yarn", "berry", strings.Replace(workspace, "/", "__", -1))
+}
+
+// BuildWorkspace installs the dependencies for a single workspace of a yarn 2+
+// monorepo. When build scripts need devDependencies every workspace is
+// installed and FocusWorkspace trims the tree down during finalize, otherwise
+// only the production dependencies of workspace are installed. Classic yarn
+// has no focus command and installs every workspace.
+func (y *Yarn) BuildWorkspace(buildDir, cacheDir, workspace string, devDependencies bool) error {
+	version, err := y.Version(buildDir)
+	if err != nil {
+		return err
+	}
+	if !IsBerry(version) {
+		y.Log.Warning("BP_NODE_WORKSPACE needs yarn 2 or later to install a single workspace, installing all workspaces with yarn %s", version)
+		req, err := installRequest(buildDir, cacheDir, false)
+		if err != nil {
+			return err
+		}
+		return y.build(req)
+	}
+
+	if devDependencies {
+		y.Log.Info("Installing node modules of all workspaces for the build scripts (yarn %s)", version)
+		return y.runBerry(buildDir, cacheDir, workspace, "install", "--immutable")
+	}
+	return y.focus(buildDir, cacheDir, workspace, version)
+}
+
+// FocusWorkspace reduces node_modules to the production dependencies of
+// workspace. It does nothing for classic yarn.
+func (y *Yarn) FocusWorkspace(buildDir, cacheDir, workspace string) error {
+	version, err := y.Version(buildDir)
+	if err != nil {
+		return err
+	}
+	if !IsBerry(version) {
+		return nil
+	}
+	return y.focus(buildDir, cacheDir, workspace, version)
+}
+
+func (y *Yarn) focus(buildDir, cacheDir, workspace, version string) error {
+	if err := y.ensureWorkspaceTools(buildDir, version); err != nil {
+		return err
+	}
+	y.Log.Info("Installing production node modules of workspace %s (yarn %s)", workspace, version)
+	return y.runBerry(buildDir, cacheDir, workspace, "workspaces", "focus", workspace, "--production")
+}
+
+// ensureWorkspaceTools imports the workspace-tools plugin, which provides
+// `yarn workspaces focus` before yarn 4, from the copy in the app. Fetching
+// it would make staging depend on the network.
+func (y *Yarn) ensureWorkspaceTools(buildDir, version string) error {
+	if majorVersion(version) >= 4 {
+		return nil
+	}
+
+	yarnrc, err := ioutil.ReadFile(filepath.Join(buildDir, ".yarnrc.yml"))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if bytes.Contains(yarnrc, []byte("plugin-workspace-tools")) {
+		return nil
+	}
+
+	if found, err := libbuildpack.FileExists(filepath.Join(buildDir, workspaceToolsPlugin)); err != nil {
+		return err
+	} else if !found {
+		return fmt.Errorf("yarn %s needs the workspace-tools plugin for BP_NODE_WORKSPACE, but it is not imported in .yarnrc.yml\nRun `yarn plugin import workspace-tools` and commit .yarnrc.yml and .yarn/plugins, or upgrade to yarn 4 which includes it", version)
+	}
+
+	y.Log.Info("Importing the workspace-tools plugin from %s", workspaceToolsPlugin)
+	return y.Command.Execute(buildDir, y.Log.Output(), y.Log.Output(), "yarn", "plugin", "import", "./"+workspaceToolsPlugin)
+}
+
+func (y *Yarn) runBerry(buildDir, cacheDir, workspace string, args ...string) error {
+	cmd := exec.Command("yarn", args...)
+	cmd.Dir = buildDir
+	cmd.Stdout = y.Log.Output()
+	cmd.Stderr = y.Log.Output()
+	cmd.Env = append(os.Environ(),
+		"npm_config_nodedir="+os.Getenv("NODE_HOME"),
+		"YARN_CACHE_FOLDER="+CacheFolder(cacheDir, workspace),
+		"YARN_ENABLE_GLOBAL_CACHE=false",
+	)
+	return y.Command.Run(cmd)
+}
@@ -0,0 +1,145 @@
+package yarn_test
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"nodejs/yarn"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/cloudfoundry/libbuildpack/ansicleaner"
+	"golang.google.cn/x/mock/gomock"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Workspaces", func() {
+	var (
+		err         error
+		buildDir    string
+		cacheDir    string
+		y           *yarn.Yarn
+		buffer      *bytes.Buffer
+		mockCtrl    *gomock.Controller
+		mockCommand *MockCommand
+		oldNodeHome string
+	)
+
+	expectVersion := func(version string) *gomock.Call {
+		return mockCommand.EXPECT().Execute(buildDir, gomock.Any(), gomock.Any(), "yarn", "--version").DoAndReturn(func(_ string, stdout io.Writer, _ io.Writer, _ string, _ ...string) error {
+			io.WriteString(stdout, version+"\n")
+			return nil
+		})
+	}
+
+	expectRun := func(args ...string) *gomock.Call {
+		return mockCommand.EXPECT().Run(gomock.Any()).Do(func(cmd *exec.Cmd) {
+			Expect(cmd.Args).To(Equal(append([]string{"yarn"}, args...)))
+			Expect(cmd.Dir).To(Equal(buildDir))
+			Expect(cmd.Env).To(ContainElement("npm_config_nodedir=test_node_home"))
+			Expect(cmd.Env).To(ContainElement("YARN_CACHE_FOLDER=" + filepath.Join(cacheDir, ".cache", "yarn", "berry", "@acme__api")))
+			Expect(cmd.Env).To(ContainElement("YARN_ENABLE_GLOBAL_CACHE=false"))
+		})
+	}
+
+	BeforeEach(func() {
+		buildDir, err = ioutil.TempDir("", "nodejs-buildpack.build.")
+		Expect(err).To(BeNil())
+		cacheDir, err = ioutil.TempDir("", "nodejs-buildpack.cache.")
+		Expect(err).To(BeNil())
+		Expect(libbuildpack.CopyDirectory(filepath.Join("testdata", "berry_workspace"), buildDir)).To(Succeed())
+
+		oldNodeHome = os.Getenv("NODE_HOME")
+		Expect(os.Setenv("NODE_HOME", "test_node_home")).To(Succeed())
+
+		buffer = new(bytes.Buffer)
+		mockCtrl = gomock.NewController(GinkgoT())
+		mockCommand = NewMockCommand(mockCtrl)
+		y = &yarn.Yarn{
+			Log:     libbuildpack.NewLogger(ansicleaner.New(buffer)),
+			Command: mockCommand,
+		}
+	})
+
+	AfterEach(func() {
+		mockCtrl.Finish()
+		Expect(os.Setenv("NODE_HOME", oldNodeHome)).To(Succeed())
+		Expect(os.RemoveAll(buildDir)).To(Succeed())
+		Expect(os.RemoveAll(cacheDir)).To(Succeed())
+	})
+
+	DescribeTable("IsBerry",
+		func(version string, expected bool) {
+			Expect(yarn.IsBerry(version)).To(Equal(expected))
+		},
+		Entry("classic", "1.22.19", false),
+		Entry("berry", "3.6.4", true),
+		Entry("yarn 4", "4.0.2", true),
+		Entry("unknown", "", false),
+	)
+
+	Describe("BuildWorkspace", func() {
+		It("installs the production dependencies of the workspace", func() {
+			gomock.InOrder(
+				expectVersion("3.6.4"),
+				mockCommand.EXPECT().Execute(buildDir, gomock.Any(), gomock.Any(), "yarn", "plugin", "import", "./.yarn/plugins/@yarnpkg/plugin-workspace-tools.cjs"),
+				expectRun("workspaces", "focus", "@acme/api", "--production"),
+			)
+			Expect(y.BuildWorkspace(buildDir, cacheDir, "@acme/api", false)).To(Succeed())
+			Expect(buffer.String()).To(ContainSubstring("Installing production node modules of workspace @acme/api (yarn 3.6.4)"))
+		})
+
+		It("installs all workspaces when build scripts need devDependencies", func() {
+			gomock.InOrder(
+				expectVersion("3.6.4"),
+				expectRun("install", "--immutable"),
+			)
+			Expect(y.BuildWorkspace(buildDir, cacheDir, "@acme/api", true)).To(Succeed())
+		})
+
+		It("installs all workspaces with classic yarn", func() {
+			expectVersion("1.22.19")
+			mockCommand.EXPECT().Run(gomock.Any()).AnyTimes()
+			mockCommand.EXPECT().Execute(buildDir, gomock.Any(), gomock.Any(), "yarn", "check")
+			Expect(y.BuildWorkspace(buildDir, cacheDir, "@acme/api", false)).To(Succeed())
+			Expect(buffer.String()).To(ContainSubstring("BP_NODE_WORKSPACE needs yarn 2 or later"))
+		})
+	})
+
+	Describe("FocusWorkspace", func() {
+		It("does not import the plugin when .yarnrc.yml has it", func() {
+			Expect(ioutil.WriteFile(filepath.Join(buildDir, ".yarnrc.yml"), []byte("plugins:\n  - path: .yarn/plugins/@yarnpkg/plugin-workspace-tools.cjs\n    spec: \"@yarnpkg/plugin-workspace-tools\"\n"), 0644)).To(Succeed())
+			gomock.InOrder(
+				expectVersion("3.6.4"),
+				expectRun("workspaces", "focus", "@acme/api", "--production"),
+			)
+			Expect(y.FocusWorkspace(buildDir, cacheDir, "@acme/api")).To(Succeed())
+		})
+
+		It("does not need the plugin with yarn 4", func() {
+			Expect(os.RemoveAll(filepath.Join(buildDir, ".yarn"))).To(Succeed())
+			gomock.InOrder(
+				expectVersion("4.0.2"),
+				expectRun("workspaces", "focus", "@acme/api", "--production"),
+			)
+			Expect(y.FocusWorkspace(buildDir, cacheDir, "@acme/api")).To(Succeed())
+		})
+
+		It("explains how to add a missing plugin", func() {
+			Expect(os.RemoveAll(filepath.Join(buildDir, ".yarn"))).To(Succeed())
+			expectVersion("3.6.4")
+			err := y.FocusWorkspace(buildDir, cacheDir, "@acme/api")
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("Run `yarn plugin import workspace-tools` and commit .yarnrc.yml and .yarn/plugins"))
+		})
+
+		It("does nothing with classic yarn", func() {
+			expectVersion("1.22.19")
+			Expect(y.FocusWorkspace(buildDir, cacheDir, "@acme/api")).To(Succeed())
+		})
+	})
+})
@@ -3,6 +3,8 @@ package yarn
 import (
 	"io"
 	"io/ioutil"
+	"nodejs/exitstatus"
+	"nodejs/pkgmanager"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -20,57 +22,43 @@ type Yarn struct {
 	Log     *libbuildpack.Logger
 }
 
+// Build installs the dependencies of buildDir, with yarn 2+ when yarn.lock
+// was written by it.
 func (y *Yarn) Build(buildDir, cacheDir string) error {
-	y.Log.Info("Installing node modules (yarn.lock)")
+	if zero, err := y.zeroInstall(buildDir); zero || err != nil {
+		return err
+	}
 
-	offline, err := libbuildpack.FileExists(filepath.Join(buildDir, "npm-packages-offline-cache"))
+	req, err := InstallRequest(buildDir, cacheDir)
 	if err != nil {
 		return err
 	}
+	return y.build(req)
+}
 
-	installArgs := []string{"install", "--pure-lockfile", "--ignore-engines", "--cache-folder", filepath.Join(cacheDir, ".cache/yarn"), "--modules-folder", filepath.Join(buildDir, "node_modules")}
-	checkArgs := []string{"check"}
+func (y *Yarn) build(req pkgmanager.Install) error {
+	if req.Berry {
+		y.Log.Info("Installing node modules (yarn.lock, yarn 2+)")
+		return y.run(Builder{}.Install(req))
+	}
 
-	yarnConfig := map[string]string{}
-	if offline {
-		yarnOfflineMirror := filepath.Join(buildDir, "npm-packages-offline-cache")
-		y.Log.Info("Found yarn mirror directory %s", yarnOfflineMirror)
+	y.Log.Info("Installing node modules (yarn.lock)")
+	if req.Offline {
+		y.Log.Info("Found yarn mirror directory %s", filepath.Join(req.BuildDir, OfflineMirror))
 		y.Log.Info("Running yarn in offline mode")
-
-		installArgs = append(installArgs, "--offline")
-		checkArgs = append(checkArgs, "--offline")
-
-		yarnConfig["yarn-offline-mirror"] = yarnOfflineMirror
-		yarnConfig["yarn-offline-mirror-pruning"] = "false"
 	} else {
 		y.Log.Info("Running yarn in online mode")
 		y.Log.Info("To run yarn in offline mode, see: https://yarnpkg.com/blog/2016/11/24/offline-mirror")
-
-		yarnConfig["yarn-offline-mirror"] = filepath.Join(cacheDir, "npm-packages-offline-cache")
-		yarnConfig["yarn-offline-mirror-pruning"] = "true"
-	}
-
-	for k, v := range yarnConfig {
-		cmd := exec.Command("yarn", "config", "set", k, v)
-		cmd.Dir = buildDir
-		cmd.Stdout = ioutil.Discard
-		cmd.Stderr = os.Stderr
-		if err := y.Command.Run(cmd); err != nil {
-			return err
-		}
 	}
-
-	cmd := exec.Command("yarn", installArgs...)
-	cmd.Dir = buildDir
-	cmd.Stdout = y.Log.Output()
-	cmd.Stderr = y.Log.Output()
-	cmd.Env = append(os.Environ(), "npm_config_nodedir="+os.Getenv("NODE_HOME"))
-	if err := y.Command.Run(cmd); err != nil {
+	if err := y.run(Builder{}.Install(req)); err != nil {
 		return err
 	}
 
-	if err := y.Command.Execute(buildDir, ioutil.Discard, os.Stderr, "yarn", checkArgs...); err != nil {
-		if _, ok := err.(*exec.ExitError); !ok {
+	check := Builder{}.Check(req)
+	if err := y.Command.Execute(check.Dir, ioutil.Discard, os.Stderr, check.Program, check.Args...); err != nil {
+		switch err.(type) {
+		case *exec.ExitError, *exitstatus.Error:
+		default:
 			return err
 		}
 		y.Log.Warning("yarn.lock is outdated")
@@ -80,3 +68,26 @@ func (y *Yarn) Build(buildDir, cacheDir string) error {
 
 	return nil
 }
+
+// run runs the invocations of Builder in order, adding their env to the
+// environment of staging. The output of quiet ones goes to stderr only.
+func (y *Yarn) run(invocations []pkgmanager.Invocation) error {
+	for _, invocation := range invocations {
+		cmd := exec.Command(invocation.Program, invocation.Args...)
+		cmd.Dir = invocation.Dir
+		if invocation.Quiet {
+			cmd.Stdout = ioutil.Discard
+			cmd.Stderr = os.Stderr
+		} else {
+			cmd.Stdout = y.Log.Output()
+			cmd.Stderr = y.Log.Output()
+		}
+		if len(invocation.Env) > 0 {
+			cmd.Env = append(os.Environ(), invocation.Env...)
+		}
+		if err := y.Command.Run(cmd); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -76,6 +76,7 @@ var _ = Describe("Yarn", func() {
 				default:
 					yarnInstallArgs = cmd.Args
 					Expect(cmd.Env).To(ContainElement("npm_config_nodedir=test_node_home"))
+					Expect(cmd.Env).To(ContainElement("YARN_CACHE_FOLDER=" + filepath.Join(cacheDir, ".cache", "yarn")))
 				}
 				Expect(cmd.Dir).To(Equal(buildDir))
 				return nil
@@ -106,7 +107,7 @@ var _ = Describe("Yarn", func() {
 
 			It("runs yarn install with offline arguments and npm_config_nodedir", func() {
 				Expect(y.Build(buildDir, cacheDir)).To(Succeed())
-				Expect(yarnInstallArgs).To(Equal([]string{"yarn", "install", "--pure-lockfile", "--ignore-engines", "--cache-folder", filepath.Join(cacheDir, ".cache/yarn"), "--modules-folder", filepath.Join(buildDir, "node_modules"), "--offline"}))
+				Expect(yarnInstallArgs).To(Equal([]string{"yarn", "install", "--pure-lockfile", "--ignore-engines", "--modules-folder", filepath.Join(buildDir, "node_modules"), "--offline"}))
 			})
 
 			Context("package.json matches yarn.lock", func() {
@@ -154,7 +155,7 @@ var _ = Describe("Yarn", func() {
 
 			It("runs yarn install", func() {
 				Expect(y.Build(buildDir, cacheDir)).To(Succeed())
-				Expect(yarnInstallArgs).To(Equal([]string{"yarn", "install", "--pure-lockfile", "--ignore-engines", "--cache-folder", filepath.Join(cacheDir, ".cache/yarn"), "--modules-folder", filepath.Join(buildDir, "node_modules")}))
+				Expect(yarnInstallArgs).To(Equal([]string{"yarn", "install", "--pure-lockfile", "--ignore-engines", "--modules-folder", filepath.Join(buildDir, "node_modules")}))
 			})
 
 			Context("package.json matches yarn.lock", func() {
@@ -0,0 +1,117 @@
+package yarn
+
+import (
+	"io/ioutil"
+	"nodejs/pkgmanager"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/cloudfoundry/libbuildpack"
+	yaml "gopkg.in/yaml.v2"
+)
+
+const (
+	// PnPFile is the Plug'n'Play loader yarn 2+ writes instead of
+	// node_modules.
+	PnPFile = ".pnp.cjs"
+	// PnPLoaderFile resolves ES modules through PnPFile, written by yarn 3+.
+	PnPLoaderFile = ".pnp.loader.mjs"
+
+	zeroInstallCache = ".yarn/cache"
+	missingShown     = 10
+)
+
+// ZeroInstall reports whether buildDir is a yarn zero-install, which commits
+// PnPFile and the packages of yarn.lock in .yarn/cache, and lists the locked
+// packages missing from the cache. Rather than running yarn with
+// --check-cache, which hashes every archive, the cache is checked for an
+// archive named after each package with a checksum in yarn.lock. Packages
+// with conditions are left out, as only those for the current platform are
+// cached.
+func ZeroInstall(buildDir string) (bool, []string, error) {
+	for _, name := range []string{PnPFile, "yarn.lock"} {
+		if found, err := libbuildpack.FileExists(filepath.Join(buildDir, name)); err != nil || !found {
+			return false, nil, err
+		}
+	}
+	archives, err := filepath.Glob(filepath.Join(buildDir, zeroInstallCache, "*.zip"))
+	if err != nil || len(archives) == 0 {
+		return false, nil, err
+	}
+	for i, archive := range archives {
+		archives[i] = filepath.Base(archive)
+	}
+	sort.Strings(archives)
+
+	contents, err := ioutil.ReadFile(filepath.Join(buildDir, "yarn.lock"))
+	if err != nil {
+		return false, nil, err
+	}
+	var lock map[string]struct {
+		Resolution string `yaml:"resolution"`
+		Checksum   string `yaml:"checksum"`
+		Conditions string `yaml:"conditions"`
+	}
+	if err := yaml.Unmarshal(contents, &lock); err != nil {
+		return false, nil, err
+	}
+
+	var missing []string
+	for key, entry := range lock {
+		if key == "__metadata" || entry.Checksum == "" || entry.Conditions != "" || entry.Resolution == "" {
+			continue
+		}
+		if !hasArchive(archives, archivePrefix(entry.Resolution)) {
+			missing = append(missing, entry.Resolution)
+		}
+	}
+	sort.Strings(missing)
+	return true, missing, nil
+}
+
+// archivePrefix returns the start of the cache archive name of a locator like
+// @types/node@npm:20.1.0, which yarn names @types-node-npm-20.1.0-<hashes>.zip.
+// The names of other protocols are only matched up to the package name.
+func archivePrefix(locator string) string {
+	name, reference := locator, ""
+	if idx := strings.Index(locator[1:], "@"); idx >= 0 {
+		name, reference = locator[:idx+1], locator[idx+2:]
+	}
+	prefix := strings.Replace(name, "/", "-", -1) + "-"
+	if strings.HasPrefix(reference, "npm:") {
+		prefix += "npm-" + strings.TrimPrefix(reference, "npm:") + "-"
+	}
+	return prefix
+}
+
+// hasArchive reports whether the sorted archives have a name starting with
+// prefix.
+func hasArchive(archives []string, prefix string) bool {
+	idx := sort.SearchStrings(archives, prefix)
+	return idx < len(archives) && strings.HasPrefix(archives[idx], prefix)
+}
+
+// zeroInstall skips the install of a complete zero-install and installs an
+// incomplete one with yarn install --immutable, filling in the cache. It
+// reports whether buildDir is a zero-install.
+func (y *Yarn) zeroInstall(buildDir string) (bool, error) {
+	found, missing, err := ZeroInstall(buildDir)
+	if err != nil || !found {
+		return false, err
+	}
+
+	if len(missing) == 0 {
+		y.Log.Info("Found a yarn zero-install (%s and %s are committed), skipping yarn install", PnPFile, zeroInstallCache)
+		return true, nil
+	}
+
+	shown := missing
+	if len(shown) > missingShown {
+		shown = append(append([]string{}, shown[:missingShown]...), "...")
+	}
+	y.Log.Warning("Found a yarn zero-install, but %s is missing %d packages of yarn.lock:\n  %s\nInstalling with yarn install --immutable instead. Run yarn install and commit %s to skip the install", zeroInstallCache, len(missing), strings.Join(shown, "\n  "), zeroInstallCache)
+
+	return true, y.run(Builder{}.Install(pkgmanager.Install{BuildDir: buildDir, NodeDir: os.Getenv("NODE_HOME"), Berry: true}))
+}
@@ -0,0 +1,120 @@
+package yarn_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"nodejs/yarn"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/cloudfoundry/libbuildpack/ansicleaner"
+	"golang.google.cn/x/mock/gomock"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ZeroInstall", func() {
+	var (
+		err         error
+		buildDir    string
+		cacheDir    string
+		y           *yarn.Yarn
+		buffer      *bytes.Buffer
+		mockCtrl    *gomock.Controller
+		mockCommand *MockCommand
+	)
+
+	copyFixture := func(name string) {
+		Expect(libbuildpack.CopyDirectory(filepath.Join("testdata", name), buildDir)).To(Succeed())
+	}
+
+	BeforeEach(func() {
+		buildDir, err = ioutil.TempDir("", "nodejs-buildpack.build.")
+		Expect(err).To(BeNil())
+		cacheDir, err = ioutil.TempDir("", "nodejs-buildpack.cache.")
+		Expect(err).To(BeNil())
+
+		buffer = new(bytes.Buffer)
+		mockCtrl = gomock.NewController(GinkgoT())
+		mockCommand = NewMockCommand(mockCtrl)
+		y = &yarn.Yarn{
+			Log:     libbuildpack.NewLogger(ansicleaner.New(buffer)),
+			Command: mockCommand,
+		}
+	})
+
+	AfterEach(func() {
+		mockCtrl.Finish()
+		Expect(os.RemoveAll(buildDir)).To(Succeed())
+		Expect(os.RemoveAll(cacheDir)).To(Succeed())
+	})
+
+	Context("with a complete cache", func() {
+		BeforeEach(func() {
+			copyFixture("zero_install")
+		})
+
+		It("finds every package of yarn.lock in the cache", func() {
+			found, missing, err := yarn.ZeroInstall(buildDir)
+			Expect(err).To(BeNil())
+			Expect(found).To(BeTrue())
+			Expect(missing).To(BeEmpty())
+		})
+
+		It("skips the install", func() {
+			Expect(y.Build(buildDir, cacheDir)).To(Succeed())
+			Expect(buffer.String()).To(ContainSubstring("Found a yarn zero-install (.pnp.cjs and .yarn/cache are committed), skipping yarn install"))
+			Expect(buffer.String()).NotTo(ContainSubstring("Installing node modules"))
+		})
+	})
+
+	Context("with an incomplete cache", func() {
+		BeforeEach(func() {
+			copyFixture("zero_install_incomplete")
+		})
+
+		It("lists the packages missing from the cache", func() {
+			found, missing, err := yarn.ZeroInstall(buildDir)
+			Expect(err).To(BeNil())
+			Expect(found).To(BeTrue())
+			Expect(missing).To(Equal([]string{"is-number@npm:6.0.0"}))
+		})
+
+		It("installs with yarn install --immutable and explains why", func() {
+			oldNodeHome := os.Getenv("NODE_HOME")
+			defer os.Setenv("NODE_HOME", oldNodeHome)
+			Expect(os.Setenv("NODE_HOME", "test_node_home")).To(Succeed())
+
+			mockCommand.EXPECT().Run(gomock.Any()).Do(func(cmd *exec.Cmd) {
+				Expect(cmd.Args).To(Equal([]string{"yarn", "install", "--immutable"}))
+				Expect(cmd.Dir).To(Equal(buildDir))
+				Expect(cmd.Env).To(ContainElement("npm_config_nodedir=test_node_home"))
+			})
+
+			Expect(y.Build(buildDir, cacheDir)).To(Succeed())
+			Expect(buffer.String()).To(ContainSubstring("Found a yarn zero-install, but .yarn/cache is missing 1 packages of yarn.lock:"))
+			Expect(buffer.String()).To(ContainSubstring("  is-number@npm:6.0.0\n"))
+			Expect(buffer.String()).To(ContainSubstring("Installing with yarn install --immutable instead"))
+		})
+	})
+
+	It("is not a zero-install without .pnp.cjs", func() {
+		copyFixture("zero_install")
+		Expect(os.Remove(filepath.Join(buildDir, ".pnp.cjs"))).To(Succeed())
+
+		found, _, err := yarn.ZeroInstall(buildDir)
+		Expect(err).To(BeNil())
+		Expect(found).To(BeFalse())
+	})
+
+	It("is not a zero-install without a committed cache", func() {
+		copyFixture("zero_install")
+		Expect(os.RemoveAll(filepath.Join(buildDir, ".yarn", "cache"))).To(Succeed())
+
+		found, _, err := yarn.ZeroInstall(buildDir)
+		Expect(err).To(BeNil())
+		Expect(found).To(BeFalse())
+	})
+})